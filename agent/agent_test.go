@@ -0,0 +1,126 @@
+package agent_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/agent"
+	"github.com/neaas/nescript/agent/agentpb"
+	"github.com/neaas/nescript/processtest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// serve serves the agent in-process, returning a connection to it which is
+// closed, along with the server, once the test completes.
+func serve(t *testing.T, opts ...agent.ServerOption) *grpc.ClientConn {
+	t.Helper()
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	agent.NewServer(opts...).Register(server)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+	conn, err := grpc.NewClient("passthrough:///agent",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestExecutor(t *testing.T) {
+	if err := processtest.TestExecutor(agent.Executor(serve(t))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGeneratedClient(t *testing.T) {
+	stream, err := agentpb.NewAgentClient(serve(t)).Execute(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := &agentpb.Start{Args: []string{"sh", "-c", "cat; echo $GREETING >&2; exit 3"}, Env: []string{"GREETING=hi"}}
+	for _, req := range []*agentpb.ExecuteRequest{
+		{Frame: &agentpb.ExecuteRequest_Start{Start: start}},
+		{Frame: &agentpb.ExecuteRequest_Stdin{Stdin: []byte("from stdin")}},
+		{Frame: &agentpb.ExecuteRequest_CloseStdin{CloseStdin: true}},
+	} {
+		if err := stream.Send(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var started bool
+	var stdout, stderr strings.Builder
+	var exit *agentpb.Exit
+	for {
+		resp, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		switch frame := resp.Frame.(type) {
+		case *agentpb.ExecuteResponse_Started:
+			started = frame.Started.Pid > 0
+		case *agentpb.ExecuteResponse_Stdout:
+			stdout.Write(frame.Stdout)
+		case *agentpb.ExecuteResponse_Stderr:
+			stderr.Write(frame.Stderr)
+		case *agentpb.ExecuteResponse_Exit:
+			exit = frame.Exit
+		}
+	}
+	if !started {
+		t.Error("expected the agent to respond with the started pid")
+	}
+	if stdout.String() != "from stdin" || stderr.String() != "hi\n" {
+		t.Errorf("expected the output of the script, got %q and %q", stdout.String(), stderr.String())
+	}
+	if exit.GetCode() != 3 {
+		t.Errorf("expected exit code 3, got %v", exit)
+	}
+}
+
+func TestMaxOutput(t *testing.T) {
+	executor := agent.Executor(serve(t, agent.WithMaxOutput(5)))
+	process, err := nescript.NewScript("echo too much output").Cmd().Exec(executor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := process.Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.StdOut != "too m" || !agent.TruncatedFrom(result).StdOut || !result.StdOutTruncated {
+		t.Errorf("expected stdout truncated to 5 bytes, got %q, %v", result.StdOut, agent.TruncatedFrom(result))
+	}
+}
+
+func TestMaxConcurrent(t *testing.T) {
+	executor := agent.Executor(serve(t, agent.WithMaxConcurrent(1)))
+	running, err := nescript.NewScript("sleep 10").Cmd().Exec(executor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer running.Close()
+	if _, err := nescript.NewScript("true").Cmd().Exec(executor); !errors.Is(err, agent.ErrBusy) {
+		t.Errorf("expected ErrBusy, got %v", err)
+	}
+}
+
+func TestStartFailure(t *testing.T) {
+	if _, err := nescript.NewCmd("/nonexistent/script").Exec(agent.Executor(serve(t))); !errors.Is(err, agent.ErrExecution) {
+		t.Errorf("expected ErrExecution, got %v", err)
+	}
+}
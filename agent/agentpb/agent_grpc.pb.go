@@ -0,0 +1,152 @@
+// The wire protocol of the nescript agent. The Go code in this package is
+// generated from it (see generate.go), and clients in other languages can be
+// generated from it with their own protoc plugins.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.4.0
+// - protoc             (unknown)
+// source: agent/agentpb/agent.proto
+
+package agentpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.62.0 or later.
+const _ = grpc.SupportPackageIsVersion8
+
+const (
+	Agent_Execute_FullMethodName = "/nescript.agent.v1.Agent/Execute"
+)
+
+// AgentClient is the client API for Agent service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type AgentClient interface {
+	// Execute runs a single script. The first request must be a Start, after
+	// which stdin and signals may be sent. The agent responds with Started
+	// once the process is running, then its output, then its Exit.
+	Execute(ctx context.Context, opts ...grpc.CallOption) (Agent_ExecuteClient, error)
+}
+
+type agentClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAgentClient(cc grpc.ClientConnInterface) AgentClient {
+	return &agentClient{cc}
+}
+
+func (c *agentClient) Execute(ctx context.Context, opts ...grpc.CallOption) (Agent_ExecuteClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Agent_ServiceDesc.Streams[0], Agent_Execute_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &agentExecuteClient{ClientStream: stream}
+	return x, nil
+}
+
+type Agent_ExecuteClient interface {
+	Send(*ExecuteRequest) error
+	Recv() (*ExecuteResponse, error)
+	grpc.ClientStream
+}
+
+type agentExecuteClient struct {
+	grpc.ClientStream
+}
+
+func (x *agentExecuteClient) Send(m *ExecuteRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *agentExecuteClient) Recv() (*ExecuteResponse, error) {
+	m := new(ExecuteResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// AgentServer is the server API for Agent service.
+// All implementations must embed UnimplementedAgentServer
+// for forward compatibility
+type AgentServer interface {
+	// Execute runs a single script. The first request must be a Start, after
+	// which stdin and signals may be sent. The agent responds with Started
+	// once the process is running, then its output, then its Exit.
+	Execute(Agent_ExecuteServer) error
+	mustEmbedUnimplementedAgentServer()
+}
+
+// UnimplementedAgentServer must be embedded to have forward compatible implementations.
+type UnimplementedAgentServer struct {
+}
+
+func (UnimplementedAgentServer) Execute(Agent_ExecuteServer) error {
+	return status.Errorf(codes.Unimplemented, "method Execute not implemented")
+}
+func (UnimplementedAgentServer) mustEmbedUnimplementedAgentServer() {}
+
+// UnsafeAgentServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AgentServer will
+// result in compilation errors.
+type UnsafeAgentServer interface {
+	mustEmbedUnimplementedAgentServer()
+}
+
+func RegisterAgentServer(s grpc.ServiceRegistrar, srv AgentServer) {
+	s.RegisterService(&Agent_ServiceDesc, srv)
+}
+
+func _Agent_Execute_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(AgentServer).Execute(&agentExecuteServer{ServerStream: stream})
+}
+
+type Agent_ExecuteServer interface {
+	Send(*ExecuteResponse) error
+	Recv() (*ExecuteRequest, error)
+	grpc.ServerStream
+}
+
+type agentExecuteServer struct {
+	grpc.ServerStream
+}
+
+func (x *agentExecuteServer) Send(m *ExecuteResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *agentExecuteServer) Recv() (*ExecuteRequest, error) {
+	m := new(ExecuteRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Agent_ServiceDesc is the grpc.ServiceDesc for Agent service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Agent_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "nescript.agent.v1.Agent",
+	HandlerType: (*AgentServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Execute",
+			Handler:       _Agent_Execute_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "agent/agentpb/agent.proto",
+}
@@ -0,0 +1,5 @@
+// Package agentpb is the generated protobuf and gRPC code of the agent's
+// protocol (see agent.proto), for clients of the agent written in Go.
+package agentpb
+
+//go:generate protoc -I ../.. --go_out=../.. --go_opt=paths=source_relative --go-grpc_out=../.. --go-grpc_opt=paths=source_relative agent/agentpb/agent.proto
@@ -0,0 +1,62 @@
+// Command nescript-agent serves the nescript agent, running the scripts sent
+// by the agent executor on this host. Clients must present a certificate
+// signed by the client CA.
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/neaas/nescript/agent"
+	"google.golang.org/grpc"
+)
+
+func main() {
+	listen := flag.String("listen", ":7443", "address to listen on")
+	certFile := flag.String("cert", "", "PEM encoded agent certificate")
+	keyFile := flag.String("key", "", "PEM encoded agent private key")
+	clientCAFile := flag.String("client-ca", "", "PEM encoded CA(s) client certificates must be signed by")
+	maxConcurrent := flag.Int("max-concurrent", 0, "maximum number of scripts run at once (0 for no limit)")
+	maxOutput := flag.Int("max-output", 0, "maximum bytes of stdout and stderr sent per script (0 for no limit)")
+	flag.Parse()
+
+	creds, err := agent.ServerCredentials(read(*certFile), read(*keyFile), read(*clientCAFile))
+	if err != nil {
+		log.Fatal(err)
+	}
+	lis, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+	server := grpc.NewServer(grpc.Creds(creds))
+	agent.NewServer(
+		agent.WithMaxConcurrent(*maxConcurrent),
+		agent.WithMaxOutput(*maxOutput),
+	).Register(server)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		server.GracefulStop()
+	}()
+	log.Printf("nescript agent listening on %s", lis.Addr())
+	if err := server.Serve(lis); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func read(path string) []byte {
+	if path == "" {
+		log.Fatal("-cert, -key and -client-ca are required")
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return b
+}
@@ -0,0 +1,58 @@
+package agent
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// ServerCredentials builds the mTLS credentials for the agent's grpc.Server
+// (see grpc.Creds), presenting the PEM encoded certificate and key, and
+// requiring clients to present a certificate signed by one of the PEM encoded
+// client CAs.
+func ServerCredentials(certPEM, keyPEM, clientCAPEM []byte) (credentials.TransportCredentials, error) {
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse agent certificate: %w", err)
+	}
+	pool, err := certPool(clientCAPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse client ca certificate: %w", err)
+	}
+	return credentials.NewTLS(&tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}), nil
+}
+
+// ClientCredentials builds the mTLS credentials for connecting to an agent
+// (see grpc.WithTransportCredentials), presenting the PEM encoded client
+// certificate and key, and verifying the agent's certificate against the PEM
+// encoded CAs.
+func ClientCredentials(certPEM, keyPEM, caPEM []byte) (credentials.TransportCredentials, error) {
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse client certificate: %w", err)
+	}
+	pool, err := certPool(caPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse agent ca certificate: %w", err)
+	}
+	return credentials.NewTLS(&tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+	}), nil
+}
+
+func certPool(pem []byte) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found")
+	}
+	return pool, nil
+}
@@ -0,0 +1,42 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/neaas/nescript"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	// ErrConnection is returned (wrapped) when the agent could not be reached,
+	// or the connection to it was lost.
+	ErrConnection = nescript.NewError(nescript.ErrConnection, "failed to connect to agent")
+
+	// ErrAuthentication is returned (wrapped) when the agent rejected the
+	// client, such as its certificate not being signed by the agent's CA.
+	ErrAuthentication = errors.New("agent authentication failed")
+
+	// ErrBusy is returned (wrapped) when the agent is already running its limit
+	// of scripts (see WithMaxConcurrent).
+	ErrBusy = errors.New("agent is at its concurrency limit")
+
+	// ErrExecution is returned (wrapped) when the agent could not start the
+	// script, or its result was lost.
+	ErrExecution = errors.New("agent execution failed")
+)
+
+// statusError wraps the gRPC error returned by the agent with the sentinel
+// error matching its code.
+func statusError(err error) error {
+	switch status.Code(err) {
+	case codes.Unavailable:
+		return fmt.Errorf("%w: %w", ErrConnection, err)
+	case codes.Unauthenticated, codes.PermissionDenied:
+		return fmt.Errorf("%w: %w", ErrAuthentication, err)
+	case codes.ResourceExhausted:
+		return fmt.Errorf("%w: %w", ErrBusy, err)
+	}
+	return fmt.Errorf("%w: %w", ErrExecution, err)
+}
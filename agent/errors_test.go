@@ -0,0 +1,63 @@
+package agent_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/agent"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestErrors(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.Close()
+	refused, err := grpc.NewClient(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer refused.Close()
+	for _, tc := range []struct {
+		name  string
+		conn  grpc.ClientConnInterface
+		ctx   func() (context.Context, context.CancelFunc)
+		is    []error
+		class string
+	}{
+		{"refused", refused, func() (context.Context, context.CancelFunc) {
+			return context.WithCancel(context.Background())
+		}, []error{agent.ErrConnection, nescript.ErrConnection}, nescript.ErrorClassConnection},
+		{"timeout", serve(t), func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), 100*time.Millisecond)
+		}, []error{agent.ErrExecution, nescript.ErrTimeout, context.DeadlineExceeded}, nescript.ErrorClassTimeout},
+		{"cancelled", serve(t), func() (context.Context, context.CancelFunc) {
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(100*time.Millisecond, cancel)
+			return ctx, cancel
+		}, []error{agent.ErrExecution, nescript.ErrCancelled, context.Canceled}, nescript.ErrorClassCancelled},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := tc.ctx()
+			defer cancel()
+			process, err := nescript.NewScript("sleep 30").Cmd().WithContext(ctx).Exec(agent.Executor(tc.conn))
+			if err == nil {
+				_, err = process.Result()
+			}
+			for _, target := range tc.is {
+				if !errors.Is(err, target) {
+					t.Errorf("expected %v to match %v", err, target)
+				}
+			}
+			if class := nescript.ErrorClass(err); class != tc.class {
+				t.Errorf("expected the class %s, got %s", tc.class, class)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/agent/agentpb"
+	"google.golang.org/grpc"
+)
+
+// Executor provides an ExecFunc that runs the script/cmd on a host running the
+// agent (see Server), over the given gRPC connection, such as one created with
+// grpc.NewClient and ClientCredentials. The cmd's env is set on the script's
+// process, which is killed if the cmd's context is done before it completes.
+// The connection is never closed by the executor. This ExecFunc does not
+// require that the cmd/script be converted to a string, so is Formatter
+// agnostic.
+func Executor(conn grpc.ClientConnInterface, opts ...Option) nescript.ExecFunc {
+	o := newOptions(opts)
+	return func(c nescript.Cmd) (nescript.Process, error) {
+		ctx, cancel := context.WithCancel(c.Context())
+		stream, err := agentpb.NewAgentClient(conn).Execute(ctx)
+		if err != nil {
+			cancel()
+			return nil, statusError(err)
+		}
+		process := &AgentProcess{
+			stream: stream,
+			ctx:    c.Context(),
+			cancel: cancel,
+			done:   make(chan struct{}),
+		}
+		if err := process.start(o.start(c)); err != nil {
+			cancel()
+			return nil, err
+		}
+		process.tee = nescript.NewTee(c, &process.stdout, &process.stderr)
+		process.started = time.Now()
+		go process.receive()
+		return process, nil
+	}
+}
+
+// start builds the start request of the cmd. Cmds created from a script are
+// invoked with the shell, if given.
+func (o *options) start(c nescript.Cmd) *agentpb.Start {
+	args := c.Raw()
+	if _, script, trailing, ok := c.Script(); ok && o.shell != nil {
+		args = append(append(append([]string{}, o.shell...), script), trailing...)
+	}
+	return &agentpb.Start{
+		Args: args,
+		Env:  c.Env(),
+		Dir:  o.workDir,
+	}
+}
+
+// start sends the start request, waiting for the agent to start the script.
+func (p *AgentProcess) start(s *agentpb.Start) error {
+	if err := p.send(&agentpb.ExecuteRequest{Frame: &agentpb.ExecuteRequest_Start{Start: s}}); err != nil {
+		return statusError(err)
+	}
+	resp, err := p.stream.Recv()
+	if err != nil {
+		return statusError(err)
+	}
+	started := resp.GetStarted()
+	if started == nil {
+		return fmt.Errorf("%w: agent did not start the script", ErrExecution)
+	}
+	p.pid = int(started.Pid)
+	return nil
+}
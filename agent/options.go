@@ -0,0 +1,71 @@
+package agent
+
+import "github.com/neaas/nescript"
+
+// ServerOption configures the agent Server.
+type ServerOption func(*serverOptions)
+
+type serverOptions struct {
+	maxConcurrent int
+	maxOutput     int
+}
+
+func newServerOptions(opts []ServerOption) *serverOptions {
+	o := &serverOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithMaxConcurrent limits how many scripts the agent runs at once. Scripts
+// started while the agent is at its limit are rejected, which the executor
+// returns as ErrBusy. There is no limit unless given.
+func WithMaxConcurrent(n int) ServerOption {
+	return func(o *serverOptions) {
+		o.maxConcurrent = n
+	}
+}
+
+// WithMaxOutput limits how many bytes of stdout (and separately stderr) the
+// agent sends for each script. Output beyond the limit is discarded, and the
+// result marked as truncated (see MetadataTruncated). There is no limit
+// unless given.
+func WithMaxOutput(bytes int) ServerOption {
+	return func(o *serverOptions) {
+		o.maxOutput = bytes
+	}
+}
+
+// Option configures the agent ExecFunc.
+type Option func(*options)
+
+type options struct {
+	shell   nescript.Subcommand
+	workDir string
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithShell sets the shell scripts are invoked with on the agent's host, such
+// as nescript.SCBash, rather than the cmd's subcommand. Cmds not created from
+// a script are unaffected.
+func WithShell(shell nescript.Subcommand) Option {
+	return func(o *options) {
+		o.shell = shell
+	}
+}
+
+// WithWorkDir sets the directory scripts are run in on the agent's host,
+// rather than the agent's working directory.
+func WithWorkDir(dir string) Option {
+	return func(o *options) {
+		o.workDir = dir
+	}
+}
@@ -0,0 +1,196 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/agent/agentpb"
+)
+
+// MetadataTruncated is the result metadata key holding a Truncated, recording
+// whether the agent discarded output beyond its limit (see WithMaxOutput).
+const MetadataTruncated = "agent.truncated"
+
+// Truncated records which of the script's output streams were truncated by
+// the agent.
+type Truncated struct {
+	StdOut bool
+	StdErr bool
+}
+
+// TruncatedFrom returns which output streams of the result were truncated by
+// the agent.
+func TruncatedFrom(r *nescript.Result) Truncated {
+	truncated, _ := r.Metadata[MetadataTruncated].(Truncated)
+	return truncated
+}
+
+// AgentProcess represents a single instance of the script running or completed
+// on a host running the agent.
+type AgentProcess struct {
+	stream    agentpb.Agent_ExecuteClient
+	ctx       context.Context
+	cancel    func()
+	pid       int
+	done      chan struct{}
+	sendMu    sync.Mutex
+	closeOnce sync.Once
+	started   time.Time
+	tee       *nescript.Tee
+
+	// set by receive, before done is closed.
+	stdout bytes.Buffer
+	stderr bytes.Buffer
+	exit   *agentpb.Exit
+	ended  time.Time
+	err    error
+}
+
+// PID returns the ID of the script's process on the agent's host.
+func (p *AgentProcess) PID() int {
+	return p.pid
+}
+
+// receive collects the script's output until the agent sends its exit.
+func (p *AgentProcess) receive() {
+	defer close(p.done)
+	defer p.tee.Finish(nil)
+	for {
+		resp, err := p.stream.Recv()
+		if errors.Is(err, io.EOF) {
+			if p.exit == nil {
+				p.err = fmt.Errorf("%w: agent ended the stream without an exit", ErrExecution)
+			}
+			return
+		}
+		if err != nil && p.ctx.Err() != nil {
+			p.err = fmt.Errorf("%w: script killed: %w", ErrExecution, nescript.ContextError(p.ctx.Err()))
+			return
+		}
+		if err != nil {
+			p.err = statusError(err)
+			return
+		}
+		switch frame := resp.Frame.(type) {
+		case *agentpb.ExecuteResponse_Stdout:
+			p.tee.Stdout().Write(frame.Stdout)
+		case *agentpb.ExecuteResponse_Stderr:
+			p.tee.Stderr().Write(frame.Stderr)
+		case *agentpb.ExecuteResponse_Exit:
+			p.exit = frame.Exit
+			p.ended = time.Now()
+		}
+	}
+}
+
+func (p *AgentProcess) send(req *agentpb.ExecuteRequest) error {
+	p.sendMu.Lock()
+	defer p.sendMu.Unlock()
+	return p.stream.Send(req)
+}
+
+// Kill sends a SIGKILL to the script's process, doing nothing once the agent
+// has sent its exit.
+func (p *AgentProcess) Kill() error {
+	select {
+	case <-p.done:
+		return nil
+	default:
+	}
+	return p.Signal(syscall.SIGKILL)
+}
+
+// Signal sends the signal to the script's process on the agent's host. Only
+// syscall signals can be sent.
+func (p *AgentProcess) Signal(s os.Signal) error {
+	sig, ok := s.(syscall.Signal)
+	if !ok {
+		return fmt.Errorf("can not send signal '%s' to agent", s)
+	}
+	if err := p.send(&agentpb.ExecuteRequest{Frame: &agentpb.ExecuteRequest_Signal{Signal: int32(sig)}}); err != nil {
+		return fmt.Errorf("failed to send signal to agent: %w", err)
+	}
+	return nil
+}
+
+func (p *AgentProcess) Write(input string) error {
+	if err := p.send(&agentpb.ExecuteRequest{Frame: &agentpb.ExecuteRequest_Stdin{Stdin: []byte(input)}}); err != nil {
+		return fmt.Errorf("failed to write to agent stdin: %w", err)
+	}
+	return nil
+}
+
+// CloseStdin closes the script's stdin, for scripts that read it until EOF.
+func (p *AgentProcess) CloseStdin() error {
+	var err error
+	p.closeOnce.Do(func() {
+		err = p.send(&agentpb.ExecuteRequest{Frame: &agentpb.ExecuteRequest_CloseStdin{CloseStdin: true}})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to close agent stdin: %w", err)
+	}
+	return nil
+}
+
+func (p *AgentProcess) Stdin() io.WriteCloser {
+	return nescript.StdinWriter(p)
+}
+
+func (p *AgentProcess) Stdout() io.Reader {
+	return p.tee.StdoutStream()
+}
+
+func (p *AgentProcess) Stderr() io.Reader {
+	return p.tee.StderrStream()
+}
+
+// ID returns the PID of the script's process on the agent's host (see PID).
+func (p *AgentProcess) ID() string {
+	return strconv.Itoa(p.pid)
+}
+
+func (p *AgentProcess) String() string {
+	return fmt.Sprintf("agent pid %d", p.pid)
+}
+
+func (p *AgentProcess) Wait(ctx context.Context) (*nescript.Result, error) {
+	return nescript.Wait(ctx, p)
+}
+
+func (p *AgentProcess) Result() (*nescript.Result, error) {
+	defer p.Close()
+	<-p.done
+	if p.err != nil {
+		return nil, p.err
+	}
+	result := nescript.Result{
+		StdOut:   p.stdout.String(),
+		StdErr:   p.stderr.String(),
+		ExitCode: int(p.exit.Code),
+	}
+	if p.exit.Signal != 0 {
+		result.SetSignal(int(p.exit.Signal))
+	}
+	result.SetTimes(p.started, p.ended)
+	result.SetMetadata(nescript.MetadataExecutor, "agent")
+	result.StdOutTruncated, result.StdErrTruncated = p.exit.StdoutTruncated, p.exit.StderrTruncated
+	result.SetMetadata(MetadataTruncated, Truncated{StdOut: p.exit.StdoutTruncated, StdErr: p.exit.StderrTruncated})
+	p.tee.Finish(&result)
+	return &result, nil
+}
+
+// Close cancels the stream, which kills the script on the agent's host if it
+// is still running.
+func (p *AgentProcess) Close() {
+	p.cancel()
+	<-p.done
+}
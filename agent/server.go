@@ -0,0 +1,171 @@
+package agent
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/neaas/nescript/agent/agentpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// waitDelay is how long the agent waits for a killed script's output to be
+// closed (such as by processes it started) before giving up on it.
+const waitDelay = 10 * time.Second
+
+// Server is the agent's gRPC service, which runs the scripts it is sent as
+// local processes on the agent's host. It is registered on a grpc.Server,
+// which should only accept mTLS connections (see ServerCredentials).
+type Server struct {
+	agentpb.UnimplementedAgentServer
+
+	o       *serverOptions
+	running chan struct{}
+}
+
+// NewServer creates the agent service.
+func NewServer(opts ...ServerOption) *Server {
+	o := newServerOptions(opts)
+	s := &Server{o: o}
+	if o.maxConcurrent > 0 {
+		s.running = make(chan struct{}, o.maxConcurrent)
+	}
+	return s
+}
+
+// Register registers the agent service on the gRPC server.
+func (s *Server) Register(registrar grpc.ServiceRegistrar) {
+	agentpb.RegisterAgentServer(registrar, s)
+}
+
+// Execute runs the script started by the first request of the stream,
+// sending its output as it is written, then its exit. The script is killed if
+// the stream's context is done (such as the client cancelling it) first.
+func (s *Server) Execute(stream agentpb.Agent_ExecuteServer) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	start := first.GetStart()
+	if len(start.GetArgs()) == 0 {
+		return status.Error(codes.InvalidArgument, "the first request must start a command")
+	}
+	if s.running != nil {
+		select {
+		case s.running <- struct{}{}:
+			defer func() { <-s.running }()
+		default:
+			return status.Errorf(codes.ResourceExhausted, "agent is running its limit of %d scripts", s.o.maxConcurrent)
+		}
+	}
+	ctx := stream.Context()
+	cmd := exec.CommandContext(ctx, start.Args[0], start.Args[1:]...)
+	cmd.Env = start.Env
+	cmd.Dir = start.Dir
+	cmd.WaitDelay = waitDelay
+	sender := &sender{stream: stream}
+	stdout := &output{sender: sender, limit: s.o.maxOutput, frame: func(b []byte) *agentpb.ExecuteResponse {
+		return &agentpb.ExecuteResponse{Frame: &agentpb.ExecuteResponse_Stdout{Stdout: b}}
+	}}
+	stderr := &output{sender: sender, limit: s.o.maxOutput, frame: func(b []byte) *agentpb.ExecuteResponse {
+		return &agentpb.ExecuteResponse{Frame: &agentpb.ExecuteResponse_Stderr{Stderr: b}}
+	}}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to create stdin pipe: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return status.Errorf(codes.FailedPrecondition, "failed to start '%s': %v", start.Args[0], err)
+	}
+	if err := sender.send(&agentpb.ExecuteResponse{Frame: &agentpb.ExecuteResponse_Started{Started: &agentpb.Started{Pid: int32(cmd.Process.Pid)}}}); err != nil {
+		return err
+	}
+	go receive(stream, cmd.Process, stdin)
+	err = cmd.Wait()
+	if ctx.Err() != nil {
+		return status.FromContextError(ctx.Err()).Err()
+	}
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return status.Errorf(codes.Internal, "failed to wait for script: %v", err)
+	}
+	exit := &agentpb.Exit{
+		Code:            int32(cmd.ProcessState.ExitCode()),
+		StdoutTruncated: stdout.truncated,
+		StderrTruncated: stderr.truncated,
+	}
+	if ws, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		exit.Signal = int32(ws.Signal())
+	}
+	return sender.send(&agentpb.ExecuteResponse{Frame: &agentpb.ExecuteResponse_Exit{Exit: exit}})
+}
+
+// receive writes the stdin sent by the client to the script, and sends it
+// signals, until the client closes its side of the stream (which also closes
+// the script's stdin) or the stream ends.
+func receive(stream agentpb.Agent_ExecuteServer, process *os.Process, stdin io.WriteCloser) {
+	defer stdin.Close()
+	for {
+		req, err := stream.Recv()
+		if err != nil {
+			return
+		}
+		switch frame := req.Frame.(type) {
+		case *agentpb.ExecuteRequest_Stdin:
+			stdin.Write(frame.Stdin)
+		case *agentpb.ExecuteRequest_CloseStdin:
+			if frame.CloseStdin {
+				stdin.Close()
+			}
+		case *agentpb.ExecuteRequest_Signal:
+			process.Signal(syscall.Signal(frame.Signal))
+		}
+	}
+}
+
+// sender serializes the frames sent on the stream, as the output of stdout
+// and stderr are copied concurrently.
+type sender struct {
+	mu     sync.Mutex
+	stream agentpb.Agent_ExecuteServer
+}
+
+func (s *sender) send(resp *agentpb.ExecuteResponse) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stream.Send(resp)
+}
+
+// output sends the output written to it as frames, up to the limit (if any),
+// after which the rest is discarded and the output marked as truncated.
+type output struct {
+	sender    *sender
+	limit     int
+	frame     func([]byte) *agentpb.ExecuteResponse
+	written   int
+	truncated bool
+}
+
+func (o *output) Write(b []byte) (int, error) {
+	n := len(b)
+	if o.limit > 0 && o.written+len(b) > o.limit {
+		b = b[:o.limit-o.written]
+		o.truncated = true
+	}
+	o.written += len(b)
+	if len(b) == 0 {
+		return n, nil
+	}
+	if err := o.sender.send(o.frame(append([]byte{}, b...))); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
@@ -0,0 +1,40 @@
+package nescript
+
+import "fmt"
+
+// Outcome is what an asynchronous execution (see Cmd.ExecAsync) completed
+// with, the result of the script or the error collecting it.
+type Outcome struct {
+	Result *Result
+	Err    error
+}
+
+// ExecAsync calls the given ExecFunc to execute the script, as Exec does,
+// however rather than the process, returns a channel which receives the outcome
+// once the script completes, and is then closed. Exactly one outcome is always
+// sent, and buffered so that it is never blocked on being received: if the
+// cmd's context is done first, the process is killed and the outcome holds the
+// context's cause (see Wait), and a panic collecting the result, or closing the
+// process, is recovered, sent as an error wrapping ErrPanic. The process is
+// closed before the outcome is sent. An error is returned, without a channel,
+// if the script fails to execute.
+func (c Cmd) ExecAsync(executor ExecFunc) (<-chan Outcome, error) {
+	process, err := c.Exec(executor)
+	if err != nil {
+		return nil, err
+	}
+	outcomes := make(chan Outcome, 1)
+	go func() {
+		var outcome Outcome
+		defer func() {
+			if r := recover(); r != nil {
+				outcome.Err = fmt.Errorf("%w: %v", ErrPanic, r)
+			}
+			outcomes <- outcome
+			close(outcomes)
+		}()
+		defer process.Close()
+		outcome.Result, outcome.Err = Wait(c.Context(), process)
+	}()
+	return outcomes, nil
+}
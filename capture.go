@@ -0,0 +1,182 @@
+package nescript
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"unicode/utf8"
+)
+
+// Retain selects which part of oversized output a Capture keeps.
+type Retain int
+
+const (
+	// RetainHead keeps the start of the output, dropping what follows the
+	// limit.
+	RetainHead Retain = iota
+
+	// RetainTail keeps the end of the output, which for a failing script tends
+	// to hold the error.
+	RetainTail
+
+	// RetainBoth keeps the start and end of the output (half of the limit
+	// each), joined by a marker naming how many bytes were dropped between.
+	RetainBoth
+)
+
+// Capture captures the output of a script for its result, keeping at most the
+// limit of bytes (if above 0) as selected by its Retain mode, while counting the
+// total written. The output kept never starts or ends part way through a UTF-8
+// rune, the partial rune being dropped along with the rest. Executors capping
+// output write it to a Capture, recording it on the result with
+// Result.SetCaptured.
+type Capture struct {
+	mu     sync.Mutex
+	retain Retain
+	limit  int
+	head   bytes.Buffer
+	tail   []byte
+	next   int
+	total  int64
+}
+
+// NewCapture creates a capture keeping at most the limit of bytes, or all that
+// is written if the limit is 0.
+func NewCapture(limit int, retain Retain) *Capture {
+	return &Capture{limit: limit, retain: retain}
+}
+
+// headLimit is the most bytes kept from the start of the output, or -1 if
+// there is no limit.
+func (c *Capture) headLimit() int {
+	switch {
+	case c.limit <= 0:
+		return -1
+	case c.retain == RetainTail:
+		return 0
+	case c.retain == RetainBoth:
+		return c.limit / 2
+	}
+	return c.limit
+}
+
+// tailLimit is the most bytes kept from the end of the output.
+func (c *Capture) tailLimit() int {
+	if c.limit <= 0 {
+		return 0
+	}
+	return c.limit - c.headLimit()
+}
+
+// Write captures the output, never failing.
+func (c *Capture) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.total += int64(len(p))
+	b := p
+	if limit := c.headLimit(); limit < 0 {
+		c.head.Write(b)
+		return len(p), nil
+	} else if room := limit - c.head.Len(); room > 0 {
+		n := min(room, len(b))
+		c.head.Write(b[:n])
+		b = b[n:]
+	}
+	c.writeTail(b)
+	return len(p), nil
+}
+
+// writeTail writes to the ring buffer holding the end of the output.
+func (c *Capture) writeTail(b []byte) {
+	limit := c.tailLimit()
+	if limit == 0 || len(b) == 0 {
+		return
+	}
+	if len(b) >= limit {
+		c.tail = append(c.tail[:0], b[len(b)-limit:]...)
+		c.next = 0
+		return
+	}
+	if len(c.tail) < limit {
+		n := min(limit-len(c.tail), len(b))
+		c.tail = append(c.tail, b[:n]...)
+		b = b[n:]
+	}
+	for len(b) > 0 {
+		n := copy(c.tail[c.next:], b)
+		b = b[n:]
+		c.next = (c.next + n) % limit
+	}
+}
+
+// Size returns the total bytes written, whether or not they were kept.
+func (c *Capture) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.total
+}
+
+// Truncated reports whether more was written than was kept.
+func (c *Capture) Truncated() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.truncated()
+}
+
+func (c *Capture) truncated() bool {
+	return c.total > int64(c.head.Len()+len(c.tail))
+}
+
+// Dropped returns how many of the bytes written were not kept.
+func (c *Capture) Dropped() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	head, tail := c.kept()
+	return c.total - int64(len(head)+len(tail))
+}
+
+// String returns the output kept.
+func (c *Capture) String() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	head, tail := c.kept()
+	if c.retain != RetainBoth || !c.truncated() {
+		return string(head) + string(tail)
+	}
+	dropped := c.total - int64(len(head)+len(tail))
+	return fmt.Sprintf("%s\n[... %d bytes truncated ...]\n%s", head, dropped, tail)
+}
+
+// kept returns the start and end of the output kept, without partial runes
+// where output was dropped between them.
+func (c *Capture) kept() (head, tail []byte) {
+	head = c.head.Bytes()
+	tail = append(append([]byte{}, c.tail[c.next:]...), c.tail[:c.next]...)
+	if !c.truncated() {
+		return head, tail
+	}
+	return trimPartialEnd(head), trimPartialStart(tail)
+}
+
+// trimPartialEnd drops an incomplete rune from the end of the output.
+func trimPartialEnd(b []byte) []byte {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				return b[:i]
+			}
+			break
+		}
+	}
+	return b
+}
+
+// trimPartialStart drops the remainder of a rune from the start of the output.
+func trimPartialStart(b []byte) []byte {
+	for i := 0; i < len(b) && i < utf8.UTFMax; i++ {
+		if utf8.RuneStart(b[i]) {
+			return b[i:]
+		}
+	}
+	return b
+}
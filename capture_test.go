@@ -0,0 +1,122 @@
+package nescript_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/neaas/nescript"
+)
+
+func TestCapture(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		limit   int
+		retain  nescript.Retain
+		output  string
+		kept    string
+		dropped int64
+	}{
+		{"no limit", 0, nescript.RetainHead, "a€b", "a€b", 0},
+		{"within the limit", 10, nescript.RetainBoth, "a€b", "a€b", 0},
+		{"exactly the limit", 5, nescript.RetainTail, "a€b", "a€b", 0},
+		{"head", 4, nescript.RetainHead, "abcdef", "abcd", 2},
+		{"head ending part way through a rune", 4, nescript.RetainHead, "ab€c", "ab", 4},
+		{"tail", 4, nescript.RetainTail, "abcdef", "cdef", 2},
+		{"tail starting part way through a rune", 4, nescript.RetainTail, "a€bc", "bc", 4},
+		{"tail of a 4 byte rune", 4, nescript.RetainTail, "ab😀", "😀", 2},
+		{"both", 6, nescript.RetainBoth, "abcdefghij", "abc\n[... 4 bytes truncated ...]\nhij", 4},
+		{"both on rune boundaries", 6, nescript.RetainBoth, "€€€€", "€\n[... 6 bytes truncated ...]\n€", 6},
+		{"both part way through runes", 5, nescript.RetainBoth, "€€€€", "\n[... 9 bytes truncated ...]\n€", 9},
+		{"both with an odd limit", 7, nescript.RetainBoth, "a€€€b", "a\n[... 6 bytes truncated ...]\n€b", 6},
+	} {
+		for _, chunk := range []int{len(tc.output), 1, 2, 3} {
+			t.Run(fmt.Sprintf("%s in writes of %d", tc.name, chunk), func(t *testing.T) {
+				capture := nescript.NewCapture(tc.limit, tc.retain)
+				writeChunks(t, capture, tc.output, chunk)
+				if got := capture.String(); got != tc.kept {
+					t.Errorf("expected %q kept, got %q", tc.kept, got)
+				}
+				if capture.Size() != int64(len(tc.output)) {
+					t.Errorf("expected a size of %d, got %d", len(tc.output), capture.Size())
+				}
+				if capture.Dropped() != tc.dropped {
+					t.Errorf("expected %d bytes dropped, got %d", tc.dropped, capture.Dropped())
+				}
+				if capture.Truncated() != (tc.dropped > 0) {
+					t.Errorf("expected truncated %t, got %t", tc.dropped > 0, capture.Truncated())
+				}
+			})
+		}
+	}
+}
+
+func TestCaptureLargeOutput(t *testing.T) {
+	// the pattern mixes runes of each length, so that the limits fall part way
+	// through runes, and numbers its repetitions, so that the output kept can be
+	// placed.
+	var b strings.Builder
+	for i := 0; b.Len() < 10<<20; i++ {
+		fmt.Fprintf(&b, "%d a é € 😀\n", i)
+	}
+	output := b.String()
+	const limit = 64<<10 + 1
+	for _, tc := range []struct {
+		name   string
+		retain nescript.Retain
+		head   int
+		tail   int
+	}{
+		{"head", nescript.RetainHead, limit, 0},
+		{"tail", nescript.RetainTail, 0, limit},
+		{"both", nescript.RetainBoth, limit / 2, limit - limit/2},
+	} {
+		// writes of an odd size wrap the ring buffer part way through its end.
+		for _, chunk := range []int{32 << 10, 4093, 7} {
+			t.Run(fmt.Sprintf("%s in writes of %d", tc.name, chunk), func(t *testing.T) {
+				capture := nescript.NewCapture(limit, tc.retain)
+				writeChunks(t, capture, output, chunk)
+				if capture.Size() != int64(len(output)) || !capture.Truncated() {
+					t.Fatalf("expected a truncated size of %d, got %d (truncated %t)", len(output), capture.Size(), capture.Truncated())
+				}
+				kept := capture.String()
+				head, tail := kept, ""
+				if tc.retain == nescript.RetainTail {
+					head, tail = "", kept
+				} else if tc.retain == nescript.RetainBoth {
+					marker := fmt.Sprintf("\n[... %d bytes truncated ...]\n", capture.Dropped())
+					var found bool
+					if head, tail, found = strings.Cut(kept, marker); !found {
+						t.Fatalf("expected the marker %q in the output kept", marker)
+					}
+				}
+				if !utf8.ValidString(head) || !utf8.ValidString(tail) {
+					t.Error("expected the output kept to be valid UTF-8")
+				}
+				if !strings.HasPrefix(output, head) || !strings.HasSuffix(output, tail) {
+					t.Error("expected the output kept to be the start and end of the output")
+				}
+				// at most a partial rune (of up to 3 bytes) is dropped from each
+				// end of the output kept.
+				if len(head) > tc.head || len(head) < tc.head-(utf8.UTFMax-1) || len(tail) > tc.tail || len(tail) < tc.tail-(utf8.UTFMax-1) {
+					t.Errorf("expected %d bytes kept from the start and %d from the end, got %d and %d", tc.head, tc.tail, len(head), len(tail))
+				}
+				if dropped := int64(len(output) - len(head) - len(tail)); capture.Dropped() != dropped {
+					t.Errorf("expected %d bytes dropped, got %d", dropped, capture.Dropped())
+				}
+			})
+		}
+	}
+}
+
+// writeChunks writes the output to the capture in writes of the size given.
+func writeChunks(t *testing.T, capture *nescript.Capture, output string, size int) {
+	t.Helper()
+	for i := 0; i < len(output); i += size {
+		chunk := output[i:min(i+size, len(output))]
+		if n, err := capture.Write([]byte(chunk)); n != len(chunk) || err != nil {
+			t.Fatalf("expected the write of %d bytes to succeed, got %d, %v", len(chunk), n, err)
+		}
+	}
+}
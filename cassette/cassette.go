@@ -0,0 +1,101 @@
+package cassette
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/neaas/nescript"
+	"gopkg.in/yaml.v3"
+)
+
+// Cassette is a recording of the cmds executed by an executor and their
+// results, which can be replayed without the executor (see Replay).
+type Cassette struct {
+	Interactions []Interaction `json:"interactions" yaml:"interactions"`
+}
+
+// Interaction is a single recorded execution. Key identifies the cmd, as
+// matched (see Key), and Command and Env record it (with secrets redacted) for
+// reading the cassette. Either ExecError is set, when the executor could not
+// start the cmd, ResultError, when its result could not be collected, or the
+// rest of the result is.
+type Interaction struct {
+	Key      string        `json:"key" yaml:"key"`
+	Command  []string      `json:"command" yaml:"command"`
+	Env      []string      `json:"env,omitempty" yaml:"env,omitempty"`
+	StdOut   string        `json:"stdout" yaml:"stdout"`
+	StdErr   string        `json:"stderr" yaml:"stderr"`
+	ExitCode int           `json:"exitCode" yaml:"exitCode"`
+	Signal   string        `json:"signal,omitempty" yaml:"signal,omitempty"`
+	Duration time.Duration `json:"duration" yaml:"duration"`
+
+	ExecError   string `json:"execError,omitempty" yaml:"execError,omitempty"`
+	ResultError string `json:"resultError,omitempty" yaml:"resultError,omitempty"`
+}
+
+// result is the result the interaction replays.
+func (i Interaction) result() *nescript.Result {
+	result := &nescript.Result{
+		StdOut:    i.StdOut,
+		StdErr:    i.StdErr,
+		ExitCode:  i.ExitCode,
+		TotalTime: i.Duration,
+	}
+	if i.Signal != "" {
+		result.Signaled = true
+		result.Signal = i.Signal
+	}
+	return result
+}
+
+// Load loads the cassette from the file at the path, as YAML if it has a .yaml
+// or .yml extension, otherwise as JSON.
+func Load(path string) (*Cassette, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cassette: %w", err)
+	}
+	var cassette Cassette
+	if isYAML(path) {
+		err = yaml.Unmarshal(data, &cassette)
+	} else {
+		err = json.Unmarshal(data, &cassette)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode cassette '%s': %w", path, err)
+	}
+	return &cassette, nil
+}
+
+// Save writes the cassette to the file at the path, in the format of its
+// extension (see Load), creating its directory if needed.
+func (c *Cassette) Save(path string) error {
+	var (
+		data []byte
+		err  error
+	)
+	if isYAML(path) {
+		data, err = yaml.Marshal(c)
+	} else {
+		data, err = json.MarshalIndent(c, "", "  ")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encode cassette: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create cassette directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write cassette: %w", err)
+	}
+	return nil
+}
+
+func isYAML(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".yaml" || ext == ".yml"
+}
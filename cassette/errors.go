@@ -0,0 +1,14 @@
+package cassette
+
+import "errors"
+
+var (
+	// ErrUnmatched is returned (wrapped) by the replay executor when a cmd
+	// matches no interaction of the cassette, such as when the code under test
+	// has changed since it was recorded.
+	ErrUnmatched = errors.New("no recorded interaction matches cmd")
+
+	// ErrRecorded is returned (wrapped) when replaying an interaction whose
+	// recording ended in an error, holding the message recorded.
+	ErrRecorded = errors.New("recorded error")
+)
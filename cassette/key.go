@@ -0,0 +1,45 @@
+package cassette
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"slices"
+
+	"github.com/neaas/nescript"
+)
+
+// Key is the key the cmd is matched by: a hash of its command and (unless
+// ignored) its sorted env, after its secrets are redacted and the scrub
+// expressions' matches are replaced. As secrets are redacted first, a cmd
+// replayed with different secrets (such as dummy credentials in CI) still
+// matches its recording.
+func Key(c nescript.Cmd, opts ...Option) string {
+	return newOptions(opts).key(c)
+}
+
+func (o *options) key(c nescript.Cmd) string {
+	redactor := o.redactor(c.Env())
+	hash := sha256.New()
+	for _, arg := range c.Raw() {
+		hash.Write([]byte(o.scrubbed(redactor.Replace(arg))))
+		hash.Write([]byte{0})
+	}
+	if !o.ignoreEnv {
+		env := slices.Clone(c.Env())
+		slices.Sort(env)
+		hash.Write([]byte{0})
+		for _, e := range env {
+			hash.Write([]byte(o.scrubbed(redactor.Replace(e))))
+			hash.Write([]byte{0})
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// scrubbed replaces the matches of the scrub expressions in the string.
+func (o *options) scrubbed(s string) string {
+	for _, re := range o.scrub {
+		s = re.ReplaceAllString(s, scrubbed)
+	}
+	return s
+}
@@ -0,0 +1,108 @@
+package cassette
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// redacted replaces secrets in everything written to a cassette.
+	redacted = "[REDACTED]"
+
+	// scrubbed replaces the matches of scrub expressions in cmds when they are
+	// matched.
+	scrubbed = "[SCRUBBED]"
+)
+
+// defaultSecretEnv matches the keys of env vars whose values are redacted by
+// default.
+var defaultSecretEnv = regexp.MustCompile(`(?i)(passw|secret|token|credential|private_?key|api_?key)`)
+
+// Option configures the recording and matching of interactions. Recording and
+// replaying the same cassette should be given the same options, so that cmds
+// get the same keys.
+type Option func(*options)
+
+type options struct {
+	ignoreEnv bool
+	scrub     []*regexp.Regexp
+	secrets   []string
+	secretEnv *regexp.Regexp
+	realTime  bool
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{secretEnv: defaultSecretEnv}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithIgnoreEnv matches cmds by their command alone, ignoring their env.
+func WithIgnoreEnv() Option {
+	return func(o *options) {
+		o.ignoreEnv = true
+	}
+}
+
+// WithScrub ignores the parts of cmds (their command and env) matching the
+// regular expressions when matching them, such as timestamps or random IDs
+// that differ between runs. It panics if an expression does not compile.
+func WithScrub(patterns ...string) Option {
+	return func(o *options) {
+		for _, pattern := range patterns {
+			o.scrub = append(o.scrub, regexp.MustCompile(pattern))
+		}
+	}
+}
+
+// WithSecrets redacts the values wherever they appear in the cmds and output
+// recorded, so that they are never written to the cassette.
+func WithSecrets(secrets ...string) Option {
+	return func(o *options) {
+		o.secrets = append(o.secrets, secrets...)
+	}
+}
+
+// WithSecretEnv sets the regular expression matching the keys of env vars
+// whose values are secrets, redacted wherever they appear in the cmds and
+// output recorded. By default, keys containing PASSW, SECRET, TOKEN,
+// CREDENTIAL, PRIVATE_KEY or API_KEY (in any case) are secrets. It panics if
+// the expression does not compile.
+func WithSecretEnv(pattern string) Option {
+	return func(o *options) {
+		o.secretEnv = regexp.MustCompile(pattern)
+	}
+}
+
+// WithRealTime makes replayed processes take as long as their recording did,
+// rather than completing straight away.
+func WithRealTime() Option {
+	return func(o *options) {
+		o.realTime = true
+	}
+}
+
+// redactor redacts the secrets given, and those in the env, from strings.
+func (o *options) redactor(env []string) *strings.Replacer {
+	secrets := append([]string{}, o.secrets...)
+	for _, e := range env {
+		if key, value, ok := strings.Cut(e, "="); ok && value != "" && o.secretEnv.MatchString(key) {
+			secrets = append(secrets, value)
+		}
+	}
+	// longer secrets are replaced first, so that none is left partly
+	// unredacted by a shorter one it contains.
+	sort.Slice(secrets, func(i, j int) bool {
+		return len(secrets[i]) > len(secrets[j])
+	})
+	pairs := make([]string, 0, 2*len(secrets))
+	for _, secret := range secrets {
+		if secret != "" {
+			pairs = append(pairs, secret, redacted)
+		}
+	}
+	return strings.NewReplacer(pairs...)
+}
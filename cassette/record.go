@@ -0,0 +1,109 @@
+package cassette
+
+import (
+	"sync"
+	"time"
+
+	"github.com/neaas/nescript"
+)
+
+// Recorder wraps an executor, recording each cmd it executes and the result
+// into a cassette, with secrets redacted.
+type Recorder struct {
+	executor nescript.ExecFunc
+	path     string
+	options  *options
+
+	mu       sync.Mutex
+	cassette Cassette
+}
+
+// Record wraps the executor with a recorder writing to the cassette file at the
+// path (see Save for the formats), which is replaced by the new recording. The
+// cassette is saved as each interaction completes, so that a test failing part
+// way still keeps what was recorded.
+func Record(executor nescript.ExecFunc, path string, opts ...Option) *Recorder {
+	return &Recorder{
+		executor: executor,
+		path:     path,
+		options:  newOptions(opts),
+	}
+}
+
+// Executor provides the ExecFunc executing cmds with the wrapped executor,
+// recording them once their result has been collected (or they fail).
+func (r *Recorder) Executor() nescript.ExecFunc {
+	return func(c nescript.Cmd) (nescript.Process, error) {
+		start := time.Now()
+		process, err := r.executor(c)
+		if err != nil {
+			r.record(c, Interaction{ExecError: err.Error(), Duration: time.Since(start)})
+			return nil, err
+		}
+		recording := &recordingProcess{recorder: r, cmd: c, start: start}
+		return nescript.WrapProcess(process, nescript.ProcessHooks{Result: recording.result}), nil
+	}
+}
+
+// Cassette returns a copy of what has been recorded so far.
+func (r *Recorder) Cassette() *Cassette {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return &Cassette{Interactions: append([]Interaction{}, r.cassette.Interactions...)}
+}
+
+// Save writes what has been recorded so far to the cassette file. As each
+// interaction is saved as it completes, this is only needed to check that the
+// cassette was saved, such as once a test ends.
+func (r *Recorder) Save() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cassette.Save(r.path)
+}
+
+// record redacts the interaction of the cmd, then adds it to the cassette and
+// saves it.
+func (r *Recorder) record(c nescript.Cmd, interaction Interaction) {
+	redactor := r.options.redactor(c.Env())
+	interaction.Key = r.options.key(c)
+	for _, arg := range c.Raw() {
+		interaction.Command = append(interaction.Command, redactor.Replace(arg))
+	}
+	for _, e := range c.Env() {
+		interaction.Env = append(interaction.Env, redactor.Replace(e))
+	}
+	interaction.StdOut = redactor.Replace(interaction.StdOut)
+	interaction.StdErr = redactor.Replace(interaction.StdErr)
+	interaction.ExecError = redactor.Replace(interaction.ExecError)
+	interaction.ResultError = redactor.Replace(interaction.ResultError)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cassette.Interactions = append(r.cassette.Interactions, interaction)
+	// a failure to save is returned by the next call to Save.
+	r.cassette.Save(r.path)
+}
+
+// recordingProcess records the result of the wrapped process once it has been
+// collected.
+type recordingProcess struct {
+	recorder *Recorder
+	cmd      nescript.Cmd
+	start    time.Time
+	once     sync.Once
+}
+
+func (p *recordingProcess) result(result *nescript.Result, err error) (*nescript.Result, error) {
+	p.once.Do(func() {
+		interaction := Interaction{Duration: time.Since(p.start)}
+		if err != nil {
+			interaction.ResultError = err.Error()
+		} else {
+			interaction.StdOut = result.StdOut
+			interaction.StdErr = result.StdErr
+			interaction.ExitCode = result.ExitCode
+			interaction.Signal = result.Signal
+		}
+		p.recorder.record(p.cmd, interaction)
+	})
+	return result, err
+}
@@ -0,0 +1,163 @@
+package cassette
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/neaas/nescript"
+)
+
+// Replay provides an ExecFunc serving cmds from the cassette file at the path
+// (see Load), without executing them. See Replayer.
+func Replay(path string, opts ...Option) (nescript.ExecFunc, error) {
+	cassette, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	return cassette.Replayer(opts...), nil
+}
+
+// Replayer provides an ExecFunc serving cmds from the cassette, without
+// executing them. Each cmd is matched to the interactions recorded with the
+// same key (see Key), which are replayed in the order they were recorded, the
+// last being repeated once all have been. A cmd matching no interaction returns
+// an error wrapping ErrUnmatched.
+func (c *Cassette) Replayer(opts ...Option) nescript.ExecFunc {
+	o := newOptions(opts)
+	interactions := make(map[string][]Interaction)
+	for _, interaction := range c.Interactions {
+		interactions[interaction.Key] = append(interactions[interaction.Key], interaction)
+	}
+	var mu sync.Mutex
+	replayed := make(map[string]int)
+	return func(cmd nescript.Cmd) (nescript.Process, error) {
+		key := o.key(cmd)
+		mu.Lock()
+		recorded, ok := interactions[key]
+		var interaction Interaction
+		if ok {
+			interaction = recorded[min(replayed[key], len(recorded)-1)]
+			replayed[key]++
+		}
+		mu.Unlock()
+		if !ok {
+			redactor := o.redactor(cmd.Env())
+			return nil, fmt.Errorf("%w: '%s'", ErrUnmatched, redactor.Replace(strings.Join(cmd.Raw(), " ")))
+		}
+		if interaction.ExecError != "" {
+			return nil, fmt.Errorf("%w: %s", ErrRecorded, interaction.ExecError)
+		}
+		process := &ReplayProcess{
+			interaction: interaction,
+			tee:         nescript.NewTee(cmd, nil, nil),
+			started:     time.Now(),
+			done:        make(chan struct{}),
+			killed:      make(chan struct{}),
+		}
+		process.tee.WriteOutput(interaction.StdOut, interaction.StdErr)
+		go process.replay(o.realTime)
+		return process, nil
+	}
+}
+
+// ReplayProcess represents a recorded execution being replayed.
+type ReplayProcess struct {
+	interaction Interaction
+	tee         *nescript.Tee
+	started     time.Time
+	done        chan struct{}
+	killed      chan struct{}
+	killOnce    sync.Once
+}
+
+// replay completes the process, after the recorded duration if in real time.
+func (p *ReplayProcess) replay(realTime bool) {
+	defer close(p.done)
+	if !realTime {
+		return
+	}
+	timer := time.NewTimer(p.interaction.Duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-p.killed:
+	}
+}
+
+// Kill completes the process straight away when replaying in real time, with
+// its recorded result.
+func (p *ReplayProcess) Kill() error {
+	p.killOnce.Do(func() {
+		close(p.killed)
+	})
+	return nil
+}
+
+// Signal kills the process for SIGKILL, and does nothing for other signals.
+func (p *ReplayProcess) Signal(s os.Signal) error {
+	if s == os.Kill {
+		return p.Kill()
+	}
+	return nil
+}
+
+// Write discards the input, as the recorded process has already consumed its
+// stdin.
+func (p *ReplayProcess) Write(input string) error {
+	return nil
+}
+
+func (p *ReplayProcess) Stdin() io.WriteCloser {
+	return nescript.StdinWriter(p)
+}
+
+// CloseStdin does nothing, as the recorded process has already consumed its
+// stdin.
+func (p *ReplayProcess) CloseStdin() error {
+	return nil
+}
+
+func (p *ReplayProcess) Stdout() io.Reader {
+	return p.tee.StdoutStream()
+}
+
+func (p *ReplayProcess) Stderr() io.Reader {
+	return p.tee.StderrStream()
+}
+
+// ID returns the key of the interaction being replayed.
+func (p *ReplayProcess) ID() string {
+	return p.interaction.Key
+}
+
+func (p *ReplayProcess) String() string {
+	return fmt.Sprintf("replay of interaction '%s'", p.interaction.Key)
+}
+
+func (p *ReplayProcess) Wait(ctx context.Context) (*nescript.Result, error) {
+	return nescript.Wait(ctx, p)
+}
+
+func (p *ReplayProcess) Result() (*nescript.Result, error) {
+	<-p.done
+	if p.interaction.ResultError != "" {
+		return nil, fmt.Errorf("%w: %s", ErrRecorded, p.interaction.ResultError)
+	}
+	result := p.interaction.result()
+	// the times are those of the replay, for the recorded duration.
+	result.SetTimes(p.started, p.started.Add(p.interaction.Duration))
+	result.SetMetadata(nescript.MetadataExecutor, "cassette")
+	p.tee.Finish(result)
+	return result, nil
+}
+
+func (p *ReplayProcess) Close() {
+	p.Kill()
+	<-p.done
+	p.tee.Finish(nil)
+}
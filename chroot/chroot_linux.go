@@ -0,0 +1,121 @@
+package chroot
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/neaas/nescript"
+)
+
+// Executor provides an ExecFunc that runs the script/cmd chrooted into the
+// rootfs, such as a directory an OCI image has been extracted to, without any
+// daemon. When running as root, /proc is mounted and /dev/null bind mounted
+// within the rootfs (see WithoutProc and WithDevices) for as long as any
+// script is running in it, and torn down once the last completes. Otherwise,
+// the script runs in a new user namespace (see WithUserNamespace). The script
+// runs with the cmd's env only, none of the host's. The command, and any
+// interpreter it needs, is checked to exist within the rootfs before anything
+// is mounted (see ErrInterpreterNotFound). This ExecFunc does not require that
+// the cmd/script be converted to a string, so is Formatter agnostic.
+func Executor(rootfs string, opts ...Option) nescript.ExecFunc {
+	o := newOptions(opts)
+	if os.Geteuid() != 0 {
+		o.userns = true
+	}
+	return func(c nescript.Cmd) (nescript.Process, error) {
+		root, err := filepath.Abs(rootfs)
+		if err == nil {
+			root, err = filepath.EvalSymlinks(root)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrRootfs, err)
+		}
+		if info, err := os.Stat(root); err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("%w: '%s' is not a directory", ErrRootfs, rootfs)
+		}
+		args := command(c, o.shell)
+		env := c.Env()
+		inRoot, host, err := lookPath(root, o.workDir, args[0], pathEnv(env))
+		if err != nil {
+			return nil, err
+		}
+		if err := checkInterpreter(root, inRoot, host, 0); err != nil {
+			return nil, err
+		}
+		release := func() error { return nil }
+		if !o.userns {
+			if release, err = acquireMounts(root, !o.noProc, o.devices); err != nil {
+				return nil, err
+			}
+		}
+		cmd := &exec.Cmd{
+			Path: inRoot,
+			Args: args,
+			// an empty (rather than nil) env, so that none of the host's is
+			// inherited.
+			Env: append([]string{}, env...),
+			Dir: o.workDir,
+		}
+		cmd.SysProcAttr = o.sysProcAttr(root)
+		process := &ChrootProcess{cmd: cmd, root: root, release: release, exited: make(chan struct{})}
+		process.tee = nescript.NewTee(c, &process.stdoutBytes, &process.stderrBytes)
+		cmd.Stdout = process.tee.Stdout()
+		cmd.Stderr = process.tee.Stderr()
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			process.tee.Finish(nil)
+			process.releaseMounts()
+			return nil, fmt.Errorf("failed to create stdin pipe: %w", err)
+		}
+		process.stdin = stdin
+		if err := cmd.Start(); err != nil {
+			process.tee.Finish(nil)
+			process.releaseMounts()
+			return nil, fmt.Errorf("process failed to start in rootfs: %w", err)
+		}
+		process.started = time.Now()
+		// the script is killed if the cmd's context is done before it exits.
+		process.stop = context.AfterFunc(c.Context(), func() {
+			cmd.Process.Kill()
+		})
+		return process, nil
+	}
+}
+
+// sysProcAttr chroots the process into the rootfs, within a new user (and
+// mount) namespace mapping root to the calling user if requested.
+func (o *options) sysProcAttr(root string) *syscall.SysProcAttr {
+	attr := &syscall.SysProcAttr{Chroot: root}
+	if o.userns {
+		attr.Cloneflags = syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS
+		attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}}
+		attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}}
+	}
+	return attr
+}
+
+// command returns the command run in the rootfs. Cmds created from a script
+// are invoked with the shell, if given.
+func command(c nescript.Cmd, shell nescript.Subcommand) []string {
+	_, script, trailing, ok := c.Script()
+	if !ok || shell == nil {
+		return c.Raw()
+	}
+	return append(append(append([]string{}, shell...), script), trailing...)
+}
+
+// pathEnv returns the PATH set in the env, or the default.
+func pathEnv(env []string) string {
+	for i := len(env) - 1; i >= 0; i-- {
+		if value, ok := strings.CutPrefix(env[i], "PATH="); ok {
+			return value
+		}
+	}
+	return defaultPath
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package chroot
+
+import "github.com/neaas/nescript"
+
+// Executor provides an ExecFunc that runs the script/cmd chrooted into the
+// rootfs. This is only supported on linux, elsewhere every execution returns
+// ErrUnsupported.
+func Executor(rootfs string, opts ...Option) nescript.ExecFunc {
+	return func(c nescript.Cmd) (nescript.Process, error) {
+		return nil, ErrUnsupported
+	}
+}
@@ -0,0 +1,23 @@
+package chroot
+
+import "errors"
+
+var (
+	// ErrUnsupported is returned when the chroot executor is used on an
+	// operating system other than linux.
+	ErrUnsupported = errors.New("chroot executor is only supported on linux")
+
+	// ErrRootfs is returned (wrapped) when the rootfs does not exist, or is not
+	// a directory.
+	ErrRootfs = errors.New("invalid rootfs")
+
+	// ErrInterpreterNotFound is returned (wrapped) when the command, or the
+	// interpreter it needs (its #! interpreter, or the dynamic loader of an ELF
+	// binary), does not exist within the rootfs. This is checked before
+	// anything is mounted.
+	ErrInterpreterNotFound = errors.New("interpreter not found in rootfs")
+
+	// ErrMount is returned (wrapped) when the mounts within the rootfs could not
+	// be set up. Any mounts already made are torn down.
+	ErrMount = errors.New("failed to set up rootfs mounts")
+)
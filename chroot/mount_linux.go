@@ -0,0 +1,144 @@
+package chroot
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// rootfsMounts tracks the mounts set up within each rootfs, shared by the
+// scripts running in it, so that concurrent executions in the same rootfs
+// neither mount twice nor tear down the mounts of one another.
+var rootfsMounts = struct {
+	mu     sync.Mutex
+	rootfs map[string]*mounts
+}{rootfs: map[string]*mounts{}}
+
+// mounts are the mounts set up within a rootfs, in the order they were made.
+type mounts struct {
+	refs   int
+	points []mountPoint
+}
+
+// mountPoint is a mount within the rootfs. If the mount point did not exist,
+// the file or directory created for it is removed on teardown.
+type mountPoint struct {
+	target  string
+	created []string
+}
+
+// acquireMounts sets up the mounts within the rootfs, unless they already
+// are, returning the func releasing them. The last release tears them down.
+func acquireMounts(root string, proc bool, devices []string) (func() error, error) {
+	rootfsMounts.mu.Lock()
+	defer rootfsMounts.mu.Unlock()
+	m, ok := rootfsMounts.rootfs[root]
+	if !ok {
+		m = &mounts{}
+		if err := m.setup(root, proc, devices); err != nil {
+			return nil, err
+		}
+		rootfsMounts.rootfs[root] = m
+	}
+	m.refs++
+	var once sync.Once
+	return func() error {
+		var err error
+		once.Do(func() {
+			rootfsMounts.mu.Lock()
+			defer rootfsMounts.mu.Unlock()
+			if m.refs--; m.refs == 0 {
+				delete(rootfsMounts.rootfs, root)
+				err = m.teardown()
+			}
+		})
+		return err
+	}, nil
+}
+
+// setup mounts /proc and bind mounts the devices within the rootfs. If any
+// mount fails, those already made are torn down.
+func (m *mounts) setup(root string, proc bool, devices []string) error {
+	if proc {
+		if err := m.mount(root, "/proc", true, func(target string) error {
+			return syscall.Mount("proc", target, "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, "")
+		}); err != nil {
+			return errors.Join(fmt.Errorf("%w: /proc: %w", ErrMount, err), m.teardown())
+		}
+	}
+	for _, device := range devices {
+		if err := m.mount(root, device, false, func(target string) error {
+			return syscall.Mount(device, target, "", syscall.MS_BIND, "")
+		}); err != nil {
+			return errors.Join(fmt.Errorf("%w: %s: %w", ErrMount, device, err), m.teardown())
+		}
+	}
+	return nil
+}
+
+// mount creates the mount point (a directory, or a file for devices) within
+// the rootfs if it does not exist, then mounts onto it.
+func (m *mounts) mount(root, path string, dir bool, mount func(target string) error) error {
+	target, err := resolve(root, path)
+	if err != nil {
+		return err
+	}
+	point := mountPoint{target: target}
+	if _, err := os.Lstat(target); os.IsNotExist(err) {
+		parent := filepath.Dir(target)
+		if _, err := os.Stat(parent); os.IsNotExist(err) {
+			if err := os.Mkdir(parent, 0o755); err != nil {
+				return err
+			}
+			point.created = append(point.created, parent)
+		}
+		if dir {
+			err = os.Mkdir(target, 0o555)
+		} else {
+			var file *os.File
+			if file, err = os.OpenFile(target, os.O_CREATE|os.O_EXCL, 0o644); err == nil {
+				err = file.Close()
+			}
+		}
+		if err != nil {
+			removeCreated(point.created)
+			return err
+		}
+		point.created = append(point.created, target)
+	}
+	if err := mount(target); err != nil {
+		removeCreated(point.created)
+		return err
+	}
+	m.points = append(m.points, point)
+	return nil
+}
+
+// teardown unmounts everything mounted within the rootfs in reverse order,
+// lazily detaching mounts that are busy, and removes the mount points created
+// for them.
+func (m *mounts) teardown() error {
+	var errs []error
+	for i := len(m.points) - 1; i >= 0; i-- {
+		point := m.points[i]
+		if err := syscall.Unmount(point.target, 0); err != nil {
+			if err := syscall.Unmount(point.target, syscall.MNT_DETACH); err != nil {
+				errs = append(errs, fmt.Errorf("failed to unmount '%s': %w", point.target, err))
+				continue
+			}
+		}
+		removeCreated(point.created)
+	}
+	m.points = nil
+	return errors.Join(errs...)
+}
+
+// removeCreated removes the created mount points, deepest first.
+func removeCreated(created []string) {
+	for i := len(created) - 1; i >= 0; i-- {
+		os.Remove(created[i])
+	}
+}
@@ -0,0 +1,78 @@
+package chroot
+
+import "github.com/neaas/nescript"
+
+const (
+	// defaultPath is the PATH commands are looked up in within the rootfs when
+	// the cmd's env does not set one.
+	defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+	// defaultDevice is the device bind mounted into the rootfs unless others
+	// are given with WithDevices.
+	defaultDevice = "/dev/null"
+)
+
+// Option configures the chroot executor.
+type Option func(*options)
+
+type options struct {
+	shell   nescript.Subcommand
+	workDir string
+	devices []string
+	noProc  bool
+	userns  bool
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		workDir: "/",
+		devices: []string{defaultDevice},
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithShell sets the shell scripts are invoked with within the rootfs, such as
+// nescript.SCBash, rather than the cmd's subcommand. Cmds not created from a
+// script are unaffected.
+func WithShell(shell nescript.Subcommand) Option {
+	return func(o *options) {
+		o.shell = shell
+	}
+}
+
+// WithWorkDir sets the directory, within the rootfs, the script is run in,
+// which is / unless given.
+func WithWorkDir(dir string) Option {
+	return func(o *options) {
+		o.workDir = dir
+	}
+}
+
+// WithDevices sets the host devices bind mounted into the rootfs (at the same
+// path), which is only /dev/null unless given. For example /dev/zero and
+// /dev/urandom.
+func WithDevices(devices ...string) Option {
+	return func(o *options) {
+		o.devices = devices
+	}
+}
+
+// WithoutProc does not mount /proc within the rootfs.
+func WithoutProc() Option {
+	return func(o *options) {
+		o.noProc = true
+	}
+}
+
+// WithUserNamespace runs the script as root within a new user namespace,
+// mapped to the calling user, rather than requiring root. This is the default
+// when not running as root. Nothing is mounted within the rootfs in a user
+// namespace, so the script has no /proc or devices.
+func WithUserNamespace() Option {
+	return func(o *options) {
+		o.userns = true
+	}
+}
@@ -0,0 +1,154 @@
+package chroot
+
+import (
+	"bytes"
+	"debug/elf"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// maxSymlinks is the number of symlinks followed when resolving a path
+	// within the rootfs, as the kernel limits it.
+	maxSymlinks = 40
+
+	// maxInterpreters is how deep a chain of #! interpreters is followed.
+	maxInterpreters = 4
+)
+
+// resolve resolves the (absolute) path within the rootfs as the chrooted
+// process would, following symlinks with absolute targets from the rootfs
+// rather than the host root, so that the result never escapes the rootfs.
+// The host path is returned, whether or not it exists.
+func resolve(rootfs, p string) (string, error) {
+	current := "/"
+	remaining := strings.Split(path.Clean("/"+p), "/")
+	for links := 0; len(remaining) > 0; {
+		component := remaining[0]
+		remaining = remaining[1:]
+		switch component {
+		case "", ".":
+			continue
+		case "..":
+			current = path.Dir(current)
+			continue
+		}
+		next := path.Join(current, component)
+		info, err := os.Lstat(filepath.Join(rootfs, next))
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			current = next
+			continue
+		}
+		if links++; links > maxSymlinks {
+			return "", fmt.Errorf("too many symlinks resolving '%s'", p)
+		}
+		target, err := os.Readlink(filepath.Join(rootfs, next))
+		if err != nil {
+			return "", err
+		}
+		if path.IsAbs(target) {
+			current = "/"
+		}
+		remaining = append(strings.Split(target, "/"), remaining...)
+	}
+	return filepath.Join(rootfs, current), nil
+}
+
+// lookPath finds the command within the rootfs, searching the PATH for names
+// without a slash, returning its path within the rootfs and on the host.
+func lookPath(rootfs, workDir, name, pathEnv string) (string, string, error) {
+	var candidates []string
+	if strings.Contains(name, "/") {
+		if !path.IsAbs(name) {
+			name = path.Join(workDir, name)
+		}
+		candidates = []string{name}
+	} else {
+		for _, dir := range filepath.SplitList(pathEnv) {
+			if dir == "" {
+				dir = "."
+			}
+			candidates = append(candidates, path.Join(dir, name))
+		}
+	}
+	for _, candidate := range candidates {
+		host, err := resolve(rootfs, candidate)
+		if err != nil {
+			continue
+		}
+		if info, err := os.Stat(host); err == nil && info.Mode().IsRegular() && info.Mode()&0o111 != 0 {
+			return candidate, host, nil
+		}
+	}
+	return "", "", fmt.Errorf("%w: '%s' (searched %s)", ErrInterpreterNotFound, name, strings.Join(candidates, ", "))
+}
+
+// checkInterpreter ensures the interpreter the executable needs exists within
+// the rootfs: the #! interpreter of a script (along with its own), or the
+// dynamic loader (PT_INTERP) of an ELF binary.
+func checkInterpreter(rootfs, inRoot, host string, depth int) error {
+	interpreter, err := interpreterOf(host)
+	if err != nil {
+		return fmt.Errorf("failed to read '%s' in rootfs: %w", inRoot, err)
+	}
+	if interpreter == "" {
+		return nil
+	}
+	if depth >= maxInterpreters {
+		return fmt.Errorf("%w: '%s' has too many nested interpreters", ErrInterpreterNotFound, inRoot)
+	}
+	interpreterHost, err := resolve(rootfs, interpreter)
+	if err == nil {
+		_, err = os.Stat(interpreterHost)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: '%s' needs '%s', which is not in the rootfs", ErrInterpreterNotFound, inRoot, interpreter)
+	}
+	return checkInterpreter(rootfs, interpreter, interpreterHost, depth+1)
+}
+
+// interpreterOf returns the interpreter the executable at the (host) path
+// needs, if any.
+func interpreterOf(host string) (string, error) {
+	file, err := os.Open(host)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	header := make([]byte, 256)
+	n, err := io.ReadFull(file, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	header = header[:n]
+	if bytes.HasPrefix(header, []byte("#!")) {
+		line, _, _ := bytes.Cut(header[2:], []byte("\n"))
+		if fields := strings.Fields(string(line)); len(fields) > 0 {
+			return fields[0], nil
+		}
+		return "", nil
+	}
+	if !bytes.HasPrefix(header, []byte(elf.ELFMAG)) {
+		return "", nil
+	}
+	binary, err := elf.NewFile(file)
+	if err != nil {
+		return "", err
+	}
+	for _, prog := range binary.Progs {
+		if prog.Type != elf.PT_INTERP {
+			continue
+		}
+		interp, err := io.ReadAll(prog.Open())
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(interp), "\x00"), nil
+	}
+	return "", nil
+}
@@ -0,0 +1,147 @@
+package chroot
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/neaas/nescript"
+)
+
+// ChrootProcess represents a single instance of the script running or
+// completed within a rootfs.
+type ChrootProcess struct {
+	cmd         *exec.Cmd
+	root        string
+	stdin       io.WriteCloser
+	stdoutBytes bytes.Buffer
+	stderrBytes bytes.Buffer
+	stop        func() bool
+	release     func() error
+	releaseOnce sync.Once
+	releaseErr  error
+	waitOnce    sync.Once
+	waitErr     error
+	started     time.Time
+	ended       time.Time
+	exited      chan struct{}
+	tee         *nescript.Tee
+}
+
+func (p *ChrootProcess) Kill() error {
+	select {
+	case <-p.exited:
+		return nil
+	default:
+	}
+	if err := p.cmd.Process.Kill(); err != nil {
+		return fmt.Errorf("failed to kill process: %w", err)
+	}
+	return nil
+}
+
+func (p *ChrootProcess) Signal(s os.Signal) error {
+	if err := p.cmd.Process.Signal(s); err != nil {
+		return fmt.Errorf("failed to send signal to process: %w", err)
+	}
+	return nil
+}
+
+func (p *ChrootProcess) Write(input string) error {
+	if _, err := io.WriteString(p.stdin, input); err != nil {
+		return fmt.Errorf("failed to write to stdin: %w", err)
+	}
+	return nil
+}
+
+func (p *ChrootProcess) Stdin() io.WriteCloser {
+	return nescript.StdinWriter(p)
+}
+
+// CloseStdin closes the script's stdin.
+func (p *ChrootProcess) CloseStdin() error {
+	if err := p.stdin.Close(); err != nil {
+		return fmt.Errorf("failed to close stdin: %w", err)
+	}
+	return nil
+}
+
+func (p *ChrootProcess) Stdout() io.Reader {
+	return p.tee.StdoutStream()
+}
+
+func (p *ChrootProcess) Stderr() io.Reader {
+	return p.tee.StderrStream()
+}
+
+// ID returns the PID of the script on the host.
+func (p *ChrootProcess) ID() string {
+	return strconv.Itoa(p.cmd.Process.Pid)
+}
+
+func (p *ChrootProcess) String() string {
+	return fmt.Sprintf("chroot %s pid %d", p.root, p.cmd.Process.Pid)
+}
+
+func (p *ChrootProcess) Wait(ctx context.Context) (*nescript.Result, error) {
+	return nescript.Wait(ctx, p)
+}
+
+// wait waits for the process to exit, then releases the rootfs mounts.
+func (p *ChrootProcess) wait() error {
+	p.waitOnce.Do(func() {
+		p.waitErr = p.cmd.Wait()
+		p.ended = time.Now()
+		close(p.exited)
+		p.stop()
+		p.tee.Finish(nil)
+		p.releaseMounts()
+	})
+	return p.waitErr
+}
+
+func (p *ChrootProcess) releaseMounts() {
+	p.releaseOnce.Do(func() {
+		p.releaseErr = p.release()
+	})
+}
+
+// Result waits for the script to complete. Failing to tear down the rootfs
+// mounts fails the result, as they would otherwise be left on the host.
+func (p *ChrootProcess) Result() (*nescript.Result, error) {
+	err := p.wait()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return nil, fmt.Errorf("failed to wait for process: %w", err)
+	}
+	if p.releaseErr != nil {
+		return nil, fmt.Errorf("%w: teardown: %w", ErrMount, p.releaseErr)
+	}
+	result := nescript.Result{
+		StdOut: p.stdoutBytes.String(),
+		StdErr: p.stderrBytes.String(),
+	}
+	result.ExitCode = p.cmd.ProcessState.ExitCode()
+	if status, ok := p.cmd.ProcessState.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		result.SetSignal(int(status.Signal()))
+	}
+	result.SetTimes(p.started, p.ended)
+	result.SetMetadata(nescript.MetadataExecutor, "chroot")
+	p.tee.Finish(&result)
+	return &result, nil
+}
+
+// Close kills the script if it is still running, and releases the rootfs
+// mounts.
+func (p *ChrootProcess) Close() {
+	p.cmd.Process.Kill()
+	p.wait()
+}
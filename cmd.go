@@ -2,15 +2,20 @@ package nescript
 
 import (
 	"bytes"
-	"fmt"
-	"html/template"
+	"context"
+	"io"
 )
 
 type Cmd struct {
-	command   string
-	args      []string
-	formatter Formatter
-	*dynamicData
+	command    string
+	args       []string
+	formatter  Formatter
+	script     int
+	ctx        context.Context
+	stdout     io.Writer
+	stderr     io.Writer
+	templating templating
+	dynamicData
 }
 
 func NewCmd(command string, args ...string) *Cmd {
@@ -21,10 +26,7 @@ func NewCmd(command string, args ...string) *Cmd {
 		command:   command,
 		args:      args,
 		formatter: defaultCmdFormatter,
-		dynamicData: &dynamicData{
-			data: make(map[string]any),
-			env:  make([]string, 0),
-		},
+		script:    -1,
 	}
 	return &cmd
 }
@@ -35,6 +37,31 @@ func (c Cmd) Raw() []string {
 	return append([]string{c.command}, c.args...)
 }
 
+// Script returns the script the command was created from (see Script.Cmd) in
+// its current state, along with the subcommand used to invoke it and any
+// arguments following it. This allows executors to invoke the script in a
+// different way, such as with a shell available on the target. False is
+// returned if the command was not created from a script.
+func (c Cmd) Script() (Subcommand, string, []string, bool) {
+	raw := c.Raw()
+	if c.script < 0 || c.script >= len(raw) {
+		return nil, "", nil, false
+	}
+	return Subcommand(raw[:c.script]), raw[c.script], raw[c.script+1:], true
+}
+
+// withScriptRaw returns a copy of the cmd created from a script, with the raw
+// script replaced.
+func (c Cmd) withScriptRaw(raw string) Cmd {
+	if c.script == 0 {
+		c.command = raw
+		return c
+	}
+	c.args = append([]string{}, c.args...)
+	c.args[c.script-1] = raw
+	return c
+}
+
 // WithArg adds an argument to the end of the current arguments slice associated
 // with the command.
 func (c Cmd) WithArg(arg string) Cmd {
@@ -69,10 +96,18 @@ func (c Cmd) WithFields(fields map[string]any, overwrite bool) Cmd {
 	return c
 }
 
+// Grow pre-sizes the command's template data and env for the number of fields
+// and env vars to be added (see Script.Grow).
+func (c Cmd) Grow(fields, env int) Cmd {
+	c.grow(fields, env)
+	return c
+}
+
 // WithEnv takes one or more environmental variables in KEY=VALUE format. These
 // will be used when executing the command. These will not be applied to the
 // actual arguments of the command, but to any subprocess spawned by the
-// command. This is different from the Env behavior of a Script.
+// command. This is different from the Env behavior of a Script. The env of the
+// cmd it is called on is left unchanged.
 func (c Cmd) WithEnv(env ...string) Cmd {
 	c.addEnv(env...)
 	return c
@@ -85,32 +120,79 @@ func (c Cmd) WithLocalOSEnv() Cmd {
 	return c
 }
 
+// WithContext sets the context the command is executed with. Executors that
+// support it terminate the process if the context is cancelled before the
+// command completes.
+func (c Cmd) WithContext(ctx context.Context) Cmd {
+	c.ctx = ctx
+	return c
+}
+
+// Context returns the context the command is executed with. If none was set,
+// the background context is returned.
+func (c Cmd) Context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// WithStdoutWriter tees the stdout of the process to the writer as the script
+// writes it, while still capturing it for the result. See Tee for how the
+// writes are made. A writer shared by processes running at the same time, such
+// as those of a fan-out, must be safe for concurrent use.
+func (c Cmd) WithStdoutWriter(w io.Writer) Cmd {
+	c.stdout = w
+	return c
+}
+
+// WithStderrWriter tees the stderr of the process to the writer as the script
+// writes it, while still capturing it for the result. See Tee for how the
+// writes are made.
+func (c Cmd) WithStderrWriter(w io.Writer) Cmd {
+	c.stderr = w
+	return c
+}
+
+// OutputWriters returns the writers the stdout and stderr of the process are
+// teed to, either of which may be nil.
+func (c Cmd) OutputWriters() (stdout, stderr io.Writer) {
+	return c.stdout, c.stderr
+}
+
 func (c Cmd) WithFormatter(formatter Formatter) Cmd {
 	c.formatter = formatter
 	return c
 }
 
-// Compile uses the go template engine and the provided data fields to compile
-// the command. These in-turn act a more portable approach than command-line
+// Compile uses the template engine (see WithTemplater) and the provided data
+// fields to compile the command. These in-turn act a more portable approach than command-line
 // arguments.
+// A *CompileError is returned if a template could not be compiled.
 func (c Cmd) Compile() (Cmd, error) {
 	compiledArgs := make([]string, len(c.args))
+	var data map[string]any
 	for idx, a := range c.args {
-		argTemplate, err := template.New("").Parse(a)
+		// an arg without template actions compiles to itself, so is not parsed.
+		if !c.templating.hasActions(a) {
+			compiledArgs[idx] = a
+			continue
+		}
+		argTemplate, err := c.templating.parse(a)
 		if err != nil {
-			return c, fmt.Errorf("failed to parse a command arg: %w", err)
+			return c, &CompileError{Arg: idx, Parse: true, Err: err}
 		}
-		if c.data == nil {
-			c.data = make(map[string]any)
+		if data == nil {
+			data = c.Data()
 		}
 		compiledArg := &bytes.Buffer{}
-		if err := argTemplate.Execute(compiledArg, c.data); err != nil {
-			return c, fmt.Errorf("cmd arg template could not be compiled: %w", err)
+		if err := argTemplate.Render(compiledArg, data); err != nil {
+			return c, &CompileError{Arg: idx, Err: err}
 		}
 		compiledArgs[idx] = compiledArg.String()
 	}
 	c.args = compiledArgs
-	c.data = make(map[string]any)
+	c.clearData()
 	return c, nil
 }
 
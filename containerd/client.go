@@ -0,0 +1,143 @@
+package containerd
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+
+	gocontainerd "github.com/containerd/containerd"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/snapshots"
+)
+
+// nerdctlNameLabel is the label nerdctl records a container's name in.
+const nerdctlNameLabel = "nerdctl/name"
+
+// Client is the part of the containerd client API used by the executors. It is
+// satisfied by containerd's *containerd.Client, so an application's existing
+// client can be given to the executors. Executors never close a client given to
+// them.
+type Client interface {
+	LoadContainer(ctx context.Context, id string) (gocontainerd.Container, error)
+	Containers(ctx context.Context, filters ...string) ([]gocontainerd.Container, error)
+	NewContainer(ctx context.Context, id string, opts ...gocontainerd.NewContainerOpts) (gocontainerd.Container, error)
+	GetImage(ctx context.Context, ref string) (gocontainerd.Image, error)
+	Pull(ctx context.Context, ref string, opts ...gocontainerd.RemoteOpt) (gocontainerd.Image, error)
+	SnapshotService(snapshotterName string) snapshots.Snapshotter
+}
+
+// isNilClient reports whether no client was given, including a nil
+// *containerd.Client.
+func isNilClient(client Client) bool {
+	if client == nil {
+		return true
+	}
+	c, ok := client.(*gocontainerd.Client)
+	return ok && c == nil
+}
+
+// NewClient connects to containerd at the address of its socket, such as
+// /run/containerd/containerd.sock or that of a rootless containerd. The client
+// should be closed once it is no longer needed.
+func NewClient(address string) (*gocontainerd.Client, error) {
+	client, err := gocontainerd.New(address)
+	if err != nil {
+		return nil, fmt.Errorf("%w: '%s': %w", ErrConnection, address, err)
+	}
+	return client, nil
+}
+
+// connection provides the client executions use, connecting to containerd the
+// first time it is needed if the executor was not given one. A failed
+// connection is retried by the next execution.
+type connection struct {
+	mu      sync.Mutex
+	client  Client
+	address string
+}
+
+func newConnection(client Client, address string) *connection {
+	if isNilClient(client) {
+		client = nil
+	}
+	return &connection{client: client, address: address}
+}
+
+func (conn *connection) get() (Client, error) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.client == nil {
+		client, err := NewClient(conn.address)
+		if err != nil {
+			return nil, err
+		}
+		conn.client = client
+	}
+	return conn.client, nil
+}
+
+// loadContainer finds the container by its ID or, failing that, by its
+// nerdctl name.
+func loadContainer(ctx context.Context, client Client, id string) (gocontainerd.Container, error) {
+	container, err := client.LoadContainer(ctx, id)
+	if err == nil {
+		return container, nil
+	} else if !errdefs.IsNotFound(err) {
+		return nil, fmt.Errorf("%w: failed to load container '%s': %w", ErrConnection, id, err)
+	}
+	named, err := client.Containers(ctx, "labels."+strconv.Quote(nerdctlNameLabel)+"=="+strconv.Quote(id))
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to list containers: %w", ErrConnection, err)
+	}
+	switch len(named) {
+	case 0:
+		return nil, fmt.Errorf("%w: '%s'", ErrContainerNotFound, id)
+	case 1:
+		return named[0], nil
+	}
+	return nil, fmt.Errorf("%w: %d containers are named '%s'", ErrAmbiguousContainer, len(named), id)
+}
+
+// runningTask gets the container's task, checking that it is running.
+func runningTask(ctx context.Context, container gocontainerd.Container) (gocontainerd.Task, error) {
+	task, err := container.Task(ctx, nil)
+	if errdefs.IsNotFound(err) {
+		return nil, fmt.Errorf("%w: container '%s' has no task", ErrTaskNotRunning, container.ID())
+	} else if err != nil {
+		return nil, fmt.Errorf("%w: failed to get task of container '%s': %w", ErrConnection, container.ID(), err)
+	}
+	status, err := task.Status(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to get status of container '%s': %w", ErrConnection, container.ID(), err)
+	}
+	if status.Status != gocontainerd.Running {
+		return nil, fmt.Errorf("%w: container '%s' is %s", ErrTaskNotRunning, container.ID(), status.Status)
+	}
+	return task, nil
+}
+
+// getImage gets the image, pulling it if it does not exist, and ensures it is
+// unpacked into the snapshotter.
+func getImage(ctx context.Context, client Client, ref, snapshotter string) (gocontainerd.Image, error) {
+	image, err := client.GetImage(ctx, ref)
+	if errdefs.IsNotFound(err) {
+		image, err = client.Pull(ctx, ref, gocontainerd.WithPullUnpack, gocontainerd.WithPullSnapshotter(snapshotter))
+		if err != nil {
+			return nil, fmt.Errorf("%w: '%s': %w", ErrImagePull, ref, err)
+		}
+		return image, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("%w: failed to get image '%s': %w", ErrConnection, ref, err)
+	}
+	unpacked, err := image.IsUnpacked(ctx, snapshotter)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to check image '%s' is unpacked: %w", ErrImagePull, ref, err)
+	}
+	if !unpacked {
+		if err := image.Unpack(ctx, snapshotter); err != nil {
+			return nil, fmt.Errorf("%w: failed to unpack '%s': %w", ErrImagePull, ref, err)
+		}
+	}
+	return image, nil
+}
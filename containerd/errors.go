@@ -0,0 +1,40 @@
+package containerd
+
+import (
+	"errors"
+
+	"github.com/neaas/nescript"
+)
+
+var (
+	// ErrConnection is returned (wrapped) when containerd could not be
+	// connected to, as opposed to a failure once connected.
+	ErrConnection = nescript.NewError(nescript.ErrConnection, "failed to connect to containerd")
+
+	// ErrContainerNotFound is returned (wrapped) when no container of the ID
+	// (or nerdctl name) the script should be executed in exists in the
+	// namespace.
+	ErrContainerNotFound = errors.New("containerd container not found")
+
+	// ErrAmbiguousContainer is returned (wrapped) when the container is given
+	// by its nerdctl name, however more than one container in the namespace
+	// has that name.
+	ErrAmbiguousContainer = errors.New("more than one containerd container matched")
+
+	// ErrTaskNotRunning is returned (wrapped) when the container the script
+	// should be executed in exists, however its task is not running (such as
+	// when it has exited, was never started or is paused).
+	ErrTaskNotRunning = errors.New("containerd task is not running")
+
+	// ErrImagePull is returned (wrapped) when the image of the script container
+	// could not be pulled or unpacked into the snapshotter.
+	ErrImagePull = errors.New("failed to pull image")
+
+	// ErrExecution is returned (wrapped) when the script could not be started,
+	// or its exit status was lost.
+	ErrExecution = errors.New("containerd execution failed")
+
+	// ErrTimeout is returned (wrapped) when the script did not complete within
+	// the timeout given by WithTimeout. The script is killed.
+	ErrTimeout = nescript.NewError(nescript.ErrTimeout, "containerd execution timed out")
+)
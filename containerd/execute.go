@@ -0,0 +1,240 @@
+package containerd
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	gocontainerd "github.com/containerd/containerd"
+	"github.com/containerd/containerd/cio"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/oci"
+	"github.com/neaas/nescript"
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// Executor provides an ExecFunc that runs the script/cmd in the running
+// container with the ID (or nerdctl name), as `ctr task exec` does, directly
+// through containerd rather than a docker engine. A containerd client (any
+// Client, such as a *containerd.Client) may be given, which the executor does
+// not close. If nil, one is created the first time the ExecFunc is used (see
+// NewClient and WithAddress) and reused by every execution after. The container
+// is looked up in the namespace given by WithNamespace. This ExecFunc does not
+// require that the cmd/script be converted to a string, so is Formatter
+// agnostic.
+func Executor(client Client, containerID string, opts ...Option) nescript.ExecFunc {
+	o := newOptions(opts)
+	conn := newConnection(client, o.address)
+	return func(c nescript.Cmd) (nescript.Process, error) {
+		client, err := conn.get()
+		if err != nil {
+			return nil, err
+		}
+		return o.exec(c, client, containerID)
+	}
+}
+
+// RunExecutor provides an ExecFunc that runs the script/cmd in a new container
+// created from the image for each execution, as `ctr run --rm` does. The image
+// is pulled if it does not exist in the namespace, and unpacked into the
+// snapshotter given by WithSnapshotter if it is not already. The container,
+// and the snapshot of its root filesystem, are deleted once the script has
+// exited. The client is as for Executor.
+func RunExecutor(client Client, image string, opts ...Option) nescript.ExecFunc {
+	o := newOptions(opts)
+	conn := newConnection(client, o.address)
+	return func(c nescript.Cmd) (nescript.Process, error) {
+		client, err := conn.get()
+		if err != nil {
+			return nil, err
+		}
+		return o.run(c, client, image)
+	}
+}
+
+func (o *options) exec(c nescript.Cmd, client Client, containerID string) (nescript.Process, error) {
+	ctx := namespaces.WithNamespace(c.Context(), o.namespace)
+	container, err := loadContainer(ctx, client, containerID)
+	if err != nil {
+		return nil, err
+	}
+	task, err := runningTask(ctx, container)
+	if err != nil {
+		return nil, err
+	}
+	spec, err := o.execSpec(ctx, client, container, c)
+	if err != nil {
+		return nil, err
+	}
+	execID, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	p := o.newProcess(c, container.ID())
+	p.execID = execID
+	process, err := task.Exec(ctx, execID, spec, p.creator())
+	if err != nil {
+		p.stdin.Close()
+		p.tee.Finish(nil)
+		return nil, fmt.Errorf("%w: failed to create exec in container '%s': %w", ErrExecution, container.ID(), err)
+	}
+	p.process = process
+	p.deleteFunc = func(ctx context.Context) {
+		process.Delete(ctx, gocontainerd.WithProcessKill)
+	}
+	return p.start(ctx)
+}
+
+// execSpec builds the spec of the exec process from that of the container's,
+// so that it keeps the container's env, user and capabilities.
+func (o *options) execSpec(ctx context.Context, client Client, container gocontainerd.Container, c nescript.Cmd) (*specs.Process, error) {
+	spec, err := container.Spec(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to get spec of container '%s': %w", ErrConnection, container.ID(), err)
+	}
+	if spec.Process == nil {
+		return nil, fmt.Errorf("%w: container '%s' has no process", ErrExecution, container.ID())
+	}
+	process := *spec.Process
+	process.Terminal = false
+	process.Args = command(c, o.shell)
+	process.Env = append(slices.Clone(process.Env), c.Env()...)
+	if o.workdir != "" {
+		process.Cwd = o.workdir
+	}
+	if o.user != "" {
+		info, err := container.Info(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to get container '%s': %w", ErrConnection, container.ID(), err)
+		}
+		spec.Process = &process
+		if err := oci.WithUser(o.user)(ctx, client, &info, spec); err != nil {
+			return nil, fmt.Errorf("%w: failed to set user '%s': %w", ErrExecution, o.user, err)
+		}
+	}
+	return &process, nil
+}
+
+func (o *options) run(c nescript.Cmd, client Client, ref string) (nescript.Process, error) {
+	ctx := namespaces.WithNamespace(c.Context(), o.namespace)
+	image, err := getImage(ctx, client, ref, o.snapshotter)
+	if err != nil {
+		return nil, err
+	}
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	specOpts := []oci.SpecOpts{
+		oci.WithImageConfig(image),
+		oci.WithProcessArgs(command(c, o.shell)...),
+		oci.WithEnv(c.Env()),
+	}
+	if o.workdir != "" {
+		specOpts = append(specOpts, oci.WithProcessCwd(o.workdir))
+	}
+	if o.user != "" {
+		specOpts = append(specOpts, oci.WithUser(o.user))
+	}
+	// the snapshot is created before the spec, so that the user can be looked
+	// up in its /etc/passwd.
+	container, err := client.NewContainer(ctx, id,
+		gocontainerd.WithImage(image),
+		gocontainerd.WithSnapshotter(o.snapshotter),
+		gocontainerd.WithNewSnapshot(id, image),
+		gocontainerd.WithNewSpec(specOpts...),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to create container from '%s': %w", ErrExecution, ref, err)
+	}
+	deleteContainer := func(ctx context.Context) {
+		container.Delete(ctx, gocontainerd.WithSnapshotCleanup)
+	}
+	p := o.newProcess(c, id)
+	task, err := container.NewTask(ctx, p.creator())
+	if err != nil {
+		p.stdin.Close()
+		p.tee.Finish(nil)
+		deleteContainer(cleanupContext(o.namespace))
+		return nil, fmt.Errorf("%w: failed to create task in container '%s': %w", ErrExecution, id, err)
+	}
+	p.process = task
+	p.deleteFunc = func(ctx context.Context) {
+		task.Delete(ctx, gocontainerd.WithProcessKill)
+		deleteContainer(ctx)
+	}
+	return p.start(ctx)
+}
+
+// newProcess creates the process of the cmd in the container, its context
+// being that of the cmd, limited by the timeout.
+func (o *options) newProcess(c nescript.Cmd, containerID string) *ContainerdProcess {
+	p := &ContainerdProcess{
+		containerID: containerID,
+		namespace:   o.namespace,
+		parent:      c.Context(),
+		timeout:     o.timeout > 0,
+		done:        make(chan struct{}),
+	}
+	if p.timeout {
+		p.ctx, p.cancel = context.WithTimeout(p.parent, o.timeout)
+	} else {
+		p.ctx, p.cancel = context.WithCancel(p.parent)
+	}
+	p.stdinReader, p.stdin = io.Pipe()
+	p.tee = nescript.NewTee(c, &p.stdout, &p.stderr)
+	return p
+}
+
+// creator creates the process's IO, streaming its stdin from Write and its
+// stdout and stderr into the process's buffers (teed to the cmd's writers).
+func (p *ContainerdProcess) creator() cio.Creator {
+	return cio.NewCreator(cio.WithStreams(p.stdinReader, p.tee.Stdout(), p.tee.Stderr()))
+}
+
+// start starts the process, waiting for it to exit in the background.
+func (p *ContainerdProcess) start(ctx context.Context) (nescript.Process, error) {
+	// the exit channel must be had before starting, or the exit could be
+	// missed. It is not bound to the cmd's context, so that the exit of a killed
+	// script is still received.
+	waitCtx, stopWait := context.WithCancel(namespaces.WithNamespace(context.Background(), p.namespace))
+	exited, err := p.process.Wait(waitCtx)
+	if err == nil {
+		err = p.process.Start(ctx)
+	}
+	if err != nil {
+		stopWait()
+		p.cancel()
+		p.stdin.Close()
+		p.delete()
+		p.tee.Finish(nil)
+		return nil, fmt.Errorf("%w: failed to start in container '%s': %w", ErrExecution, p.containerID, err)
+	}
+	p.started = time.Now()
+	go p.watch(exited, stopWait)
+	return p, nil
+}
+
+// command converts the cmd into the command run in the container. If a shell
+// is set and the cmd was created from a script, the script is passed to the
+// shell, otherwise the cmd is run as it is.
+func command(c nescript.Cmd, shell nescript.Subcommand) []string {
+	_, script, trailing, ok := c.Script()
+	if !ok || shell == nil {
+		return c.Raw()
+	}
+	return append(append(append([]string{}, shell...), script), trailing...)
+}
+
+// newID generates the ID of an exec process or script container.
+func newID() (string, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("failed to generate id: %w", err)
+	}
+	return "nescript-" + hex.EncodeToString(id), nil
+}
@@ -0,0 +1,104 @@
+package containerd
+
+import (
+	"os"
+	"time"
+
+	"github.com/containerd/containerd/defaults"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/neaas/nescript"
+)
+
+// Option configures the containerd executors.
+type Option func(*options)
+
+type options struct {
+	address     string
+	namespace   string
+	snapshotter string
+	shell       nescript.Subcommand
+	workdir     string
+	user        string
+	timeout     time.Duration
+}
+
+// newOptions creates the options, defaulting the address and namespace to
+// CONTAINERD_ADDRESS and CONTAINERD_NAMESPACE as ctr does.
+func newOptions(opts []Option) *options {
+	o := &options{
+		address:   os.Getenv("CONTAINERD_ADDRESS"),
+		namespace: os.Getenv(namespaces.NamespaceEnvVar),
+	}
+	if o.address == "" {
+		o.address = defaults.DefaultAddress
+	}
+	if o.namespace == "" {
+		o.namespace = namespaces.Default
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithAddress sets the address of the containerd socket connected to when the
+// executor is not given a client. CONTAINERD_ADDRESS is used unless given,
+// otherwise /run/containerd/containerd.sock.
+func WithAddress(address string) Option {
+	return func(o *options) {
+		o.address = address
+	}
+}
+
+// WithNamespace sets the containerd namespace the container is in (or created
+// in), such as "k8s.io" for the containers of kubernetes pods or "moby" for
+// those of the docker engine. CONTAINERD_NAMESPACE is used unless given,
+// otherwise "default", which is also that of nerdctl.
+func WithNamespace(namespace string) Option {
+	return func(o *options) {
+		o.namespace = namespace
+	}
+}
+
+// WithSnapshotter sets the snapshotter the image is unpacked into and the
+// script container's root filesystem is created by, such as "native" where
+// overlayfs is not available, rather than containerd's default.
+func WithSnapshotter(snapshotter string) Option {
+	return func(o *options) {
+		o.snapshotter = snapshotter
+	}
+}
+
+// WithShell sets the shell scripts are invoked with in the container, such as
+// nescript.SCBash, rather than the cmd's subcommand. Cmds not created from a
+// script are run as they are.
+func WithShell(shell nescript.Subcommand) Option {
+	return func(o *options) {
+		o.shell = shell
+	}
+}
+
+// WithWorkDir sets the working directory of the script (in the context of the
+// container's file system), rather than that of the container's process.
+func WithWorkDir(workdir string) Option {
+	return func(o *options) {
+		o.workdir = workdir
+	}
+}
+
+// WithUser sets the user the script is run as, as user, uid, user:group or
+// uid:gid (names being looked up in the container's /etc/passwd and
+// /etc/group), rather than that of the container's process.
+func WithUser(user string) Option {
+	return func(o *options) {
+		o.user = user
+	}
+}
+
+// WithTimeout limits how long the script may run for. If the script has not
+// completed within the timeout, it is killed and Result returns ErrTimeout.
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.timeout = timeout
+	}
+}
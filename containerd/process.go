@@ -0,0 +1,256 @@
+package containerd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	gocontainerd "github.com/containerd/containerd"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/neaas/nescript"
+)
+
+const (
+	// MetadataContainer is the result metadata key holding the ID of the
+	// container the script was executed in.
+	MetadataContainer = "containerd.container"
+
+	// MetadataNamespace is the result metadata key holding the containerd
+	// namespace of the container.
+	MetadataNamespace = "containerd.namespace"
+
+	// MetadataExec is the result metadata key holding the ID of the exec
+	// process the script was run as, set only by the Executor.
+	MetadataExec = "containerd.exec"
+
+	// killTimeout is how long the script's exit is waited for once it has been
+	// killed, should containerd not report it.
+	killTimeout = 10 * time.Second
+
+	// cleanupTimeout bounds deleting the exec process, or the script container
+	// and its snapshot.
+	cleanupTimeout = 30 * time.Second
+)
+
+// ContainerdProcess represents a single instance of the script running or
+// completed in a containerd container, either as an exec process in an existing
+// container or as the task of a new one.
+type ContainerdProcess struct {
+	process     gocontainerd.Process
+	containerID string
+	execID      string
+	namespace   string
+	deleteFunc  func(context.Context)
+	stdinReader *io.PipeReader
+	stdin       *io.PipeWriter
+	parent      context.Context
+	ctx         context.Context
+	cancel      context.CancelFunc
+	timeout     bool
+	done        chan struct{}
+	stdinOnce   sync.Once
+	deleteOnce  sync.Once
+	started     time.Time
+	tee         *nescript.Tee
+
+	// written by the process's IO, read once done is closed.
+	stdout bytes.Buffer
+	stderr bytes.Buffer
+
+	// set by watch, before done is closed.
+	exitCode int
+	ended    time.Time
+	err      error
+}
+
+// ContainerID returns the ID of the container the script is running in.
+func (p *ContainerdProcess) ContainerID() string {
+	return p.containerID
+}
+
+// PID returns the PID of the script on the host.
+func (p *ContainerdProcess) PID() uint32 {
+	return p.process.Pid()
+}
+
+// watch waits for the script to exit, then deletes it once its output has been
+// copied. If the context is done first, the script is killed.
+func (p *ContainerdProcess) watch(exited <-chan gocontainerd.ExitStatus, stopWait context.CancelFunc) {
+	defer close(p.done)
+	defer p.tee.Finish(nil)
+	defer p.cancel()
+	defer stopWait()
+	var status gocontainerd.ExitStatus
+	select {
+	case status = <-exited:
+		p.ended = time.Now()
+	case <-p.ctx.Done():
+		p.signal(syscall.SIGKILL)
+		select {
+		case <-exited:
+		case <-time.After(killTimeout):
+		}
+		p.stdin.Close()
+		p.delete()
+		p.err = p.ctxErr()
+		return
+	}
+	p.stdin.Close()
+	if pio := p.process.IO(); pio != nil {
+		pio.Wait()
+	}
+	p.delete()
+	code, _, err := status.Result()
+	if err != nil {
+		p.err = fmt.Errorf("%w: failed to wait for exit in container '%s': %w", ErrExecution, p.containerID, err)
+		return
+	}
+	p.exitCode = int(code)
+}
+
+// delete deletes the exec process, or the script container and its snapshot.
+func (p *ContainerdProcess) delete() {
+	p.deleteOnce.Do(func() {
+		ctx, cancel := context.WithTimeout(cleanupContext(p.namespace), cleanupTimeout)
+		defer cancel()
+		p.deleteFunc(ctx)
+	})
+}
+
+// cleanupContext is the context of deleting what the execution created, which
+// is not bound to the cmd's context as the cleanup is still needed once it is
+// done.
+func cleanupContext(namespace string) context.Context {
+	return namespaces.WithNamespace(context.Background(), namespace)
+}
+
+// ctxErr is the error the execution ended with once its context is done.
+func (p *ContainerdProcess) ctxErr() error {
+	if p.timeout && errors.Is(p.ctx.Err(), context.DeadlineExceeded) && p.parent.Err() == nil {
+		return fmt.Errorf("%w: script killed", ErrTimeout)
+	}
+	return fmt.Errorf("%w: script killed: %w", ErrExecution, nescript.ContextError(p.ctx.Err()))
+}
+
+func (p *ContainerdProcess) signal(sig syscall.Signal) error {
+	ctx, cancel := context.WithTimeout(cleanupContext(p.namespace), killTimeout)
+	defer cancel()
+	return p.process.Kill(ctx, sig)
+}
+
+func (p *ContainerdProcess) Kill() error {
+	select {
+	case <-p.done:
+		return nil
+	default:
+	}
+	if err := p.signal(syscall.SIGKILL); err != nil {
+		return fmt.Errorf("failed to kill containerd process: %w", err)
+	}
+	return nil
+}
+
+// Signal sends the signal to the script. Only syscall signals can be sent.
+func (p *ContainerdProcess) Signal(s os.Signal) error {
+	sig, ok := s.(syscall.Signal)
+	if !ok {
+		return fmt.Errorf("can not send signal '%s' to containerd process", s)
+	}
+	if err := p.signal(sig); err != nil {
+		return fmt.Errorf("failed to send signal to containerd process: %w", err)
+	}
+	return nil
+}
+
+func (p *ContainerdProcess) Write(input string) error {
+	if _, err := p.stdin.Write([]byte(input)); err != nil {
+		return fmt.Errorf("failed to write to containerd process stdin: %w", err)
+	}
+	return nil
+}
+
+// CloseStdin closes the script's stdin, for scripts that read it until EOF.
+func (p *ContainerdProcess) CloseStdin() error {
+	select {
+	case <-p.done:
+		return nil
+	default:
+	}
+	var err error
+	p.stdinOnce.Do(func() {
+		p.stdin.Close()
+		ctx, cancel := context.WithTimeout(cleanupContext(p.namespace), killTimeout)
+		defer cancel()
+		err = p.process.CloseIO(ctx, gocontainerd.WithStdinCloser)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to close containerd process stdin: %w", err)
+	}
+	return nil
+}
+
+func (p *ContainerdProcess) Stdin() io.WriteCloser {
+	return nescript.StdinWriter(p)
+}
+
+func (p *ContainerdProcess) Stdout() io.Reader {
+	return p.tee.StdoutStream()
+}
+
+func (p *ContainerdProcess) Stderr() io.Reader {
+	return p.tee.StderrStream()
+}
+
+// ID returns the ID of the exec process, or of the container the script is the
+// task of.
+func (p *ContainerdProcess) ID() string {
+	if p.execID != "" {
+		return p.execID
+	}
+	return p.containerID
+}
+
+func (p *ContainerdProcess) String() string {
+	if p.execID != "" {
+		return fmt.Sprintf("containerd exec %s in %s/%s", p.execID, p.namespace, p.containerID)
+	}
+	return fmt.Sprintf("containerd container %s/%s", p.namespace, p.containerID)
+}
+
+func (p *ContainerdProcess) Wait(ctx context.Context) (*nescript.Result, error) {
+	return nescript.Wait(ctx, p)
+}
+
+func (p *ContainerdProcess) Result() (*nescript.Result, error) {
+	<-p.done
+	if p.err != nil {
+		return nil, p.err
+	}
+	result := nescript.Result{
+		StdOut:   p.stdout.String(),
+		StdErr:   p.stderr.String(),
+		ExitCode: p.exitCode,
+	}
+	result.SetTimes(p.started, p.ended)
+	result.SetMetadata(nescript.MetadataExecutor, "containerd")
+	result.SetMetadata(MetadataContainer, p.containerID)
+	result.SetMetadata(MetadataNamespace, p.namespace)
+	if p.execID != "" {
+		result.SetMetadata(MetadataExec, p.execID)
+	}
+	p.tee.Finish(&result)
+	return &result, nil
+}
+
+// Close kills the script if it is still running, and deletes the exec process
+// or the script container.
+func (p *ContainerdProcess) Close() {
+	p.cancel()
+	<-p.done
+}
@@ -1,49 +1,177 @@
 package nescript
 
-import "os"
+import (
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
 
+// dynamicData holds the template data and env of a script/cmd. Each is a view
+// of a log shared between copies of the script/cmd, such as the script a
+// builder method (like WithField) was called on and the script it returned.
+// Adding to a view at the tip of its log appends in place, while adding to any
+// other view first copies it, so each copy only ever sees what was added to it:
+// deriving several scripts from one base never changes the base or the others.
 type dynamicData struct {
-	data map[string]any
-	env  []string
+	fields logView[field]
+	env    logView[string]
 }
 
-// Data returns the map of template data to be used when compiling the
-// script/cmd.
-func (dd dynamicData) Data() map[string]any {
-	return dd.data
+// field is a key/value of template data. Where a key is added more than once,
+// the last value added is that of the field.
+type field struct {
+	key   string
+	value any
 }
 
-// Env returns the env vars in KEY=VALUE format that will be used when executing
+// Data returns a copy of the map of template data to be used when compiling
 // the script/cmd.
+func (dd dynamicData) Data() map[string]any {
+	data := make(map[string]any, len(dd.fields.items))
+	for _, f := range dd.fields.items {
+		data[f.key] = f.value
+	}
+	return data
+}
+
+// Env returns a copy of the env vars in KEY=VALUE format that will be used
+// when executing the script/cmd.
 func (dd dynamicData) Env() []string {
-	return dd.env
+	if dd.env.items == nil {
+		return make([]string, 0)
+	}
+	return slices.Clone(dd.env.items)
 }
 
-func (dd *dynamicData) addField(key string, value any) {
-	if dd.data == nil {
-		dd.data = make(map[string]any)
+// field returns the value of the template data with the key, if it is set.
+func (dd dynamicData) field(key string) (any, bool) {
+	for i := len(dd.fields.items) - 1; i >= 0; i-- {
+		if dd.fields.items[i].key == key {
+			return dd.fields.items[i].value, true
+		}
 	}
-	dd.data[key] = value
+	return nil, false
+}
+
+func (dd *dynamicData) addField(key string, value any) {
+	dd.fields = dd.fields.appended(field{key: key, value: value})
 }
 
 func (dd *dynamicData) addFields(fields map[string]any, overwrite bool) {
-	if dd.data == nil {
-		dd.data = make(map[string]any)
+	var set map[string]any
+	if !overwrite {
+		set = dd.Data()
 	}
+	added := make([]field, 0, len(fields))
 	for k, v := range fields {
-		if _, ok := dd.data[k]; !ok || overwrite {
-			dd.data[k] = v
+		if _, ok := set[k]; !ok {
+			added = append(added, field{key: k, value: v})
 		}
 	}
+	dd.fields = dd.fields.appended(added...)
+}
+
+// clearData removes the template data, as once the script/cmd is compiled.
+func (dd *dynamicData) clearData() {
+	dd.fields = logView[field]{}
+}
+
+// grow pre-sizes the data for the number of fields and env vars to be added, so
+// that adding them allocates once.
+func (dd *dynamicData) grow(fields, env int) {
+	dd.fields = dd.fields.reserved(fields)
+	dd.env = dd.env.reserved(env)
 }
 
 func (dd *dynamicData) addEnv(env ...string) {
-	if dd.env == nil {
-		dd.env = make([]string, 0)
+	dd.env = dd.env.appended(env...)
+}
+
+// mergeEnv merges the env vars onto the env, each replacing the var of the same
+// key in its place, else being appended. The merged env is a new log, so does
+// not change the env of other copies.
+func (dd *dynamicData) mergeEnv(env ...string) {
+	merged := slices.Clone(dd.env.items)
+	indexes := make(map[string]int, len(merged))
+	for i, e := range merged {
+		key, _, _ := strings.Cut(e, "=")
+		indexes[key] = i
 	}
-	dd.env = append(dd.env, env...)
+	for _, e := range env {
+		key, _, _ := strings.Cut(e, "=")
+		if i, ok := indexes[key]; ok {
+			merged[i] = e
+			continue
+		}
+		indexes[key] = len(merged)
+		merged = append(merged, e)
+	}
+	dd.env = logView[string]{}.appended(merged...)
 }
 
 func (dd *dynamicData) addLocalOSEnv() {
 	dd.addEnv(os.Environ()...)
 }
+
+// logView is a view of the items of an append-only log, which may be shared
+// with other views. Items within a view are never changed, so views may be
+// read and appended to concurrently.
+type logView[T any] struct {
+	items []T
+	log   *sharedLog
+}
+
+// sharedLog records how much of the backing array of a log is used by its
+// views, the view of all of it being its tip.
+type sharedLog struct {
+	mu   sync.Mutex
+	used int
+}
+
+// minLogSize is the capacity a new log starts with at least, so that a chain
+// of builder methods adding one item at a time does not grow it for each of
+// the first few.
+const minLogSize = 8
+
+// appended returns the view with the items appended: in place, where the view
+// is at the tip of its log and has the capacity, otherwise into a new log with
+// room to grow.
+func (v logView[T]) appended(items ...T) logView[T] {
+	if len(items) == 0 {
+		return v
+	}
+	if v.log != nil {
+		v.log.mu.Lock()
+		if len(v.items) == v.log.used && cap(v.items)-len(v.items) >= len(items) {
+			v.items = append(v.items, items...)
+			v.log.used = len(v.items)
+			v.log.mu.Unlock()
+			return v
+		}
+		v.log.mu.Unlock()
+	}
+	grown := make([]T, len(v.items), max(2*len(v.items), len(v.items)+len(items), minLogSize))
+	copy(grown, v.items)
+	return logView[T]{items: append(grown, items...), log: &sharedLog{used: len(v.items) + len(items)}}
+}
+
+// reserved returns the view with the capacity to have n items appended in
+// place, copying it into a new log unless it is at the tip of its log with the
+// capacity already.
+func (v logView[T]) reserved(n int) logView[T] {
+	if n <= 0 {
+		return v
+	}
+	if v.log != nil {
+		v.log.mu.Lock()
+		tip := len(v.items) == v.log.used && cap(v.items)-len(v.items) >= n
+		v.log.mu.Unlock()
+		if tip {
+			return v
+		}
+	}
+	grown := make([]T, len(v.items), len(v.items)+n)
+	copy(grown, v.items)
+	return logView[T]{items: grown, log: &sharedLog{used: len(grown)}}
+}
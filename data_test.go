@@ -0,0 +1,111 @@
+package nescript_test
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/neaas/nescript"
+)
+
+func TestWithMergedEnv(t *testing.T) {
+	base := nescript.NewScript("true").WithEnv("A=1", "B=2")
+	merged := base.WithMergedEnv("B=3", "C=4")
+	if env := merged.Env(); !slices.Equal(env, []string{"A=1", "B=3", "C=4"}) {
+		t.Errorf("expected B replaced in place and C appended, got %v", env)
+	}
+	if env := base.Env(); !slices.Equal(env, []string{"A=1", "B=2"}) {
+		t.Errorf("expected the base env to be unchanged, got %v", env)
+	}
+	if env := base.WithEnv("D=5").Env(); !slices.Equal(env, []string{"A=1", "B=2", "D=5"}) {
+		t.Errorf("expected the base to be appended to as before, got %v", env)
+	}
+}
+
+func TestDerivedScriptsAreIsolated(t *testing.T) {
+	base := nescript.NewScript("echo {{ .name }}").WithField("name", "base").WithEnv("BASE=1")
+	const derived = 8
+	scripts := make([]nescript.Script, derived)
+	var wg sync.WaitGroup
+	for i := range derived {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s := base.WithField("name", fmt.Sprintf("derived %d", i))
+			for j := range 50 {
+				s = s.WithEnv(fmt.Sprintf("VAR%d_%d=1", i, j)).WithField(fmt.Sprintf("field%d", j), i)
+			}
+			s = s.WithMergedEnv("BASE=" + fmt.Sprint(i))
+			scripts[i] = s
+		}()
+	}
+	wg.Wait()
+	if env := base.Env(); !slices.Equal(env, []string{"BASE=1"}) {
+		t.Errorf("expected the base env unchanged, got %v", env)
+	}
+	if data := base.Data(); len(data) != 1 || data["name"] != "base" {
+		t.Errorf("expected the base data unchanged, got %v", data)
+	}
+	for i, s := range scripts {
+		env := s.Env()
+		if len(env) != 51 || env[0] != fmt.Sprintf("BASE=%d", i) {
+			t.Fatalf("expected script %d to have its own env, got %v", i, env)
+		}
+		for j, e := range env[1:] {
+			if e != fmt.Sprintf("VAR%d_%d=1", i, j) {
+				t.Fatalf("expected script %d to have only its own env vars, got %s", i, e)
+			}
+		}
+		data := s.Data()
+		if len(data) != 51 || data["name"] != fmt.Sprintf("derived %d", i) || data["field49"] != i {
+			t.Errorf("expected script %d to have its own data, got %v", i, data)
+		}
+	}
+}
+
+func TestDerivedScriptsConcurrentUse(t *testing.T) {
+	base := nescript.NewScript("true").Grow(0, 4).WithEnv("A=1")
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			want := []string{"A=1"}
+			s := base
+			for j := range 20 {
+				// reading the base while others append to it, and to the
+				// scripts derived from it, must not race.
+				if env := base.Env(); !slices.Equal(env, []string{"A=1"}) {
+					t.Errorf("expected the base env unchanged, got %v", env)
+					return
+				}
+				e := fmt.Sprintf("B%d=%d", i, j)
+				s, want = s.WithEnv(e), append(want, e)
+				if env := s.Env(); !slices.Equal(env, want) {
+					t.Errorf("expected %v, got %v", want, env)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestEnvIsACopy(t *testing.T) {
+	base := nescript.NewScript("true").WithEnv("A=1")
+	sibling := base.WithEnv("B=2")
+	env := base.WithEnv("C=3").Env()
+	env[0] = "A=changed"
+	if got := base.Env(); !slices.Equal(got, []string{"A=1"}) {
+		t.Errorf("expected the base env unchanged, got %v", got)
+	}
+	if got := sibling.Env(); !slices.Equal(got, []string{"A=1", "B=2"}) {
+		t.Errorf("expected the sibling env unchanged, got %v", got)
+	}
+	env = base.Cmd().Env()
+	env[0] = "A=changed"
+	if got := base.Cmd().Env(); !slices.Equal(got, []string{"A=1"}) {
+		t.Errorf("expected the cmd env unchanged, got %v", got)
+	}
+}
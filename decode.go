@@ -0,0 +1,132 @@
+package nescript
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// snippetLength is the most output quoted by a decode error.
+const snippetLength = 200
+
+// DecodeOption configures how a result's output is decoded.
+type DecodeOption func(*decodeOptions)
+
+type decodeOptions struct {
+	skipLeading bool
+	useErr      bool
+}
+
+func newDecodeOptions(opts []DecodeOption) *decodeOptions {
+	o := &decodeOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithSkipLeading skips the lines of output before the first starting with '{'
+// or '[', such as log lines written before the JSON. For JSONLines, every line
+// not starting with '{' or '[' is skipped.
+func WithSkipLeading() DecodeOption {
+	return func(o *decodeOptions) {
+		o.skipLeading = true
+	}
+}
+
+// WithStdErr decodes stderr, rather than stdout.
+func WithStdErr() DecodeOption {
+	return func(o *decodeOptions) {
+		o.useErr = true
+	}
+}
+
+// JSON decodes the script's stdout, with surrounding white space trimmed, as
+// JSON into the value v (as json.Unmarshal does). Failing to decode returns an
+// error wrapping ErrDecode, quoting the start of the output.
+func (r Result) JSON(v any, opts ...DecodeOption) error {
+	o := newDecodeOptions(opts)
+	output := strings.TrimSpace(r.stream(o.useErr))
+	if o.skipLeading {
+		output = skipLeading(output)
+	}
+	if err := json.Unmarshal([]byte(output), v); err != nil {
+		return fmt.Errorf("%w: json: %w: '%s'", ErrDecode, err, snippet(output))
+	}
+	return nil
+}
+
+// YAML decodes the script's stdout, with surrounding white space trimmed, as
+// YAML into the value v (as yaml.Unmarshal does). Failing to decode returns an
+// error wrapping ErrDecode, quoting the start of the output.
+func (r Result) YAML(v any, opts ...DecodeOption) error {
+	o := newDecodeOptions(opts)
+	output := strings.TrimSpace(r.stream(o.useErr))
+	if o.skipLeading {
+		output = skipLeading(output)
+	}
+	if err := yaml.Unmarshal([]byte(output), v); err != nil {
+		return fmt.Errorf("%w: yaml: %w: '%s'", ErrDecode, err, snippet(output))
+	}
+	return nil
+}
+
+// JSONLines calls the function with each line of the script's stdout, as
+// newline delimited JSON, in order. Blank lines are ignored. The function can
+// decode the line into a value of its own, with json.Unmarshal. If a line is
+// not valid JSON, an error wrapping ErrDecode is returned, naming and quoting
+// the line. An error returned by the function stops the lines being read, and
+// is returned.
+func (r Result) JSONLines(fn func(line json.RawMessage) error, opts ...DecodeOption) error {
+	o := newDecodeOptions(opts)
+	for i, line := range strings.Split(r.stream(o.useErr), "\n") {
+		line := []byte(strings.TrimSpace(line))
+		if len(line) == 0 || (o.skipLeading && !jsonStart(line)) {
+			continue
+		}
+		if !json.Valid(line) {
+			return fmt.Errorf("%w: json: invalid line %d: '%s'", ErrDecode, i+1, snippet(string(line)))
+		}
+		if err := fn(json.RawMessage(line)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// skipLeading removes the lines of the output before the first starting with
+// '{' or '['. If no line does, the output is returned as it is.
+func skipLeading(output string) string {
+	for i := 0; i < len(output); {
+		line := output[i:]
+		if end := strings.IndexByte(line, '\n'); end >= 0 {
+			line = line[:end+1]
+		}
+		if jsonStart([]byte(strings.TrimSpace(line))) {
+			return output[i:]
+		}
+		i += len(line)
+	}
+	return output
+}
+
+// jsonStart reports whether the line starts a JSON object or array.
+func jsonStart(line []byte) bool {
+	return len(line) > 0 && (line[0] == '{' || line[0] == '[')
+}
+
+// snippet returns the start of the output for quoting in an error, without
+// splitting a rune.
+func snippet(output string) string {
+	if len(output) <= snippetLength {
+		return output
+	}
+	end := snippetLength
+	for end > 0 && !utf8.RuneStart(output[end]) {
+		end--
+	}
+	return output[:end] + "..."
+}
@@ -0,0 +1,423 @@
+package nescript
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ExecOptions are the hints of a script definition for executing the script
+// (see LoadDefinition), which are given to the executor as suits it, such as
+// the work dir of local.Executor.
+type ExecOptions struct {
+	// Timeout is how long the script may run for, or 0 if it may run until it
+	// exits (or the cmd's context is done).
+	Timeout time.Duration
+
+	// WorkDir is the dir the script is executed in, or empty for that of the
+	// executor.
+	WorkDir string
+}
+
+// Definition is a script defined in YAML (see LoadDefinitions), along with the
+// hints for executing it.
+type Definition struct {
+	Name    string
+	Script  *Script
+	Options ExecOptions
+}
+
+// DefinitionOption configures how script definitions are loaded.
+type DefinitionOption func(*definitionOptions)
+
+type definitionOptions struct {
+	strict bool
+}
+
+// WithStrictDefinition rejects definitions with keys that are not known, such
+// as a misspelt "feilds", rather than ignoring them.
+func WithStrictDefinition() DefinitionOption {
+	return func(o *definitionOptions) {
+		o.strict = true
+	}
+}
+
+// LoadDefinition loads the script defined in the YAML file, along with the
+// hints for executing it. For example:
+//
+//	name: db-migrate
+//	raw: |
+//	  migrate -database "$DATABASE_URL" -path {{.path}} up
+//	interpreter: [bash, -c]
+//	fields:
+//	  path: /srv/migrations
+//	required: [path]
+//	env:
+//	  - TZ=UTC
+//	timeout: 5m
+//	workDir: /srv/app
+//
+// Instead of raw, the script can be read from a source: a file (relative to
+// the definition's dir), an http URL or a file in a git repository (cloned with
+// the git CLI), such as:
+//
+//	source:
+//	  git:
+//	    repo: https://github.com/example/runbooks.git
+//	    ref: main
+//	    path: db/migrate.sh
+//
+// The interpreter is the script's subcommand (sh -c by default, or none if
+// empty), fields its template data, required its required fields (see
+// Script.WithRequiredFields) and env its env vars. A definition that is not
+// valid returns a *DefinitionError citing where in the YAML it is invalid. A
+// file defining more than one script (as YAML documents) returns an error,
+// see LoadDefinitions.
+func LoadDefinition(path string, opts ...DefinitionOption) (*Script, ExecOptions, error) {
+	definitions, err := loadDefinitions(path, opts)
+	if err != nil {
+		return nil, ExecOptions{}, err
+	}
+	if len(definitions) != 1 {
+		return nil, ExecOptions{}, &DefinitionError{File: path, Err: fmt.Errorf("%d scripts are defined, rather than one", len(definitions))}
+	}
+	return definitions[0].Script, definitions[0].Options, nil
+}
+
+// LoadDefinitions loads the named set of scripts defined in the YAML file, as
+// one YAML document (separated by "---") per script (see LoadDefinition), each
+// of which must have a unique name. Paths within the YAML are cited by the
+// index of the document, such as "scripts[2].env[0]".
+func LoadDefinitions(path string, opts ...DefinitionOption) (map[string]Definition, error) {
+	definitions, err := loadDefinitions(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	set := make(map[string]Definition, len(definitions))
+	for _, definition := range definitions {
+		set[definition.Name] = definition
+	}
+	return set, nil
+}
+
+// loadDefinitions loads the scripts defined in the file, in order.
+func loadDefinitions(path string, opts []DefinitionOption) ([]Definition, error) {
+	o := &definitionOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read script definition: %w", err)
+	}
+	p := &definitionParser{file: path, dir: filepath.Dir(path), strict: o.strict}
+	var documents []*yaml.Node
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	for {
+		var document yaml.Node
+		if err := decoder.Decode(&document); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, &DefinitionError{File: path, Err: err}
+		}
+		if len(document.Content) > 0 {
+			documents = append(documents, &document)
+		}
+	}
+	if len(documents) == 0 {
+		return nil, &DefinitionError{File: path, Err: errors.New("no script is defined")}
+	}
+	definitions := make([]Definition, 0, len(documents))
+	names := make(map[string]bool, len(documents))
+	for i, document := range documents {
+		path := ""
+		if len(documents) > 1 {
+			path = fmt.Sprintf("scripts[%d]", i)
+		}
+		definition, err := p.definition(document.Content[0], path)
+		if err != nil {
+			return nil, err
+		}
+		if len(documents) > 1 {
+			if definition.Name == "" {
+				return nil, p.errorf(document.Content[0], yamlKey(path, "name"), "a name is required when more than one script is defined")
+			}
+			if names[definition.Name] {
+				return nil, p.errorf(document.Content[0], yamlKey(path, "name"), "duplicate script name '%s'", definition.Name)
+			}
+			names[definition.Name] = true
+		}
+		definitions = append(definitions, definition)
+	}
+	return definitions, nil
+}
+
+// definitionParser parses the YAML documents of a definition file.
+type definitionParser struct {
+	file   string
+	dir    string
+	strict bool
+}
+
+func (p *definitionParser) errorf(node *yaml.Node, path string, format string, args ...any) error {
+	return &DefinitionError{File: p.file, Path: path, Line: node.Line, Err: fmt.Errorf(format, args...)}
+}
+
+// yamlKey returns the path of the key within the mapping at the path.
+func yamlKey(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// yamlIndex returns the path of the index within the sequence at the path.
+func yamlIndex(path string, i int) string {
+	return fmt.Sprintf("%s[%d]", path, i)
+}
+
+// definition parses the definition of a script, the mapping at the path.
+func (p *definitionParser) definition(node *yaml.Node, path string) (Definition, error) {
+	var definition Definition
+	if node.Kind != yaml.MappingNode {
+		return definition, p.errorf(node, path, "a script definition must be a mapping")
+	}
+	var (
+		raw, source *yaml.Node
+		script      Script
+		apply       []func(Script) Script
+	)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		name, value := node.Content[i].Value, node.Content[i+1]
+		at := yamlKey(path, name)
+		switch name {
+		case "name":
+			s, err := p.string(value, at)
+			if err != nil {
+				return definition, err
+			}
+			definition.Name = s
+		case "raw":
+			raw = value
+		case "source":
+			source = value
+		case "interpreter":
+			interpreter, err := p.strings(value, at)
+			if err != nil {
+				return definition, err
+			}
+			apply = append(apply, func(s Script) Script {
+				if len(interpreter) == 0 {
+					return s.WithSubcommand(SCBinary)
+				}
+				return s.WithSubcommand(Subcommand(interpreter))
+			})
+		case "fields":
+			if value.Kind != yaml.MappingNode {
+				return definition, p.errorf(value, at, "fields must be a mapping")
+			}
+			fields := make(map[string]any)
+			if err := value.Decode(&fields); err != nil {
+				return definition, p.errorf(value, at, "%s", err)
+			}
+			apply = append(apply, func(s Script) Script {
+				return s.WithFields(fields, true)
+			})
+		case "required":
+			required, err := p.strings(value, at)
+			if err != nil {
+				return definition, err
+			}
+			apply = append(apply, func(s Script) Script {
+				return s.WithRequiredFields(required...)
+			})
+		case "env":
+			env, err := p.strings(value, at)
+			if err != nil {
+				return definition, err
+			}
+			for i, e := range env {
+				if k, _, ok := strings.Cut(e, "="); !ok || k == "" {
+					return definition, p.errorf(value.Content[i], yamlIndex(at, i), "env var '%s' must be in KEY=VALUE format", e)
+				}
+			}
+			apply = append(apply, func(s Script) Script {
+				return s.WithEnv(env...)
+			})
+		case "timeout":
+			s, err := p.string(value, at)
+			if err != nil {
+				return definition, err
+			}
+			timeout, err := time.ParseDuration(s)
+			if err != nil || timeout < 0 {
+				return definition, p.errorf(value, at, "timeout '%s' must be a duration such as 5m", s)
+			}
+			definition.Options.Timeout = timeout
+		case "workDir":
+			s, err := p.string(value, at)
+			if err != nil {
+				return definition, err
+			}
+			definition.Options.WorkDir = s
+		default:
+			if p.strict {
+				return definition, p.errorf(node.Content[i], at, "unknown key '%s'", name)
+			}
+		}
+	}
+	switch {
+	case raw != nil && source != nil:
+		return definition, p.errorf(source, yamlKey(path, "source"), "only one of raw and source can be given")
+	case raw != nil:
+		s, err := p.string(raw, yamlKey(path, "raw"))
+		if err != nil {
+			return definition, err
+		}
+		script = *NewScript(s)
+	case source != nil:
+		s, err := p.source(source, yamlKey(path, "source"))
+		if err != nil {
+			return definition, err
+		}
+		script = *s
+	default:
+		return definition, p.errorf(node, path, "either raw or source must be given")
+	}
+	for _, fn := range apply {
+		script = fn(script)
+	}
+	definition.Script = &script
+	return definition, nil
+}
+
+// source reads the script from the source, the mapping at the path.
+func (p *definitionParser) source(node *yaml.Node, path string) (*Script, error) {
+	if node.Kind != yaml.MappingNode || len(node.Content) != 2 {
+		return nil, p.errorf(node, path, "source must be one of file, http or git")
+	}
+	name, value := node.Content[0].Value, node.Content[1]
+	at := yamlKey(path, name)
+	switch name {
+	case "file":
+		file, err := p.string(value, at)
+		if err != nil {
+			return nil, err
+		}
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(p.dir, file)
+		}
+		script, err := NewScriptFromFile(file)
+		if err != nil {
+			return nil, p.errorf(value, at, "%s", err)
+		}
+		return script, nil
+	case "http":
+		link, err := p.string(value, at)
+		if err != nil {
+			return nil, err
+		}
+		script, err := NewScriptFromHTTP(link)
+		if err != nil {
+			return nil, p.errorf(value, at, "%s", err)
+		}
+		return script, nil
+	case "git":
+		return p.gitSource(value, at)
+	}
+	return nil, p.errorf(node.Content[0], at, "unknown source '%s', must be one of file, http or git", name)
+}
+
+// gitSource reads the script from a file in a git repository, the mapping of
+// the repo, ref and path at the path.
+func (p *definitionParser) gitSource(node *yaml.Node, path string) (*Script, error) {
+	if node.Kind != yaml.MappingNode {
+		return nil, p.errorf(node, path, "git source must be a mapping of repo, ref and path")
+	}
+	values := make(map[string]string, 3)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		name, value := node.Content[i].Value, node.Content[i+1]
+		switch name {
+		case "repo", "ref", "path":
+			s, err := p.string(value, yamlKey(path, name))
+			if err != nil {
+				return nil, err
+			}
+			values[name] = s
+		default:
+			if p.strict {
+				return nil, p.errorf(node.Content[i], yamlKey(path, name), "unknown key '%s'", name)
+			}
+		}
+	}
+	repo, ref, file := values["repo"], values["ref"], values["path"]
+	if repo == "" {
+		return nil, p.errorf(node, yamlKey(path, "repo"), "a repo is required")
+	}
+	if !filepath.IsLocal(filepath.FromSlash(file)) {
+		return nil, p.errorf(node, yamlKey(path, "path"), "path '%s' must be a file within the repo", file)
+	}
+	script, err := gitScript(repo, ref, file)
+	if err != nil {
+		return nil, p.errorf(node, path, "%s", err)
+	}
+	return script, nil
+}
+
+// gitScript reads the script from the file in a shallow clone of the ref (or
+// default branch) of the repo. The script's origin is <repo>@<ref>:<path>.
+func gitScript(repo, ref, file string) (*Script, error) {
+	dir, err := os.MkdirTemp("", "nescript-git-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dir to clone into: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	args := []string{"clone", "--quiet", "--depth", "1"}
+	if ref != "" {
+		args = append(args, "--branch", ref)
+	}
+	if output, err := exec.Command("git", append(args, "--", repo, dir)...).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("failed to clone '%s': %w: %s", repo, err, strings.TrimSpace(string(output)))
+	}
+	script, err := NewScriptFromFile(filepath.Join(dir, filepath.FromSlash(file)))
+	if err != nil {
+		return nil, err
+	}
+	script.origin = repo + ":" + file
+	if ref != "" {
+		script.origin = repo + "@" + ref + ":" + file
+	}
+	return script, nil
+}
+
+// string returns the string scalar at the path.
+func (p *definitionParser) string(node *yaml.Node, path string) (string, error) {
+	if node.Kind != yaml.ScalarNode || node.Tag == "!!null" {
+		return "", p.errorf(node, path, "must be a string")
+	}
+	return node.Value, nil
+}
+
+// strings returns the sequence of string scalars at the path.
+func (p *definitionParser) strings(node *yaml.Node, path string) ([]string, error) {
+	if node.Kind != yaml.SequenceNode {
+		return nil, p.errorf(node, path, "must be a list of strings")
+	}
+	values := make([]string, 0, len(node.Content))
+	for i, item := range node.Content {
+		s, err := p.string(item, yamlIndex(path, i))
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, s)
+	}
+	return values, nil
+}
@@ -0,0 +1,92 @@
+package nescript
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// describedLine is how many characters of the first line of the raw script are
+// described by Script.String.
+const describedLine = 40
+
+// String describes the script concisely, for log lines and error messages: the
+// first line of its raw script (truncated), the number of fields (template
+// data) and env vars, the keys of the env vars and the origin, if known. For
+// example:
+//
+//	script "apt-get install -y {{.package}}" (fields: 1, env: 2 [DEBIAN_FRONTEND TOKEN], origin: install.sh)
+//
+// Values are not described, other than within the raw script, from which the
+// values of secret fields and env vars (see WithSecretField and WithSecretEnv)
+// are redacted, including once compiled.
+func (s Script) String() string {
+	// secrets are redacted before truncating, which could otherwise cut them
+	// short of being redacted.
+	line, _, multiline := strings.Cut(strings.TrimSpace(s.redactSecrets(s.raw)), "\n")
+	line = strings.TrimSpace(line)
+	if runes := []rune(line); len(runes) > describedLine {
+		line, multiline = string(runes[:describedLine]), true
+	}
+	if multiline {
+		line += "..."
+	}
+	var keys []string
+	for _, e := range s.Env() {
+		key, _, _ := strings.Cut(e, "=")
+		keys = append(keys, key)
+	}
+	description := fmt.Sprintf("script %q (fields: %d, env: %d", line, len(s.Data()), len(keys))
+	if len(keys) > 0 {
+		slices.Sort(keys)
+		description += " [" + strings.Join(slices.Compact(keys), " ") + "]"
+	}
+	if s.origin != "" {
+		description += ", origin: " + s.redactSecrets(s.origin)
+	}
+	return description + ")"
+}
+
+// GoString describes the script as String does, so that formatting it with
+// %#v does not reveal its secrets.
+func (s Script) GoString() string {
+	return s.String()
+}
+
+// DebugString describes the script verbosely: its whole raw script, the
+// subcommand, the origin, the fields and env vars with their values, and the
+// required fields. The values of secret fields and env vars are redacted
+// (including from the raw script), however other values, which may still be
+// sensitive, are not, so this must be called explicitly where that is safe.
+func (s Script) DebugString() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "script:\n%s\n", s.redactSecrets(s.raw))
+	fmt.Fprintf(&b, "subcommand: %q\n", []string(s.subcommand))
+	if s.origin != "" {
+		fmt.Fprintf(&b, "origin: %s\n", s.redactSecrets(s.origin))
+	}
+	data := s.Data()
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		value := fmt.Sprintf("%#v", data[key])
+		if s.isSecretField(key) {
+			value = redacted
+		}
+		fmt.Fprintf(&b, "field %s: %s\n", key, s.redactSecrets(value))
+	}
+	for _, e := range s.Env() {
+		key, value, _ := strings.Cut(e, "=")
+		if s.isSecretEnv(e) {
+			value = redacted
+		}
+		fmt.Fprintf(&b, "env %s=%s\n", key, s.redactSecrets(value))
+	}
+	if len(s.required) > 0 {
+		fmt.Fprintf(&b, "required: %s\n", strings.Join(s.required, " "))
+	}
+	return b.String()
+}
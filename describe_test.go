@@ -0,0 +1,72 @@
+package nescript_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/neaas/nescript"
+)
+
+func TestScriptString(t *testing.T) {
+	fromFS, err := nescript.NewScriptFromFS(fstest.MapFS{"install.sh": {Data: []byte("apt-get install -y {{.package}}\n")}}, "install.sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name   string
+		script nescript.Script
+		want   string
+	}{
+		{"plain", *nescript.NewScript("echo hello"), `script "echo hello" (fields: 0, env: 0)`},
+		{"fields and env", nescript.NewScript("apt-get install -y {{.package}}").WithField("package", "curl").WithEnv("TOKEN=abc", "DEBIAN_FRONTEND=noninteractive"),
+			`script "apt-get install -y {{.package}}" (fields: 1, env: 2 [DEBIAN_FRONTEND TOKEN])`},
+		{"multiline", *nescript.NewScript("  set -e\n  make\n"), `script "set -e..." (fields: 0, env: 0)`},
+		{"truncated", *nescript.NewScript(strings.Repeat("é", 45)), fmt.Sprintf("script %q (fields: 0, env: 0)", strings.Repeat("é", 40)+"...")},
+		{"origin", fromFS.WithField("package", "curl"), `script "apt-get install -y {{.package}}" (fields: 1, env: 0, origin: install.sh)`},
+		{"secret env", nescript.NewScript("curl -u $PW x").WithSecretEnv("PW=" + planted), `script "curl -u $PW x" (fields: 0, env: 1 [PW])`},
+		{"compiled secret field", nescript.NewScript("curl -u {{.pw}} x").WithSecretField("pw", planted).MustCompile(), `script "curl -u [REDACTED] x" (fields: 0, env: 0)`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.script.String(); got != tc.want {
+				t.Errorf("expected %s, got %s", tc.want, got)
+			}
+			if got := fmt.Sprintf("%v", tc.script); got != tc.want {
+				t.Errorf("expected %%v to be %s, got %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestScriptStringSecretAtTruncation(t *testing.T) {
+	// the secret starts just before the line is truncated, so only its start is
+	// within the described line.
+	for i := 30; i <= 40; i++ {
+		raw := "echo " + strings.Repeat("x", i) + " {{.pw}}"
+		script := nescript.NewScript(raw).WithSecretField("pw", planted).MustCompile()
+		for _, output := range []string{script.String(), script.DebugString(), fmt.Errorf("failed to run %v", script).Error()} {
+			if strings.Contains(output, planted[:3]) {
+				t.Errorf("expected no part of the secret to appear, got: %s", output)
+			}
+		}
+	}
+}
+
+func TestScriptDebugString(t *testing.T) {
+	script := nescript.NewScript("curl -u {{.user}}:{{.pw}} x").
+		WithField("user", "admin").
+		WithSecretField("pw", planted).
+		WithEnv("STAGE=prod").
+		WithSecretEnv("TOKEN=" + planted).
+		WithRequiredFields("user")
+	debug := script.DebugString()
+	for _, part := range []string{"script:\ncurl -u {{.user}}:{{.pw}} x\n", `field user: "admin"`, "field pw: [REDACTED]", "env STAGE=prod", "env TOKEN=[REDACTED]", "required: user"} {
+		if !strings.Contains(debug, part) {
+			t.Errorf("expected the debug string to contain %q, got:\n%s", part, debug)
+		}
+	}
+	if strings.Contains(debug, planted) {
+		t.Errorf("expected the secret not to appear, got:\n%s", debug)
+	}
+}
@@ -0,0 +1,157 @@
+package docker
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/errdefs"
+	"github.com/neaas/nescript"
+)
+
+// artifactSpec is a path to copy out of the script container once it exits.
+type artifactSpec struct {
+	containerPath string
+	hostDest      string
+}
+
+// Artifact records the outcome of copying a path out of the script container
+// (see WithArtifact). Error is set (and the other fields may be incomplete) if
+// the path could not be copied, such as when it does not exist.
+type Artifact struct {
+	ContainerPath string   `json:"containerPath"`
+	HostDest      string   `json:"hostDest"`
+	Files         []string `json:"files,omitempty"`
+	Error         string   `json:"error,omitempty"`
+}
+
+// ArtifactsFrom returns the artifacts copied out of the container the result
+// was produced in. False is returned if the result was not produced by the
+// RunExecutor, or no artifacts were requested.
+func ArtifactsFrom(r *nescript.Result) ([]Artifact, bool) {
+	artifacts, ok := r.Metadata[MetadataArtifacts].([]Artifact)
+	return artifacts, ok
+}
+
+// copyArtifacts copies each of the requested paths out of the container,
+// recording (rather than returning) any failure.
+func copyArtifacts(ctx context.Context, client Client, containerID string, specs []artifactSpec) []Artifact {
+	artifacts := make([]Artifact, len(specs))
+	for i, spec := range specs {
+		artifacts[i] = Artifact{
+			ContainerPath: spec.containerPath,
+			HostDest:      spec.hostDest,
+		}
+		files, err := copyArtifact(ctx, client, containerID, spec)
+		artifacts[i].Files = files
+		if err != nil {
+			artifacts[i].Error = err.Error()
+		}
+	}
+	return artifacts
+}
+
+// copyArtifact copies a single path out of the container, extracting it into
+// the host destination directory.
+func copyArtifact(ctx context.Context, client Client, containerID string, spec artifactSpec) ([]string, error) {
+	reader, _, err := client.CopyFromContainer(ctx, containerID, spec.containerPath)
+	if errdefs.IsNotFound(err) {
+		return nil, fmt.Errorf("path '%s' does not exist in the container", spec.containerPath)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to copy '%s' from the container: %w", spec.containerPath, err)
+	}
+	defer reader.Close()
+	if err := os.MkdirAll(spec.hostDest, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create artifact destination: %w", err)
+	}
+	return untar(reader, spec.hostDest)
+}
+
+// untar extracts a tar stream into the destination directory, returning the
+// paths of the files written. Only the permission bits of the file modes are
+// preserved, ownership is not. Symlinks are only recreated if they point to a
+// path within the destination, and entries that would be written outside of it
+// are rejected.
+func untar(reader io.Reader, dest string) ([]string, error) {
+	root, err := filepath.Abs(dest)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]string, 0)
+	skipped := make([]string, 0)
+	archive := tar.NewReader(reader)
+	for {
+		header, err := archive.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return files, fmt.Errorf("failed to read artifact archive: %w", err)
+		}
+		path := filepath.Join(root, filepath.FromSlash(header.Name))
+		if !within(root, path) {
+			return files, fmt.Errorf("artifact archive entry '%s' is outside of the destination", header.Name)
+		}
+		mode := os.FileMode(header.Mode).Perm()
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, mode|0o700); err != nil {
+				return files, err
+			}
+		case tar.TypeReg:
+			if err := writeFile(path, mode, archive); err != nil {
+				return files, err
+			}
+			files = append(files, path)
+		case tar.TypeSymlink:
+			target := header.Linkname
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(filepath.Dir(path), target)
+			}
+			if filepath.IsAbs(header.Linkname) || !within(root, target) {
+				skipped = append(skipped, header.Name)
+				continue
+			}
+			os.Remove(path)
+			if err := os.Symlink(header.Linkname, path); err != nil {
+				return files, err
+			}
+			files = append(files, path)
+		default:
+			skipped = append(skipped, header.Name)
+		}
+	}
+	if len(skipped) > 0 {
+		return files, fmt.Errorf("skipped links and special files that can not be safely extracted: %s", strings.Join(skipped, ", "))
+	}
+	return files, nil
+}
+
+// writeFile writes a regular file from the archive.
+func writeFile(path string, mode os.FileMode, reader io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, reader); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Chmod(path, mode)
+}
+
+// within reports whether the path is the root or inside of it.
+func within(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
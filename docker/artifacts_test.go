@@ -0,0 +1,161 @@
+package docker_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/docker"
+)
+
+// archiveEntry is an entry of a tar stream served by serveArchives.
+type archiveEntry struct {
+	name     string
+	typeflag byte
+	mode     int64
+	body     string
+	linkname string
+}
+
+// serveArchives serves the archives of container paths, as copied by
+// CopyFromContainer, with paths not given not found.
+func serveArchives(t *testing.T, daemon *fakeDaemon, archives map[string][]archiveEntry) {
+	t.Helper()
+	daemon.HandleMatch(`^GET /containers/[^/]+/archive$`, func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Query().Get("path")
+		entries, ok := archives[path]
+		if !ok {
+			daemonError(w, http.StatusNotFound, "Could not find the file "+path+" in container")
+			return
+		}
+		buf := bytes.Buffer{}
+		archive := tar.NewWriter(&buf)
+		for _, entry := range entries {
+			header := &tar.Header{Name: entry.name, Typeflag: entry.typeflag, Mode: entry.mode, Linkname: entry.linkname, Size: int64(len(entry.body))}
+			if err := archive.WriteHeader(header); err != nil {
+				t.Error(err)
+			}
+			archive.Write([]byte(entry.body))
+		}
+		archive.Close()
+		stat, _ := json.Marshal(types.ContainerPathStat{Name: filepath.Base(path)})
+		w.Header().Set("X-Docker-Container-Path-Stat", base64.StdEncoding.EncodeToString(stat))
+		w.Header().Set("Content-Type", "application/x-tar")
+		w.Write(buf.Bytes())
+	})
+}
+
+func TestArtifacts(t *testing.T) {
+	daemon := newFakeDaemon(t)
+	daemon.Image("alpine:3")
+	daemon.Run()
+	serveArchives(t, daemon, map[string][]archiveEntry{
+		"/out/report.txt": {
+			{name: "report.txt", typeflag: tar.TypeReg, mode: 0o640, body: "passed"},
+		},
+		"/src/bin": {
+			{name: "bin/", typeflag: tar.TypeDir, mode: 0o755},
+			{name: "bin/tools/", typeflag: tar.TypeDir, mode: 0o755},
+			{name: "bin/tools/build", typeflag: tar.TypeReg, mode: 0o755, body: "#!/bin/sh"},
+			{name: "bin/latest", typeflag: tar.TypeSymlink, linkname: "tools/build"},
+			{name: "bin/passwd", typeflag: tar.TypeSymlink, linkname: "/etc/passwd"},
+			{name: "bin/escape", typeflag: tar.TypeSymlink, linkname: "../../../etc/passwd"},
+			{name: "bin/fifo", typeflag: tar.TypeFifo, mode: 0o644},
+		},
+		"/out/evil": {
+			{name: "../evil", typeflag: tar.TypeReg, mode: 0o644, body: "outside"},
+		},
+	})
+	dest := filepath.Join(t.TempDir(), "artifacts")
+	process, err := nescript.NewScript("exit 3").Cmd().Exec(docker.RunExecutor(nil, "alpine:3",
+		docker.WithHost(daemon.URL),
+		docker.WithArtifact("/out/report.txt", dest),
+		docker.WithArtifact("/src/bin", dest),
+		docker.WithArtifact("/out/missing", dest),
+		docker.WithArtifact("/out/evil", dest),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := process.Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ExitCode != 3 {
+		t.Errorf("expected the script's exit code kept, got %d", result.ExitCode)
+	}
+	artifacts, ok := docker.ArtifactsFrom(result)
+	if !ok || len(artifacts) != 4 {
+		t.Fatalf("expected the 4 artifacts recorded, got %v", artifacts)
+	}
+
+	t.Run("file", func(t *testing.T) {
+		artifact := artifacts[0]
+		path := filepath.Join(dest, "report.txt")
+		if artifact.Error != "" || !slices.Equal(artifact.Files, []string{path}) {
+			t.Errorf("expected the report copied, got %+v", artifact)
+		}
+		if content, err := os.ReadFile(path); err != nil || string(content) != "passed" {
+			t.Errorf("expected the report's content, got %q: %v", content, err)
+		}
+		if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o640 {
+			t.Errorf("expected the report's permissions kept, got %v: %v", info.Mode(), err)
+		}
+	})
+
+	t.Run("directory", func(t *testing.T) {
+		artifact := artifacts[1]
+		build := filepath.Join(dest, "bin", "tools", "build")
+		latest := filepath.Join(dest, "bin", "latest")
+		if !slices.Equal(artifact.Files, []string{build, latest}) {
+			t.Errorf("expected the file and the link within the destination copied, got %v", artifact.Files)
+		}
+		if info, err := os.Stat(build); err != nil || info.Mode().Perm() != 0o755 {
+			t.Errorf("expected the tool's permissions kept, got %v: %v", info.Mode(), err)
+		}
+		if target, err := os.Readlink(latest); err != nil || target != "tools/build" {
+			t.Errorf("expected the link to the tool recreated, got %q: %v", target, err)
+		}
+		if !strings.HasSuffix(artifact.Error, "bin/passwd, bin/escape, bin/fifo") {
+			t.Errorf("expected the links outside the destination and the fifo skipped, got %q", artifact.Error)
+		}
+		for _, name := range []string{"passwd", "escape", "fifo"} {
+			if _, err := os.Lstat(filepath.Join(dest, "bin", name)); !os.IsNotExist(err) {
+				t.Errorf("expected %s not extracted, got %v", name, err)
+			}
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		if artifact := artifacts[2]; artifact.Error != "path '/out/missing' does not exist in the container" || len(artifact.Files) > 0 {
+			t.Errorf("expected the missing path recorded, got %+v", artifact)
+		}
+	})
+
+	t.Run("outside of the destination", func(t *testing.T) {
+		if artifact := artifacts[3]; !strings.Contains(artifact.Error, "outside of the destination") {
+			t.Errorf("expected the entry rejected, got %+v", artifact)
+		}
+		if _, err := os.Stat(filepath.Join(filepath.Dir(dest), "evil")); !os.IsNotExist(err) {
+			t.Errorf("expected nothing written outside of the destination, got %v", err)
+		}
+	})
+
+	t.Run("before removal", func(t *testing.T) {
+		requests := daemon.Requests()
+		copied := slices.IndexFunc(requests, func(r string) bool { return strings.HasSuffix(r, "/archive") })
+		removed := slices.IndexFunc(requests, func(r string) bool { return strings.HasPrefix(r, "DELETE /containers/") })
+		if copied < 0 || removed < copied {
+			t.Errorf("expected the artifacts copied before the container was removed, got %v", requests)
+		}
+	})
+}
@@ -0,0 +1,200 @@
+package docker
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	docker "github.com/docker/docker/client"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Engine identifies the container engine serving the docker API.
+type Engine string
+
+const (
+	EngineDocker Engine = "docker"
+	EnginePodman Engine = "podman"
+)
+
+// Client is the part of the docker engine API used by the executors. It is
+// satisfied by the docker SDK's *client.Client, so an application's existing
+// client (with its own TLS, timeouts and so on) can be given to the executors,
+// or a fake given for testing. Executors never close a client given to them.
+type Client interface {
+	ClientVersion() string
+	DaemonHost() string
+	NegotiateAPIVersion(ctx context.Context)
+	ServerVersion(ctx context.Context) (types.Version, error)
+
+	ContainerAttach(ctx context.Context, container string, options container.AttachOptions) (types.HijackedResponse, error)
+	ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, platform *ocispec.Platform, containerName string) (container.CreateResponse, error)
+	ContainerInspect(ctx context.Context, container string) (types.ContainerJSON, error)
+	ContainerKill(ctx context.Context, container, signal string) error
+	ContainerList(ctx context.Context, options container.ListOptions) ([]types.Container, error)
+	ContainerRemove(ctx context.Context, container string, options container.RemoveOptions) error
+	ContainerStart(ctx context.Context, container string, options container.StartOptions) error
+	ContainerStatPath(ctx context.Context, container, path string) (types.ContainerPathStat, error)
+	ContainerStop(ctx context.Context, container string, options container.StopOptions) error
+	ContainerWait(ctx context.Context, container string, condition container.WaitCondition) (<-chan container.WaitResponse, <-chan error)
+	CopyFromContainer(ctx context.Context, container, srcPath string) (io.ReadCloser, types.ContainerPathStat, error)
+
+	ContainerExecAttach(ctx context.Context, execID string, config types.ExecStartCheck) (types.HijackedResponse, error)
+	ContainerExecCreate(ctx context.Context, container string, config types.ExecConfig) (types.IDResponse, error)
+	ContainerExecInspect(ctx context.Context, execID string) (types.ContainerExecInspect, error)
+
+	ImageInspectWithRaw(ctx context.Context, image string) (types.ImageInspect, []byte, error)
+	ImagePull(ctx context.Context, ref string, options image.PullOptions) (io.ReadCloser, error)
+
+	NetworkConnect(ctx context.Context, network, container string, config *network.EndpointSettings) error
+	NetworkInspect(ctx context.Context, network string, options types.NetworkInspectOptions) (types.NetworkResource, error)
+}
+
+// isNilClient reports whether no client was given, including a nil
+// *client.Client.
+func isNilClient(client Client) bool {
+	if client == nil {
+		return true
+	}
+	c, ok := client.(*docker.Client)
+	return ok && c == nil
+}
+
+// NewClient creates a docker client for the engine at the endpoint given by
+// WithHost. If no host is given, DOCKER_HOST is honored, then the docker CLI
+// context given by WithDockerContext (or otherwise selected with DOCKER_CONTEXT
+// or `docker context use`), then the default docker socket, the rootless podman socket
+// ($XDG_RUNTIME_DIR/podman/podman.sock) and the rootful podman socket are
+// tried in turn. Hosts may be unix://, tcp:// (optionally with TLS, see WithTLS)
+// or ssh:// (see WithSSHKey and WithSSHClientConfig), where the ssh form is
+// ssh://[user@]host[:port][/path/to/docker.sock]. API version negotiation is
+// always enabled, so that engines that only support older API versions (such
+// as podman) can be used.
+func NewClient(opts ...Option) (*docker.Client, error) {
+	return newOptions(opts).newClient()
+}
+
+func (o *options) newClient() (*docker.Client, error) {
+	clientOpts := []docker.Opt{
+		docker.FromEnv,
+		docker.WithAPIVersionNegotiation(),
+	}
+	host := o.host
+	if host == "" {
+		dockerContext, err := o.resolveContext()
+		if err != nil {
+			return nil, err
+		}
+		if dockerContext != nil {
+			host = dockerContext.host
+			if o.tlsCA == nil && o.tlsCert == nil && o.tlsKey == nil {
+				o.tlsCA, o.tlsCert, o.tlsKey = dockerContext.tlsCA, dockerContext.tlsCert, dockerContext.tlsKey
+			}
+			o.tlsSkipVerify = dockerContext.skipTLSVerify
+		}
+	}
+	if o.tlsCA != nil || o.tlsCert != nil || o.tlsKey != nil || o.tlsSkipVerify {
+		tlsConfig, err := o.tlsConfig()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrConnection, err)
+		}
+		clientOpts = append(clientOpts, docker.WithHTTPClient(&http.Client{
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		}))
+	}
+	if host == "" {
+		host = os.Getenv("DOCKER_HOST")
+	}
+	if host == "" {
+		host = discoverHost()
+	}
+	if strings.HasPrefix(host, "ssh://") {
+		dialer, err := newSSHDialer(host, o)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrConnection, err)
+		}
+		clientOpts = append(clientOpts,
+			docker.WithHost("http://docker.sock"),
+			docker.WithDialContext(dialer.DialContext),
+		)
+	} else if host != "" {
+		clientOpts = append(clientOpts, docker.WithHost(host))
+	}
+	client, err := docker.NewClientWithOpts(clientOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to create docker client: %w", ErrConnection, err)
+	}
+	return client, nil
+}
+
+// tlsConfig builds the client TLS config from the PEM material given by
+// WithTLS.
+func (o *options) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: o.tlsSkipVerify,
+	}
+	if o.tlsCA != nil {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(o.tlsCA) {
+			return nil, fmt.Errorf("failed to parse tls ca certificate")
+		}
+		config.RootCAs = pool
+	}
+	if o.tlsCert != nil || o.tlsKey != nil {
+		cert, err := tls.X509KeyPair(o.tlsCert, o.tlsKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse tls client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// discoverHost returns the endpoint of the first engine socket found on the
+// local machine, or an empty string if there are none.
+func discoverHost() string {
+	candidates := []string{"/var/run/docker.sock"}
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		candidates = append(candidates, filepath.Join(runtimeDir, "podman", "podman.sock"))
+	}
+	candidates = append(candidates, "/run/podman/podman.sock")
+	for _, candidate := range candidates {
+		if info, err := os.Stat(candidate); err == nil && info.Mode()&os.ModeSocket != 0 {
+			return "unix://" + candidate
+		}
+	}
+	return ""
+}
+
+// DetectEngine determines whether the docker API is being served by docker or
+// by podman's docker-compatible API.
+func DetectEngine(ctx context.Context, client Client) (Engine, error) {
+	version, err := client.ServerVersion(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get docker engine version: %w", err)
+	}
+	return engineFromVersion(version), nil
+}
+
+func engineFromVersion(version types.Version) Engine {
+	if strings.Contains(strings.ToLower(version.Platform.Name), "podman") {
+		return EnginePodman
+	}
+	for _, component := range version.Components {
+		if strings.Contains(strings.ToLower(component.Name), "podman") {
+			return EnginePodman
+		}
+	}
+	return EngineDocker
+}
@@ -0,0 +1,179 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/docker/docker/api/types/versions"
+	docker "github.com/docker/docker/client"
+	"github.com/neaas/nescript"
+)
+
+// Connection is a (lazily established) connection to a docker engine, from
+// which any number of executors can be created. The engine is connected to (and
+// the API version negotiated) the first time it is needed, after which the
+// connection is reused by every executor created from it. A failed attempt to
+// connect is not kept, so the engine is connected to again the next time it is
+// needed, such as once a daemon that was briefly down is back.
+type Connection struct {
+	opts  []Option
+	given Client
+
+	mu        sync.Mutex
+	connected bool
+	client    Client
+	owned     *docker.Client
+	engine    Engine
+	version   string
+	closed    bool
+
+	shells  shellCache
+	details detailsCache
+}
+
+// Connect creates a Connection to the docker engine described by the given
+// options (see NewClient). Options given here are also applied to every
+// executor created from the connection.
+func Connect(opts ...Option) *Connection {
+	return &Connection{opts: opts}
+}
+
+// connect creates a connection that uses the given client, if not nil.
+func connect(client Client, opts []Option) *Connection {
+	if isNilClient(client) {
+		client = nil
+	}
+	return &Connection{
+		opts:  opts,
+		given: client,
+	}
+}
+
+// Close closes the docker client created by the connection, if one was
+// created. A client given to the connection (see WithDockerClient) is owned by
+// the caller, so is never closed. Executors created from the connection can not
+// be used once it is closed, failing with an error wrapping ErrConnection, even
+// where the engine was already connected to. The connection of a package level
+// executor can be closed by getting it with WithConnectionRef.
+func (conn *Connection) Close() error {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.closed {
+		return nil
+	}
+	conn.closed = true
+	if conn.owned != nil {
+		return conn.owned.Close()
+	}
+	return nil
+}
+
+// Executor provides an ExecFunc that will start the script/cmd process in the
+// docker container with the given container ID. See the package level Executor.
+func (conn *Connection) Executor(containerID string, opts ...Option) nescript.ExecFunc {
+	return conn.execExecutor(containerID, conn.options(opts))
+}
+
+// RunExecutor provides an ExecFunc that will start the script/cmd process in a
+// new docker container created from the given image. See the package level
+// RunExecutor.
+func (conn *Connection) RunExecutor(image string, opts ...Option) nescript.ExecFunc {
+	return conn.runExecutor(image, conn.options(opts))
+}
+
+// APIVersion returns the docker API version negotiated with the engine,
+// connecting to the engine if this has not happened yet.
+func (conn *Connection) APIVersion() (string, error) {
+	if _, _, err := conn.get(); err != nil {
+		return "", err
+	}
+	return conn.apiVersion(), nil
+}
+
+// Engine returns the kind of engine serving the docker API, connecting to the
+// engine if this has not happened yet.
+func (conn *Connection) Engine() (Engine, error) {
+	_, engine, err := conn.get()
+	return engine, err
+}
+
+// options builds the options for an executor, from the connection's options
+// followed by the executor's own.
+func (conn *Connection) options(opts []Option) *options {
+	o := newOptions(append(append([]Option{}, conn.opts...), opts...))
+	if o.connection != nil {
+		*o.connection = conn
+	}
+	return o
+}
+
+func (conn *Connection) get() (Client, Engine, error) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.closed {
+		return nil, "", fmt.Errorf("%w: the connection has been closed", ErrConnection)
+	}
+	if conn.connected {
+		return conn.client, conn.engine, nil
+	}
+	client, owned, err := conn.dial()
+	if err != nil {
+		return nil, "", err
+	}
+	ctx := context.Background()
+	client.NegotiateAPIVersion(ctx)
+	version, err := client.ServerVersion(ctx)
+	if err == nil && version.MinAPIVersion != "" && versions.LessThan(client.ClientVersion(), version.MinAPIVersion) {
+		err = fmt.Errorf("%w: docker engine at '%s' requires at least API version %s, but this client only supports up to %s", ErrAPIVersion, client.DaemonHost(), version.MinAPIVersion, client.ClientVersion())
+	} else if err != nil {
+		err = fmt.Errorf("%w: failed to get docker engine version: %w", ErrConnection, err)
+	}
+	if err != nil {
+		if owned != nil {
+			owned.Close()
+		}
+		return nil, "", err
+	}
+	conn.client, conn.owned, conn.connected = client, owned, true
+	conn.engine = engineFromVersion(version)
+	conn.version = client.ClientVersion()
+	return conn.client, conn.engine, nil
+}
+
+// dial returns the client to connect with: that given to the connection, else
+// that given by the options (see WithDockerClient), else a new client owned by
+// the connection.
+func (conn *Connection) dial() (client Client, owned *docker.Client, err error) {
+	if conn.given != nil {
+		return conn.given, nil, nil
+	}
+	o := newOptions(conn.opts)
+	if !isNilClient(o.client) {
+		return o.client, nil, nil
+	}
+	if owned, err = o.newClient(); err != nil {
+		return nil, nil, err
+	}
+	return owned, owned, nil
+}
+
+// apiVersion returns the API version negotiated with the engine.
+func (conn *Connection) apiVersion() string {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	return conn.version
+}
+
+// getFor returns the connection's client for use by an executor, ensuring the
+// negotiated API version meets the minimum the executor requires.
+func (conn *Connection) getFor(o *options) (Client, Engine, error) {
+	client, engine, err := conn.get()
+	if err != nil {
+		return nil, "", err
+	}
+	if version := conn.apiVersion(); o.minAPIVersion != "" && versions.LessThan(version, o.minAPIVersion) {
+		return nil, "", fmt.Errorf("%w: docker engine at '%s' only supports API version %s, but at least %s is required, the engine may need upgrading", ErrAPIVersion, client.DaemonHost(), version, o.minAPIVersion)
+	}
+	return client, engine, nil
+}
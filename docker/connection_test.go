@@ -0,0 +1,74 @@
+package docker_test
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/docker"
+)
+
+func TestConnectionRetriesFailedConnect(t *testing.T) {
+	daemon := newFakeDaemon(t)
+	var down atomic.Bool
+	down.Store(true)
+	daemon.Handle("GET /version", func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			daemonError(w, http.StatusServiceUnavailable, "daemon is starting")
+			return
+		}
+		writeJSON(w, http.StatusOK, types.Version{APIVersion: apiVersion, MinAPIVersion: "1.24"})
+	})
+	conn := docker.Connect(docker.WithHost(daemon.URL))
+	defer conn.Close()
+	if _, err := conn.APIVersion(); !errors.Is(err, docker.ErrConnection) {
+		t.Fatalf("expected ErrConnection while the daemon is down, got %v", err)
+	}
+	down.Store(false)
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if version, err := conn.APIVersion(); err != nil || version != apiVersion {
+				t.Errorf("expected API version %s once the daemon is up, got '%s': %v", apiVersion, version, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := daemon.Count("GET /version"); n != 2 {
+		t.Errorf("expected the engine to be connected to once more then reused, got %d version requests", n)
+	}
+}
+
+func TestClosedConnectionIsNotUsed(t *testing.T) {
+	daemon := newFakeDaemon(t)
+	var conn *docker.Connection
+	executor := docker.Executor(nil, "abc", "", docker.WithHost(daemon.URL), docker.WithConnectionRef(&conn))
+	if conn == nil {
+		t.Fatal("expected the package level executor's connection to be set")
+	}
+	if _, err := conn.APIVersion(); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	requests := len(daemon.Requests())
+	if _, err := nescript.NewCmd("true").Exec(executor); !errors.Is(err, docker.ErrConnection) {
+		t.Errorf("expected executing once closed to fail with ErrConnection, got %v", err)
+	}
+	if _, err := conn.APIVersion(); !errors.Is(err, docker.ErrConnection) {
+		t.Errorf("expected the closed connection not to be used, got %v", err)
+	}
+	if n := len(daemon.Requests()); n != requests {
+		t.Errorf("expected no requests once closed, got %v", daemon.Requests()[requests:])
+	}
+	if err := conn.Close(); err != nil {
+		t.Errorf("expected closing again to do nothing, got %v", err)
+	}
+}
@@ -0,0 +1,160 @@
+package docker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// defaultContextName is the docker CLI context that uses DOCKER_HOST or the
+	// local engine, rather than stored metadata.
+	defaultContextName = "default"
+)
+
+// dockerContext is an endpoint stored by `docker context create`.
+type dockerContext struct {
+	name          string
+	host          string
+	skipTLSVerify bool
+	tlsCA         []byte
+	tlsCert       []byte
+	tlsKey        []byte
+}
+
+// contextMeta is the format of the docker CLI's context metadata files.
+type contextMeta struct {
+	Name      string `json:"Name"`
+	Endpoints map[string]struct {
+		Host          string `json:"Host"`
+		SkipTLSVerify bool   `json:"SkipTLSVerify"`
+	} `json:"Endpoints"`
+}
+
+// dockerConfigDir returns the docker CLI config directory, honoring
+// DOCKER_CONFIG.
+func dockerConfigDir() (string, error) {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return dir, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find docker config directory: %w", err)
+	}
+	return filepath.Join(home, ".docker"), nil
+}
+
+// currentContextName returns the context selected with DOCKER_CONTEXT or
+// `docker context use`, or the default context if none is selected.
+func currentContextName(configDir string) (string, error) {
+	if name := os.Getenv("DOCKER_CONTEXT"); name != "" {
+		return name, nil
+	}
+	configBytes, err := os.ReadFile(filepath.Join(configDir, "config.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return defaultContextName, nil
+	} else if err != nil {
+		return "", fmt.Errorf("failed to read docker config: %w", err)
+	}
+	config := struct {
+		CurrentContext string `json:"currentContext"`
+	}{}
+	if err := json.Unmarshal(configBytes, &config); err != nil {
+		return "", fmt.Errorf("failed to parse docker config: %w", err)
+	}
+	if config.CurrentContext == "" {
+		return defaultContextName, nil
+	}
+	return config.CurrentContext, nil
+}
+
+// loadContext reads the docker endpoint of the named context, including its TLS
+// material, from the docker CLI config directory.
+func loadContext(configDir, name string) (*dockerContext, error) {
+	digest := sha256.Sum256([]byte(name))
+	id := hex.EncodeToString(digest[:])
+	metaBytes, err := os.ReadFile(filepath.Join(configDir, "contexts", "meta", id, "meta.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		available, _ := contextNames(configDir)
+		return nil, fmt.Errorf("%w: docker context '%s' does not exist, available contexts are: %s", ErrConnection, name, strings.Join(available, ", "))
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read docker context '%s': %w", name, err)
+	}
+	meta := contextMeta{}
+	if err := json.Unmarshal(metaBytes, &meta); err != nil {
+		return nil, fmt.Errorf("failed to parse docker context '%s': %w", name, err)
+	}
+	endpoint, ok := meta.Endpoints["docker"]
+	if !ok {
+		return nil, fmt.Errorf("docker context '%s' has no docker endpoint", name)
+	}
+	c := &dockerContext{
+		name:          name,
+		host:          endpoint.Host,
+		skipTLSVerify: endpoint.SkipTLSVerify,
+	}
+	tlsDir := filepath.Join(configDir, "contexts", "tls", id, "docker")
+	for file, dest := range map[string]*[]byte{"ca.pem": &c.tlsCA, "cert.pem": &c.tlsCert, "key.pem": &c.tlsKey} {
+		if pem, err := os.ReadFile(filepath.Join(tlsDir, file)); err == nil {
+			*dest = pem
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read tls material of docker context '%s': %w", name, err)
+		}
+	}
+	return c, nil
+}
+
+// contextNames lists the names of the docker contexts available, including the
+// default context.
+func contextNames(configDir string) ([]string, error) {
+	names := []string{defaultContextName}
+	entries, err := os.ReadDir(filepath.Join(configDir, "contexts", "meta"))
+	if err != nil {
+		return names, err
+	}
+	for _, entry := range entries {
+		metaBytes, err := os.ReadFile(filepath.Join(configDir, "contexts", "meta", entry.Name(), "meta.json"))
+		if err != nil {
+			continue
+		}
+		meta := contextMeta{}
+		if json.Unmarshal(metaBytes, &meta) == nil && meta.Name != "" {
+			names = append(names, meta.Name)
+		}
+	}
+	sort.Strings(names[1:])
+	return names, nil
+}
+
+// resolveContext returns the docker context the client should connect with, or
+// nil if the default context (DOCKER_HOST or the local engine) should be used.
+// A context given by WithDockerContext or selected with the docker CLI must
+// exist, whereas if the docker CLI config can not be read, the default is used.
+func (o *options) resolveContext() (*dockerContext, error) {
+	configDir, err := dockerConfigDir()
+	if err != nil {
+		if o.dockerContext != "" {
+			return nil, err
+		}
+		return nil, nil
+	}
+	name := o.dockerContext
+	if name == "" {
+		if os.Getenv("DOCKER_HOST") != "" {
+			return nil, nil
+		}
+		if name, err = currentContextName(configDir); err != nil {
+			return nil, nil
+		}
+	}
+	if name == defaultContextName {
+		return nil, nil
+	}
+	return loadContext(configDir, name)
+}
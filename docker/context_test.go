@@ -0,0 +1,165 @@
+package docker_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/docker"
+	"golang.org/x/crypto/ssh"
+)
+
+// writeContext stores a context in the docker CLI config directory as
+// `docker context create` does, with the tls material given by file name.
+func writeContext(t *testing.T, configDir, name, host string, skipTLSVerify bool, tls map[string][]byte) {
+	t.Helper()
+	digest := sha256.Sum256([]byte(name))
+	id := hex.EncodeToString(digest[:])
+	meta, err := json.Marshal(map[string]any{
+		"Name":     name,
+		"Metadata": map[string]any{},
+		"Endpoints": map[string]any{
+			"docker": map[string]any{"Host": host, "SkipTLSVerify": skipTLSVerify},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metaDir := filepath.Join(configDir, "contexts", "meta", id)
+	if err := os.MkdirAll(metaDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(metaDir, "meta.json"), meta, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tlsDir := filepath.Join(configDir, "contexts", "tls", id, "docker")
+	for file, pem := range tls {
+		if err := os.MkdirAll(tlsDir, 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(tlsDir, file), pem, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// newContextsFixture returns a docker CLI config directory set as DOCKER_CONFIG
+// (with DOCKER_HOST and DOCKER_CONTEXT unset), holding the contexts "remote"
+// (serving the daemon over tcp), "secure" (over tls) and "builder" (over ssh),
+// with "remote" selected by `docker context use`.
+func newContextsFixture(t *testing.T, daemon *fakeDaemon) string {
+	t.Helper()
+	configDir := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", configDir)
+	t.Setenv("DOCKER_HOST", "")
+	t.Setenv("DOCKER_CONTEXT", "")
+	os.Unsetenv("DOCKER_HOST")
+	os.Unsetenv("DOCKER_CONTEXT")
+	writeContext(t, configDir, "remote", daemon.URL, false, nil)
+	tlsHost, ca := daemon.ListenTLS(t)
+	writeContext(t, configDir, "secure", tlsHost, false, map[string][]byte{"ca.pem": ca})
+	writeContext(t, configDir, "builder", "ssh://ops@build.example.com:2222/run/user/1000/docker.sock", false, nil)
+	// a directory without metadata, as left by a failed create, is skipped.
+	if err := os.MkdirAll(filepath.Join(configDir, "contexts", "meta", "partial"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(configDir, "config.json"), []byte(`{"auths": {}, "currentContext": "remote"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return configDir
+}
+
+func TestDockerContexts(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		context string
+		env     map[string]string
+	}{
+		{"current context", "", nil},
+		{"named", "remote", nil},
+		{"selected with DOCKER_CONTEXT", "", map[string]string{"DOCKER_CONTEXT": "secure"}},
+		{"tls", "secure", nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			daemon := newFakeDaemon(t)
+			newContextsFixture(t, daemon)
+			for key, value := range tc.env {
+				t.Setenv(key, value)
+			}
+			daemon.Image("alpine:3")
+			daemon.Run()
+			var opts []docker.Option
+			if tc.context != "" {
+				opts = append(opts, docker.WithDockerContext(tc.context))
+			}
+			process, err := nescript.NewScript("echo ran").Cmd().Exec(docker.RunExecutor(nil, "alpine:3", opts...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := process.Result()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.StdOut != "ran\n" {
+				t.Errorf("expected the script run on the context's engine, got %q", result.StdOut)
+			}
+		})
+	}
+}
+
+func TestDockerContextSSH(t *testing.T) {
+	daemon := newFakeDaemon(t)
+	newContextsFixture(t, daemon)
+	config := &ssh.ClientConfig{HostKeyCallback: ssh.InsecureIgnoreHostKey()}
+	client, err := docker.NewClient(docker.WithDockerContext("builder"), docker.WithSSHClientConfig(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if host := client.DaemonHost(); host != "http://docker.sock" {
+		t.Errorf("expected the engine dialed over ssh, got the host %q", host)
+	}
+}
+
+func TestDockerContextDefault(t *testing.T) {
+	daemon := newFakeDaemon(t)
+	newContextsFixture(t, daemon)
+	t.Setenv("DOCKER_HOST", "tcp://127.0.0.1:1")
+	for _, opts := range [][]docker.Option{nil, {docker.WithDockerContext("default")}} {
+		client, err := docker.NewClient(opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if host := client.DaemonHost(); host != "tcp://127.0.0.1:1" {
+			t.Errorf("expected DOCKER_HOST used over the current context, got %q", host)
+		}
+		client.Close()
+	}
+}
+
+func TestDockerContextNotFound(t *testing.T) {
+	daemon := newFakeDaemon(t)
+	newContextsFixture(t, daemon)
+	_, err := docker.NewClient(docker.WithDockerContext("staging"))
+	if !errors.Is(err, docker.ErrConnection) {
+		t.Fatalf("expected ErrConnection, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "'staging'") || !strings.HasSuffix(err.Error(), "available contexts are: default, builder, remote, secure") {
+		t.Errorf("expected the available contexts listed, got %v", err)
+	}
+}
+
+func TestDockerContextSelectedNotFound(t *testing.T) {
+	daemon := newFakeDaemon(t)
+	newContextsFixture(t, daemon)
+	t.Setenv("DOCKER_CONTEXT", "staging")
+	if _, err := docker.NewClient(); !errors.Is(err, docker.ErrConnection) {
+		t.Errorf("expected ErrConnection for the selected context, got %v", err)
+	}
+}
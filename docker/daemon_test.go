@@ -0,0 +1,640 @@
+package docker_test
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// apiVersion is the API version the fake daemon serves.
+const apiVersion = "1.45"
+
+// versionPrefix matches the API version prefix of request paths.
+var versionPrefix = regexp.MustCompile(`^/v[0-9.]+`)
+
+// fakeDaemon is an in-process docker engine API, serving the handlers set on
+// it by method and path (without the API version prefix), such as
+// "GET /containers/abc/json", so that executors can be tested without an
+// engine. The ping and version endpoints are served unless handlers are set
+// for them.
+type fakeDaemon struct {
+	// URL is the endpoint of the daemon, given with WithHost.
+	URL string
+
+	mu       sync.Mutex
+	handlers map[string]http.HandlerFunc
+	patterns []fakeRoute
+	requests []string
+	execs    []types.ExecConfig
+}
+
+// fakeRoute is a handler of requests whose method and path match the pattern.
+type fakeRoute struct {
+	pattern *regexp.Regexp
+	handler http.HandlerFunc
+}
+
+// newFakeDaemon starts a fake daemon, closed once the test completes.
+func newFakeDaemon(t testing.TB) *fakeDaemon {
+	t.Helper()
+	d := &fakeDaemon{handlers: make(map[string]http.HandlerFunc)}
+	d.Handle("HEAD /_ping", ping)
+	d.Handle("GET /_ping", ping)
+	d.Handle("GET /version", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, types.Version{Version: "26.1.3", APIVersion: apiVersion, MinAPIVersion: "1.24", Os: "linux", Arch: "amd64"})
+	})
+	server := httptest.NewServer(http.HandlerFunc(d.serve))
+	t.Cleanup(server.Close)
+	d.URL = "tcp://" + server.Listener.Addr().String()
+	return d
+}
+
+// Handle sets the handler of requests with the method and path, such as
+// "POST /containers/create".
+func (d *fakeDaemon) Handle(route string, handler http.HandlerFunc) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.handlers[route] = handler
+}
+
+// HandleMatch sets the handler of requests whose method and path match the
+// regular expression, such as `^POST /containers/[^/]+/start$`, used where no
+// handler was set with Handle.
+func (d *fakeDaemon) HandleMatch(pattern string, handler http.HandlerFunc) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.patterns = append(d.patterns, fakeRoute{regexp.MustCompile(pattern), handler})
+}
+
+// Requests returns the method and path of each request received, in order.
+func (d *fakeDaemon) Requests() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string{}, d.requests...)
+}
+
+// Count returns how many requests were received with the method and path.
+func (d *fakeDaemon) Count(route string) int {
+	n := 0
+	for _, request := range d.Requests() {
+		if request == route {
+			n++
+		}
+	}
+	return n
+}
+
+// ListenUnix also serves the daemon on a unix socket at the path, as an engine
+// found by its socket is, until the test completes.
+func (d *fakeDaemon) ListenUnix(t testing.TB, path string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(d.serve)}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+}
+
+// ListenTLS also serves the daemon over tls until the test completes,
+// returning its endpoint and the PEM of the CA its certificate is trusted with.
+func (d *fakeDaemon) ListenTLS(t testing.TB) (string, []byte) {
+	t.Helper()
+	server := httptest.NewTLSServer(http.HandlerFunc(d.serve))
+	t.Cleanup(server.Close)
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	return "tcp://" + server.Listener.Addr().String(), ca
+}
+
+func (d *fakeDaemon) serve(w http.ResponseWriter, r *http.Request) {
+	route := r.Method + " " + versionPrefix.ReplaceAllString(r.URL.Path, "")
+	d.mu.Lock()
+	d.requests = append(d.requests, route)
+	handler, ok := d.handlers[route]
+	if !ok {
+		for _, p := range d.patterns {
+			if p.pattern.MatchString(route) {
+				handler, ok = p.handler, true
+				break
+			}
+		}
+	}
+	d.mu.Unlock()
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "no fake handler for " + route})
+		return
+	}
+	handler(w, r)
+}
+
+func ping(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("API-Version", apiVersion)
+	w.Header().Set("OSType", "linux")
+	w.WriteHeader(http.StatusOK)
+}
+
+// writeJSON writes the value as the JSON body of the response.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// daemonError writes an error response, as the engine does.
+func daemonError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"message": message})
+}
+
+// fakeExec is an exec created in a container of the fake daemon.
+type fakeExec struct {
+	config   types.ExecConfig
+	done     chan struct{}
+	exitCode int
+}
+
+// Container serves a running linux container with the ID and env, whose execs
+// run their command as a local process, with the exec's env and work dir, so
+// that the exec executor can be tested end to end.
+func (d *fakeDaemon) Container(id string, env ...string) {
+	d.container(id, "linux", env)
+}
+
+// WindowsContainer serves a running windows container with the ID, whose execs
+// are recorded (see Execs) but fail to start, as their command can not be run
+// locally.
+func (d *fakeDaemon) WindowsContainer(id string) {
+	d.container(id, "windows", nil)
+}
+
+// Execs returns the config of each exec created, in order.
+func (d *fakeDaemon) Execs() []types.ExecConfig {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]types.ExecConfig{}, d.execs...)
+}
+
+func (d *fakeDaemon) container(id, platform string, env []string) {
+	var (
+		mu    sync.Mutex
+		execs = make(map[string]*fakeExec)
+	)
+	d.Handle("GET /containers/"+id+"/json", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, types.ContainerJSON{
+			ContainerJSONBase: &types.ContainerJSONBase{ID: id, Platform: platform, State: &types.ContainerState{Running: true, Status: "running"}},
+			Config:            &container.Config{Env: env},
+		})
+	})
+	d.Handle("POST /containers/"+id+"/exec", func(w http.ResponseWriter, r *http.Request) {
+		var config types.ExecConfig
+		if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+			daemonError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		d.mu.Lock()
+		d.execs = append(d.execs, config)
+		d.mu.Unlock()
+		mu.Lock()
+		execID := fmt.Sprintf("%s-exec-%d", id, len(execs))
+		execs[execID] = &fakeExec{config: config, done: make(chan struct{})}
+		mu.Unlock()
+		writeJSON(w, http.StatusCreated, types.IDResponse{ID: execID})
+	})
+	lookup := func(r *http.Request) *fakeExec {
+		mu.Lock()
+		defer mu.Unlock()
+		return execs[strings.Split(versionPrefix.ReplaceAllString(r.URL.Path, ""), "/")[2]]
+	}
+	d.HandleMatch(`^POST /exec/`+regexp.QuoteMeta(id)+`-exec-[0-9]+/start$`, func(w http.ResponseWriter, r *http.Request) {
+		e := lookup(r)
+		if e == nil {
+			daemonError(w, http.StatusNotFound, "no such exec")
+			return
+		}
+		// the body is read first, so only the stdin is left on the connection.
+		io.Copy(io.Discard, r.Body)
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		defer close(e.done)
+		rw.WriteString("HTTP/1.1 101 UPGRADED\r\nContent-Type: application/vnd.docker.raw-stream\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+		rw.Flush()
+		output := &lockedWriter{w: conn}
+		if platform != "linux" {
+			e.exitCode = 126
+			return
+		}
+		cmd := exec.Command(e.config.Cmd[0], e.config.Cmd[1:]...)
+		cmd.Env = append(append([]string{}, os.Environ()...), e.config.Env...)
+		cmd.Dir = e.config.WorkingDir
+		cmd.Stdout = stdcopy.NewStdWriter(output, stdcopy.Stdout)
+		cmd.Stderr = stdcopy.NewStdWriter(output, stdcopy.Stderr)
+		stdin, _ := cmd.StdinPipe()
+		if err := cmd.Start(); err != nil {
+			fmt.Fprintf(cmd.Stderr, "exec failed: %v", err)
+			e.exitCode = 126
+			return
+		}
+		go func() {
+			io.Copy(stdin, rw.Reader)
+			stdin.Close()
+		}()
+		err = cmd.Wait()
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			e.exitCode = exitErr.ExitCode()
+		}
+	})
+	d.HandleMatch(`^GET /exec/`+regexp.QuoteMeta(id)+`-exec-[0-9]+/json$`, func(w http.ResponseWriter, r *http.Request) {
+		e := lookup(r)
+		if e == nil {
+			daemonError(w, http.StatusNotFound, "no such exec")
+			return
+		}
+		select {
+		case <-e.done:
+			writeJSON(w, http.StatusOK, types.ContainerExecInspect{ExecID: r.URL.Path, ContainerID: id, ExitCode: e.exitCode})
+		default:
+			writeJSON(w, http.StatusOK, types.ContainerExecInspect{ExecID: r.URL.Path, ContainerID: id, Running: true})
+		}
+	})
+}
+
+// lockedWriter serializes the writes of stdout and stderr to the connection.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
+
+// Image serves an image with the ref and env, for the local platform.
+func (d *fakeDaemon) Image(ref string, env ...string) {
+	d.Handle("GET /images/"+ref+"/json", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, types.ImageInspect{
+			ID:           ref,
+			RepoDigests:  []string{ref + "@sha256:0123"},
+			Os:           "linux",
+			Architecture: "amd64",
+			Config:       &container.Config{Env: env},
+		})
+	})
+}
+
+// fakeContainer is a container created in the fake daemon, whose command is
+// run as a local process once it is started.
+type fakeContainer struct {
+	ID         string
+	Name       string
+	Platform   string
+	Config     container.Config
+	HostConfig container.HostConfig
+	Networking network.NetworkingConfig
+
+	conn     net.Conn
+	rw       *bufio.ReadWriter
+	cmd      *exec.Cmd
+	exited   chan struct{}
+	exitCode int
+	started  time.Time
+	finished time.Time
+	killed   bool
+	removed  bool
+}
+
+// fakeRuns is the state of the containers created in the fake daemon, served
+// by Run.
+type fakeRuns struct {
+	mu         sync.Mutex
+	containers []*fakeContainer
+
+	// Warnings are returned by each container create.
+	Warnings []string
+
+	// CreateFailures is the number of container creates that create the
+	// container, but then fail as if the connection was lost.
+	CreateFailures int
+
+	// KillError, if not empty, is returned by each container kill.
+	KillError string
+}
+
+// Containers returns the containers created, in order.
+func (f *fakeRuns) Containers() []*fakeContainer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*fakeContainer{}, f.containers...)
+}
+
+// Live returns the containers created that have not been removed.
+func (f *fakeRuns) Live() []*fakeContainer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var live []*fakeContainer
+	for _, c := range f.containers {
+		if !c.removed {
+			live = append(live, c)
+		}
+	}
+	return live
+}
+
+func (f *fakeRuns) lookup(r *http.Request) *fakeContainer {
+	id := strings.Split(versionPrefix.ReplaceAllString(r.URL.Path, ""), "/")[2]
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, c := range f.containers {
+		if (c.ID == id || c.Name == id) && !c.removed {
+			return c
+		}
+	}
+	return nil
+}
+
+// Run serves the container endpoints the run executor uses, so that it can be
+// tested end to end. Containers are listed, and inspected, by ID; their labels
+// are matched by the label filter.
+func (d *fakeDaemon) Run() *fakeRuns {
+	f := &fakeRuns{}
+	d.Handle("POST /containers/create", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			container.Config
+			HostConfig       container.HostConfig
+			NetworkingConfig network.NetworkingConfig
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			daemonError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		f.mu.Lock()
+		c := &fakeContainer{
+			ID:         fmt.Sprintf("container-%d", len(f.containers)),
+			Name:       r.URL.Query().Get("name"),
+			Platform:   r.URL.Query().Get("platform"),
+			Config:     body.Config,
+			HostConfig: body.HostConfig,
+			Networking: body.NetworkingConfig,
+			exited:     make(chan struct{}),
+		}
+		f.containers = append(f.containers, c)
+		fail := f.CreateFailures > 0
+		if fail {
+			f.CreateFailures--
+		}
+		warnings := f.Warnings
+		f.mu.Unlock()
+		if fail {
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		writeJSON(w, http.StatusCreated, container.CreateResponse{ID: c.ID, Warnings: warnings})
+	})
+	d.Handle("GET /containers/json", func(w http.ResponseWriter, r *http.Request) {
+		args, err := filters.FromJSON(r.URL.Query().Get("filters"))
+		if err != nil {
+			daemonError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		var list []types.Container
+		for _, c := range f.Live() {
+			if args.MatchKVList("label", c.Config.Labels) {
+				list = append(list, types.Container{ID: c.ID, Names: []string{"/" + c.Name}, Labels: c.Config.Labels})
+			}
+		}
+		writeJSON(w, http.StatusOK, list)
+	})
+	d.HandleMatch(`^GET /containers/[^/]+/json$`, func(w http.ResponseWriter, r *http.Request) {
+		c := f.lookup(r)
+		if c == nil {
+			daemonError(w, http.StatusNotFound, "No such container")
+			return
+		}
+		f.mu.Lock()
+		state := &types.ContainerState{Status: "created"}
+		if !c.started.IsZero() {
+			state.StartedAt = c.started.Format(time.RFC3339Nano)
+			select {
+			case <-c.exited:
+				state.Status = "exited"
+				state.ExitCode = c.exitCode
+				state.FinishedAt = c.finished.Format(time.RFC3339Nano)
+			default:
+				state.Running = true
+				state.Status = "running"
+			}
+		}
+		f.mu.Unlock()
+		writeJSON(w, http.StatusOK, types.ContainerJSON{
+			ContainerJSONBase: &types.ContainerJSONBase{ID: c.ID, Image: c.Config.Image, Platform: "linux", State: state},
+			Config:            &c.Config,
+		})
+	})
+	d.HandleMatch(`^HEAD /containers/[^/]+/archive$`, func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Query().Get("path")
+		info, err := os.Stat(path)
+		if f.lookup(r) == nil || err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		stat, _ := json.Marshal(types.ContainerPathStat{Name: info.Name(), Size: info.Size(), Mode: info.Mode()})
+		w.Header().Set("X-Docker-Container-Path-Stat", base64.StdEncoding.EncodeToString(stat))
+		w.WriteHeader(http.StatusOK)
+	})
+	d.HandleMatch(`^POST /containers/[^/]+/attach$`, func(w http.ResponseWriter, r *http.Request) {
+		c := f.lookup(r)
+		if c == nil {
+			daemonError(w, http.StatusNotFound, "No such container")
+			return
+		}
+		io.Copy(io.Discard, r.Body)
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		rw.WriteString("HTTP/1.1 101 UPGRADED\r\nContent-Type: application/vnd.docker.raw-stream\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+		rw.Flush()
+		f.mu.Lock()
+		c.conn, c.rw = conn, rw
+		f.mu.Unlock()
+	})
+	d.HandleMatch(`^POST /containers/[^/]+/wait$`, func(w http.ResponseWriter, r *http.Request) {
+		c := f.lookup(r)
+		if c == nil {
+			daemonError(w, http.StatusNotFound, "No such container")
+			return
+		}
+		// the status is written once the container exits, as the engine does.
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		select {
+		case <-c.exited:
+		case <-r.Context().Done():
+			return
+		}
+		f.mu.Lock()
+		exitCode := c.exitCode
+		f.mu.Unlock()
+		json.NewEncoder(w).Encode(container.WaitResponse{StatusCode: int64(exitCode)})
+	})
+	d.HandleMatch(`^POST /containers/[^/]+/start$`, func(w http.ResponseWriter, r *http.Request) {
+		c := f.lookup(r)
+		if c == nil {
+			daemonError(w, http.StatusNotFound, "No such container")
+			return
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		command := append(append([]string{}, c.Config.Entrypoint...), c.Config.Cmd...)
+		c.cmd = exec.Command(command[0], command[1:]...)
+		c.cmd.Env = append(append([]string{}, os.Environ()...), c.Config.Env...)
+		c.cmd.Dir = c.Config.WorkingDir
+		if c.conn != nil {
+			output := &lockedWriter{w: c.conn}
+			c.cmd.Stdout = stdcopy.NewStdWriter(output, stdcopy.Stdout)
+			c.cmd.Stderr = stdcopy.NewStdWriter(output, stdcopy.Stderr)
+		}
+		stdin, _ := c.cmd.StdinPipe()
+		if err := c.cmd.Start(); err != nil {
+			daemonError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		c.started = time.Now()
+		if c.rw != nil {
+			go func() {
+				io.Copy(stdin, c.rw.Reader)
+				stdin.Close()
+			}()
+		}
+		go func() {
+			err := c.cmd.Wait()
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				c.exitCode = exitErr.ExitCode()
+				if c.exitCode < 0 {
+					c.exitCode = 128 + int(exitErr.Sys().(syscall.WaitStatus).Signal())
+				}
+			}
+			c.finished = time.Now()
+			if c.conn != nil {
+				c.conn.Close()
+			}
+			close(c.exited)
+		}()
+		w.WriteHeader(http.StatusNoContent)
+	})
+	d.HandleMatch(`^POST /containers/[^/]+/kill$`, func(w http.ResponseWriter, r *http.Request) {
+		c := f.lookup(r)
+		if c == nil {
+			daemonError(w, http.StatusNotFound, "No such container")
+			return
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if f.KillError != "" {
+			daemonError(w, http.StatusInternalServerError, f.KillError)
+			return
+		}
+		if c.cmd != nil && c.cmd.Process != nil {
+			c.killed = true
+			c.cmd.Process.Kill()
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	d.HandleMatch(`^DELETE /containers/[^/]+$`, func(w http.ResponseWriter, r *http.Request) {
+		c := f.lookup(r)
+		if c == nil {
+			daemonError(w, http.StatusNotFound, "No such container")
+			return
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if c.cmd != nil && c.cmd.Process != nil {
+			c.cmd.Process.Kill()
+		} else {
+			// a container removed before it was started ends any wait on it.
+			if c.conn != nil {
+				c.conn.Close()
+			}
+			close(c.exited)
+		}
+		c.removed = true
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return f
+}
+
+// Network serves a network of the name, recording the containers connected to
+// it (after they were created) by their endpoint settings, keyed by container
+// ID.
+func (d *fakeDaemon) Network(name string) *fakeNetwork {
+	n := &fakeNetwork{connected: make(map[string]network.EndpointSettings)}
+	d.Handle("GET /networks/"+name, func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, types.NetworkResource{Name: name, ID: name})
+	})
+	d.Handle("POST /networks/"+name+"/connect", func(w http.ResponseWriter, r *http.Request) {
+		var body types.NetworkConnect
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			daemonError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		if body.EndpointConfig != nil {
+			n.connected[body.Container] = *body.EndpointConfig
+		} else {
+			n.connected[body.Container] = network.EndpointSettings{}
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	return n
+}
+
+// fakeNetwork is a network served by the fake daemon.
+type fakeNetwork struct {
+	mu        sync.Mutex
+	connected map[string]network.EndpointSettings
+}
+
+// Connected returns the endpoint settings of the containers connected to the
+// network, keyed by container ID.
+func (n *fakeNetwork) Connected() map[string]network.EndpointSettings {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return maps.Clone(n.connected)
+}
@@ -0,0 +1,144 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// EnvPolicy determines how the env vars of a script/cmd are combined with the
+// env vars already configured on the container (or image) it is executed in.
+type EnvPolicy int
+
+const (
+	// EnvOverlay reads the env configured on the container (or image) and
+	// merges the script's env over it, so that keys set by the script take
+	// precedence and all other keys (such as PATH) are preserved. This is the
+	// default.
+	EnvOverlay EnvPolicy = iota
+
+	// EnvReplace passes only the script's env to the process, discarding the env
+	// configured on the container (or image). As the docker engine always
+	// merges the container's env, this is done by invoking the script via
+	// `env -i`, so requires the env binary in the container.
+	EnvReplace
+)
+
+// mergeEnv merges the overlay env vars over the base env vars. Keys keep the
+// position they first appear at, with a later occurrence of a key replacing
+// the value of an earlier one, so the result is deterministic regardless of
+// duplicate keys.
+func mergeEnv(base, overlay []string) []string {
+	merged := make([]string, 0, len(base)+len(overlay))
+	positions := make(map[string]int, len(base)+len(overlay))
+	for _, e := range append(append([]string{}, base...), overlay...) {
+		key, _, _ := strings.Cut(e, "=")
+		if idx, ok := positions[key]; ok {
+			merged[idx] = e
+			continue
+		}
+		positions[key] = len(merged)
+		merged = append(merged, e)
+	}
+	return merged
+}
+
+// effectiveEnv determines the env the process should be given, and the command
+// it should run, for the given policy. The base env is the env configured on
+// the container or image, and is only used for the overlay policy. The env of
+// the script/cmd must be valid (see validateEnv), as under the replace policy
+// an entry that is not would be taken by `env` as the command, or an option.
+func effectiveEnv(policy EnvPolicy, base, env, raw []string) ([]string, []string, error) {
+	if err := validateEnv(env); err != nil {
+		return nil, nil, err
+	}
+	switch policy {
+	case EnvReplace:
+		env = mergeEnv(nil, env)
+		command := append([]string{"env", "-i"}, env...)
+		return env, append(command, raw...), nil
+	default:
+		return mergeEnv(base, env), raw, nil
+	}
+}
+
+// validateEnv ensures each env var is of the form KEY=value, with a key that is
+// not empty and does not start with "-", returning an ErrInvalidEnv if not.
+func validateEnv(env []string) error {
+	for _, e := range env {
+		if key, _, ok := strings.Cut(e, "="); !ok || key == "" || strings.HasPrefix(key, "-") {
+			return fmt.Errorf("%w: '%s' is not of the form KEY=value", ErrInvalidEnv, e)
+		}
+	}
+	return nil
+}
+
+// details are the parts of a container's (or image's) configuration that
+// affect how a script is executed in it.
+type details struct {
+	env      []string
+	os       string
+	platform string
+}
+
+// detailsCache holds the details of each container inspected, so that each is
+// only inspected once per connection. The details can not change during the
+// life of a container, as its env and platform are fixed when it is created.
+type detailsCache struct {
+	mu      sync.Mutex
+	details map[string]details
+}
+
+// containerDetails inspects (and caches the details of) an existing container.
+func (conn *Connection) containerDetails(ctx context.Context, client Client, containerID string) (details, error) {
+	conn.details.mu.Lock()
+	d, ok := conn.details.details[containerID]
+	conn.details.mu.Unlock()
+	if ok {
+		return d, nil
+	}
+	d, err := containerDetails(ctx, client, containerID)
+	if err != nil {
+		return details{}, err
+	}
+	conn.details.mu.Lock()
+	defer conn.details.mu.Unlock()
+	if conn.details.details == nil {
+		conn.details.details = make(map[string]details)
+	}
+	conn.details.details[containerID] = d
+	return d, nil
+}
+
+// containerDetails inspects an existing container.
+func containerDetails(ctx context.Context, client Client, containerID string) (details, error) {
+	inspect, err := client.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return details{}, fmt.Errorf("failed to inspect docker container '%s': %w", containerID, err)
+	}
+	d := details{}
+	if inspect.ContainerJSONBase != nil {
+		d.os = inspect.Platform
+	}
+	if inspect.Config != nil {
+		d.env = inspect.Config.Env
+	}
+	return d, nil
+}
+
+// imageDetails inspects an image.
+func imageDetails(ctx context.Context, client Client, ref string) (details, error) {
+	inspect, _, err := client.ImageInspectWithRaw(ctx, ref)
+	if err != nil {
+		return details{}, fmt.Errorf("failed to inspect docker image '%s': %w", ref, err)
+	}
+	d := details{
+		os:       inspect.Os,
+		platform: imagePlatform(inspect),
+	}
+	if inspect.Config != nil {
+		d.env = inspect.Config.Env
+	}
+	return d, nil
+}
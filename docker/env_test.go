@@ -0,0 +1,67 @@
+package docker_test
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/docker"
+)
+
+func TestEnvPolicy(t *testing.T) {
+	daemon := newFakeDaemon(t)
+	daemon.Container("app", "PATH=/usr/bin:/bin", "TZ=UTC")
+	for _, tc := range []struct {
+		name   string
+		policy docker.EnvPolicy
+		env    []string
+		want   []string
+		err    error
+	}{
+		{"overlay", docker.EnvOverlay, []string{"TZ=Europe/London", "A=1"}, []string{"PATH=/usr/bin:/bin", "TZ=Europe/London", "A=1"}, nil},
+		{"replace", docker.EnvReplace, []string{"A=1", "B=2", "A=3"}, []string{"A=3", "B=2"}, nil},
+		{"replace without a value", docker.EnvReplace, []string{"A=1", "touch"}, nil, docker.ErrInvalidEnv},
+		{"replace with an option", docker.EnvReplace, []string{"-u=PATH"}, nil, docker.ErrInvalidEnv},
+		{"overlay with an empty key", docker.EnvOverlay, []string{"=1"}, nil, docker.ErrInvalidEnv},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			executor := docker.Executor(nil, "app", "", docker.WithHost(daemon.URL), docker.WithEnvPolicy(tc.policy))
+			process, err := nescript.NewCmd("true").WithEnv(tc.env...).Exec(executor)
+			if tc.err != nil {
+				if !errors.Is(err, tc.err) {
+					t.Errorf("expected %v, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := process.Result()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if env, _ := docker.EnvFrom(result); !slices.Equal(env, tc.want) {
+				t.Errorf("expected the env %q, got %q", tc.want, env)
+			}
+		})
+	}
+}
+
+func TestExecutorInspectsContainerOnce(t *testing.T) {
+	daemon := newFakeDaemon(t)
+	daemon.Container("app")
+	executor := docker.Executor(nil, "app", "", docker.WithHost(daemon.URL))
+	for range 3 {
+		process, err := nescript.NewCmd("true").Exec(executor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := process.Result(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := daemon.Count("GET /containers/app/json"); n != 1 {
+		t.Errorf("expected the container inspected once, got %d", n)
+	}
+}
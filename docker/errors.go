@@ -0,0 +1,86 @@
+package docker
+
+import (
+	"errors"
+
+	"github.com/neaas/nescript"
+)
+
+var (
+	// ErrConnection is returned (wrapped) when the docker engine could not be
+	// connected to, as opposed to an execution failure once connected.
+	ErrConnection = nescript.NewError(nescript.ErrConnection, "failed to connect to docker engine")
+
+	// ErrAPIVersion is returned (wrapped) when the API version negotiated with
+	// the docker engine is not supported by the engine, or is older than the
+	// minimum required (see WithMinAPIVersion).
+	ErrAPIVersion = errors.New("unsupported docker API version")
+
+	// ErrContainerNotRunning is returned (wrapped) when the container a script
+	// should be executed in has stopped, such as while waiting for it to become
+	// healthy.
+	ErrContainerNotRunning = errors.New("docker container is not running")
+
+	// ErrNoHealthcheck is returned (wrapped) when waiting for a container to
+	// become healthy and a healthcheck is required, however the container does
+	// not define one.
+	ErrNoHealthcheck = errors.New("docker container has no healthcheck")
+
+	// ErrContainerNotFound is returned (wrapped) when no container matches the
+	// compose target or name prefix the script should be executed in.
+	ErrContainerNotFound = errors.New("docker container not found")
+
+	// ErrAmbiguousContainer is returned (wrapped) when more than one container
+	// matches the compose target or name prefix the script should be executed
+	// in.
+	ErrAmbiguousContainer = errors.New("more than one docker container matched")
+
+	// ErrNoShell is returned (wrapped) when shell detection is enabled, however
+	// none of the candidate shells exist in the container or image.
+	ErrNoShell = errors.New("no usable shell found")
+
+	// ErrUnsupportedOnWindows is returned (wrapped) when an option is given that
+	// is only supported by linux containers, however the target is a windows
+	// container.
+	ErrUnsupportedOnWindows = errors.New("option is not supported on windows containers")
+
+	// ErrSkipped is recorded in a FanOutError for the containers a cmd was not
+	// executed in, as an earlier container failed and fail-fast was requested.
+	ErrSkipped = errors.New("skipped after an earlier failure")
+
+	// ErrPlatformUnsupported is returned (wrapped) when a platform is requested
+	// (see WithPlatform) that the docker engine or image does not support.
+	ErrPlatformUnsupported = errors.New("platform not supported")
+
+	// ErrGPUUnavailable is returned (wrapped) when GPUs are requested for the
+	// script container (see WithGPUs), however the docker engine has no GPU
+	// runtime (such as the NVIDIA container toolkit) able to provide them.
+	ErrGPUUnavailable = errors.New("docker engine can not provide the requested gpus")
+
+	// ErrNetworkNotFound is returned (wrapped) when a network the script
+	// container should be attached to does not exist. This is checked before the
+	// container is created.
+	ErrNetworkNotFound = errors.New("docker network not found")
+
+	// ErrResourceLimitsRejected is returned (wrapped) when the docker engine
+	// refuses, or would silently not apply, the resource limits requested for
+	// the script container. For example, when the host kernel does not support
+	// pids limits. Warnings that do not stop the limits from applying (such as
+	// swap accounting being disabled on the host) are recorded on the result
+	// instead (see nescript.MetadataWarnings).
+	ErrResourceLimitsRejected = errors.New("docker engine rejected the container resource limits")
+
+	// ErrSecurityOptionsRejected is returned (wrapped) when the docker engine
+	// refuses to create the script container with the privileges, capabilities,
+	// devices or security options requested.
+	ErrSecurityOptionsRejected = errors.New("docker engine rejected the container security options")
+
+	// ErrStdinConflict is returned (wrapped) when the stdin reader (see
+	// WithStdin) was already consumed by another execution.
+	ErrStdinConflict = errors.New("docker stdin conflict")
+
+	// ErrInvalidEnv is returned (wrapped) when an env var of the script/cmd is
+	// not of the form KEY=value, with a key that is not empty and does not
+	// start with "-".
+	ErrInvalidEnv = errors.New("invalid env var")
+)
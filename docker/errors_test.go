@@ -0,0 +1,59 @@
+package docker_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/docker"
+)
+
+func TestErrors(t *testing.T) {
+	daemon := newFakeDaemon(t)
+	daemon.Container("app", "PATH=/usr/bin:/bin")
+	daemon.Handle("GET /containers/missing/json", func(w http.ResponseWriter, _ *http.Request) {
+		daemonError(w, http.StatusNotFound, "No such container: missing")
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.Close()
+	for _, tc := range []struct {
+		name      string
+		host      string
+		container string
+		cancel    time.Duration
+		is        []error
+		class     string
+	}{
+		{"refused", "tcp://" + listener.Addr().String(), "app", 0, []error{docker.ErrConnection, nescript.ErrConnection}, nescript.ErrorClassConnection},
+		{"missing container", daemon.URL, "missing", 0, nil, nescript.ErrorClassOther},
+		{"cancelled", daemon.URL, "app", 200 * time.Millisecond, []error{nescript.ErrCancelled, context.Canceled}, nescript.ErrorClassCancelled},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tc.cancel > 0 {
+				time.AfterFunc(tc.cancel, cancel)
+			}
+			executor := docker.Executor(nil, tc.container, "", docker.WithHost(tc.host))
+			process, err := nescript.NewScript("sleep 1").Cmd().WithContext(ctx).Exec(executor)
+			if err == nil {
+				_, err = process.Result()
+			}
+			for _, target := range tc.is {
+				if !errors.Is(err, target) {
+					t.Errorf("expected %v to match %v", err, target)
+				}
+			}
+			if class := nescript.ErrorClass(err); class != tc.class {
+				t.Errorf("expected the class %s, got %s", tc.class, class)
+			}
+		})
+	}
+}
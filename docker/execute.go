@@ -3,53 +3,143 @@ package docker
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/docker/docker/api/types"
-	docker "github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/neaas/nescript"
 )
 
 // Executor provides an ExecFunc that will start the script/cmd process in the
-// docker container with the given container ID. An initialized docker client
-// must also be passed for communication with the relevant docker engine.
-// Optionally, a WorkDir may be set, setting the precess working directory (path
-// should be in the context of the container's file system). This ExecFunc does
-// not require that the cmd/script be converted to a string, so is Formatter
-// agnostic.
-func Executor(client *docker.Client, containerID, workdir string) nescript.ExecFunc {
+// docker container with the given container ID. A docker client (any Client,
+// such as the SDK's *client.Client) may be passed for communication with the
+// relevant docker engine, which the executor does not close. If nil, one is
+// created the first time the ExecFunc is used (see NewClient) and reused by
+// every execution after, until its Connection (see WithConnectionRef) is
+// closed. Both docker and podman engines are supported. The container may
+// instead be found by its compose service (see WithComposeTarget), in which
+// case the ID can be left empty. Optionally, a WorkDir may be set, setting the
+// process working directory (path should be in the context of the container's
+// file system). If the cmd context is cancelled before the script exits, the
+// exec is detached from (closing its stdin) and the result is the context's
+// error (matching nescript.ErrCancelled or nescript.ErrTimeout), as docker can
+// not kill an exec; a script that does not exit once its stdin is closed keeps
+// running in the container. This ExecFunc does not require that the cmd/script
+// be converted to a string, so is Formatter agnostic.
+func Executor(client Client, containerID, workdir string, opts ...Option) nescript.ExecFunc {
+	opts = append([]Option{WithWorkDir(workdir)}, opts...)
+	return connect(client, opts).Executor(containerID)
+}
+
+func (conn *Connection) execExecutor(containerID string, o *options) nescript.ExecFunc {
 	return func(c nescript.Cmd) (nescript.Process, error) {
+		client, _, err := conn.getFor(o)
+		if err != nil {
+			return nil, err
+		}
+		if err := o.claimStdin(); err != nil {
+			return nil, err
+		}
+		containerID := containerID
+		if o.target != nil {
+			if containerID, err = o.target.resolve(context.Background(), client); err != nil {
+				return nil, err
+			}
+		}
+		if o.waitHealthy > 0 {
+			if err := waitHealthy(context.Background(), client, containerID, o.waitHealthy, o.requireHealthcheck); err != nil {
+				return nil, err
+			}
+		}
+		target, err := conn.containerDetails(context.Background(), client, containerID)
+		if err != nil {
+			return nil, err
+		}
+		if err := o.validateFor(target.os); err != nil {
+			return nil, err
+		}
+		raw, err := adaptShell(c, o, target.os, func() (nescript.Subcommand, error) {
+			return conn.containerShell(context.Background(), client, containerID, o.shells)
+		})
+		if err != nil {
+			return nil, err
+		}
+		env, command, err := effectiveEnv(o.envPolicy, target.env, c.Env(), raw)
+		if err != nil {
+			return nil, err
+		}
 		config := types.ExecConfig{
 			Tty:          false,
 			AttachStdin:  true,
 			AttachStderr: true,
 			AttachStdout: true,
-			Env:          c.Env(),
-			WorkingDir:   workdir,
-			Cmd:          c.Raw(),
+			Env:          env,
+			WorkingDir:   o.workdir,
+			Cmd:          command,
 		}
-		idResponse, err := client.ContainerExecCreate(context.Background(), containerID, config)
+		if o.envPolicy == EnvReplace {
+			config.Env = nil
+		}
+		var idResponse types.IDResponse
+		err = o.retry.do(context.Background(), "create exec", func() (err error) {
+			idResponse, err = client.ContainerExecCreate(context.Background(), containerID, config)
+			return err
+		})
 		if err != nil {
 			return nil, fmt.Errorf("failed to create docker exec in container '%s': %w", containerID, err)
 		}
 		process := DockerProcess{
 			dockerClient: client,
 			commandID:    idResponse.ID,
+			env:          env,
+			windows:      target.os == osWindows,
 			complete:     make(chan error),
 		}
-		if conn, err := client.ContainerExecAttach(context.Background(), process.commandID, types.ExecStartCheck{}); err != nil {
-			return nil, fmt.Errorf("failed to attach to docker exec: %w", err)
+		// attaching to the exec also starts it, a separate start request is
+		// rejected by podman (and is redundant on docker).
+		var hijacked types.HijackedResponse
+		if err := o.retry.do(context.Background(), "start exec", func() (err error) {
+			hijacked, err = client.ContainerExecAttach(context.Background(), process.commandID, types.ExecStartCheck{})
+			if err != nil && execStarted(client, process.commandID) {
+				return noRetry{err}
+			}
+			return err
+		}); err != nil {
+			return nil, fmt.Errorf("failed to start docker exec: %w", err)
 		} else {
-			process.dockerConn = &conn
+			process.dockerConn = &hijacked
+			if o.stdin != nil {
+				process.stdin = pipeStdin(&hijacked, o.stdin)
+			}
+			process.started = time.Now()
+			process.tee = nescript.NewTee(c, &process.stdoutBytes, &process.stderrBytes)
 			go func() {
-				_, err := stdcopy.StdCopy(&process.stdoutBytes, &process.stderrBytes, conn.Reader)
+				_, err := stdcopy.StdCopy(process.tee.Stdout(), process.tee.Stderr(), hijacked.Reader)
+				process.ended = time.Now()
+				process.tee.Finish(nil)
 				process.complete <- err
 			}()
 		}
-		if err := client.ContainerExecStart(context.Background(), process.commandID, types.ExecStartCheck{}); err != nil {
-			return nil, fmt.Errorf("failed to start docker exec: %w", err)
+		process.completion = nescript.NewCompletion(process.collect)
+		process.completion.Start()
+		if done := c.Context().Done(); done != nil {
+			go func() {
+				select {
+				case <-done:
+					process.detach(nescript.ContextError(c.Context().Err()))
+				case <-process.completion.Done():
+				}
+			}()
 		}
 		return &process, nil
 
 	}
 }
+
+// execStarted reports whether the exec has (or may have) started, in which
+// case starting it must not be retried. If this can not be determined, the
+// exec is assumed to have started.
+func execStarted(client Client, execID string) bool {
+	inspect, err := client.ContainerExecInspect(context.Background(), execID)
+	return err != nil || inspect.Running || inspect.Pid != 0
+}
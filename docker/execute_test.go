@@ -0,0 +1,25 @@
+package docker_test
+
+import (
+	"testing"
+
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/docker"
+)
+
+func TestExecutor(t *testing.T) {
+	daemon := newFakeDaemon(t)
+	daemon.Container("app", "PATH=/usr/bin:/bin", "TZ=UTC")
+	executor := docker.Executor(nil, "app", "", docker.WithHost(daemon.URL))
+	process, err := nescript.NewScript("echo $TZ $GREETING; echo oops >&2; exit 3").Cmd().WithEnv("GREETING=hi").Exec(executor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := process.Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.StdOut != "UTC hi\n" || result.StdErr != "oops\n" || result.ExitCode != 3 {
+		t.Errorf("expected the exec's output and exit code, got %q, %q, %d", result.StdOut, result.StdErr, result.ExitCode)
+	}
+}
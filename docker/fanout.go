@@ -0,0 +1,208 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/neaas/nescript"
+)
+
+// Selector selects the containers a cmd is fanned out to (see ExecAll). A
+// container must match every label and, if any are given, at least one of the
+// name patterns.
+type Selector struct {
+	// Labels are label filters, either "key" or "key=value".
+	Labels []string
+
+	// Names are container name patterns, in the syntax of path.Match, such as
+	// "worker-*".
+	Names []string
+
+	// All includes stopped containers. By default, only running containers are
+	// selected.
+	All bool
+}
+
+// FanOutOrder is the order in which the selected containers are executed in.
+type FanOutOrder int
+
+const (
+	// OrderByName executes in the containers in order of their names. This is the
+	// default.
+	OrderByName FanOutOrder = iota
+
+	// OrderByCreated executes in the oldest containers first.
+	OrderByCreated
+)
+
+// FanOutError is returned by ExecAll when the cmd could not be executed in, or
+// its result collected from, one or more of the containers. Errors is keyed by
+// container ID.
+type FanOutError struct {
+	Errors map[string]error
+}
+
+func (e *FanOutError) Error() string {
+	ids := make([]string, 0, len(e.Errors))
+	for id := range e.Errors {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	messages := make([]string, len(ids))
+	for i, id := range ids {
+		messages[i] = fmt.Sprintf("container '%s': %s", shortID(id), e.Errors[id])
+	}
+	return fmt.Sprintf("failed in %d container(s): %s", len(ids), strings.Join(messages, "; "))
+}
+
+func (e *FanOutError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// ExecAll executes the cmd in every container matching the selector, waiting
+// for each to complete. The results are returned keyed by container ID. By
+// default, the cmd is executed in one container at a time; see WithConcurrency.
+// A failure in one container does not stop the others unless WithFailFast is
+// given. If any failed, a *FanOutError is also returned, alongside the results
+// that were collected. A docker client may be passed, or if nil, one is created
+// (see NewClient). The options are also applied to the executor used for each
+// container.
+func ExecAll(client Client, selector Selector, c nescript.Cmd, opts ...Option) (map[string]*nescript.Result, error) {
+	return connect(client, opts).ExecAll(selector, c)
+}
+
+// ExecAll executes the cmd in every container matching the selector. See the
+// package level ExecAll.
+func (conn *Connection) ExecAll(selector Selector, c nescript.Cmd, opts ...Option) (map[string]*nescript.Result, error) {
+	o := conn.options(opts)
+	client, _, err := conn.getFor(o)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(c.Context())
+	defer cancel()
+	containers, err := selector.list(ctx, client, o.fanOutOrder)
+	if err != nil {
+		return nil, err
+	}
+	concurrency := o.concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		results = make(map[string]*nescript.Result)
+		errs    = make(map[string]error)
+		slots   = make(chan struct{}, concurrency)
+	)
+	for _, ctr := range containers {
+		slots <- struct{}{}
+		if ctx.Err() != nil {
+			<-slots
+			mu.Lock()
+			errs[ctr.ID] = ErrSkipped
+			mu.Unlock()
+			continue
+		}
+		wg.Add(1)
+		go func(containerID string) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			result, err := execResult(conn.execExecutor(containerID, o), c.WithContext(ctx))
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs[containerID] = err
+				if o.failFast {
+					cancel()
+				}
+				return
+			}
+			results[containerID] = result
+		}(ctr.ID)
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return results, &FanOutError{Errors: errs}
+	}
+	return results, nil
+}
+
+// execResult executes the cmd and waits for its result.
+func execResult(executor nescript.ExecFunc, c nescript.Cmd) (*nescript.Result, error) {
+	process, err := c.Exec(executor)
+	if err != nil {
+		return nil, err
+	}
+	defer process.Close()
+	return process.Result()
+}
+
+// list finds the containers matching the selector, in the given order.
+func (s Selector) list(ctx context.Context, client Client, order FanOutOrder) ([]types.Container, error) {
+	args := filters.NewArgs()
+	for _, label := range s.Labels {
+		args.Add("label", label)
+	}
+	containers, err := client.ContainerList(ctx, container.ListOptions{All: s.All, Filters: args})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list docker containers: %w", err)
+	}
+	matches := make([]types.Container, 0, len(containers))
+	for _, c := range containers {
+		if ok, err := s.matchName(c); err != nil {
+			return nil, err
+		} else if ok {
+			matches = append(matches, c)
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if order == OrderByCreated && matches[i].Created != matches[j].Created {
+			return matches[i].Created < matches[j].Created
+		}
+		return containerName(matches[i]) < containerName(matches[j])
+	})
+	return matches, nil
+}
+
+// matchName reports whether any of the container's names match any of the
+// selector's name patterns. Every container matches if there are no patterns.
+func (s Selector) matchName(c types.Container) (bool, error) {
+	if len(s.Names) == 0 {
+		return true, nil
+	}
+	for _, pattern := range s.Names {
+		for _, name := range c.Names {
+			ok, err := path.Match(pattern, strings.TrimPrefix(name, "/"))
+			if err != nil {
+				return false, fmt.Errorf("invalid container name pattern '%s': %w", pattern, err)
+			}
+			if ok {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// containerName returns the primary name of the container.
+func containerName(c types.Container) string {
+	if len(c.Names) == 0 {
+		return c.ID
+	}
+	return strings.TrimPrefix(c.Names[0], "/")
+}
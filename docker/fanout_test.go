@@ -0,0 +1,48 @@
+package docker_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/docker"
+)
+
+func TestExecAllFailFast(t *testing.T) {
+	daemon := newFakeDaemon(t)
+	daemon.Container("running")
+	daemon.Handle("GET /containers/json", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, []types.Container{
+			{ID: "running", Names: []string{"/a-running"}},
+			{ID: "failing", Names: []string{"/b-failing"}},
+			{ID: "skipped", Names: []string{"/c-skipped"}},
+		})
+	})
+	// the script waits on its stdin, so only ends once detached from.
+	cmd := nescript.NewCmd("cat")
+	start := time.Now()
+	results, err := docker.ExecAll(nil, docker.Selector{}, *cmd, docker.WithHost(daemon.URL), docker.WithConcurrency(2), docker.WithFailFast(true))
+	if time.Since(start) > 10*time.Second {
+		t.Errorf("expected the running execution cancelled, took %s", time.Since(start))
+	}
+	var fanOutErr *docker.FanOutError
+	if !errors.As(err, &fanOutErr) {
+		t.Fatalf("expected a *FanOutError, got %v", err)
+	}
+	if len(results) > 0 {
+		t.Errorf("expected no results, got %v", results)
+	}
+	if err := fanOutErr.Errors["running"]; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the running execution cancelled, got %v", err)
+	}
+	if err := fanOutErr.Errors["failing"]; err == nil || errors.Is(err, context.Canceled) {
+		t.Errorf("expected the failing container's own error, got %v", err)
+	}
+	if err := fanOutErr.Errors["skipped"]; !errors.Is(err, docker.ErrSkipped) {
+		t.Errorf("expected the container not yet started skipped, got %v", err)
+	}
+}
@@ -0,0 +1,65 @@
+package docker
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+const (
+	gpuDriver     = "nvidia"
+	gpuCapability = "gpu"
+)
+
+// parseGPUs translates a GPU spec, in the same form as `docker run --gpus`,
+// into a device request. The spec may be "all", a number of GPUs (such as "2"
+// or "count=2"), or the IDs/UUIDs of specific devices (such as "device=0,1").
+func parseGPUs(spec string) (container.DeviceRequest, error) {
+	request := container.DeviceRequest{
+		Driver:       gpuDriver,
+		Capabilities: [][]string{{gpuCapability}},
+	}
+	spec = strings.TrimSpace(spec)
+	key, value, hasValue := strings.Cut(spec, "=")
+	switch {
+	case spec == "all":
+		request.Count = -1
+	case !hasValue:
+		count, err := strconv.Atoi(spec)
+		if err != nil || count <= 0 {
+			return container.DeviceRequest{}, fmt.Errorf("invalid gpu spec '%s': must be 'all', a number of gpus, or 'device=<ids>'", spec)
+		}
+		request.Count = count
+	case key == "count":
+		if value == "all" {
+			request.Count = -1
+			break
+		}
+		count, err := strconv.Atoi(value)
+		if err != nil || count <= 0 {
+			return container.DeviceRequest{}, fmt.Errorf("invalid gpu count '%s': must be 'all' or a positive number", value)
+		}
+		request.Count = count
+	case key == "device":
+		for _, id := range strings.Split(strings.Trim(value, `"'`), ",") {
+			if id = strings.TrimSpace(id); id == "" {
+				return container.DeviceRequest{}, fmt.Errorf("invalid gpu spec '%s': empty device id", spec)
+			}
+			request.DeviceIDs = append(request.DeviceIDs, id)
+		}
+	default:
+		return container.DeviceRequest{}, fmt.Errorf("invalid gpu spec '%s': unknown option '%s'", spec, key)
+	}
+	return request, nil
+}
+
+// gpuUnavailable reports whether an error from the engine was caused by it
+// having no runtime able to provide the requested GPUs.
+func gpuUnavailable(err error) bool {
+	message := strings.ToLower(err.Error())
+	return strings.Contains(message, "could not select device driver") ||
+		strings.Contains(message, "nvidia-container-cli") ||
+		strings.Contains(message, "unknown or invalid runtime name: nvidia")
+}
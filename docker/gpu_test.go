@@ -0,0 +1,123 @@
+package docker_test
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/docker"
+)
+
+func TestGPUs(t *testing.T) {
+	for _, tc := range []struct {
+		spec    string
+		request container.DeviceRequest
+	}{
+		{"all", container.DeviceRequest{Count: -1}},
+		{" all ", container.DeviceRequest{Count: -1}},
+		{"2", container.DeviceRequest{Count: 2}},
+		{"count=2", container.DeviceRequest{Count: 2}},
+		{"count=all", container.DeviceRequest{Count: -1}},
+		{"device=0", container.DeviceRequest{DeviceIDs: []string{"0"}}},
+		{"device=0,1", container.DeviceRequest{DeviceIDs: []string{"0", "1"}}},
+		{`device="0, 1"`, container.DeviceRequest{DeviceIDs: []string{"0", "1"}}},
+		{"device=GPU-3a23c669-1f69-c64e-cf85-44e9b07e7a2a", container.DeviceRequest{DeviceIDs: []string{"GPU-3a23c669-1f69-c64e-cf85-44e9b07e7a2a"}}},
+	} {
+		t.Run(tc.spec, func(t *testing.T) {
+			daemon := newFakeDaemon(t)
+			daemon.Image("alpine:3")
+			runs := daemon.Run()
+			process, err := nescript.NewCmd("true").Exec(docker.RunExecutor(nil, "alpine:3", docker.WithHost(daemon.URL), docker.WithGPUs(tc.spec)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := process.Result(); err != nil {
+				t.Fatal(err)
+			}
+			expected := tc.request
+			expected.Driver = "nvidia"
+			expected.Capabilities = [][]string{{"gpu"}}
+			requests := runs.Containers()[0].HostConfig.DeviceRequests
+			if len(requests) != 1 || !reflect.DeepEqual(requests[0], expected) {
+				t.Errorf("expected the device request %+v, got %+v", expected, requests)
+			}
+		})
+	}
+}
+
+func TestGPUsInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		spec    string
+		message string
+	}{
+		{"0", "must be 'all', a number of gpus, or 'device=<ids>'"},
+		{"-1", "must be 'all', a number of gpus, or 'device=<ids>'"},
+		{"two", "must be 'all', a number of gpus, or 'device=<ids>'"},
+		{"all,capabilities=utility", "unknown option 'all,capabilities'"},
+		{"count=0", "invalid gpu count '0'"},
+		{"count=many", "invalid gpu count 'many'"},
+		{"device=", "empty device id"},
+		{"device=0,,1", "empty device id"},
+		{"driver=nvidia", "unknown option 'driver'"},
+	} {
+		t.Run(tc.spec, func(t *testing.T) {
+			daemon := newFakeDaemon(t)
+			daemon.Image("alpine:3")
+			daemon.Run()
+			_, err := nescript.NewCmd("true").Exec(docker.RunExecutor(nil, "alpine:3", docker.WithHost(daemon.URL), docker.WithGPUs(tc.spec)))
+			if err == nil || !strings.Contains(err.Error(), tc.message) {
+				t.Errorf("expected the spec rejected with %q, got %v", tc.message, err)
+			}
+			if n := daemon.Count("POST /containers/create"); n != 0 {
+				t.Errorf("expected no container created, got %d", n)
+			}
+			if _, err := docker.Plan("alpine:3", *nescript.NewCmd("true"), docker.WithGPUs(tc.spec)); err == nil || !strings.Contains(err.Error(), tc.message) {
+				t.Errorf("expected the plan rejected with %q, got %v", tc.message, err)
+			}
+		})
+	}
+}
+
+func TestGPUUnavailable(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		route string
+		err   string
+	}{
+		{"no runtime at create", "POST /containers/create", "unknown or invalid runtime name: nvidia"},
+		{"no driver at start", "POST /containers/container-0/start", `could not select device driver "nvidia" with capabilities: [[gpu]]`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			daemon := newFakeDaemon(t)
+			daemon.Image("alpine:3")
+			runs := daemon.Run()
+			daemon.Handle(tc.route, func(w http.ResponseWriter, _ *http.Request) {
+				daemonError(w, http.StatusBadRequest, tc.err)
+			})
+			_, err := nescript.NewCmd("true").Exec(docker.RunExecutor(nil, "alpine:3", docker.WithHost(daemon.URL), docker.WithGPUs("all")))
+			if !errors.Is(err, docker.ErrGPUUnavailable) {
+				t.Fatalf("expected ErrGPUUnavailable, got %v", err)
+			}
+			if !strings.Contains(err.Error(), "gpus 'all'") {
+				t.Errorf("expected the spec in the error, got %v", err)
+			}
+			if live := runs.Live(); len(live) > 0 {
+				t.Errorf("expected the container removed, got %d left", len(live))
+			}
+		})
+	}
+}
+
+func TestGPUsPlan(t *testing.T) {
+	plan, err := docker.Plan("alpine:3", *nescript.NewCmd("true"), docker.WithGPUs("device=0,1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.GPUs != "device=0,1" || !strings.Contains(plan.String(), "\ngpus: device=0,1") {
+		t.Errorf("expected the gpus in the plan, got %q:\n%s", plan.GPUs, plan)
+	}
+}
@@ -0,0 +1,83 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+const (
+	healthPollInterval = 500 * time.Millisecond
+)
+
+// HealthTimeoutError is returned when a container did not become healthy
+// within the time given by WithWaitHealthy. It includes the last observed
+// health status and health check output to help diagnose why.
+type HealthTimeoutError struct {
+	ContainerID string
+	Timeout     time.Duration
+	Status      string
+	LastLog     *types.HealthcheckResult
+}
+
+func (e *HealthTimeoutError) Error() string {
+	msg := fmt.Sprintf("container '%s' was not healthy after %s (status '%s')", e.ContainerID, e.Timeout, e.Status)
+	if e.LastLog != nil {
+		msg = fmt.Sprintf("%s, last health check exited %d: %s", msg, e.LastLog.ExitCode, strings.TrimSpace(e.LastLog.Output))
+	}
+	return msg
+}
+
+// waitHealthy polls the container until it is healthy. If the container has no
+// healthcheck, it is considered healthy once running, unless a healthcheck is
+// required. If the container stops running while waiting, this returns
+// immediately with an error wrapping ErrContainerNotRunning.
+func waitHealthy(ctx context.Context, client Client, containerID string, timeout time.Duration, requireHealthcheck bool) error {
+	deadline := time.Now().Add(timeout)
+	status := "unknown"
+	var lastLog *types.HealthcheckResult
+	for {
+		inspect, err := client.ContainerInspect(ctx, containerID)
+		if err != nil {
+			return fmt.Errorf("failed to inspect docker container '%s': %w", containerID, err)
+		}
+		if state := inspect.State; state != nil {
+			status = state.Status
+			if state.Status == "exited" || state.Status == "dead" || state.Status == "removing" {
+				return fmt.Errorf("container '%s' is %s: %w", containerID, state.Status, ErrContainerNotRunning)
+			}
+			if state.Health == nil || state.Health.Status == types.NoHealthcheck {
+				if requireHealthcheck {
+					return fmt.Errorf("container '%s' has no healthcheck defined: %w", containerID, ErrNoHealthcheck)
+				}
+				if state.Running {
+					return nil
+				}
+			} else {
+				status = state.Health.Status
+				if len(state.Health.Log) > 0 {
+					lastLog = state.Health.Log[len(state.Health.Log)-1]
+				}
+				if state.Health.Status == types.Healthy {
+					return nil
+				}
+			}
+		}
+		if time.Now().After(deadline) {
+			return &HealthTimeoutError{
+				ContainerID: containerID,
+				Timeout:     timeout,
+				Status:      status,
+				LastLog:     lastLog,
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(healthPollInterval):
+		}
+	}
+}
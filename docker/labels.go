@@ -0,0 +1,100 @@
+package docker
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/neaas/nescript"
+)
+
+const (
+	// LabelManaged marks a container as having been created by nescript.
+	LabelManaged = "nescript.managed"
+
+	// LabelRunID holds the unique ID of the execution a container was created
+	// for. This is also recorded on the result (see RunIDFrom).
+	LabelRunID = "nescript.run-id"
+
+	// LabelScriptHash holds the sha256 of the cmd/script a container was
+	// created to run.
+	LabelScriptHash = "nescript.script-hash"
+)
+
+// newRunID generates a random (version 4) UUID to identify an execution.
+func newRunID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("failed to generate run id: %w", err)
+	}
+	id[6] = (id[6] & 0x0f) | 0x40
+	id[8] = (id[8] & 0x3f) | 0x80
+	h := hex.EncodeToString(id)
+	return fmt.Sprintf("%s-%s-%s-%s-%s", h[0:8], h[8:12], h[12:16], h[16:20], h[20:32]), nil
+}
+
+// cmdHash returns the sha256 of the raw command the container will run.
+func cmdHash(c nescript.Cmd) string {
+	sum := sha256.Sum256([]byte(strings.Join(c.Raw(), "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// trackingLabels returns the labels applied to every container created by the
+// RunExecutor.
+func trackingLabels(runID string, c nescript.Cmd) map[string]string {
+	return map[string]string{
+		LabelManaged:    "true",
+		LabelRunID:      runID,
+		LabelScriptHash: cmdHash(c),
+	}
+}
+
+// Cleanup removes containers created by the RunExecutor that are older than
+// the given duration, such as those left behind when an application crashed
+// mid-execution. Only stopped containers are removed, unless force is set, in
+// which case running containers are killed and removed too. Containers not
+// carrying the nescript.managed label are never touched. The IDs of the
+// removed containers are returned, along with any errors removing others.
+func Cleanup(ctx context.Context, client Client, olderThan time.Duration, force bool) ([]string, error) {
+	containers, err := client.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", LabelManaged+"=true")),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list docker containers: %w", err)
+	}
+	cutoff := time.Now().Add(-olderThan)
+	removed := make([]string, 0)
+	var errs []error
+	for _, c := range containers {
+		if c.Labels[LabelManaged] != "true" || time.Unix(c.Created, 0).After(cutoff) {
+			continue
+		}
+		if c.State == "running" && !force {
+			continue
+		}
+		if err := client.ContainerRemove(ctx, c.ID, container.RemoveOptions{Force: force}); err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove container '%s': %w", shortID(c.ID), err))
+			continue
+		}
+		removed = append(removed, c.ID)
+	}
+	return removed, errors.Join(errs...)
+}
+
+// Cleanup removes containers created by the RunExecutor that are older than
+// the given duration. See the package level Cleanup.
+func (conn *Connection) Cleanup(ctx context.Context, olderThan time.Duration, force bool) ([]string, error) {
+	client, _, err := conn.get()
+	if err != nil {
+		return nil, err
+	}
+	return Cleanup(ctx, client, olderThan, force)
+}
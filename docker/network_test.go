@@ -0,0 +1,72 @@
+package docker_test
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/docker"
+)
+
+func TestRunExecutorNetworks(t *testing.T) {
+	daemon := newFakeDaemon(t)
+	daemon.Image("alpine:3")
+	runs := daemon.Run()
+	daemon.Network("backend")
+	frontend := daemon.Network("frontend")
+	opts := []docker.Option{
+		docker.WithHost(daemon.URL),
+		docker.WithNetwork("backend", "worker", "job"),
+		docker.WithNetwork("frontend", "web"),
+		docker.WithExtraHosts("db:10.0.0.5"),
+		docker.WithDNS("10.0.0.2"),
+		docker.WithDNSSearch("internal"),
+		docker.WithDNSOptions("ndots:2"),
+	}
+	process, err := nescript.NewCmd("true").Exec(docker.RunExecutor(nil, "alpine:3", opts...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := process.Result(); err != nil {
+		t.Fatal(err)
+	}
+	c := runs.Containers()[0]
+	if c.HostConfig.NetworkMode != "backend" {
+		t.Errorf("expected the first network as the primary network, got %q", c.HostConfig.NetworkMode)
+	}
+	if endpoint := c.Networking.EndpointsConfig["backend"]; endpoint == nil || !slices.Equal(endpoint.Aliases, []string{"worker", "job"}) || len(c.Networking.EndpointsConfig) != 1 {
+		t.Errorf("expected only the primary network's aliases set on create, got %v", c.Networking.EndpointsConfig)
+	}
+	if endpoint, ok := frontend.Connected()[c.ID]; !ok || !slices.Equal(endpoint.Aliases, []string{"web"}) {
+		t.Errorf("expected the container connected to the second network with its aliases, got %v", frontend.Connected())
+	}
+	for _, setting := range []struct {
+		name      string
+		got, want []string
+	}{
+		{"extra hosts", c.HostConfig.ExtraHosts, []string{"db:10.0.0.5"}},
+		{"dns servers", c.HostConfig.DNS, []string{"10.0.0.2"}},
+		{"dns search domains", c.HostConfig.DNSSearch, []string{"internal"}},
+		{"dns options", c.HostConfig.DNSOptions, []string{"ndots:2"}},
+	} {
+		if !slices.Equal(setting.got, setting.want) {
+			t.Errorf("expected the %s %v, got %v", setting.name, setting.want, setting.got)
+		}
+	}
+}
+
+func TestRunExecutorNetworkNotFound(t *testing.T) {
+	daemon := newFakeDaemon(t)
+	daemon.Image("alpine:3")
+	runs := daemon.Run()
+	daemon.Network("backend")
+	opts := []docker.Option{docker.WithHost(daemon.URL), docker.WithNetwork("backend"), docker.WithNetwork("missing")}
+	_, err := nescript.NewCmd("true").Exec(docker.RunExecutor(nil, "alpine:3", opts...))
+	if !errors.Is(err, docker.ErrNetworkNotFound) {
+		t.Fatalf("expected ErrNetworkNotFound, got %v", err)
+	}
+	if containers := runs.Containers(); len(containers) > 0 {
+		t.Errorf("expected no container created, got %d", len(containers))
+	}
+}
@@ -0,0 +1,595 @@
+package docker
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/neaas/nescript"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// minMemoryLimit is the smallest memory limit the docker engine will accept.
+	minMemoryLimit int64 = 6 * 1024 * 1024
+)
+
+// Option configures a docker ExecFunc. Options that describe how a container
+// is created (such as networking) are only used by the RunExecutor, as the
+// exec Executor runs inside a container that already exists.
+type Option func(*options)
+
+type options struct {
+	client           Client
+	host             string
+	tlsCA            []byte
+	tlsCert          []byte
+	tlsKey           []byte
+	tlsSkipVerify    bool
+	sshConfig        *ssh.ClientConfig
+	sshKey           []byte
+	sshKeyPassphrase string
+	sshKnownHosts    string
+	minAPIVersion    string
+	dockerContext    string
+	connection       **Connection
+
+	workdir   string
+	envPolicy EnvPolicy
+	stdin     io.Reader
+	shell     nescript.Subcommand
+	shells    []Shell
+
+	// stdinClaimed is shared by every options the stdin is given to, so that
+	// the reader is consumed by one execution only.
+	stdinClaimed *atomic.Bool
+
+	windowsShell WindowsShell
+
+	pullProgress PullProgressFunc
+	platform     string
+	retry        retryPolicy
+
+	target *target
+
+	waitHealthy        time.Duration
+	requireHealthcheck bool
+
+	networks   []networkAttachment
+	extraHosts []string
+	dns        []string
+	dnsSearch  []string
+	dnsOptions []string
+	resources  Resources
+	security   Security
+	gpus       string
+
+	stopSignal  string
+	stopTimeout *time.Duration
+	artifacts   []artifactSpec
+
+	concurrency int
+	failFast    bool
+	fanOutOrder FanOutOrder
+}
+
+type networkAttachment struct {
+	name    string
+	aliases []string
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		retry: retryPolicy{attempts: 1},
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithHost sets the endpoint of the engine serving the docker API, such as
+// unix:///run/user/1000/podman/podman.sock or tcp://10.0.0.1:2375. This is only
+// used when the executor is not given a client, in which case one is created
+// (see NewClient).
+func WithHost(endpoint string) Option {
+	return func(o *options) {
+		o.host = endpoint
+	}
+}
+
+// WithDockerClient sets an already configured client to be used by the
+// executor, as an alternative to passing it directly. When set, all other
+// connection options are ignored.
+func WithDockerClient(client Client) Option {
+	return func(o *options) {
+		o.client = client
+	}
+}
+
+// WithDockerContext connects to the endpoint of the named docker CLI context
+// (see `docker context ls`), including its TLS material, rather than the
+// currently selected context. An error listing the available contexts is
+// returned if the context does not exist.
+func WithDockerContext(name string) Option {
+	return func(o *options) {
+		o.dockerContext = name
+	}
+}
+
+// WithTLS sets the PEM encoded CA certificate, client certificate and client
+// key used to connect to a tcp:// host over TLS. The CA may be nil to use the
+// system's trusted roots, and the certificate and key may be nil when the
+// engine does not require client authentication.
+func WithTLS(caPEM, certPEM, keyPEM []byte) Option {
+	return func(o *options) {
+		o.tlsCA = caPEM
+		o.tlsCert = certPEM
+		o.tlsKey = keyPEM
+	}
+}
+
+// WithSSHClientConfig sets the SSH client config used to connect to an ssh://
+// host, giving full control over authentication and host key verification. If
+// the host URL includes a user, it takes precedence over the config's user.
+func WithSSHClientConfig(config *ssh.ClientConfig) Option {
+	return func(o *options) {
+		o.sshConfig = config
+	}
+}
+
+// WithSSHKey sets the PEM encoded private key used to authenticate with an
+// ssh:// host. The passphrase may be empty if the key is not encrypted.
+func WithSSHKey(keyPEM []byte, passphrase string) Option {
+	return func(o *options) {
+		o.sshKey = keyPEM
+		o.sshKeyPassphrase = passphrase
+	}
+}
+
+// WithSSHKnownHosts sets the path of the known_hosts file used to verify the
+// host key of an ssh:// host. By default ~/.ssh/known_hosts is used.
+func WithSSHKnownHosts(path string) Option {
+	return func(o *options) {
+		o.sshKnownHosts = path
+	}
+}
+
+// WithConnectionRef sets ref to the Connection the executor is created from,
+// such as that created by the package level Executor and RunExecutor, so that
+// the docker client it creates (where none is given) can be closed once the
+// executor is no longer needed (see Connection.Close).
+func WithConnectionRef(ref **Connection) Option {
+	return func(o *options) {
+		o.connection = ref
+	}
+}
+
+// WithMinAPIVersion sets the minimum docker API version the executor relies on
+// (for example "1.25", which added exec env support). If the engine only
+// supports an older version, executions fail fast with an error wrapping
+// ErrAPIVersion rather than with a confusing error from the engine.
+func WithMinAPIVersion(version string) Option {
+	return func(o *options) {
+		o.minAPIVersion = version
+	}
+}
+
+// WithWorkDir sets the working directory of the script process. The path
+// should be in the context of the container's file system.
+func WithWorkDir(workdir string) Option {
+	return func(o *options) {
+		o.workdir = workdir
+	}
+}
+
+// WithStdin sets a reader to be copied to the stdin of the script process (the
+// equivalent of piping into `docker exec -i` or `docker run -i`). Once the
+// reader is exhausted, stdin is closed so the script receives EOF. The result
+// does not wait for a reader that has not returned once the script exits, such
+// as os.Stdin. As an execution consumes the reader, it is given to the first
+// execution only, any other execution with the option (including those of a
+// fan-out) failing with ErrStdinConflict. Process.Write should not be used
+// alongside this option.
+func WithStdin(stdin io.Reader) Option {
+	claimed := &atomic.Bool{}
+	return func(o *options) {
+		o.stdin = stdin
+		o.stdinClaimed = claimed
+	}
+}
+
+// WithShellDetection makes the executor detect the shell available in the
+// container (or image) and invoke scripts with it, rather than with the
+// script's own subcommand. The shells are tried in the order given, or if none
+// are given, DefaultShells is used. The detected shell is cached per container
+// (or image and platform) for the lifetime of the executor's Connection. An
+// image is probed with a container created (but never started) for the
+// platform (see WithPlatform), labelled like the script container. This only
+// affects cmds created from a script (see Script.Cmd).
+func WithShellDetection(shells ...Shell) Option {
+	return func(o *options) {
+		if len(shells) == 0 {
+			shells = DefaultShells
+		}
+		o.shells = shells
+	}
+}
+
+// WithContainerShell sets the shell scripts are invoked with in the container,
+// such as ["/bin/ash", "-c"], overriding both the script's own subcommand and
+// any shell detection. This only affects cmds created from a script (see
+// Script.Cmd).
+func WithContainerShell(shell nescript.Subcommand) Option {
+	return func(o *options) {
+		o.shell = shell
+	}
+}
+
+// WithWindowsShell sets the shell scripts are invoked with in windows
+// containers. By default, WindowsPowerShell is used. WithContainerShell takes
+// precedence over this.
+func WithWindowsShell(shell WindowsShell) Option {
+	return func(o *options) {
+		o.windowsShell = shell
+	}
+}
+
+// WithEnvPolicy sets how the env vars of the script/cmd are combined with the
+// env vars already configured on the container or image. By default,
+// EnvOverlay is used.
+func WithEnvPolicy(policy EnvPolicy) Option {
+	return func(o *options) {
+		o.envPolicy = policy
+	}
+}
+
+// WithComposeTarget makes the exec executor find the container to execute in by
+// its docker-compose project, service and container number (index, starting at
+// 1) labels, rather than by ID. An index of 0 matches any container of the
+// service, which must then be the only one. Containers are only matched by
+// their labels (which every version of compose sets), never by the names
+// compose gives them, as the name of one container can be the prefix of
+// another's. The container is resolved for each execution, so follows the
+// service if its container is recreated.
+func WithComposeTarget(project, service string, index int) Option {
+	return func(o *options) {
+		o.target = &target{
+			project: project,
+			service: service,
+			index:   index,
+		}
+	}
+}
+
+// WithContainerNamePrefix makes the exec executor find the container to execute
+// in by its name, rather than by ID. Exactly one container must have a name
+// starting with the given prefix.
+func WithContainerNamePrefix(prefix string) Option {
+	return func(o *options) {
+		o.target = &target{
+			prefix: prefix,
+		}
+	}
+}
+
+// WithWaitHealthy makes the exec executor wait (up to the given timeout) for
+// the container to report as healthy before the script is started. If the
+// container does not define a healthcheck, it is considered healthy as soon as
+// it is running, unless WithRequireHealthcheck is given. A timeout results in a
+// *HealthTimeoutError.
+func WithWaitHealthy(timeout time.Duration) Option {
+	return func(o *options) {
+		o.waitHealthy = timeout
+	}
+}
+
+// WithRequireHealthcheck makes waiting for a healthy container (see
+// WithWaitHealthy) fail with ErrNoHealthcheck if the container does not define
+// a healthcheck, rather than treating a running container as healthy.
+func WithRequireHealthcheck(require bool) Option {
+	return func(o *options) {
+		o.requireHealthcheck = require
+	}
+}
+
+// WithPullProgress sets a func to be called with progress updates while the
+// RunExecutor pulls an image that is not present on the engine. Without this,
+// pulls happen quietly.
+func WithPullProgress(progressFunc PullProgressFunc) Option {
+	return func(o *options) {
+		o.pullProgress = progressFunc
+	}
+}
+
+// WithPlatform sets the platform (os[/arch[/variant]], such as "linux/arm64")
+// of the image the script container is created from. The image is pulled for
+// that platform if not already present, which allows images for other
+// architectures to be run under emulation (such as qemu/binfmt). The platform
+// of the image actually run is recorded on the result (see PlatformFrom).
+func WithPlatform(platform string) Option {
+	return func(o *options) {
+		o.platform = platform
+	}
+}
+
+// WithRetry makes the executor retry the calls made to the engine before the
+// script starts (creating, attaching to and starting the container or exec)
+// when they fail with a transient error (see IsTransient), up to the given
+// number of attempts in total. The backoff between attempts starts at the
+// given duration and doubles after each. Once the script may have started, it
+// is never retried, so the script is never run twice. When retries happen, the
+// returned error is a *RetryError holding every error encountered.
+func WithRetry(attempts int, backoff time.Duration) Option {
+	return func(o *options) {
+		o.retry = retryPolicy{
+			attempts: attempts,
+			backoff:  backoff,
+		}
+	}
+}
+
+// WithNetwork attaches the script container to the named (user-defined)
+// network, optionally with a set of aliases that other containers on the
+// network can use to reach it. This can be given multiple times to attach the
+// container to more than one network, the first network given being the
+// container's primary network.
+func WithNetwork(name string, aliases ...string) Option {
+	return func(o *options) {
+		o.networks = append(o.networks, networkAttachment{
+			name:    name,
+			aliases: aliases,
+		})
+	}
+}
+
+// WithExtraHosts adds entries to the container's /etc/hosts file. Each entry
+// should be in the form host:ip, as would be given to `docker run --add-host`.
+func WithExtraHosts(hosts ...string) Option {
+	return func(o *options) {
+		o.extraHosts = append(o.extraHosts, hosts...)
+	}
+}
+
+// WithDNS overrides the DNS servers used by the script container.
+func WithDNS(servers ...string) Option {
+	return func(o *options) {
+		o.dns = append(o.dns, servers...)
+	}
+}
+
+// WithDNSSearch overrides the DNS search domains used by the script container.
+func WithDNSSearch(domains ...string) Option {
+	return func(o *options) {
+		o.dnsSearch = append(o.dnsSearch, domains...)
+	}
+}
+
+// WithDNSOptions sets resolver options (such as ndots:2) for the script
+// container.
+func WithDNSOptions(opts ...string) Option {
+	return func(o *options) {
+		o.dnsOptions = append(o.dnsOptions, opts...)
+	}
+}
+
+// WithMemoryLimit limits the memory available to the script container, in
+// bytes. The docker engine requires a limit of at least 6MiB. If the script is
+// killed for exceeding the limit, this is reported on the result (see
+// OOMKilled).
+func WithMemoryLimit(bytes int64) Option {
+	return func(o *options) {
+		o.resources.MemoryBytes = bytes
+	}
+}
+
+// WithCPUQuota limits the number of CPUs the script container may use, for
+// example 1.5 allows the container one and a half CPUs worth of time.
+func WithCPUQuota(cpus float64) Option {
+	return func(o *options) {
+		o.resources.CPUs = cpus
+	}
+}
+
+// WithPidsLimit limits the number of processes that can exist in the script
+// container at any one time.
+func WithPidsLimit(n int64) Option {
+	return func(o *options) {
+		o.resources.PidsLimit = n
+	}
+}
+
+// WithPrivileged runs the script container in privileged mode, giving it all
+// capabilities and access to the host's devices.
+func WithPrivileged(privileged bool) Option {
+	return func(o *options) {
+		o.security.Privileged = privileged
+	}
+}
+
+// WithCapAdd adds linux capabilities (such as "NET_ADMIN" or "CAP_NET_ADMIN")
+// to the script container.
+func WithCapAdd(caps ...string) Option {
+	return func(o *options) {
+		o.security.CapAdd = append(o.security.CapAdd, caps...)
+	}
+}
+
+// WithCapDrop drops linux capabilities from the script container. "ALL" drops
+// every capability, which can be combined with WithCapAdd to only grant those
+// needed.
+func WithCapDrop(caps ...string) Option {
+	return func(o *options) {
+		o.security.CapDrop = append(o.security.CapDrop, caps...)
+	}
+}
+
+// WithDevices maps host devices into the script container, in the same format
+// as `docker run --device`, host-path[:container-path[:permissions]]. For
+// example, "/dev/fuse" or "/dev/ttyUSB0:/dev/ttyS0:rw".
+func WithDevices(mappings ...string) Option {
+	return func(o *options) {
+		o.security.Devices = append(o.security.Devices, mappings...)
+	}
+}
+
+// WithSecurityOpt sets security options on the script container, in the same
+// format as `docker run --security-opt`, such as "no-new-privileges" or
+// "seccomp=/path/to/profile.json".
+func WithSecurityOpt(opts ...string) Option {
+	return func(o *options) {
+		o.security.SecurityOpt = append(o.security.SecurityOpt, opts...)
+	}
+}
+
+// WithArtifact copies a path (file or directory) out of the script container
+// once the script exits, before the container is removed. The path is
+// extracted into the hostDest directory, keeping its base name, so
+// "/out/report.txt" is written to hostDest/report.txt and the directory "/out"
+// to hostDest/out. A path that can not be copied, such as one the script did not
+// create, does not fail the execution; instead the error is recorded on the
+// result (see ArtifactsFrom).
+func WithArtifact(containerPath, hostDest string) Option {
+	return func(o *options) {
+		o.artifacts = append(o.artifacts, artifactSpec{containerPath: containerPath, hostDest: hostDest})
+	}
+}
+
+// WithGPUs gives the script container access to the host's NVIDIA GPUs, like
+// `docker run --gpus`. The spec may be "all", a number of GPUs (such as "2"),
+// or specific devices by index or UUID (such as "device=0,1"). The docker engine
+// must have a GPU runtime, such as the NVIDIA container toolkit, otherwise an
+// ErrGPUUnavailable is returned.
+func WithGPUs(spec string) Option {
+	return func(o *options) {
+		o.gpus = spec
+	}
+}
+
+// WithStopSignal sets the signal (such as "SIGINT") used to stop the script
+// container, either when the cmd context is cancelled or when the process is
+// stopped (see DockerRunProcess.Stop). By default, SIGTERM is used.
+func WithStopSignal(signal string) Option {
+	return func(o *options) {
+		o.stopSignal = signal
+	}
+}
+
+// WithStopTimeout sets how long the script container is given to exit after
+// the stop signal is sent, before it is killed. By default, the engine's
+// timeout (10 seconds on docker) is used. The timeout is applied in whole
+// seconds, rounding up.
+func WithStopTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.stopTimeout = &timeout
+	}
+}
+
+// stopTimeoutSeconds returns the stop timeout in the form used by the docker
+// API, or nil if the engine default should be used.
+func (o *options) stopTimeoutSeconds() *int {
+	if o.stopTimeout == nil {
+		return nil
+	}
+	seconds := int((*o.stopTimeout + time.Second - 1) / time.Second)
+	return &seconds
+}
+
+// WithConcurrency sets the maximum number of containers ExecAll executes in at
+// once. By default, this is one.
+func WithConcurrency(n int) Option {
+	return func(o *options) {
+		o.concurrency = n
+	}
+}
+
+// WithFailFast stops ExecAll from starting executions in any further
+// containers once one has failed, and cancels those already started (see
+// Executor for what cancelling an exec does).
+func WithFailFast(failFast bool) Option {
+	return func(o *options) {
+		o.failFast = failFast
+	}
+}
+
+// WithFanOutOrder sets the order ExecAll executes in the selected containers.
+func WithFanOutOrder(order FanOutOrder) Option {
+	return func(o *options) {
+		o.fanOutOrder = order
+	}
+}
+
+// validate reports any options that have been given nonsensical values.
+func (o *options) validate() error {
+	if o.resources.MemoryBytes != 0 && o.resources.MemoryBytes < minMemoryLimit {
+		return fmt.Errorf("invalid memory limit %d: must be at least %d bytes", o.resources.MemoryBytes, minMemoryLimit)
+	}
+	if o.resources.CPUs < 0 {
+		return fmt.Errorf("invalid cpu quota %g: must be greater than zero", o.resources.CPUs)
+	}
+	if o.platform != "" {
+		if _, err := parsePlatform(o.platform); err != nil {
+			return err
+		}
+	}
+	if o.gpus != "" {
+		if _, err := parseGPUs(o.gpus); err != nil {
+			return err
+		}
+	}
+	if err := o.security.validate(); err != nil {
+		return err
+	}
+	if o.stopTimeout != nil && *o.stopTimeout < 0 {
+		return fmt.Errorf("invalid stop timeout %s: must not be negative", *o.stopTimeout)
+	}
+	if o.resources.PidsLimit < 0 {
+		return fmt.Errorf("invalid pids limit %d: must be greater than zero", o.resources.PidsLimit)
+	}
+	return nil
+}
+
+// hostConfig builds the host config of the script container. The options must
+// have been validated first.
+func (o *options) hostConfig() *container.HostConfig {
+	hostConfig := &container.HostConfig{
+		ExtraHosts: o.extraHosts,
+		DNS:        o.dns,
+		DNSSearch:  o.dnsSearch,
+		DNSOptions: o.dnsOptions,
+		Resources:  o.resources.hostResources(),
+	}
+	o.security.apply(hostConfig)
+	if o.gpus != "" {
+		request, _ := parseGPUs(o.gpus)
+		hostConfig.DeviceRequests = []container.DeviceRequest{request}
+	}
+	if len(o.networks) > 0 {
+		hostConfig.NetworkMode = container.NetworkMode(o.networks[0].name)
+	}
+	return hostConfig
+}
+
+// hostResources maps the resource limits onto the docker host config
+// representation.
+func (r Resources) hostResources() container.Resources {
+	resources := container.Resources{
+		Memory:   r.MemoryBytes,
+		NanoCPUs: int64(r.CPUs * 1e9),
+	}
+	if r.PidsLimit > 0 {
+		pidsLimit := r.PidsLimit
+		resources.PidsLimit = &pidsLimit
+	}
+	return resources
+}
+
+// endpointSettings returns the endpoint configuration for the given attachment.
+func (n networkAttachment) endpointSettings() *network.EndpointSettings {
+	return &network.EndpointSettings{
+		Aliases: n.aliases,
+	}
+}
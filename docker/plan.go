@@ -0,0 +1,103 @@
+package docker
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/neaas/nescript"
+)
+
+// RunPlan describes the container the RunExecutor would create to execute a
+// cmd, so that it can be reviewed before anything is executed.
+type RunPlan struct {
+	Image       string         `json:"image"`
+	Platform    string         `json:"platform,omitempty"`
+	Command     []string       `json:"command"`
+	Env         []string       `json:"env,omitempty"`
+	EnvPolicy   EnvPolicy      `json:"envPolicy"`
+	WorkDir     string         `json:"workDir,omitempty"`
+	Networks    []string       `json:"networks,omitempty"`
+	Resources   Resources      `json:"resources"`
+	Security    Security       `json:"security"`
+	GPUs        string         `json:"gpus,omitempty"`
+	StopSignal  string         `json:"stopSignal,omitempty"`
+	StopTimeout *time.Duration `json:"stopTimeout,omitempty"`
+}
+
+// Plan describes the container the RunExecutor would create from the image to
+// execute the cmd with the given options, without contacting the docker
+// engine (a dry run). As the image is not inspected, the env does not include
+// the image's own env, and the command is shown with the cmd's own shell if
+// shell detection is enabled. An error is returned if the options (or the cmd's
+// env) are invalid.
+func Plan(image string, c nescript.Cmd, opts ...Option) (*RunPlan, error) {
+	o := newOptions(opts)
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	raw, err := adaptShell(c, o, "", func() (nescript.Subcommand, error) {
+		subcommand, _, _, _ := c.Script()
+		return subcommand, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	env, command, err := effectiveEnv(o.envPolicy, nil, c.Env(), raw)
+	if err != nil {
+		return nil, err
+	}
+	plan := &RunPlan{
+		Image:       image,
+		Platform:    o.platform,
+		Command:     command,
+		Env:         env,
+		EnvPolicy:   o.envPolicy,
+		WorkDir:     o.workdir,
+		Resources:   o.resources,
+		Security:    o.security,
+		GPUs:        o.gpus,
+		StopSignal:  o.stopSignal,
+		StopTimeout: o.stopTimeout,
+	}
+	for _, n := range o.networks {
+		plan.Networks = append(plan.Networks, n.name)
+	}
+	return plan, nil
+}
+
+// String formats the plan for review, one setting per line.
+func (p RunPlan) String() string {
+	lines := []string{
+		"image: " + p.Image,
+	}
+	if p.Platform != "" {
+		lines = append(lines, "platform: "+p.Platform)
+	}
+	lines = append(lines, fmt.Sprintf("command: %q", p.Command))
+	if len(p.Env) > 0 {
+		lines = append(lines, "env: "+strings.Join(p.Env, " "))
+	}
+	if p.WorkDir != "" {
+		lines = append(lines, "workdir: "+p.WorkDir)
+	}
+	if len(p.Networks) > 0 {
+		lines = append(lines, "networks: "+strings.Join(p.Networks, ", "))
+	}
+	if p.Resources.resourcesSet() {
+		lines = append(lines, fmt.Sprintf("resources: memory=%d cpus=%g pids=%d", p.Resources.MemoryBytes, p.Resources.CPUs, p.Resources.PidsLimit))
+	}
+	if p.Security.securitySet() {
+		lines = append(lines, "security: "+p.Security.String())
+	}
+	if p.GPUs != "" {
+		lines = append(lines, "gpus: "+p.GPUs)
+	}
+	if p.StopSignal != "" {
+		lines = append(lines, "stop signal: "+p.StopSignal)
+	}
+	if p.StopTimeout != nil {
+		lines = append(lines, "stop timeout: "+p.StopTimeout.String())
+	}
+	return strings.Join(lines, "\n")
+}
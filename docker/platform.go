@@ -0,0 +1,77 @@
+package docker
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/versions"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// minPlatformAPIVersion is the first docker API version that accepts a
+// platform when creating a container.
+const minPlatformAPIVersion = "1.41"
+
+var (
+	// platformRegex is the accepted platform format, os[/arch[/variant]].
+	platformRegex = regexp.MustCompile(`^[a-z0-9_-]+(/[a-z0-9_-]+(/[a-z0-9_-]+)?)?$`)
+)
+
+// parsePlatform parses a platform in the form os[/arch[/variant]], such as
+// "linux/arm64" or "linux/arm/v7".
+func parsePlatform(platform string) (*ocispec.Platform, error) {
+	platform = strings.ToLower(strings.TrimSpace(platform))
+	if !platformRegex.MatchString(platform) {
+		return nil, fmt.Errorf("invalid platform '%s': must be in the form os[/arch[/variant]]", platform)
+	}
+	parts := strings.Split(platform, "/")
+	p := &ocispec.Platform{OS: parts[0]}
+	if len(parts) > 1 {
+		p.Architecture = parts[1]
+	}
+	if len(parts) > 2 {
+		p.Variant = parts[2]
+	}
+	return p, nil
+}
+
+// formatPlatform formats the platform an image was built for.
+func formatPlatform(os, arch, variant string) string {
+	platform := os
+	if arch != "" {
+		platform += "/" + arch
+	}
+	if variant != "" {
+		platform += "/" + variant
+	}
+	return platform
+}
+
+// imagePlatform returns the platform the inspected image was built for.
+func imagePlatform(inspect types.ImageInspect) string {
+	return formatPlatform(inspect.Os, inspect.Architecture, inspect.Variant)
+}
+
+// matchesPlatform reports whether an image built for the actual platform
+// (os/arch[/variant]) satisfies the requested platform. Parts not given in the
+// request match any value.
+func matchesPlatform(requested *ocispec.Platform, actual string) bool {
+	if requested == nil {
+		return true
+	}
+	parts := append(strings.SplitN(actual, "/", 3), "", "")
+	return (requested.OS == "" || requested.OS == parts[0]) &&
+		(requested.Architecture == "" || requested.Architecture == parts[1]) &&
+		(requested.Variant == "" || requested.Variant == parts[2])
+}
+
+// checkPlatformSupport ensures the negotiated API version allows a platform to
+// be given when creating containers.
+func checkPlatformSupport(version string) error {
+	if versions.LessThan(version, minPlatformAPIVersion) {
+		return fmt.Errorf("%w: selecting a platform requires API version %s, however the engine only supports %s", ErrPlatformUnsupported, minPlatformAPIVersion, version)
+	}
+	return nil
+}
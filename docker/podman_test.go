@@ -0,0 +1,117 @@
+package docker_test
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/docker"
+)
+
+// podmanVersion is the version reported by podman's docker-compatible API,
+// which supports an older API version than the docker SDK.
+var podmanVersion = types.Version{
+	Platform:      struct{ Name string }{Name: "linux/amd64/fedora-40"},
+	Components:    []types.ComponentVersion{{Name: "Podman Engine", Version: "5.1.1"}},
+	Version:       "5.1.1",
+	APIVersion:    "1.41",
+	MinAPIVersion: "1.24",
+	Os:            "linux",
+	Arch:          "amd64",
+}
+
+func TestEngines(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		version    types.Version
+		engine     docker.Engine
+		apiVersion string
+	}{
+		{"docker", types.Version{Version: "26.1.3", APIVersion: apiVersion, MinAPIVersion: "1.24"}, docker.EngineDocker, apiVersion},
+		{"podman", podmanVersion, docker.EnginePodman, "1.41"},
+		{"podman by platform name", types.Version{Platform: struct{ Name string }{Name: "Podman Engine"}, APIVersion: "1.41"}, docker.EnginePodman, "1.41"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			daemon := newFakeDaemon(t)
+			daemon.Handle("GET /version", func(w http.ResponseWriter, _ *http.Request) {
+				writeJSON(w, http.StatusOK, tc.version)
+			})
+			// the API version is negotiated down to that of the engine from
+			// the ping.
+			daemon.Handle("HEAD /_ping", func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("API-Version", tc.apiVersion)
+				w.WriteHeader(http.StatusOK)
+			})
+			daemon.Handle("GET /_ping", func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("API-Version", tc.apiVersion)
+				w.WriteHeader(http.StatusOK)
+			})
+			daemon.Image("alpine:3")
+			daemon.Run()
+			conn := docker.Connect(docker.WithHost(daemon.URL))
+			defer conn.Close()
+			if engine, err := conn.Engine(); err != nil || engine != tc.engine {
+				t.Errorf("expected the engine %s, got %s: %v", tc.engine, engine, err)
+			}
+			if version, err := conn.APIVersion(); err != nil || version != tc.apiVersion {
+				t.Errorf("expected API version %s negotiated, got %s: %v", tc.apiVersion, version, err)
+			}
+			process, err := nescript.NewScript("echo ran; exit 3").Cmd().Exec(conn.RunExecutor("alpine:3"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := process.Result()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.StdOut != "ran\n" || result.ExitCode != 3 {
+				t.Errorf("expected the script's output and exit code, got %q and %d", result.StdOut, result.ExitCode)
+			}
+		})
+	}
+}
+
+func TestEngineRequiresNewerAPIVersion(t *testing.T) {
+	daemon := newFakeDaemon(t)
+	daemon.Handle("GET /version", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, types.Version{APIVersion: "9.99", MinAPIVersion: "9.0"})
+	})
+	conn := docker.Connect(docker.WithHost(daemon.URL))
+	defer conn.Close()
+	if _, err := conn.APIVersion(); !errors.Is(err, docker.ErrAPIVersion) {
+		t.Errorf("expected ErrAPIVersion, got %v", err)
+	}
+}
+
+func TestPodmanSocketDiscovery(t *testing.T) {
+	if _, err := os.Stat("/var/run/docker.sock"); err == nil {
+		t.Skip("the docker socket is found before the podman socket")
+	}
+	runtimeDir := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", runtimeDir)
+	t.Setenv("DOCKER_HOST", "")
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	daemon := newFakeDaemon(t)
+	daemon.Handle("GET /version", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, podmanVersion)
+	})
+	socket := filepath.Join(runtimeDir, "podman", "podman.sock")
+	daemon.ListenUnix(t, socket)
+	client, err := docker.NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if host := client.DaemonHost(); host != "unix://"+socket {
+		t.Errorf("expected the rootless podman socket found, got %q", host)
+	}
+	conn := docker.Connect()
+	defer conn.Close()
+	if engine, err := conn.Engine(); err != nil || engine != docker.EnginePodman {
+		t.Errorf("expected podman detected through its socket, got %s: %v", engine, err)
+	}
+}
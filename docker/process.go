@@ -4,26 +4,53 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"os"
+	"sync"
+	"time"
 
 	"github.com/docker/docker/api/types"
-	docker "github.com/docker/docker/client"
 	"github.com/neaas/nescript"
 )
 
 type DockerProcess struct {
-	dockerClient *docker.Client
+	dockerClient Client
 	dockerConn   *types.HijackedResponse
 	commandID    string
+	env          []string
+	windows      bool
+	stdin        *stdinPipe
 	stdoutBytes  bytes.Buffer
 	stderrBytes  bytes.Buffer
 	complete     chan error
+	started      time.Time
+	ended        time.Time
+	tee          *nescript.Tee
+	completion   *nescript.Completion
+
+	mu       sync.Mutex
+	detached error
 }
 
+// Kill returns an error while the script is running, as a docker exec can not
+// be killed, and does nothing once it has exited.
 func (p *DockerProcess) Kill() error {
+	if p.exited() {
+		return nil
+	}
 	return fmt.Errorf("can not kill docker exec process")
 }
 
+// exited reports whether the result of the script has been collected.
+func (p *DockerProcess) exited() bool {
+	select {
+	case <-p.completion.Done():
+		return true
+	default:
+		return false
+	}
+}
+
 func (p *DockerProcess) Signal(s os.Signal) error {
 	return fmt.Errorf("can not signal docker exec process")
 }
@@ -35,12 +62,61 @@ func (p *DockerProcess) Write(input string) error {
 	return nil
 }
 
+func (p *DockerProcess) Stdin() io.WriteCloser {
+	return nescript.StdinWriter(p)
+}
+
+func (p *DockerProcess) Stdout() io.Reader {
+	return p.tee.StdoutStream()
+}
+
+func (p *DockerProcess) Stderr() io.Reader {
+	return p.tee.StderrStream()
+}
+
+// ID returns the ID of the exec.
+func (p *DockerProcess) ID() string {
+	return p.commandID
+}
+
+func (p *DockerProcess) String() string {
+	return fmt.Sprintf("docker exec %s", p.commandID)
+}
+
+func (p *DockerProcess) Wait(ctx context.Context) (*nescript.Result, error) {
+	return nescript.Wait(ctx, p)
+}
+
 func (p *DockerProcess) Result() (*nescript.Result, error) {
+	return p.completion.Result()
+}
+
+func (p *DockerProcess) Poll() (*nescript.Result, bool) {
+	return p.completion.Poll()
+}
+
+func (p *DockerProcess) WaitContext(ctx context.Context) (*nescript.Result, error) {
+	return p.completion.WaitContext(ctx)
+}
+
+// collect waits for the script to exit, collecting its result (see
+// nescript.Completion).
+func (p *DockerProcess) collect() (*nescript.Result, error) {
 	defer p.Close()
 	err := <-p.complete
+	p.mu.Lock()
+	detached := p.detached
+	p.mu.Unlock()
+	if detached != nil {
+		return nil, fmt.Errorf("detached from docker exec: %w", detached)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to wait for docker process: %w", err)
 	}
+	p.dockerConn.Close()
+	if err := p.stdin.wait(); err != nil {
+		return nil, err
+	}
 	res, err := p.dockerClient.ContainerExecInspect(context.Background(), p.commandID)
 	if err != nil {
 		return nil, fmt.Errorf("could not determine exit code: %w", err)
@@ -50,9 +126,27 @@ func (p *DockerProcess) Result() (*nescript.Result, error) {
 		StdErr: string(p.stderrBytes.String()),
 	}
 	result.ExitCode = res.ExitCode
+	if p.windows {
+		result.StdOut = normalizeWindowsOutput(result.StdOut)
+		result.StdErr = normalizeWindowsOutput(result.StdErr)
+		result.ExitCode = windowsExitCode(result.ExitCode)
+	}
+	result.SetTimes(p.started, p.ended)
+	result.SetMetadata(nescript.MetadataExecutor, "docker")
+	result.SetMetadata(MetadataEnv, p.env)
+	p.tee.Finish(&result)
 	return &result, nil
 }
 
+// detach closes the connection to the exec as the cmd context ended with the
+// error, which the result is then failed with.
+func (p *DockerProcess) detach(err error) {
+	p.mu.Lock()
+	p.detached = err
+	p.mu.Unlock()
+	p.dockerConn.Close()
+}
+
 func (p *DockerProcess) Close() {
 	p.dockerConn.Close()
 }
@@ -0,0 +1,25 @@
+package docker_test
+
+import (
+	"testing"
+
+	"github.com/neaas/nescript/docker"
+	"github.com/neaas/nescript/processtest"
+)
+
+func TestExecutorContract(t *testing.T) {
+	daemon := newFakeDaemon(t)
+	daemon.Container("app")
+	if err := processtest.TestExecutor(docker.Executor(nil, "app", "", docker.WithHost(daemon.URL))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunExecutorContract(t *testing.T) {
+	daemon := newFakeDaemon(t)
+	daemon.Image("alpine:3")
+	daemon.Run()
+	if err := processtest.TestExecutor(docker.RunExecutor(nil, "alpine:3", docker.WithHost(daemon.URL))); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,107 @@
+package docker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/docker/docker/pkg/jsonmessage"
+)
+
+// PullProgress is a single progress update from pulling an image.
+type PullProgress struct {
+	// LayerID is the ID of the layer the update relates to, or empty for
+	// updates about the image as a whole.
+	LayerID string
+
+	// Status is the status as reported by the engine, such as "Downloading" or
+	// "Pull complete".
+	Status string
+
+	// Current and Total are the bytes of the layer processed so far, and in
+	// total. Total is zero when not known.
+	Current int64
+	Total   int64
+
+	// Percent is an estimate of the overall download progress of the image,
+	// across all layers with a known size, from 0 to 100.
+	Percent float64
+}
+
+// PullProgressFunc is called for every progress update while pulling an image.
+type PullProgressFunc func(PullProgress)
+
+// layerProgress tracks the download progress of each layer, so that overall
+// progress can be estimated.
+type layerProgress struct {
+	order  []string
+	layers map[string]*jsonmessage.JSONProgress
+}
+
+func (lp *layerProgress) update(msg jsonmessage.JSONMessage) float64 {
+	if msg.ID != "" {
+		progress, ok := lp.layers[msg.ID]
+		if !ok {
+			progress = &jsonmessage.JSONProgress{}
+			lp.layers[msg.ID] = progress
+			lp.order = append(lp.order, msg.ID)
+		}
+		switch msg.Status {
+		case "Downloading":
+			if msg.Progress != nil {
+				progress.Current = msg.Progress.Current
+				progress.Total = msg.Progress.Total
+			}
+		case "Download complete", "Already exists", "Pull complete":
+			if progress.Total == 0 {
+				progress.Total = 1
+			}
+			progress.Current = progress.Total
+		}
+	}
+	var current, total int64
+	for _, id := range lp.order {
+		current += lp.layers[id].Current
+		total += lp.layers[id].Total
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(current) / float64(total) * 100
+}
+
+// readPullStream consumes the JSON message stream returned by the engine when
+// pulling an image, calling the progress func (if given) for each update. An
+// error reported within the stream (such as an unknown manifest part way
+// through) is returned as the pull error.
+func readPullStream(stream io.Reader, progressFunc PullProgressFunc) error {
+	decoder := json.NewDecoder(stream)
+	progress := &layerProgress{
+		layers: make(map[string]*jsonmessage.JSONProgress),
+	}
+	for {
+		var msg jsonmessage.JSONMessage
+		if err := decoder.Decode(&msg); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to read image pull progress: %w", err)
+		}
+		if msg.Error != nil {
+			return msg.Error
+		}
+		if progressFunc == nil {
+			continue
+		}
+		update := PullProgress{
+			LayerID: msg.ID,
+			Status:  msg.Status,
+			Percent: progress.update(msg),
+		}
+		if msg.Progress != nil {
+			update.Current = msg.Progress.Current
+			update.Total = msg.Progress.Total
+		}
+		progressFunc(update)
+	}
+}
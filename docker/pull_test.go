@@ -0,0 +1,112 @@
+package docker_test
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/docker"
+)
+
+// servePull serves pulls of the image with the recorded stream in testdata,
+// serving the image once the stream has been written.
+func servePull(t *testing.T, daemon *fakeDaemon, ref, fixture string) {
+	t.Helper()
+	stream, err := os.ReadFile(filepath.Join("testdata", fixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	daemon.Handle("POST /images/create", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(stream)
+		if !bytes.Contains(stream, []byte(`"error"`)) {
+			daemon.Image(ref)
+		}
+	})
+}
+
+func TestPullProgress(t *testing.T) {
+	daemon := newFakeDaemon(t)
+	servePull(t, daemon, "alpine:3", "pull.jsonl")
+	daemon.Run()
+	var (
+		mu      sync.Mutex
+		updates []docker.PullProgress
+	)
+	progress := docker.WithPullProgress(func(p docker.PullProgress) {
+		mu.Lock()
+		defer mu.Unlock()
+		updates = append(updates, p)
+	})
+	process, err := nescript.NewScript("echo ran").Cmd().Exec(docker.RunExecutor(nil, "alpine:3", docker.WithHost(daemon.URL), progress))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := process.Result(); err != nil {
+		t.Fatal(err)
+	}
+	if len(updates) != 18 {
+		t.Fatalf("expected an update for each of the 18 messages, got %d", len(updates))
+	}
+	if first := updates[0]; first.LayerID != "3" || first.Status != "Pulling from library/alpine" {
+		t.Errorf("expected the first update for the image, got %+v", first)
+	}
+	if update := updates[4]; update.LayerID != "4abcf2066143" || update.Status != "Downloading" || update.Current != 1048576 || update.Total != 3408729 {
+		t.Errorf("expected the layer's download progress, got %+v", update)
+	}
+	if percent := updates[4].Percent; percent < 30 || percent > 31 {
+		t.Errorf("expected about 30.8%% downloaded, got %.1f", percent)
+	}
+	for _, update := range updates {
+		if update.Percent < 0 || update.Percent > 100 {
+			t.Errorf("expected the percentage within 0 and 100, got %+v", update)
+		}
+	}
+	if last := updates[len(updates)-1]; last.Percent != 100 || !strings.HasPrefix(last.Status, "Status: Downloaded") {
+		t.Errorf("expected the pull complete, got %+v", last)
+	}
+}
+
+func TestPullQuiet(t *testing.T) {
+	daemon := newFakeDaemon(t)
+	servePull(t, daemon, "alpine:3", "pull.jsonl")
+	daemon.Run()
+	process, err := nescript.NewScript("echo ran").Cmd().Exec(docker.RunExecutor(nil, "alpine:3", docker.WithHost(daemon.URL), docker.WithPullProgress(nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := process.Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.StdOut != "ran\n" {
+		t.Errorf("expected the script run after the pull, got %q", result.StdOut)
+	}
+	if n := daemon.Count("POST /images/create"); n != 1 {
+		t.Errorf("expected the image pulled once, got %d", n)
+	}
+}
+
+func TestPullStreamError(t *testing.T) {
+	daemon := newFakeDaemon(t)
+	servePull(t, daemon, "alpine:3", "pull-manifest-unknown.jsonl")
+	daemon.Run()
+	var updates int
+	progress := docker.WithPullProgress(func(docker.PullProgress) { updates++ })
+	_, err := nescript.NewScript("echo ran").Cmd().Exec(docker.RunExecutor(nil, "alpine:3", docker.WithHost(daemon.URL), progress))
+	if err == nil || !strings.Contains(err.Error(), "manifest unknown") {
+		t.Fatalf("expected the error in the stream returned, got %v", err)
+	}
+	if updates != 3 {
+		t.Errorf("expected the 3 updates before the error, got %d", updates)
+	}
+	if n := daemon.Count("POST /containers/create"); n != 0 {
+		t.Errorf("expected no container created, got %d", n)
+	}
+}
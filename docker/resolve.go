@@ -0,0 +1,87 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+)
+
+const (
+	composeProjectLabel = "com.docker.compose.project"
+	composeServiceLabel = "com.docker.compose.service"
+	composeNumberLabel  = "com.docker.compose.container-number"
+)
+
+// target describes how the container to exec into is found, when not given
+// directly by ID: by the compose labels, or by the name prefix if it is set.
+type target struct {
+	project string
+	service string
+	index   int
+	prefix  string
+}
+
+// resolve finds the ID of the single container matching the target, by its
+// compose labels for a compose target, or otherwise by its name.
+func (t *target) resolve(ctx context.Context, client Client) (string, error) {
+	if t.prefix == "" {
+		args := filters.NewArgs(
+			filters.Arg("label", composeProjectLabel+"="+t.project),
+			filters.Arg("label", composeServiceLabel+"="+t.service),
+		)
+		if t.index > 0 {
+			args.Add("label", composeNumberLabel+"="+strconv.Itoa(t.index))
+		}
+		containers, err := client.ContainerList(ctx, container.ListOptions{Filters: args})
+		if err != nil {
+			return "", fmt.Errorf("failed to list docker containers: %w", err)
+		}
+		description := fmt.Sprintf("compose project '%s' service '%s'", t.project, t.service)
+		if t.index > 0 {
+			description += fmt.Sprintf(" container number %d", t.index)
+		}
+		return t.single(containers, description)
+	}
+	containers, err := client.ContainerList(ctx, container.ListOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to list docker containers: %w", err)
+	}
+	matches := make([]types.Container, 0)
+	for _, c := range containers {
+		for _, name := range c.Names {
+			if strings.HasPrefix(strings.TrimPrefix(name, "/"), t.prefix) {
+				matches = append(matches, c)
+				break
+			}
+		}
+	}
+	return t.single(matches, fmt.Sprintf("name prefix '%s'", t.prefix))
+}
+
+// single returns the ID of the only container given, or an error listing the
+// candidates if there is not exactly one.
+func (t *target) single(containers []types.Container, description string) (string, error) {
+	switch len(containers) {
+	case 0:
+		return "", fmt.Errorf("no containers found matching %s: %w", description, ErrContainerNotFound)
+	case 1:
+		return containers[0].ID, nil
+	}
+	found := make([]string, len(containers))
+	for idx, c := range containers {
+		found[idx] = fmt.Sprintf("%s (%s)", strings.TrimPrefix(strings.Join(c.Names, ","), "/"), shortID(c.ID))
+	}
+	return "", fmt.Errorf("%d containers found matching %s, expected one (found: %s): %w", len(containers), description, strings.Join(found, ", "), ErrAmbiguousContainer)
+}
+
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
@@ -0,0 +1,71 @@
+package docker_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/docker"
+)
+
+func TestComposeTarget(t *testing.T) {
+	daemon := newFakeDaemon(t)
+	containers := []types.Container{
+		{ID: "db1", Names: []string{"/proj-db-1"}, Labels: map[string]string{"com.docker.compose.project": "proj", "com.docker.compose.service": "db", "com.docker.compose.container-number": "1"}},
+		{ID: "db10", Names: []string{"/proj-db-10"}, Labels: map[string]string{"com.docker.compose.project": "proj", "com.docker.compose.service": "db", "com.docker.compose.container-number": "10"}},
+		{ID: "cache", Names: []string{"/proj-cache-1"}},
+		{ID: "legacy", Names: []string{"/legacy-worker"}},
+	}
+	daemon.Handle("GET /containers/json", func(w http.ResponseWriter, r *http.Request) {
+		args, err := filters.FromJSON(r.URL.Query().Get("filters"))
+		if err != nil {
+			daemonError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		matches := make([]types.Container, 0)
+		for _, c := range containers {
+			if args.MatchKVList("label", c.Labels) {
+				matches = append(matches, c)
+			}
+		}
+		writeJSON(w, http.StatusOK, matches)
+	})
+	for _, c := range containers {
+		daemon.Container(c.ID, "CONTAINER="+c.ID)
+	}
+	for _, tc := range []struct {
+		name   string
+		opt    docker.Option
+		stdout string
+		err    error
+	}{
+		{"container number", docker.WithComposeTarget("proj", "db", 1), "db1\n", nil},
+		{"container number prefixing another", docker.WithComposeTarget("proj", "db", 10), "db10\n", nil},
+		{"any container of the service", docker.WithComposeTarget("proj", "db", 0), "", docker.ErrAmbiguousContainer},
+		{"named but not labelled", docker.WithComposeTarget("proj", "cache", 1), "", docker.ErrContainerNotFound},
+		{"name prefix", docker.WithContainerNamePrefix("legacy-"), "legacy\n", nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			process, err := nescript.NewScript("echo $CONTAINER").Cmd().Exec(docker.Executor(nil, "", "", docker.WithHost(daemon.URL), tc.opt))
+			if tc.err != nil {
+				if !errors.Is(err, tc.err) {
+					t.Errorf("expected %v, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := process.Result()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.StdOut != tc.stdout {
+				t.Errorf("expected the script run in the container with %q, got %q", tc.stdout, result.StdOut)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package docker
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/neaas/nescript"
+)
+
+const (
+	// MetadataEnv is the result metadata key holding the effective env the
+	// script process was given, after applying the EnvPolicy.
+	MetadataEnv = "docker.env"
+
+	// MetadataResources is the result metadata key holding the Resources
+	// applied to a script container.
+	MetadataResources = "docker.resources"
+
+	// MetadataRunID is the result metadata key holding the unique ID of the
+	// execution, which the script container was labelled with.
+	MetadataRunID = "docker.runID"
+
+	// MetadataOOMKilled is the result metadata key recording whether the script
+	// container was killed for exceeding its memory limit.
+	MetadataOOMKilled = "docker.oomKilled"
+
+	// MetadataContainerInfo is the result metadata key holding the
+	// ContainerInfo of the script container, inspected once the script
+	// completed.
+	MetadataContainerInfo = "docker.container"
+
+	// MetadataPlatform is the result metadata key holding the platform
+	// (os/arch[/variant]) of the image the script container was created from.
+	MetadataPlatform = "docker.platform"
+
+	// MetadataArtifacts is the result metadata key holding the Artifacts copied
+	// out of the script container.
+	MetadataArtifacts = "docker.artifacts"
+
+	// MetadataTermination is the result metadata key recording how the script
+	// container was terminated.
+	MetadataTermination = "docker.termination"
+)
+
+// Termination describes how a script container came to exit.
+type Termination string
+
+const (
+	// TerminationExited means the script exited of its own accord.
+	TerminationExited Termination = "exited"
+
+	// TerminationStopped means the container was sent the stop signal (see
+	// WithStopSignal), and exited within the stop timeout.
+	TerminationStopped Termination = "stopped"
+
+	// TerminationKilled means the container was killed, either explicitly or
+	// because it did not exit within the stop timeout after being stopped.
+	TerminationKilled Termination = "killed"
+)
+
+// Resources describes the resource limits applied to a script container. A
+// zero value for any field means no limit was applied.
+type Resources struct {
+	MemoryBytes int64   `json:"memoryBytes,omitempty"`
+	CPUs        float64 `json:"cpus,omitempty"`
+	PidsLimit   int64   `json:"pidsLimit,omitempty"`
+}
+
+// resourcesSet reports whether any resource limit has been configured.
+func (r Resources) resourcesSet() bool {
+	return r != Resources{}
+}
+
+// discarded returns the warnings the docker engine gave when creating the
+// container that say one of the limits set was discarded, as the host kernel
+// does not support it, along with the other warnings (such as the memory limit
+// being applied without swap), which do not stop the limits from applying.
+func (r Resources) discarded(warnings []string) (discarded, others []string) {
+	var limits []string
+	if r.MemoryBytes > 0 {
+		limits = append(limits, "memory limit")
+	}
+	if r.CPUs > 0 {
+		limits = append(limits, "cpu cfs")
+	}
+	if r.PidsLimit > 0 {
+		limits = append(limits, "pids limit")
+	}
+	for _, warning := range warnings {
+		lower := strings.ToLower(warning)
+		if strings.Contains(lower, "discarded") && slices.ContainsFunc(limits, func(limit string) bool {
+			return strings.Contains(lower, limit)
+		}) {
+			discarded = append(discarded, warning)
+		} else {
+			others = append(others, warning)
+		}
+	}
+	return discarded, others
+}
+
+// ContainerInfo holds the details the docker engine recorded about the script
+// container, useful for debugging failed scripts. If the container could not be
+// inspected (for example, if it had already been removed), InspectError says
+// why and the other fields are left empty.
+type ContainerInfo struct {
+	OOMKilled    bool      `json:"oomKilled"`
+	Error        string    `json:"error,omitempty"`
+	StartedAt    time.Time `json:"startedAt"`
+	FinishedAt   time.Time `json:"finishedAt"`
+	ImageID      string    `json:"imageID,omitempty"`
+	ImageDigest  string    `json:"imageDigest,omitempty"`
+	Removed      bool      `json:"removed,omitempty"`
+	InspectError string    `json:"inspectError,omitempty"`
+}
+
+// ContainerInfoFrom returns the details of the container a result was produced
+// in. False is returned if the result was not produced by the RunExecutor.
+func ContainerInfoFrom(r *nescript.Result) (ContainerInfo, bool) {
+	info, ok := r.Metadata[MetadataContainerInfo].(ContainerInfo)
+	return info, ok
+}
+
+// ResourcesFrom returns the resource limits that were applied to the container
+// a result was produced in. False is returned if the result was not produced by
+// the RunExecutor.
+func ResourcesFrom(r *nescript.Result) (Resources, bool) {
+	resources, ok := r.Metadata[MetadataResources].(Resources)
+	return resources, ok
+}
+
+// RunIDFrom returns the unique ID of the execution that produced the result,
+// which the script container was labelled with (see LabelRunID). False is
+// returned if the result was not produced by the RunExecutor.
+func RunIDFrom(r *nescript.Result) (string, bool) {
+	runID, ok := r.Metadata[MetadataRunID].(string)
+	return runID, ok
+}
+
+// OOMKilled reports whether the script container was killed by the kernel for
+// exceeding its memory limit, as opposed to exiting with a non-zero code of its
+// own accord.
+func OOMKilled(r *nescript.Result) bool {
+	oomKilled, _ := r.Metadata[MetadataOOMKilled].(bool)
+	return oomKilled
+}
+
+// EnvFrom returns the effective env the script process was given, after the
+// EnvPolicy was applied. False is returned if the result was not produced by a
+// docker executor.
+func EnvFrom(r *nescript.Result) ([]string, bool) {
+	env, ok := r.Metadata[MetadataEnv].([]string)
+	return env, ok
+}
+
+// TerminationFrom returns how the script container a result was produced in
+// was terminated. False is returned if the result was not produced by the
+// RunExecutor.
+func TerminationFrom(r *nescript.Result) (Termination, bool) {
+	termination, ok := r.Metadata[MetadataTermination].(Termination)
+	return termination, ok
+}
+
+// PlatformFrom returns the platform (os/arch[/variant]) of the image the result
+// was produced in. False is returned if the result was not produced by the
+// RunExecutor.
+func PlatformFrom(r *nescript.Result) (string, bool) {
+	platform, ok := r.Metadata[MetadataPlatform].(string)
+	return platform, ok
+}
@@ -0,0 +1,143 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	docker "github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+)
+
+// transientMessages are fragments of engine error messages that indicate a
+// transient failure, even though the engine reports them as internal errors.
+var transientMessages = []string{
+	"connection reset",
+	"connection refused",
+	"broken pipe",
+	"unexpected eof",
+	"i/o timeout",
+	"server is shutting down",
+	"tls handshake timeout",
+}
+
+// IsTransient reports whether an error returned by the docker client is likely
+// to succeed if retried, such as a dropped connection or a restarting engine.
+// Errors the engine returns for invalid requests (not found, conflict, invalid
+// argument, etc...) are never transient.
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errdefs.IsNotFound(err) || errdefs.IsConflict(err) || errdefs.IsInvalidParameter(err) ||
+		errdefs.IsUnauthorized(err) || errdefs.IsForbidden(err) || errdefs.IsNotImplemented(err) ||
+		errdefs.IsNotModified(err) || errdefs.IsCancelled(err) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errdefs.IsUnavailable(err) || docker.IsErrConnectionFailed(err) {
+		return true
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	for _, fragment := range transientMessages {
+		if strings.Contains(msg, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+// RetryError is returned when an operation against the engine failed after
+// being retried. It wraps every error that was encountered, the last being the
+// one that caused the retries to stop.
+type RetryError struct {
+	Operation string
+	Attempts  int
+	Errors    []error
+}
+
+func (e *RetryError) Error() string {
+	last := e.Errors[len(e.Errors)-1]
+	class := "permanent"
+	if IsTransient(last) {
+		class = "transient"
+	}
+	return fmt.Sprintf("%s failed after %d attempt(s), last error was %s: %s", e.Operation, e.Attempts, class, last)
+}
+
+func (e *RetryError) Unwrap() []error {
+	return e.Errors
+}
+
+// noRetry marks an error as one that must not be retried regardless of its
+// class, such as when the script may already have started.
+type noRetry struct {
+	err error
+}
+
+func (e noRetry) Error() string {
+	return e.err.Error()
+}
+
+func (e noRetry) Unwrap() error {
+	return e.err
+}
+
+// retryPolicy retries operations that fail with transient errors, with an
+// exponential backoff between attempts.
+type retryPolicy struct {
+	attempts int
+	backoff  time.Duration
+}
+
+// do runs the operation, retrying it while it fails with a transient error and
+// attempts remain. If the operation is never retried, its error is returned as
+// is, otherwise a *RetryError is returned.
+func (r retryPolicy) do(ctx context.Context, operation string, fn func() error) error {
+	errs := make([]error, 0)
+	backoff := r.backoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		var stop noRetry
+		if errors.As(err, &stop) {
+			err = stop.err
+		}
+		errs = append(errs, err)
+		if attempt >= r.attempts || stop.err != nil || !IsTransient(err) {
+			if attempt == 1 {
+				return err
+			}
+			return &RetryError{
+				Operation: operation,
+				Attempts:  attempt,
+				Errors:    errs,
+			}
+		}
+		select {
+		case <-ctx.Done():
+			errs = append(errs, ctx.Err())
+			return &RetryError{
+				Operation: operation,
+				Attempts:  attempt,
+				Errors:    errs,
+			}
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
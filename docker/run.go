@@ -0,0 +1,336 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/neaas/nescript"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// RunExecutor provides an ExecFunc that will start the script/cmd process in a
+// new docker container created from the given image, much like `docker run`. If
+// the image is not present on the docker engine it is pulled first. The
+// container is removed once the result has been collected (or the process is
+// closed). Every container created is labelled (see LabelManaged), so that any
+// left behind can be found and removed with Cleanup. A docker client (any
+// Client, such as the SDK's *client.Client) may be passed for communication
+// with the relevant docker engine, which the executor does not close. If nil,
+// one is created the first time the ExecFunc is used (see NewClient) and reused
+// by every execution after, until its Connection (see WithConnectionRef) is
+// closed. Both docker and podman engines are supported. Options can be given to
+// configure the container, such as the networks it is attached to. This
+// ExecFunc does not require that the cmd/script be converted to a string, so is
+// Formatter agnostic.
+func RunExecutor(client Client, image string, opts ...Option) nescript.ExecFunc {
+	return connect(client, opts).RunExecutor(image)
+}
+
+func (conn *Connection) runExecutor(image string, o *options) nescript.ExecFunc {
+	return func(c nescript.Cmd) (nescript.Process, error) {
+		ctx := c.Context()
+		if err := o.validate(); err != nil {
+			return nil, err
+		}
+		client, engine, err := conn.getFor(o)
+		if err != nil {
+			return nil, err
+		}
+		if err := o.claimStdin(); err != nil {
+			return nil, err
+		}
+		var platform *ocispec.Platform
+		if o.platform != "" {
+			if err := checkPlatformSupport(conn.apiVersion()); err != nil {
+				return nil, err
+			}
+			platform, _ = parsePlatform(o.platform)
+		}
+		if err := checkNetworks(ctx, client, o.networks); err != nil {
+			return nil, err
+		}
+		if err := ensureImage(ctx, client, image, platform, o.pullProgress); err != nil {
+			return nil, err
+		}
+		target, err := imageDetails(ctx, client, image)
+		if err != nil {
+			return nil, err
+		}
+		if !matchesPlatform(platform, target.platform) {
+			return nil, fmt.Errorf("%w: image '%s' is for platform '%s', not '%s'", ErrPlatformUnsupported, image, target.platform, o.platform)
+		}
+		if err := o.validateFor(target.os); err != nil {
+			return nil, err
+		}
+		runID, err := newRunID()
+		if err != nil {
+			return nil, err
+		}
+		raw, err := adaptShell(c, o, target.os, func() (nescript.Subcommand, error) {
+			return conn.imageShell(ctx, client, image, platform, trackingLabels(runID, c), o.shells)
+		})
+		if err != nil {
+			return nil, err
+		}
+		env, command, err := effectiveEnv(o.envPolicy, target.env, c.Env(), raw)
+		if err != nil {
+			return nil, err
+		}
+		config := &container.Config{
+			Image:        image,
+			Labels:       trackingLabels(runID, c),
+			Entrypoint:   command[:1],
+			Cmd:          command[1:],
+			Env:          env,
+			WorkingDir:   o.workdir,
+			Tty:          false,
+			AttachStdin:  true,
+			AttachStderr: true,
+			AttachStdout: true,
+			OpenStdin:    true,
+			StdinOnce:    true,
+			StopSignal:   o.stopSignal,
+			StopTimeout:  o.stopTimeoutSeconds(),
+		}
+		hostConfig := o.hostConfig()
+		if o.envPolicy == EnvReplace {
+			config.Env = nil
+		}
+		networkingConfig := &network.NetworkingConfig{}
+		if len(o.networks) > 0 {
+			primary := o.networks[0]
+			networkingConfig.EndpointsConfig = map[string]*network.EndpointSettings{
+				primary.name: primary.endpointSettings(),
+			}
+		}
+		var (
+			created   container.CreateResponse
+			attempted bool
+		)
+		err = o.retry.do(ctx, "create container", func() (err error) {
+			// an attempt that failed may still have created the container, which
+			// is used rather than creating another.
+			if attempted {
+				if id, err := runContainer(ctx, client, runID); err != nil || id != "" {
+					created = container.CreateResponse{ID: id}
+					return err
+				}
+			}
+			attempted = true
+			created, err = client.ContainerCreate(ctx, config, hostConfig, networkingConfig, platform, "")
+			return err
+		})
+		if err != nil {
+			if attempted {
+				removeRunContainers(client, runID)
+			}
+			if o.resources.resourcesSet() && errdefs.IsInvalidParameter(err) {
+				return nil, fmt.Errorf("%w: %w", ErrResourceLimitsRejected, err)
+			}
+			if o.gpus != "" && gpuUnavailable(err) {
+				return nil, fmt.Errorf("%w (gpus '%s'): %w", ErrGPUUnavailable, o.gpus, err)
+			}
+			if o.security.securitySet() && (errdefs.IsInvalidParameter(err) || errdefs.IsForbidden(err) || (errdefs.IsSystem(err) && len(o.security.namedIn(err)) > 0)) {
+				return nil, o.security.rejected(err)
+			}
+			if platform != nil && strings.Contains(strings.ToLower(err.Error()), "platform") {
+				return nil, fmt.Errorf("%w: failed to create docker container from image '%s' for platform '%s': %w", ErrPlatformUnsupported, image, o.platform, err)
+			}
+			return nil, fmt.Errorf("failed to create docker container from image '%s': %w", image, err)
+		}
+		process := DockerRunProcess{
+			dockerClient: client,
+			containerID:  created.ID,
+			runID:        runID,
+			resources:    o.resources,
+			env:          env,
+			windows:      target.os == osWindows,
+			platform:     target.platform,
+			artifacts:    o.artifacts,
+			stopSignal:   o.stopSignal,
+			stopTimeout:  o.stopTimeoutSeconds(),
+			complete:     make(chan error, 1),
+			exited:       make(chan struct{}),
+		}
+		discarded, warnings := o.resources.discarded(created.Warnings)
+		if len(discarded) > 0 {
+			process.Close()
+			return nil, fmt.Errorf("%w: %s", ErrResourceLimitsRejected, strings.Join(discarded, "; "))
+		}
+		process.warnings = warnings
+		if len(o.networks) > 1 {
+			for _, n := range o.networks[1:] {
+				if err := client.NetworkConnect(ctx, n.name, process.containerID, n.endpointSettings()); err != nil {
+					process.Close()
+					return nil, fmt.Errorf("failed to connect container to network '%s': %w", n.name, err)
+				}
+			}
+		}
+		attachOptions := container.AttachOptions{
+			Stream: true,
+			Stdin:  true,
+			Stdout: true,
+			Stderr: true,
+		}
+		var hijacked types.HijackedResponse
+		if err := o.retry.do(ctx, "attach to container", func() (err error) {
+			hijacked, err = client.ContainerAttach(ctx, process.containerID, attachOptions)
+			return err
+		}); err != nil {
+			process.Close()
+			return nil, fmt.Errorf("failed to attach to docker container: %w", err)
+		} else {
+			process.dockerConn = &hijacked
+			if o.stdin != nil {
+				process.stdin = pipeStdin(&hijacked, o.stdin)
+			}
+			process.tee = nescript.NewTee(c, &process.stdoutBytes, &process.stderrBytes)
+			go func() {
+				_, err := stdcopy.StdCopy(process.tee.Stdout(), process.tee.Stderr(), hijacked.Reader)
+				process.ended = time.Now()
+				process.tee.Finish(nil)
+				process.complete <- err
+				close(process.exited)
+			}()
+		}
+		// podman does not reliably honor the next-exit condition for a container
+		// that has not yet started, so instead waits for it to stop running once
+		// started.
+		if engine != EnginePodman {
+			process.waitResponse, process.waitErr = client.ContainerWait(context.Background(), process.containerID, container.WaitConditionNextExit)
+		}
+		if err := o.retry.do(ctx, "start container", func() error {
+			err := client.ContainerStart(ctx, process.containerID, container.StartOptions{})
+			if err != nil && containerStarted(ctx, client, process.containerID) {
+				return noRetry{err}
+			}
+			return err
+		}); err != nil {
+			process.Close()
+			if o.gpus != "" && gpuUnavailable(err) {
+				return nil, fmt.Errorf("%w (gpus '%s'): %w", ErrGPUUnavailable, o.gpus, err)
+			}
+			if len(o.security.namedIn(err)) > 0 {
+				return nil, fmt.Errorf("failed to start docker container: %w", o.security.rejected(err))
+			}
+			return nil, fmt.Errorf("failed to start docker container: %w", err)
+		}
+		process.started = time.Now()
+		if engine == EnginePodman {
+			process.waitResponse, process.waitErr = client.ContainerWait(context.Background(), process.containerID, container.WaitConditionNotRunning)
+		}
+		if done := ctx.Done(); done != nil {
+			go func() {
+				select {
+				case <-done:
+					process.Stop()
+				case <-process.exited:
+				}
+			}()
+		}
+		process.completion = nescript.NewCompletion(process.collect)
+		process.completion.Start()
+		return &process, nil
+	}
+}
+
+// containerStarted reports whether the container has (or may have) started,
+// in which case starting it must not be retried. If this can not be
+// determined, the container is assumed to have started.
+func containerStarted(ctx context.Context, client Client, containerID string) bool {
+	inspect, err := client.ContainerInspect(ctx, containerID)
+	if err != nil || inspect.State == nil {
+		return true
+	}
+	started, err := time.Parse(time.RFC3339Nano, inspect.State.StartedAt)
+	return inspect.State.Running || (err == nil && !started.IsZero())
+}
+
+// runContainer returns the ID of the container labelled with the run ID, or
+// an empty ID if there is none.
+func runContainer(ctx context.Context, client Client, runID string) (string, error) {
+	containers, err := client.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", LabelRunID+"="+runID)),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to list docker containers: %w", err)
+	}
+	if len(containers) == 0 {
+		return "", nil
+	}
+	return containers[0].ID, nil
+}
+
+// removeRunContainers removes any container labelled with the run ID, as one
+// may have been created by an attempt that failed.
+func removeRunContainers(client Client, runID string) {
+	containers, err := client.ContainerList(context.Background(), container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", LabelRunID+"="+runID)),
+	})
+	if err != nil {
+		return
+	}
+	for _, c := range containers {
+		client.ContainerRemove(context.Background(), c.ID, container.RemoveOptions{Force: true})
+	}
+}
+
+// checkNetworks ensures that each of the networks the container should be
+// attached to exists, returning an ErrNetworkNotFound if not.
+func checkNetworks(ctx context.Context, client Client, networks []networkAttachment) error {
+	for _, n := range networks {
+		if _, err := client.NetworkInspect(ctx, n.name, types.NetworkInspectOptions{}); err != nil {
+			if errdefs.IsNotFound(err) {
+				return fmt.Errorf("can not attach container to network '%s': %w", n.name, ErrNetworkNotFound)
+			}
+			return fmt.Errorf("failed to inspect docker network '%s': %w", n.name, err)
+		}
+	}
+	return nil
+}
+
+// ensureImage pulls the given image if it is not already present on the docker
+// engine (for the given platform, if not nil), reporting progress to the given
+// func (if not nil).
+func ensureImage(ctx context.Context, client Client, ref string, platform *ocispec.Platform, progressFunc PullProgressFunc) error {
+	if inspect, _, err := client.ImageInspectWithRaw(ctx, ref); err == nil {
+		if matchesPlatform(platform, imagePlatform(inspect)) {
+			return nil
+		}
+	} else if !errdefs.IsNotFound(err) {
+		return fmt.Errorf("failed to inspect docker image '%s': %w", ref, err)
+	}
+	pullOptions := image.PullOptions{}
+	if platform != nil {
+		pullOptions.Platform = formatPlatform(platform.OS, platform.Architecture, platform.Variant)
+	}
+	pull, err := client.ImagePull(ctx, ref, pullOptions)
+	if err != nil {
+		return pullError(ref, pullOptions.Platform, err)
+	}
+	defer pull.Close()
+	if err := readPullStream(pull, progressFunc); err != nil {
+		return pullError(ref, pullOptions.Platform, err)
+	}
+	return nil
+}
+
+// pullError wraps an error pulling an image, identifying errors caused by the
+// image not being available for the requested platform.
+func pullError(ref, platform string, err error) error {
+	if platform != "" && strings.Contains(strings.ToLower(err.Error()), "no matching manifest") {
+		return fmt.Errorf("%w: image '%s' is not available for platform '%s': %w", ErrPlatformUnsupported, ref, platform, err)
+	}
+	return fmt.Errorf("failed to pull docker image '%s': %w", ref, err)
+}
@@ -0,0 +1,232 @@
+package docker_test
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/docker"
+)
+
+func TestRunExecutor(t *testing.T) {
+	daemon := newFakeDaemon(t)
+	daemon.Image("alpine:3", "TZ=UTC")
+	runs := daemon.Run()
+	process, err := nescript.NewScript("echo $TZ $GREETING; echo oops >&2; exit 3").Cmd().WithEnv("GREETING=hi").Exec(docker.RunExecutor(nil, "alpine:3", docker.WithHost(daemon.URL)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := process.Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.StdOut != "UTC hi\n" || result.StdErr != "oops\n" || result.ExitCode != 3 {
+		t.Errorf("expected the container's output and exit code, got %q, %q, %d", result.StdOut, result.StdErr, result.ExitCode)
+	}
+	if live := runs.Live(); len(live) > 0 {
+		t.Errorf("expected the container removed, got %d left", len(live))
+	}
+}
+
+func TestRunExecutorResourceWarnings(t *testing.T) {
+	const (
+		swap = "Your kernel does not support swap limit capabilities or the cgroup is not mounted. Memory limited without swap."
+		pids = "Your kernel does not support pids limit capabilities or the cgroup is not mounted. PIDs limit discarded."
+	)
+	for _, tc := range []struct {
+		name     string
+		opts     []docker.Option
+		warnings []string
+		err      error
+	}{
+		{"limit discarded", []docker.Option{docker.WithPidsLimit(64)}, []string{swap, pids}, docker.ErrResourceLimitsRejected},
+		{"unrelated to the limits set", []docker.Option{docker.WithMemoryLimit(64 * 1024 * 1024)}, []string{swap, pids}, nil},
+		{"no limits set", nil, []string{pids}, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			daemon := newFakeDaemon(t)
+			daemon.Image("alpine:3")
+			runs := daemon.Run()
+			runs.Warnings = tc.warnings
+			opts := append([]docker.Option{docker.WithHost(daemon.URL)}, tc.opts...)
+			process, err := nescript.NewCmd("true").Exec(docker.RunExecutor(nil, "alpine:3", opts...))
+			if tc.err != nil {
+				if !errors.Is(err, tc.err) {
+					t.Errorf("expected %v, got %v", tc.err, err)
+				} else if strings.Contains(err.Error(), swap) {
+					t.Errorf("expected only the discarded limit in the error, got %v", err)
+				}
+				if live := runs.Live(); len(live) > 0 {
+					t.Errorf("expected the container removed, got %d left", len(live))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := process.Result()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if warnings, _ := result.Metadata[nescript.MetadataWarnings].([]string); !slices.Equal(warnings, tc.warnings) {
+				t.Errorf("expected the warnings %q recorded on the result, got %q", tc.warnings, warnings)
+			}
+		})
+	}
+}
+
+func TestRunProcessKill(t *testing.T) {
+	daemon := newFakeDaemon(t)
+	daemon.Image("alpine:3")
+	runs := daemon.Run()
+	runs.KillError = "cannot kill container: permission denied"
+	executor := docker.RunExecutor(nil, "alpine:3", docker.WithHost(daemon.URL))
+
+	process, err := nescript.NewCmd("sleep", "0.5").Exec(executor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := process.Kill(); err == nil {
+		t.Fatal("expected the kill to fail")
+	}
+	result, err := process.Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if termination, _ := docker.TerminationFrom(result); termination != docker.TerminationExited {
+		t.Errorf("expected a failed kill not recorded, got %s", termination)
+	}
+
+	runs.mu.Lock()
+	runs.KillError = ""
+	runs.mu.Unlock()
+	process, err = nescript.NewCmd("sleep", "30").Exec(executor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := process.Kill(); err != nil {
+		t.Fatal(err)
+	}
+	result, err = process.Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if termination, _ := docker.TerminationFrom(result); termination != docker.TerminationKilled {
+		t.Errorf("expected the container killed, got %s", termination)
+	}
+}
+
+func TestRunExecutorSecurityRejected(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		opts    []docker.Option
+		route   string
+		status  int
+		message string
+		named   string
+	}{
+		{"capability refused on create", []docker.Option{docker.WithCapAdd("net_admin")}, "POST /containers/create", http.StatusBadRequest, `invalid CapAdd: unknown capability: "CAP_NET_ADMIN"`, "capability net_admin"},
+		{"unrelated create failure", []docker.Option{docker.WithCapAdd("NET_ADMIN")}, "POST /containers/create", http.StatusInternalServerError, "failed to allocate network: address pool exhausted", ""},
+		{"security opt refused on start", []docker.Option{docker.WithSecurityOpt("seccomp=/etc/profile.json")}, "POST /containers/container-0/start", http.StatusInternalServerError, "opening seccomp profile (/etc/profile.json) failed", "security opt seccomp=/etc/profile.json"},
+		{"another device on start", []docker.Option{docker.WithDevices("/dev/sd")}, "POST /containers/container-0/start", http.StatusInternalServerError, `error gathering device information while adding custom device "/dev/sda": no such file or directory`, ""},
+		{"capability as part of a word on start", []docker.Option{docker.WithCapDrop("KILL")}, "POST /containers/container-0/start", http.StatusInternalServerError, "OCI runtime create failed: container was KILLED by the runtime", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			daemon := newFakeDaemon(t)
+			daemon.Image("alpine:3")
+			daemon.Run()
+			daemon.Handle(tc.route, func(w http.ResponseWriter, r *http.Request) {
+				daemonError(w, tc.status, tc.message)
+			})
+			opts := append([]docker.Option{docker.WithHost(daemon.URL)}, tc.opts...)
+			_, err := nescript.NewCmd("true").Exec(docker.RunExecutor(nil, "alpine:3", opts...))
+			if err == nil {
+				t.Fatal("expected the execution to fail")
+			}
+			if tc.named == "" {
+				if errors.Is(err, docker.ErrSecurityOptionsRejected) {
+					t.Errorf("expected the failure not attributed to the security settings, got %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, docker.ErrSecurityOptionsRejected) || !strings.Contains(err.Error(), "("+tc.named+")") {
+				t.Errorf("expected ErrSecurityOptionsRejected naming %s, got %v", tc.named, err)
+			}
+		})
+	}
+}
+
+func TestRunExecutorCreateRetry(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		attempts int
+		fails    bool
+	}{
+		{"created by a failed attempt", 3, false},
+		{"not retried", 1, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			daemon := newFakeDaemon(t)
+			daemon.Image("alpine:3")
+			runs := daemon.Run()
+			runs.CreateFailures = 1
+			executor := docker.RunExecutor(nil, "alpine:3", docker.WithHost(daemon.URL), docker.WithRetry(tc.attempts, time.Millisecond))
+			process, err := nescript.NewCmd("echo", "ran").Exec(executor)
+			if tc.fails {
+				if err == nil {
+					t.Fatal("expected the execution to fail")
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if result, err := process.Result(); err != nil || result.StdOut != "ran\n" {
+					t.Errorf("expected the script run, got %v: %v", result, err)
+				}
+			}
+			if n := len(runs.Containers()); n != 1 {
+				t.Errorf("expected the container created by the failed attempt used, got %d created", n)
+			}
+			if live := runs.Live(); len(live) > 0 {
+				t.Errorf("expected no containers left behind, got %d", len(live))
+			}
+		})
+	}
+}
+
+func TestRunExecutorShellProbe(t *testing.T) {
+	daemon := newFakeDaemon(t)
+	daemon.Image("alpine:3")
+	runs := daemon.Run()
+	executor := docker.RunExecutor(nil, "alpine:3", docker.WithHost(daemon.URL), docker.WithPlatform("linux/amd64"), docker.WithShellDetection())
+	for range 2 {
+		process, err := nescript.NewScript("echo ran").Cmd().Exec(executor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result, err := process.Result(); err != nil || result.StdOut != "ran\n" {
+			t.Fatalf("expected the script run, got %v: %v", result, err)
+		}
+	}
+	containers := runs.Containers()
+	if len(containers) != 3 {
+		t.Fatalf("expected one probe container and two script containers, got %d", len(containers))
+	}
+	probe := containers[0]
+	if probe.Config.Entrypoint[0] != "/nescript-probe" {
+		t.Fatalf("expected the probe created first, got %q", probe.Config.Entrypoint)
+	}
+	if probe.Platform != "linux/amd64" {
+		t.Errorf("expected the probe created for the platform, got %q", probe.Platform)
+	}
+	if probe.Config.Labels[docker.LabelManaged] != "true" || probe.Config.Labels[docker.LabelRunID] != containers[1].Config.Labels[docker.LabelRunID] {
+		t.Errorf("expected the probe labelled like the script container, got %v", probe.Config.Labels)
+	}
+	if live := runs.Live(); len(live) > 0 {
+		t.Errorf("expected no containers left behind, got %d", len(live))
+	}
+}
@@ -0,0 +1,291 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/errdefs"
+	"github.com/neaas/nescript"
+)
+
+// DockerRunProcess represents a single instance of the script running or
+// completed in a container created by the RunExecutor.
+type DockerRunProcess struct {
+	dockerClient Client
+	dockerConn   *types.HijackedResponse
+	containerID  string
+	runID        string
+	resources    Resources
+	env          []string
+	windows      bool
+	platform     string
+	artifacts    []artifactSpec
+	warnings     []string
+	stopSignal   string
+	stopTimeout  *int
+	stdin        *stdinPipe
+	stdoutBytes  bytes.Buffer
+	stderrBytes  bytes.Buffer
+	complete     chan error
+	exited       chan struct{}
+	waitResponse <-chan container.WaitResponse
+	waitErr      <-chan error
+	removeOnce   sync.Once
+	started      time.Time
+	ended        time.Time
+	tee          *nescript.Tee
+	completion   *nescript.Completion
+
+	mu          sync.Mutex
+	termination Termination
+}
+
+// ContainerID returns the ID of the container the script is running in.
+func (p *DockerRunProcess) ContainerID() string {
+	return p.containerID
+}
+
+// RunID returns the unique ID of the execution, which the container is also
+// labelled with.
+func (p *DockerRunProcess) RunID() string {
+	return p.runID
+}
+
+func (p *DockerRunProcess) Kill() error {
+	select {
+	case <-p.completion.Done():
+		return nil
+	default:
+	}
+	// the termination is recorded before the kill, as the container may exit
+	// (and the result be collected) before the kill returns, and dropped again
+	// if the kill fails.
+	recorded := p.terminated(TerminationKilled)
+	if err := p.dockerClient.ContainerKill(context.Background(), p.containerID, "KILL"); err != nil {
+		if recorded {
+			p.mu.Lock()
+			p.termination = ""
+			p.mu.Unlock()
+		}
+		return fmt.Errorf("failed to kill container: %w", err)
+	}
+	return nil
+}
+
+func (p *DockerRunProcess) Signal(s os.Signal) error {
+	signal := s.String()
+	if sig, ok := s.(syscall.Signal); ok {
+		signal = strconv.Itoa(int(sig))
+	}
+	if err := p.dockerClient.ContainerKill(context.Background(), p.containerID, signal); err != nil {
+		return fmt.Errorf("failed to send signal to container: %w", err)
+	}
+	return nil
+}
+
+// Stop sends the stop signal (see WithStopSignal) to the container, waiting up
+// to the stop timeout (see WithStopTimeout) for it to exit before killing it.
+// This is also used to terminate the container if the cmd context is
+// cancelled.
+func (p *DockerRunProcess) Stop() error {
+	p.terminated(TerminationStopped)
+	stopOptions := container.StopOptions{
+		Signal:  p.stopSignal,
+		Timeout: p.stopTimeout,
+	}
+	if err := p.dockerClient.ContainerStop(context.Background(), p.containerID, stopOptions); err != nil {
+		return fmt.Errorf("failed to stop container: %w", err)
+	}
+	return nil
+}
+
+// terminated records the first way the container was asked to terminate,
+// reporting whether it was this one.
+func (p *DockerRunProcess) terminated(termination Termination) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.termination == "" {
+		p.termination = termination
+		return true
+	}
+	return false
+}
+
+// terminationFor determines how the container was terminated, given its
+// exit code. A stopped container exiting with 137 (128 + SIGKILL) did not exit
+// within the stop timeout, so was killed.
+func (p *DockerRunProcess) terminationFor(exitCode int) Termination {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch {
+	case p.termination == "":
+		return TerminationExited
+	case p.termination == TerminationStopped && exitCode == 128+int(syscall.SIGKILL) && !isKillSignal(p.stopSignal):
+		return TerminationKilled
+	default:
+		return p.termination
+	}
+}
+
+// isKillSignal reports whether the stop signal given is SIGKILL, in which case
+// being killed is the expected result of stopping the container.
+func isKillSignal(signal string) bool {
+	switch strings.TrimPrefix(strings.ToUpper(signal), "SIG") {
+	case "KILL", "9":
+		return true
+	}
+	return false
+}
+
+func (p *DockerRunProcess) Write(input string) error {
+	if _, err := p.dockerConn.Conn.Write([]byte(input)); err != nil {
+		return fmt.Errorf("failed to write to container stdin: %w", err)
+	}
+	return nil
+}
+
+func (p *DockerRunProcess) Stdin() io.WriteCloser {
+	return nescript.StdinWriter(p)
+}
+
+func (p *DockerRunProcess) Stdout() io.Reader {
+	return p.tee.StdoutStream()
+}
+
+func (p *DockerRunProcess) Stderr() io.Reader {
+	return p.tee.StderrStream()
+}
+
+// ID returns the ID of the container the script is running in.
+func (p *DockerRunProcess) ID() string {
+	return p.containerID
+}
+
+func (p *DockerRunProcess) String() string {
+	return fmt.Sprintf("docker container %s", p.containerID)
+}
+
+func (p *DockerRunProcess) Wait(ctx context.Context) (*nescript.Result, error) {
+	return nescript.Wait(ctx, p)
+}
+
+func (p *DockerRunProcess) Result() (*nescript.Result, error) {
+	return p.completion.Result()
+}
+
+func (p *DockerRunProcess) Poll() (*nescript.Result, bool) {
+	return p.completion.Poll()
+}
+
+func (p *DockerRunProcess) WaitContext(ctx context.Context) (*nescript.Result, error) {
+	return p.completion.WaitContext(ctx)
+}
+
+// collect waits for the script to exit, collecting its result (see
+// nescript.Completion).
+func (p *DockerRunProcess) collect() (*nescript.Result, error) {
+	defer p.Close()
+	if err := <-p.complete; err != nil {
+		return nil, fmt.Errorf("failed to wait for docker process: %w", err)
+	}
+	p.dockerConn.Close()
+	if err := p.stdin.wait(); err != nil {
+		return nil, err
+	}
+	var exitCode int
+	select {
+	case res := <-p.waitResponse:
+		if res.Error != nil {
+			return nil, fmt.Errorf("failed to wait for docker container: %s", res.Error.Message)
+		}
+		exitCode = int(res.StatusCode)
+	case err := <-p.waitErr:
+		return nil, fmt.Errorf("failed to wait for docker container: %w", err)
+	}
+	result := nescript.Result{
+		StdOut: string(p.stdoutBytes.String()),
+		StdErr: string(p.stderrBytes.String()),
+	}
+	result.ExitCode = exitCode
+	if p.windows {
+		result.StdOut = normalizeWindowsOutput(result.StdOut)
+		result.StdErr = normalizeWindowsOutput(result.StdErr)
+		result.ExitCode = windowsExitCode(result.ExitCode)
+	}
+	result.SetTimes(p.started, p.ended)
+	result.SetMetadata(nescript.MetadataExecutor, "docker")
+	if len(p.warnings) > 0 {
+		result.SetMetadata(nescript.MetadataWarnings, p.warnings)
+	}
+	result.SetMetadata(MetadataEnv, p.env)
+	result.SetMetadata(MetadataRunID, p.runID)
+	result.SetMetadata(MetadataPlatform, p.platform)
+	termination := p.terminationFor(exitCode)
+	result.SetMetadata(MetadataTermination, termination)
+	if p.resources.resourcesSet() {
+		result.SetMetadata(MetadataResources, p.resources)
+	}
+	info := p.containerInfo(context.Background())
+	result.SetMetadata(MetadataContainerInfo, info)
+	result.SetMetadata(MetadataOOMKilled, info.OOMKilled)
+	// the engine reports a container terminated by a signal with an exit code
+	// of 128 + the signal number, which a script could also exit with, so the
+	// signal is only recorded when the container is known to have been
+	// signalled.
+	if signalled := termination != TerminationExited || info.OOMKilled; signalled && !p.windows && exitCode > 128 {
+		result.SetSignal(exitCode - 128)
+	}
+	if len(p.artifacts) > 0 {
+		result.SetMetadata(MetadataArtifacts, copyArtifacts(context.Background(), p.dockerClient, p.containerID, p.artifacts))
+	}
+	p.tee.Finish(&result)
+	return &result, nil
+}
+
+// Close detaches from and removes the container, killing the script if it is
+// still running.
+func (p *DockerRunProcess) Close() {
+	if p.dockerConn != nil {
+		p.dockerConn.Close()
+	}
+	p.removeOnce.Do(func() {
+		p.dockerClient.ContainerRemove(context.Background(), p.containerID, container.RemoveOptions{Force: true})
+	})
+}
+
+// containerInfo inspects the (exited) container. Failing to inspect it does not
+// fail the execution, instead the reason is recorded on the info.
+func (p *DockerRunProcess) containerInfo(ctx context.Context) ContainerInfo {
+	inspect, err := p.dockerClient.ContainerInspect(ctx, p.containerID)
+	if err != nil {
+		return ContainerInfo{
+			Removed:      errdefs.IsNotFound(err),
+			InspectError: err.Error(),
+		}
+	}
+	info := ContainerInfo{}
+	if inspect.ContainerJSONBase == nil {
+		return info
+	}
+	info.ImageID = inspect.Image
+	if state := inspect.State; state != nil {
+		info.OOMKilled = state.OOMKilled
+		info.Error = state.Error
+		info.StartedAt, _ = time.Parse(time.RFC3339Nano, state.StartedAt)
+		info.FinishedAt, _ = time.Parse(time.RFC3339Nano, state.FinishedAt)
+	}
+	if image, _, err := p.dockerClient.ImageInspectWithRaw(ctx, inspect.Image); err == nil && len(image.RepoDigests) > 0 {
+		info.ImageDigest = image.RepoDigests[0]
+	}
+	return info
+}
@@ -0,0 +1,220 @@
+package docker
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// capabilities are the linux capability names accepted by WithCapAdd and
+// WithCapDrop, without the CAP_ prefix.
+var capabilities = map[string]bool{
+	"ALL":                true,
+	"AUDIT_CONTROL":      true,
+	"AUDIT_READ":         true,
+	"AUDIT_WRITE":        true,
+	"BLOCK_SUSPEND":      true,
+	"BPF":                true,
+	"CHECKPOINT_RESTORE": true,
+	"CHOWN":              true,
+	"DAC_OVERRIDE":       true,
+	"DAC_READ_SEARCH":    true,
+	"FOWNER":             true,
+	"FSETID":             true,
+	"IPC_LOCK":           true,
+	"IPC_OWNER":          true,
+	"KILL":               true,
+	"LEASE":              true,
+	"LINUX_IMMUTABLE":    true,
+	"MAC_ADMIN":          true,
+	"MAC_OVERRIDE":       true,
+	"MKNOD":              true,
+	"NET_ADMIN":          true,
+	"NET_BIND_SERVICE":   true,
+	"NET_BROADCAST":      true,
+	"NET_RAW":            true,
+	"PERFMON":            true,
+	"SETFCAP":            true,
+	"SETGID":             true,
+	"SETPCAP":            true,
+	"SETUID":             true,
+	"SYSLOG":             true,
+	"SYS_ADMIN":          true,
+	"SYS_BOOT":           true,
+	"SYS_CHROOT":         true,
+	"SYS_MODULE":         true,
+	"SYS_NICE":           true,
+	"SYS_PACCT":          true,
+	"SYS_PTRACE":         true,
+	"SYS_RAWIO":          true,
+	"SYS_RESOURCE":       true,
+	"SYS_TIME":           true,
+	"SYS_TTY_CONFIG":     true,
+	"WAKE_ALARM":         true,
+}
+
+// Security describes the privileges given to (or taken from) a script
+// container.
+type Security struct {
+	Privileged  bool     `json:"privileged,omitempty"`
+	CapAdd      []string `json:"capAdd,omitempty"`
+	CapDrop     []string `json:"capDrop,omitempty"`
+	Devices     []string `json:"devices,omitempty"`
+	SecurityOpt []string `json:"securityOpt,omitempty"`
+}
+
+// securitySet reports whether any security setting has been configured.
+func (s Security) securitySet() bool {
+	return s.Privileged || len(s.CapAdd) > 0 || len(s.CapDrop) > 0 || len(s.Devices) > 0 || len(s.SecurityOpt) > 0
+}
+
+// validate ensures the capability names are known and devices are in the
+// expected format.
+func (s Security) validate() error {
+	for _, capability := range append(append([]string{}, s.CapAdd...), s.CapDrop...) {
+		if !capabilities[normalizeCapability(capability)] {
+			return fmt.Errorf("unknown linux capability '%s'", capability)
+		}
+	}
+	for _, device := range s.Devices {
+		if _, err := parseDevice(device); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply sets the security settings on the host config. The settings must have
+// been validated first.
+func (s Security) apply(hostConfig *container.HostConfig) {
+	hostConfig.Privileged = s.Privileged
+	hostConfig.SecurityOpt = s.SecurityOpt
+	for _, capability := range s.CapAdd {
+		hostConfig.CapAdd = append(hostConfig.CapAdd, normalizeCapability(capability))
+	}
+	for _, capability := range s.CapDrop {
+		hostConfig.CapDrop = append(hostConfig.CapDrop, normalizeCapability(capability))
+	}
+	for _, device := range s.Devices {
+		mapping, _ := parseDevice(device)
+		hostConfig.Devices = append(hostConfig.Devices, mapping)
+	}
+}
+
+// rejected wraps an error from the docker engine refusing to create or start
+// the container, naming the security settings that the error names (or all of
+// them, if none can be identified).
+func (s Security) rejected(err error) error {
+	named := s.namedIn(err)
+	if len(named) == 0 {
+		named = append(named, s.String())
+	}
+	return fmt.Errorf("%w (%s): %w", ErrSecurityOptionsRejected, strings.Join(named, ", "), err)
+}
+
+// namedIn returns the security settings that the error names exactly: a
+// capability by its name (with or without the CAP_ prefix), a device by its
+// host path and a security opt by the whole option or its value (such as a
+// seccomp profile), each as a word of its own rather than part of a longer one.
+func (s Security) namedIn(err error) []string {
+	message := err.Error()
+	named := make([]string, 0)
+	if s.Privileged && mentions(strings.ToLower(message), "privileged") {
+		named = append(named, "privileged")
+	}
+	for _, capability := range append(append([]string{}, s.CapAdd...), s.CapDrop...) {
+		name := normalizeCapability(capability)
+		if mentions(message, "CAP_"+name) || mentions(message, name) {
+			named = append(named, "capability "+capability)
+		}
+	}
+	for _, device := range s.Devices {
+		if mapping, _ := parseDevice(device); mentions(message, mapping.PathOnHost) {
+			named = append(named, "device "+device)
+		}
+	}
+	for _, opt := range s.SecurityOpt {
+		_, value, _ := strings.Cut(opt, "=")
+		if mentions(message, opt) || (value != "" && mentions(message, value)) {
+			named = append(named, "security opt "+opt)
+		}
+	}
+	return named
+}
+
+// mentions reports whether the name appears in the message as a word of its
+// own, that is not preceded or followed by a character that could be part of
+// the name.
+func mentions(message, name string) bool {
+	for i := 0; ; {
+		j := strings.Index(message[i:], name)
+		if j < 0 {
+			return false
+		}
+		start, end := i+j, i+j+len(name)
+		if (start == 0 || !isNameByte(message[start-1])) && (end == len(message) || !isNameByte(message[end])) {
+			return true
+		}
+		i = start + 1
+	}
+}
+
+// isNameByte reports whether the byte can be part of a capability name, device
+// path or security opt.
+func isNameByte(b byte) bool {
+	return b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' || strings.IndexByte("_-/=", b) >= 0
+}
+
+// String summarises the security settings.
+func (s Security) String() string {
+	parts := make([]string, 0)
+	if s.Privileged {
+		parts = append(parts, "privileged")
+	}
+	if len(s.CapAdd) > 0 {
+		parts = append(parts, "cap-add "+strings.Join(s.CapAdd, ","))
+	}
+	if len(s.CapDrop) > 0 {
+		parts = append(parts, "cap-drop "+strings.Join(s.CapDrop, ","))
+	}
+	if len(s.Devices) > 0 {
+		parts = append(parts, "devices "+strings.Join(s.Devices, ","))
+	}
+	if len(s.SecurityOpt) > 0 {
+		parts = append(parts, "security-opt "+strings.Join(s.SecurityOpt, ","))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// normalizeCapability converts a capability name into the upper case form
+// without the CAP_ prefix.
+func normalizeCapability(capability string) string {
+	return strings.TrimPrefix(strings.ToUpper(capability), "CAP_")
+}
+
+// parseDevice parses a device mapping in the same format as `docker run
+// --device`, host-path[:container-path[:permissions]].
+func parseDevice(device string) (container.DeviceMapping, error) {
+	parts := strings.Split(device, ":")
+	if len(parts) > 3 || parts[0] == "" {
+		return container.DeviceMapping{}, fmt.Errorf("invalid device mapping '%s'", device)
+	}
+	mapping := container.DeviceMapping{
+		PathOnHost:        parts[0],
+		PathInContainer:   parts[0],
+		CgroupPermissions: "rwm",
+	}
+	if len(parts) > 1 && parts[1] != "" {
+		mapping.PathInContainer = parts[1]
+	}
+	if len(parts) > 2 {
+		for _, permission := range parts[2] {
+			if !strings.ContainsRune("rwm", permission) {
+				return container.DeviceMapping{}, fmt.Errorf("invalid device permissions '%s' in mapping '%s'", parts[2], device)
+			}
+		}
+		mapping.CgroupPermissions = parts[2]
+	}
+	return mapping, nil
+}
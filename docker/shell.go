@@ -0,0 +1,148 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/errdefs"
+	"github.com/neaas/nescript"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Shell is a shell that may be available inside a container, along with how a
+// script is invoked with it.
+type Shell struct {
+	// Paths are the locations the shell binary may be found at, in order of
+	// preference.
+	Paths []string
+
+	// Subcommand is how a script is invoked with the shell. The first element
+	// is replaced with the path the shell binary was found at.
+	Subcommand nescript.Subcommand
+}
+
+var (
+	// DefaultShells is the preference order used when detecting the shell
+	// available in a container (see WithShellDetection).
+	DefaultShells = []Shell{
+		{Paths: []string{"/bin/bash", "/usr/bin/bash"}, Subcommand: nescript.SCBash},
+		{Paths: []string{"/bin/sh", "/usr/bin/sh"}, Subcommand: nescript.SCShell},
+		{Paths: []string{"/bin/busybox", "/usr/bin/busybox"}, Subcommand: nescript.Subcommand{"busybox", "sh", "-c"}},
+	}
+)
+
+// shellCache holds the shell detected for each container/image, so that each
+// is only probed once per connection.
+type shellCache struct {
+	mu     sync.Mutex
+	shells map[string]nescript.Subcommand
+}
+
+func (sc *shellCache) get(key string) (nescript.Subcommand, bool) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	shell, ok := sc.shells[key]
+	return shell, ok
+}
+
+func (sc *shellCache) set(key string, shell nescript.Subcommand) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.shells == nil {
+		sc.shells = make(map[string]nescript.Subcommand)
+	}
+	sc.shells[key] = shell
+}
+
+// adaptShell re-wraps a cmd created from a script so that it is invoked with a
+// shell available in the container. An explicit shell (WithContainerShell)
+// always wins, otherwise for windows containers the windows shell is used, and
+// for others, if detection is enabled, the detect func is used to find one.
+// Cmds not created from a script are returned as is.
+func adaptShell(c nescript.Cmd, o *options, osType string, detect func() (nescript.Subcommand, error)) ([]string, error) {
+	_, script, trailing, ok := c.Script()
+	if !ok {
+		return c.Raw(), nil
+	}
+	shell := o.shell
+	if shell == nil && osType == osWindows {
+		return windowsInvocation(o.windowsShell, script, trailing), nil
+	}
+	if shell == nil {
+		if o.shells == nil {
+			return c.Raw(), nil
+		}
+		var err error
+		if shell, err = detect(); err != nil {
+			return nil, err
+		}
+	}
+	command := append(append([]string{}, shell...), script)
+	return append(command, trailing...), nil
+}
+
+// detectShell finds the most preferred shell present in the container, by
+// checking for each candidate path in the container's file system.
+func detectShell(ctx context.Context, client Client, containerID string, shells []Shell) (nescript.Subcommand, bool, error) {
+	for _, shell := range shells {
+		for _, path := range shell.Paths {
+			if _, err := client.ContainerStatPath(ctx, containerID, path); err == nil {
+				return append(nescript.Subcommand{path}, shell.Subcommand[1:]...), true, nil
+			} else if !errdefs.IsNotFound(err) {
+				return nil, false, fmt.Errorf("failed to probe container '%s' for a shell: %w", containerID, err)
+			}
+		}
+	}
+	return nil, false, nil
+}
+
+// containerShell detects (and caches) the shell available in a running
+// container.
+func (conn *Connection) containerShell(ctx context.Context, client Client, containerID string, shells []Shell) (nescript.Subcommand, error) {
+	key := "container:" + containerID
+	if shell, ok := conn.shells.get(key); ok {
+		return shell, nil
+	}
+	shell, ok, err := detectShell(ctx, client, containerID, shells)
+	if err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, fmt.Errorf("no usable shell found in container '%s': %w", containerID, ErrNoShell)
+	}
+	conn.shells.set(key, shell)
+	return shell, nil
+}
+
+// imageShell detects (and caches) the shell available in an image, for the
+// given platform (if not nil). As files can only be checked for in a
+// container, a probe container is created (but never started) from the image
+// for the platform, with the labels of the execution (so that Cleanup finds it
+// if it is left behind), and removed afterwards.
+func (conn *Connection) imageShell(ctx context.Context, client Client, image string, platform *ocispec.Platform, labels map[string]string, shells []Shell) (nescript.Subcommand, error) {
+	key := "image:" + image
+	if platform != nil {
+		key += "@" + formatPlatform(platform.OS, platform.Architecture, platform.Variant)
+	}
+	if shell, ok := conn.shells.get(key); ok {
+		return shell, nil
+	}
+	probe, err := client.ContainerCreate(ctx, &container.Config{
+		Image:      image,
+		Entrypoint: []string{"/nescript-probe"},
+		Labels:     labels,
+	}, nil, nil, platform, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create shell probe container from image '%s': %w", image, err)
+	}
+	defer client.ContainerRemove(context.Background(), probe.ID, container.RemoveOptions{Force: true})
+	shell, ok, err := detectShell(ctx, client, probe.ID, shells)
+	if err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, fmt.Errorf("no usable shell found in image '%s': %w", image, ErrNoShell)
+	}
+	conn.shells.set(key, shell)
+	return shell, nil
+}
@@ -0,0 +1,131 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	defaultSSHPort   = "22"
+	defaultSSHSocket = "/var/run/docker.sock"
+)
+
+// sshDialer tunnels connections to the remote engine's unix socket over a
+// single (lazily established) SSH connection, in the same way as the docker
+// CLI does for ssh:// hosts, however without requiring the ssh binary.
+type sshDialer struct {
+	address string
+	socket  string
+	config  *ssh.ClientConfig
+
+	mu     sync.Mutex
+	client *ssh.Client
+}
+
+// newSSHDialer creates a dialer for a host in the form
+// ssh://[user@]host[:port][/path/to/docker.sock].
+func newSSHDialer(host string, o *options) (*sshDialer, error) {
+	hostURL, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ssh docker host '%s': %w", host, err)
+	}
+	config, err := o.sshClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	if hostURL.User != nil && hostURL.User.Username() != "" {
+		config.User = hostURL.User.Username()
+	}
+	if config.User == "" {
+		return nil, fmt.Errorf("no user given for ssh docker host '%s'", host)
+	}
+	port := hostURL.Port()
+	if port == "" {
+		port = defaultSSHPort
+	}
+	socket := hostURL.Path
+	if socket == "" || socket == "/" {
+		socket = defaultSSHSocket
+	}
+	return &sshDialer{
+		address: net.JoinHostPort(hostURL.Hostname(), port),
+		socket:  socket,
+		config:  config,
+	}, nil
+}
+
+// DialContext opens a connection to the remote engine socket. If the SSH
+// connection has been lost since the last dial, it is re-established.
+func (d *sshDialer) DialContext(ctx context.Context, _, _ string) (net.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.client != nil {
+		if conn, err := d.client.DialContext(ctx, "unix", d.socket); err == nil {
+			return conn, nil
+		}
+		d.client.Close()
+		d.client = nil
+	}
+	netConn, err := (&net.Dialer{}).DialContext(ctx, "tcp", d.address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to ssh target '%s': %w", d.address, err)
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(netConn, d.address, d.config)
+	if err != nil {
+		netConn.Close()
+		return nil, fmt.Errorf("failed to establish ssh connection to '%s': %w", d.address, err)
+	}
+	d.client = ssh.NewClient(sshConn, chans, reqs)
+	conn, err := d.client.DialContext(ctx, "unix", d.socket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to docker socket '%s' on '%s': %w", d.socket, d.address, err)
+	}
+	return conn, nil
+}
+
+// sshClientConfig builds the SSH client config from the options. If a full
+// config was given, a copy of it is used as is. Otherwise the config is built
+// from the private key and known_hosts file given (defaulting to
+// ~/.ssh/known_hosts).
+func (o *options) sshClientConfig() (*ssh.ClientConfig, error) {
+	if o.sshConfig != nil {
+		config := *o.sshConfig
+		return &config, nil
+	}
+	config := &ssh.ClientConfig{}
+	if o.sshKey != nil {
+		var signer ssh.Signer
+		var err error
+		if o.sshKeyPassphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(o.sshKey, []byte(o.sshKeyPassphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey(o.sshKey)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse ssh private key: %w", err)
+		}
+		config.Auth = append(config.Auth, ssh.PublicKeys(signer))
+	}
+	knownHostsPath := o.sshKnownHosts
+	if knownHostsPath == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to find known_hosts file: %w", err)
+		}
+		knownHostsPath = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeyCallback, err := knownhosts.New(knownHostsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load known_hosts file '%s': %w", knownHostsPath, err)
+	}
+	config.HostKeyCallback = hostKeyCallback
+	return config, nil
+}
@@ -0,0 +1,77 @@
+package docker
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/docker/docker/api/types"
+)
+
+// stdinPipe copies a reader into the stdin of a docker process.
+type stdinPipe struct {
+	done    chan struct{}
+	readErr error
+}
+
+// errReader records the error (other than EOF) returned by the reader, so that
+// failures to read the input can be told apart from failures to write it,
+// which happen as a matter of course when a script exits without reading all
+// of its input.
+type errReader struct {
+	reader io.Reader
+	err    error
+}
+
+func (r *errReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+// pipeStdin starts copying the reader into the hijacked connection, closing the
+// write side of the connection once the reader is exhausted so that the script
+// receives EOF. The copy is marked done before the connection is closed, so
+// that a script ending once it receives EOF always sees the outcome of the
+// copy.
+func pipeStdin(conn *types.HijackedResponse, stdin io.Reader) *stdinPipe {
+	pipe := &stdinPipe{
+		done: make(chan struct{}),
+	}
+	go func() {
+		reader := &errReader{reader: stdin}
+		io.Copy(conn.Conn, reader)
+		pipe.readErr = reader.err
+		close(pipe.done)
+		conn.CloseWrite()
+	}()
+	return pipe
+}
+
+// wait returns an error if the input could not be read. It is called once the
+// script has exited, so does not wait for a reader that has not returned (such
+// as os.Stdin, which may never return), whose copy ends once its read returns.
+func (p *stdinPipe) wait() error {
+	if p == nil {
+		return nil
+	}
+	select {
+	case <-p.done:
+	default:
+		return nil
+	}
+	if p.readErr != nil {
+		return fmt.Errorf("failed to read script stdin: %w", p.readErr)
+	}
+	return nil
+}
+
+// claimStdin claims the stdin reader (if any) for an execution, as it can only
+// be consumed once.
+func (o *options) claimStdin() error {
+	if o.stdin != nil && !o.stdinClaimed.CompareAndSwap(false, true) {
+		return fmt.Errorf("%w: the stdin reader was already consumed by another execution", ErrStdinConflict)
+	}
+	return nil
+}
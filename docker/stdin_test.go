@@ -0,0 +1,57 @@
+package docker_test
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/docker"
+)
+
+func TestStdin(t *testing.T) {
+	daemon := newFakeDaemon(t)
+	daemon.Container("app")
+	executor := docker.Executor(nil, "app", "", docker.WithHost(daemon.URL), docker.WithStdin(strings.NewReader("piped input")))
+	process, err := nescript.NewCmd("cat").Exec(executor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := process.Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.StdOut != "piped input" {
+		t.Errorf("expected the reader copied to stdin, got %q", result.StdOut)
+	}
+	if _, err := nescript.NewCmd("cat").Exec(executor); !errors.Is(err, docker.ErrStdinConflict) {
+		t.Errorf("expected a second execution consuming the reader to fail with ErrStdinConflict, got %v", err)
+	}
+}
+
+func TestStdinDoesNotWaitForReader(t *testing.T) {
+	daemon := newFakeDaemon(t)
+	daemon.Container("app")
+	// the reader never returns, as os.Stdin with nothing typed.
+	reader, writer := io.Pipe()
+	defer writer.Close()
+	process, err := nescript.NewCmd("echo", "done").Exec(docker.Executor(nil, "app", "", docker.WithHost(daemon.URL), docker.WithStdin(reader)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := make(chan *nescript.Result, 1)
+	go func() {
+		result, _ := process.Result()
+		results <- result
+	}()
+	select {
+	case result := <-results:
+		if result == nil || result.StdOut != "done\n" {
+			t.Errorf("expected the script's result, got %v", result)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected the result not to wait for the stdin reader")
+	}
+}
@@ -0,0 +1,94 @@
+package docker
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/neaas/nescript"
+)
+
+const (
+	osWindows = "windows"
+)
+
+// WindowsShell is the shell scripts are invoked with in windows containers.
+type WindowsShell int
+
+const (
+	// WindowsPowerShell invokes scripts with Windows PowerShell, as
+	// `powershell -NoProfile -NonInteractive -Command <script>`. This is the
+	// default.
+	WindowsPowerShell WindowsShell = iota
+
+	// WindowsPwsh invokes scripts with PowerShell (core), as
+	// `pwsh -NoProfile -NonInteractive -Command <script>`.
+	WindowsPwsh
+
+	// WindowsCmd invokes scripts with cmd, as `cmd /S /C <script>`. As cmd only
+	// executes a single line, the lines of the script are joined with `&&`, so
+	// that the script stops at the first line that fails, exiting with its
+	// code.
+	WindowsCmd
+)
+
+// powershellUTF8Output sets the console's output encoding of a PowerShell
+// script to UTF-8 (without a BOM), so that the output of native commands is not
+// in the container's OEM code page.
+const powershellUTF8Output = "$OutputEncoding = New-Object System.Text.UTF8Encoding $false\n" +
+	"try { [Console]::OutputEncoding = $OutputEncoding } catch {}\n"
+
+// windowsInvocation builds the command that runs a script in a windows
+// container with the given shell. The output is set to be UTF-8, with
+// `chcp 65001` for cmd scripts.
+func windowsInvocation(shell WindowsShell, script string, trailing []string) []string {
+	script = strings.ReplaceAll(script, "\r\n", "\n")
+	var command []string
+	switch shell {
+	case WindowsCmd:
+		lines := []string{"chcp 65001 >NUL"}
+		for _, line := range strings.Split(script, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				lines = append(lines, line)
+			}
+		}
+		command = []string{"cmd", "/S", "/C", strings.Join(lines, " && ")}
+	case WindowsPwsh:
+		command = []string{"pwsh", "-NoProfile", "-NonInteractive", "-Command", powershellUTF8Output + script}
+	default:
+		command = []string{"powershell", "-NoProfile", "-NonInteractive", "-Command", powershellUTF8Output + script}
+	}
+	return append(command, trailing...)
+}
+
+// windowsExitCode maps the exit code reported for a windows process into the
+// unsigned 32 bit range windows uses, as some engines report codes such as
+// 0xC0000005 (access violation) as negative numbers.
+func windowsExitCode(code int) int {
+	return int(uint32(code))
+}
+
+// normalizeWindowsOutput decodes UTF-16 output (such as that of a program
+// writing Unicode to a redirected stream) by its byte order mark, and converts
+// windows (CRLF) line endings into newlines.
+func normalizeWindowsOutput(output string) string {
+	output, _ = nescript.DecodeOutput(output, nescript.EncodingAuto)
+	return strings.ReplaceAll(output, "\r\n", "\n")
+}
+
+// validateFor ensures the options are supported by the container's operating
+// system, as some options are specific to linux containers.
+func (o *options) validateFor(osType string) error {
+	if osType != osWindows {
+		return nil
+	}
+	if o.resources.PidsLimit > 0 {
+		return fmt.Errorf("pids limit: %w", ErrUnsupportedOnWindows)
+	}
+	if o.envPolicy == EnvReplace {
+		return fmt.Errorf("env replace policy: %w", ErrUnsupportedOnWindows)
+	}
+	if len(o.dnsOptions) > 0 {
+		return fmt.Errorf("dns options: %w", ErrUnsupportedOnWindows)
+	}
+	return nil
+}
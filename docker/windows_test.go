@@ -0,0 +1,39 @@
+package docker_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/docker"
+)
+
+func TestWindowsShell(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		shell   docker.WindowsShell
+		command []string
+	}{
+		{"cmd", docker.WindowsCmd, []string{"cmd", "/S", "/C", "chcp 65001 >NUL && mkdir out && copy a.txt out"}},
+		{"powershell", docker.WindowsPowerShell, []string{"powershell", "-NoProfile", "-NonInteractive", "-Command"}},
+		{"pwsh", docker.WindowsPwsh, []string{"pwsh", "-NoProfile", "-NonInteractive", "-Command"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			daemon := newFakeDaemon(t)
+			daemon.WindowsContainer("iis")
+			executor := docker.Executor(nil, "iis", "", docker.WithHost(daemon.URL), docker.WithWindowsShell(tc.shell))
+			process, err := nescript.NewScript("mkdir out\r\n\r\n  copy a.txt out\r\n").Cmd().Exec(executor)
+			if err != nil {
+				t.Fatal(err)
+			}
+			process.Result()
+			execs := daemon.Execs()
+			if len(execs) != 1 {
+				t.Fatalf("expected one exec, got %d", len(execs))
+			}
+			if command := execs[0].Cmd; !slices.Equal(command[:len(tc.command)], tc.command) {
+				t.Errorf("expected the script invoked as %q, got %q", tc.command, command)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package nescript
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// EncodingAuto is the output encoding detecting UTF-16 output by its byte
+// order mark, which windows programs (such as PowerShell redirecting to a
+// file, or wsl.exe) write, otherwise taking output to be UTF-8.
+const EncodingAuto = ""
+
+var (
+	utf16LEBOM = []byte{0xff, 0xfe}
+	utf16BEBOM = []byte{0xfe, 0xff}
+)
+
+// DecodeOutput decodes the output of a script from the encoding into UTF-8.
+// The encoding is named as registered with IANA, or by one of its aliases, such
+// as "utf-16le", "windows-1252", or "cp850" and "cp437" (the OEM code pages of
+// a western european or US windows console). With EncodingAuto, output starting
+// with a UTF-16 byte order mark is decoded as UTF-16, a UTF-8 byte order mark is
+// removed, and other output is returned as it is. Bytes that are not valid in
+// the encoding are replaced with U+FFFD. An encoding that is not known returns
+// an error wrapping ErrUnknownEncoding.
+func DecodeOutput(output, encodingName string) (string, error) {
+	enc, err := outputEncoding(encodingName)
+	if err != nil {
+		return output, err
+	}
+	return decodeOutput(output, enc), nil
+}
+
+// outputEncoding looks up the named encoding, which is nil for EncodingAuto.
+func outputEncoding(name string) (encoding.Encoding, error) {
+	if name == EncodingAuto {
+		return nil, nil
+	}
+	enc, err := ianaindex.IANA.Encoding(name)
+	if err != nil || enc == nil {
+		return nil, fmt.Errorf("%w: '%s'", ErrUnknownEncoding, name)
+	}
+	return enc, nil
+}
+
+// decodeOutput decodes the output from the encoding, detecting it by its byte
+// order mark if nil.
+func decodeOutput(output string, enc encoding.Encoding) string {
+	if enc == nil {
+		raw := []byte(output)
+		switch {
+		case bytes.HasPrefix(raw, utf16LEBOM):
+			enc = unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM)
+		case bytes.HasPrefix(raw, utf16BEBOM):
+			enc = unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM)
+		default:
+			return strings.TrimPrefix(output, "\ufeff")
+		}
+	}
+	decoded, err := enc.NewDecoder().String(output)
+	if err != nil {
+		return output
+	}
+	return strings.TrimPrefix(decoded, "\ufeff")
+}
+
+// Decoded returns a copy of the result with its stdOut and stdErr decoded from
+// the encoding into UTF-8 (see DecodeOutput). For example, for the output of a
+// cmd script run on a windows target with the default console code page:
+//
+//	result, err = result.Decoded("cp437")
+func (r Result) Decoded(encoding string) (Result, error) {
+	enc, err := outputEncoding(encoding)
+	if err != nil {
+		return r, err
+	}
+	r.StdOut = decodeOutput(r.StdOut, enc)
+	r.StdErr = decodeOutput(r.StdErr, enc)
+	return r, nil
+}
+
+// Decoding wraps the executor so that the output of each result is decoded from
+// the encoding into UTF-8 (see Result.Decoded). Output streamed to the cmd's
+// writers is written as it is. An encoding that is not known fails each
+// execution with an error wrapping ErrUnknownEncoding, without executing the
+// script.
+func Decoding(executor ExecFunc, encoding string) ExecFunc {
+	enc, err := outputEncoding(encoding)
+	return func(c Cmd) (Process, error) {
+		if err != nil {
+			return nil, err
+		}
+		process, err := executor(c)
+		if err != nil {
+			return nil, err
+		}
+		return WrapProcess(process, ProcessHooks{Result: func(result *Result, err error) (*Result, error) {
+			if result != nil {
+				result.StdOut = decodeOutput(result.StdOut, enc)
+				result.StdErr = decodeOutput(result.StdErr, enc)
+			}
+			return result, err
+		}}), nil
+	}
+}
@@ -0,0 +1,56 @@
+package nescript_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/local"
+)
+
+func TestDecodingEachResult(t *testing.T) {
+	process, err := nescript.NewScript(`printf 'caf\351'`).Cmd().Exec(nescript.Decoding(local.Executor(""), "windows-1252"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer process.Close()
+	if _, ok := process.(nescript.Poller); !ok {
+		t.Error("expected the decoding process to be a Poller")
+	}
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if result, err := process.Result(); err != nil || result.StdOut != "café" {
+				t.Errorf("expected stdout 'café', got %q: %v", result.StdOut, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if result, _ := process.Result(); result.StdOut != "café" {
+		t.Errorf("expected a later result to be decoded once, got %q", result.StdOut)
+	}
+}
+
+func TestDecodeOutput(t *testing.T) {
+	for _, tc := range []struct {
+		encoding, raw, decoded string
+	}{
+		{"windows-1252", "caf\xe9 \x80", "café €"},
+		{"iso-8859-1", "\xfcber", "über"},
+		{"shift_jis", "\x93\xfa\x96\x7b", "日本"},
+		{"utf-16le", "h\x00i\x00", "hi"},
+	} {
+		decoded, err := nescript.DecodeOutput(tc.raw, tc.encoding)
+		if err != nil {
+			t.Errorf("%s: %v", tc.encoding, err)
+		} else if decoded != tc.decoded {
+			t.Errorf("%s: expected %q, got %q", tc.encoding, tc.decoded, decoded)
+		}
+	}
+	if _, err := nescript.DecodeOutput("", "nope"); !errors.Is(err, nescript.ErrUnknownEncoding) {
+		t.Errorf("expected an unknown encoding to fail with ErrUnknownEncoding, got %v", err)
+	}
+}
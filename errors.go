@@ -0,0 +1,290 @@
+package nescript
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrUnavailable is returned (wrapped) by an executor wrapped with Available
+	// when its availability check fails, and by Fallback when every executor
+	// failed in a way its policy falls through on.
+	ErrUnavailable = errors.New("executor unavailable")
+
+	// ErrExited is returned (wrapped) by a guarded process (see Guard) when
+	// writing to its stdin once its result has been collected.
+	ErrExited = errors.New("process has exited")
+
+	// ErrStillRunning is returned (wrapped) by a process's WaitContext (see
+	// Poller) when the context is done before the script exits, which is left
+	// running.
+	ErrStillRunning = errors.New("process is still running")
+
+	// ErrPanic is returned (wrapped) by Wait, and so sent as the outcome of an
+	// asynchronous execution (see Cmd.ExecAsync), when collecting the result
+	// panicked, quoting the panic.
+	ErrPanic = errors.New("panic collecting the result")
+
+	// ErrDecode is returned (wrapped) when a result's output can not be decoded
+	// (see Result.JSON), quoting the output.
+	ErrDecode = errors.New("failed to decode output")
+
+	// ErrNoMatch is returned (wrapped) by Result.MustExtract when the regexp
+	// does not match the output, quoting the regexp and the output.
+	ErrNoMatch = errors.New("no match in output")
+
+	// ErrUnknownEncoding is returned (wrapped) when decoding output from an
+	// encoding that is not known (see DecodeOutput), quoting its name.
+	ErrUnknownEncoding = errors.New("unknown output encoding")
+
+	// ErrSchemaVersion is returned (wrapped) when unmarshaling a script (see
+	// Script.UnmarshalJSON) of a schema version that is not supported, quoting
+	// the version.
+	ErrSchemaVersion = errors.New("unsupported script schema version")
+
+	// ErrMissingField is returned (wrapped, within a *CompileError) when
+	// compiling a script without the data of one of its required fields (see
+	// Script.WithRequiredFields), quoting the field.
+	ErrMissingField = errors.New("missing required field")
+
+	// ErrInvalidDefinition is matched (with errors.Is) by the *DefinitionError
+	// returned when loading a script definition that is not valid (see
+	// LoadDefinition).
+	ErrInvalidDefinition = errors.New("invalid script definition")
+
+	// ErrInvalidSignature is matched (with errors.Is) by the *SignatureError
+	// returned when a script can not be verified against its signature (see
+	// WithSignature).
+	ErrInvalidSignature = errors.New("script signature verification failed")
+
+	// ErrScriptNotFound is returned (wrapped) by a Registry when getting a
+	// script that is not registered, quoting its name.
+	ErrScriptNotFound = errors.New("script not registered")
+
+	// ErrDuplicateScript is returned (wrapped) by a Registry when registering a
+	// script under a name that is already registered, quoting the name.
+	ErrDuplicateScript = errors.New("script already registered")
+
+	// ErrRegistrySealed is returned (wrapped) by a Registry when registering a
+	// script once the registry is sealed (see Registry.Seal), quoting its name.
+	ErrRegistrySealed = errors.New("registry is sealed")
+
+	// ErrPolicyViolation is matched (with errors.Is) by the *PolicyError
+	// returned when a script violates a policy (see Enforcing).
+	ErrPolicyViolation = errors.New("script violates policy")
+
+	// ErrTimeout is matched (with errors.Is) by the errors of every executor
+	// when the script did not complete within a deadline, whether a timeout of
+	// the executor or the deadline of the cmd's context.
+	ErrTimeout = errors.New("execution timed out")
+
+	// ErrCancelled is matched (with errors.Is) by the errors of every executor
+	// when the script was stopped by the caller cancelling the cmd's context,
+	// rather than by a deadline (see ErrTimeout).
+	ErrCancelled = errors.New("execution cancelled")
+
+	// ErrConnection is matched (with errors.Is) by the errors of every executor
+	// failing to reach its target, such as a refused connection or rejected API
+	// call. When returned by an ExecFunc, the script was not started, so the
+	// execution is safe to retry.
+	ErrConnection = errors.New("failed to connect to target")
+)
+
+// The classes of error returned by ErrorClass.
+const (
+	ErrorClassExit       = "exit"
+	ErrorClassTimeout    = "timeout"
+	ErrorClassCancelled  = "cancelled"
+	ErrorClassConnection = "connection"
+	ErrorClassCompile    = "compile"
+	ErrorClassOther      = "other"
+)
+
+// ErrorClass returns the class of the error, such as for recording failures
+// in metrics or traces: whether the script exited unsuccessfully (an
+// *ExitError), did not complete in time or was cancelled, the target could
+// not be reached, or the script could not be compiled.
+func ErrorClass(err error) string {
+	var exitErr *ExitError
+	var compileErr *CompileError
+	switch {
+	case errors.As(err, &exitErr):
+		return ErrorClassExit
+	case errors.Is(err, ErrTimeout):
+		return ErrorClassTimeout
+	case errors.Is(err, ErrCancelled):
+		return ErrorClassCancelled
+	case errors.Is(err, ErrConnection):
+		return ErrorClassConnection
+	case errors.As(err, &compileErr):
+		return ErrorClassCompile
+	}
+	return ErrorClassOther
+}
+
+// NewError returns an error with the message, which also matches the class
+// (such as ErrConnection) with errors.Is. Executors use it to define their own
+// sentinel errors as members of the classes above, for example:
+//
+//	ErrConnection = nescript.NewError(nescript.ErrConnection, "failed to connect to docker engine")
+func NewError(class error, message string) error {
+	return &classError{class: class, err: errors.New(message)}
+}
+
+// ContextError returns the error of a done context as a member of ErrTimeout,
+// if its deadline passed, or ErrCancelled, if it was cancelled. The message of
+// the error is unchanged, and any other error is returned as it is.
+func ContextError(err error) error {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return &classError{class: ErrTimeout, err: err}
+	case errors.Is(err, context.Canceled):
+		return &classError{class: ErrCancelled, err: err}
+	}
+	return err
+}
+
+// classError is an error which is also a member of the class.
+type classError struct {
+	class error
+	err   error
+}
+
+func (e *classError) Error() string {
+	return e.err.Error()
+}
+
+func (e *classError) Unwrap() []error {
+	return []error{e.class, e.err}
+}
+
+// ExitError is the error returned by Result.Err when the script did not exit
+// successfully, holding the result so its output is still available:
+//
+//	var exitErr *nescript.ExitError
+//	if errors.As(err, &exitErr) {
+//		log.Print(exitErr.Result.StdErr)
+//	}
+type ExitError struct {
+	Result *Result
+}
+
+func (e *ExitError) Error() string {
+	if e.Result.Signaled {
+		return fmt.Sprintf("script killed by signal %s", e.Result.Signal)
+	}
+	return fmt.Sprintf("script exited with code %d", e.Result.ExitCode)
+}
+
+// CompileError is returned by Script.Compile and Cmd.Compile when a template
+// could not be parsed or executed with the data fields.
+type CompileError struct {
+	// Arg is the index of the command arg which failed to compile, or -1 if it
+	// was the script.
+	Arg int
+
+	// Parse is true if the template could not be parsed, rather than executed.
+	Parse bool
+
+	// Err is the error of the template engine.
+	Err error
+}
+
+func (e *CompileError) Error() string {
+	switch {
+	case e.Arg < 0 && e.Parse:
+		return fmt.Sprintf("failed to parse the script: %s", e.Err)
+	case e.Arg < 0:
+		return fmt.Sprintf("script template could not be compiled: %s", e.Err)
+	case e.Parse:
+		return fmt.Sprintf("failed to parse a command arg: %s", e.Err)
+	}
+	return fmt.Sprintf("cmd arg template could not be compiled: %s", e.Err)
+}
+
+func (e *CompileError) Unwrap() error {
+	return e.Err
+}
+
+// DefinitionError is the error returned when loading a script definition that
+// is not valid (see LoadDefinition), citing where in the YAML it is invalid.
+type DefinitionError struct {
+	// File is the path of the definition file.
+	File string
+
+	// Path is the path within the YAML of the invalid value, such as
+	// "scripts[2].env[0]" for the first env var of the third document, or
+	// empty for the document as a whole.
+	Path string
+
+	// Line is the line of the invalid value, or 0 if not known.
+	Line int
+
+	// Err is the reason the value is invalid.
+	Err error
+}
+
+func (e *DefinitionError) Error() string {
+	at := e.File
+	if e.Path != "" {
+		at += ": " + e.Path
+	}
+	if e.Line > 0 {
+		at += fmt.Sprintf(" (line %d)", e.Line)
+	}
+	return fmt.Sprintf("%s: '%s': %s", ErrInvalidDefinition, at, e.Err)
+}
+
+func (e *DefinitionError) Unwrap() []error {
+	return []error{ErrInvalidDefinition, e.Err}
+}
+
+// PolicyError is the error returned by an executor wrapped with Enforcing when
+// a script violates a policy, in which case the script is not executed.
+type PolicyError struct {
+	// Rule names the rule violated, such as "rm-root" (see DefaultDenyPatterns),
+	// or "custom" for a PolicyFunc returning another error.
+	Rule string `json:"rule"`
+
+	// Line is the line (from 1) of the script violating the rule, with its text
+	// as Snippet, or 0 if the rule applies to the script as a whole.
+	Line    int    `json:"line,omitempty"`
+	Snippet string `json:"snippet,omitempty"`
+
+	// Reason describes how the rule was violated.
+	Reason string `json:"reason"`
+}
+
+func (e *PolicyError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s: '%s': line %d: '%s': %s", ErrPolicyViolation, e.Rule, e.Line, e.Snippet, e.Reason)
+	}
+	return fmt.Sprintf("%s: '%s': %s", ErrPolicyViolation, e.Rule, e.Reason)
+}
+
+func (e *PolicyError) Unwrap() error {
+	return ErrPolicyViolation
+}
+
+// SignatureError is the error returned when a script read from its source can
+// not be verified against its signature (see WithSignature), in which case no
+// script is created.
+type SignatureError struct {
+	// Origin is the file or URL the script was read from.
+	Origin string
+
+	// Location is the file or URL the signature was read from.
+	Location string
+
+	// Err is the reason the script could not be verified.
+	Err error
+}
+
+func (e *SignatureError) Error() string {
+	return fmt.Sprintf("%s: '%s' (signature '%s'): %s", ErrInvalidSignature, e.Origin, e.Location, e.Err)
+}
+
+func (e *SignatureError) Unwrap() []error {
+	return []error{ErrInvalidSignature, e.Err}
+}
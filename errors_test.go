@@ -0,0 +1,135 @@
+package nescript_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/local"
+)
+
+func TestErrorClass(t *testing.T) {
+	errConnection := nescript.NewError(nescript.ErrConnection, "failed to connect to test target")
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	_, compileErr := nescript.NewScript("{{ .missing").Compile()
+	for _, tc := range []struct {
+		name  string
+		err   error
+		is    []error
+		class string
+	}{
+		{"exit", fmt.Errorf("failed: %w", (&nescript.Result{ExitCode: 2}).Err()), nil, nescript.ErrorClassExit},
+		{"signal", (&nescript.Result{ExitCode: -1, Signaled: true}).Err(), nil, nescript.ErrorClassExit},
+		{"timeout", nescript.ContextError(expired.Err()), []error{nescript.ErrTimeout, context.DeadlineExceeded}, nescript.ErrorClassTimeout},
+		{"cancelled", nescript.ContextError(cancelled.Err()), []error{nescript.ErrCancelled, context.Canceled}, nescript.ErrorClassCancelled},
+		{"executor's connection error", fmt.Errorf("%w 'host': refused", errConnection), []error{errConnection, nescript.ErrConnection}, nescript.ErrorClassConnection},
+		{"compile", compileErr, nil, nescript.ErrorClassCompile},
+		{"other", errors.New("failed"), nil, nescript.ErrorClassOther},
+		{"other context error", nescript.ContextError(errors.New("failed")), nil, nescript.ErrorClassOther},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, target := range tc.is {
+				if !errors.Is(tc.err, target) {
+					t.Errorf("expected %v to match %v", tc.err, target)
+				}
+			}
+			if class := nescript.ErrorClass(tc.err); class != tc.class {
+				t.Errorf("expected the class %s, got %s", tc.class, class)
+			}
+		})
+	}
+}
+
+func TestErrorMessages(t *testing.T) {
+	expired, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	if err := nescript.NewError(nescript.ErrConnection, "failed to connect"); err.Error() != "failed to connect" {
+		t.Errorf("expected the message unchanged, got %q", err)
+	}
+	if err := nescript.ContextError(expired.Err()); err.Error() != context.DeadlineExceeded.Error() {
+		t.Errorf("expected the context's message unchanged, got %q", err)
+	}
+	if err := nescript.ContextError(nil); err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+}
+
+func TestExitError(t *testing.T) {
+	if err := (&nescript.Result{}).Err(); err != nil {
+		t.Errorf("expected no error for a successful result, got %v", err)
+	}
+	result := &nescript.Result{StdErr: "not found", ExitCode: 127}
+	var exitErr *nescript.ExitError
+	if err := fmt.Errorf("failed: %w", result.Err()); !errors.As(err, &exitErr) {
+		t.Fatalf("expected an *ExitError, got %v", err)
+	}
+	if exitErr.Result != result || exitErr.Error() != "script exited with code 127" {
+		t.Errorf("expected the error to hold the result, got %q", exitErr)
+	}
+}
+
+func TestCompileError(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		compile func() error
+		arg     int
+		parse   bool
+	}{
+		{"script parse", func() error { _, err := nescript.NewScript("{{ .missing").Compile(); return err }, -1, true},
+		{"script execute", func() error {
+			_, err := nescript.NewScript("{{ .fn 1 }}").WithField("fn", "text").Compile()
+			return err
+		}, -1, false},
+		{"cmd arg parse", func() error { _, err := nescript.NewCmd("echo", "ok", "{{ .missing").Compile(); return err }, 1, true},
+		{"cmd arg execute", func() error {
+			_, err := nescript.NewCmd("echo", "{{ .fn 1 }}").WithField("fn", "text").Compile()
+			return err
+		}, 0, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var compileErr *nescript.CompileError
+			if err := tc.compile(); !errors.As(err, &compileErr) {
+				t.Fatalf("expected a *CompileError, got %v", err)
+			}
+			if compileErr.Arg != tc.arg || compileErr.Parse != tc.parse || compileErr.Err == nil {
+				t.Errorf("expected arg %d and parse %t, got %+v", tc.arg, tc.parse, compileErr)
+			}
+		})
+	}
+}
+
+func TestWaitErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		ctx    func() (context.Context, context.CancelFunc)
+		target error
+	}{
+		{"timeout", func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), 100*time.Millisecond)
+		}, nescript.ErrTimeout},
+		{"cancelled", func() (context.Context, context.CancelFunc) {
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(100*time.Millisecond, cancel)
+			return ctx, cancel
+		}, nescript.ErrCancelled},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := tc.ctx()
+			defer cancel()
+			process, err := nescript.NewScript("sleep 10").Cmd().Exec(local.Executor(""))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer process.Close()
+			if _, err := nescript.Wait(ctx, process); !errors.Is(err, tc.target) {
+				t.Errorf("expected %v, got %v", tc.target, err)
+			}
+		})
+	}
+}
@@ -57,7 +57,7 @@ func (c Cmd) OSCmd() (*exec.Cmd, error) {
 	} else {
 		command = exec.Command(commandSlice[0], commandSlice[1:]...)
 	}
-	command.Env = c.env
+	command.Env = c.Env()
 	return command, nil
 }
 
@@ -66,3 +66,27 @@ func (c Cmd) OSCmd() (*exec.Cmd, error) {
 func (c Cmd) String() string {
 	return c.formatter(c.Raw())
 }
+
+// SuccessCodes wraps the executor so that its results count the exit codes as
+// the script succeeding, rather than only 0 (see Result.Success), such as for
+// tools using non-zero exit codes that are not failures:
+//
+//	executor := nescript.SuccessCodes(local.Executor(""), 0, 1) // grep
+//
+// A script exiting with one of the codes is not an ExitError (see Result.Err),
+// nor counted as failed by fan-outs, logging or metrics. 0 is only a success
+// code if included.
+func SuccessCodes(executor ExecFunc, codes ...int) ExecFunc {
+	return func(c Cmd) (Process, error) {
+		process, err := executor(c)
+		if err != nil {
+			return nil, err
+		}
+		return WrapProcess(process, ProcessHooks{Result: func(result *Result, err error) (*Result, error) {
+			if result != nil {
+				result.SuccessCodes = codes
+			}
+			return result, err
+		}}), nil
+	}
+}
@@ -0,0 +1,99 @@
+package nescript
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ExtractOption configures which of a result's output is searched by Extract,
+// ExtractAll and MustExtract.
+type ExtractOption func(*extractOptions)
+
+type extractOptions struct {
+	useErr   bool
+	combined bool
+}
+
+func newExtractOptions(opts []ExtractOption) *extractOptions {
+	o := &extractOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// ExtractStdErr searches stderr, rather than stdout.
+func ExtractStdErr() ExtractOption {
+	return func(o *extractOptions) {
+		o.useErr = true
+	}
+}
+
+// ExtractCombined searches stdout followed by stderr, for tools which do not
+// write to the stream expected.
+func ExtractCombined() ExtractOption {
+	return func(o *extractOptions) {
+		o.combined = true
+	}
+}
+
+// output returns the output to be searched.
+func (o *extractOptions) output(r Result) string {
+	if !o.combined {
+		return r.stream(o.useErr)
+	}
+	if r.StdOut == "" || strings.HasSuffix(r.StdOut, "\n") {
+		return r.StdOut + r.StdErr
+	}
+	return r.StdOut + "\n" + r.StdErr
+}
+
+// Extract returns the first capture group of the first match of the regexp in
+// the script's stdout, or the whole match if it has no groups. False is
+// returned if there is no match.
+//
+// As with any regexp, ^ and $ match the start and end of the whole output,
+// unless the m flag is set to match those of each line:
+//
+//	version, ok := result.Extract(regexp.MustCompile(`(?m)^version: (\S+)$`))
+func (r Result) Extract(re *regexp.Regexp, opts ...ExtractOption) (string, bool) {
+	match := re.FindStringSubmatch(newExtractOptions(opts).output(r))
+	if match == nil {
+		return "", false
+	}
+	return submatch(match), true
+}
+
+// ExtractAll returns the first capture group (or whole match, if it has no
+// groups) of every match of the regexp in the script's stdout, in order. If
+// there is no match, an empty slice is returned.
+func (r Result) ExtractAll(re *regexp.Regexp, opts ...ExtractOption) []string {
+	matches := re.FindAllStringSubmatch(newExtractOptions(opts).output(r), -1)
+	extracted := make([]string, len(matches))
+	for i, match := range matches {
+		extracted[i] = submatch(match)
+	}
+	return extracted
+}
+
+// MustExtract returns the match of the regexp in the script's stdout, as
+// Extract does, however returns an error wrapping ErrNoMatch if there is not
+// one, quoting the regexp and the start of the output searched.
+func (r Result) MustExtract(re *regexp.Regexp, opts ...ExtractOption) (string, error) {
+	output := newExtractOptions(opts).output(r)
+	match := re.FindStringSubmatch(output)
+	if match == nil {
+		return "", fmt.Errorf("%w: '%s' in '%s'", ErrNoMatch, re, snippet(output))
+	}
+	return submatch(match), nil
+}
+
+// submatch returns the first capture group of the match, or the whole match if
+// the regexp has no groups.
+func submatch(match []string) string {
+	if len(match) > 1 {
+		return match[1]
+	}
+	return match[0]
+}
@@ -0,0 +1,100 @@
+package nescript
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+const (
+	// MetadataExecutor is the result metadata key holding the name of the
+	// executor the script was run by, recorded by each executor (such as
+	// "local" or "ssh") unless named by Available.
+	MetadataExecutor = "nescript.executor"
+
+	// MetadataFallback is the result metadata key holding the index of the
+	// executor, of those given to Fallback, the script was run by.
+	MetadataFallback = "nescript.fallback"
+)
+
+// FallbackPolicy reports whether Fallback should try the next executor after
+// the error returned by an executor, rather than returning it.
+type FallbackPolicy func(err error) bool
+
+// FallThroughUnavailable is the default FallbackPolicy, only falling through
+// for executors found unavailable by their availability check (see
+// Available). Other errors starting the script, such as a command that does not
+// exist on the target, are returned.
+func FallThroughUnavailable(err error) bool {
+	return errors.Is(err, ErrUnavailable)
+}
+
+// Available wraps the executor with an availability check, such as a cheap
+// ping of a docker engine, made before each execution. If the check fails, the
+// executor returns an error wrapping ErrUnavailable without executing the
+// script. The name is recorded on the results of the executor (see
+// MetadataExecutor). The check may be nil, only naming the executor.
+func Available(name string, executor ExecFunc, check func(ctx context.Context) error) ExecFunc {
+	return func(c Cmd) (Process, error) {
+		if check != nil {
+			if err := check(c.Context()); err != nil {
+				return nil, fmt.Errorf("%w: '%s': %w", ErrUnavailable, name, err)
+			}
+		}
+		process, err := executor(c)
+		if err != nil {
+			return nil, err
+		}
+		return withMetadata(process, MetadataExecutor, name), nil
+	}
+}
+
+// Fallback provides an ExecFunc trying each of the executors in order, until
+// one starts the script, falling through only for those unavailable (see
+// Available and FallThroughUnavailable). For example, to use docker if the
+// engine responds, otherwise run locally:
+//
+//	executor := nescript.Fallback(
+//		nescript.Available("docker", docker.Executor(client, id, ""), func(ctx context.Context) error {
+//			_, err := client.Ping(ctx)
+//			return err
+//		}),
+//		nescript.Available("local", local.Executor(""), nil),
+//	)
+//
+// Once an executor has started the script it is never re-run by another, so a
+// script exiting non-zero, or failing once started, is returned as it is. The
+// index of the executor used is recorded on the result (see MetadataFallback).
+func Fallback(executors ...ExecFunc) ExecFunc {
+	return FallbackWithPolicy(FallThroughUnavailable, executors...)
+}
+
+// FallbackWithPolicy provides an ExecFunc as Fallback does, with the policy
+// deciding which errors starting the script fall through to the next executor.
+// If the cmd's context is done, no further executors are tried.
+func FallbackWithPolicy(policy FallbackPolicy, executors ...ExecFunc) ExecFunc {
+	return func(c Cmd) (Process, error) {
+		errs := make([]error, 0, len(executors))
+		for i, executor := range executors {
+			process, err := executor(c)
+			if err == nil {
+				return withMetadata(process, MetadataFallback, i), nil
+			}
+			if !policy(err) || c.Context().Err() != nil {
+				return nil, err
+			}
+			errs = append(errs, err)
+		}
+		return nil, fmt.Errorf("%w: no executor could run the script: %w", ErrUnavailable, errors.Join(errs...))
+	}
+}
+
+// withMetadata wraps the process so that the metadata is recorded on its result.
+func withMetadata(p Process, key string, value any) Process {
+	return WrapProcess(p, ProcessHooks{Result: func(result *Result, err error) (*Result, error) {
+		if result != nil {
+			result.SetMetadata(key, value)
+		}
+		return result, err
+	}})
+}
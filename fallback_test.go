@@ -0,0 +1,121 @@
+package nescript_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/mock"
+)
+
+func TestFallback(t *testing.T) {
+	down := errors.New("daemon not responding")
+	refused := fmt.Errorf("%w: refused", nescript.NewError(nescript.ErrConnection, "failed to connect"))
+	for _, tc := range []struct {
+		name     string
+		policy   nescript.FallbackPolicy
+		check    error
+		program  func(first *mock.Mock)
+		err      error
+		exitCode int
+		executor string
+		index    int
+		calls    [2]int
+	}{
+		{"first available", nil, nil, func(first *mock.Mock) {
+			first.On(mock.Any()).Return("docker\n", "", 0)
+		}, nil, 0, "docker", 0, [2]int{1, 0}},
+		{"first unavailable", nil, down, nil, nil, 0, "local", 1, [2]int{0, 1}},
+		{"script exits non-zero", nil, nil, func(first *mock.Mock) {
+			first.On(mock.Any()).Return("", "failed\n", 3)
+		}, nil, 3, "docker", 0, [2]int{1, 0}},
+		{"execution failure", nil, nil, func(first *mock.Mock) {
+			first.On(mock.Any()).Fail(refused)
+		}, refused, 0, "", 0, [2]int{1, 0}},
+		{"execution failure with a policy", func(err error) bool {
+			return errors.Is(err, nescript.ErrConnection)
+		}, nil, func(first *mock.Mock) {
+			first.On(mock.Any()).Fail(refused)
+		}, nil, 0, "local", 1, [2]int{1, 1}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			first, second := mock.New(t), mock.New(t)
+			if tc.program != nil {
+				tc.program(first)
+			}
+			second.On(mock.Any()).Return("local\n", "", 0)
+			executors := []nescript.ExecFunc{
+				nescript.Available("docker", first.Executor(), func(ctx context.Context) error {
+					return tc.check
+				}),
+				nescript.Available("local", second.Executor(), nil),
+			}
+			executor := nescript.Fallback(executors...)
+			if tc.policy != nil {
+				executor = nescript.FallbackWithPolicy(tc.policy, executors...)
+			}
+			process, err := nescript.NewScript("deploy").Cmd().Exec(executor)
+			if calls := [2]int{len(first.Calls()), len(second.Calls())}; calls != tc.calls {
+				t.Errorf("expected the executors called %v times, got %v", tc.calls, calls)
+			}
+			if tc.err != nil {
+				if !errors.Is(err, tc.err) || errors.Is(err, nescript.ErrUnavailable) {
+					t.Errorf("expected %v returned as it is, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := process.Result()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.ExitCode != tc.exitCode {
+				t.Errorf("expected exit code %d, got %d", tc.exitCode, result.ExitCode)
+			}
+			if executor := result.Metadata[nescript.MetadataExecutor]; executor != tc.executor {
+				t.Errorf("expected the script run by %s, got %v", tc.executor, executor)
+			}
+			if index := result.Metadata[nescript.MetadataFallback]; index != tc.index {
+				t.Errorf("expected the executor at %d recorded, got %v", tc.index, index)
+			}
+		})
+	}
+}
+
+func TestFallbackAllUnavailable(t *testing.T) {
+	m := mock.New(t)
+	unavailable := func(name string) nescript.ExecFunc {
+		return nescript.Available(name, m.Executor(), func(ctx context.Context) error {
+			return errors.New("not responding")
+		})
+	}
+	_, err := nescript.NewScript("deploy").Cmd().Exec(nescript.Fallback(unavailable("docker"), unavailable("podman")))
+	if !errors.Is(err, nescript.ErrUnavailable) || !strings.Contains(err.Error(), "'docker'") || !strings.Contains(err.Error(), "'podman'") {
+		t.Errorf("expected %v for both executors, got %v", nescript.ErrUnavailable, err)
+	}
+	if len(m.Calls()) != 0 {
+		t.Errorf("expected no executions, got %d", len(m.Calls()))
+	}
+}
+
+func TestFallbackCancelled(t *testing.T) {
+	m := mock.New(t)
+	m.On(mock.Any())
+	ctx, cancel := context.WithCancel(context.Background())
+	first := nescript.Available("docker", m.Executor(), func(ctx context.Context) error {
+		cancel()
+		return ctx.Err()
+	})
+	_, err := nescript.NewScript("deploy").Cmd().WithContext(ctx).Exec(nescript.Fallback(first, m.Executor()))
+	if !errors.Is(err, nescript.ErrUnavailable) || !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the cancelled check returned, got %v", err)
+	}
+	if len(m.Calls()) != 0 {
+		t.Errorf("expected no further executors tried, got %d calls", len(m.Calls()))
+	}
+}
@@ -0,0 +1,113 @@
+package nescript
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"slices"
+	"strings"
+)
+
+// HashVersion is the version of the canonicalization of Script.Hash, which
+// prefixes each hash. It is incremented by any change to what is hashed, so
+// that hashes of different versions never match, rather than the same script
+// silently hashing differently.
+const HashVersion = 1
+
+// Hash returns a stable identity of the script with its inputs, for caching,
+// deduplication and audit, as "v<HashVersion>:<hex sha256>". The hash covers:
+//
+//   - the raw script, as it is: a compiled script (see Compile) hashes its
+//     compiled raw with no template data, so differs from the script it was
+//     compiled from
+//   - the subcommand
+//   - the template data, with keys sorted and values encoded as JSON (or
+//     formatted with %v if they can not be)
+//   - the env, deduplicated by key (keeping the last value, which is that the
+//     script is executed with) and sorted by key
+//   - the required fields, sorted
+//
+// The values of secret fields and env vars (see WithSecretField and
+// WithSecretEnv) are hashed as their own digests, salted with their name, so
+// the hash changes with them without revealing them. The origin and logger are
+// not hashed, so the same script read from different places hashes the same.
+func (s Script) Hash() string {
+	h := sha256.New()
+	hashPart(h, fmt.Sprintf("nescript script v%d", HashVersion))
+	hashPart(h, s.raw)
+	hashPart(h, fmt.Sprint(len(s.subcommand)))
+	for _, part := range s.subcommand {
+		hashPart(h, part)
+	}
+	data, env := s.Data(), s.Env()
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	hashPart(h, fmt.Sprint(len(keys)))
+	for _, key := range keys {
+		value := canonicalValue(data[key])
+		if s.isSecretField(key) {
+			value = secretDigest("field", key, value)
+		}
+		hashPart(h, key)
+		hashPart(h, value)
+	}
+	values := make(map[string]string, len(env))
+	for _, e := range env {
+		key, value, _ := strings.Cut(e, "=")
+		values[key] = value
+	}
+	keys = keys[:0]
+	for key := range values {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	hashPart(h, fmt.Sprint(len(keys)))
+	for _, key := range keys {
+		value := values[key]
+		if s.isSecretEnv(key) {
+			value = secretDigest("env", key, value)
+		}
+		hashPart(h, key)
+		hashPart(h, value)
+	}
+	required := slices.Clone(s.required)
+	slices.Sort(required)
+	hashPart(h, fmt.Sprint(len(required)))
+	for _, key := range required {
+		hashPart(h, key)
+	}
+	return fmt.Sprintf("v%d:%s", HashVersion, hex.EncodeToString(h.Sum(nil)))
+}
+
+// hashPart writes the part to the hash prefixed with its length, so that parts
+// can not run into each other.
+func hashPart(h hash.Hash, part string) {
+	var size [8]byte
+	binary.BigEndian.PutUint64(size[:], uint64(len(part)))
+	h.Write(size[:])
+	h.Write([]byte(part))
+}
+
+// canonicalValue encodes the template data value as JSON, which sorts the keys
+// of maps, or formats it with %v if it can not be encoded.
+func canonicalValue(value any) string {
+	if encoded, err := json.Marshal(value); err == nil {
+		return string(encoded)
+	}
+	return fmt.Sprintf("%T:%v", value, value)
+}
+
+// secretDigest returns the digest of the secret value, salted with the kind
+// and name of the secret.
+func secretDigest(kind, name, value string) string {
+	mac := hmac.New(sha256.New, []byte(fmt.Sprintf("nescript secret v%d %s %s", HashVersion, kind, name)))
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,52 @@
+// Package shellquote quotes strings for the shells nescript builds commands for
+// on remote targets, so that every executor quotes them the same way.
+package shellquote
+
+import (
+	"encoding/base64"
+	"strings"
+	"unicode/utf16"
+)
+
+// powershellQuotes are the characters PowerShell treats as a single quote,
+// which includes the typographic quotes as well as the apostrophe.
+var powershellQuotes = strings.NewReplacer(
+	"'", "''",
+	"‘", "‘‘",
+	"’", "’’",
+	"‚", "‚‚",
+	"‛", "‛‛",
+)
+
+// powershellBraced escapes the characters that are special within ${...}.
+var powershellBraced = strings.NewReplacer("`", "``", "{", "`{", "}", "`}")
+
+// POSIX quotes a string as a single POSIX shell word.
+func POSIX(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// PowerShell quotes a string as a single PowerShell verbatim (single quoted)
+// string. Every character PowerShell takes as a single quote is doubled, not
+// just the apostrophe.
+func PowerShell(s string) string {
+	return "'" + powershellQuotes.Replace(s) + "'"
+}
+
+// PowerShellBraced escapes a variable name for use within ${...}, so that names
+// that are not valid in PowerShell (such as with dashes) can be used.
+func PowerShellBraced(name string) string {
+	return powershellBraced.Replace(name)
+}
+
+// EncodedCommand encodes a PowerShell script for -EncodedCommand, as base64
+// encoded UTF-16LE, which needs no quoting for whichever shell runs the
+// command.
+func EncodedCommand(script string) string {
+	units := utf16.Encode([]rune(script))
+	encoded := make([]byte, 0, len(units)*2)
+	for _, unit := range units {
+		encoded = append(encoded, byte(unit), byte(unit>>8))
+	}
+	return base64.StdEncoding.EncodeToString(encoded)
+}
@@ -0,0 +1,87 @@
+package shellquote_test
+
+import (
+	"encoding/base64"
+	"os/exec"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/neaas/nescript/internal/shellquote"
+)
+
+// awkward are strings that must survive quoting unchanged.
+var awkward = []string{
+	"",
+	"plain",
+	"with spaces",
+	"it's",
+	`'; rm -rf / #`,
+	`$HOME $(id) ` + "`id`",
+	"\"double\" \\back\\slash",
+	"new\nline",
+	"‘typographic’ ‚quotes‛",
+	"glob * ? [a]",
+}
+
+func TestPOSIX(t *testing.T) {
+	for _, s := range awkward {
+		output, err := exec.Command("sh", "-c", "printf %s "+shellquote.POSIX(s)).Output()
+		if err != nil {
+			t.Fatalf("%q: %v", s, err)
+		}
+		if string(output) != s {
+			t.Errorf("expected %q, got %q", s, output)
+		}
+	}
+}
+
+func TestPowerShell(t *testing.T) {
+	for s, want := range map[string]string{
+		"plain":     "'plain'",
+		"it's":      "'it''s'",
+		"‘quoted’":  "'‘‘quoted’’'",
+		"‚low‛":     "'‚‚low‛‛'",
+		"$env:PATH": "'$env:PATH'",
+	} {
+		if quoted := shellquote.PowerShell(s); quoted != want {
+			t.Errorf("expected %q quoted as %s, got %s", s, want, quoted)
+		}
+	}
+	pwsh, err := exec.LookPath("pwsh")
+	if err != nil {
+		t.Skip("pwsh is not installed")
+	}
+	for _, s := range awkward {
+		output, err := exec.Command(pwsh, "-NoProfile", "-NonInteractive", "-Command", "[Console]::Out.Write("+shellquote.PowerShell(s)+")").Output()
+		if err != nil {
+			t.Fatalf("%q: %v", s, err)
+		}
+		if string(output) != s {
+			t.Errorf("expected %q, got %q", s, output)
+		}
+	}
+}
+
+func TestPowerShellBraced(t *testing.T) {
+	if escaped := shellquote.PowerShellBraced("a-b{c}`d"); escaped != "a-b`{c`}``d" {
+		t.Errorf("expected the braces and backticks escaped, got %s", escaped)
+	}
+}
+
+func TestEncodedCommand(t *testing.T) {
+	script := "Write-Output 'héllo 🌍'"
+	decoded, err := base64.StdEncoding.DecodeString(shellquote.EncodedCommand(script))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded)%2 != 0 {
+		t.Fatalf("expected UTF-16 code units, got %d bytes", len(decoded))
+	}
+	units := make([]uint16, len(decoded)/2)
+	for i := range units {
+		units[i] = uint16(decoded[2*i]) | uint16(decoded[2*i+1])<<8
+	}
+	if got := string(utf16.Decode(units)); got != script {
+		t.Errorf("expected the script encoded as UTF-16LE, got %q", got)
+	}
+}
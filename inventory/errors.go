@@ -0,0 +1,22 @@
+package inventory
+
+import "errors"
+
+var (
+	// ErrInvalidInventory is returned (wrapped) when an inventory can not be
+	// parsed, or a target in it has no name, a duplicate name or no type.
+	ErrInvalidInventory = errors.New("invalid inventory")
+
+	// ErrUnknownType is returned (wrapped) when a target's type has no
+	// executor builder registered for it (see Register).
+	ErrUnknownType = errors.New("unknown target type")
+
+	// ErrInvalidOptions is returned (wrapped) when a target's options can not
+	// be decoded for its type, or are missing a required option.
+	ErrInvalidOptions = errors.New("invalid target options")
+
+	// ErrSkipped is recorded in a FanOutError for the targets a script was not
+	// executed on, as an earlier target failed and fail-fast was requested, or
+	// the fan-out was cancelled first.
+	ErrSkipped = errors.New("skipped after an earlier failure")
+)
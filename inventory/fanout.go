@@ -0,0 +1,278 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/neaas/nescript"
+)
+
+// killGrace is how long a killed script's result is waited for once the target
+// timeout or fan-out deadline has passed.
+const killGrace = 5 * time.Second
+
+// Outcome is how the execution on a target of a fan-out ended.
+type Outcome string
+
+const (
+	// OutcomeSucceeded means the script exited successfully, with a zero exit
+	// code or one of its success codes (see nescript.SuccessCodes).
+	OutcomeSucceeded Outcome = "succeeded"
+
+	// OutcomeFailed means the script exited unsuccessfully, or could not be
+	// executed (or its result collected), including when the target's executor
+	// could not be built from its options.
+	OutcomeFailed Outcome = "failed"
+
+	// OutcomeTimedOut means the target timeout (see WithTargetTimeout) or the
+	// overall fan-out deadline passed before the script completed.
+	OutcomeTimedOut Outcome = "timed out"
+
+	// OutcomeSkipped means the script was not executed on the target, as an
+	// earlier target failed with fail-fast enabled, or the fan-out was
+	// cancelled (or its deadline passed) before the target was reached.
+	OutcomeSkipped Outcome = "skipped"
+)
+
+// TargetResult is the outcome of the execution on one target of a fan-out.
+// Either the result or the error is set. Script is the script as compiled for
+// the target (with the target's fields and env), for auditing.
+type TargetResult struct {
+	Target   string
+	Type     string
+	Outcome  Outcome
+	Result   *nescript.Result
+	Err      error
+	Duration time.Duration
+	Script   *nescript.Script
+}
+
+// FanOutResults are the outcomes of a fan-out, keyed by target name, along
+// with the number of targets with each outcome.
+type FanOutResults struct {
+	Targets map[string]TargetResult
+
+	Succeeded int
+	Failed    int
+	TimedOut  int
+	Skipped   int
+}
+
+// ProgressFunc is called as each target of a fan-out completes (or is
+// skipped), with the number of targets completed so far and the total. Calls
+// are not made concurrently.
+type ProgressFunc func(result TargetResult, completed, total int)
+
+// FanOutError is returned by ExecScriptAll when the script could not be
+// executed on, or its result collected from, one or more of the targets.
+// Errors is keyed by target name.
+type FanOutError struct {
+	Errors map[string]error
+}
+
+func (e *FanOutError) Error() string {
+	targets := make([]string, 0, len(e.Errors))
+	for target := range e.Errors {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	messages := make([]string, len(targets))
+	for i, target := range targets {
+		messages[i] = fmt.Sprintf("target '%s': %s", target, e.Errors[target])
+	}
+	return fmt.Sprintf("failed on %d target(s): %s", len(targets), strings.Join(messages, "; "))
+}
+
+func (e *FanOutError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// ExecScriptAll executes the script on every target, each with the executor
+// its type's builder creates from its options, waiting for each to complete.
+// The script is compiled separately for each target, with the target's fields
+// and env merged onto the script's own, values given for the target replacing
+// those of the same key. By default, the script is executed on one target at a
+// time; see WithConcurrency. The fan-out is limited by the context, and by
+// WithTargetTimeout and WithFanOutTimeout. A failure on one target does not
+// stop the others unless WithFailFast is given. The outcome of every target is
+// returned; if any errored, a *FanOutError is also returned. To execute on a
+// subset of an inventory, see Inventory.Select.
+func ExecScriptAll(ctx context.Context, targets []Target, script nescript.Script, opts ...Option) (*FanOutResults, error) {
+	o := newOptions(opts)
+	return o.fanOut(ctx, targets, func(ctx context.Context, target Target) TargetResult {
+		failed := TargetResult{Target: target.Name, Type: target.Type, Outcome: OutcomeFailed}
+		executor, err := Executor(target)
+		if err != nil {
+			failed.Err = err
+			return failed
+		}
+		compiled, err := targetScript(script, target).Compile()
+		if err != nil {
+			failed.Err = err
+			return failed
+		}
+		result := o.execTarget(ctx, target, executor, compiled.Cmd())
+		result.Script = &compiled
+		return result
+	})
+}
+
+// targetScript returns the script with the target's fields and env merged onto its
+// own. The script's data is copied on write, so scripts for each target can be
+// created concurrently, while keeping its templating, required and secret
+// fields and logger.
+func targetScript(script nescript.Script, target Target) nescript.Script {
+	return script.WithFields(target.Fields, true).WithMergedEnv(target.Env...)
+}
+
+// fanOut runs the func for every target, with the concurrency, timeouts and
+// fail fast behavior of the options.
+func (o *options) fanOut(ctx context.Context, targets []Target, run func(context.Context, Target) TargetResult) (*FanOutResults, error) {
+	names := make(map[string]bool, len(targets))
+	for _, target := range targets {
+		if names[target.Name] {
+			return nil, fmt.Errorf("%w: duplicate target '%s'", ErrInvalidInventory, target.Name)
+		}
+		names[target.Name] = true
+	}
+	ctx, cancel := withTimeout(ctx, o.fanOutTimeout)
+	defer cancel()
+	concurrency := o.concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		failed  atomic.Bool
+		results = &FanOutResults{Targets: make(map[string]TargetResult, len(targets))}
+		slots   = make(chan struct{}, concurrency)
+	)
+	record := func(result TargetResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		results.Targets[result.Target] = result
+		switch result.Outcome {
+		case OutcomeSucceeded:
+			results.Succeeded++
+		case OutcomeFailed:
+			results.Failed++
+		case OutcomeTimedOut:
+			results.TimedOut++
+		case OutcomeSkipped:
+			results.Skipped++
+		}
+		if o.progress != nil {
+			o.progress(result, len(results.Targets), len(targets))
+		}
+	}
+	for _, target := range targets {
+		slots <- struct{}{}
+		if err := ctx.Err(); err != nil || (o.failFast && failed.Load()) {
+			<-slots
+			if err == nil {
+				err = ErrSkipped
+			} else {
+				err = fmt.Errorf("%w: %w", ErrSkipped, err)
+			}
+			record(TargetResult{Target: target.Name, Type: target.Type, Outcome: OutcomeSkipped, Err: err})
+			continue
+		}
+		wg.Add(1)
+		go func(target Target) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			result := run(ctx, target)
+			if result.Outcome != OutcomeSucceeded {
+				failed.Store(true)
+			}
+			record(result)
+		}(target)
+	}
+	wg.Wait()
+	errs := make(map[string]error)
+	for name, result := range results.Targets {
+		if result.Err != nil {
+			errs[name] = result.Err
+		}
+	}
+	if len(errs) > 0 {
+		return results, &FanOutError{Errors: errs}
+	}
+	return results, nil
+}
+
+// execTarget executes the cmd on the target, within the target timeout.
+func (o *options) execTarget(ctx context.Context, target Target, executor nescript.ExecFunc, c nescript.Cmd) TargetResult {
+	ctx, cancel := withTimeout(ctx, o.targetTimeout)
+	defer cancel()
+	start := time.Now()
+	result, err := execResult(ctx, executor, c.WithContext(ctx))
+	outcome := TargetResult{
+		Target:   target.Name,
+		Type:     target.Type,
+		Result:   result,
+		Err:      err,
+		Duration: time.Since(start),
+	}
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded) && (err != nil || !result.Success()):
+		outcome.Outcome = OutcomeTimedOut
+	case err != nil || !result.Success():
+		outcome.Outcome = OutcomeFailed
+	default:
+		outcome.Outcome = OutcomeSucceeded
+	}
+	return outcome
+}
+
+// execResult executes the cmd and waits for its result. Should the context be
+// done first, the process is killed, as not every executor stops the script
+// once its cmd's context is done.
+func execResult(ctx context.Context, executor nescript.ExecFunc, c nescript.Cmd) (*nescript.Result, error) {
+	process, err := c.Exec(executor)
+	if err != nil {
+		return nil, err
+	}
+	defer process.Close()
+	type completed struct {
+		result *nescript.Result
+		err    error
+	}
+	done := make(chan completed, 1)
+	go func() {
+		result, err := process.Result()
+		done <- completed{result, err}
+	}()
+	select {
+	case completed := <-done:
+		return completed.result, completed.err
+	case <-ctx.Done():
+	}
+	process.Kill()
+	select {
+	case <-done:
+	case <-time.After(killGrace):
+	}
+	return nil, fmt.Errorf("script killed: %w", nescript.ContextError(ctx.Err()))
+}
+
+// withTimeout limits the context by the timeout, if one is given.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
@@ -0,0 +1,51 @@
+package inventory_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/inventory"
+)
+
+func TestExecScriptAllCompilesPerTarget(t *testing.T) {
+	targets := make([]inventory.Target, 3)
+	for i := range targets {
+		targets[i] = inventory.Target{
+			Name:   fmt.Sprintf("node-%d", i),
+			Type:   "local",
+			Fields: map[string]any{"NodeIndex": i},
+			Env:    []string{fmt.Sprintf("ROLE=role-%d", i)},
+		}
+	}
+	script := nescript.NewScript("echo [[.NodeIndex]] $ROLE $TZ [[.token]] {{.kept}}").
+		WithDelims("[[", "]]").
+		WithRequiredFields("NodeIndex").
+		WithSecretField("token", "s3cr3t").
+		WithEnv("ROLE=base", "TZ=UTC")
+	results, err := inventory.ExecScriptAll(context.Background(), targets, script, inventory.WithConcurrency(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, target := range targets {
+		result := results.Targets[target.Name]
+		if result.Outcome != inventory.OutcomeSucceeded {
+			t.Fatalf("%s: expected success, got %s: %v", target.Name, result.Outcome, result.Err)
+		}
+		expected := fmt.Sprintf("%d role-%d UTC s3cr3t {{.kept}}\n", i, i)
+		if result.Result.StdOut != expected {
+			t.Errorf("%s: expected stdout %q, got %q", target.Name, expected, result.Result.StdOut)
+		}
+		if env := result.Script.Env(); len(env) != 2 || env[0] != fmt.Sprintf("ROLE=role-%d", i) {
+			t.Errorf("%s: expected the target's env to replace the script's, got %v", target.Name, env)
+		}
+		if strings.Contains(result.Script.String(), "s3cr3t") {
+			t.Errorf("%s: expected the secret redacted from the script, got %s", target.Name, result.Script)
+		}
+	}
+	if env := script.Env(); len(env) != 2 || env[0] != "ROLE=base" {
+		t.Errorf("expected the script's env to be unchanged, got %v", env)
+	}
+}
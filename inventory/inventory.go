@@ -0,0 +1,132 @@
+package inventory
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Inventory is a list of named targets scripts can be fanned out to (see
+// ExecScriptAll), such as loaded from a fleet manifest:
+//
+//	targets:
+//	  - name: web-01
+//	    type: ssh
+//	    tags: [web, eu]
+//	    options:
+//	      address: web-01.internal:22
+//	      user: deploy
+//	      keyFile: ~/.ssh/deploy
+//	    env: [ROLE=frontend]
+//	  - name: cache
+//	    type: docker
+//	    options:
+//	      container: redis-cache
+type Inventory struct {
+	Targets []Target `yaml:"targets" json:"targets"`
+}
+
+// Target is a named target of an inventory, executed on by the executor its
+// type's builder creates from its options (see Register).
+type Target struct {
+	// Name identifies the target in the results, and must be unique in the
+	// inventory.
+	Name string `yaml:"name" json:"name"`
+
+	// Type selects the executor builder, such as local, ssh, docker or
+	// kubernetes.
+	Type string `yaml:"type" json:"type"`
+
+	// Tags are the labels targets are selected by (see Select).
+	Tags []string `yaml:"tags,omitempty" json:"tags,omitempty"`
+
+	// Options are the connection options of the target, as decoded by its
+	// type's builder (see DecodeOptions).
+	Options map[string]any `yaml:"options,omitempty" json:"options,omitempty"`
+
+	// Fields are template fields merged onto the script's fields for this
+	// target only, replacing any of the same key.
+	Fields map[string]any `yaml:"fields,omitempty" json:"fields,omitempty"`
+
+	// Env are env vars in KEY=VALUE format merged onto the script's env for
+	// this target only, replacing any of the same key.
+	Env []string `yaml:"env,omitempty" json:"env,omitempty"`
+}
+
+// HasTags reports whether the target has every one of the tags.
+func (t Target) HasTags(tags ...string) bool {
+	for _, tag := range tags {
+		if !slices.Contains(t.Tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// Load loads the inventory from the YAML (or JSON) file at the path.
+func Load(path string) (*Inventory, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read inventory: %w", err)
+	}
+	return Parse(data)
+}
+
+// Parse parses the inventory from YAML (or JSON), validating that every target
+// has a unique name and a type. Unknown keys are rejected, so that a misspelt
+// key is not silently ignored.
+func Parse(data []byte) (*Inventory, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	var inventory Inventory
+	if err := decoder.Decode(&inventory); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidInventory, err)
+	}
+	if err := inventory.Validate(); err != nil {
+		return nil, err
+	}
+	return &inventory, nil
+}
+
+// Validate checks that every target has a unique name and a type.
+func (inv *Inventory) Validate() error {
+	names := make(map[string]bool, len(inv.Targets))
+	for i, target := range inv.Targets {
+		switch {
+		case target.Name == "":
+			return fmt.Errorf("%w: target %d has no name", ErrInvalidInventory, i)
+		case names[target.Name]:
+			return fmt.Errorf("%w: duplicate target '%s'", ErrInvalidInventory, target.Name)
+		case target.Type == "":
+			return fmt.Errorf("%w: target '%s' has no type", ErrInvalidInventory, target.Name)
+		}
+		names[target.Name] = true
+	}
+	return nil
+}
+
+// Select returns the targets with every one of the tags, in inventory order.
+// With no tags, every target is returned.
+func (inv *Inventory) Select(tags ...string) []Target {
+	selected := make([]Target, 0, len(inv.Targets))
+	for _, target := range inv.Targets {
+		if target.HasTags(tags...) {
+			selected = append(selected, target)
+		}
+	}
+	return selected
+}
+
+// Target returns the target of the name, false being returned if there is
+// none.
+func (inv *Inventory) Target(name string) (Target, bool) {
+	for _, target := range inv.Targets {
+		if target.Name == name {
+			return target, true
+		}
+	}
+	return Target{}, false
+}
@@ -0,0 +1,62 @@
+package inventory
+
+import "time"
+
+// Option configures a fan-out across the targets of an inventory.
+type Option func(*options)
+
+type options struct {
+	concurrency   int
+	targetTimeout time.Duration
+	fanOutTimeout time.Duration
+	failFast      bool
+	progress      ProgressFunc
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithConcurrency sets the maximum number of targets the script is executed on
+// at once. By default, it is executed on one target at a time.
+func WithConcurrency(n int) Option {
+	return func(o *options) {
+		o.concurrency = n
+	}
+}
+
+// WithTargetTimeout limits how long the script may take on each target, after
+// which that target's outcome is OutcomeTimedOut.
+func WithTargetTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.targetTimeout = timeout
+	}
+}
+
+// WithFanOutTimeout limits how long the fan-out may take as a whole. Targets
+// not yet reached once it passes are skipped.
+func WithFanOutTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.fanOutTimeout = timeout
+	}
+}
+
+// WithFailFast stops the fan-out from starting executions on any further
+// targets once the script fails on one. Executions already started run to
+// completion.
+func WithFailFast(failFast bool) Option {
+	return func(o *options) {
+		o.failFast = failFast
+	}
+}
+
+// WithProgress sets a func the fan-out calls as each target completes.
+func WithProgress(progress ProgressFunc) Option {
+	return func(o *options) {
+		o.progress = progress
+	}
+}
@@ -0,0 +1,252 @@
+package inventory
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/docker"
+	"github.com/neaas/nescript/k8s"
+	"github.com/neaas/nescript/local"
+	"github.com/neaas/nescript/sshe"
+	"gopkg.in/yaml.v3"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Builder creates the executor of a target from its options (see
+// DecodeOptions).
+type Builder func(target Target) (nescript.ExecFunc, error)
+
+var (
+	buildersMu sync.RWMutex
+	builders   = map[string]Builder{
+		"local":      buildLocal,
+		"ssh":        buildSSH,
+		"docker":     buildDocker,
+		"kubernetes": buildKubernetes,
+		"k8s":        buildKubernetes,
+	}
+)
+
+// Register registers the builder for targets of the type, such as for an
+// executor of another package, replacing any already registered for it. The
+// local, ssh, docker and kubernetes (or k8s) types are registered by default.
+func Register(typ string, builder Builder) {
+	buildersMu.Lock()
+	defer buildersMu.Unlock()
+	builders[typ] = builder
+}
+
+// Types returns the registered target types, sorted.
+func Types() []string {
+	buildersMu.RLock()
+	defer buildersMu.RUnlock()
+	types := make([]string, 0, len(builders))
+	for typ := range builders {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Executor creates the executor of the target with the builder registered for
+// its type.
+func Executor(target Target) (nescript.ExecFunc, error) {
+	buildersMu.RLock()
+	builder, ok := builders[target.Type]
+	buildersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: '%s'", ErrUnknownType, target.Type)
+	}
+	return builder(target)
+}
+
+// DecodeOptions decodes the target's options into the struct pointed to by
+// into, by its yaml tags. Options unknown to the struct are rejected.
+func DecodeOptions(target Target, into any) error {
+	data, err := yaml.Marshal(target.Options)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidOptions, err)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	// an empty document decodes as EOF, leaving the struct as it is.
+	if err := decoder.Decode(into); err != nil && len(target.Options) > 0 {
+		return fmt.Errorf("%w: %w", ErrInvalidOptions, err)
+	}
+	return nil
+}
+
+// LocalOptions are the options of local targets, run by local.Executor.
+type LocalOptions struct {
+	WorkDir string `yaml:"workdir"`
+}
+
+func buildLocal(target Target) (nescript.ExecFunc, error) {
+	var o LocalOptions
+	if err := DecodeOptions(target, &o); err != nil {
+		return nil, err
+	}
+	return local.Executor(o.WorkDir), nil
+}
+
+// SSHOptions are the options of ssh targets, run by sshe.Executor. The target
+// is either given by its address (host or host:port, the port defaulting to
+// 22), or by a host alias of ~/.ssh/config, whose settings are used. Without a
+// password or key file, the SSH agent is used.
+type SSHOptions struct {
+	Address    string `yaml:"address"`
+	Alias      string `yaml:"alias"`
+	User       string `yaml:"user"`
+	Password   string `yaml:"password"`
+	KeyFile    string `yaml:"keyFile"`
+	Passphrase string `yaml:"passphrase"`
+	Agent      bool   `yaml:"agent"`
+	KnownHosts string `yaml:"knownHosts"`
+
+	// HostKeys is how host keys are verified: strict (the default),
+	// accept-new or insecure.
+	HostKeys string `yaml:"hostKeys"`
+}
+
+func buildSSH(target Target) (nescript.ExecFunc, error) {
+	var o SSHOptions
+	if err := DecodeOptions(target, &o); err != nil {
+		return nil, err
+	}
+	var opts []sshe.Option
+	if o.User != "" {
+		opts = append(opts, sshe.WithUser(o.User))
+	}
+	if o.Password != "" {
+		opts = append(opts, sshe.WithPassword(o.Password))
+	}
+	if o.KeyFile != "" {
+		opts = append(opts, sshe.WithPrivateKeyFile(expandHome(o.KeyFile), o.Passphrase))
+	}
+	if o.Agent || (o.Password == "" && o.KeyFile == "" && o.Alias == "") {
+		opts = append(opts, sshe.WithAgent())
+	}
+	if o.KnownHosts != "" {
+		opts = append(opts, sshe.WithKnownHosts(expandHome(o.KnownHosts)))
+	}
+	switch o.HostKeys {
+	case "", "strict":
+	case "accept-new":
+		opts = append(opts, sshe.WithHostKeyMode(sshe.HostKeyAcceptNew))
+	case "insecure":
+		opts = append(opts, sshe.WithHostKeyMode(sshe.HostKeyInsecure))
+	default:
+		return nil, fmt.Errorf("%w: unknown hostKeys '%s' of target '%s'", ErrInvalidOptions, o.HostKeys, target.Name)
+	}
+	if o.Alias != "" {
+		config, err := sshe.LoadSSHConfig(o.Alias)
+		if err != nil {
+			return nil, fmt.Errorf("%w: target '%s': %w", ErrInvalidOptions, target.Name, err)
+		}
+		return config.Executor(opts...), nil
+	}
+	if o.Address == "" {
+		return nil, fmt.Errorf("%w: target '%s' has no address or alias", ErrInvalidOptions, target.Name)
+	}
+	address := o.Address
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "22")
+	}
+	return sshe.Executor(address, nil, opts...), nil
+}
+
+// DockerOptions are the options of docker targets, executed in the existing
+// container by docker.Executor, or in a new container from the image by
+// docker.RunExecutor. The docker engine is that at the host if given,
+// otherwise as found by docker.NewClient.
+type DockerOptions struct {
+	Container string `yaml:"container"`
+	Image     string `yaml:"image"`
+	Host      string `yaml:"host"`
+	WorkDir   string `yaml:"workdir"`
+}
+
+func buildDocker(target Target) (nescript.ExecFunc, error) {
+	var o DockerOptions
+	if err := DecodeOptions(target, &o); err != nil {
+		return nil, err
+	}
+	var opts []docker.Option
+	if o.Host != "" {
+		opts = append(opts, docker.WithHost(o.Host))
+	}
+	switch {
+	case o.Container != "" && o.Image != "":
+		return nil, fmt.Errorf("%w: target '%s' has both a container and an image", ErrInvalidOptions, target.Name)
+	case o.Container != "":
+		return docker.Executor(nil, o.Container, o.WorkDir, opts...), nil
+	case o.Image != "":
+		if o.WorkDir != "" {
+			opts = append(opts, docker.WithWorkDir(o.WorkDir))
+		}
+		return docker.RunExecutor(nil, o.Image, opts...), nil
+	}
+	return nil, fmt.Errorf("%w: target '%s' has no container or image", ErrInvalidOptions, target.Name)
+}
+
+// KubernetesOptions are the options of kubernetes targets, run in a new job
+// from the image by k8s.JobExecutor. The cluster is that of the kubeconfig
+// (the default loading rules, such as $KUBECONFIG, being used if not given) and
+// its current context, unless another is given.
+type KubernetesOptions struct {
+	Image          string `yaml:"image"`
+	Namespace      string `yaml:"namespace"`
+	Kubeconfig     string `yaml:"kubeconfig"`
+	Context        string `yaml:"context"`
+	ServiceAccount string `yaml:"serviceAccount"`
+}
+
+func buildKubernetes(target Target) (nescript.ExecFunc, error) {
+	var o KubernetesOptions
+	if err := DecodeOptions(target, &o); err != nil {
+		return nil, err
+	}
+	if o.Image == "" {
+		return nil, fmt.Errorf("%w: target '%s' has no image", ErrInvalidOptions, target.Name)
+	}
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if o.Kubeconfig != "" {
+		rules.ExplicitPath = expandHome(o.Kubeconfig)
+	}
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{CurrentContext: o.Context}).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubeconfig of target '%s': %w", target.Name, err)
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kubernetes client of target '%s': %w", target.Name, err)
+	}
+	var opts []k8s.Option
+	if o.Namespace != "" {
+		opts = append(opts, k8s.WithNamespace(o.Namespace))
+	}
+	if o.ServiceAccount != "" {
+		opts = append(opts, k8s.WithServiceAccount(o.ServiceAccount))
+	}
+	return k8s.JobExecutor(client, o.Image, opts...), nil
+}
+
+// expandHome expands a leading ~ of the path into the home directory.
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
+}
@@ -0,0 +1,75 @@
+package k8s
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/neaas/nescript"
+)
+
+var (
+	// ErrConnection is returned (wrapped) when a request to the k8s API fails,
+	// such as when the API server is unreachable or the client is not
+	// authorized, as opposed to a failure of the script pod.
+	ErrConnection = nescript.NewError(nescript.ErrConnection, "failed to call the k8s api")
+
+	// ErrImagePull is returned (wrapped in a *PodError) when the image of the
+	// script container can not be pulled, such as it not existing or the pull
+	// secret being missing.
+	ErrImagePull = errors.New("k8s failed to pull the script image")
+
+	// ErrUnschedulable is returned (wrapped in a *PodError) when the script pod
+	// can not be scheduled onto a node (for longer than the scheduling timeout,
+	// see WithSchedulingTimeout), such as no node matching its node selector or
+	// having the resources it requests.
+	ErrUnschedulable = errors.New("k8s could not schedule the script pod")
+
+	// ErrDeadlineExceeded is returned (wrapped in a *PodError) when the job did
+	// not complete within its deadline (see WithDeadline). The job is deleted.
+	ErrDeadlineExceeded = nescript.NewError(nescript.ErrTimeout, "k8s job deadline exceeded")
+
+	// ErrPodLost is returned (wrapped) when the job finished, however the script
+	// pod (or its container status) could not be found to collect the result
+	// from, such as it being evicted and deleted.
+	ErrPodLost = errors.New("k8s script pod lost")
+
+	// ErrSignalUnsupported is returned when a signal other than SIGTERM or
+	// SIGKILL is sent to a job process, as pods can only be deleted.
+	ErrSignalUnsupported = errors.New("signal not supported by k8s jobs")
+
+	// ErrStdinUnsupported is returned when writing to a job process, as job pods
+	// have no stdin attached.
+	ErrStdinUnsupported = errors.New("stdin not supported by k8s jobs")
+)
+
+// PodError describes why the script pod of a job failed to run, along with the
+// events recorded for the pod (which explain failures such as scheduling in
+// detail). Err is the reason for the failure, such as ErrImagePull.
+type PodError struct {
+	Err     error
+	Job     string
+	Pod     string
+	Reason  string
+	Message string
+	Events  []Event
+}
+
+func (e *PodError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: job '%s'", e.Err, e.Job)
+	if e.Pod != "" {
+		fmt.Fprintf(&b, ", pod '%s'", e.Pod)
+	}
+	if e.Reason != "" {
+		fmt.Fprintf(&b, ": %s", e.Reason)
+	}
+	if e.Message != "" {
+		fmt.Fprintf(&b, ": %s", e.Message)
+	}
+	return b.String()
+}
+
+func (e *PodError) Unwrap() error {
+	return e.Err
+}
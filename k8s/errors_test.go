@@ -0,0 +1,61 @@
+package k8s_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/k8s"
+)
+
+func TestErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		opts   []k8s.Option
+		cancel time.Duration
+		refuse bool
+		pod    bool
+		is     []error
+		class  string
+	}{
+		{"api call failed", nil, 0, true, false, []error{k8s.ErrConnection, nescript.ErrConnection}, nescript.ErrorClassConnection},
+		{"deadline", []k8s.Option{k8s.WithDeadline(100 * time.Millisecond)}, 0, false, true, []error{k8s.ErrDeadlineExceeded, nescript.ErrTimeout}, nescript.ErrorClassTimeout},
+		{"cancelled", nil, 100 * time.Millisecond, false, false, []error{nescript.ErrCancelled, context.Canceled}, nescript.ErrorClassCancelled},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client := newFakeClient(t, nil)
+			if tc.refuse {
+				client.PrependReactor("create", "jobs", func(k8stesting.Action) (bool, runtime.Object, error) {
+					return true, nil, errors.New("connection refused")
+				})
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tc.cancel > 0 {
+				time.AfterFunc(tc.cancel, cancel)
+			}
+			opts := append([]k8s.Option{k8s.WithPollInterval(10 * time.Millisecond)}, tc.opts...)
+			process, err := nescript.NewScript("sleep 30").Cmd().WithContext(ctx).Exec(k8s.JobExecutor(client, "alpine", opts...))
+			if err == nil {
+				_, err = process.Result()
+			}
+			for _, target := range tc.is {
+				if !errors.Is(err, target) {
+					t.Errorf("expected %v to match %v", err, target)
+				}
+			}
+			var podErr *k8s.PodError
+			if tc.pod && !errors.As(err, &podErr) {
+				t.Errorf("expected a *PodError, got %v", err)
+			}
+			if class := nescript.ErrorClass(err); class != tc.class {
+				t.Errorf("expected the class %s, got %s", tc.class, class)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package k8s
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Event is an event recorded by kubernetes for the script pod, such as the
+// scheduler reporting why it could not be scheduled.
+type Event struct {
+	Type     string    `json:"type"`
+	Reason   string    `json:"reason"`
+	Message  string    `json:"message"`
+	Count    int32     `json:"count,omitempty"`
+	LastSeen time.Time `json:"lastSeen,omitempty"`
+}
+
+// podEvents lists the events recorded for the pod, oldest first. Failing to
+// list them gives no events, as they only explain a failure already found.
+func podEvents(ctx context.Context, client kubernetes.Interface, namespace, pod string) []Event {
+	list, err := client.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("involvedObject.name", pod).String(),
+	})
+	if err != nil {
+		return nil
+	}
+	events := make([]Event, 0, len(list.Items))
+	for _, item := range list.Items {
+		events = append(events, Event{
+			Type:     item.Type,
+			Reason:   item.Reason,
+			Message:  item.Message,
+			Count:    item.Count,
+			LastSeen: lastSeen(item),
+		})
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].LastSeen.Before(events[j].LastSeen)
+	})
+	return events
+}
+
+// lastSeen returns when the event was last recorded, which depending on the
+// component reporting it may be in any of its timestamps.
+func lastSeen(event corev1.Event) time.Time {
+	switch {
+	case !event.LastTimestamp.IsZero():
+		return event.LastTimestamp.Time
+	case !event.EventTime.IsZero():
+		return event.EventTime.Time
+	default:
+		return event.FirstTimestamp.Time
+	}
+}
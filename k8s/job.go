@@ -0,0 +1,129 @@
+package k8s
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"strings"
+
+	"github.com/neaas/nescript"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// LabelManaged labels every job (and its pod) created by the JobExecutor,
+	// so that jobs left behind can be found.
+	LabelManaged = "app.kubernetes.io/managed-by"
+
+	// managedBy is the value of LabelManaged.
+	managedBy = "nescript"
+
+	// scriptContainer is the name of the container running the script.
+	scriptContainer = "script"
+
+	// jobNameLabel is the label set on pods by the job controller, naming
+	// their job.
+	jobNameLabel = "job-name"
+)
+
+// JobExecutor provides an ExecFunc that will run the script/cmd to completion
+// in a new kubernetes Job, whose single container is created from the given
+// image. The result is collected from the pod's logs and the container's exit
+// code once the job finishes, and the job (along with its pod) is deleted once
+// the process is closed (see WithTTL). Jobs do not retry the script unless
+// requested with WithBackoffLimit. A client (such as the *kubernetes.Clientset
+// created from a kubeconfig, or a fake for testing) must be given, which the
+// executor uses for every execution. This ExecFunc does not require that the
+// cmd/script be converted to a string, so is Formatter agnostic.
+func JobExecutor(client kubernetes.Interface, image string, opts ...Option) nescript.ExecFunc {
+	o := newOptions(opts)
+	return func(c nescript.Cmd) (nescript.Process, error) {
+		job := o.job(image, command(c, o.shell), c.Env())
+		created, err := client.BatchV1().Jobs(o.namespace).Create(c.Context(), job, metav1.CreateOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to create k8s job in namespace '%s': %w", ErrConnection, o.namespace, err)
+		}
+		ctx, cancel := withDeadline(c.Context(), o.deadline)
+		process := &JobProcess{
+			client:    client,
+			namespace: o.namespace,
+			name:      created.Name,
+			deleteJob: o.ttl == nil,
+			cancel:    cancel,
+			done:      make(chan struct{}),
+			tee:       nescript.NewTee(c, nil, nil),
+		}
+		go process.watch(c.Context(), ctx, o.pollInterval, o.scheduling)
+		return process, nil
+	}
+}
+
+// command returns the command the script container runs. Cmds created from
+// a script are invoked with the shell, if given.
+func command(c nescript.Cmd, shell nescript.Subcommand) []string {
+	_, script, trailing, ok := c.Script()
+	if !ok || shell == nil {
+		return c.Raw()
+	}
+	command := append(append([]string{}, shell...), script)
+	return append(command, trailing...)
+}
+
+// job builds the job running the command, which never restarts its pod in
+// place, so that each attempt is a new pod with its own exit code and logs.
+func (o *options) job(image string, command []string, env []string) *batchv1.Job {
+	labels := map[string]string{}
+	maps.Copy(labels, o.labels)
+	labels[LabelManaged] = managedBy
+	container := corev1.Container{
+		Name:            scriptContainer,
+		Image:           image,
+		Command:         command,
+		Env:             envVars(env),
+		Resources:       o.resources,
+		ImagePullPolicy: o.pullPolicy,
+	}
+	backoffLimit := o.backoffLimit
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "nescript-",
+			Labels:       labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy:      corev1.RestartPolicyNever,
+					ServiceAccountName: o.serviceAccount,
+					NodeSelector:       o.nodeSelector,
+					Containers:         []corev1.Container{container},
+				},
+			},
+		},
+	}
+	if o.deadline > 0 {
+		seconds := int64(math.Ceil(o.deadline.Seconds()))
+		job.Spec.ActiveDeadlineSeconds = &seconds
+	}
+	if o.ttl != nil {
+		seconds := int32(math.Ceil(o.ttl.Seconds()))
+		job.Spec.TTLSecondsAfterFinished = &seconds
+	}
+	return job
+}
+
+// envVars converts the cmd's env (KEY=value) into container env vars.
+func envVars(env []string) []corev1.EnvVar {
+	vars := make([]corev1.EnvVar, 0, len(env))
+	for _, e := range env {
+		name, value, _ := strings.Cut(e, "=")
+		vars = append(vars, corev1.EnvVar{Name: name, Value: value})
+	}
+	return vars
+}
@@ -0,0 +1,151 @@
+package k8s
+
+import (
+	"time"
+
+	"github.com/neaas/nescript"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// defaultNamespace is the namespace jobs are created in, unless another is
+	// given with WithNamespace.
+	defaultNamespace = "default"
+
+	// defaultPollInterval is how often the job and its pod are checked while
+	// waiting for the script to complete.
+	defaultPollInterval = time.Second
+)
+
+// Option configures a k8s ExecFunc.
+type Option func(*options)
+
+type options struct {
+	namespace      string
+	shell          nescript.Subcommand
+	resources      corev1.ResourceRequirements
+	serviceAccount string
+	nodeSelector   map[string]string
+	pullPolicy     corev1.PullPolicy
+	labels         map[string]string
+
+	backoffLimit int32
+	ttl          *time.Duration
+	deadline     time.Duration
+	scheduling   time.Duration
+	pollInterval time.Duration
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		namespace:    defaultNamespace,
+		pollInterval: defaultPollInterval,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithNamespace sets the namespace the job is created in, which is "default"
+// unless given.
+func WithNamespace(namespace string) Option {
+	return func(o *options) {
+		o.namespace = namespace
+	}
+}
+
+// WithShell sets the shell scripts are invoked with in the script container,
+// such as nescript.SCBash, rather than the cmd's subcommand. Cmds not created
+// from a script are unaffected.
+func WithShell(shell nescript.Subcommand) Option {
+	return func(o *options) {
+		o.shell = shell
+	}
+}
+
+// WithResources sets the resource requests and limits of the script container.
+func WithResources(resources corev1.ResourceRequirements) Option {
+	return func(o *options) {
+		o.resources = resources
+	}
+}
+
+// WithServiceAccount sets the service account the script pod runs as.
+func WithServiceAccount(name string) Option {
+	return func(o *options) {
+		o.serviceAccount = name
+	}
+}
+
+// WithNodeSelector restricts the nodes the script pod can be scheduled onto
+// to those with all of the given labels.
+func WithNodeSelector(selector map[string]string) Option {
+	return func(o *options) {
+		o.nodeSelector = selector
+	}
+}
+
+// WithImagePullPolicy sets when the image of the script container is pulled
+// by the node. By default, kubernetes pulls images tagged latest (or
+// untagged) every time, and others only if not present.
+func WithImagePullPolicy(policy corev1.PullPolicy) Option {
+	return func(o *options) {
+		o.pullPolicy = policy
+	}
+}
+
+// WithLabels adds labels to the job and its pod, in addition to the labels
+// identifying it as managed by nescript.
+func WithLabels(labels map[string]string) Option {
+	return func(o *options) {
+		o.labels = labels
+	}
+}
+
+// WithBackoffLimit sets the number of times the job retries the script pod if
+// it fails. This is 0 by default, so that the script runs exactly once. The
+// result is that of the last attempt.
+func WithBackoffLimit(retries int32) Option {
+	return func(o *options) {
+		o.backoffLimit = retries
+	}
+}
+
+// WithTTL leaves the job to be deleted by the cluster the given time after it
+// finishes (with the job's ttlSecondsAfterFinished), rather than deleting it
+// once the process is closed. This keeps the job and its logs available for
+// inspection. Jobs that fail to run (such as ErrImagePull) are still deleted.
+func WithTTL(after time.Duration) Option {
+	return func(o *options) {
+		o.ttl = &after
+	}
+}
+
+// WithDeadline sets the overall time the job may take, from being created
+// until the script completes, including scheduling and pulling the image.
+// This is set as the job's activeDeadlineSeconds, and once passed the job is
+// deleted and the execution fails with ErrDeadlineExceeded.
+func WithDeadline(deadline time.Duration) Option {
+	return func(o *options) {
+		o.deadline = deadline
+	}
+}
+
+// WithSchedulingTimeout sets how long the script pod may remain unschedulable
+// before the execution fails with ErrUnschedulable, such as to allow a cluster
+// autoscaler to add a node. By default, the execution fails as soon as the
+// scheduler reports the pod unschedulable.
+func WithSchedulingTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.scheduling = timeout
+	}
+}
+
+// WithPollInterval sets how often the job and its pod are checked while
+// waiting for the script to complete, which is every second by default.
+func WithPollInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.pollInterval = interval
+	}
+}
@@ -0,0 +1,359 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/neaas/nescript"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// MetadataJob is the result metadata key holding the name of the job the
+	// script was run by.
+	MetadataJob = "k8s.job"
+
+	// MetadataPod is the result metadata key holding the name of the pod the
+	// result was collected from (the last attempt, if the job retried).
+	MetadataPod = "k8s.pod"
+
+	// MetadataNode is the result metadata key holding the name of the node the
+	// script pod ran on.
+	MetadataNode = "k8s.node"
+)
+
+// JobProcess represents a single instance of the script running or completed
+// in a job created by the JobExecutor.
+type JobProcess struct {
+	client     kubernetes.Interface
+	namespace  string
+	name       string
+	deleteJob  bool
+	cancel     context.CancelFunc
+	done       chan struct{}
+	deleteOnce sync.Once
+	tee        *nescript.Tee
+
+	// set by watch, before done is closed.
+	pod *corev1.Pod
+	err error
+}
+
+// JobName returns the name of the job running the script.
+func (p *JobProcess) JobName() string {
+	return p.name
+}
+
+// watch waits for the job to finish, polling the job and its latest pod each
+// interval, and failing early if the pod can not pull its image or be
+// scheduled. If the context is done first, the job is deleted.
+func (p *JobProcess) watch(parent, ctx context.Context, interval, scheduling time.Duration) {
+	defer close(p.done)
+	defer p.cancel()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var unschedulable time.Time
+	for {
+		finished, err := p.check(ctx, scheduling, &unschedulable)
+		if ctx.Err() != nil {
+			break
+		}
+		if err != nil {
+			p.err = err
+			p.delete()
+			return
+		}
+		if finished {
+			return
+		}
+		select {
+		case <-ctx.Done():
+		case <-ticker.C:
+			continue
+		}
+		break
+	}
+	if parent.Err() == nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		pod, _ := p.latestPod(context.Background())
+		p.err = p.podError(context.Background(), ErrDeadlineExceeded, pod, "DeadlineExceeded", "job did not complete within its deadline")
+	} else {
+		p.err = fmt.Errorf("k8s job '%s' was cancelled: %w", p.name, nescript.ContextError(ctx.Err()))
+	}
+	p.delete()
+}
+
+// check reports whether the job has finished, or why it can not. The pod
+// having been unschedulable since the time given for longer than the
+// scheduling timeout fails with ErrUnschedulable.
+func (p *JobProcess) check(ctx context.Context, scheduling time.Duration, unschedulable *time.Time) (bool, error) {
+	job, err := p.client.BatchV1().Jobs(p.namespace).Get(ctx, p.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return true, fmt.Errorf("%w: job '%s' was deleted", ErrPodLost, p.name)
+	}
+	if err != nil {
+		return false, fmt.Errorf("%w: failed to get k8s job '%s': %w", ErrConnection, p.name, err)
+	}
+	pod, err := p.latestPod(ctx)
+	if err != nil {
+		return false, err
+	}
+	if failed := jobCondition(job, batchv1.JobFailed); failed != nil && failed.Reason == batchv1.JobReasonDeadlineExceeded {
+		return true, p.podError(ctx, ErrDeadlineExceeded, pod, failed.Reason, failed.Message)
+	}
+	if jobCondition(job, batchv1.JobComplete) != nil || jobCondition(job, batchv1.JobFailed) != nil {
+		if pod == nil || terminated(pod) == nil {
+			return true, fmt.Errorf("%w: no terminated script container found for job '%s'", ErrPodLost, p.name)
+		}
+		p.pod = pod
+		return true, nil
+	}
+	if pod == nil {
+		return false, nil
+	}
+	if waiting := pullFailure(pod); waiting != nil {
+		return true, p.podError(ctx, ErrImagePull, pod, waiting.Reason, waiting.Message)
+	}
+	scheduled := podCondition(pod, corev1.PodScheduled)
+	if scheduled == nil || scheduled.Status != corev1.ConditionFalse || scheduled.Reason != corev1.PodReasonUnschedulable {
+		*unschedulable = time.Time{}
+		return false, nil
+	}
+	if unschedulable.IsZero() {
+		*unschedulable = time.Now()
+	}
+	if time.Since(*unschedulable) >= scheduling {
+		return true, p.podError(ctx, ErrUnschedulable, pod, scheduled.Reason, scheduled.Message)
+	}
+	return false, nil
+}
+
+// latestPod returns the most recently created pod of the job, or nil if it has
+// none (yet).
+func (p *JobProcess) latestPod(ctx context.Context) (*corev1.Pod, error) {
+	pods, err := p.client.CoreV1().Pods(p.namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(labels.Set{jobNameLabel: p.name}).String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to list pods of k8s job '%s': %w", ErrConnection, p.name, err)
+	}
+	var latest *corev1.Pod
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if latest == nil || latest.CreationTimestamp.Before(&pod.CreationTimestamp) {
+			latest = pod
+		}
+	}
+	return latest, nil
+}
+
+// podError describes the failure of the pod, along with its events.
+func (p *JobProcess) podError(ctx context.Context, err error, pod *corev1.Pod, reason, message string) *PodError {
+	podErr := &PodError{
+		Err:     err,
+		Job:     p.name,
+		Reason:  reason,
+		Message: message,
+	}
+	if pod != nil {
+		podErr.Pod = pod.Name
+		podErr.Events = podEvents(ctx, p.client, p.namespace, pod.Name)
+	}
+	return podErr
+}
+
+func jobCondition(job *batchv1.Job, conditionType batchv1.JobConditionType) *batchv1.JobCondition {
+	for i, condition := range job.Status.Conditions {
+		if condition.Type == conditionType && condition.Status == corev1.ConditionTrue {
+			return &job.Status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+func podCondition(pod *corev1.Pod, conditionType corev1.PodConditionType) *corev1.PodCondition {
+	for i, condition := range pod.Status.Conditions {
+		if condition.Type == conditionType {
+			return &pod.Status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// scriptStatus returns the status of the script container of the pod.
+func scriptStatus(pod *corev1.Pod) *corev1.ContainerStatus {
+	for i, status := range pod.Status.ContainerStatuses {
+		if status.Name == scriptContainer {
+			return &pod.Status.ContainerStatuses[i]
+		}
+	}
+	return nil
+}
+
+// terminated returns the terminated state of the script container, or nil if
+// it has not terminated.
+func terminated(pod *corev1.Pod) *corev1.ContainerStateTerminated {
+	if status := scriptStatus(pod); status != nil {
+		return status.State.Terminated
+	}
+	return nil
+}
+
+// pullFailure returns the waiting state of the script container if it is
+// waiting as its image can not be pulled.
+func pullFailure(pod *corev1.Pod) *corev1.ContainerStateWaiting {
+	status := scriptStatus(pod)
+	if status == nil || status.State.Waiting == nil {
+		return nil
+	}
+	switch status.State.Waiting.Reason {
+	case "ErrImagePull", "ImagePullBackOff", "InvalidImageName", "ErrImageNeverPull":
+		return status.State.Waiting
+	}
+	return nil
+}
+
+// Kill deletes the job, along with its pods without a grace period, so that
+// the script is killed immediately. Once the job has finished, Kill does
+// nothing.
+func (p *JobProcess) Kill() error {
+	select {
+	case <-p.done:
+		return nil
+	default:
+	}
+	if err := p.deletePods(0); err != nil {
+		return err
+	}
+	if err := p.stop(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Signal stops the script with SIGTERM (deleting the job, so the pod is sent
+// SIGTERM followed by SIGKILL after its termination grace period) or kills it
+// with SIGKILL (see Kill). Other signals are not supported by kubernetes.
+func (p *JobProcess) Signal(s os.Signal) error {
+	switch s {
+	case syscall.SIGKILL:
+		return p.Kill()
+	case syscall.SIGTERM:
+		return p.stop()
+	}
+	return fmt.Errorf("%w: %s", ErrSignalUnsupported, s)
+}
+
+func (p *JobProcess) Write(input string) error {
+	return ErrStdinUnsupported
+}
+
+func (p *JobProcess) Stdin() io.WriteCloser {
+	return nescript.StdinWriter(p)
+}
+
+func (p *JobProcess) Stdout() io.Reader {
+	return p.tee.StdoutStream()
+}
+
+func (p *JobProcess) Stderr() io.Reader {
+	return p.tee.StderrStream()
+}
+
+// ID returns the name of the job running the script (see JobName).
+func (p *JobProcess) ID() string {
+	return p.name
+}
+
+func (p *JobProcess) String() string {
+	return fmt.Sprintf("k8s job %s/%s", p.namespace, p.name)
+}
+
+func (p *JobProcess) Wait(ctx context.Context) (*nescript.Result, error) {
+	return nescript.Wait(ctx, p)
+}
+
+func (p *JobProcess) Result() (*nescript.Result, error) {
+	defer p.Close()
+	<-p.done
+	if p.err != nil {
+		return nil, p.err
+	}
+	state := terminated(p.pod)
+	logs, err := p.client.CoreV1().Pods(p.namespace).GetLogs(p.pod.Name, &corev1.PodLogOptions{Container: scriptContainer}).DoRaw(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to get logs of k8s pod '%s': %w", ErrConnection, p.pod.Name, err)
+	}
+	result := nescript.Result{
+		StdOut:   string(logs),
+		ExitCode: int(state.ExitCode),
+	}
+	p.tee.WriteOutput(result.StdOut, "")
+	if state.Signal != 0 {
+		result.SetSignal(int(state.Signal))
+	}
+	// the times are those reported for the script container, to the second.
+	result.SetTimes(state.StartedAt.Time, state.FinishedAt.Time)
+	result.SetMetadata(nescript.MetadataExecutor, "k8s")
+	result.SetMetadata(MetadataJob, p.name)
+	result.SetMetadata(MetadataPod, p.pod.Name)
+	result.SetMetadata(MetadataNode, p.pod.Spec.NodeName)
+	p.tee.Finish(&result)
+	return &result, nil
+}
+
+// Close stops waiting for the job, deleting it (and its pods) unless it is
+// left to be deleted by its TTL (see WithTTL).
+func (p *JobProcess) Close() {
+	p.cancel()
+	<-p.done
+	p.tee.Finish(nil)
+	if p.deleteJob {
+		p.delete()
+	}
+}
+
+// delete deletes the job, along with its pods.
+func (p *JobProcess) delete() {
+	p.deleteOnce.Do(func() {
+		p.stop()
+	})
+}
+
+func (p *JobProcess) stop() error {
+	propagation := metav1.DeletePropagationBackground
+	err := p.client.BatchV1().Jobs(p.namespace).Delete(context.Background(), p.name, metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete k8s job '%s': %w", p.name, err)
+	}
+	return nil
+}
+
+// deletePods deletes the pods of the job with the grace period (in seconds).
+func (p *JobProcess) deletePods(grace int64) error {
+	err := p.client.CoreV1().Pods(p.namespace).DeleteCollection(context.Background(), metav1.DeleteOptions{GracePeriodSeconds: &grace}, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(labels.Set{jobNameLabel: p.name}).String(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete pods of k8s job '%s': %w", p.name, err)
+	}
+	return nil
+}
+
+// withDeadline derives a context with the deadline, if it is positive.
+func withDeadline(ctx context.Context, deadline time.Duration) (context.Context, context.CancelFunc) {
+	if deadline > 0 {
+		return context.WithTimeout(ctx, deadline)
+	}
+	return context.WithCancel(ctx)
+}
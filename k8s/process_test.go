@@ -0,0 +1,104 @@
+package k8s_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/k8s"
+)
+
+// newFakeClient returns a fake clientset which names the jobs created, and
+// completes each with a pod whose script container terminated as given, or
+// leaves them running if state is nil.
+func newFakeClient(t *testing.T, state *corev1.ContainerStateTerminated) *fake.Clientset {
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		job := action.(k8stesting.CreateAction).GetObject().(*batchv1.Job)
+		if job.Name == "" {
+			job.Name = job.GenerateName + "test"
+		}
+		return false, nil, nil
+	})
+	if state == nil {
+		return client
+	}
+	client.PrependReactor("get", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		name := action.(k8stesting.GetAction).GetName()
+		namespace := action.GetNamespace()
+		if _, err := client.Tracker().Get(corev1.SchemeGroupVersion.WithResource("pods"), namespace, name+"-pod"); err == nil {
+			return false, nil, nil
+		}
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name + "-pod", Namespace: namespace, Labels: map[string]string{"job-name": name}},
+			Spec:       corev1.PodSpec{NodeName: "node-1"},
+			Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+				Name:  "script",
+				State: corev1.ContainerState{Terminated: state},
+			}}},
+		}
+		if err := client.Tracker().Add(pod); err != nil {
+			t.Error(err)
+		}
+		obj, err := client.Tracker().Get(batchv1.SchemeGroupVersion.WithResource("jobs"), namespace, name)
+		if err != nil {
+			return true, nil, err
+		}
+		job := obj.(*batchv1.Job)
+		job.Status.Conditions = append(job.Status.Conditions, batchv1.JobCondition{Type: batchv1.JobComplete, Status: corev1.ConditionTrue})
+		return true, job, client.Tracker().Update(batchv1.SchemeGroupVersion.WithResource("jobs"), job, namespace)
+	})
+	return client
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("broken pipe")
+}
+
+func TestJobExecutor(t *testing.T) {
+	started := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	client := newFakeClient(t, &corev1.ContainerStateTerminated{
+		ExitCode:   3,
+		StartedAt:  metav1.NewTime(started),
+		FinishedAt: metav1.NewTime(started.Add(2 * time.Second)),
+	})
+	cmd := nescript.NewScript("echo hello").Cmd().WithStdoutWriter(failingWriter{})
+	process, err := cmd.Exec(k8s.JobExecutor(client, "alpine", k8s.WithPollInterval(10*time.Millisecond)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := process.Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the fake clientset serves these logs for every pod.
+	if result.StdOut != "fake logs" || result.ExitCode != 3 {
+		t.Errorf("expected stdout %q and exit code 3, got %q and %d", "fake logs", result.StdOut, result.ExitCode)
+	}
+	for key, value := range map[string]any{
+		nescript.MetadataExecutor: "k8s",
+		k8s.MetadataJob:           "nescript-test",
+		k8s.MetadataPod:           "nescript-test-pod",
+		k8s.MetadataNode:          "node-1",
+	} {
+		if result.Metadata[key] != value {
+			t.Errorf("expected metadata %s %v, got %v", key, value, result.Metadata[key])
+		}
+	}
+	if warnings, _ := result.Metadata[nescript.MetadataWarnings].([]string); len(warnings) != 1 {
+		t.Errorf("expected the failed tee recorded as a warning, got %v", result.Metadata)
+	}
+	if duration := result.Duration(); duration != 2*time.Second {
+		t.Errorf("expected the script container's times, got a duration of %s", duration)
+	}
+}
@@ -0,0 +1,168 @@
+package libvirt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
+	"time"
+
+	golibvirt "github.com/digitalocean/go-libvirt"
+)
+
+// Conn is the part of the libvirt API used by the executor. It is satisfied by
+// go-libvirt's *libvirt.Libvirt, so an application's existing connection can be
+// given to the executor, or a fake given for testing. Executors never
+// disconnect a connection given to them.
+type Conn interface {
+	DomainLookupByName(name string) (golibvirt.Domain, error)
+	DomainGetState(dom golibvirt.Domain, flags uint32) (int32, int32, error)
+	QEMUDomainAgentCommand(dom golibvirt.Domain, cmd string, timeout int32, flags uint32) (golibvirt.OptString, error)
+}
+
+// isNilConn reports whether no connection was given, including a nil
+// *libvirt.Libvirt.
+func isNilConn(conn Conn) bool {
+	if conn == nil {
+		return true
+	}
+	c, ok := conn.(*golibvirt.Libvirt)
+	return ok && c == nil
+}
+
+// Connect connects to libvirt at the URI, such as qemu:///system (over the
+// local socket), qemu+ssh://user@host/system or qemu+tls://host/system. The
+// connection should be disconnected once it is no longer needed.
+func Connect(uri string) (*golibvirt.Libvirt, error) {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid uri '%s': %w", ErrConnection, uri, err)
+	}
+	conn, err := golibvirt.ConnectToURI(parsed)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrConnection, err)
+	}
+	return conn, nil
+}
+
+// lookupDomain finds the domain, checking that it is running.
+func lookupDomain(conn Conn, name string) (golibvirt.Domain, error) {
+	dom, err := conn.DomainLookupByName(name)
+	if golibvirt.IsNotFound(err) {
+		return dom, fmt.Errorf("%w: '%s'", ErrDomainNotFound, name)
+	} else if err != nil {
+		return dom, fmt.Errorf("%w: failed to look up domain '%s': %w", ErrConnection, name, err)
+	}
+	state, _, err := conn.DomainGetState(dom, 0)
+	if err != nil {
+		return dom, fmt.Errorf("%w: failed to get state of domain '%s': %w", ErrConnection, name, err)
+	}
+	// blocked domains are running, only waiting on a resource.
+	if s := golibvirt.DomainState(state); s != golibvirt.DomainRunning && s != golibvirt.DomainBlocked {
+		return dom, fmt.Errorf("%w: '%s' is %s", ErrDomainNotRunning, name, domainState(state))
+	}
+	return dom, nil
+}
+
+// domainState names the domain state, as virsh domstate does.
+func domainState(state int32) string {
+	switch golibvirt.DomainState(state) {
+	case golibvirt.DomainPaused:
+		return "paused"
+	case golibvirt.DomainShutdown:
+		return "shutting down"
+	case golibvirt.DomainShutoff:
+		return "shut off"
+	case golibvirt.DomainCrashed:
+		return "crashed"
+	case golibvirt.DomainPmsuspended:
+		return "suspended"
+	}
+	return "in no state"
+}
+
+// guest sends commands to the guest agent of a domain.
+type guest struct {
+	conn    Conn
+	dom     golibvirt.Domain
+	timeout int32
+}
+
+func newGuest(conn Conn, dom golibvirt.Domain, timeout time.Duration) *guest {
+	return &guest{
+		conn:    conn,
+		dom:     dom,
+		timeout: int32(math.Ceil(timeout.Seconds())),
+	}
+}
+
+// agentRequest is a command sent to the guest agent.
+type agentRequest struct {
+	Execute   string `json:"execute"`
+	Arguments any    `json:"arguments,omitempty"`
+}
+
+// agentReply is the guest agent's reply to a command.
+type agentReply struct {
+	Return json.RawMessage `json:"return"`
+	Error  *struct {
+		Class string `json:"class"`
+		Desc  string `json:"desc"`
+	} `json:"error"`
+}
+
+// command sends the command to the guest agent, decoding its return value into
+// the result (if not nil).
+func (g *guest) command(execute string, arguments, result any) error {
+	request, err := json.Marshal(agentRequest{Execute: execute, Arguments: arguments})
+	if err != nil {
+		return fmt.Errorf("failed to encode guest agent command: %w", err)
+	}
+	out, err := g.conn.QEMUDomainAgentCommand(g.dom, string(request), g.timeout, 0)
+	if err != nil {
+		return agentError(execute, err)
+	}
+	if len(out) == 0 {
+		return fmt.Errorf("%w: no reply to '%s'", ErrAgent, execute)
+	}
+	var reply agentReply
+	if err := json.Unmarshal([]byte(out[0]), &reply); err != nil {
+		return fmt.Errorf("%w: invalid reply to '%s': %w", ErrAgent, execute, err)
+	}
+	if reply.Error != nil {
+		return agentError(execute, fmt.Errorf("%s: %s", reply.Error.Class, reply.Error.Desc))
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(reply.Return, result); err != nil {
+		return fmt.Errorf("%w: invalid reply to '%s': %w", ErrAgent, execute, err)
+	}
+	return nil
+}
+
+// agentError classifies an error sending a command to the guest agent, which
+// libvirt reports with the agent's own error in its message.
+func agentError(execute string, err error) error {
+	var libvirtErr golibvirt.Error
+	if errors.As(err, &libvirtErr) {
+		switch golibvirt.ErrorNumber(libvirtErr.Code) {
+		case golibvirt.ErrAgentUnresponsive, golibvirt.ErrArgumentUnsupported:
+			return fmt.Errorf("%w: %w", ErrAgentUnavailable, err)
+		}
+	}
+	message := err.Error()
+	switch {
+	case strings.Contains(message, "CommandNotFound"),
+		strings.Contains(message, "has not been found"),
+		strings.Contains(message, "has been disabled"):
+		return fmt.Errorf("%w: '%s': %w", ErrExecUnsupported, execute, err)
+	case strings.Contains(message, "agent is not connected"),
+		strings.Contains(message, "agent is not configured"),
+		strings.Contains(message, "agent not available"):
+		return fmt.Errorf("%w: %w", ErrAgentUnavailable, err)
+	}
+	return fmt.Errorf("%w: '%s': %w", ErrAgent, execute, err)
+}
@@ -0,0 +1,50 @@
+package libvirt
+
+import (
+	"errors"
+
+	"github.com/neaas/nescript"
+)
+
+var (
+	// ErrConnection is returned (wrapped) when libvirt could not be connected
+	// to, as opposed to a failure once connected.
+	ErrConnection = nescript.NewError(nescript.ErrConnection, "failed to connect to libvirt")
+
+	// ErrDomainNotFound is returned (wrapped) when no domain of the name the
+	// script should be executed in is defined.
+	ErrDomainNotFound = errors.New("libvirt domain not found")
+
+	// ErrDomainNotRunning is returned (wrapped) when the domain the script
+	// should be executed in is defined, however is not running (such as when
+	// it is shut off or paused).
+	ErrDomainNotRunning = errors.New("libvirt domain is not running")
+
+	// ErrAgentUnavailable is returned (wrapped) when the domain has no guest
+	// agent channel configured, or the qemu-guest-agent is not running in the
+	// guest (such as early in boot, before it has started).
+	ErrAgentUnavailable = errors.New("qemu guest agent is not available")
+
+	// ErrExecUnsupported is returned (wrapped) when the guest agent does not
+	// support executing commands, or the commands used (guest-exec and the
+	// guest-file ones) have been disabled in the agent's configuration.
+	ErrExecUnsupported = errors.New("qemu guest agent does not support exec")
+
+	// ErrAgent is returned (wrapped) when the guest agent returns an error
+	// executing the script or collecting its output, such as when the command
+	// does not exist in the guest.
+	ErrAgent = errors.New("qemu guest agent command failed")
+
+	// ErrTimeout is returned (wrapped) when the script did not complete within
+	// the timeout given by WithTimeout. The script is killed.
+	ErrTimeout = nescript.NewError(nescript.ErrTimeout, "guest exec timed out")
+
+	// ErrSignalUnsupported is returned when a signal other than SIGKILL (or
+	// SIGTERM) is sent to the script in a windows guest, or a signal that is
+	// not a syscall signal to one in a linux guest.
+	ErrSignalUnsupported = errors.New("signal not supported by the guest")
+
+	// ErrStdinUnsupported is returned when writing to a guest exec process, as
+	// the guest agent only takes the script's stdin as it is started.
+	ErrStdinUnsupported = errors.New("stdin not supported by guest exec")
+)
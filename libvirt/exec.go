@@ -0,0 +1,215 @@
+package libvirt
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/internal/shellquote"
+)
+
+const (
+	// readChunk is the most bytes read from a guest file per command, which
+	// (base64 encoded) keeps the reply well within libvirt's message limits.
+	readChunk = 1 << 20
+
+	// reapTimeout bounds waiting for the helper commands (killing the script
+	// and removing its output files) to exit.
+	reapTimeout = 5 * time.Second
+)
+
+// execArgs are the arguments of guest-exec.
+type execArgs struct {
+	Path          string   `json:"path"`
+	Arg           []string `json:"arg,omitempty"`
+	Env           []string `json:"env,omitempty"`
+	CaptureOutput bool     `json:"capture-output"`
+}
+
+// execStatus is the return value of guest-exec-status. The captured output is
+// base64 encoded by the agent, which is decoded into the byte slices.
+type execStatus struct {
+	Exited       bool   `json:"exited"`
+	ExitCode     *int   `json:"exitcode"`
+	Signal       *int   `json:"signal"`
+	OutData      []byte `json:"out-data"`
+	ErrData      []byte `json:"err-data"`
+	OutTruncated bool   `json:"out-truncated"`
+	ErrTruncated bool   `json:"err-truncated"`
+}
+
+// osInfo is the return value of guest-get-osinfo.
+type osInfo struct {
+	ID string `json:"id"`
+}
+
+// windows reports whether the guest runs windows. Agents too old to report
+// their OS are assumed to be linux.
+func (g *guest) windows() (bool, error) {
+	var info osInfo
+	if err := g.command("guest-get-osinfo", nil, &info); errors.Is(err, ErrExecUnsupported) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return info.ID == "mswindows", nil
+}
+
+// exec starts the command in the guest, returning its PID.
+func (g *guest) exec(args execArgs) (int, error) {
+	var started struct {
+		PID int `json:"pid"`
+	}
+	if err := g.command("guest-exec", args, &started); err != nil {
+		return 0, err
+	}
+	return started.PID, nil
+}
+
+// status gets the status of the command started with the PID, which includes
+// its captured output once it has exited.
+func (g *guest) status(pid int) (*execStatus, error) {
+	var status execStatus
+	if err := g.command("guest-exec-status", map[string]int{"pid": pid}, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// run executes a helper command in the guest, waiting for it to exit so that
+// the agent releases its status.
+func (g *guest) run(path string, args ...string) error {
+	pid, err := g.exec(execArgs{Path: path, Arg: args})
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(reapTimeout)
+	for time.Now().Before(deadline) {
+		status, err := g.status(pid)
+		if err != nil {
+			return err
+		}
+		if status.Exited {
+			return nil
+		}
+		time.Sleep(defaultPollInterval)
+	}
+	return fmt.Errorf("%w: '%s' did not exit", ErrAgent, path)
+}
+
+// readFile reads up to max bytes of the file in the guest, reporting whether
+// there was more.
+func (g *guest) readFile(path string, max int) ([]byte, bool, error) {
+	var handle int64
+	if err := g.command("guest-file-open", map[string]string{"path": path, "mode": "r"}, &handle); err != nil {
+		return nil, false, err
+	}
+	defer g.command("guest-file-close", map[string]int64{"handle": handle}, nil)
+	var data []byte
+	for len(data) <= max {
+		var read struct {
+			Count int    `json:"count"`
+			Buf   []byte `json:"buf-b64"`
+			EOF   bool   `json:"eof"`
+		}
+		count := min(readChunk, max+1-len(data))
+		if err := g.command("guest-file-read", map[string]int64{"handle": handle, "count": int64(count)}, &read); err != nil {
+			return nil, false, err
+		}
+		data = append(data, read.Buf...)
+		if read.EOF || read.Count == 0 {
+			break
+		}
+	}
+	if len(data) > max {
+		return data[:max], true, nil
+	}
+	return data, false, nil
+}
+
+// outputFiles are the files in a linux guest the script's stdout and stderr
+// are redirected to, rather than being captured by the agent, which would
+// buffer all of the output in the guest's memory.
+type outputFiles struct {
+	base string
+}
+
+func newOutputFiles() (*outputFiles, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("failed to generate output file name: %w", err)
+	}
+	return &outputFiles{base: "/tmp/nescript-" + hex.EncodeToString(id)}, nil
+}
+
+func (f *outputFiles) stdout() string {
+	return f.base + ".out"
+}
+
+func (f *outputFiles) stderr() string {
+	return f.base + ".err"
+}
+
+// linuxExec builds the guest-exec of the cmd in a linux guest. The command is
+// run by sh, which exports the cmd's env (the agent's env would replace the
+// guest's own, including PATH), redirects stdout and stderr to the output
+// files, then execs the command, so that the PID is that of the script.
+func linuxExec(c nescript.Cmd, shell nescript.Subcommand, files *outputFiles) execArgs {
+	var script strings.Builder
+	script.WriteString("umask 077\n")
+	for _, e := range c.Env() {
+		if key, value, ok := strings.Cut(e, "="); ok {
+			fmt.Fprintf(&script, "export %s=%s\n", key, shellquote.POSIX(value))
+		}
+	}
+	script.WriteString(`exec "$@" >"$0.out" 2>"$0.err"`)
+	return execArgs{
+		Path: "/bin/sh",
+		Arg:  append([]string{"-c", script.String(), files.base}, command(c, shell)...),
+	}
+}
+
+// windowsExec builds the guest-exec of the cmd in a windows guest, where the
+// output is captured by the agent.
+func windowsExec(c nescript.Cmd, shell nescript.Subcommand) execArgs {
+	args := command(c, shell)
+	return execArgs{
+		Path:          args[0],
+		Arg:           args[1:],
+		Env:           c.Env(),
+		CaptureOutput: true,
+	}
+}
+
+// command converts the cmd into the command run in the guest. If a shell is
+// set and the cmd was created from a script, the script is passed to the shell,
+// otherwise the cmd is run as it is.
+func command(c nescript.Cmd, shell nescript.Subcommand) []string {
+	_, script, trailing, ok := c.Script()
+	if !ok || shell == nil {
+		return c.Raw()
+	}
+	return append(append(append([]string{}, shell...), script), trailing...)
+}
+
+// killArgs builds the helper command sending the signal to the PID in the
+// guest.
+func killArgs(windows bool, pid, signal int) (string, []string) {
+	if windows {
+		return "taskkill", []string{"/F", "/T", "/PID", strconv.Itoa(pid)}
+	}
+	return "kill", []string{"-" + strconv.Itoa(signal), strconv.Itoa(pid)}
+}
+
+// normalizeWindowsOutput decodes UTF-16 output (such as that of a program
+// writing Unicode to a redirected stream) by its byte order mark, and converts
+// windows (CRLF) line endings into newlines.
+func normalizeWindowsOutput(output string) string {
+	output, _ = nescript.DecodeOutput(output, nescript.EncodingAuto)
+	return strings.ReplaceAll(output, "\r\n", "\n")
+}
@@ -0,0 +1,92 @@
+package libvirt
+
+import (
+	"context"
+	"time"
+
+	golibvirt "github.com/digitalocean/go-libvirt"
+	"github.com/neaas/nescript"
+)
+
+// Executor creates an ExecFunc that runs cmds in the guest of the libvirt
+// domain through its qemu-guest-agent, with guest-exec, so needs no network
+// access to the guest. A connection may be passed, or if nil, one is made to
+// the URI given by WithURI for each execution. Before each execution the
+// domain is checked to be running, and the guest's OS determined. The script's
+// status is then polled until it exits.
+func Executor(conn Conn, domain string, opts ...Option) nescript.ExecFunc {
+	o := newOptions(opts)
+	return func(c nescript.Cmd) (nescript.Process, error) {
+		conn := conn
+		var owned *golibvirt.Libvirt
+		if isNilConn(conn) {
+			var err error
+			if owned, err = Connect(o.uri); err != nil {
+				return nil, err
+			}
+			conn = owned
+		}
+		process, err := o.start(c, conn, domain)
+		if err != nil {
+			if owned != nil {
+				owned.Disconnect()
+			}
+			return nil, err
+		}
+		process.owned = owned
+		return process, nil
+	}
+}
+
+// start starts the cmd in the domain's guest, and begins polling its status
+// within the timeout.
+func (o *options) start(c nescript.Cmd, conn Conn, domain string) (*GuestProcess, error) {
+	dom, err := lookupDomain(conn, domain)
+	if err != nil {
+		return nil, err
+	}
+	guest := newGuest(conn, dom, o.agentTimeout)
+	windows, err := guest.windows()
+	if err != nil {
+		return nil, err
+	}
+	var (
+		args  execArgs
+		files *outputFiles
+	)
+	if windows {
+		args = windowsExec(c, o.shell)
+	} else {
+		if files, err = newOutputFiles(); err != nil {
+			return nil, err
+		}
+		args = linuxExec(c, o.shell, files)
+	}
+	pid, err := guest.exec(args)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(c.Context())
+	if o.timeout > 0 {
+		ctx, cancel = context.WithTimeout(c.Context(), o.timeout)
+	}
+	process := &GuestProcess{
+		guest:           guest,
+		domain:          domain,
+		pid:             pid,
+		windows:         windows,
+		files:           files,
+		maxOutput:       o.maxOutput,
+		pollInterval:    o.pollInterval,
+		maxPollInterval: o.maxPollInterval,
+		parent:          c.Context(),
+		ctx:             ctx,
+		cancel:          cancel,
+		timeout:         o.timeout > 0,
+		done:            make(chan struct{}),
+		started:         time.Now(),
+		tee:             nescript.NewTee(c, nil, nil),
+	}
+	go process.watch()
+	return process, nil
+}
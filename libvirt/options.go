@@ -0,0 +1,116 @@
+package libvirt
+
+import (
+	"os"
+	"time"
+
+	"github.com/neaas/nescript"
+)
+
+const (
+	// defaultURI is the libvirt URI connected to if none is given, and
+	// LIBVIRT_DEFAULT_URI is not set.
+	defaultURI = "qemu:///system"
+
+	// defaultMaxOutput is the most of each of stdout and stderr read back from
+	// the guest.
+	defaultMaxOutput = 4 << 20
+
+	// defaultPollInterval is how long after starting the script its status is
+	// first polled, which then backs off up to the max poll interval.
+	defaultPollInterval = 100 * time.Millisecond
+
+	// defaultMaxPollInterval is the longest the script goes without its status
+	// being polled.
+	defaultMaxPollInterval = 2 * time.Second
+
+	// defaultAgentTimeout is how long libvirt waits for the guest agent to
+	// reply to each command.
+	defaultAgentTimeout = 10 * time.Second
+)
+
+// Option configures the libvirt executor.
+type Option func(*options)
+
+type options struct {
+	uri             string
+	shell           nescript.Subcommand
+	maxOutput       int
+	pollInterval    time.Duration
+	maxPollInterval time.Duration
+	agentTimeout    time.Duration
+	timeout         time.Duration
+}
+
+// newOptions creates the options, defaulting the URI to LIBVIRT_DEFAULT_URI as
+// virsh does.
+func newOptions(opts []Option) *options {
+	o := &options{
+		uri:             os.Getenv("LIBVIRT_DEFAULT_URI"),
+		maxOutput:       defaultMaxOutput,
+		pollInterval:    defaultPollInterval,
+		maxPollInterval: defaultMaxPollInterval,
+		agentTimeout:    defaultAgentTimeout,
+	}
+	if o.uri == "" {
+		o.uri = defaultURI
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithURI sets the libvirt URI connected to when the executor is not given a
+// connection, such as qemu+ssh://root@hv01/system or qemu+tls://hv01/system.
+// LIBVIRT_DEFAULT_URI is used unless given, otherwise qemu:///system.
+func WithURI(uri string) Option {
+	return func(o *options) {
+		o.uri = uri
+	}
+}
+
+// WithShell sets the shell scripts are invoked with in the guest, such as
+// nescript.SCBash, rather than the cmd's subcommand. Cmds not created from a
+// script are run as they are.
+func WithShell(shell nescript.Subcommand) Option {
+	return func(o *options) {
+		o.shell = shell
+	}
+}
+
+// WithMaxOutput sets the most bytes of each of stdout and stderr read back from
+// the guest, beyond which the output is truncated (see MetadataTruncated). The
+// default is 4MiB.
+func WithMaxOutput(bytes int) Option {
+	return func(o *options) {
+		o.maxOutput = bytes
+	}
+}
+
+// WithPollInterval sets how long after starting the script its status is first
+// polled, and the longest between polls. The interval grows between each poll
+// up to the max.
+func WithPollInterval(interval, max time.Duration) Option {
+	return func(o *options) {
+		o.pollInterval = interval
+		o.maxPollInterval = max
+	}
+}
+
+// WithAgentTimeout sets how long libvirt waits for the guest agent to reply to
+// each command sent to it, after which the execution fails with
+// ErrAgentUnavailable. The default is 10 seconds.
+func WithAgentTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.agentTimeout = timeout
+	}
+}
+
+// WithTimeout limits how long the script may run for. If the script has not
+// completed within the timeout, it is killed and Result returns ErrTimeout.
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.timeout = timeout
+	}
+}
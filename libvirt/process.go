@@ -0,0 +1,276 @@
+package libvirt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	golibvirt "github.com/digitalocean/go-libvirt"
+	"github.com/neaas/nescript"
+)
+
+const (
+	// MetadataDomain is the result metadata key holding the name of the domain
+	// the script was executed in.
+	MetadataDomain = "libvirt.domain"
+
+	// MetadataPID is the result metadata key holding the PID of the script in
+	// the guest.
+	MetadataPID = "libvirt.pid"
+
+	// MetadataTruncated is the result metadata key holding a Truncated,
+	// recording which output streams were truncated at the max output (see
+	// WithMaxOutput), set only when either was.
+	MetadataTruncated = "libvirt.truncated"
+)
+
+// Truncated records which of the script's output streams were truncated.
+type Truncated struct {
+	StdOut bool
+	StdErr bool
+}
+
+// GuestProcess represents a single instance of the script running or completed
+// in a guest, started through its qemu-guest-agent.
+type GuestProcess struct {
+	guest           *guest
+	owned           *golibvirt.Libvirt
+	domain          string
+	pid             int
+	windows         bool
+	files           *outputFiles
+	maxOutput       int
+	pollInterval    time.Duration
+	maxPollInterval time.Duration
+	parent          context.Context
+	ctx             context.Context
+	cancel          context.CancelFunc
+	timeout         bool
+	done            chan struct{}
+	closeOnce       sync.Once
+	started         time.Time
+	tee             *nescript.Tee
+
+	// set by watch, before done is closed.
+	stdout    []byte
+	stderr    []byte
+	status    *execStatus
+	truncated Truncated
+	ended     time.Time
+	err       error
+}
+
+// PID returns the PID of the script in the guest.
+func (p *GuestProcess) PID() int {
+	return p.pid
+}
+
+// watch polls the script's status until it exits, backing off between polls,
+// then collects its output. If the context is done first, the script is
+// killed.
+func (p *GuestProcess) watch() {
+	defer close(p.done)
+	defer p.tee.Finish(nil)
+	defer p.cancel()
+	defer p.removeFiles()
+	interval := p.pollInterval
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-p.ctx.Done():
+			p.signal(int(syscall.SIGKILL))
+			p.reap()
+			p.err = p.ctxErr()
+			return
+		case <-timer.C:
+		}
+		status, err := p.guest.status(p.pid)
+		if err != nil {
+			p.err = err
+			return
+		}
+		if status.Exited {
+			// the exit is only found when polled, so is as precise as the interval.
+			p.ended = time.Now()
+			p.status = status
+			p.err = p.collect()
+			p.tee.WriteOutput(string(p.stdout), string(p.stderr))
+			return
+		}
+		interval += interval / 2
+		if interval > p.maxPollInterval {
+			interval = p.maxPollInterval
+		}
+		timer.Reset(interval)
+	}
+}
+
+// collect reads the script's output, from the output files in linux guests or
+// as captured by the agent in windows guests, up to the max output.
+func (p *GuestProcess) collect() error {
+	if p.files == nil {
+		p.stdout, p.truncated.StdOut = truncate(p.status.OutData, p.maxOutput, p.status.OutTruncated)
+		p.stderr, p.truncated.StdErr = truncate(p.status.ErrData, p.maxOutput, p.status.ErrTruncated)
+		return nil
+	}
+	var err error
+	if p.stdout, p.truncated.StdOut, err = p.guest.readFile(p.files.stdout(), p.maxOutput); err != nil {
+		return fmt.Errorf("failed to read stdout: %w", err)
+	}
+	if p.stderr, p.truncated.StdErr, err = p.guest.readFile(p.files.stderr(), p.maxOutput); err != nil {
+		return fmt.Errorf("failed to read stderr: %w", err)
+	}
+	return nil
+}
+
+// truncate limits the output to the max, reporting whether it (or the agent)
+// truncated it.
+func truncate(output []byte, max int, truncated bool) ([]byte, bool) {
+	if len(output) > max {
+		return output[:max], true
+	}
+	return output, truncated
+}
+
+// reap waits for the killed script to exit, so that the agent releases its
+// status.
+func (p *GuestProcess) reap() {
+	deadline := time.Now().Add(reapTimeout)
+	for time.Now().Before(deadline) {
+		if status, err := p.guest.status(p.pid); err != nil || status.Exited {
+			return
+		}
+		time.Sleep(defaultPollInterval)
+	}
+}
+
+// removeFiles removes the output files from the guest.
+func (p *GuestProcess) removeFiles() {
+	if p.files != nil {
+		p.guest.run("rm", "-f", p.files.stdout(), p.files.stderr())
+	}
+}
+
+// ctxErr is the error the execution ended with once its context is done.
+func (p *GuestProcess) ctxErr() error {
+	if p.timeout && errors.Is(p.ctx.Err(), context.DeadlineExceeded) && p.parent.Err() == nil {
+		return fmt.Errorf("%w: script killed", ErrTimeout)
+	}
+	return fmt.Errorf("%w: script killed: %w", ErrAgent, nescript.ContextError(p.ctx.Err()))
+}
+
+// signal sends the signal to the script with a helper command in the guest.
+func (p *GuestProcess) signal(signal int) error {
+	path, args := killArgs(p.windows, p.pid, signal)
+	return p.guest.run(path, args...)
+}
+
+// Kill kills the script, doing nothing once it has exited.
+func (p *GuestProcess) Kill() error {
+	select {
+	case <-p.done:
+		return nil
+	default:
+	}
+	if err := p.signal(int(syscall.SIGKILL)); err != nil {
+		return fmt.Errorf("failed to kill guest process: %w", err)
+	}
+	return nil
+}
+
+// Signal sends the signal to the script with kill in linux guests. In windows
+// guests, SIGTERM and SIGKILL end the script with taskkill, and other signals
+// return ErrSignalUnsupported.
+func (p *GuestProcess) Signal(s os.Signal) error {
+	sig, ok := s.(syscall.Signal)
+	if p.windows {
+		ok = s == os.Kill || s == syscall.SIGKILL || s == syscall.SIGTERM
+	}
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrSignalUnsupported, s)
+	}
+	if err := p.signal(int(sig)); err != nil {
+		return fmt.Errorf("failed to send signal to guest process: %w", err)
+	}
+	return nil
+}
+
+func (p *GuestProcess) Write(input string) error {
+	return ErrStdinUnsupported
+}
+
+func (p *GuestProcess) Stdin() io.WriteCloser {
+	return nescript.StdinWriter(p)
+}
+
+func (p *GuestProcess) Stdout() io.Reader {
+	return p.tee.StdoutStream()
+}
+
+func (p *GuestProcess) Stderr() io.Reader {
+	return p.tee.StderrStream()
+}
+
+// ID returns the PID of the script in the guest (see PID).
+func (p *GuestProcess) ID() string {
+	return strconv.Itoa(p.pid)
+}
+
+func (p *GuestProcess) String() string {
+	return fmt.Sprintf("libvirt domain %s pid %d", p.domain, p.pid)
+}
+
+func (p *GuestProcess) Wait(ctx context.Context) (*nescript.Result, error) {
+	return nescript.Wait(ctx, p)
+}
+
+func (p *GuestProcess) Result() (*nescript.Result, error) {
+	defer p.Close()
+	<-p.done
+	if p.err != nil {
+		return nil, p.err
+	}
+	result := nescript.Result{
+		StdOut: string(p.stdout),
+		StdErr: string(p.stderr),
+	}
+	if p.windows {
+		result.StdOut = normalizeWindowsOutput(result.StdOut)
+		result.StdErr = normalizeWindowsOutput(result.StdErr)
+	}
+	if p.status.ExitCode != nil {
+		result.ExitCode = *p.status.ExitCode
+	}
+	if p.status.Signal != nil {
+		result.SetSignal(*p.status.Signal)
+	}
+	result.SetTimes(p.started, p.ended)
+	result.SetMetadata(nescript.MetadataExecutor, "libvirt")
+	result.SetMetadata(MetadataDomain, p.domain)
+	result.SetMetadata(MetadataPID, p.pid)
+	result.StdOutTruncated, result.StdErrTruncated = p.truncated.StdOut, p.truncated.StdErr
+	if p.truncated.StdOut || p.truncated.StdErr {
+		result.SetMetadata(MetadataTruncated, p.truncated)
+	}
+	p.tee.Finish(&result)
+	return &result, nil
+}
+
+// Close kills the script if it is still running, removes its output files and
+// disconnects from libvirt, if the executor connected.
+func (p *GuestProcess) Close() {
+	p.cancel()
+	<-p.done
+	p.closeOnce.Do(func() {
+		if p.owned != nil {
+			p.owned.Disconnect()
+		}
+	})
+}
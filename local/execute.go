@@ -2,6 +2,7 @@ package local
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/neaas/nescript"
 )
@@ -21,16 +22,24 @@ func Executor(workdir string) nescript.ExecFunc {
 		}
 		process.cmd.Env = c.Env()
 		process.cmd.Dir = workdir
-		process.cmd.Stdout = &process.stdoutBytes
-		process.cmd.Stderr = &process.stderrBytes
+		setProcessGroup(process.cmd)
+		process.tee = nescript.NewTee(c, &process.stdoutBytes, &process.stderrBytes)
+		process.cmd.Stdout = process.tee.Stdout()
+		process.cmd.Stderr = process.tee.Stderr()
 		if stdin, err := process.cmd.StdinPipe(); err != nil {
+			process.tee.Finish(nil)
 			return nil, fmt.Errorf("failed to create stdin pipe: %w", err)
 		} else {
 			process.stdin = stdin
 		}
 		if err := process.cmd.Start(); err != nil || process.cmd.Process == nil {
+			process.tee.Finish(nil)
 			return nil, fmt.Errorf("process failed to start: %w", err)
 		}
+		process.pid = process.cmd.Process.Pid
+		process.started = time.Now()
+		process.completion = nescript.NewCompletion(process.collect)
+		process.completion.Start()
 		return &process, nil
 	}
 }
@@ -0,0 +1,21 @@
+//go:build !windows
+
+package local
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup starts the command in a process group of its own, so that it
+// is killed along with any processes the script starts (see killProcess).
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killProcess kills the process group of the command, so that processes the
+// script started (such as a shell forking `sleep`) do not outlive it, keeping
+// its output open.
+func killProcess(cmd *exec.Cmd, pid int) error {
+	return syscall.Kill(-pid, syscall.SIGKILL)
+}
@@ -0,0 +1,13 @@
+package local
+
+import (
+	"os/exec"
+)
+
+// setProcessGroup does nothing on windows, where the process is killed alone.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcess kills the process of the command.
+func killProcess(cmd *exec.Cmd, pid int) error {
+	return cmd.Process.Kill()
+}
@@ -2,10 +2,14 @@ package local
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
+	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/neaas/nescript"
 )
@@ -14,13 +18,20 @@ import (
 // the local device.
 type LocalProcess struct {
 	cmd         *exec.Cmd
-	stdin       io.Writer
+	pid         int
+	stdin       io.WriteCloser
 	stdoutBytes bytes.Buffer
 	stderrBytes bytes.Buffer
+	started     time.Time
+	tee         *nescript.Tee
+	completion  *nescript.Completion
 }
 
 func (p *LocalProcess) Kill() error {
-	if err := p.cmd.Process.Kill(); err != nil {
+	if p.exited() {
+		return nil
+	}
+	if err := killProcess(p.cmd, p.pid); err != nil {
 		return fmt.Errorf("failed to kill process: %w", err)
 	}
 	return nil
@@ -34,7 +45,7 @@ func (p *LocalProcess) Signal(s os.Signal) error {
 }
 
 func (p *LocalProcess) Exited() bool {
-	if process, err := os.FindProcess(p.cmd.Process.Pid); err != nil || process == nil {
+	if process, err := os.FindProcess(p.pid); err != nil || process == nil {
 		return true
 	}
 	return false
@@ -50,17 +61,82 @@ func (p *LocalProcess) Write(input string) error {
 	return nil
 }
 
+// exited reports whether the result of the script has been collected.
+func (p *LocalProcess) exited() bool {
+	select {
+	case <-p.completion.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *LocalProcess) Stdin() io.WriteCloser {
+	return nescript.StdinWriter(p)
+}
+
+// CloseStdin closes the script's stdin.
+func (p *LocalProcess) CloseStdin() error {
+	if err := p.stdin.Close(); err != nil {
+		return fmt.Errorf("failed to close stdin: %w", err)
+	}
+	return nil
+}
+
+func (p *LocalProcess) Stdout() io.Reader {
+	return p.tee.StdoutStream()
+}
+
+func (p *LocalProcess) Stderr() io.Reader {
+	return p.tee.StderrStream()
+}
+
+// ID returns the PID of the script.
+func (p *LocalProcess) ID() string {
+	return strconv.Itoa(p.pid)
+}
+
+func (p *LocalProcess) String() string {
+	return fmt.Sprintf("local pid %d", p.pid)
+}
+
 func (p *LocalProcess) Result() (*nescript.Result, error) {
+	return p.completion.Result()
+}
+
+func (p *LocalProcess) Wait(ctx context.Context) (*nescript.Result, error) {
+	return nescript.Wait(ctx, p)
+}
+
+func (p *LocalProcess) Poll() (*nescript.Result, bool) {
+	return p.completion.Poll()
+}
+
+func (p *LocalProcess) WaitContext(ctx context.Context) (*nescript.Result, error) {
+	return p.completion.WaitContext(ctx)
+}
+
+// collect waits for the script to exit, collecting its result (see
+// nescript.Completion).
+func (p *LocalProcess) collect() (*nescript.Result, error) {
 	if err := p.cmd.Wait(); err != nil {
 		if _, ok := err.(*exec.ExitError); !ok {
+			p.tee.Finish(nil)
 			return nil, fmt.Errorf("failed to wait for process: %w", err)
 		}
 	}
+	ended := time.Now()
 	result := nescript.Result{
 		StdOut: string(p.stdoutBytes.String()),
 		StdErr: string(p.stderrBytes.String()),
 	}
 	result.ExitCode = p.cmd.ProcessState.ExitCode()
+	if status, ok := p.cmd.ProcessState.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		result.SetSignal(int(status.Signal()))
+	}
+	result.SetTimes(p.started, ended)
+	result.SetMetadata(nescript.MetadataExecutor, "local")
+	p.tee.Finish(&result)
 	if err := p.cmd.Process.Release(); err != nil {
 		return nil, fmt.Errorf("failed to release to process resources: %w", err)
 	}
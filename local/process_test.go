@@ -0,0 +1,14 @@
+package local_test
+
+import (
+	"testing"
+
+	"github.com/neaas/nescript/local"
+	"github.com/neaas/nescript/processtest"
+)
+
+func TestExecutor(t *testing.T) {
+	if err := processtest.TestExecutor(local.Executor("")); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,294 @@
+package nescript
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogEvent is a class of event logged by an executor wrapped with Logging, or
+// a script with a logger (see Script.WithLogger). Each class is logged at its
+// own level (see WithLogLevel).
+type LogEvent string
+
+const (
+	// LogEventCompiled is logged when a script is compiled, at debug level by
+	// default.
+	LogEventCompiled LogEvent = "compiled"
+
+	// LogEventStarted is logged when a script has been started by the
+	// executor, at info level by default.
+	LogEventStarted LogEvent = "started"
+
+	// LogEventOutput is logged when a script first writes to each of stdout
+	// and stderr, at debug level by default.
+	LogEventOutput LogEvent = "output"
+
+	// LogEventCompleted is logged when a script exits 0, at info level by
+	// default.
+	LogEventCompleted LogEvent = "completed"
+
+	// LogEventFailed is logged when a script fails to compile, start, or exits
+	// unsuccessfully, at error level by default.
+	LogEventFailed LogEvent = "failed"
+)
+
+// The attribute keys of the logged events, which are kept stable.
+const (
+	LogKeyEvent       = "event"
+	LogKeyOrigin      = "origin"
+	LogKeyHash        = "hash"
+	LogKeySize        = "size"
+	LogKeyTarget      = "target"
+	LogKeyInterpreter = "interpreter"
+	LogKeyStream      = "stream"
+	LogKeyExitCode    = "exit_code"
+	LogKeySignal      = "signal"
+	LogKeyDuration    = "duration"
+	LogKeyError       = "error"
+	LogKeyStdOut      = "stdout"
+	LogKeyStdErr      = "stderr"
+)
+
+// redacted replaces the redacted secrets in logged values.
+const redacted = "[REDACTED]"
+
+var defaultLogLevels = map[LogEvent]slog.Level{
+	LogEventCompiled:  slog.LevelDebug,
+	LogEventStarted:   slog.LevelInfo,
+	LogEventOutput:    slog.LevelDebug,
+	LogEventCompleted: slog.LevelInfo,
+	LogEventFailed:    slog.LevelError,
+}
+
+// LogOption configures the events logged by Logging and Script.WithLogger.
+type LogOption func(*logOptions)
+
+type logOptions struct {
+	levels map[LogEvent]slog.Level
+	target string
+	output int
+	redact []string
+}
+
+func newLogOptions(opts []LogOption) *logOptions {
+	o := &logOptions{levels: maps.Clone(defaultLogLevels)}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithLogLevel sets the level the class of event is logged at.
+func WithLogLevel(event LogEvent, level slog.Level) LogOption {
+	return func(o *logOptions) {
+		o.levels[event] = level
+	}
+}
+
+// WithLogTarget names the target the script is executed on, such as a host or
+// container, in the events of the execution.
+func WithLogTarget(target string) LogOption {
+	return func(o *logOptions) {
+		o.target = target
+	}
+}
+
+// WithLogOutput includes up to the first and last n bytes of stdout and stderr
+// in the event of a script exiting unsuccessfully.
+func WithLogOutput(n int) LogOption {
+	return func(o *logOptions) {
+		o.output = n
+	}
+}
+
+// WithLogRedact replaces each of the secrets, such as passwords passed to the
+// script, with "[REDACTED]" wherever they would be logged. The env of the cmd
+// is never logged.
+func WithLogRedact(secrets ...string) LogOption {
+	return func(o *logOptions) {
+		for _, secret := range secrets {
+			if secret != "" {
+				o.redact = append(o.redact, secret)
+			}
+		}
+	}
+}
+
+// eventLogger logs the events of a script.
+type eventLogger struct {
+	logger *slog.Logger
+	*logOptions
+}
+
+func (l *eventLogger) enabled(ctx context.Context, event LogEvent) bool {
+	return l.logger.Enabled(ctx, l.levels[event])
+}
+
+func (l *eventLogger) log(ctx context.Context, event LogEvent, msg string, attrs ...slog.Attr) {
+	if !l.enabled(ctx, event) {
+		return
+	}
+	attrs = append([]slog.Attr{slog.String(LogKeyEvent, string(event))}, attrs...)
+	l.logger.LogAttrs(ctx, l.levels[event], msg, attrs...)
+}
+
+func (l *eventLogger) redacted(s string) string {
+	for _, secret := range l.redact {
+		s = strings.ReplaceAll(s, secret, redacted)
+	}
+	return s
+}
+
+func (l *eventLogger) errorAttr(err error) slog.Attr {
+	return slog.String(LogKeyError, l.redacted(err.Error()))
+}
+
+// excerpt returns the output, or its first and last n bytes if it is longer.
+func (l *eventLogger) excerpt(output string) string {
+	if len(output) > 2*l.output {
+		head := trimPartialEnd([]byte(output[:l.output]))
+		tail := trimPartialStart([]byte(output[len(output)-l.output:]))
+		output = string(head) + "\n[...]\n" + string(tail)
+	}
+	return l.redacted(output)
+}
+
+// scriptHash returns the hex encoded SHA-256 hash of the script.
+func scriptHash(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// Logging wraps the executor so that each execution's lifecycle is logged to
+// the logger as structured events (see LogEvent): the script starting (with
+// the target and interpreter), first writing output, and completing (with its
+// exit code and duration) or failing. If the logger is nil, the executor is
+// returned as it is, so that nothing is logged.
+func Logging(executor ExecFunc, logger *slog.Logger, opts ...LogOption) ExecFunc {
+	if logger == nil {
+		return executor
+	}
+	l := &eventLogger{logger: logger, logOptions: newLogOptions(opts)}
+	return func(c Cmd) (Process, error) {
+		ctx := c.Context()
+		attrs := l.cmdAttrs(c)
+		if l.enabled(ctx, LogEventOutput) {
+			stdout, stderr := c.OutputWriters()
+			c.stdout = l.outputWriter(ctx, "stdout", stdout, attrs)
+			c.stderr = l.outputWriter(ctx, "stderr", stderr, attrs)
+		}
+		process, err := executor(c)
+		if err != nil {
+			l.log(ctx, LogEventFailed, "script failed to start", append(attrs, l.errorAttr(err))...)
+			return nil, err
+		}
+		l.log(ctx, LogEventStarted, "script started", attrs...)
+		return WrapProcess(process, ProcessHooks{Result: func(result *Result, err error) (*Result, error) {
+			l.completed(ctx, result, err, attrs)
+			return result, err
+		}}), nil
+	}
+}
+
+// cmdAttrs returns the attributes of the events of the cmd's execution.
+func (l *eventLogger) cmdAttrs(c Cmd) []slog.Attr {
+	var attrs []slog.Attr
+	if l.target != "" {
+		attrs = append(attrs, slog.String(LogKeyTarget, l.target))
+	}
+	interpreter := c.command
+	if subcommand, _, _, ok := c.Script(); ok {
+		interpreter = strings.Join(subcommand, " ")
+	}
+	return slices.Clip(append(attrs, slog.String(LogKeyInterpreter, l.redacted(interpreter))))
+}
+
+// outputWriter returns a writer logging the first write of the stream, before
+// passing the output to w (if not nil).
+func (l *eventLogger) outputWriter(ctx context.Context, stream string, w io.Writer, attrs []slog.Attr) io.Writer {
+	return &firstWriteWriter{w: w, first: func() {
+		l.log(ctx, LogEventOutput, "script output started", append(attrs, slog.String(LogKeyStream, stream))...)
+	}}
+}
+
+// firstWriteWriter calls first on the first write, before writing to w.
+type firstWriteWriter struct {
+	w     io.Writer
+	once  sync.Once
+	first func()
+}
+
+func (w *firstWriteWriter) Write(p []byte) (int, error) {
+	w.once.Do(w.first)
+	if w.w == nil {
+		return len(p), nil
+	}
+	return w.w.Write(p)
+}
+
+// completed logs the result (or error) of an execution.
+func (l *eventLogger) completed(ctx context.Context, result *Result, err error, attrs []slog.Attr) {
+	switch {
+	case err != nil:
+		l.log(ctx, LogEventFailed, "script failed", append(attrs, l.errorAttr(err))...)
+	case result.Success():
+		l.log(ctx, LogEventCompleted, "script completed", append(attrs,
+			slog.Int(LogKeyExitCode, result.ExitCode),
+			slog.Duration(LogKeyDuration, result.Duration()),
+		)...)
+	default:
+		attrs = append(attrs,
+			slog.Int(LogKeyExitCode, result.ExitCode),
+			slog.Duration(LogKeyDuration, result.Duration()),
+		)
+		if result.Signaled {
+			attrs = append(attrs, slog.String(LogKeySignal, result.Signal))
+		}
+		if l.output > 0 {
+			attrs = append(attrs,
+				slog.String(LogKeyStdOut, l.excerpt(result.StdOut)),
+				slog.String(LogKeyStdErr, l.excerpt(result.StdErr)),
+			)
+		}
+		l.log(ctx, LogEventFailed, "script exited unsuccessfully", attrs...)
+	}
+}
+
+// WithLogger sets the logger the compilation of the script is logged to (see
+// LogEventCompiled), with the origin (the file or URL it was created from),
+// SHA-256 hash and size of the script. Nothing is logged without a logger.
+func (s Script) WithLogger(logger *slog.Logger, opts ...LogOption) Script {
+	s.logger = nil
+	if logger != nil {
+		s.logger = &eventLogger{logger: logger, logOptions: newLogOptions(opts)}
+	}
+	return s
+}
+
+// logCompiled logs the compilation of the script, if it has a logger.
+func (s Script) logCompiled(raw string, start time.Time, err error) {
+	if s.logger == nil {
+		return
+	}
+	ctx := context.Background()
+	attrs := []slog.Attr{slog.String(LogKeyHash, scriptHash(raw))}
+	if s.origin != "" {
+		attrs = append(attrs, slog.String(LogKeyOrigin, s.logger.redacted(s.origin)))
+	}
+	if err != nil {
+		s.logger.log(ctx, LogEventFailed, "script failed to compile", append(attrs, s.logger.errorAttr(err))...)
+		return
+	}
+	s.logger.log(ctx, LogEventCompiled, "script compiled", append(attrs,
+		slog.Int(LogKeySize, len(s.raw)),
+		slog.Duration(LogKeyDuration, time.Since(start)),
+	)...)
+}
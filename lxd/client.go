@@ -0,0 +1,216 @@
+package lxd
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/websocket"
+)
+
+// errNotFound is returned by the client for API responses with a 404 status,
+// for the caller to return the sentinel error for whatever was not found.
+var errNotFound = errors.New("not found")
+
+// client makes requests to the LXD REST API, over the local unix socket or a
+// remote https endpoint.
+type client struct {
+	base    *url.URL
+	socket  string
+	tls     *tls.Config
+	http    *http.Client
+	project string
+
+	trustToken string
+	trustMu    sync.Mutex
+	trusted    bool
+}
+
+// response is an LXD API response, whose metadata depends on the request.
+type response struct {
+	Type       string          `json:"type"`
+	StatusCode int             `json:"status_code"`
+	Error      string          `json:"error"`
+	ErrorCode  int             `json:"error_code"`
+	Metadata   json.RawMessage `json:"metadata"`
+}
+
+// newClient creates the client for the LXD server at the address, which is
+// either unix:///path/to/unix.socket or https://host:8443. If the address is
+// empty, the local server's socket is found (see localSocket).
+func (o *options) newClient(address string) (*client, error) {
+	c := &client{
+		project:    o.project,
+		trustToken: o.trustToken,
+	}
+	switch {
+	case address == "":
+		socket, err := localSocket()
+		if err != nil {
+			return nil, err
+		}
+		c.socket = socket
+	case strings.HasPrefix(address, "unix://"):
+		c.socket = strings.TrimPrefix(address, "unix://")
+	case strings.HasPrefix(address, "https://"):
+		base, err := url.Parse(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid lxd address '%s': %w", address, err)
+		}
+		if c.tls, err = o.tlsConfig(); err != nil {
+			return nil, err
+		}
+		c.base = base
+		c.http = &http.Client{Transport: &http.Transport{TLSClientConfig: c.tls}}
+		return c, nil
+	default:
+		return nil, fmt.Errorf("invalid lxd address '%s', expected unix:// or https://", address)
+	}
+	// clients of the unix socket are always trusted.
+	c.trusted = true
+	c.base = &url.URL{Scheme: "http", Host: "unix.socket"}
+	c.http = &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return c.dialSocket(ctx)
+		},
+	}}
+	return c, nil
+}
+
+// localSocket finds the socket of the local LXD server: that in $LXD_DIR if
+// set, otherwise that of the snap or of a native install.
+func localSocket() (string, error) {
+	if dir := os.Getenv("LXD_DIR"); dir != "" {
+		return filepath.Join(dir, "unix.socket"), nil
+	}
+	for _, candidate := range []string{"/var/snap/lxd/common/lxd/unix.socket", "/var/lib/lxd/unix.socket"} {
+		if info, err := os.Stat(candidate); err == nil && info.Mode()&os.ModeSocket != 0 {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("no local lxd socket found")
+}
+
+func (c *client) dialSocket(ctx context.Context) (net.Conn, error) {
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "unix", c.socket)
+}
+
+// url builds the URL of the API path, with the project set.
+func (c *client) url(scheme, path string, query url.Values) *url.URL {
+	if query == nil {
+		query = url.Values{}
+	}
+	if c.project != "" {
+		query.Set("project", c.project)
+	}
+	u := *c.base
+	u.Scheme = scheme
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	u.RawQuery = query.Encode()
+	return &u
+}
+
+// do makes the API request, with the body (if any) encoded as JSON.
+func (c *client) do(ctx context.Context, method, path string, query url.Values, body any) (*response, error) {
+	var reader io.Reader = http.NoBody
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode lxd request: %w", err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.url(c.base.Scheme, path, query).String(), reader)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrConnection, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	httpResp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrConnection, err)
+	}
+	defer httpResp.Body.Close()
+	resp := &response{}
+	if err := json.NewDecoder(httpResp.Body).Decode(resp); err != nil {
+		return nil, fmt.Errorf("failed to decode lxd response (status %d): %w", httpResp.StatusCode, err)
+	}
+	if resp.Type == "error" || httpResp.StatusCode >= 400 {
+		switch httpResp.StatusCode {
+		case http.StatusNotFound:
+			return nil, errNotFound
+		case http.StatusForbidden:
+			return nil, fmt.Errorf("%w: %s", ErrUntrusted, resp.Error)
+		}
+		return nil, fmt.Errorf("lxd returned %d: %s", httpResp.StatusCode, resp.Error)
+	}
+	return resp, nil
+}
+
+// websocket connects to the websocket at the API path.
+func (c *client) websocket(ctx context.Context, path string, query url.Values) (*websocket.Conn, error) {
+	scheme := "wss"
+	if c.socket != "" {
+		scheme = "ws"
+	}
+	config, err := websocket.NewConfig(c.url(scheme, path, query).String(), c.base.String())
+	if err != nil {
+		return nil, err
+	}
+	if c.socket == "" {
+		config.TlsConfig = c.tls
+		return config.DialContext(ctx)
+	}
+	conn, err := c.dialSocket(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ws, err := websocket.NewClient(config, conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ws, nil
+}
+
+// ensureTrusted ensures the remote server trusts the client certificate,
+// adding it to the server's trust store with the trust token if not.
+func (c *client) ensureTrusted(ctx context.Context) error {
+	c.trustMu.Lock()
+	defer c.trustMu.Unlock()
+	if c.trusted {
+		return nil
+	}
+	resp, err := c.do(ctx, http.MethodGet, "/1.0", nil, nil)
+	if err != nil {
+		return err
+	}
+	var server struct {
+		Auth string `json:"auth"`
+	}
+	if err := json.Unmarshal(resp.Metadata, &server); err != nil {
+		return fmt.Errorf("failed to decode lxd server info: %w", err)
+	}
+	if server.Auth != "trusted" {
+		if c.trustToken == "" {
+			return fmt.Errorf("%w: no trust token was given", ErrUntrusted)
+		}
+		request := map[string]any{"type": "client", "trust_token": c.trustToken}
+		if _, err := c.do(ctx, http.MethodPost, "/1.0/certificates", nil, request); err != nil {
+			return fmt.Errorf("%w: failed to add the client certificate with the trust token: %w", ErrUntrusted, err)
+		}
+	}
+	c.trusted = true
+	return nil
+}
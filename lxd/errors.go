@@ -0,0 +1,30 @@
+package lxd
+
+import (
+	"errors"
+
+	"github.com/neaas/nescript"
+)
+
+var (
+	// ErrConnection is returned (wrapped) when the LXD server could not be
+	// reached, as opposed to a failure once connected.
+	ErrConnection = nescript.NewError(nescript.ErrConnection, "failed to connect to lxd")
+
+	// ErrUntrusted is returned (wrapped) when the LXD server does not trust the
+	// client certificate, and it could not be trusted with a trust token (see
+	// WithTrustToken).
+	ErrUntrusted = errors.New("lxd server does not trust the client")
+
+	// ErrInstanceNotFound is returned (wrapped) when the instance the script
+	// should be executed in does not exist (in the project, if given).
+	ErrInstanceNotFound = errors.New("lxd instance not found")
+
+	// ErrInstanceNotRunning is returned (wrapped) when the instance the script
+	// should be executed in is not running.
+	ErrInstanceNotRunning = errors.New("lxd instance is not running")
+
+	// ErrExecution is returned (wrapped) when the script could not be started
+	// in the instance, or its exit status was lost.
+	ErrExecution = errors.New("lxd exec failed")
+)
@@ -0,0 +1,162 @@
+package lxd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/neaas/nescript"
+	"golang.org/x/net/websocket"
+)
+
+// statusRunning is the status of a running instance.
+const statusRunning = "Running"
+
+// Executor provides an ExecFunc that runs the script/cmd inside the named LXD
+// instance (container or VM), as `lxc exec` does, through the LXD server at
+// the address: unix:///path/to/unix.socket, https://host:8443 for a remote
+// server (see WithClientCert), or empty for the local server. Stdout and
+// stderr are streamed separately over the exec operation's websockets, and the
+// result's exit code is that of the operation. This ExecFunc does not require
+// that the cmd/script be converted to a string, so is Formatter agnostic.
+func Executor(address, instance string, opts ...Option) nescript.ExecFunc {
+	o := newOptions(opts)
+	client, err := o.newClient(address)
+	return func(c nescript.Cmd) (nescript.Process, error) {
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrConnection, err)
+		}
+		return o.exec(c, client, instance)
+	}
+}
+
+// execRequest is the body of an instance exec request.
+type execRequest struct {
+	Command     []string          `json:"command"`
+	Environment map[string]string `json:"environment,omitempty"`
+	Interactive bool              `json:"interactive"`
+	WaitForWS   bool              `json:"wait-for-websocket"`
+	User        *uint32           `json:"user,omitempty"`
+	Group       *uint32           `json:"group,omitempty"`
+	Cwd         string            `json:"cwd,omitempty"`
+}
+
+// operation is the part of an LXD operation used by the executor.
+type operation struct {
+	ID       string `json:"id"`
+	Status   string `json:"status"`
+	Err      string `json:"err"`
+	Metadata struct {
+		FDs    map[string]string `json:"fds"`
+		Return *int              `json:"return"`
+	} `json:"metadata"`
+}
+
+func (o *options) exec(c nescript.Cmd, client *client, instance string) (nescript.Process, error) {
+	ctx := c.Context()
+	if err := client.ensureTrusted(ctx); err != nil {
+		return nil, err
+	}
+	if err := client.checkRunning(ctx, instance); err != nil {
+		return nil, err
+	}
+	request := execRequest{
+		Command:     command(c, o.shell),
+		Environment: environment(c.Env()),
+		WaitForWS:   true,
+		User:        o.user,
+		Group:       o.group,
+		Cwd:         o.cwd,
+	}
+	resp, err := client.do(ctx, http.MethodPost, "/1.0/instances/"+url.PathEscape(instance)+"/exec", nil, request)
+	if err != nil {
+		return nil, fmt.Errorf("%w: in instance '%s': %w", ErrExecution, instance, err)
+	}
+	op := &operation{}
+	if err := json.Unmarshal(resp.Metadata, op); err != nil {
+		return nil, fmt.Errorf("%w: failed to decode exec operation: %w", ErrExecution, err)
+	}
+	process := &LXDProcess{
+		client:      client,
+		instance:    instance,
+		operationID: op.ID,
+		done:        make(chan struct{}),
+	}
+	// the command only starts once every websocket is connected.
+	for _, fd := range []string{"control", "0", "1", "2"} {
+		secret, ok := op.Metadata.FDs[fd]
+		if !ok {
+			process.closeWebsockets()
+			return nil, fmt.Errorf("%w: exec operation has no websocket for fd '%s'", ErrExecution, fd)
+		}
+		ws, err := client.websocket(ctx, "/1.0/operations/"+url.PathEscape(op.ID)+"/websocket", url.Values{"secret": {secret}})
+		if err != nil {
+			process.closeWebsockets()
+			return nil, fmt.Errorf("%w: failed to connect exec websocket for fd '%s': %w", ErrExecution, fd, err)
+		}
+		process.websockets = append(process.websockets, ws)
+	}
+	process.control, process.stdin = process.websockets[0], process.websockets[1]
+	process.tee = nescript.NewTee(c, &process.stdout, &process.stderr)
+	process.started = time.Now()
+	go process.watch(ctx)
+	return process, nil
+}
+
+// checkRunning ensures the instance exists and is running, so that exec fails
+// with a typed error rather than the server's message.
+func (c *client) checkRunning(ctx context.Context, instance string) error {
+	resp, err := c.do(ctx, http.MethodGet, "/1.0/instances/"+url.PathEscape(instance), nil, nil)
+	if errors.Is(err, errNotFound) {
+		return fmt.Errorf("%w: '%s'", ErrInstanceNotFound, instance)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get lxd instance '%s': %w", instance, err)
+	}
+	var state struct {
+		Status string `json:"status"`
+	}
+	if err := json.Unmarshal(resp.Metadata, &state); err != nil {
+		return fmt.Errorf("failed to decode lxd instance: %w", err)
+	}
+	if state.Status != statusRunning {
+		return fmt.Errorf("%w: instance '%s' is %s", ErrInstanceNotRunning, instance, strings.ToLower(state.Status))
+	}
+	return nil
+}
+
+// command returns the command run in the instance. Cmds created from a script
+// are invoked with the shell, if given.
+func command(c nescript.Cmd, shell nescript.Subcommand) []string {
+	_, script, trailing, ok := c.Script()
+	if !ok || shell == nil {
+		return c.Raw()
+	}
+	return append(append(append([]string{}, shell...), script), trailing...)
+}
+
+// environment converts the cmd's env (KEY=value) into the exec environment.
+func environment(env []string) map[string]string {
+	if len(env) == 0 {
+		return nil
+	}
+	vars := make(map[string]string, len(env))
+	for _, e := range env {
+		key, value, _ := strings.Cut(e, "=")
+		vars[key] = value
+	}
+	return vars
+}
+
+// signal sends the signal to the exec'd command over the control websocket.
+func signal(control *websocket.Conn, sig int) error {
+	return websocket.JSON.Send(control, map[string]any{
+		"command": "signal",
+		"signal":  sig,
+	})
+}
@@ -0,0 +1,145 @@
+package lxd
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/neaas/nescript"
+)
+
+// Option configures the LXD executor.
+type Option func(*options)
+
+type options struct {
+	project    string
+	user       *uint32
+	group      *uint32
+	cwd        string
+	shell      nescript.Subcommand
+	cert       []byte
+	key        []byte
+	serverCert []byte
+	skipVerify bool
+	trustToken string
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithProject sets the LXD project the instance is in, rather than the
+// default project.
+func WithProject(project string) Option {
+	return func(o *options) {
+		o.project = project
+	}
+}
+
+// WithUser sets the user ID the script is run as in the instance, rather than
+// root.
+func WithUser(uid uint32) Option {
+	return func(o *options) {
+		o.user = &uid
+	}
+}
+
+// WithGroup sets the group ID the script is run as in the instance, rather
+// than root.
+func WithGroup(gid uint32) Option {
+	return func(o *options) {
+		o.group = &gid
+	}
+}
+
+// WithCwd sets the directory the script is run in within the instance, rather
+// than the user's home directory.
+func WithCwd(dir string) Option {
+	return func(o *options) {
+		o.cwd = dir
+	}
+}
+
+// WithShell sets the shell scripts are invoked with in the instance, such as
+// nescript.SCBash, rather than the cmd's subcommand. Cmds not created from a
+// script are unaffected.
+func WithShell(shell nescript.Subcommand) Option {
+	return func(o *options) {
+		o.shell = shell
+	}
+}
+
+// WithClientCert sets the PEM encoded client certificate and key presented to
+// remote (https) LXD servers, which must trust it (see WithTrustToken).
+func WithClientCert(certPEM, keyPEM []byte) Option {
+	return func(o *options) {
+		o.cert = certPEM
+		o.key = keyPEM
+	}
+}
+
+// WithServerCert only trusts a remote LXD server presenting the PEM encoded
+// certificate (as `lxc remote add` stores it), as LXD servers normally have
+// self-signed certificates. Otherwise the server is verified against the
+// system roots.
+func WithServerCert(pem []byte) Option {
+	return func(o *options) {
+		o.serverCert = pem
+	}
+}
+
+// WithInsecureSkipVerify connects to remote LXD servers without verifying
+// their certificate. This should only be used on trusted networks.
+func WithInsecureSkipVerify() Option {
+	return func(o *options) {
+		o.skipVerify = true
+	}
+}
+
+// WithTrustToken sets the trust token (from `lxc config trust add`) used to
+// have a remote LXD server trust the client certificate, if it does not
+// already. Tokens can only be used once, after which the certificate stays
+// trusted.
+func WithTrustToken(token string) Option {
+	return func(o *options) {
+		o.trustToken = token
+	}
+}
+
+// tlsConfig builds the TLS config for remote LXD servers.
+func (o *options) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: o.skipVerify,
+	}
+	if o.cert != nil || o.key != nil {
+		cert, err := tls.X509KeyPair(o.cert, o.key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse tls client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if o.serverCert != nil {
+		// the certificate is pinned rather than used as a root, as LXD's
+		// self-signed certificates rarely name the address used.
+		block, _ := pem.Decode(o.serverCert)
+		if block == nil {
+			return nil, fmt.Errorf("failed to parse lxd server certificate")
+		}
+		pinned := block.Bytes
+		config.InsecureSkipVerify = true
+		config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) > 0 && bytes.Equal(rawCerts[0], pinned) {
+				return nil
+			}
+			return fmt.Errorf("lxd server certificate does not match the pinned certificate")
+		}
+	}
+	return config, nil
+}
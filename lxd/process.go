@@ -0,0 +1,217 @@
+package lxd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/neaas/nescript"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// MetadataOperation is the result metadata key holding the ID of the LXD
+	// exec operation the script was run by.
+	MetadataOperation = "lxd.operation"
+
+	// killTimeout is how long the operation is waited for once the command has
+	// been killed, should the server not respond.
+	killTimeout = 10 * time.Second
+)
+
+// LXDProcess represents a single instance of the script running or completed
+// in an LXD instance.
+type LXDProcess struct {
+	client      *client
+	instance    string
+	operationID string
+	websockets  []*websocket.Conn
+	control     *websocket.Conn
+	stdin       *websocket.Conn
+	done        chan struct{}
+	stdinOnce   sync.Once
+	closeOnce   sync.Once
+	started     time.Time
+	tee         *nescript.Tee
+
+	// set by watch, before done is closed.
+	stdout   bytes.Buffer
+	stderr   bytes.Buffer
+	exitCode int
+	ended    time.Time
+	err      error
+}
+
+// OperationID returns the ID of the exec operation running the script.
+func (p *LXDProcess) OperationID() string {
+	return p.operationID
+}
+
+// watch collects the output of the command and waits for the operation to
+// complete. If the context is done first, the command is killed.
+func (p *LXDProcess) watch(ctx context.Context) {
+	defer close(p.done)
+	defer func() { p.ended = time.Now() }()
+	defer p.tee.Finish(nil)
+	defer p.closeWebsockets()
+	var output sync.WaitGroup
+	output.Add(2)
+	go receive(p.websockets[2], p.tee.Stdout(), &output)
+	go receive(p.websockets[3], p.tee.Stderr(), &output)
+	waited := make(chan error, 1)
+	op := &operation{}
+	waitCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		resp, err := p.client.do(waitCtx, http.MethodGet, "/1.0/operations/"+url.PathEscape(p.operationID)+"/wait", url.Values{"timeout": {"-1"}}, nil)
+		if err == nil {
+			err = json.Unmarshal(resp.Metadata, op)
+		}
+		waited <- err
+	}()
+	var err error
+	select {
+	case err = <-waited:
+	case <-ctx.Done():
+		signal(p.control, int(syscall.SIGKILL))
+		select {
+		case <-waited:
+		case <-time.After(killTimeout):
+		}
+		p.err = fmt.Errorf("%w: command killed: %w", ErrExecution, nescript.ContextError(ctx.Err()))
+		return
+	}
+	output.Wait()
+	switch {
+	case err != nil:
+		p.err = fmt.Errorf("%w: failed to wait for exec operation: %w", ErrExecution, err)
+	case op.Metadata.Return == nil:
+		p.err = fmt.Errorf("%w: exec operation %s: %s", ErrExecution, op.Status, op.Err)
+	default:
+		p.exitCode = *op.Metadata.Return
+	}
+}
+
+// receive copies the output received on the websocket until it is closed.
+func receive(ws *websocket.Conn, dst io.Writer, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for {
+		var data []byte
+		if err := websocket.Message.Receive(ws, &data); err != nil {
+			return
+		}
+		dst.Write(data)
+	}
+}
+
+func (p *LXDProcess) closeWebsockets() {
+	p.closeOnce.Do(func() {
+		for _, ws := range p.websockets {
+			ws.Close()
+		}
+	})
+}
+
+// Kill sends a SIGKILL to the command, doing nothing once the operation has
+// completed.
+func (p *LXDProcess) Kill() error {
+	select {
+	case <-p.done:
+		return nil
+	default:
+	}
+	return p.Signal(syscall.SIGKILL)
+}
+
+// Signal sends the signal to the command in the instance. Only syscall
+// signals can be sent.
+func (p *LXDProcess) Signal(s os.Signal) error {
+	sig, ok := s.(syscall.Signal)
+	if !ok {
+		return fmt.Errorf("can not send signal '%s' to lxd instance", s)
+	}
+	if err := signal(p.control, int(sig)); err != nil {
+		return fmt.Errorf("failed to send signal to lxd instance: %w", err)
+	}
+	return nil
+}
+
+func (p *LXDProcess) Write(input string) error {
+	if err := websocket.Message.Send(p.stdin, []byte(input)); err != nil {
+		return fmt.Errorf("failed to write to lxd exec stdin: %w", err)
+	}
+	return nil
+}
+
+// CloseStdin closes the command's stdin, for scripts that read it until EOF.
+func (p *LXDProcess) CloseStdin() error {
+	var err error
+	p.stdinOnce.Do(func() {
+		err = p.stdin.Close()
+	})
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("failed to close lxd exec stdin: %w", err)
+	}
+	return nil
+}
+
+func (p *LXDProcess) Stdin() io.WriteCloser {
+	return nescript.StdinWriter(p)
+}
+
+func (p *LXDProcess) Stdout() io.Reader {
+	return p.tee.StdoutStream()
+}
+
+func (p *LXDProcess) Stderr() io.Reader {
+	return p.tee.StderrStream()
+}
+
+// ID returns the ID of the exec operation running the script (see
+// OperationID).
+func (p *LXDProcess) ID() string {
+	return p.operationID
+}
+
+func (p *LXDProcess) String() string {
+	return fmt.Sprintf("lxd exec %s in %s", p.operationID, p.instance)
+}
+
+func (p *LXDProcess) Wait(ctx context.Context) (*nescript.Result, error) {
+	return nescript.Wait(ctx, p)
+}
+
+func (p *LXDProcess) Result() (*nescript.Result, error) {
+	<-p.done
+	if p.err != nil {
+		return nil, p.err
+	}
+	result := nescript.Result{
+		StdOut:   p.stdout.String(),
+		StdErr:   p.stderr.String(),
+		ExitCode: p.exitCode,
+	}
+	result.SetTimes(p.started, p.ended)
+	result.SetMetadata(nescript.MetadataExecutor, "lxd")
+	result.SetMetadata(MetadataOperation, p.operationID)
+	p.tee.Finish(&result)
+	return &result, nil
+}
+
+// Close kills the command if it is still running.
+func (p *LXDProcess) Close() {
+	select {
+	case <-p.done:
+	default:
+		p.Kill()
+		<-p.done
+	}
+}
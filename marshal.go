@@ -0,0 +1,110 @@
+package nescript
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// ScriptSchemaVersion is the version of the schema scripts are marshaled with
+// (see Script.MarshalJSON). It is incremented by changes that older versions
+// could not unmarshal faithfully; fields added in a compatible way are ignored
+// by older versions.
+const ScriptSchemaVersion = 1
+
+// scriptJSON is the schema of a marshaled script.
+type scriptJSON struct {
+	Version    int            `json:"version"`
+	Raw        string         `json:"raw"`
+	Redacted   bool           `json:"redacted,omitempty"`
+	Subcommand Subcommand     `json:"subcommand"`
+	Origin     string         `json:"origin,omitempty"`
+	Data       map[string]any `json:"data,omitempty"`
+	Env        []string       `json:"env,omitempty"`
+	Required   []string       `json:"required,omitempty"`
+	Secrets    *secretsJSON   `json:"secrets,omitempty"`
+}
+
+// secretsJSON names the secret fields and env vars of a marshaled script,
+// whose values are excluded.
+type secretsJSON struct {
+	Fields []string `json:"fields,omitempty"`
+	Env    []string `json:"env,omitempty"`
+}
+
+// MarshalJSON marshals the script, with its raw (as it is, or compiled if it
+// has been), subcommand, origin, template data, env and required fields, along
+// with the schema version (see ScriptSchemaVersion). For example:
+//
+//	{"version":1,"raw":"echo {{.name}}","subcommand":["sh","-c"],"data":{"name":"world"},"env":["TZ=UTC"]}
+//
+// The values of secret fields and env vars (see WithSecretField and
+// WithSecretEnv) are excluded, only their names being kept under "secrets", so
+// they must be given again once unmarshaled. A script compiled with secret
+// values has them redacted from its raw (as String does), marked by
+// "redacted", so it is marshaled for logs and audit rather than to be executed
+// again once unmarshaled. The logger is not marshaled.
+func (s Script) MarshalJSON() ([]byte, error) {
+	v := scriptJSON{
+		Version:    ScriptSchemaVersion,
+		Raw:        s.raw,
+		Subcommand: s.subcommand,
+		Origin:     s.origin,
+		Required:   s.required,
+	}
+	if len(s.secrets.values) > 0 {
+		v.Raw, v.Redacted = s.redactSecrets(s.raw), true
+	}
+	for key, value := range s.Data() {
+		if s.isSecretField(key) {
+			continue
+		}
+		if v.Data == nil {
+			v.Data = make(map[string]any)
+		}
+		v.Data[key] = value
+	}
+	for _, e := range s.Env() {
+		if !s.isSecretEnv(e) {
+			v.Env = append(v.Env, e)
+		}
+	}
+	if len(s.secrets.fields) > 0 || len(s.secrets.env) > 0 {
+		v.Secrets = &secretsJSON{Fields: s.secrets.fields, Env: s.secrets.env}
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON unmarshals a script marshaled by MarshalJSON, of a schema
+// version no later than ScriptSchemaVersion, otherwise returning an error
+// wrapping ErrSchemaVersion. Fields of the schema that are not known are
+// ignored. Numbers in the template data are unmarshaled as json.Number, so
+// that they compile as they were given. Secret fields and env vars remain
+// marked as secret, without their values.
+func (s *Script) UnmarshalJSON(b []byte) error {
+	var v scriptJSON
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	if err := decoder.Decode(&v); err != nil {
+		return err
+	}
+	if v.Version < 1 || v.Version > ScriptSchemaVersion {
+		return fmt.Errorf("%w: %d", ErrSchemaVersion, v.Version)
+	}
+	script := NewScript(v.Raw)
+	script.subcommand = v.Subcommand
+	script.origin = v.Origin
+	script.required = v.Required
+	script.addFields(v.Data, true)
+	script.addEnv(v.Env...)
+	if v.Secrets != nil {
+		for _, key := range v.Secrets.Fields {
+			script.secrets.fields = withSecret(script.secrets.fields, key)
+		}
+		for _, key := range v.Secrets.Env {
+			script.secrets.env = withSecret(script.secrets.env, key)
+		}
+	}
+	*s = *script
+	return nil
+}
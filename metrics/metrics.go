@@ -0,0 +1,194 @@
+// Package metrics instruments executors with prometheus metrics of their
+// executions, labelled by the type of executor and the name of the script.
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/neaas/nescript"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The labels of the metrics.
+const (
+	LabelExecutor = "executor"
+	LabelScript   = "script"
+	LabelTarget   = "target"
+	LabelResult   = "result"
+	LabelClass    = "class"
+	LabelStream   = "stream"
+)
+
+// The values of the result label of completed executions.
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+// Metrics are the collectors of executions, registered with New. Executors
+// are instrumented with Executor.
+type Metrics struct {
+	started   *prometheus.CounterVec
+	completed *prometheus.CounterVec
+	failures  *prometheus.CounterVec
+	duration  *prometheus.HistogramVec
+	output    *prometheus.CounterVec
+	inFlight  *prometheus.GaugeVec
+	exitCode  *prometheus.GaugeVec
+	o         *options
+}
+
+// New creates the collectors of executions, registering them with the
+// registerer (such as prometheus.DefaultRegisterer):
+//
+//   - nescript_executions_started_total
+//   - nescript_executions_completed_total, by result (success or failure)
+//   - nescript_execution_failures_total, by class (see nescript.ErrorClass)
+//   - nescript_execution_duration_seconds
+//   - nescript_output_bytes_total, by stream (stdout or stderr)
+//   - nescript_executions_in_flight
+//   - nescript_last_exit_code
+//
+// Each is labelled by executor and script, and target if opted in to (see
+// WithTargetLabel). An error is returned if a collector can not be registered,
+// such as when already registered.
+func New(registerer prometheus.Registerer, opts ...Option) (*Metrics, error) {
+	o := newOptions(opts)
+	labels := []string{LabelExecutor, LabelScript}
+	if o.targetLabel {
+		labels = append(labels, LabelTarget)
+	}
+	with := func(extra ...string) []string {
+		return append(append([]string{}, labels...), extra...)
+	}
+	m := &Metrics{
+		started: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: o.namespace,
+			Name:      "executions_started_total",
+			Help:      "Number of script executions started.",
+		}, labels),
+		completed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: o.namespace,
+			Name:      "executions_completed_total",
+			Help:      "Number of script executions completed, by whether the script succeeded.",
+		}, with(LabelResult)),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: o.namespace,
+			Name:      "execution_failures_total",
+			Help:      "Number of script executions failed, by the class of failure.",
+		}, with(LabelClass)),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: o.namespace,
+			Name:      "execution_duration_seconds",
+			Help:      "Duration of script executions.",
+			Buckets:   o.buckets,
+		}, labels),
+		output: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: o.namespace,
+			Name:      "output_bytes_total",
+			Help:      "Bytes of output written by scripts, by stream.",
+		}, with(LabelStream)),
+		inFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: o.namespace,
+			Name:      "executions_in_flight",
+			Help:      "Number of script executions running.",
+		}, labels),
+		exitCode: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: o.namespace,
+			Name:      "last_exit_code",
+			Help:      "Exit code of the last completed execution of the script.",
+		}, labels),
+		o: o,
+	}
+	for _, collector := range []prometheus.Collector{m.started, m.completed, m.failures, m.duration, m.output, m.inFlight, m.exitCode} {
+		if err := registerer.Register(collector); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Executor wraps the executor so that its executions are recorded in the
+// metrics, labelled with the type of executor (such as "ssh" or "docker") and
+// the options. An execution is recorded once its result is collected; a
+// process closed before then is only removed from the in-flight gauge. As a
+// wrapper, it composes with others, such as nescript.Logging and
+// nescript.Fallback.
+func (m *Metrics) Executor(executor nescript.ExecFunc, executorType string, opts ...ExecutorOption) nescript.ExecFunc {
+	eo := newExecutorOptions(opts)
+	labels := prometheus.Labels{LabelExecutor: executorType, LabelScript: eo.script}
+	if m.o.targetLabel {
+		labels[LabelTarget] = eo.target
+	}
+	return func(c nescript.Cmd) (nescript.Process, error) {
+		start := time.Now()
+		m.started.With(labels).Inc()
+		process, err := executor(c)
+		if err != nil {
+			m.failed(labels, err)
+			m.duration.With(labels).Observe(time.Since(start).Seconds())
+			return nil, err
+		}
+		m.inFlight.With(labels).Inc()
+		instrumented := &instrumentedProcess{metrics: m, labels: labels, start: start}
+		return nescript.WrapProcess(process, nescript.ProcessHooks{Result: instrumented.result, Close: instrumented.close}), nil
+	}
+}
+
+// failed records the failed execution.
+func (m *Metrics) failed(labels prometheus.Labels, err error) {
+	m.completed.MustCurryWith(labels).WithLabelValues(ResultFailure).Inc()
+	m.failures.MustCurryWith(labels).WithLabelValues(nescript.ErrorClass(err)).Inc()
+}
+
+// instrumentedProcess records the result of the process once collected.
+type instrumentedProcess struct {
+	metrics *Metrics
+	labels  prometheus.Labels
+	start   time.Time
+	once    sync.Once
+}
+
+func (p *instrumentedProcess) result(result *nescript.Result, err error) (*nescript.Result, error) {
+	p.once.Do(func() {
+		p.metrics.collected(p.labels, p.start, result, err)
+	})
+	return result, err
+}
+
+func (p *instrumentedProcess) close() {
+	p.once.Do(func() {
+		p.metrics.inFlight.With(p.labels).Dec()
+	})
+}
+
+// collected records the result (or error) of an execution.
+func (m *Metrics) collected(labels prometheus.Labels, start time.Time, result *nescript.Result, err error) {
+	m.inFlight.With(labels).Dec()
+	if err != nil {
+		m.failed(labels, err)
+		m.duration.With(labels).Observe(time.Since(start).Seconds())
+		return
+	}
+	duration := result.Duration()
+	if duration <= 0 {
+		duration = time.Since(start)
+	}
+	m.duration.With(labels).Observe(duration.Seconds())
+	m.exitCode.With(labels).Set(float64(result.ExitCode))
+	output := m.output.MustCurryWith(labels)
+	output.WithLabelValues("stdout").Add(float64(size(result.StdOut, result.StdOutSize)))
+	output.WithLabelValues("stderr").Add(float64(size(result.StdErr, result.StdErrSize)))
+	if err := result.Err(); err != nil {
+		m.failed(labels, err)
+		return
+	}
+	m.completed.MustCurryWith(labels).WithLabelValues(ResultSuccess).Inc()
+}
+
+// size returns the size of the output the script wrote, which is larger than
+// the output kept if it was truncated.
+func size(output string, written int64) int64 {
+	return max(int64(len(output)), written)
+}
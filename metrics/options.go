@@ -0,0 +1,77 @@
+package metrics
+
+// defaultBuckets are the buckets, in seconds, of the duration histogram.
+var defaultBuckets = []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 900, 3600}
+
+// Option configures the collectors created by New.
+type Option func(*options)
+
+type options struct {
+	namespace   string
+	buckets     []float64
+	targetLabel bool
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{namespace: "nescript", buckets: defaultBuckets}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithNamespace sets the namespace (prefix) of the metric names, rather than
+// nescript.
+func WithNamespace(namespace string) Option {
+	return func(o *options) {
+		o.namespace = namespace
+	}
+}
+
+// WithBuckets sets the buckets, in seconds, of the duration histogram.
+func WithBuckets(buckets ...float64) Option {
+	return func(o *options) {
+		o.buckets = buckets
+	}
+}
+
+// WithTargetLabel adds the target label to every metric, set by the target
+// given to each instrumented executor (see WithTarget). As targets such as
+// host names can be many, the label is left out unless opted in to.
+func WithTargetLabel() Option {
+	return func(o *options) {
+		o.targetLabel = true
+	}
+}
+
+// ExecutorOption configures the labels of an instrumented executor.
+type ExecutorOption func(*executorOptions)
+
+type executorOptions struct {
+	script string
+	target string
+}
+
+func newExecutorOptions(opts []ExecutorOption) *executorOptions {
+	o := &executorOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithScript sets the script label of the executions, naming the script the
+// executor runs, such as "backup".
+func WithScript(name string) ExecutorOption {
+	return func(o *executorOptions) {
+		o.script = name
+	}
+}
+
+// WithTarget sets the target label of the executions, if the metrics were
+// created with WithTargetLabel.
+func WithTarget(target string) ExecutorOption {
+	return func(o *executorOptions) {
+		o.target = target
+	}
+}
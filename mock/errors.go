@@ -0,0 +1,19 @@
+package mock
+
+import "errors"
+
+var (
+	// ErrUnexpectedCall is returned (wrapped) by the executor when a cmd
+	// matches none of the mock's expectations (or all that match have been
+	// called as many times as expected).
+	ErrUnexpectedCall = errors.New("unexpected call to mock executor")
+
+	// ErrCancelled is returned (wrapped) by Result when the cmd's context is
+	// done before the expectation's latency has elapsed, as a real executor
+	// killing the script would.
+	ErrCancelled = errors.New("mock execution cancelled")
+
+	// ErrExited is returned when writing to, or signalling, a mock process that
+	// has already exited.
+	ErrExited = errors.New("mock process has exited")
+)
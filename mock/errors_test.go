@@ -0,0 +1,58 @@
+package mock_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/mock"
+)
+
+func TestErrors(t *testing.T) {
+	refused := fmt.Errorf("%w: refused", nescript.NewError(nescript.ErrConnection, "failed to connect to test target"))
+	m := mock.New(nil)
+	m.On(mock.Raw("connect")).Fail(refused)
+	m.On(mock.Raw("sleep 30")).Latency(time.Hour)
+	for _, tc := range []struct {
+		name   string
+		script string
+		ctx    func() (context.Context, context.CancelFunc)
+		is     []error
+		class  string
+	}{
+		{"unexpected call", "unexpected", func() (context.Context, context.CancelFunc) {
+			return context.WithCancel(context.Background())
+		}, []error{mock.ErrUnexpectedCall}, nescript.ErrorClassOther},
+		{"failed to start", "connect", func() (context.Context, context.CancelFunc) {
+			return context.WithCancel(context.Background())
+		}, []error{refused, nescript.ErrConnection}, nescript.ErrorClassConnection},
+		{"timeout", "sleep 30", func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), 100*time.Millisecond)
+		}, []error{mock.ErrCancelled, nescript.ErrTimeout, context.DeadlineExceeded}, nescript.ErrorClassTimeout},
+		{"cancelled", "sleep 30", func() (context.Context, context.CancelFunc) {
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(100*time.Millisecond, cancel)
+			return ctx, cancel
+		}, []error{mock.ErrCancelled, nescript.ErrCancelled, context.Canceled}, nescript.ErrorClassCancelled},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := tc.ctx()
+			defer cancel()
+			process, err := nescript.NewScript(tc.script).Cmd().WithContext(ctx).Exec(m.Executor())
+			if err == nil {
+				_, err = process.Result()
+			}
+			for _, target := range tc.is {
+				if !errors.Is(err, target) {
+					t.Errorf("expected %v to match %v", err, target)
+				}
+			}
+			if class := nescript.ErrorClass(err); class != tc.class {
+				t.Errorf("expected the class %s, got %s", tc.class, class)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package mock
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/neaas/nescript"
+)
+
+// Matcher reports whether a cmd is that an expectation is for.
+type Matcher func(c nescript.Cmd) bool
+
+// Raw matches cmds whose raw form (see Command) is exactly the given string,
+// such as the compiled script.
+func Raw(raw string) Matcher {
+	return func(c nescript.Cmd) bool {
+		return Command(c) == raw
+	}
+}
+
+// Regexp matches cmds whose raw form (see Command) matches the regular
+// expression. It panics if the expression does not compile.
+func Regexp(pattern string) Matcher {
+	re := regexp.MustCompile(pattern)
+	return func(c nescript.Cmd) bool {
+		return re.MatchString(Command(c))
+	}
+}
+
+// Any matches every cmd.
+func Any() Matcher {
+	return func(nescript.Cmd) bool {
+		return true
+	}
+}
+
+// Command is the raw form of the cmd expectations are matched against: the
+// script (without its subcommand) if the cmd was created from one, otherwise
+// the command and its args joined by spaces.
+func Command(c nescript.Cmd) string {
+	if _, script, _, ok := c.Script(); ok {
+		return script
+	}
+	return strings.Join(c.Raw(), " ")
+}
+
+// Expectation is a programmed response of the mock to the cmds it matches.
+// Expectations are configured by chaining, and are safe to configure only
+// before the executor is used.
+type Expectation struct {
+	matcher  Matcher
+	caller   string
+	stdout   string
+	stderr   string
+	exitCode int
+	err      error
+	latency  time.Duration
+	times    int
+	optional bool
+
+	// guarded by the mock's mutex.
+	calls int
+}
+
+// Return sets the output and exit code of the result of the matched cmds.
+func (e *Expectation) Return(stdout, stderr string, exitCode int) *Expectation {
+	e.stdout = stdout
+	e.stderr = stderr
+	e.exitCode = exitCode
+	return e
+}
+
+// Fail makes the executor return the error for the matched cmds, rather than a
+// process, as when a real executor can not start the script.
+func (e *Expectation) Fail(err error) *Expectation {
+	e.err = err
+	return e
+}
+
+// Latency sets how long the script "runs" for before its result is available,
+// during which it can be signalled, killed or cancelled by its context.
+func (e *Expectation) Latency(latency time.Duration) *Expectation {
+	e.latency = latency
+	return e
+}
+
+// Times sets how many times the expectation must be matched. Once it has, it
+// no longer matches further cmds. By default, it must be matched at least once
+// and matches any number of cmds.
+func (e *Expectation) Times(n int) *Expectation {
+	e.times = n
+	return e
+}
+
+// Once is Times(1).
+func (e *Expectation) Once() *Expectation {
+	return e.Times(1)
+}
+
+// Optional allows the expectation to not be matched at all, when asserting
+// the expectations were met.
+func (e *Expectation) Optional() *Expectation {
+	e.optional = true
+	return e
+}
+
+// exhausted reports whether the expectation has been matched as many times as
+// it may be.
+func (e *Expectation) exhausted() bool {
+	return e.times > 0 && e.calls >= e.times
+}
+
+// unmet reports whether the expectation has been matched fewer times than it
+// must be.
+func (e *Expectation) unmet() bool {
+	if e.times > 0 {
+		return e.calls < e.times
+	}
+	return !e.optional && e.calls == 0
+}
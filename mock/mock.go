@@ -0,0 +1,207 @@
+package mock
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sync"
+
+	"github.com/neaas/nescript"
+)
+
+// TestingT is the part of *testing.T used by the mock, to fail the test on
+// unexpected or missing calls.
+type TestingT interface {
+	Helper()
+	Errorf(format string, args ...any)
+	Cleanup(func())
+}
+
+// Mock is an executor whose behavior is programmed per expected cmd (see On),
+// recording every cmd it is given, for unit testing code that executes scripts
+// without running real processes.
+type Mock struct {
+	t      TestingT
+	strict bool
+	stdout io.Writer
+	stderr io.Writer
+
+	mu           sync.Mutex
+	expectations []*Expectation
+	calls        []*Call
+}
+
+// Option configures the mock.
+type Option func(*Mock)
+
+// WithStrict fails the test when the executor is given a cmd that matches no
+// expectation, and when the test ends with an expectation not met (see
+// AssertExpectations).
+func WithStrict() Option {
+	return func(m *Mock) {
+		m.strict = true
+	}
+}
+
+// WithOutput sets writers the output of each process is written to as soon as
+// it starts, before its latency elapses, for exercising code that streams
+// output while the script runs.
+func WithOutput(stdout, stderr io.Writer) Option {
+	return func(m *Mock) {
+		m.stdout = stdout
+		m.stderr = stderr
+	}
+}
+
+// New creates a mock, failing the test through t (which may be nil outside of
+// tests). Without expectations, every cmd is unexpected.
+func New(t TestingT, opts ...Option) *Mock {
+	m := &Mock{t: t}
+	for _, opt := range opts {
+		opt(m)
+	}
+	if m.strict && t != nil {
+		t.Cleanup(func() {
+			m.AssertExpectations()
+		})
+	}
+	return m
+}
+
+// On adds an expectation for the cmds the matcher matches, returning it to be
+// programmed. Expectations are tried in the order they were added.
+func (m *Mock) On(matcher Matcher) *Expectation {
+	e := &Expectation{matcher: matcher, caller: caller()}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expectations = append(m.expectations, e)
+	return e
+}
+
+// caller is the file and line an expectation was added at, for identifying it
+// in failures.
+func caller() string {
+	if _, file, line, ok := runtime.Caller(2); ok {
+		return fmt.Sprintf("%s:%d", filepath.Base(file), line)
+	}
+	return "unknown"
+}
+
+// Executor provides the ExecFunc of the mock. Each cmd is matched against the
+// expectations, recorded as a call, then either fails as the expectation was
+// programmed to, or starts a process that "runs" for the expectation's latency
+// before completing with its result. Unexpected cmds return
+// ErrUnexpectedCall, and fail the test in strict mode.
+func (m *Mock) Executor() nescript.ExecFunc {
+	return func(c nescript.Cmd) (nescript.Process, error) {
+		call := newCall(c)
+		m.mu.Lock()
+		e := m.match(c)
+		call.Expectation = e
+		m.calls = append(m.calls, call)
+		m.mu.Unlock()
+		if e == nil {
+			if m.strict && m.t != nil {
+				m.t.Helper()
+				m.t.Errorf("mock: unexpected call: %s", Command(c))
+			}
+			return nil, fmt.Errorf("%w: '%s'", ErrUnexpectedCall, Command(c))
+		}
+		if e.err != nil {
+			return nil, e.err
+		}
+		return m.start(c, e, call), nil
+	}
+}
+
+// match finds the first expectation not yet exhausted that matches the cmd,
+// counting the call against it.
+func (m *Mock) match(c nescript.Cmd) *Expectation {
+	for _, e := range m.expectations {
+		if !e.exhausted() && e.matcher(c) {
+			e.calls++
+			return e
+		}
+	}
+	return nil
+}
+
+// Calls returns every call made to the executor so far, in order.
+func (m *Mock) Calls() []*Call {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.calls)
+}
+
+// AssertExpectations fails the test for every expectation that was matched
+// fewer times than expected, reporting whether all were met. In strict mode,
+// this is called once the test ends.
+func (m *Mock) AssertExpectations() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	met := true
+	for _, e := range m.expectations {
+		if !e.unmet() {
+			continue
+		}
+		met = false
+		if m.t != nil {
+			m.t.Helper()
+			if e.times > 0 {
+				m.t.Errorf("mock: expectation at %s called %d of %d times", e.caller, e.calls, e.times)
+			} else {
+				m.t.Errorf("mock: expectation at %s never called", e.caller)
+			}
+		}
+	}
+	return met
+}
+
+// Call is a cmd the executor was given, along with what the process did with
+// it. The stdin written and signals sent are recorded until the process exits.
+type Call struct {
+	// Cmd is the cmd as given to the executor.
+	Cmd nescript.Cmd
+
+	// Command is the raw form of the cmd (see Command), and Args the cmd split
+	// by its arguments.
+	Command string
+	Args    []string
+
+	// Env is the env of the cmd, in KEY=VALUE format.
+	Env []string
+
+	// Expectation is that the cmd matched, nil if it was unexpected.
+	Expectation *Expectation
+
+	mu      sync.Mutex
+	stdin   []byte
+	signals []os.Signal
+}
+
+func newCall(c nescript.Cmd) *Call {
+	return &Call{
+		Cmd:     c,
+		Command: Command(c),
+		Args:    c.Raw(),
+		Env:     slices.Clone(c.Env()),
+	}
+}
+
+// Stdin returns everything written to the process's stdin.
+func (c *Call) Stdin() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return string(c.stdin)
+}
+
+// Signals returns the signals sent to the process, including the SIGKILL of
+// Kill.
+func (c *Call) Signals() []os.Signal {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.signals)
+}
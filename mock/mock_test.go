@@ -0,0 +1,243 @@
+package mock_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/mock"
+)
+
+// recordingT records the failures of the mock, and runs its cleanups once the
+// "test" ends (see end).
+type recordingT struct {
+	errors   []string
+	cleanups []func()
+}
+
+func (t *recordingT) Helper() {}
+
+func (t *recordingT) Errorf(format string, args ...any) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func (t *recordingT) Cleanup(f func()) {
+	t.cleanups = append(t.cleanups, f)
+}
+
+// end runs the cleanups, as the test ending would.
+func (t *recordingT) end() {
+	for i := len(t.cleanups) - 1; i >= 0; i-- {
+		t.cleanups[i]()
+	}
+}
+
+func TestStrict(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		program func(m *mock.Mock)
+		scripts []string
+		errors  []string
+	}{
+		{"met", func(m *mock.Mock) {
+			m.On(mock.Raw("echo a"))
+			m.On(mock.Raw("echo b")).Times(2)
+		}, []string{"echo a", "echo b", "echo b"}, nil},
+		{"unexpected call", func(m *mock.Mock) {
+			m.On(mock.Raw("echo a"))
+		}, []string{"echo a", "echo c"}, []string{"mock: unexpected call: echo c"}},
+		{"missing call", func(m *mock.Mock) {
+			m.On(mock.Raw("echo a"))
+			m.On(mock.Raw("echo b"))
+		}, []string{"echo a"}, []string{"mock: expectation at mock_test.go:"}},
+		{"called too few times", func(m *mock.Mock) {
+			m.On(mock.Raw("echo a")).Times(2)
+		}, []string{"echo a"}, []string{"mock: expectation at mock_test.go:"}},
+		{"called too many times", func(m *mock.Mock) {
+			m.On(mock.Raw("echo a")).Once()
+		}, []string{"echo a", "echo a"}, []string{"mock: unexpected call: echo a"}},
+		{"optional", func(m *mock.Mock) {
+			m.On(mock.Raw("echo a"))
+			m.On(mock.Raw("echo b")).Optional()
+		}, []string{"echo a"}, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := &recordingT{}
+			m := mock.New(recorder, mock.WithStrict())
+			tc.program(m)
+			for _, script := range tc.scripts {
+				process, err := nescript.NewScript(script).Cmd().Exec(m.Executor())
+				if err == nil {
+					_, err = process.Result()
+				}
+				if err != nil && !errors.Is(err, mock.ErrUnexpectedCall) {
+					t.Fatal(err)
+				}
+			}
+			recorder.end()
+			if len(recorder.errors) != len(tc.errors) {
+				t.Fatalf("expected the failures %q, got %q", tc.errors, recorder.errors)
+			}
+			for i, prefix := range tc.errors {
+				if !strings.HasPrefix(recorder.errors[i], prefix) {
+					t.Errorf("expected a failure starting %q, got %q", prefix, recorder.errors[i])
+				}
+			}
+		})
+	}
+}
+
+func TestStrictCounts(t *testing.T) {
+	recorder := &recordingT{}
+	m := mock.New(recorder, mock.WithStrict())
+	m.On(mock.Raw("echo a")).Times(3)
+	m.On(mock.Raw("echo b"))
+	nescript.NewScript("echo a").Cmd().Exec(m.Executor())
+	if m.AssertExpectations() {
+		t.Error("expected the expectations not met")
+	}
+	if len(recorder.errors) != 2 || !strings.HasSuffix(recorder.errors[0], "called 1 of 3 times") || !strings.HasSuffix(recorder.errors[1], "never called") {
+		t.Errorf("expected each unmet expectation reported, got %q", recorder.errors)
+	}
+}
+
+func TestNotStrict(t *testing.T) {
+	recorder := &recordingT{}
+	m := mock.New(recorder)
+	m.On(mock.Raw("echo a"))
+	_, err := nescript.NewScript("echo c").Cmd().Exec(m.Executor())
+	if !errors.Is(err, mock.ErrUnexpectedCall) || !strings.Contains(err.Error(), "'echo c'") {
+		t.Errorf("expected %v for the cmd, got %v", mock.ErrUnexpectedCall, err)
+	}
+	recorder.end()
+	if len(recorder.errors) != 0 || len(recorder.cleanups) != 0 {
+		t.Errorf("expected the test not failed, got %q", recorder.errors)
+	}
+	if m.AssertExpectations() || len(recorder.errors) != 1 {
+		t.Errorf("expected the missing call reported when asserted, got %q", recorder.errors)
+	}
+}
+
+func TestMatchers(t *testing.T) {
+	m := mock.New(t)
+	m.On(mock.Raw("echo exact")).Return("raw\n", "", 0)
+	m.On(mock.Regexp(`^curl .*example\.com`)).Return("regexp\n", "", 0)
+	m.On(func(c nescript.Cmd) bool {
+		return slices.Contains(c.Env(), "STAGE=prod")
+	}).Return("predicate\n", "", 0)
+	m.On(mock.Regexp(`^echo`)).Return("first match\n", "", 0)
+	m.On(mock.Raw("echo other")).Return("never\n", "", 0).Optional()
+	m.On(mock.Any()).Return("any\n", "", 1)
+	for _, tc := range []struct {
+		name     string
+		cmd      nescript.Cmd
+		stdout   string
+		exitCode int
+	}{
+		{"raw", nescript.NewScript("echo exact").Cmd(), "raw\n", 0},
+		{"raw with the subcommand", nescript.NewScript("echo exact").WithSubcommand(nescript.SCBash).Cmd(), "raw\n", 0},
+		{"regexp", nescript.NewScript("curl -fsS https://example.com/health").Cmd(), "regexp\n", 0},
+		{"predicate", nescript.NewScript("deploy").Cmd().WithEnv("STAGE=prod"), "predicate\n", 0},
+		{"first match", nescript.NewScript("echo other").Cmd(), "first match\n", 0},
+		{"command", *nescript.NewCmd("ls", "-l"), "any\n", 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			process, err := tc.cmd.Exec(m.Executor())
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := process.Result()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.StdOut != tc.stdout || result.ExitCode != tc.exitCode {
+				t.Errorf("expected stdout %q and exit code %d, got %q and %d", tc.stdout, tc.exitCode, result.StdOut, result.ExitCode)
+			}
+		})
+	}
+}
+
+func TestCalls(t *testing.T) {
+	m := mock.New(t, mock.WithStrict())
+	deploy := m.On(mock.Regexp(`^deploy`)).Return("deployed\n", "", 0)
+	m.On(mock.Raw("ls -l"))
+	script := nescript.NewScript("deploy {{.version}}").WithField("version", "1.2.3").WithEnv("STAGE=prod")
+	process, err := script.Cmd().CompileExec(m.Executor())
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := process.Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := nescript.NewCmd("ls", "-l").Exec(m.Executor()); err != nil {
+		t.Fatal(err)
+	}
+	calls := m.Calls()
+	if len(calls) != 2 {
+		t.Fatalf("expected 2 calls, got %d", len(calls))
+	}
+	if call := calls[0]; call.Command != "deploy 1.2.3" || !slices.Contains(call.Env, "STAGE=prod") || call.Expectation != deploy {
+		t.Errorf("expected the compiled script, its env and expectation recorded, got %q, %q and %v", call.Command, call.Env, call.Expectation)
+	}
+	if call := calls[1]; call.Command != "ls -l" || !slices.Equal(call.Args, []string{"ls", "-l"}) {
+		t.Errorf("expected the command and its args recorded, got %q and %q", call.Command, call.Args)
+	}
+	if calls[0] != process.(*mock.MockProcess).Call() {
+		t.Error("expected the process's call to be that recorded")
+	}
+	if executor, _ := result.Metadata[nescript.MetadataExecutor].(string); executor != "mock" {
+		t.Errorf("expected the mock executor recorded, got %q", executor)
+	}
+	if caller, _ := result.Metadata[mock.MetadataExpectation].(string); !strings.HasPrefix(caller, "mock_test.go:") {
+		t.Errorf("expected the expectation's caller recorded, got %q", caller)
+	}
+}
+
+func TestSignals(t *testing.T) {
+	m := mock.New(t)
+	m.On(mock.Any()).Return("partial\n", "", 0).Latency(time.Hour)
+	process, err := nescript.NewScript("sleep 600").Cmd().Exec(m.Executor())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := process.Signal(syscall.SIGQUIT); err != nil {
+		t.Fatal(err)
+	}
+	if err := process.Signal(syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	result, err := process.Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Signaled || result.Signal != "SIGTERM" || result.ExitCode != 143 || result.StdOut != "partial\n" {
+		t.Errorf("expected the process terminated by SIGTERM, got %v", result)
+	}
+	if signals := m.Calls()[0].Signals(); !slices.Equal(signals, []os.Signal{syscall.SIGQUIT, syscall.SIGTERM}) {
+		t.Errorf("expected both signals recorded, got %v", signals)
+	}
+	if err := process.Signal(syscall.SIGTERM); !errors.Is(err, mock.ErrExited) {
+		t.Errorf("expected %v once exited, got %v", mock.ErrExited, err)
+	}
+}
+
+func TestWithOutput(t *testing.T) {
+	var stdout, stderr strings.Builder
+	m := mock.New(t, mock.WithOutput(&stdout, &stderr))
+	m.On(mock.Any()).Return("out\n", "err\n", 0).Latency(time.Hour)
+	process, err := nescript.NewScript("sleep 600").Cmd().Exec(m.Executor())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer process.Close()
+	if stdout.String() != "out\n" || stderr.String() != "err\n" {
+		t.Errorf("expected the output written while running, got %q and %q", stdout.String(), stderr.String())
+	}
+}
@@ -0,0 +1,187 @@
+package mock
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/neaas/nescript"
+)
+
+// MetadataExpectation is the result metadata key holding the file and line the
+// matched expectation was added at.
+const MetadataExpectation = "mock.expectation"
+
+// MockProcess represents a single instance of a mocked script, "running" for
+// the latency of its expectation.
+type MockProcess struct {
+	expectation *Expectation
+	call        *Call
+	started     time.Time
+	done        chan struct{}
+	stop        chan syscall.Signal
+	closeOnce   sync.Once
+	tee         *nescript.Tee
+
+	// set by run, before done is closed.
+	result *nescript.Result
+	err    error
+}
+
+// start starts the process of the expectation, writing its output to the
+// mock's writers straight away.
+func (m *Mock) start(c nescript.Cmd, e *Expectation, call *Call) *MockProcess {
+	p := &MockProcess{
+		expectation: e,
+		call:        call,
+		started:     time.Now(),
+		done:        make(chan struct{}),
+		stop:        make(chan syscall.Signal, 1),
+		tee:         nescript.NewTee(c, nil, nil),
+	}
+	if m.stdout != nil && e.stdout != "" {
+		m.stdout.Write([]byte(e.stdout))
+	}
+	if m.stderr != nil && e.stderr != "" {
+		m.stderr.Write([]byte(e.stderr))
+	}
+	p.tee.WriteOutput(e.stdout, e.stderr)
+	go p.run(c)
+	return p
+}
+
+// run completes the process once the latency has elapsed, or earlier if it is
+// terminated by a signal or its cmd's context is done.
+func (p *MockProcess) run(c nescript.Cmd) {
+	defer close(p.done)
+	timer := time.NewTimer(p.expectation.latency)
+	defer timer.Stop()
+	result := &nescript.Result{
+		StdOut:   p.expectation.stdout,
+		StdErr:   p.expectation.stderr,
+		ExitCode: p.expectation.exitCode,
+	}
+	select {
+	case <-timer.C:
+	case sig := <-p.stop:
+		result.SetSignal(int(sig))
+	case <-c.Context().Done():
+		p.err = fmt.Errorf("%w: %w", ErrCancelled, nescript.ContextError(c.Context().Err()))
+		p.tee.Finish(nil)
+		return
+	}
+	result.SetTimes(p.started, time.Now())
+	result.SetMetadata(nescript.MetadataExecutor, "mock")
+	result.SetMetadata(MetadataExpectation, p.expectation.caller)
+	p.tee.Finish(result)
+	p.result = result
+}
+
+// Call returns the recorded call of the process.
+func (p *MockProcess) Call() *Call {
+	return p.call
+}
+
+// Kill terminates the process with a SIGKILL (see Signal), doing nothing once
+// it has exited.
+func (p *MockProcess) Kill() error {
+	select {
+	case <-p.done:
+		return nil
+	default:
+	}
+	return p.Signal(syscall.SIGKILL)
+}
+
+// Signal records the signal on the call. SIGKILL, SIGTERM, SIGINT and SIGHUP
+// terminate the process, its result recording the signal, while other signals
+// are only recorded.
+func (p *MockProcess) Signal(s os.Signal) error {
+	select {
+	case <-p.done:
+		return ErrExited
+	default:
+	}
+	p.call.mu.Lock()
+	p.call.signals = append(p.call.signals, s)
+	p.call.mu.Unlock()
+	sig, ok := s.(syscall.Signal)
+	if !ok {
+		return fmt.Errorf("can not send signal '%s' to mock process", s)
+	}
+	switch sig {
+	case syscall.SIGKILL, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP:
+		select {
+		case p.stop <- sig:
+		default:
+		}
+	}
+	return nil
+}
+
+// Write records the input as written to the process's stdin.
+func (p *MockProcess) Write(input string) error {
+	select {
+	case <-p.done:
+		return ErrExited
+	default:
+	}
+	p.call.mu.Lock()
+	defer p.call.mu.Unlock()
+	p.call.stdin = append(p.call.stdin, input...)
+	return nil
+}
+
+func (p *MockProcess) Stdin() io.WriteCloser {
+	return nescript.StdinWriter(p)
+}
+
+// Stdout streams the stdout of the expectation, written as soon as the process
+// starts.
+func (p *MockProcess) Stdout() io.Reader {
+	return p.tee.StdoutStream()
+}
+
+// Stderr streams the stderr of the expectation, written as soon as the process
+// starts.
+func (p *MockProcess) Stderr() io.Reader {
+	return p.tee.StderrStream()
+}
+
+// ID is empty, as a mock process has no identifier.
+func (p *MockProcess) ID() string {
+	return ""
+}
+
+func (p *MockProcess) String() string {
+	return fmt.Sprintf("mock of '%s' (expectation at %s)", p.call.Command, p.expectation.caller)
+}
+
+func (p *MockProcess) Wait(ctx context.Context) (*nescript.Result, error) {
+	return nescript.Wait(ctx, p)
+}
+
+func (p *MockProcess) Result() (*nescript.Result, error) {
+	<-p.done
+	if p.err != nil {
+		return nil, p.err
+	}
+	result := *p.result
+	return &result, nil
+}
+
+// Close kills the process if it is still running.
+func (p *MockProcess) Close() {
+	p.closeOnce.Do(func() {
+		select {
+		case <-p.done:
+		default:
+			p.Kill()
+			<-p.done
+		}
+	})
+}
@@ -0,0 +1,63 @@
+package mock_test
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/mock"
+	"github.com/neaas/nescript/processtest"
+)
+
+// lines is the output of the unread streams check of processtest.
+func lines() string {
+	var b strings.Builder
+	for i := range 2000 {
+		fmt.Fprintf(&b, "line %d of output that is not read\n", i)
+	}
+	return b.String()
+}
+
+func TestExecutor(t *testing.T) {
+	m := mock.New(t)
+	m.On(mock.Raw("echo out; echo err >&2")).Return("out\n", "err\n", 0)
+	m.On(mock.Raw("exit 3")).Return("", "", 3)
+	m.On(func(c nescript.Cmd) bool {
+		return mock.Command(c) == `echo "$NESCRIPT_PROCESSTEST"` && slices.Contains(c.Env(), "NESCRIPT_PROCESSTEST=value")
+	}).Return("value\n", "", 0)
+	m.On(mock.Raw(`read line; echo "got $line"`)).Return("got input\n", "", 0).Latency(500 * time.Millisecond)
+	m.On(mock.Regexp(`^i=0; while`)).Return(lines(), "", 0)
+	m.On(mock.Raw("sleep 600")).Latency(time.Hour)
+	m.On(mock.Raw("echo out")).Return("out\n", "", 0)
+	m.On(mock.Raw("true"))
+	if err := processtest.TestExecutor(m.Executor()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProcessRecordsStdin(t *testing.T) {
+	m := mock.New(t)
+	m.On(mock.Any()).Latency(time.Hour)
+	process, err := nescript.NewCmd("cat").Exec(m.Executor())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer process.Close()
+	process.Write("a")
+	process.Stdin().Write([]byte("b"))
+	if got := m.Calls()[0].Stdin(); got != "ab" {
+		t.Errorf("expected stdin 'ab', got '%s'", got)
+	}
+	if err := process.Kill(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := process.Result(); err != nil {
+		t.Fatal(err)
+	}
+	if err := process.Kill(); err != nil {
+		t.Errorf("expected kill once exited to do nothing, got: %v", err)
+	}
+}
@@ -0,0 +1,159 @@
+package nomad
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+)
+
+const (
+	// allocIDLength is the length of a full allocation ID, shorter IDs are
+	// resolved as prefixes (as with the nomad CLI).
+	allocIDLength = 36
+
+	statusRunning = "running"
+	desiredRun    = "run"
+)
+
+// Allocation is the part of a nomad allocation used to choose where scripts
+// are executed.
+type Allocation struct {
+	ID               string
+	Name             string
+	Namespace        string
+	JobID            string
+	JobVersion       uint64
+	TaskGroup        string
+	NodeID           string
+	NodeName         string
+	ClientStatus     string
+	DesiredStatus    string
+	TaskStates       map[string]TaskState
+	DeploymentStatus *DeploymentStatus
+	CreateIndex      uint64
+}
+
+// TaskState is the state of a task within an allocation.
+type TaskState struct {
+	State  string
+	Failed bool
+}
+
+// DeploymentStatus is the health of an allocation reported by its deployment.
+// Healthy is nil until the allocation's health has been determined.
+type DeploymentStatus struct {
+	Healthy *bool
+}
+
+// Running reports whether the allocation is running and should stay running,
+// with the task running in it.
+func (a *Allocation) Running(task string) bool {
+	state, ok := a.TaskStates[task]
+	return ok && a.ClientStatus == statusRunning && a.DesiredStatus == desiredRun && state.State == statusRunning
+}
+
+// Healthy reports whether the allocation is running the task, and is healthy.
+// Allocations not tracked by a deployment (such as those of batch jobs, or
+// jobs without an update block) are healthy when running, otherwise their
+// deployment must have marked them as healthy, so that allocations still
+// being rolled out (or found unhealthy) are not used.
+func (a *Allocation) Healthy(task string) bool {
+	if !a.Running(task) {
+		return false
+	}
+	if a.DeploymentStatus == nil {
+		return true
+	}
+	return a.DeploymentStatus.Healthy != nil && *a.DeploymentStatus.Healthy
+}
+
+// LatestHealthyAllocation resolves the allocation a script should be executed
+// in for the task of the job: the healthy allocation (see
+// Allocation.Healthy) of the job's latest version, preferring the most
+// recently created. ErrJobNotFound, ErrTaskNotFound (none of the job's
+// allocations have the task) or ErrNoHealthyAllocation are returned if there
+// is none. The address, token and namespace are as for JobExecutor.
+func LatestHealthyAllocation(ctx context.Context, address, job, task string, opts ...Option) (*Allocation, error) {
+	o := newOptions(opts)
+	client, err := o.newClient(address)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrConnection, err)
+	}
+	return o.latestHealthy(ctx, client, job, task)
+}
+
+func (o *options) latestHealthy(ctx context.Context, client *client, job, task string) (*Allocation, error) {
+	var allocs []*Allocation
+	if err := client.get(ctx, "/v1/job/"+url.PathEscape(job)+"/allocations", nil, &allocs); err != nil {
+		if errors.Is(err, errNotFound) {
+			return nil, fmt.Errorf("%w: '%s'", ErrJobNotFound, job)
+		}
+		return nil, fmt.Errorf("failed to list allocations of nomad job '%s': %w", job, err)
+	}
+	var withTask, running int
+	var healthy []*Allocation
+	for _, alloc := range allocs {
+		if o.taskGroup != "" && alloc.TaskGroup != o.taskGroup {
+			continue
+		}
+		// pending allocations have no task states yet.
+		if _, ok := alloc.TaskStates[task]; !ok && len(alloc.TaskStates) > 0 {
+			continue
+		}
+		withTask++
+		if alloc.Running(task) {
+			running++
+		}
+		if alloc.Healthy(task) {
+			healthy = append(healthy, alloc)
+		}
+	}
+	if withTask == 0 {
+		return nil, fmt.Errorf("%w: no allocation of job '%s' has task '%s'", ErrTaskNotFound, job, task)
+	}
+	if len(healthy) == 0 {
+		return nil, fmt.Errorf("%w: job '%s' task '%s' has %d allocations, %d running, none healthy", ErrNoHealthyAllocation, job, task, withTask, running)
+	}
+	sort.Slice(healthy, func(i, j int) bool {
+		if healthy[i].JobVersion != healthy[j].JobVersion {
+			return healthy[i].JobVersion > healthy[j].JobVersion
+		}
+		return healthy[i].CreateIndex > healthy[j].CreateIndex
+	})
+	return healthy[0], nil
+}
+
+// allocation fetches the allocation by its ID, or a unique prefix of it, and
+// ensures the task is running in it.
+func (o *options) allocation(ctx context.Context, client *client, id, task string) (*Allocation, error) {
+	if len(id) < allocIDLength {
+		var matches []*Allocation
+		if err := client.get(ctx, "/v1/allocations", url.Values{"prefix": {id}}, &matches); err != nil {
+			return nil, fmt.Errorf("failed to list nomad allocations: %w", err)
+		}
+		if len(matches) != 1 {
+			return nil, fmt.Errorf("%w: %d allocations match prefix '%s'", ErrAllocationNotFound, len(matches), id)
+		}
+		id = matches[0].ID
+	}
+	alloc := &Allocation{}
+	if err := client.get(ctx, "/v1/allocation/"+url.PathEscape(id), nil, alloc); err != nil {
+		if errors.Is(err, errNotFound) {
+			return nil, fmt.Errorf("%w: '%s'", ErrAllocationNotFound, id)
+		}
+		return nil, fmt.Errorf("failed to get nomad allocation '%s': %w", id, err)
+	}
+	state, ok := alloc.TaskStates[task]
+	if !ok && len(alloc.TaskStates) > 0 {
+		return nil, fmt.Errorf("%w: allocation '%s' has no task '%s'", ErrTaskNotFound, alloc.ID, task)
+	}
+	if !alloc.Running(task) {
+		if state.State == "" {
+			state.State = "pending"
+		}
+		return nil, fmt.Errorf("%w: allocation '%s' is %s, task '%s' is %s", ErrAllocationNotRunning, alloc.ID, alloc.ClientStatus, task, state.State)
+	}
+	return alloc, nil
+}
@@ -0,0 +1,115 @@
+package nomad
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// errNotFound is returned by the client for API responses with a 404 status,
+// for the caller to return the sentinel error for whatever was not found.
+var errNotFound = errors.New("not found")
+
+// client makes requests to the nomad HTTP API.
+type client struct {
+	address   *url.URL
+	token     string
+	namespace string
+	region    string
+	http      *http.Client
+	tls       *tls.Config
+}
+
+// newClient creates the client for the nomad API at the address, such as
+// http://127.0.0.1:4646. If the address is empty, NOMAD_ADDR is used, then
+// the default address of a local agent.
+func (o *options) newClient(address string) (*client, error) {
+	if address == "" {
+		address = os.Getenv("NOMAD_ADDR")
+	}
+	if address == "" {
+		address = defaultAddress
+	}
+	parsed, err := url.Parse(address)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return nil, fmt.Errorf("invalid nomad address '%s'", address)
+	}
+	c := &client{
+		address:   parsed,
+		token:     o.token,
+		namespace: o.namespace,
+		region:    o.region,
+		http:      o.httpClient,
+	}
+	if parsed.Scheme == "https" {
+		if c.tls, err = o.tlsConfig(); err != nil {
+			return nil, err
+		}
+	}
+	if c.http == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = c.tls
+		c.http = &http.Client{Transport: transport}
+	}
+	return c, nil
+}
+
+// url builds the URL of the API path, with the namespace and region set.
+func (c *client) url(scheme, path string, query url.Values) *url.URL {
+	if query == nil {
+		query = url.Values{}
+	}
+	if c.namespace != "" {
+		query.Set("namespace", c.namespace)
+	}
+	if c.region != "" {
+		query.Set("region", c.region)
+	}
+	u := *c.address
+	u.Scheme = scheme
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	u.RawQuery = query.Encode()
+	return &u
+}
+
+// get decodes the JSON response of the API path into v.
+func (c *client) get(ctx context.Context, path string, query url.Values, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url(c.address.Scheme, path, query).String(), nil)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrConnection, err)
+	}
+	if c.token != "" {
+		req.Header.Set("X-Nomad-Token", c.token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrConnection, err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			return fmt.Errorf("failed to decode nomad response: %w", err)
+		}
+		return nil
+	case http.StatusNotFound:
+		return errNotFound
+	case http.StatusForbidden:
+		return fmt.Errorf("%w: %s", ErrPermissionDenied, responseMessage(resp))
+	}
+	return fmt.Errorf("nomad api returned %s: %s", resp.Status, responseMessage(resp))
+}
+
+// responseMessage returns the (short, plain text) error message of a failed
+// API response.
+func responseMessage(resp *http.Response) string {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return strings.TrimSpace(string(body))
+}
@@ -0,0 +1,48 @@
+package nomad
+
+import (
+	"errors"
+
+	"github.com/neaas/nescript"
+)
+
+var (
+	// ErrConnection is returned (wrapped) when the nomad API could not be
+	// reached, as opposed to a failure once connected.
+	ErrConnection = nescript.NewError(nescript.ErrConnection, "failed to connect to nomad")
+
+	// ErrPermissionDenied is returned (wrapped) when the nomad API rejects the
+	// token (see WithToken), or the token's policy does not allow alloc exec.
+	ErrPermissionDenied = errors.New("nomad permission denied")
+
+	// ErrJobNotFound is returned (wrapped) when the job an allocation should be
+	// resolved for does not exist.
+	ErrJobNotFound = errors.New("nomad job not found")
+
+	// ErrAllocationNotFound is returned (wrapped) when no allocation matches
+	// the allocation ID (or prefix) given, or more than one does.
+	ErrAllocationNotFound = errors.New("nomad allocation not found")
+
+	// ErrAllocationNotRunning is returned (wrapped) when the allocation, or the
+	// task within it, is not running, such as when it is still pending or has
+	// completed.
+	ErrAllocationNotRunning = errors.New("nomad allocation is not running")
+
+	// ErrNoHealthyAllocation is returned (wrapped) when resolving the
+	// allocation of a job's task and none of its allocations are running and
+	// healthy.
+	ErrNoHealthyAllocation = errors.New("no healthy nomad allocation")
+
+	// ErrTaskNotFound is returned (wrapped) when the allocation (or none of the
+	// job's allocations) has the task the script should be executed in.
+	ErrTaskNotFound = errors.New("nomad task not found")
+
+	// ErrExecution is returned (wrapped) when the script could not be started
+	// in the task, or its exit code was lost.
+	ErrExecution = errors.New("nomad exec failed")
+
+	// ErrSignalUnsupported is returned when a signal other than SIGTERM or
+	// SIGKILL is sent to a nomad exec process, as alloc exec can only end the
+	// exec session.
+	ErrSignalUnsupported = errors.New("signal not supported by nomad exec")
+)
@@ -0,0 +1,111 @@
+package nomad
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/neaas/nescript"
+	"golang.org/x/net/websocket"
+)
+
+// AllocExecutor provides an ExecFunc that runs the script/cmd in the task of
+// a running nomad allocation, as `nomad alloc exec` does, through the nomad
+// API at the address (such as http://127.0.0.1:4646, or NOMAD_ADDR if empty).
+// The allocation may be given by its full ID or a unique prefix of it, and is
+// checked to be running the task (see ErrAllocationNotRunning and
+// ErrTaskNotFound) before each execution. This ExecFunc does not require that
+// the cmd/script be converted to a string, so is Formatter agnostic.
+func AllocExecutor(address, allocID, task string, opts ...Option) nescript.ExecFunc {
+	o := newOptions(opts)
+	client, err := o.newClient(address)
+	return func(c nescript.Cmd) (nescript.Process, error) {
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrConnection, err)
+		}
+		alloc, err := o.allocation(c.Context(), client, allocID, task)
+		if err != nil {
+			return nil, err
+		}
+		return o.exec(c, client, alloc, task)
+	}
+}
+
+// JobExecutor provides an ExecFunc that runs the script/cmd in the task of
+// the job's latest healthy allocation (see LatestHealthyAllocation), which is
+// resolved again for each execution, so that scripts follow the job as it is
+// redeployed. Otherwise, this is as AllocExecutor.
+func JobExecutor(address, job, task string, opts ...Option) nescript.ExecFunc {
+	o := newOptions(opts)
+	client, err := o.newClient(address)
+	return func(c nescript.Cmd) (nescript.Process, error) {
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrConnection, err)
+		}
+		alloc, err := o.latestHealthy(c.Context(), client, job, task)
+		if err != nil {
+			return nil, err
+		}
+		return o.exec(c, client, alloc, task)
+	}
+}
+
+// exec opens the alloc exec stream running the cmd in the task.
+func (o *options) exec(c nescript.Cmd, client *client, alloc *Allocation, task string) (nescript.Process, error) {
+	command, err := json.Marshal(command(c, o.shell))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode command: %w", err)
+	}
+	scheme := "ws"
+	if client.address.Scheme == "https" {
+		scheme = "wss"
+	}
+	query := url.Values{
+		"task":    {task},
+		"tty":     {"false"},
+		"command": {string(command)},
+	}
+	target := client.url(scheme, "/v1/client/allocation/"+url.PathEscape(alloc.ID)+"/exec", query)
+	config, err := websocket.NewConfig(target.String(), client.address.String())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrConnection, err)
+	}
+	config.TlsConfig = client.tls
+	if client.token != "" {
+		config.Header.Set("X-Nomad-Token", client.token)
+	}
+	conn, err := config.DialContext(c.Context())
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to open exec stream to allocation '%s' task '%s': %w", ErrExecution, alloc.ID, task, err)
+	}
+	ctx, cancel := context.WithCancel(c.Context())
+	process := &NomadProcess{
+		conn:    conn,
+		alloc:   alloc,
+		task:    task,
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		started: time.Now(),
+	}
+	process.tee = nescript.NewTee(c, &process.stdout, &process.stderr)
+	go process.receive()
+	go process.heartbeat()
+	return process, nil
+}
+
+// command returns the command run in the task. Cmds created from a script are
+// invoked with the shell, if given. As alloc exec can not set env vars, any
+// set on the cmd are set by running the command with env.
+func command(c nescript.Cmd, shell nescript.Subcommand) []string {
+	command := c.Raw()
+	if _, script, trailing, ok := c.Script(); ok && shell != nil {
+		command = append(append(append([]string{}, shell...), script), trailing...)
+	}
+	if env := c.Env(); len(env) > 0 {
+		command = append(append([]string{"env"}, env...), command...)
+	}
+	return command
+}
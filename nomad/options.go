@@ -0,0 +1,133 @@
+package nomad
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/neaas/nescript"
+)
+
+// defaultAddress is the address of the nomad API used if none is given, and
+// NOMAD_ADDR is not set.
+const defaultAddress = "http://127.0.0.1:4646"
+
+// Option configures the nomad executors.
+type Option func(*options)
+
+type options struct {
+	token      string
+	namespace  string
+	region     string
+	taskGroup  string
+	shell      nescript.Subcommand
+	ca         []byte
+	skipVerify bool
+	httpClient *http.Client
+}
+
+// newOptions creates the options, defaulting the token, namespace and region
+// to NOMAD_TOKEN, NOMAD_NAMESPACE and NOMAD_REGION as the nomad CLI does.
+func newOptions(opts []Option) *options {
+	o := &options{
+		token:     os.Getenv("NOMAD_TOKEN"),
+		namespace: os.Getenv("NOMAD_NAMESPACE"),
+		region:    os.Getenv("NOMAD_REGION"),
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithToken sets the ACL token sent to the nomad API, which needs the
+// alloc-exec capability in the job's namespace. NOMAD_TOKEN is used unless
+// given.
+func WithToken(token string) Option {
+	return func(o *options) {
+		o.token = token
+	}
+}
+
+// WithNamespace sets the namespace of the job or allocation. NOMAD_NAMESPACE
+// is used unless given, otherwise nomad's default namespace.
+func WithNamespace(namespace string) Option {
+	return func(o *options) {
+		o.namespace = namespace
+	}
+}
+
+// WithRegion sets the region requests are forwarded to. NOMAD_REGION is used
+// unless given, otherwise the region of the agent at the address.
+func WithRegion(region string) Option {
+	return func(o *options) {
+		o.region = region
+	}
+}
+
+// WithTaskGroup only considers the job's allocations of the task group when
+// resolving the allocation to execute in, for jobs with the same task name in
+// more than one group.
+func WithTaskGroup(group string) Option {
+	return func(o *options) {
+		o.taskGroup = group
+	}
+}
+
+// WithShell sets the shell scripts are invoked with in the task, such as
+// nescript.SCBash, rather than the cmd's subcommand. Cmds not created from a
+// script are unaffected.
+func WithShell(shell nescript.Subcommand) Option {
+	return func(o *options) {
+		o.shell = shell
+	}
+}
+
+// WithCACert verifies the nomad API's certificate (for https addresses)
+// against the given PEM encoded CA certificate(s), rather than the system
+// roots.
+func WithCACert(pem []byte) Option {
+	return func(o *options) {
+		o.ca = pem
+	}
+}
+
+// WithInsecureSkipVerify connects to https addresses without verifying the
+// nomad API's certificate. This should only be used on trusted networks.
+func WithInsecureSkipVerify() Option {
+	return func(o *options) {
+		o.skipVerify = true
+	}
+}
+
+// WithHTTPClient sets the client used for nomad API requests, such as one
+// configured with client certificates for mTLS. Its TLS config (if it uses an
+// *http.Transport) is also used for the exec websocket.
+func WithHTTPClient(client *http.Client) Option {
+	return func(o *options) {
+		o.httpClient = client
+	}
+}
+
+// tlsConfig builds the TLS config for https addresses.
+func (o *options) tlsConfig() (*tls.Config, error) {
+	if o.httpClient != nil {
+		if transport, ok := o.httpClient.Transport.(*http.Transport); ok && transport.TLSClientConfig != nil {
+			return transport.TLSClientConfig.Clone(), nil
+		}
+	}
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: o.skipVerify,
+	}
+	if o.ca != nil {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(o.ca) {
+			return nil, fmt.Errorf("failed to parse tls ca certificate")
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
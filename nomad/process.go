@@ -0,0 +1,206 @@
+package nomad
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/neaas/nescript"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// MetadataAllocation is the result metadata key holding the ID of the
+	// allocation the script was executed in.
+	MetadataAllocation = "nomad.alloc"
+
+	// MetadataNode is the result metadata key holding the name of the node the
+	// allocation is running on.
+	MetadataNode = "nomad.node"
+
+	// heartbeatInterval is how often an empty frame is sent on the exec stream,
+	// so that it is not closed as idle while the script produces no output.
+	heartbeatInterval = 10 * time.Second
+)
+
+// execInput is a frame sent on the alloc exec stream.
+type execInput struct {
+	Stdin *execIO `json:"stdin,omitempty"`
+}
+
+// execOutput is a frame received on the alloc exec stream.
+type execOutput struct {
+	Stdout *execIO `json:"stdout,omitempty"`
+	Stderr *execIO `json:"stderr,omitempty"`
+	Exited bool    `json:"exited,omitempty"`
+	Result *struct {
+		ExitCode int `json:"exit_code"`
+	} `json:"result,omitempty"`
+}
+
+type execIO struct {
+	Data  []byte `json:"data,omitempty"`
+	Close bool   `json:"close,omitempty"`
+}
+
+// NomadProcess represents a single instance of the script running or
+// completed in a nomad allocation's task.
+type NomadProcess struct {
+	conn      *websocket.Conn
+	alloc     *Allocation
+	task      string
+	ctx       context.Context
+	cancel    context.CancelFunc
+	done      chan struct{}
+	closeOnce sync.Once
+	started   time.Time
+	tee       *nescript.Tee
+
+	// set by receive, before done is closed.
+	stdout   bytes.Buffer
+	stderr   bytes.Buffer
+	exitCode int
+	ended    time.Time
+	err      error
+}
+
+// Allocation returns the allocation the script is executing in.
+func (p *NomadProcess) Allocation() *Allocation {
+	return p.alloc
+}
+
+// receive collects the script's output until the stream reports its exit.
+func (p *NomadProcess) receive() {
+	defer close(p.done)
+	defer p.tee.Finish(nil)
+	defer p.cancel()
+	for {
+		var frame execOutput
+		if err := websocket.JSON.Receive(p.conn, &frame); err != nil {
+			if p.ctx.Err() != nil {
+				err = nescript.ContextError(p.ctx.Err())
+			}
+			p.err = fmt.Errorf("%w: exec stream closed before the script exited: %w", ErrExecution, err)
+			return
+		}
+		if frame.Stdout != nil {
+			p.tee.Stdout().Write(frame.Stdout.Data)
+		}
+		if frame.Stderr != nil {
+			p.tee.Stderr().Write(frame.Stderr.Data)
+		}
+		if frame.Exited {
+			p.ended = time.Now()
+			if frame.Result != nil {
+				p.exitCode = frame.Result.ExitCode
+			}
+			return
+		}
+	}
+}
+
+// heartbeat keeps the stream open until the context is done, which (as the
+// cmd's context or closing the process) closes the stream, ending the exec.
+func (p *NomadProcess) heartbeat() {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.ctx.Done():
+			p.conn.Close()
+			return
+		case <-ticker.C:
+			websocket.JSON.Send(p.conn, execInput{})
+		}
+	}
+}
+
+// Kill ends the exec session, which kills the script.
+func (p *NomadProcess) Kill() error {
+	p.cancel()
+	return nil
+}
+
+// Signal ends the exec session for SIGTERM and SIGKILL. Other signals return
+// ErrSignalUnsupported.
+func (p *NomadProcess) Signal(s os.Signal) error {
+	switch s {
+	case syscall.SIGTERM, os.Kill, syscall.SIGKILL:
+		return p.Kill()
+	}
+	return fmt.Errorf("%w: %s", ErrSignalUnsupported, s)
+}
+
+func (p *NomadProcess) Write(input string) error {
+	if err := websocket.JSON.Send(p.conn, execInput{Stdin: &execIO{Data: []byte(input)}}); err != nil {
+		return fmt.Errorf("failed to write to nomad exec stdin: %w", err)
+	}
+	return nil
+}
+
+// CloseStdin closes the script's stdin, for scripts that read it until EOF.
+func (p *NomadProcess) CloseStdin() error {
+	var err error
+	p.closeOnce.Do(func() {
+		err = websocket.JSON.Send(p.conn, execInput{Stdin: &execIO{Close: true}})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to close nomad exec stdin: %w", err)
+	}
+	return nil
+}
+
+func (p *NomadProcess) Stdin() io.WriteCloser {
+	return nescript.StdinWriter(p)
+}
+
+func (p *NomadProcess) Stdout() io.Reader {
+	return p.tee.StdoutStream()
+}
+
+func (p *NomadProcess) Stderr() io.Reader {
+	return p.tee.StderrStream()
+}
+
+// ID returns the ID of the allocation the script is running in.
+func (p *NomadProcess) ID() string {
+	return p.alloc.ID
+}
+
+func (p *NomadProcess) String() string {
+	return fmt.Sprintf("nomad task %s of allocation %s", p.task, p.alloc.ID)
+}
+
+func (p *NomadProcess) Wait(ctx context.Context) (*nescript.Result, error) {
+	return nescript.Wait(ctx, p)
+}
+
+func (p *NomadProcess) Result() (*nescript.Result, error) {
+	defer p.Close()
+	<-p.done
+	if p.err != nil {
+		return nil, p.err
+	}
+	result := nescript.Result{
+		StdOut:   p.stdout.String(),
+		StdErr:   p.stderr.String(),
+		ExitCode: p.exitCode,
+	}
+	result.SetTimes(p.started, p.ended)
+	result.SetMetadata(nescript.MetadataExecutor, "nomad")
+	result.SetMetadata(MetadataAllocation, p.alloc.ID)
+	result.SetMetadata(MetadataNode, p.alloc.NodeName)
+	p.tee.Finish(&result)
+	return &result, nil
+}
+
+// Close closes the exec stream, killing the script if it is still running.
+func (p *NomadProcess) Close() {
+	p.cancel()
+	<-p.done
+}
@@ -0,0 +1,181 @@
+package nescript
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Normalizer post-processes the output of a script, such as removing the
+// escape sequences of a colored CLI.
+type Normalizer func(output string) string
+
+// ansiRegex matches ANSI escape sequences: CSI sequences (such as colors and
+// cursor movement), OSC sequences (such as window titles and hyperlinks) and
+// other two byte escapes.
+var ansiRegex = regexp.MustCompile(`\x1b\[[0-?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)|\x1b[@-Z\\-_]`)
+
+// StripANSI removes ANSI escape sequences from the output, such as the colors
+// of CLIs that do not check whether they are writing to a terminal.
+func StripANSI(output string) string {
+	return ansiRegex.ReplaceAllString(output, "")
+}
+
+// NormalizeNewlines converts windows (CRLF) line endings into newlines. A bare
+// carriage return is left as it is (see CollapseCarriageReturns).
+func NormalizeNewlines(output string) string {
+	return strings.ReplaceAll(output, "\r\n", "\n")
+}
+
+// CollapseCarriageReturns keeps only the final state of lines overwritten by
+// returning to their start with a carriage return, as a terminal would show
+// them, such as the progress bars of docker pull or pip install. Text written
+// after a carriage return overwrites the line from its start, any longer text
+// before it remaining. A CRLF line ending is kept as a newline. Escape
+// sequences should be stripped first (see StripANSI), as these are not
+// interpreted.
+func CollapseCarriageReturns(output string) string {
+	if !strings.Contains(output, "\r") {
+		return output
+	}
+	lines := strings.Split(output, "\n")
+	for i, line := range lines {
+		if !strings.Contains(line, "\r") {
+			continue
+		}
+		var state []rune
+		column := 0
+		for _, r := range line {
+			if r == '\r' {
+				column = 0
+				continue
+			}
+			if column < len(state) {
+				state[column] = r
+			} else {
+				state = append(state, r)
+			}
+			column++
+		}
+		lines[i] = string(state)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Normalize applies the normalizers in order to the output.
+func Normalize(output string, normalizers ...Normalizer) string {
+	for _, normalizer := range normalizers {
+		output = normalizer(output)
+	}
+	return output
+}
+
+// Normalized returns a copy of the result with the normalizers applied in order
+// to its stdOut and stdErr. For example, to clean up the output of a colored
+// CLI showing progress bars:
+//
+//	result = result.Normalized(nescript.StripANSI, nescript.CollapseCarriageReturns)
+func (r Result) Normalized(normalizers ...Normalizer) Result {
+	r.StdOut = Normalize(r.StdOut, normalizers...)
+	r.StdErr = Normalize(r.StdErr, normalizers...)
+	return r
+}
+
+// NormalizingWriter applies normalizers to each line written to it, before
+// writing the line to the underlying writer, so that streamed output is
+// normalized as the result is (see Normalizing). Lines are written once their
+// line ending is, the normalizers being given each line along with its ending,
+// so a final line without one is only written by Flush.
+type NormalizingWriter struct {
+	mu        sync.Mutex
+	w         io.Writer
+	normalize []Normalizer
+	partial   []byte
+}
+
+// NewNormalizingWriter returns a writer applying the normalizers in order to
+// each line written to it, before writing the line to w.
+func NewNormalizingWriter(w io.Writer, normalizers ...Normalizer) *NormalizingWriter {
+	return &NormalizingWriter{w: w, normalize: normalizers}
+}
+
+func (w *NormalizingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	n := len(p)
+	for {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			break
+		}
+		line := p[:i+1]
+		if len(w.partial) > 0 {
+			line = append(w.partial, line...)
+			w.partial = w.partial[:0]
+		}
+		if _, err := io.WriteString(w.w, Normalize(string(line), w.normalize...)); err != nil {
+			return n - len(p), err
+		}
+		p = p[i+1:]
+	}
+	w.partial = append(w.partial, p...)
+	return n, nil
+}
+
+// Flush writes the final line, if the output did not end with a line ending.
+// It must only be called once the output has been fully written.
+func (w *NormalizingWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.partial) == 0 {
+		return nil
+	}
+	line := string(w.partial)
+	w.partial = nil
+	_, err := io.WriteString(w.w, Normalize(line, w.normalize...))
+	return err
+}
+
+// Normalizing wraps the executor so that the normalizers are applied in order to
+// the output of each result (see Result.Normalized), and to each line teed to
+// the cmd's writers (see Cmd.WithStdoutWriter and NormalizingWriter), the final
+// line being written once the result is collected or the process is closed.
+// The output read from the process's Stdout and Stderr is as it is.
+func Normalizing(executor ExecFunc, normalizers ...Normalizer) ExecFunc {
+	return func(c Cmd) (Process, error) {
+		var writers []*NormalizingWriter
+		stdout, stderr := c.OutputWriters()
+		if stdout != nil {
+			writers = append(writers, NewNormalizingWriter(stdout, normalizers...))
+			c = c.WithStdoutWriter(writers[len(writers)-1])
+		}
+		if stderr != nil {
+			writers = append(writers, NewNormalizingWriter(stderr, normalizers...))
+			c = c.WithStderrWriter(writers[len(writers)-1])
+		}
+		process, err := executor(c)
+		if err != nil {
+			return nil, err
+		}
+		var flushed sync.Once
+		flush := func() {
+			flushed.Do(func() {
+				for _, w := range writers {
+					w.Flush()
+				}
+			})
+		}
+		return WrapProcess(process, ProcessHooks{
+			Result: func(result *Result, err error) (*Result, error) {
+				flush()
+				if result != nil {
+					*result = result.Normalized(normalizers...)
+				}
+				return result, err
+			},
+			Close: flush,
+		}), nil
+	}
+}
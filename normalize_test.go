@@ -0,0 +1,108 @@
+package nescript_test
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/local"
+)
+
+// normalizerCases are output captured from colored CLIs and progress bars,
+// along with the output once normalized with StripANSI, NormalizeNewlines and
+// CollapseCarriageReturns.
+var normalizerCases = []struct {
+	name, output, normalized string
+}{
+	{
+		name:       "docker pull",
+		output:     "latest: Pulling from library/alpine\r\n\x1b[1A\x1b[2K\r4abcf2066143: Downloading  1.049MB/3.408MB\r\x1b[1A\x1b[2K\r4abcf2066143: Pull complete \x1b[1B\r\nDigest: sha256:c5b1261d\r\n",
+		normalized: "latest: Pulling from library/alpine\n4abcf2066143: Pull complete .049MB/3.408MB\nDigest: sha256:c5b1261d\n",
+	},
+	{
+		name:       "pip install",
+		output:     "Collecting requests\n   \x1b[90m━━━━━━━━━━\x1b[0m \x1b[32m0.0/64.9 kB\x1b[0m \x1b[31m?\x1b[0m eta \x1b[36m-:--:--\x1b[0m\r   \x1b[38;5;70m━━━━━━━━━━\x1b[0m \x1b[32m64.9/64.9 kB\x1b[0m \x1b[31m3.1 MB/s\x1b[0m eta \x1b[36m0:00:00\x1b[0m\nInstalling collected packages: requests\n",
+		normalized: "Collecting requests\n   ━━━━━━━━━━ 64.9/64.9 kB 3.1 MB/s eta 0:00:00\nInstalling collected packages: requests\n",
+	},
+	{
+		name:       "osc hyperlink",
+		output:     "see \x1b]8;;https://example.com\x1b\\docs\x1b]8;;\x1b\\\n",
+		normalized: "see docs\n",
+	},
+	{
+		name:       "plain",
+		output:     "no escapes\nhere",
+		normalized: "no escapes\nhere",
+	},
+}
+
+var normalizers = []nescript.Normalizer{nescript.StripANSI, nescript.NormalizeNewlines, nescript.CollapseCarriageReturns}
+
+func TestNormalize(t *testing.T) {
+	for _, tc := range normalizerCases {
+		if normalized := nescript.Normalize(tc.output, normalizers...); normalized != tc.normalized {
+			t.Errorf("%s: expected %q, got %q", tc.name, tc.normalized, normalized)
+		}
+	}
+}
+
+func TestNormalizingWriter(t *testing.T) {
+	for _, tc := range normalizerCases {
+		for _, size := range []int{1, 7, len(tc.output)} {
+			var written strings.Builder
+			w := nescript.NewNormalizingWriter(&written, normalizers...)
+			for output := tc.output; len(output) > 0; {
+				n := min(size, len(output))
+				w.Write([]byte(output[:n]))
+				output = output[n:]
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if written.String() != tc.normalized {
+				t.Errorf("%s, writes of %d: expected %q, got %q", tc.name, size, tc.normalized, written.String())
+			}
+		}
+	}
+}
+
+// lockedBuilder is a strings.Builder safe for concurrent use.
+type lockedBuilder struct {
+	mu sync.Mutex
+	b  strings.Builder
+}
+
+func (b *lockedBuilder) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *lockedBuilder) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.String()
+}
+
+func TestNormalizingStreamsAsResult(t *testing.T) {
+	var stdout, stderr lockedBuilder
+	c := nescript.NewScript(`printf '\033[31mred\033[0m\r\nprogress 1\rprogress 2\n'; printf 'no newline \033[1mbold' >&2`).Cmd().
+		WithStdoutWriter(&stdout).
+		WithStderrWriter(&stderr)
+	process, err := c.Exec(nescript.Normalizing(local.Executor(""), normalizers...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer process.Close()
+	result, err := process.Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.StdOut != "red\nprogress 2\n" || stdout.String() != result.StdOut {
+		t.Errorf("expected the streamed stdout normalized as the result's, got %q and %q", stdout.String(), result.StdOut)
+	}
+	if result.StdErr != "no newline bold" || stderr.String() != result.StdErr {
+		t.Errorf("expected the final line streamed once collected, got %q and %q", stderr.String(), result.StdErr)
+	}
+}
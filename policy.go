@@ -0,0 +1,148 @@
+package nescript
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// MetadataPolicyViolations is the result metadata key holding the policy
+// violations of a script executed by an executor wrapped with Auditing.
+const MetadataPolicyViolations = "nescript.policyViolations"
+
+// PolicyFunc evaluates a compiled script before it is executed (see Enforcing
+// and Auditing), returning an error if it violates the policy, ideally a
+// *PolicyError naming the rule and where the script violates it.
+type PolicyFunc func(compiled Script) error
+
+// DenyPattern is a rule denying scripts with content matching the pattern
+// (see Deny).
+type DenyPattern struct {
+	Rule    string
+	Pattern *regexp.Regexp
+}
+
+// DefaultDenyPatterns are rules denying commonly destructive or unsafe
+// commands: recursively removing the root dir, making a filesystem, piping a
+// download into a shell, writing to a disk device with dd, and a fork bomb.
+var DefaultDenyPatterns = []DenyPattern{
+	{Rule: "rm-root", Pattern: regexp.MustCompile(`\brm\s+(?:-\S+\s+)*(?:-[a-zA-Z]*[rR][a-zA-Z]*|--recursive)\s+(?:-\S+\s+)*['"]?/\*?['"]?(?:$|[\s;&|)])|--no-preserve-root`)},
+	{Rule: "mkfs", Pattern: regexp.MustCompile(`\bmkfs(?:\.\w+)?\b`)},
+	{Rule: "pipe-to-shell", Pattern: regexp.MustCompile(`\b(?:curl|wget)\b(?:[^|\n]|\\\n)*\|\s*(?:sudo\s+)?(?:ba|da|k|z)?sh\b`)},
+	{Rule: "dd-device", Pattern: regexp.MustCompile(`\bdd\b[^\n]*\bof=/dev/(?:sd|hd|vd|xvd|nvme|disk|mmcblk)`)},
+	{Rule: "fork-bomb", Pattern: regexp.MustCompile(`:\(\)\s*\{\s*:\s*\|\s*:\s*&\s*\}\s*;\s*:`)},
+}
+
+// Deny provides a policy denying scripts matching any of the patterns (or
+// DefaultDenyPatterns, if none are given). Patterns are matched against the
+// whole script, so may match across lines, with the violation citing the line
+// the match starts on.
+func Deny(patterns ...DenyPattern) PolicyFunc {
+	if len(patterns) == 0 {
+		patterns = DefaultDenyPatterns
+	}
+	return func(compiled Script) error {
+		for _, pattern := range patterns {
+			if loc := pattern.Pattern.FindStringIndex(compiled.raw); loc != nil {
+				line, text := lineAt(compiled.raw, loc[0])
+				return &PolicyError{Rule: pattern.Rule, Line: line, Snippet: snippet(text), Reason: fmt.Sprintf("matches the denied pattern '%s'", pattern.Pattern)}
+			}
+		}
+		return nil
+	}
+}
+
+// MaxSize provides a policy denying scripts larger than the number of bytes.
+func MaxSize(bytes int) PolicyFunc {
+	return func(compiled Script) error {
+		if len(compiled.raw) > bytes {
+			return &PolicyError{Rule: "max-size", Reason: fmt.Sprintf("the script is %d bytes, more than %d", len(compiled.raw), bytes)}
+		}
+		return nil
+	}
+}
+
+// RequireShebang provides a policy denying scripts that do not start with a
+// shebang (such as #!/bin/bash), for executors running scripts as files.
+func RequireShebang() PolicyFunc {
+	return func(compiled Script) error {
+		if !strings.HasPrefix(compiled.raw, "#!") {
+			line, text := lineAt(compiled.raw, 0)
+			return &PolicyError{Rule: "shebang", Line: line, Snippet: snippet(text), Reason: "the script does not start with a shebang"}
+		}
+		return nil
+	}
+}
+
+// lineAt returns the number (from 1) and text of the line at the offset.
+func lineAt(raw string, offset int) (int, string) {
+	start := strings.LastIndexByte(raw[:offset], '\n') + 1
+	end := strings.IndexByte(raw[offset:], '\n')
+	if end < 0 {
+		end = len(raw)
+	} else {
+		end += offset
+	}
+	return strings.Count(raw[:offset], "\n") + 1, strings.TrimSpace(raw[start:end])
+}
+
+// checkPolicies evaluates the script of the cmd, as it is executed, against
+// the policies, returning each violation.
+func checkPolicies(c Cmd, policies []PolicyFunc) []*PolicyError {
+	subcommand, raw, _, ok := c.Script()
+	if !ok {
+		subcommand, raw = nil, strings.Join(c.Raw(), " ")
+	}
+	compiled := Script{raw: raw, subcommand: subcommand, dynamicData: c.dynamicData}
+	var violations []*PolicyError
+	for _, policy := range policies {
+		if err := policy(compiled); err != nil {
+			var policyErr *PolicyError
+			if !errors.As(err, &policyErr) {
+				policyErr = &PolicyError{Rule: "custom", Reason: err.Error()}
+			}
+			violations = append(violations, policyErr)
+		}
+	}
+	return violations
+}
+
+// Enforcing wraps the executor so that each script is evaluated against the
+// policies before it is executed, refusing to execute it if any is violated by
+// returning the *PolicyError of the first violation. Scripts are evaluated as
+// they are executed, after compiling (see CompileExec), so that template data
+// can not smuggle content past the policies. Cmds not created from a script
+// are evaluated as their args joined by spaces. For example:
+//
+//	executor = nescript.Enforcing(executor, nescript.Deny(), nescript.MaxSize(64<<10))
+func Enforcing(executor ExecFunc, policies ...PolicyFunc) ExecFunc {
+	return func(c Cmd) (Process, error) {
+		if violations := checkPolicies(c, policies); len(violations) > 0 {
+			return nil, violations[0]
+		}
+		return executor(c)
+	}
+}
+
+// Auditing wraps the executor so that each script is evaluated against the
+// policies as Enforcing does, however is executed whether any are violated or
+// not, with the violations recorded on the result (see
+// Result.PolicyViolations), such as while rolling out a policy.
+func Auditing(executor ExecFunc, policies ...PolicyFunc) ExecFunc {
+	return func(c Cmd) (Process, error) {
+		violations := checkPolicies(c, policies)
+		process, err := executor(c)
+		if err != nil || len(violations) == 0 {
+			return process, err
+		}
+		return withMetadata(process, MetadataPolicyViolations, violations), nil
+	}
+}
+
+// PolicyViolations returns the policy violations of the script, if it was
+// executed by an executor wrapped with Auditing.
+func (r Result) PolicyViolations() []*PolicyError {
+	violations, _ := r.Metadata[MetadataPolicyViolations].([]*PolicyError)
+	return violations
+}
@@ -1,13 +1,36 @@
 package nescript
 
-import "os"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
 
 // Process is a single instance of the script, either running or exited. A
 // process can be used to control the script and extract results from a script
 // that has completed its execution.
+//
+// Executors provide processes to the same contract, so that callers (and
+// wrapping executors, such as Fallback) need not know how the script is run:
+//   - Result or Wait should be called once, collecting the result. Guard wraps
+//     a process so that they may be called any number of times.
+//   - The output of the script is collected by the process whether or not it is
+//     read while running, so the script never blocks on a full output, nor on
+//     Stdout and Stderr being read slowly or not at all.
+//   - Kill once the script has exited does nothing, returning nil, and does not
+//     stop Result from returning. Signal and Write may return an error once the
+//     script has exited.
+//   - Close may be called whether or not the result has been collected, and
+//     more than once.
+//
+// The processtest package checks an executor keeps to this contract.
 type Process interface {
 	// Kill sends a SIGKILL to the running process. If this fails, for example if
-	// the process is not running, this will return an error.
+	// the process could not be reached, this will return an error. Once the
+	// script has exited, Kill does nothing.
 	Kill() error
 
 	// Signal sends a signal (such as SIGINT) to the running process. If this
@@ -21,11 +44,389 @@ type Process interface {
 	// an error is returned.
 	Write(string) error
 
+	// Stdin returns a writer to the process's STDIN, writing as Write does, and
+	// closing STDIN when closed where the process is a StdinCloser (see
+	// StdinWriter).
+	Stdin() io.WriteCloser
+
+	// Stdout returns a reader of the script's stdout, starting with that
+	// written so far then as it is written, which returns io.EOF once the script
+	// has exited and the output is collected. The output is held until read, so
+	// the script is never blocked by a slow reader.
+	Stdout() io.Reader
+
+	// Stderr returns a reader of the script's stderr as it is written (see
+	// Stdout).
+	Stderr() io.Reader
+
 	// Result waits for a script to complete execution, then a result is returned.
 	// If the script returns an unknown error, this will also error.
 	Result() (*Result, error)
 
+	// Wait waits for a script to complete execution, as Result does, unless the
+	// context is done first, in which case the script is killed and an error
+	// matching ErrTimeout or ErrCancelled is returned (see the Wait function).
+	Wait(ctx context.Context) (*Result, error)
+
+	// ID returns the identifier of the script's process on its target, such as
+	// the PID of a local process or the ID of a docker exec, which is empty
+	// where the target has none.
+	ID() string
+
+	// String describes the process and its target, for logs and errors, such as
+	// "local pid 1234".
+	String() string
+
 	// Close should be called on a process, freeing any resources used where
 	// appropriate.
 	Close()
 }
+
+// StdinCloser is implemented by processes able to close the script's stdin, so
+// that a script reading it to the end (such as `cat`) can complete.
+type StdinCloser interface {
+	CloseStdin() error
+}
+
+// StdinWriter returns a writer to the stdin of the process, for executors to
+// provide as the process's Stdin. Writes are written with Write, and closing
+// the writer closes stdin where the process is a StdinCloser, otherwise
+// returning an error wrapping errors.ErrUnsupported.
+func StdinWriter(p Process) io.WriteCloser {
+	return stdinWriter{p}
+}
+
+// stdinWriter is the writer provided by StdinWriter.
+type stdinWriter struct {
+	p Process
+}
+
+func (w stdinWriter) Write(b []byte) (int, error) {
+	if err := w.p.Write(string(b)); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (w stdinWriter) Close() error {
+	if closer, ok := w.p.(StdinCloser); ok {
+		return closer.CloseStdin()
+	}
+	return fmt.Errorf("%w: can not close the stdin of %s", errors.ErrUnsupported, w.p)
+}
+
+// Poller is implemented by processes whose result can be checked for without
+// blocking, or waited for until a context is done while leaving the script
+// running, such as those of the local, docker and ssh executors. Any number of
+// callers may wait concurrently, each receiving the same result. Guard provides
+// a Poller for any process.
+type Poller interface {
+	// Poll returns the result and true if the script has exited, otherwise it
+	// returns immediately with false. If collecting the result failed, Poll
+	// returns nil and true, with Result returning the error.
+	Poll() (*Result, bool)
+
+	// WaitContext collects the result, as Result does, unless the context is
+	// done first, in which case an error wrapping ErrStillRunning is returned
+	// and the script is left running.
+	WaitContext(ctx context.Context) (*Result, error)
+}
+
+// Completion collects the result of a process once, in the background, for
+// any number of callers to wait for or poll. Executors use it to implement
+// Result and Poller, starting it once the script is running so that its exit
+// is observed without a caller waiting.
+type Completion struct {
+	collect func() (*Result, error)
+	once    sync.Once
+	done    chan struct{}
+	result  *Result
+	err     error
+}
+
+// NewCompletion creates a completion collecting the result with the function,
+// which is called once Start is.
+func NewCompletion(collect func() (*Result, error)) *Completion {
+	return &Completion{collect: collect, done: make(chan struct{})}
+}
+
+// Start starts collecting the result, if it has not already started.
+func (c *Completion) Start() {
+	c.once.Do(func() {
+		go func() {
+			c.result, c.err = c.collect()
+			close(c.done)
+		}()
+	})
+}
+
+// Done returns a channel closed once the result has been collected.
+func (c *Completion) Done() <-chan struct{} {
+	return c.done
+}
+
+// Result starts collecting the result and waits for it.
+func (c *Completion) Result() (*Result, error) {
+	c.Start()
+	<-c.done
+	return c.result, c.err
+}
+
+// Poll starts collecting the result and returns it, if it has been collected
+// (see Poller).
+func (c *Completion) Poll() (*Result, bool) {
+	c.Start()
+	select {
+	case <-c.done:
+		return c.result, true
+	default:
+		return nil, false
+	}
+}
+
+// WaitContext starts collecting the result and waits for it, unless the
+// context is done first (see Poller).
+func (c *Completion) WaitContext(ctx context.Context) (*Result, error) {
+	c.Start()
+	select {
+	case <-c.done:
+		return c.result, c.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%w: %w", ErrStillRunning, contextCause(ctx))
+	}
+}
+
+// Wait collects the result of the process, as Result does, unless the context
+// is done first, in which case the process is killed and the context's cause is
+// returned (matching ErrTimeout or ErrCancelled), along with the result of the
+// killed process if there is one. A panic collecting the result is recovered,
+// returned as an error wrapping ErrPanic.
+func Wait(ctx context.Context, p Process) (*Result, error) {
+	type collected struct {
+		result *Result
+		err    error
+	}
+	done := make(chan collected, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- collected{err: fmt.Errorf("%w: %v", ErrPanic, r)}
+			}
+		}()
+		result, err := p.Result()
+		done <- collected{result, err}
+	}()
+	select {
+	case c := <-done:
+		return c.result, c.err
+	case <-ctx.Done():
+	}
+	p.Kill()
+	c := <-done
+	return c.result, fmt.Errorf("process killed: %w", contextCause(ctx))
+}
+
+// contextCause returns the cause of the done context, classed by its error as
+// ErrTimeout or ErrCancelled (see ContextError).
+func contextCause(ctx context.Context) error {
+	if err, ok := ContextError(ctx.Err()).(*classError); ok {
+		return &classError{class: err.class, err: context.Cause(ctx)}
+	}
+	return context.Cause(ctx)
+}
+
+// Guard wraps the process so that its result may be collected any number of
+// times and from many goroutines, each returning the result collected first.
+// Once collected, Kill and Signal do nothing, and Write returns an error
+// wrapping ErrExited, rather than each depending on how the executor handles
+// an exited script. The guarded process is a Poller, with the result being
+// collected from the first call of Result, Poll or WaitContext.
+func Guard(p Process) Process {
+	if g, ok := p.(*guardedProcess); ok {
+		return g
+	}
+	return &guardedProcess{Process: p, completion: NewCompletion(p.Result)}
+}
+
+// guardedProcess is the process provided by Guard.
+type guardedProcess struct {
+	Process
+	completion *Completion
+}
+
+func (p *guardedProcess) exited() bool {
+	select {
+	case <-p.completion.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *guardedProcess) Kill() error {
+	if p.exited() {
+		return nil
+	}
+	return p.Process.Kill()
+}
+
+func (p *guardedProcess) Signal(s os.Signal) error {
+	if p.exited() {
+		return nil
+	}
+	return p.Process.Signal(s)
+}
+
+func (p *guardedProcess) Write(input string) error {
+	if p.exited() {
+		return fmt.Errorf("%w: can not write to stdin", ErrExited)
+	}
+	return p.Process.Write(input)
+}
+
+func (p *guardedProcess) CloseStdin() error {
+	if closer, ok := p.Process.(StdinCloser); ok && !p.exited() {
+		return closer.CloseStdin()
+	}
+	return nil
+}
+
+func (p *guardedProcess) Stdin() io.WriteCloser {
+	return StdinWriter(p)
+}
+
+func (p *guardedProcess) Result() (*Result, error) {
+	return p.completion.Result()
+}
+
+func (p *guardedProcess) Wait(ctx context.Context) (*Result, error) {
+	return Wait(ctx, p)
+}
+
+func (p *guardedProcess) Poll() (*Result, bool) {
+	return p.completion.Poll()
+}
+
+func (p *guardedProcess) WaitContext(ctx context.Context) (*Result, error) {
+	return p.completion.WaitContext(ctx)
+}
+
+// ProcessHooks are the functions a wrapping executor (such as a logging or
+// metrics one) applies to the process of the executor it wraps (see
+// WrapProcess).
+type ProcessHooks struct {
+	// Result is called once, with the result (or error) first collected from
+	// the process by Result, Poll or WaitContext, returning the result and
+	// error every caller is given instead. The result is a copy of that of the
+	// process, so may be changed.
+	Result func(result *Result, err error) (*Result, error)
+
+	// Close is called once the process has been closed.
+	Close func()
+}
+
+// WrapProcess wraps the process so that the hooks are applied to it, as the
+// base of the processes of wrapping executors. The wrapped process keeps the
+// optional interfaces of the process, being a Poller and StdinCloser only if
+// the process is, so the wrapping executor does not hide them from callers.
+// The result given to the Result hook is a copy, as the result of the process
+// may be shared with its other callers (such as by a Completion), and each
+// later caller is given the same outcome as the first.
+func WrapProcess(p Process, hooks ProcessHooks) Process {
+	wrapped := &wrappedProcess{Process: p, hooks: hooks}
+	poller, polls := p.(Poller)
+	closer, closes := p.(StdinCloser)
+	switch {
+	case polls && closes:
+		return &wrappedPollingCloser{wrappedPoller{wrapped, poller}, closer}
+	case polls:
+		return &wrappedPoller{wrapped, poller}
+	case closes:
+		return &wrappedCloser{wrapped, closer}
+	default:
+		return wrapped
+	}
+}
+
+// wrappedProcess is the process provided by WrapProcess.
+type wrappedProcess struct {
+	Process
+	hooks ProcessHooks
+
+	mu        sync.Mutex
+	collected bool
+	outcome   *Result
+	err       error
+}
+
+func (p *wrappedProcess) Result() (*Result, error) {
+	return p.result(p.Process.Result())
+}
+
+func (p *wrappedProcess) Wait(ctx context.Context) (*Result, error) {
+	return Wait(ctx, p)
+}
+
+// result applies the Result hook to a copy of the result first collected,
+// returning its outcome to every caller.
+func (p *wrappedProcess) result(result *Result, err error) (*Result, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.collected {
+		return p.outcome, p.err
+	}
+	if result != nil {
+		result = result.clone()
+	}
+	if p.hooks.Result != nil {
+		result, err = p.hooks.Result(result, err)
+	}
+	p.collected, p.outcome, p.err = true, result, err
+	return result, err
+}
+
+func (p *wrappedProcess) Close() {
+	p.Process.Close()
+	if p.hooks.Close != nil {
+		p.hooks.Close()
+	}
+}
+
+// wrappedPoller is a process provided by WrapProcess for a Poller.
+type wrappedPoller struct {
+	*wrappedProcess
+	poller Poller
+}
+
+func (p *wrappedPoller) Poll() (*Result, bool) {
+	result, done := p.poller.Poll()
+	if !done || result == nil {
+		return nil, done
+	}
+	result, err := p.result(result, nil)
+	if err != nil {
+		return nil, true
+	}
+	return result, true
+}
+
+func (p *wrappedPoller) WaitContext(ctx context.Context) (*Result, error) {
+	result, err := p.poller.WaitContext(ctx)
+	if errors.Is(err, ErrStillRunning) {
+		return nil, err
+	}
+	return p.result(result, err)
+}
+
+// wrappedCloser is a process provided by WrapProcess for a StdinCloser.
+type wrappedCloser struct {
+	*wrappedProcess
+	StdinCloser
+}
+
+// wrappedPollingCloser is a process provided by WrapProcess for a Poller that
+// is also a StdinCloser.
+type wrappedPollingCloser struct {
+	wrappedPoller
+	StdinCloser
+}
@@ -0,0 +1,165 @@
+package nescript_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/local"
+	"github.com/neaas/nescript/processtest"
+)
+
+// fakeProcess is a process exiting 0 with the result.
+type fakeProcess struct {
+	result *nescript.Result
+}
+
+func (p *fakeProcess) Kill() error                       { return nil }
+func (p *fakeProcess) Signal(os.Signal) error            { return nil }
+func (p *fakeProcess) Write(string) error                { return nil }
+func (p *fakeProcess) Stdin() io.WriteCloser             { return nescript.StdinWriter(p) }
+func (p *fakeProcess) Stdout() io.Reader                 { return strings.NewReader(p.result.StdOut) }
+func (p *fakeProcess) Stderr() io.Reader                 { return strings.NewReader(p.result.StdErr) }
+func (p *fakeProcess) Result() (*nescript.Result, error) { return p.result, nil }
+func (p *fakeProcess) ID() string                        { return "" }
+func (p *fakeProcess) String() string                    { return "fake" }
+func (p *fakeProcess) Close()                            {}
+func (p *closingFakeProcess) CloseStdin() error          { return nil }
+func (p *fakeProcess) Wait(ctx context.Context) (*nescript.Result, error) {
+	return nescript.Wait(ctx, p)
+}
+func fakeExecutor(p nescript.Process) nescript.ExecFunc {
+	return func(nescript.Cmd) (nescript.Process, error) { return p, nil }
+}
+func newFakeProcess(stdout string) *fakeProcess {
+	return &fakeProcess{result: &nescript.Result{StdOut: stdout}}
+}
+
+// closingFakeProcess is a fakeProcess that is a StdinCloser.
+type closingFakeProcess struct {
+	*fakeProcess
+}
+
+// wrappers are the wrapping executors of the package, applied to an executor.
+var wrappers = map[string]func(nescript.ExecFunc) nescript.ExecFunc{
+	"decoding": func(e nescript.ExecFunc) nescript.ExecFunc {
+		return nescript.Decoding(e, "windows-1252")
+	},
+	"normalizing": func(e nescript.ExecFunc) nescript.ExecFunc {
+		return nescript.Normalizing(e, nescript.StripANSI)
+	},
+	"success codes": func(e nescript.ExecFunc) nescript.ExecFunc {
+		return nescript.SuccessCodes(e, 0, 1)
+	},
+	"logging": func(e nescript.ExecFunc) nescript.ExecFunc {
+		return nescript.Logging(e, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	},
+	"fallback": func(e nescript.ExecFunc) nescript.ExecFunc {
+		return nescript.Fallback(e)
+	},
+	"available": func(e nescript.ExecFunc) nescript.ExecFunc {
+		return nescript.Available("fake", e, nil)
+	},
+	"auditing": func(e nescript.ExecFunc) nescript.ExecFunc {
+		return nescript.Auditing(e, nescript.RequireShebang())
+	},
+}
+
+func TestWrappersKeepOptionalInterfaces(t *testing.T) {
+	for name, wrap := range wrappers {
+		t.Run(name, func(t *testing.T) {
+			for _, tc := range []struct {
+				name    string
+				process nescript.Process
+				poller  bool
+				closer  bool
+			}{
+				{"plain", newFakeProcess("out"), false, false},
+				{"stdin closer", &closingFakeProcess{newFakeProcess("out")}, false, true},
+				{"poller", nescript.Guard(newFakeProcess("out")), true, true},
+			} {
+				process, err := nescript.NewCmd("true").Exec(wrap(fakeExecutor(tc.process)))
+				if err != nil {
+					t.Fatalf("%s: failed to execute: %v", tc.name, err)
+				}
+				if _, ok := process.(nescript.Poller); ok != tc.poller {
+					t.Errorf("%s: expected Poller to be %t, got %t", tc.name, tc.poller, ok)
+				}
+				if _, ok := process.(nescript.StdinCloser); ok != tc.closer {
+					t.Errorf("%s: expected StdinCloser to be %t, got %t", tc.name, tc.closer, ok)
+				}
+			}
+		})
+	}
+}
+
+func TestWrappersKeepProcessContract(t *testing.T) {
+	for name, wrap := range wrappers {
+		t.Run(name, func(t *testing.T) {
+			if err := processtest.TestExecutor(wrap(local.Executor(""))); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestStepwiseKeepsProcessContract(t *testing.T) {
+	if err := processtest.TestExecutor(nescript.Stepwise(local.Executor(""), nescript.StepStopOnFailure)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWrappersDoNotChangeSharedResult(t *testing.T) {
+	shared := &nescript.Result{StdOut: "caf\xe9", Metadata: map[string]any{"k": "v"}}
+	executor := nescript.Decoding(fakeExecutor(&fakeProcess{result: shared}), "windows-1252")
+	process, err := nescript.NewCmd("true").Exec(executor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 2 {
+		result, err := process.Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.StdOut != "café" {
+			t.Errorf("result %d: expected stdout 'café', got %q", i, result.StdOut)
+		}
+	}
+	if shared.StdOut != "caf\xe9" {
+		t.Errorf("expected the wrapped process's result to be unchanged, got %q", shared.StdOut)
+	}
+	audited, err := nescript.NewCmd("true").Exec(nescript.Auditing(fakeExecutor(&fakeProcess{result: shared}), nescript.RequireShebang()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, _ := audited.Result(); len(result.PolicyViolations()) != 1 {
+		t.Errorf("expected a policy violation recorded, got %v", result.Metadata)
+	}
+	if len(shared.Metadata) != 1 {
+		t.Errorf("expected the wrapped process's metadata to be unchanged, got %v", shared.Metadata)
+	}
+}
+
+func TestWrappedPollerReturnsSameResult(t *testing.T) {
+	process, err := nescript.NewScript("echo out").Cmd().Exec(nescript.Normalizing(local.Executor(""), nescript.StripANSI))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer process.Close()
+	poller := process.(nescript.Poller)
+	waited, err := poller.WaitContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	polled, done := poller.Poll()
+	if !done || polled != waited {
+		t.Error("expected poll to return the result waited for")
+	}
+	if result, _ := process.Result(); result != waited {
+		t.Error("expected result to return the result waited for")
+	}
+}
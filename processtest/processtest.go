@@ -0,0 +1,490 @@
+// Package processtest checks that an executor provides processes keeping to the
+// nescript.Process contract, for executor implementations to run in their own
+// tests.
+package processtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/neaas/nescript"
+)
+
+// Timeout is how long each check waits for a script to complete before failing.
+var Timeout = 30 * time.Second
+
+// waiters is how many callers wait for the result concurrently in checkPoll.
+const waiters = 4
+
+// check is a single check of the contract, run against a fresh process.
+type check struct {
+	name string
+	run  func(executor nescript.ExecFunc) error
+}
+
+var checks = []check{
+	{"output", checkOutput},
+	{"exit code", checkExitCode},
+	{"result", checkResult},
+	{"env", checkEnv},
+	{"stdin", checkStdin},
+	{"stdin writer", checkStdinWriter},
+	{"streams", checkStreams},
+	{"writer warnings", checkWriterWarnings},
+	{"unread streams", checkUnreadStreams},
+	{"kill", checkKill},
+	{"kill once exited", checkKillExited},
+	{"wait", checkWait},
+	{"identity", checkIdentity},
+	{"guard", checkGuard},
+	{"poll", checkPoll},
+	{"close", checkClose},
+}
+
+// TestExecutor executes a set of POSIX shell scripts with the executor,
+// checking the processes keep to the nescript.Process contract: collecting
+// separated output and the exit code, recording the times, success and
+// executor on the result (see nescript.Result.Executor), passing the env, writing to stdin,
+// streaming the output without blocking the script when it is not read, being
+// waited for with a deadline, polled and waited for concurrently (see
+// nescript.Poller), being killed (doing nothing once exited) and closed. Each
+// failed check is returned, joined in a single error; nil is returned if all
+// pass.
+//
+//	func TestExecutor(t *testing.T) {
+//		if err := processtest.TestExecutor(local.Executor("")); err != nil {
+//			t.Fatal(err)
+//		}
+//	}
+func TestExecutor(executor nescript.ExecFunc) error {
+	var errs []error
+	for _, c := range checks {
+		if err := c.run(executor); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", c.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// script provides the cmd of the POSIX shell script.
+func script(raw string) nescript.Cmd {
+	return nescript.NewScript(raw).WithSubcommand(nescript.SCShell).Cmd()
+}
+
+// result waits up to the timeout for the result of the process, killing it if
+// it does not complete.
+func result(p nescript.Process) (*nescript.Result, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+	return p.Wait(ctx)
+}
+
+// run executes the cmd and collects its result.
+func run(executor nescript.ExecFunc, c nescript.Cmd) (*nescript.Result, error) {
+	process, err := c.Exec(executor)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute: %w", err)
+	}
+	defer process.Close()
+	r, err := result(process)
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect result: %w", err)
+	}
+	return r, nil
+}
+
+func checkOutput(executor nescript.ExecFunc) error {
+	r, err := run(executor, script("echo out; echo err >&2"))
+	if err != nil {
+		return err
+	}
+	if got := strings.TrimSpace(r.StdOut); got != "out" {
+		return fmt.Errorf("expected stdout 'out', got '%s'", got)
+	}
+	if got := strings.TrimSpace(r.StdErr); got != "err" {
+		return fmt.Errorf("expected stderr 'err', got '%s'", got)
+	}
+	if r.ExitCode != 0 {
+		return fmt.Errorf("expected exit code 0, got %d", r.ExitCode)
+	}
+	return nil
+}
+
+func checkExitCode(executor nescript.ExecFunc) error {
+	r, err := run(executor, script("exit 3"))
+	if err != nil {
+		return err
+	}
+	if r.ExitCode != 3 {
+		return fmt.Errorf("expected exit code 3, got %d", r.ExitCode)
+	}
+	if r.Signaled {
+		return fmt.Errorf("expected exit not to be signaled, got %s", r.Signal)
+	}
+	return nil
+}
+
+// checkResult checks the result records the times the script ran between, its
+// success and the executor it was run by.
+func checkResult(executor nescript.ExecFunc) error {
+	before := time.Now()
+	succeeded, err := run(executor, script("true"))
+	if err != nil {
+		return err
+	}
+	failed, err := run(executor, script("exit 3"))
+	if err != nil {
+		return err
+	}
+	if succeeded.Start.IsZero() || succeeded.End.Before(succeeded.Start) {
+		return fmt.Errorf("expected the start and end times, got %s and %s", succeeded.Start, succeeded.End)
+	}
+	// kubernetes reports the times of the script to the second.
+	if succeeded.End.Before(before.Truncate(time.Second)) {
+		return fmt.Errorf("expected the end time after the script was executed, got %s", succeeded.End)
+	}
+	if succeeded.Duration() != succeeded.End.Sub(succeeded.Start) {
+		return fmt.Errorf("expected the duration between the start and end times, got %s", succeeded.Duration())
+	}
+	if !succeeded.Success() || failed.Success() {
+		return fmt.Errorf("expected only exit code 0 to succeed")
+	}
+	if succeeded.Executor() == "" {
+		return fmt.Errorf("expected the executor recorded on the result")
+	}
+	return nil
+}
+
+func checkEnv(executor nescript.ExecFunc) error {
+	r, err := run(executor, script(`echo "$NESCRIPT_PROCESSTEST"`).WithEnv("NESCRIPT_PROCESSTEST=value"))
+	if err != nil {
+		return err
+	}
+	if got := strings.TrimSpace(r.StdOut); got != "value" {
+		return fmt.Errorf("expected env var 'value', got '%s'", got)
+	}
+	return nil
+}
+
+func checkStdin(executor nescript.ExecFunc) error {
+	process, err := script(`read line; echo "got $line"`).Exec(executor)
+	if err != nil {
+		return fmt.Errorf("failed to execute: %w", err)
+	}
+	defer process.Close()
+	if err := process.Write("input\n"); err != nil {
+		return fmt.Errorf("failed to write: %w", err)
+	}
+	r, err := result(process)
+	if err != nil {
+		return fmt.Errorf("failed to collect result: %w", err)
+	}
+	if got := strings.TrimSpace(r.StdOut); got != "got input" {
+		return fmt.Errorf("expected stdout 'got input', got '%s'", got)
+	}
+	return nil
+}
+
+// checkStdinWriter writes to the process's Stdin, closing it where the process
+// can close its stdin.
+func checkStdinWriter(executor nescript.ExecFunc) error {
+	raw := `read line; echo "got $line"`
+	_, closes := noopProcess(executor).(nescript.StdinCloser)
+	if closes {
+		raw = `while read line; do echo "got $line"; done`
+	}
+	process, err := script(raw).Exec(executor)
+	if err != nil {
+		return fmt.Errorf("failed to execute: %w", err)
+	}
+	defer process.Close()
+	stdin := process.Stdin()
+	if _, err := io.WriteString(stdin, "input\n"); err != nil {
+		return fmt.Errorf("failed to write: %w", err)
+	}
+	if closes {
+		if err := stdin.Close(); err != nil {
+			return fmt.Errorf("failed to close stdin: %w", err)
+		}
+	}
+	r, err := result(process)
+	if err != nil {
+		return fmt.Errorf("failed to collect result: %w", err)
+	}
+	if got := strings.TrimSpace(r.StdOut); got != "got input" {
+		return fmt.Errorf("expected stdout 'got input', got '%s'", got)
+	}
+	return nil
+}
+
+// noopProcess returns a process of a script doing nothing, for checking the
+// interfaces of the executor's processes, or nil if it fails to execute.
+func noopProcess(executor nescript.ExecFunc) nescript.Process {
+	process, err := script("true").Exec(executor)
+	if err != nil {
+		return nil
+	}
+	defer process.Close()
+	result(process)
+	return process
+}
+
+func checkStreams(executor nescript.ExecFunc) error {
+	process, err := script("echo out; echo err >&2").Exec(executor)
+	if err != nil {
+		return fmt.Errorf("failed to execute: %w", err)
+	}
+	defer process.Close()
+	stdout, stderr := readAll(process.Stdout()), readAll(process.Stderr())
+	r, err := result(process)
+	if err != nil {
+		return fmt.Errorf("failed to collect result: %w", err)
+	}
+	for _, stream := range []struct {
+		name     string
+		read     <-chan string
+		expected string
+	}{{"stdout", stdout, r.StdOut}, {"stderr", stderr, r.StdErr}} {
+		select {
+		case got := <-stream.read:
+			if got != stream.expected {
+				return fmt.Errorf("expected %s streamed '%s', got '%s'", stream.name, stream.expected, got)
+			}
+		case <-time.After(Timeout):
+			return fmt.Errorf("%s stream did not end once the result was collected", stream.name)
+		}
+	}
+	return nil
+}
+
+// failingWriter is a writer given to the cmd which fails every write.
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("writer closed")
+}
+
+// checkWriterWarnings checks a writer given to the cmd (see
+// nescript.Cmd.WithStdoutWriter) failing is recorded as a warning on the
+// result, rather than failing the execution.
+func checkWriterWarnings(executor nescript.ExecFunc) error {
+	r, err := run(executor, script("echo out").WithStdoutWriter(failingWriter{}))
+	if err != nil {
+		return err
+	}
+	warnings, _ := r.Metadata[nescript.MetadataWarnings].([]string)
+	for _, warning := range warnings {
+		if strings.Contains(warning, "writer closed") {
+			return nil
+		}
+	}
+	return fmt.Errorf("expected the failed writer recorded as a warning, got %v", warnings)
+}
+
+// readAll reads the reader to its end, sending what was read.
+func readAll(r io.Reader) <-chan string {
+	read := make(chan string, 1)
+	go func() {
+		b, _ := io.ReadAll(r)
+		read <- string(b)
+	}()
+	return read
+}
+
+// checkUnreadStreams checks a script writing more than a pipe holds completes
+// while its streams are not read.
+func checkUnreadStreams(executor nescript.ExecFunc) error {
+	process, err := script(`i=0; while [ $i -lt 2000 ]; do echo "line $i of output that is not read"; i=$((i+1)); done`).Exec(executor)
+	if err != nil {
+		return fmt.Errorf("failed to execute: %w", err)
+	}
+	defer process.Close()
+	process.Stdout()
+	r, err := result(process)
+	if err != nil {
+		return fmt.Errorf("failed to collect result: %w", err)
+	}
+	if got := strings.Count(r.StdOut, "\n"); got != 2000 {
+		return fmt.Errorf("expected 2000 lines of stdout, got %d", got)
+	}
+	return nil
+}
+
+func checkKill(executor nescript.ExecFunc) error {
+	process, err := script("sleep 600").Exec(executor)
+	if err != nil {
+		return fmt.Errorf("failed to execute: %w", err)
+	}
+	defer process.Close()
+	if err := process.Kill(); err != nil {
+		return fmt.Errorf("failed to kill: %w", err)
+	}
+	r, err := result(process)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("result not returned once killed")
+	}
+	// a killed script may be reported either as an error or a failed result.
+	if err == nil && r.ExitCode == 0 {
+		return fmt.Errorf("expected killed script not to exit 0")
+	}
+	return nil
+}
+
+func checkKillExited(executor nescript.ExecFunc) error {
+	process, err := script("echo out").Exec(executor)
+	if err != nil {
+		return fmt.Errorf("failed to execute: %w", err)
+	}
+	defer process.Close()
+	if _, err := result(process); err != nil {
+		return fmt.Errorf("failed to collect result: %w", err)
+	}
+	if err := process.Kill(); err != nil {
+		return fmt.Errorf("expected kill once exited to do nothing, got: %w", err)
+	}
+	return nil
+}
+
+// checkWait checks waiting past a deadline kills the script, returning an error
+// matching nescript.ErrTimeout.
+func checkWait(executor nescript.ExecFunc) error {
+	process, err := script("sleep 600").Exec(executor)
+	if err != nil {
+		return fmt.Errorf("failed to execute: %w", err)
+	}
+	defer process.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		_, err := process.Wait(ctx)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, nescript.ErrTimeout) {
+			return fmt.Errorf("expected wait past the deadline to fail with '%v', got: %v", nescript.ErrTimeout, err)
+		}
+		return nil
+	case <-time.After(Timeout):
+		return fmt.Errorf("wait did not return once killed")
+	}
+}
+
+func checkIdentity(executor nescript.ExecFunc) error {
+	process, err := script("true").Exec(executor)
+	if err != nil {
+		return fmt.Errorf("failed to execute: %w", err)
+	}
+	defer process.Close()
+	defer result(process)
+	if process.String() == "" {
+		return fmt.Errorf("expected the process to describe itself")
+	}
+	return nil
+}
+
+func checkGuard(executor nescript.ExecFunc) error {
+	process, err := script("echo out").Exec(executor)
+	if err != nil {
+		return fmt.Errorf("failed to execute: %w", err)
+	}
+	guarded := nescript.Guard(process)
+	defer guarded.Close()
+	first, err := result(guarded)
+	if err != nil {
+		return fmt.Errorf("failed to collect result: %w", err)
+	}
+	second, err := guarded.Result()
+	if err != nil || second != first {
+		return fmt.Errorf("expected the same result collected twice")
+	}
+	if err := guarded.Kill(); err != nil {
+		return fmt.Errorf("expected kill once exited to do nothing, got: %w", err)
+	}
+	if err := guarded.Write("input\n"); !errors.Is(err, nescript.ErrExited) {
+		return fmt.Errorf("expected write once exited to fail with '%v', got: %v", nescript.ErrExited, err)
+	}
+	return nil
+}
+
+// checkPoll checks the process is a nescript.Poller, through a guard if it is
+// not one itself.
+func checkPoll(executor nescript.ExecFunc) error {
+	process, err := script(`read line; echo "got $line"`).Exec(executor)
+	if err != nil {
+		return fmt.Errorf("failed to execute: %w", err)
+	}
+	defer process.Close()
+	if _, ok := process.(nescript.Poller); !ok {
+		process = nescript.Guard(process)
+	}
+	poller := process.(nescript.Poller)
+	if _, exited := poller.Poll(); exited {
+		return fmt.Errorf("expected poll to report the script running")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := poller.WaitContext(ctx); !errors.Is(err, nescript.ErrStillRunning) {
+		return fmt.Errorf("expected wait past the deadline to fail with '%v', got: %v", nescript.ErrStillRunning, err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+	results := make(chan *nescript.Result, waiters)
+	errs := make(chan error, waiters)
+	for range waiters {
+		go func() {
+			r, err := poller.WaitContext(ctx)
+			results <- r
+			errs <- err
+		}()
+	}
+	if err := process.Write("input\n"); err != nil {
+		return fmt.Errorf("failed to write once waited for: %w", err)
+	}
+	var first *nescript.Result
+	for range waiters {
+		r, err := <-results, <-errs
+		if err != nil {
+			return fmt.Errorf("failed to collect result: %w", err)
+		}
+		if first == nil {
+			first = r
+		}
+		if r != first {
+			return fmt.Errorf("expected every waiter to receive the same result")
+		}
+	}
+	if got := strings.TrimSpace(first.StdOut); got != "got input" {
+		return fmt.Errorf("expected stdout 'got input', got '%s'", got)
+	}
+	if r, exited := poller.Poll(); !exited || r != first {
+		return fmt.Errorf("expected poll once exited to return the result")
+	}
+	return nil
+}
+
+func checkClose(executor nescript.ExecFunc) error {
+	process, err := script("sleep 600").Exec(executor)
+	if err != nil {
+		return fmt.Errorf("failed to execute: %w", err)
+	}
+	process.Kill()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		result(process)
+		process.Close()
+		process.Close()
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-time.After(Timeout):
+		return fmt.Errorf("close did not return")
+	}
+}
@@ -0,0 +1,165 @@
+package nescript
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+)
+
+// Overlay modifies a script got from a Registry, such as adding the fields of
+// a particular execution:
+//
+//	script, err := nescript.Get("db-migrate", func(s nescript.Script) nescript.Script {
+//		return s.WithField("version", version)
+//	})
+type Overlay func(Script) Script
+
+// Registry is a central set of named scripts, such as those of an application
+// registered at init, with default fields and env vars applied to every script
+// got from it. A Registry is safe for concurrent use.
+//
+// The fields and env vars of a script got from the registry are layered as
+// follows, each layer taking precedence over those before it:
+//
+//  1. the registry's defaults (see SetDefaults)
+//  2. those of the script when it was registered
+//  3. those added by the overlays given to Get, in order
+//
+// As executors take the last of a duplicated env var, env vars are in the order
+// of the layers. Each script got from the registry has its own fields and env,
+// so changing it does not change the registered script.
+type Registry struct {
+	mu      sync.RWMutex
+	scripts map[string]Script
+	fields  map[string]any
+	env     []string
+	sealed  bool
+}
+
+// DefaultRegistry is the registry used by the package level Register, Get and
+// Seal.
+var DefaultRegistry = NewRegistry()
+
+// NewRegistry creates an empty registry, without defaults.
+func NewRegistry() *Registry {
+	return &Registry{scripts: make(map[string]Script)}
+}
+
+// SetDefaults sets the default fields and env vars (in KEY=VALUE format) of
+// the scripts got from the registry, replacing any set before.
+func (r *Registry) SetDefaults(fields map[string]any, env ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fields = maps.Clone(fields)
+	r.env = slices.Clone(env)
+}
+
+// Register registers the script under the name, with a copy of its fields and
+// env, so that changing the script after does not change the registered
+// script. Registering a name already registered returns an error wrapping
+// ErrDuplicateScript, and registering once the registry is sealed an error
+// wrapping ErrRegistrySealed.
+func (r *Registry) Register(name string, s *Script) error {
+	if name == "" {
+		return errors.New("a script must be registered with a name")
+	}
+	if s == nil {
+		return fmt.Errorf("no script was given to register as '%s'", name)
+	}
+	script := *s
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.sealed {
+		return fmt.Errorf("%w: can not register '%s'", ErrRegistrySealed, name)
+	}
+	if _, ok := r.scripts[name]; ok {
+		return fmt.Errorf("%w: '%s'", ErrDuplicateScript, name)
+	}
+	r.scripts[name] = script
+	return nil
+}
+
+// MustRegister registers the script under the name (see Register), however
+// will panic if an error occurs, for registering scripts at init.
+func (r *Registry) MustRegister(name string, script *Script) {
+	if err := r.Register(name, script); err != nil {
+		panic(err)
+	}
+}
+
+// Get returns the script registered under the name, with the registry's
+// defaults and the overlays applied in order (see Registry). A name that is not
+// registered returns an error wrapping ErrScriptNotFound.
+func (r *Registry) Get(name string, overlays ...Overlay) (*Script, error) {
+	r.mu.RLock()
+	script, ok := r.scripts[name]
+	fields, env := r.fields, r.env
+	r.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: '%s'", ErrScriptNotFound, name)
+	}
+	var layered dynamicData
+	layered.grow(len(fields)+len(script.fields.items), len(env)+len(script.env.items))
+	layered.addFields(fields, true)
+	layered.fields = layered.fields.appended(script.fields.items...)
+	layered.addEnv(env...)
+	layered.addEnv(script.env.items...)
+	script.dynamicData = layered
+	for _, overlay := range overlays {
+		script = overlay(script)
+	}
+	return &script, nil
+}
+
+// Names returns the sorted names of the registered scripts.
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.scripts))
+	for name := range r.scripts {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Seal prevents any further scripts being registered, such as once an
+// application has started, so that registering late is caught as an error
+// (see ErrRegistrySealed) rather than racing with the scripts being got.
+func (r *Registry) Seal() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sealed = true
+}
+
+// Sealed reports whether the registry is sealed (see Seal).
+func (r *Registry) Sealed() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.sealed
+}
+
+// Register registers the script under the name in the DefaultRegistry (see
+// Registry.Register).
+func Register(name string, script *Script) error {
+	return DefaultRegistry.Register(name, script)
+}
+
+// MustRegister registers the script under the name in the DefaultRegistry,
+// however will panic if an error occurs (see Registry.MustRegister).
+func MustRegister(name string, script *Script) {
+	DefaultRegistry.MustRegister(name, script)
+}
+
+// Get returns the script registered under the name in the DefaultRegistry,
+// with the overlays applied (see Registry.Get).
+func Get(name string, overlays ...Overlay) (*Script, error) {
+	return DefaultRegistry.Get(name, overlays...)
+}
+
+// Seal seals the DefaultRegistry (see Registry.Seal).
+func Seal() {
+	DefaultRegistry.Seal()
+}
@@ -1,6 +1,10 @@
 package nescript
 
 import (
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -12,7 +16,207 @@ type Result struct {
 	StdErr   string `json:"stderr"`
 	ExitCode int    `json:"exitCode"`
 
+	// SuccessCodes are the exit codes counted as the script succeeding (see
+	// Success), such as 1 for grep finding no match, when set (see
+	// SuccessCodes). Otherwise, only 0 is. ExitCode is left as it is.
+	SuccessCodes []int `json:"successCodes,omitempty"`
+
+	// StdOutTruncated and StdErrTruncated are true if the executor kept only
+	// part of the output, as it exceeded a cap. StdOutSize and StdErrSize are
+	// the total bytes the script wrote, where counted by the executor (such as
+	// one capping the output), otherwise 0.
+	StdOutTruncated bool  `json:"stdoutTruncated,omitempty"`
+	StdErrTruncated bool  `json:"stderrTruncated,omitempty"`
+	StdOutSize      int64 `json:"stdoutSize,omitempty"`
+	StdErrSize      int64 `json:"stderrSize,omitempty"`
+
+	// Signaled is true if the process was terminated by a signal, in which case
+	// Signal is its name (such as SIGKILL) and ExitCode is 128 + the signal
+	// number, as reported by a shell. Executors set this the same way for
+	// equivalent outcomes (see SetSignal).
+	Signaled bool   `json:"signaled,omitempty"`
+	Signal   string `json:"signal,omitempty"`
+
+	// Start and End are when the executor started the script, and when it was
+	// found to have exited. TotalTime is the time between the two.
+	Start     time.Time     `json:"startTime"`
+	End       time.Time     `json:"endTime"`
 	TotalTime time.Duration `json:"executionTime"`
+
+	// Metadata holds executor specific details about the execution, such as the
+	// resource limits applied to a container. Executor packages provide typed
+	// accessors for the keys they set.
+	Metadata map[string]any `json:"metadata,omitempty"`
+}
+
+// SetMetadata records an executor specific detail about the execution on the
+// result. If the key already exists, it is overwritten.
+func (r *Result) SetMetadata(key string, value any) {
+	if r.Metadata == nil {
+		r.Metadata = make(map[string]any)
+	}
+	r.Metadata[key] = value
+}
+
+// clone returns a copy of the result, with its own success codes and metadata,
+// so that changing the copy does not change the result.
+func (r *Result) clone() *Result {
+	c := *r
+	c.SuccessCodes = slices.Clone(r.SuccessCodes)
+	c.Metadata = maps.Clone(r.Metadata)
+	return &c
+}
+
+// SetCaptured records the output kept by the captures on the result, along with
+// whether each was truncated and the total bytes written to it.
+func (r *Result) SetCaptured(stdout, stderr *Capture) {
+	r.StdOut, r.StdOutTruncated, r.StdOutSize = stdout.String(), stdout.Truncated(), stdout.Size()
+	r.StdErr, r.StdErrTruncated, r.StdErrSize = stderr.String(), stderr.Truncated(), stderr.Size()
+}
+
+// SetTimes records on the result when the script was started and found to have
+// exited, along with the total time between the two.
+func (r *Result) SetTimes(start, end time.Time) {
+	r.Start = start
+	r.End = end
+	r.TotalTime = end.Sub(start)
+}
+
+// Duration is how long the script ran for: the time between its start and end,
+// or the total time if these were not recorded (such as for a replayed result).
+func (r Result) Duration() time.Duration {
+	if r.Start.IsZero() || r.End.IsZero() {
+		return r.TotalTime
+	}
+	return r.End.Sub(r.Start)
+}
+
+// Success reports whether the script exited 0 (or with one of the success
+// codes, if set), rather than with a failing exit code or by a signal.
+func (r Result) Success() bool {
+	if r.Signaled {
+		return false
+	}
+	if len(r.SuccessCodes) == 0 {
+		return r.ExitCode == 0
+	}
+	return slices.Contains(r.SuccessCodes, r.ExitCode)
+}
+
+// Err returns an *ExitError holding the result if the script did not exit
+// successfully (see Success), for callers treating a failing exit as an error.
+// Otherwise, nil is returned.
+func (r *Result) Err() error {
+	if r.Success() {
+		return nil
+	}
+	return &ExitError{Result: r}
+}
+
+// Executor returns the name of the executor the script was run by, such as
+// "local", or that given to Available (see MetadataExecutor). An empty string
+// is returned if the result was not recorded by an executor.
+func (r Result) Executor() string {
+	name, _ := r.Metadata[MetadataExecutor].(string)
+	return name
+}
+
+// Bytes returns the script's stdOut (or stdErr if specified) as bytes.
+func (r Result) Bytes(useErr bool) []byte {
+	return []byte(r.stream(useErr))
+}
+
+// Trimmed returns the script's stdOut (or stdErr if specified), with leading
+// and trailing white space removed.
+func (r Result) Trimmed(useErr bool) string {
+	return strings.TrimSpace(r.stream(useErr))
+}
+
+// FirstLines returns up to the first n lines of the script's stdOut (or stdErr
+// if specified), without their line endings.
+func (r Result) FirstLines(n int, useErr bool) []string {
+	lines := r.lines(useErr)
+	return lines[:min(max(n, 0), len(lines))]
+}
+
+// LastLines returns up to the last n lines of the script's stdOut (or stdErr if
+// specified), without their line endings. For a failed script, these tend to
+// hold the error.
+func (r Result) LastLines(n int, useErr bool) []string {
+	lines := r.lines(useErr)
+	return lines[len(lines)-min(max(n, 0), len(lines)):]
+}
+
+func (r Result) stream(useErr bool) string {
+	if useErr {
+		return r.StdErr
+	}
+	return r.StdOut
+}
+
+// lines splits the stream into its lines, ignoring the final line ending.
+func (r Result) lines(useErr bool) []string {
+	stream := strings.TrimSuffix(r.stream(useErr), "\n")
+	if stream == "" {
+		return []string{}
+	}
+	lines := strings.Split(stream, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+	return lines
+}
+
+// SetSignal records on the result that the process was terminated by the
+// signal with the given (linux) number, setting the exit code to 128 + the
+// signal number.
+func (r *Result) SetSignal(signal int) {
+	r.Signaled = true
+	r.Signal = SignalName(signal)
+	r.ExitCode = 128 + signal
+}
+
+// signalNames are the names of the standard signals, by their linux number.
+var signalNames = map[int]string{
+	1:  "SIGHUP",
+	2:  "SIGINT",
+	3:  "SIGQUIT",
+	4:  "SIGILL",
+	5:  "SIGTRAP",
+	6:  "SIGABRT",
+	7:  "SIGBUS",
+	8:  "SIGFPE",
+	9:  "SIGKILL",
+	10: "SIGUSR1",
+	11: "SIGSEGV",
+	12: "SIGUSR2",
+	13: "SIGPIPE",
+	14: "SIGALRM",
+	15: "SIGTERM",
+}
+
+// SignalName returns the name of the signal with the given (linux) number, such
+// as SIGKILL for 9. Signals without a standard name are named by their number.
+func SignalName(signal int) string {
+	if name, ok := signalNames[signal]; ok {
+		return name
+	}
+	return "SIG" + strconv.Itoa(signal)
+}
+
+// SignalNumber returns the (linux) number of the signal with the given name,
+// with or without the SIG prefix. False is returned if the name is not of a
+// standard signal.
+func SignalNumber(name string) (int, bool) {
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	for number, n := range signalNames {
+		if n == name {
+			return number, true
+		}
+	}
+	return 0, false
 }
 
 // Output parses the specified outputs from the script's stdOut (or stdErr if
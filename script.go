@@ -3,11 +3,13 @@ package nescript
 import (
 	"bytes"
 	"fmt"
-	"html/template"
 	"io"
+	"io/fs"
 	"net/http"
 	"net/url"
 	"os"
+	"slices"
+	"time"
 )
 
 // Script is some executable string, along with data to supplement its
@@ -18,7 +20,12 @@ import (
 type Script struct {
 	raw        string
 	subcommand Subcommand
-	*dynamicData
+	origin     string
+	logger     *eventLogger
+	secrets    secretKeys
+	required   []string
+	templating templating
+	dynamicData
 }
 
 var (
@@ -30,27 +37,51 @@ func NewScript(raw string) *Script {
 	script := Script{
 		raw:        raw,
 		subcommand: defaultSubcommand,
-		dynamicData: &dynamicData{
-			data: make(map[string]any),
-			env:  make([]string, 0),
-		},
 	}
 	return &script
 }
 
 // NewScriptFromFile creates a Script from the string extracted from a given
-// file. This can error if the file can not be read.
-func NewScriptFromFile(path string) (*Script, error) {
+// file. This can error if the file can not be read, or (with WithSignature)
+// can not be verified against its signature.
+func NewScriptFromFile(path string, opts ...SourceOption) (*Script, error) {
 	fileBytes, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get script from file: %w", err)
 	}
-	return NewScript(string(fileBytes)), nil
+	if err := newSourceOptions(opts).verifyScript(fileBytes, path, path+".sig", os.ReadFile); err != nil {
+		return nil, err
+	}
+	script := NewScript(string(fileBytes))
+	script.origin = path
+	return script, nil
+}
+
+// NewScriptFromFS creates a Script from the string extracted from the named
+// file of a filesystem, such as an embed.FS of scripts bundled with the binary
+// by //go:embed, so that they need not be written to disk first. This can
+// error if the file can not be read, or (with WithSignature) can not be
+// verified against its signature, read from the same filesystem.
+func NewScriptFromFS(fsys fs.FS, name string, opts ...SourceOption) (*Script, error) {
+	fileBytes, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get script from fs: %w", err)
+	}
+	read := func(location string) ([]byte, error) {
+		return fs.ReadFile(fsys, location)
+	}
+	if err := newSourceOptions(opts).verifyScript(fileBytes, name, name+".sig", read); err != nil {
+		return nil, err
+	}
+	script := NewScript(string(fileBytes))
+	script.origin = name
+	return script, nil
 }
 
 // NewScriptFromHTTP creates a Script from the string extracted from a given
-// URL. This can error if the contents of the remote resource can not be read.
-func NewScriptFromHTTP(link string) (*Script, error) {
+// URL. This can error if the contents of the remote resource can not be read,
+// or (with WithSignature) can not be verified against its signature.
+func NewScriptFromHTTP(link string, opts ...SourceOption) (*Script, error) {
 	scriptURL, err := url.Parse(link)
 	if err != nil {
 		return nil, fmt.Errorf("could not parse given link as a url: %w", err)
@@ -62,7 +93,14 @@ func NewScriptFromHTTP(link string) (*Script, error) {
 		if bodyBytes, err := io.ReadAll(response.Body); err != nil {
 			return nil, fmt.Errorf("could not read the downloaded script: %w", err)
 		} else {
-			return NewScript(string(bodyBytes)), nil
+			signatureURL := *scriptURL
+			signatureURL.Path, signatureURL.RawPath = signatureURL.Path+".sig", ""
+			if err := newSourceOptions(opts).verifyScript(bodyBytes, scriptURL.String(), signatureURL.String(), readHTTP); err != nil {
+				return nil, err
+			}
+			script := NewScript(string(bodyBytes))
+			script.origin = scriptURL.String()
+			return script, nil
 		}
 	}
 }
@@ -79,7 +117,8 @@ func (s Script) WithSubcommand(sc Subcommand) Script {
 }
 
 // WithField adds a key/value to the map of template data to be used when
-// compiling the script. If the key already exists, it is overwritten.
+// compiling the script. If the key already exists, it is overwritten. The data
+// of the script it is called on is left unchanged.
 func (s Script) WithField(key string, value any) Script {
 	s.addField(key, value)
 	return s
@@ -93,6 +132,30 @@ func (s Script) WithFields(fields map[string]any, overwrite bool) Script {
 	return s
 }
 
+// Grow pre-sizes the script's template data and env for the number of fields
+// and env vars to be added, so that building a script with many of each (such
+// as with a chain of WithField and WithEnv calls) allocates once for each,
+// rather than as they grow.
+func (s Script) Grow(fields, env int) Script {
+	s.grow(fields, env)
+	return s
+}
+
+// WithRequiredFields sets fields that must be given data (see WithField) for
+// the script to compile, so that a script missing one fails to compile with a
+// *CompileError wrapping ErrMissingField, rather than compiling the template
+// with "<no value>".
+func (s Script) WithRequiredFields(keys ...string) Script {
+	s.required = append(slices.Clip(s.required), keys...)
+	return s
+}
+
+// RequiredFields returns the fields that must be given data for the script to
+// compile (see WithRequiredFields).
+func (s Script) RequiredFields() []string {
+	return slices.Clone(s.required)
+}
+
 // WithEnv takes one or more environmental variables in KEY=VALUE format. These
 // will be used when executing the script.
 func (s Script) WithEnv(env ...string) Script {
@@ -100,6 +163,15 @@ func (s Script) WithEnv(env ...string) Script {
 	return s
 }
 
+// WithMergedEnv merges one or more environmental variables in KEY=VALUE format
+// onto those of the script, each replacing the variable of the same key in its
+// place, else being appended, such as to override the env of a script for one
+// host of a fan-out. The env of the script it is called on is left unchanged.
+func (s Script) WithMergedEnv(env ...string) Script {
+	s.mergeEnv(env...)
+	return s
+}
+
 // WithLocalOSEnv appends the environmental variables from the local system to
 // the env var set currently held be the script.
 func (s Script) WithLocalOSEnv() Script {
@@ -107,23 +179,43 @@ func (s Script) WithLocalOSEnv() Script {
 	return s
 }
 
-// Compile uses the go template engine and the provided data fields to compile
-// the script. These in-turn act a more portable approach than command-line
-// arguments.
+// Compile uses the template engine (GoTemplater, of text/template, so the
+// compiled script is exactly as written, without characters such as > and &
+// escaped, unless another is set with WithTemplater) and the provided data
+// fields to compile the script. These in-turn act a more
+// portable approach than command-line arguments.
+// A *CompileError is returned if a template could not be compiled.
 func (s Script) Compile() (Script, error) {
-	scriptTemplate, err := template.New("").Parse(s.raw)
-	if err != nil {
-		return s, fmt.Errorf("failed to parse the script: %w", err)
-	}
-	if s.data == nil {
-		s.data = make(map[string]any)
+	start := time.Now()
+	for _, key := range s.required {
+		if _, ok := s.field(key); !ok {
+			err := &CompileError{Arg: -1, Err: fmt.Errorf("%w: '%s'", ErrMissingField, key)}
+			s.logCompiled(s.raw, start, err)
+			return s, err
+		}
 	}
-	compiledRaw := &bytes.Buffer{}
-	if err := scriptTemplate.Execute(compiledRaw, s.data); err != nil {
-		return s, fmt.Errorf("script template could not be compiled: %w", err)
+	raw := s.raw
+	// a script without template actions compiles to itself, so is not parsed.
+	if s.templating.hasActions(s.raw) {
+		scriptTemplate, err := s.templating.parse(s.raw)
+		if err != nil {
+			err := &CompileError{Arg: -1, Parse: true, Err: err}
+			s.logCompiled(s.raw, start, err)
+			return s, err
+		}
+		compiledRaw := &bytes.Buffer{}
+		compiledRaw.Grow(len(s.raw))
+		if err := scriptTemplate.Render(compiledRaw, s.Data()); err != nil {
+			err := &CompileError{Arg: -1, Err: err}
+			s.logCompiled(s.raw, start, err)
+			return s, err
+		}
+		s.raw = compiledRaw.String()
 	}
-	s.raw = compiledRaw.String()
-	s.data = make(map[string]any)
+	s.secrets.values = s.secretValues()
+	s.clearData()
+	s.required = nil
+	s.logCompiled(raw, start, nil)
 	return s, nil
 }
 
@@ -142,7 +234,7 @@ func (s Script) MustCompile() Script {
 // does not compile the script first or the command after, thus handlebar values
 // will persist.
 func (s Script) Cmd() Cmd {
-	command := append(s.subcommand, s.raw)
+	command := append(slices.Clip(s.subcommand), s.raw)
 	var cmd *Cmd
 	if len(command) <= 0 {
 		cmd = NewCmd("")
@@ -152,6 +244,8 @@ func (s Script) Cmd() Cmd {
 		cmd = NewCmd(command[0], command[1:]...)
 	}
 	cmd.dynamicData = s.dynamicData
+	cmd.templating = s.templating
 	cmd.formatter = defaultScriptFormatter
+	cmd.script = len(command) - 1
 	return *cmd
 }
@@ -0,0 +1,84 @@
+package nescript_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/neaas/nescript"
+)
+
+// builderFields and builderEnv are the fields and env vars set on each script
+// built by BenchmarkBuilder, in the numbers a busy caller sets them.
+var builderFields, builderEnv = func() ([]string, []string) {
+	fields := make([]string, 20)
+	for i := range fields {
+		fields[i] = fmt.Sprintf("field%d", i)
+	}
+	env := make([]string, 40)
+	for i := range env {
+		env[i] = fmt.Sprintf("VAR%d=value", i)
+	}
+	return fields, env
+}()
+
+// BenchmarkBuilder builds scripts with the chain of builder methods, with and
+// without pre-sizing them (see Script.Grow), setting the fields in bulk, and
+// compiling them with and without template actions.
+func BenchmarkBuilder(b *testing.B) {
+	const raw = "echo {{ .field0 }} {{ .field19 }}"
+	b.Run("chained", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			s := *nescript.NewScript(raw)
+			for _, key := range builderFields {
+				s = s.WithField(key, key)
+			}
+			for _, e := range builderEnv {
+				s = s.WithEnv(e)
+			}
+		}
+	})
+	b.Run("grown", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			s := nescript.NewScript(raw).Grow(len(builderFields), len(builderEnv))
+			for _, key := range builderFields {
+				s = s.WithField(key, key)
+			}
+			for _, e := range builderEnv {
+				s = s.WithEnv(e)
+			}
+		}
+	})
+	b.Run("bulk", func(b *testing.B) {
+		fields := make(map[string]any, len(builderFields))
+		for _, key := range builderFields {
+			fields[key] = key
+		}
+		b.ReportAllocs()
+		for range b.N {
+			nescript.NewScript(raw).WithFields(fields, true).WithEnv(builderEnv...)
+		}
+	})
+	b.Run("compile", func(b *testing.B) {
+		s := *nescript.NewScript(raw)
+		for _, key := range builderFields {
+			s = s.WithField(key, key)
+		}
+		b.ReportAllocs()
+		for range b.N {
+			if _, err := s.Compile(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("compile without actions", func(b *testing.B) {
+		s := nescript.NewScript("echo hello").WithEnv(builderEnv...)
+		b.ReportAllocs()
+		for range b.N {
+			if _, err := s.Compile(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,110 @@
+package nescript
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// secretKeys holds the names of the template fields and env vars of a script
+// that are secret, such as passwords, so that their values are never
+// serialized. The slices are sorted, and replaced rather than appended to, so
+// that copies of a script do not share them.
+type secretKeys struct {
+	fields []string
+	env    []string
+
+	// values are those of the secret fields a compiled script was compiled
+	// with, which are redacted from its raw when formatted.
+	values []string
+}
+
+// WithSecretField adds a key/value to the map of template data as WithField
+// does, marking the field as secret so that its value is excluded when the
+// script is marshaled (see Script.MarshalJSON).
+func (s Script) WithSecretField(key string, value any) Script {
+	s.addField(key, value)
+	s.secrets.fields = withSecret(s.secrets.fields, key)
+	return s
+}
+
+// WithSecretEnv takes one or more env vars in KEY=VALUE format as WithEnv does,
+// marking their keys as secret so that their values are excluded when the
+// script is marshaled (see Script.MarshalJSON).
+func (s Script) WithSecretEnv(env ...string) Script {
+	s.addEnv(env...)
+	for _, e := range env {
+		key, _, _ := strings.Cut(e, "=")
+		s.secrets.env = withSecret(s.secrets.env, key)
+	}
+	return s
+}
+
+// SecretFields returns the sorted names of the template fields marked as
+// secret (see WithSecretField).
+func (s Script) SecretFields() []string {
+	return slices.Clone(s.secrets.fields)
+}
+
+// SecretEnv returns the sorted keys of the env vars marked as secret (see
+// WithSecretEnv).
+func (s Script) SecretEnv() []string {
+	return slices.Clone(s.secrets.env)
+}
+
+// isSecretEnv reports whether the env var, in KEY=VALUE format, is secret.
+func (s Script) isSecretEnv(e string) bool {
+	key, _, _ := strings.Cut(e, "=")
+	_, found := slices.BinarySearch(s.secrets.env, key)
+	return found
+}
+
+// isSecretField reports whether the template field is secret.
+func (s Script) isSecretField(key string) bool {
+	_, found := slices.BinarySearch(s.secrets.fields, key)
+	return found
+}
+
+// secretValues returns the values of the secret fields and env vars, as they
+// appear in the raw script once compiled, along with those it was compiled
+// with, longest first so that none is left partly unredacted by a shorter one
+// it contains.
+func (s Script) secretValues() []string {
+	values := slices.Clone(s.secrets.values)
+	for _, key := range s.secrets.fields {
+		if value, ok := s.field(key); ok {
+			values = append(values, fmt.Sprint(value))
+		}
+	}
+	for _, e := range s.env.items {
+		if _, value, ok := strings.Cut(e, "="); ok && s.isSecretEnv(e) {
+			values = append(values, value)
+		}
+	}
+	values = slices.DeleteFunc(values, func(value string) bool { return value == "" })
+	slices.SortFunc(values, func(a, b string) int {
+		if len(a) != len(b) {
+			return len(b) - len(a)
+		}
+		return strings.Compare(a, b)
+	})
+	return slices.Compact(values)
+}
+
+// redactSecrets replaces the secret values in the string with "[REDACTED]".
+func (s Script) redactSecrets(text string) string {
+	for _, value := range s.secretValues() {
+		text = strings.ReplaceAll(text, value, redacted)
+	}
+	return text
+}
+
+// withSecret returns a copy of the sorted names with the name inserted, if it
+// is not already one of them.
+func withSecret(names []string, name string) []string {
+	i, found := slices.BinarySearch(names, name)
+	if found {
+		return names
+	}
+	return slices.Insert(slices.Clip(names), i, name)
+}
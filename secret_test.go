@@ -0,0 +1,88 @@
+package nescript_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/neaas/nescript"
+)
+
+// planted is a secret value which must never appear once formatted or
+// marshaled.
+const planted = "hunter2"
+
+func TestSecretsNeverAppear(t *testing.T) {
+	scripts := map[string]nescript.Script{
+		"field":          nescript.NewScript("curl -u {{.pw}} x").WithSecretField("pw", planted),
+		"compiled field": nescript.NewScript("curl -u {{.pw}} x").WithSecretField("pw", planted).MustCompile(),
+		"env":            nescript.NewScript("curl -u $PW x").WithSecretEnv("PW=" + planted),
+		"compiled env":   nescript.NewScript("echo {{.pw}} $PW").WithSecretField("pw", "x").WithSecretEnv("PW=" + planted).MustCompile(),
+	}
+	for name, script := range scripts {
+		t.Run(name, func(t *testing.T) {
+			marshaled, err := json.Marshal(script)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for format, output := range map[string]string{
+				"String":      script.String(),
+				"GoString":    fmt.Sprintf("%#v", script),
+				"DebugString": script.DebugString(),
+				"MarshalJSON": string(marshaled),
+			} {
+				if strings.Contains(output, planted) {
+					t.Errorf("%s: expected the secret not to appear, got: %s", format, output)
+				}
+			}
+		})
+	}
+}
+
+func TestMarshalRecordsRedactedRaw(t *testing.T) {
+	compiled := nescript.NewScript("curl -u {{.pw}} x").WithSecretField("pw", planted).MustCompile()
+	marshaled, err := json.Marshal(compiled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v struct {
+		Raw      string `json:"raw"`
+		Redacted bool   `json:"redacted"`
+	}
+	if err := json.Unmarshal(marshaled, &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Raw != "curl -u [REDACTED] x" || !v.Redacted {
+		t.Errorf("expected the raw redacted and marked, got %s", marshaled)
+	}
+	uncompiled, err := json.Marshal(nescript.NewScript("echo {{.name}}").WithField("name", "world"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(uncompiled), "redacted") {
+		t.Errorf("expected a script without secrets not to be marked redacted, got %s", uncompiled)
+	}
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	script := nescript.NewScript("echo {{.name}} $TZ").WithField("name", "world").WithEnv("TZ=UTC")
+	marshaled, err := json.Marshal(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var unmarshaled nescript.Script
+	if err := json.Unmarshal(marshaled, &unmarshaled); err != nil {
+		t.Fatal(err)
+	}
+	compiled, err := unmarshaled.Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if compiled.Raw() != "echo world $TZ" {
+		t.Errorf("expected the unmarshaled script to compile as it was, got '%s'", compiled.Raw())
+	}
+	if env := unmarshaled.Env(); len(env) != 1 || env[0] != "TZ=UTC" {
+		t.Errorf("expected the env to round trip, got %v", env)
+	}
+}
@@ -0,0 +1,113 @@
+package nescript
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// SourceOption configures how a script is read from its source (see
+// NewScriptFromFile, NewScriptFromFS and NewScriptFromHTTP).
+type SourceOption func(*sourceOptions)
+
+type sourceOptions struct {
+	keys      []ed25519.PublicKey
+	signature string
+	verify    bool
+}
+
+func newSourceOptions(opts []SourceOption) *sourceOptions {
+	o := &sourceOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithSignature verifies the script against its detached Ed25519 signature
+// before the script is created, accepting a signature by any of the trusted
+// keys, so that keys can be rotated by trusting both the old and new key for a
+// time. The signature is read from the location of the script with ".sig"
+// appended, unless another is given with WithSignatureAt, either as the raw 64
+// bytes or base64 encoded. If it can not be read, or is not that of the script
+// by a trusted key, a *SignatureError is returned and no script is created.
+func WithSignature(keys ...ed25519.PublicKey) SourceOption {
+	return func(o *sourceOptions) {
+		o.keys = append(o.keys, keys...)
+		o.verify = true
+	}
+}
+
+// WithSignatureAt reads the detached signature of the script (see
+// WithSignature) from the location, a file path for NewScriptFromFile, name
+// within the filesystem for NewScriptFromFS or URL for NewScriptFromHTTP.
+func WithSignatureAt(location string) SourceOption {
+	return func(o *sourceOptions) {
+		o.signature = location
+		o.verify = true
+	}
+}
+
+// verifyScript verifies the script read from the origin against its
+// signature, read with the func from its location (the given one, unless
+// another was set with WithSignatureAt).
+func (o *sourceOptions) verifyScript(script []byte, origin, location string, read func(location string) ([]byte, error)) error {
+	if !o.verify {
+		return nil
+	}
+	if o.signature != "" {
+		location = o.signature
+	}
+	sigErr := &SignatureError{Origin: origin, Location: location}
+	if len(o.keys) == 0 {
+		sigErr.Err = errors.New("no trusted keys were given")
+		return sigErr
+	}
+	raw, err := read(location)
+	if err != nil {
+		sigErr.Err = fmt.Errorf("failed to read signature: %w", err)
+		return sigErr
+	}
+	signature, err := decodeSignature(raw)
+	if err != nil {
+		sigErr.Err = err
+		return sigErr
+	}
+	for _, key := range o.keys {
+		if len(key) == ed25519.PublicKeySize && ed25519.Verify(key, script, signature) {
+			return nil
+		}
+	}
+	sigErr.Err = errors.New("not signed by a trusted key")
+	return sigErr
+}
+
+// decodeSignature decodes an Ed25519 signature given as its raw bytes, or
+// base64 encoded.
+func decodeSignature(raw []byte) ([]byte, error) {
+	if len(raw) == ed25519.SignatureSize {
+		return raw, nil
+	}
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(raw)))
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return nil, errors.New("signature is not an Ed25519 signature, raw or base64 encoded")
+	}
+	return signature, nil
+}
+
+// readHTTP reads the signature from a URL, which must respond 200 OK.
+func readHTTP(link string) ([]byte, error) {
+	response, err := http.Get(link)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("'%s' responded %s", link, response.Status)
+	}
+	return io.ReadAll(response.Body)
+}
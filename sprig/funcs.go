@@ -0,0 +1,23 @@
+package sprig
+
+import (
+	"text/template"
+
+	"github.com/Masterminds/sprig/v3"
+	"github.com/neaas/nescript"
+)
+
+// Funcs returns the Sprig template function library (such as quote, upper,
+// b64enc, default and toJson), as used by Helm, for giving to
+// Script.WithTemplateFuncs. As with Sprig itself, this includes functions that
+// compile differently each time, such as randAlphaNum, uuidv4 and env.
+func Funcs() template.FuncMap {
+	return sprig.TxtFuncMap()
+}
+
+// WithFuncs adds the Sprig functions (see Funcs) to the script, replacing any
+// already added of the same name. It is a nescript.Overlay, so can be given
+// when getting a script from a registry.
+func WithFuncs(script nescript.Script) nescript.Script {
+	return script.WithTemplateFuncs(Funcs())
+}
@@ -0,0 +1,38 @@
+package sshe
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// agentSigners connects to the SSH agent on the given socket (or
+// $SSH_AUTH_SOCK), returning signers that sign with the agent's keys. The
+// connection to the agent must stay open until authentication completes.
+func agentSigners(socket string) ([]ssh.Signer, io.Closer, error) {
+	if socket == "" {
+		socket = os.Getenv("SSH_AUTH_SOCK")
+	}
+	if socket == "" {
+		return nil, nil, fmt.Errorf("%w: SSH_AUTH_SOCK is not set", ErrAgentUnavailable)
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: failed to connect to agent at '%s': %w", ErrAgentUnavailable, socket, err)
+	}
+	client := agent.NewClient(conn)
+	signers, err := client.Signers()
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("%w: failed to list agent keys: %w", ErrAgentUnavailable, err)
+	}
+	if len(signers) == 0 {
+		conn.Close()
+		return nil, nil, fmt.Errorf("%w: agent at '%s' holds no keys", ErrAgentUnavailable, socket)
+	}
+	return signers, conn, nil
+}
@@ -0,0 +1,177 @@
+package sshe_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/sshe"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// countingAgent is an agent counting the signatures it makes.
+type countingAgent struct {
+	agent.Agent
+	signs atomic.Int32
+}
+
+func (a *countingAgent) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	a.signs.Add(1)
+	return a.Agent.Sign(key, data)
+}
+
+// newAgent serves an agent holding a new key for each of the authorized flags
+// on a unix socket, returning the socket's path. The keys are authorized on
+// the server where the flag is true.
+func newAgent(t *testing.T, server *testServer, authorized ...bool) (string, *countingAgent) {
+	t.Helper()
+	keyring := &countingAgent{Agent: agent.NewKeyring()}
+	for _, authorize := range authorized {
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := keyring.Add(agent.AddedKey{PrivateKey: private}); err != nil {
+			t.Fatal(err)
+		}
+		if authorize {
+			signer, _ := ssh.NewSignerFromKey(private)
+			server.Authorize(signer.PublicKey())
+		}
+	}
+	// unix socket paths are limited in length, so the test's own temp dir may
+	// be too deep.
+	dir, err := os.MkdirTemp("", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	socket := filepath.Join(dir, "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				agent.ServeAgent(keyring, conn)
+			}()
+		}
+	}()
+	return socket, keyring
+}
+
+func TestAgent(t *testing.T) {
+	server := newTestServer(t)
+	key, keyPEM := newKey(t, "")
+	server.Authorize(key)
+	_, unauthorizedPEM := newKey(t, "")
+	missing := filepath.Join(t.TempDir(), "missing.sock")
+	for _, tc := range []struct {
+		name    string
+		keys    []bool
+		envSock bool
+		opts    func(socket string) []sshe.Option
+		signed  bool
+	}{
+		{"socket", []bool{true}, false, func(socket string) []sshe.Option {
+			return []sshe.Option{sshe.WithAgentSocket(socket)}
+		}, true},
+		{"SSH_AUTH_SOCK", []bool{true}, true, func(string) []sshe.Option {
+			return []sshe.Option{sshe.WithAgent()}
+		}, true},
+		{"second agent key", []bool{false, true}, false, func(socket string) []sshe.Option {
+			return []sshe.Option{sshe.WithAgentSocket(socket)}
+		}, true},
+		{"preferred over a key", []bool{true}, false, func(socket string) []sshe.Option {
+			return []sshe.Option{sshe.WithAgentSocket(socket), sshe.WithPrivateKey(unauthorizedPEM, "")}
+		}, true},
+		{"key after the agent", []bool{false}, false, func(socket string) []sshe.Option {
+			return []sshe.Option{sshe.WithAgentSocket(socket), sshe.WithPrivateKey(keyPEM, "")}
+		}, false},
+		{"key preferred over the agent", []bool{true}, false, func(socket string) []sshe.Option {
+			return []sshe.Option{sshe.WithPrivateKey(keyPEM, ""), sshe.WithAgentSocket(socket)}
+		}, false},
+		{"unreachable agent skipped", nil, false, func(string) []sshe.Option {
+			return []sshe.Option{sshe.WithAgentSocket(missing), sshe.WithPrivateKey(keyPEM, "")}
+		}, false},
+		{"empty agent skipped", nil, false, func(socket string) []sshe.Option {
+			return []sshe.Option{sshe.WithAgentSocket(socket), sshe.WithPrivateKey(keyPEM, "")}
+		}, false},
+		{"password after the agent", []bool{false}, false, func(socket string) []sshe.Option {
+			return []sshe.Option{sshe.WithAgentSocket(socket), sshe.WithPassword("test")}
+		}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			socket, keyring := newAgent(t, server, tc.keys...)
+			if tc.envSock {
+				t.Setenv("SSH_AUTH_SOCK", socket)
+			}
+			process, err := nescript.NewScript("echo signed").Cmd().Exec(newKeyExecutor(server, tc.opts(socket)...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := process.Result()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.StdOut != "signed\n" {
+				t.Errorf("expected the script run, got %q", result.StdOut)
+			}
+			if used := keyring.signs.Load() > 0; used != tc.signed {
+				t.Errorf("expected the agent to sign %t, got %t", tc.signed, used)
+			}
+		})
+	}
+}
+
+func TestAgentUnavailable(t *testing.T) {
+	server := newTestServer(t)
+	emptySocket, _ := newAgent(t, server)
+	for _, tc := range []struct {
+		name    string
+		env     string
+		opts    []sshe.Option
+		message string
+	}{
+		{"SSH_AUTH_SOCK not set", "", []sshe.Option{sshe.WithAgent()}, "SSH_AUTH_SOCK is not set"},
+		{"unreachable", "", []sshe.Option{sshe.WithAgentSocket(filepath.Join(t.TempDir(), "missing.sock"))}, "failed to connect to agent"},
+		{"no keys", "", []sshe.Option{sshe.WithAgentSocket(emptySocket)}, "holds no keys"},
+		{"no keys at SSH_AUTH_SOCK", emptySocket, []sshe.Option{sshe.WithAgent()}, "holds no keys"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("SSH_AUTH_SOCK", tc.env)
+			_, err := nescript.NewScript("echo ran").Cmd().Exec(newKeyExecutor(server, tc.opts...))
+			if !errors.Is(err, sshe.ErrAuthentication) || !errors.Is(err, sshe.ErrAgentUnavailable) {
+				t.Fatalf("expected ErrAuthentication wrapping ErrAgentUnavailable, got %v", err)
+			}
+			if !strings.Contains(err.Error(), tc.message) {
+				t.Errorf("expected %q in the error, got %v", tc.message, err)
+			}
+		})
+	}
+	t.Run("unauthorized keys", func(t *testing.T) {
+		socket, keyring := newAgent(t, server, false)
+		_, err := nescript.NewScript("echo ran").Cmd().Exec(newKeyExecutor(server, sshe.WithAgentSocket(socket)))
+		if !errors.Is(err, sshe.ErrAuthentication) || errors.Is(err, sshe.ErrAgentUnavailable) {
+			t.Errorf("expected ErrAuthentication from the server, got %v", err)
+		}
+		if keyring.signs.Load() > 0 {
+			t.Errorf("expected no signature for a key the server does not accept")
+		}
+	})
+}
@@ -0,0 +1,118 @@
+package sshe
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// legacyRSA are the host key algorithms using SHA-1 signatures, which are only
+// accepted with WithLegacyRSA.
+var legacyRSA = []string{ssh.KeyAlgoRSA, ssh.CertAlgoRSAv01}
+
+// The algorithms implemented by golang.org/x/crypto/ssh, including those not
+// enabled by default as they are considered weak. Older devices may only speak
+// these.
+var (
+	supportedCiphers = []string{
+		"aes128-gcm@openssh.com", "aes256-gcm@openssh.com",
+		"chacha20-poly1305@openssh.com",
+		"aes128-ctr", "aes192-ctr", "aes256-ctr",
+		"aes128-cbc", "3des-cbc",
+		"arcfour256", "arcfour128", "arcfour",
+	}
+	supportedKeyExchanges = []string{
+		"curve25519-sha256", "curve25519-sha256@libssh.org",
+		"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+		"diffie-hellman-group14-sha256", "diffie-hellman-group16-sha512",
+		"diffie-hellman-group-exchange-sha256",
+		"diffie-hellman-group14-sha1", "diffie-hellman-group-exchange-sha1",
+		"diffie-hellman-group1-sha1",
+	}
+	supportedHostKeyAlgorithms = []string{
+		ssh.CertAlgoED25519v01,
+		ssh.CertAlgoECDSA256v01, ssh.CertAlgoECDSA384v01, ssh.CertAlgoECDSA521v01,
+		ssh.CertAlgoRSASHA512v01, ssh.CertAlgoRSASHA256v01,
+		ssh.KeyAlgoED25519,
+		ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+		ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256,
+	}
+	supportedMACs = []string{
+		"hmac-sha2-256-etm@openssh.com", "hmac-sha2-512-etm@openssh.com",
+		"hmac-sha2-256", "hmac-sha2-512",
+		"hmac-sha1", "hmac-sha1-96",
+	}
+)
+
+// algorithms are the transport algorithms to offer the target, in order of
+// preference. Nil uses the defaults of the client config.
+type algorithms struct {
+	ciphers      []string
+	keyExchanges []string
+	macs         []string
+	hostKeys     []string
+	legacyRSA    bool
+}
+
+// validate checks that each of the algorithms is implemented, so that a typo
+// is reported before connecting rather than as a handshake failure.
+func (a algorithms) validate() error {
+	for _, kind := range []struct {
+		name      string
+		given     []string
+		supported []string
+	}{
+		{"cipher", a.ciphers, supportedCiphers},
+		{"key exchange", a.keyExchanges, supportedKeyExchanges},
+		{"mac", a.macs, supportedMACs},
+		{"host key", a.hostKeys, supportedHostKeyAlgorithms},
+	} {
+		for _, algorithm := range kind.given {
+			if kind.name == "host key" && slices.Contains(legacyRSA, algorithm) {
+				if !a.legacyRSA {
+					return fmt.Errorf("%w: host key '%s' uses SHA-1 signatures, see WithLegacyRSA", ErrUnsupportedAlgorithm, algorithm)
+				}
+			} else if !slices.Contains(kind.supported, algorithm) {
+				return fmt.Errorf("%w: %s '%s'", ErrUnsupportedAlgorithm, kind.name, algorithm)
+			}
+		}
+	}
+	return nil
+}
+
+// hostKeyAlgorithms returns the host key algorithms to offer the target, or
+// nil for the defaults of the client config. With legacy RSA, ssh-rsa is
+// offered after the others if not given explicitly.
+func (a algorithms) hostKeyAlgorithms() []string {
+	if !a.legacyRSA {
+		return a.hostKeys
+	}
+	hostKeys := a.hostKeys
+	if hostKeys == nil {
+		hostKeys = supportedHostKeyAlgorithms
+	}
+	hostKeys = slices.Clone(hostKeys)
+	for _, algorithm := range legacyRSA {
+		if !slices.Contains(hostKeys, algorithm) {
+			hostKeys = append(hostKeys, algorithm)
+		}
+	}
+	return hostKeys
+}
+
+// validateClientVersion checks that the version is a valid SSH-2.0
+// identification string (RFC 4253 section 4.2).
+func validateClientVersion(version string) error {
+	if !strings.HasPrefix(version, "SSH-2.0-") || len(version) > 253 || strings.ContainsAny(version, "\r\n") {
+		return fmt.Errorf("invalid client version '%s': must start with 'SSH-2.0-' and be a single line", version)
+	}
+	return nil
+}
+
+// isNegotiationError reports whether the handshake failed as the client and
+// target have no algorithm in common. The error lists those each side offered.
+func isNegotiationError(err error) bool {
+	return strings.Contains(err.Error(), "no common algorithm for")
+}
@@ -0,0 +1,146 @@
+package sshe_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/sshe"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestPasswordNotInErrors(t *testing.T) {
+	server := newTestServer(t)
+	host, portString, _ := net.SplitHostPort(server.Addr)
+	port, _ := strconv.Atoi(portString)
+	executor := sshe.NewExecutor(host, port, "test", sshe.WithPassword("hunter2"), sshe.WithHostKeyMode(sshe.HostKeyInsecure))
+	_, err := nescript.NewScript("echo ok").Cmd().Exec(executor)
+	if err == nil {
+		t.Fatal("expected the incorrect password to fail authentication")
+	}
+	for _, format := range []string{"%v", "%+v", "%#v", "%s", "%q"} {
+		if formatted := fmt.Sprintf(format, err); strings.Contains(formatted, "hunter2") {
+			t.Errorf("expected the password redacted with %s, got %s", format, formatted)
+		}
+	}
+}
+
+// newKey generates an ed25519 key, returning its public key and its PEM,
+// encrypted with the passphrase if not empty.
+func newKey(t testing.TB, passphrase string) (ssh.PublicKey, []byte) {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var block *pem.Block
+	if passphrase != "" {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(private, "test", []byte(passphrase))
+	} else {
+		block, err = ssh.MarshalPrivateKey(private, "test")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, pem.EncodeToMemory(block)
+}
+
+// newKeyExecutor returns an executor for the server authenticating as the
+// test user with the options.
+func newKeyExecutor(server *testServer, opts ...sshe.Option) nescript.ExecFunc {
+	host, portString, _ := net.SplitHostPort(server.Addr)
+	port, _ := strconv.Atoi(portString)
+	return sshe.NewExecutor(host, port, "test", append([]sshe.Option{sshe.WithHostKeyMode(sshe.HostKeyInsecure)}, opts...)...)
+}
+
+func TestPrivateKey(t *testing.T) {
+	server := newTestServer(t)
+	key, keyPEM := newKey(t, "")
+	encryptedKey, encryptedPEM := newKey(t, "correct horse")
+	server.Authorize(key)
+	server.Authorize(encryptedKey)
+	dir := t.TempDir()
+	keyFile, encryptedFile := filepath.Join(dir, "id_ed25519"), filepath.Join(dir, "id_encrypted")
+	os.WriteFile(keyFile, keyPEM, 0o600)
+	os.WriteFile(encryptedFile, encryptedPEM, 0o600)
+	for _, tc := range []struct {
+		name string
+		opts []sshe.Option
+	}{
+		{"key", []sshe.Option{sshe.WithPrivateKey(keyPEM, "")}},
+		{"encrypted key", []sshe.Option{sshe.WithPrivateKey(encryptedPEM, "correct horse")}},
+		{"key file", []sshe.Option{sshe.WithPrivateKeyFile(keyFile, "")}},
+		{"encrypted key file", []sshe.Option{sshe.WithPrivateKeyFile(encryptedFile, "correct horse")}},
+		{"after an unauthorized key", []sshe.Option{sshe.WithPrivateKey(func() []byte { _, pem := newKey(t, ""); return pem }(), ""), sshe.WithPrivateKey(keyPEM, "")}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			process, err := nescript.NewScript("echo $USER-ok; echo oops >&2; exit 3").Cmd().Exec(newKeyExecutor(server, tc.opts...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := process.Result()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasSuffix(result.StdOut, "-ok\n") || result.StdErr != "oops\n" || result.ExitCode != 3 {
+				t.Errorf("expected the script's output and exit code, got %q, %q and %d", result.StdOut, result.StdErr, result.ExitCode)
+			}
+		})
+	}
+}
+
+func TestPrivateKeyErrors(t *testing.T) {
+	server := newTestServer(t)
+	key, keyPEM := newKey(t, "")
+	encryptedKey, encryptedPEM := newKey(t, "correct horse")
+	server.Authorize(key)
+	server.Authorize(encryptedKey)
+	_, unauthorizedPEM := newKey(t, "")
+	for _, tc := range []struct {
+		name    string
+		opts    []sshe.Option
+		message string
+	}{
+		{"unauthorized key", []sshe.Option{sshe.WithPrivateKey(unauthorizedPEM, "")}, "unable to authenticate"},
+		{"wrong passphrase", []sshe.Option{sshe.WithPrivateKey(encryptedPEM, "battery staple")}, "failed to parse ssh private key"},
+		{"missing passphrase", []sshe.Option{sshe.WithPrivateKey(encryptedPEM, "")}, "passphrase protected"},
+		{"not a key", []sshe.Option{sshe.WithPrivateKey([]byte("not a key"), "")}, "failed to parse ssh private key"},
+		{"missing key file", []sshe.Option{sshe.WithPrivateKeyFile(filepath.Join(t.TempDir(), "id_ed25519"), "")}, "no such file"},
+		{"other user", []sshe.Option{sshe.WithPrivateKey(keyPEM, ""), sshe.WithUser("root")}, "as 'root'"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := nescript.NewScript("echo ran").Cmd().Exec(newKeyExecutor(server, tc.opts...))
+			if !errors.Is(err, sshe.ErrAuthentication) || errors.Is(err, sshe.ErrConnection) {
+				t.Fatalf("expected only ErrAuthentication, got %v", err)
+			}
+			if !strings.Contains(err.Error(), tc.message) {
+				t.Errorf("expected %q in the error, got %v", tc.message, err)
+			}
+			if strings.Contains(err.Error(), "correct horse") || strings.Contains(err.Error(), "battery staple") {
+				t.Errorf("expected the passphrase left out of the error, got %v", err)
+			}
+		})
+	}
+	t.Run("unreachable", func(t *testing.T) {
+		listener, _ := net.Listen("tcp", "127.0.0.1:0")
+		addr := listener.Addr().(*net.TCPAddr)
+		listener.Close()
+		_, err := nescript.NewScript("echo ran").Cmd().Exec(sshe.NewExecutor("127.0.0.1", addr.Port, "test", sshe.WithPrivateKey(keyPEM, ""), sshe.WithHostKeyMode(sshe.HostKeyInsecure)))
+		if !errors.Is(err, sshe.ErrConnection) || errors.Is(err, sshe.ErrAuthentication) {
+			t.Errorf("expected only ErrConnection, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,96 @@
+package sshe
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// certificateSigner returns a signer signing with the private key, presenting the certificate (in the authorized_keys format, such as an
+// id_ed25519-cert.pub file) issued for it. The certificate is checked to be a
+// currently valid user certificate for the key, so that an expired certificate
+// fails before connecting.
+func certificateSigner(certBytes, keyPEM []byte, passphrase string) (ssh.Signer, error) {
+	cert, err := parseCertificate(certBytes)
+	if err != nil {
+		return nil, err
+	}
+	if cert.CertType != ssh.UserCert {
+		return nil, fmt.Errorf("%w: ssh certificate '%s' is not a user certificate", ErrCertificateInvalid, cert.KeyId)
+	}
+	if err := checkValidity(cert, time.Now()); err != nil {
+		return nil, err
+	}
+	signer, err := privateKeySigner(keyPEM, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(cert.Key.Marshal(), signer.PublicKey().Marshal()) {
+		return nil, fmt.Errorf("%w: ssh certificate '%s' was not issued for the private key", ErrCertificateInvalid, cert.KeyId)
+	}
+	certSigner, err := ssh.NewCertSigner(cert, signer)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCertificateInvalid, err)
+	}
+	return certSigner, nil
+}
+
+func parseCertificate(certBytes []byte) (*ssh.Certificate, error) {
+	key, _, _, _, err := ssh.ParseAuthorizedKey(certBytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to parse ssh certificate: %w", ErrCertificateInvalid, err)
+	}
+	cert, ok := key.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("%w: '%s' key is not a certificate", ErrCertificateInvalid, key.Type())
+	}
+	return cert, nil
+}
+
+// checkValidity checks that the certificate is valid at the given time.
+func checkValidity(cert *ssh.Certificate, now time.Time) error {
+	unix := uint64(now.Unix())
+	if unix < cert.ValidAfter {
+		return fmt.Errorf("%w: ssh certificate '%s' is valid from %s", ErrCertificateNotYetValid, cert.KeyId, certTime(cert.ValidAfter))
+	}
+	if cert.ValidBefore != ssh.CertTimeInfinity && unix >= cert.ValidBefore {
+		return fmt.Errorf("%w: ssh certificate '%s' expired at %s", ErrCertificateExpired, cert.KeyId, certTime(cert.ValidBefore))
+	}
+	return nil
+}
+
+func certTime(t uint64) string {
+	return time.Unix(int64(t), 0).UTC().Format(time.RFC3339)
+}
+
+// hostCertificateCallback verifies host certificates against the certificate
+// authorities, falling back to the given callback for plain host keys.
+func hostCertificateCallback(authorities []ssh.PublicKey, fallback ssh.HostKeyCallback) ssh.HostKeyCallback {
+	checker := &ssh.CertChecker{
+		IsHostAuthority: func(auth ssh.PublicKey, address string) bool {
+			for _, authority := range authorities {
+				if bytes.Equal(auth.Marshal(), authority.Marshal()) {
+					return true
+				}
+			}
+			return false
+		},
+		HostKeyFallback: fallback,
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		cert, ok := key.(*ssh.Certificate)
+		if !ok {
+			return checker.CheckHostKey(hostname, remote, key)
+		}
+		if err := checkValidity(cert, time.Now()); err != nil {
+			return fmt.Errorf("%w: host '%s': %w", ErrHostCertificateInvalid, hostname, err)
+		}
+		if err := checker.CheckHostKey(hostname, remote, key); err != nil {
+			return fmt.Errorf("%w: host '%s': %w", ErrHostCertificateInvalid, hostname, err)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,126 @@
+package sshe
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/internal/shellquote"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// MetadataWarnings is the result metadata key recording problems that did not
+// fail the execution, such as an uploaded script that could not be removed.
+const MetadataWarnings = "sshe.warnings"
+
+// WarningsFrom returns the warnings recorded on a result produced by an SSH
+// executor, if any.
+func WarningsFrom(r *nescript.Result) []string {
+	warnings, _ := r.Metadata[MetadataWarnings].([]string)
+	return warnings
+}
+
+// CleanupRemoteTemp removes the scripts uploaded to the target (see
+// WithUpload) more than olderThan ago, which were left behind as their
+// process was never closed, or the connection was lost and the target could
+// not be connected to again. The target and config are as given to Executor,
+// with the options giving the upload dir (see WithUploadDir), the transfer
+// method (see WithTransfer) and how to connect. Without SFTP, the directory is
+// listed with ls and scripts removed with rm. The paths removed are returned,
+// along with the errors for any that could not be.
+func CleanupRemoteTemp(ctx context.Context, target string, olderThan time.Duration, config *ssh.ClientConfig, opts ...Option) ([]string, error) {
+	o := newOptions(config, opts)
+	conn, err := o.dial(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	platform, _ := o.targetPlatform(conn, target)
+	dir := defaultUploadDir
+	if o.upload != nil && o.upload.dir != "" {
+		dir = o.upload.dir
+	} else if platform.Windows() {
+		dir = windowsUploadDir
+	}
+	transfer := TransferAuto
+	if o.upload != nil && o.upload.transfer != "" {
+		transfer = o.upload.transfer
+	}
+	var names []string
+	remove := func(file string) error { return removeSCP(conn.client, file) }
+	if transfer == TransferSCP {
+		names, err = listRemote(ctx, conn.client, dir)
+	} else if client, sftpErr := sftp.NewClient(conn.client); sftpErr == nil {
+		defer client.Close()
+		stop := context.AfterFunc(ctx, func() { client.Close() })
+		defer stop()
+		names, err = listSFTP(client, dir)
+		remove = client.Remove
+	} else if transfer == TransferAuto && !platform.Windows() {
+		names, err = listRemote(ctx, conn.client, dir)
+	} else {
+		return nil, fmt.Errorf("%w: %w", ErrSFTPUnavailable, sftpErr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list upload dir '%s': %w", dir, err)
+	}
+	removed := []string{}
+	var errs []error
+	for _, name := range names {
+		uploaded, ok := uploadedAt(name)
+		if !ok || time.Since(uploaded) < olderThan || ctx.Err() != nil {
+			continue
+		}
+		file := path.Join(dir, name)
+		if err := remove(file); err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove '%s': %w", file, err))
+			continue
+		}
+		removed = append(removed, file)
+	}
+	if ctx.Err() != nil {
+		return removed, &ContextError{Phase: PhaseExecute, Err: ctx.Err(), cause: errors.Join(errs...)}
+	}
+	return removed, errors.Join(errs...)
+}
+
+// listSFTP returns the names of the regular files in the directory.
+func listSFTP(client *sftp.Client, dir string) ([]string, error) {
+	entries, err := client.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, entry := range entries {
+		if entry.Mode().IsRegular() {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+// listRemote returns the names of the files in the directory using ls, for
+// targets without SFTP. Directories are also listed, however rm (without -r)
+// refuses to remove them.
+func listRemote(ctx context.Context, client *ssh.Client, dir string) ([]string, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+	stop := context.AfterFunc(ctx, func() { session.Close() })
+	defer stop()
+	var stderr bytes.Buffer
+	session.Stderr = &stderr
+	output, err := session.Output("ls -1a -- " + shellquote.POSIX(dir))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.Fields(string(output)), nil
+}
@@ -0,0 +1,92 @@
+package sshe
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// clientConfig builds the SSH client config from the options. If a config was
+// given, a copy of it is extended by the options, otherwise a new config is
+// built, verifying host keys against the known_hosts file. The returned func
+// releases resources held by the authentication methods, and must be called
+// once connected.
+func (o *options) clientConfig() (*ssh.ClientConfig, func(), error) {
+	config := &ssh.ClientConfig{}
+	if o.config != nil {
+		*config = *o.config
+		config.Auth = append([]ssh.AuthMethod{}, o.config.Auth...)
+	}
+	if o.user != "" {
+		config.User = o.user
+	}
+	if err := o.algorithms.validate(); err != nil {
+		return nil, nil, err
+	}
+	if o.algorithms.ciphers != nil {
+		config.Ciphers = o.algorithms.ciphers
+	}
+	if o.algorithms.keyExchanges != nil {
+		config.KeyExchanges = o.algorithms.keyExchanges
+	}
+	if o.algorithms.macs != nil {
+		config.MACs = o.algorithms.macs
+	}
+	if hostKeys := o.algorithms.hostKeyAlgorithms(); hostKeys != nil {
+		config.HostKeyAlgorithms = hostKeys
+	}
+	if o.clientVersion != "" {
+		if err := validateClientVersion(o.clientVersion); err != nil {
+			return nil, nil, err
+		}
+		config.ClientVersion = o.clientVersion
+	}
+	closers := make([]io.Closer, 0)
+	release := func() {
+		for _, closer := range closers {
+			closer.Close()
+		}
+	}
+	var unavailable error
+	var signers []ssh.Signer
+	publicKeys := -1
+	for _, source := range o.auth {
+		method, closer, err := source()
+		if errors.Is(err, ErrAgentUnavailable) || errors.Is(err, errIdentitySkipped) {
+			unavailable = err
+			continue
+		} else if err != nil {
+			release()
+			return nil, nil, fmt.Errorf("%w: %w", ErrAuthentication, err)
+		}
+		if closer != nil {
+			closers = append(closers, closer)
+		}
+		if method.method != nil {
+			config.Auth = append(config.Auth, method.method)
+			continue
+		}
+		if publicKeys < 0 {
+			publicKeys = len(config.Auth)
+			config.Auth = append(config.Auth, nil)
+		}
+		signers = append(signers, method.signers...)
+	}
+	if publicKeys >= 0 {
+		config.Auth[publicKeys] = ssh.PublicKeys(signers...)
+	}
+	if unavailable != nil && len(config.Auth) == 0 {
+		return nil, nil, fmt.Errorf("%w: %w", ErrAuthentication, unavailable)
+	}
+	if config.HostKeyCallback == nil {
+		callback, err := o.hostKeyCallback()
+		if err != nil {
+			release()
+			return nil, nil, err
+		}
+		config.HostKeyCallback = callback
+	}
+	return config, release, nil
+}
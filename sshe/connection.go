@@ -0,0 +1,166 @@
+package sshe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/neaas/nescript"
+	"golang.org/x/crypto/ssh"
+)
+
+// Connection is a persistent (lazily established) connection to an SSH
+// target, over which any number of scripts can be executed, each in its own
+// session. This avoids the dial, handshake and authentication for every
+// execution. Concurrent executions share the connection. If the connection is
+// found to be lost before an execution, it is re-established.
+type Connection struct {
+	target string
+	opts   *options
+
+	mu      sync.Mutex
+	conn    *connection
+	dialing chan struct{}
+	closed  bool
+}
+
+// Connect creates a Connection to the SSH target at the given address
+// (host:port), configured in the same way as Executor. Nothing is dialed until
+// the first execution. The connection must be closed once no longer needed.
+func Connect(target string, config *ssh.ClientConfig, opts ...Option) *Connection {
+	return &Connection{
+		target: target,
+		opts:   newOptions(config, opts),
+	}
+}
+
+// Executor provides an ExecFunc that executes the script/cmd over the
+// connection. See the package level Executor.
+func (conn *Connection) Executor() nescript.ExecFunc {
+	return func(c nescript.Cmd) (nescript.Process, error) {
+		if err := conn.opts.validateStdin(); err != nil {
+			return nil, err
+		}
+		var process nescript.Process
+		err := conn.opts.retry.do(c.Context(), conn.target, func() error {
+			shared, err := conn.get(c.Context())
+			if err != nil {
+				return err
+			}
+			process, err = conn.opts.start(c, conn.target, shared, func() {}, conn.isClosed)
+			if errors.Is(err, ErrSessionLimit) && conn.opts.sessions.overflow {
+				process, err = conn.overflow(c)
+			}
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		return process, nil
+	}
+}
+
+// Close closes the connection. Scripts still running over it are terminated,
+// and their results fail with ErrConnectionClosed, as do any later executions.
+func (conn *Connection) Close() error {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	conn.closed = true
+	if conn.conn == nil {
+		return nil
+	}
+	err := conn.conn.Close()
+	conn.conn = nil
+	return err
+}
+
+// get returns the established connection, dialing it if not yet connected or
+// if the connection has been lost. The lock is not held while dialing, so that
+// a dial only holds up the executions waiting for it, which then use the
+// connection it established (or dial again themselves, if it failed).
+func (conn *Connection) get(ctx context.Context) (*connection, error) {
+	for {
+		conn.mu.Lock()
+		if conn.closed {
+			conn.mu.Unlock()
+			return nil, fmt.Errorf("%w: can not execute on '%s'", ErrConnectionClosed, conn.target)
+		}
+		if conn.conn != nil && conn.conn.alive() {
+			established := conn.conn
+			conn.mu.Unlock()
+			return established, nil
+		} else if conn.conn != nil {
+			conn.conn.Close()
+			conn.conn = nil
+		}
+		if dialing := conn.dialing; dialing != nil {
+			conn.mu.Unlock()
+			select {
+			case <-dialing:
+				continue
+			case <-ctx.Done():
+				return nil, &ContextError{Phase: PhaseConnect, Err: ctx.Err()}
+			}
+		}
+		dialing := make(chan struct{})
+		conn.dialing = dialing
+		conn.mu.Unlock()
+		established, err := conn.opts.dial(ctx, conn.target)
+		conn.mu.Lock()
+		defer conn.mu.Unlock()
+		conn.dialing = nil
+		close(dialing)
+		if err != nil {
+			return nil, err
+		}
+		if conn.closed {
+			established.Close()
+			return nil, fmt.Errorf("%w: can not execute on '%s'", ErrConnectionClosed, conn.target)
+		}
+		conn.conn = established
+		return established, nil
+	}
+}
+
+// overflow starts the cmd over an additional connection to the target, as the
+// shared connection has no session available. The additional connection is
+// closed along with the process.
+func (conn *Connection) overflow(c nescript.Cmd) (nescript.Process, error) {
+	extra, err := conn.opts.dial(c.Context(), conn.target)
+	if err != nil {
+		return nil, err
+	}
+	return conn.opts.start(c, conn.target, extra, func() { extra.Close() }, conn.isClosed)
+}
+
+// Platform returns the platform of the target, detecting it if not already
+// detected with WithOSDetection (otherwise it is that given by the options).
+// The error is that of connecting to the target, as failing to detect the
+// platform gives the fallback platform.
+func (conn *Connection) Platform(ctx context.Context) (Platform, error) {
+	established, err := conn.get(ctx)
+	if err != nil {
+		return "", err
+	}
+	platform, _ := conn.opts.targetPlatform(established, conn.target)
+	return platform, nil
+}
+
+// Queued returns the number of executions waiting for a session on the
+// connection, as the target refused to open more (see WithSessionQueue).
+func (conn *Connection) Queued() int {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.conn == nil {
+		return 0
+	}
+	return conn.conn.queued()
+}
+
+// isClosed reports whether the connection has been closed.
+func (conn *Connection) isClosed() bool {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	return conn.closed
+}
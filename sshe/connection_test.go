@@ -0,0 +1,114 @@
+package sshe_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/sshe"
+)
+
+// run executes the script, returning its stdout. Failures are reported with
+// Error, so that it can be called from other goroutines.
+func run(t testing.TB, executor nescript.ExecFunc, script string) string {
+	t.Helper()
+	process, err := nescript.NewScript(script).Cmd().Exec(executor)
+	if err != nil {
+		t.Error(err)
+		return ""
+	}
+	result, err := process.Result()
+	if err != nil {
+		t.Error(err)
+		return ""
+	}
+	return result.StdOut
+}
+
+func TestConnectionSharesOneConnection(t *testing.T) {
+	server := newTestServer(t)
+	conn := sshe.Connect(server.Addr, server.Config)
+	defer conn.Close()
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if stdout := run(t, conn.Executor(), "echo ok"); stdout != "ok\n" {
+				t.Errorf("expected 'ok', got %q", stdout)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := server.Connections(); n != 1 {
+		t.Errorf("expected the executions to share 1 connection, got %d", n)
+	}
+	if n := server.Sessions(); n != 8 {
+		t.Errorf("expected a session per execution, got %d", n)
+	}
+}
+
+func TestConnectionRedialsLostConnection(t *testing.T) {
+	server := newTestServer(t)
+	conn := sshe.Connect(server.Addr, server.Config)
+	defer conn.Close()
+	run(t, conn.Executor(), "true")
+	server.Drop()
+	// the client notices the connection closing asynchronously.
+	time.Sleep(100 * time.Millisecond)
+	if stdout := run(t, conn.Executor(), "echo ok"); stdout != "ok\n" {
+		t.Errorf("expected 'ok', got %q", stdout)
+	}
+	if n := server.Connections(); n != 2 {
+		t.Errorf("expected the lost connection dialed again, got %d connections", n)
+	}
+}
+
+func TestConnectionClose(t *testing.T) {
+	server := newTestServer(t)
+	conn := sshe.Connect(server.Addr, server.Config)
+	process, err := nescript.NewScript("sleep 10").Cmd().Exec(conn.Executor())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if _, err := process.Result(); !errors.Is(err, sshe.ErrConnectionClosed) {
+		t.Errorf("expected the running script to fail with ErrConnectionClosed, got %v", err)
+	}
+	if _, err := nescript.NewScript("true").Cmd().Exec(conn.Executor()); !errors.Is(err, sshe.ErrConnectionClosed) {
+		t.Errorf("expected a later execution to fail with ErrConnectionClosed, got %v", err)
+	}
+}
+
+// BenchmarkExecute compares dialing the target for every execution with
+// reusing a Connection, sequentially and concurrently.
+func BenchmarkExecute(b *testing.B) {
+	server := newTestServer(b)
+	b.Run("dial per execution", func(b *testing.B) {
+		executor := sshe.Executor(server.Addr, server.Config)
+		b.ReportAllocs()
+		for range b.N {
+			run(b, executor, "true")
+		}
+	})
+	b.Run("connection", func(b *testing.B) {
+		conn := sshe.Connect(server.Addr, server.Config)
+		defer conn.Close()
+		b.ReportAllocs()
+		for range b.N {
+			run(b, conn.Executor(), "true")
+		}
+	})
+	b.Run("connection concurrent", func(b *testing.B) {
+		conn := sshe.Connect(server.Addr, server.Config)
+		defer conn.Close()
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				run(b, conn.Executor(), "true")
+			}
+		})
+	})
+}
@@ -0,0 +1,185 @@
+package sshe
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/neaas/nescript"
+	"golang.org/x/crypto/ssh"
+)
+
+// connection is an SSH connection to the target, along with the connections to
+// any jump hosts it was dialed through.
+type connection struct {
+	client *ssh.Client
+	hops   []*ssh.Client
+
+	stop      chan struct{}
+	closeOnce sync.Once
+	lost      atomic.Bool
+	down      chan struct{}
+
+	sessions sessions
+}
+
+// Close closes the connection to the target, followed by each jump host.
+func (c *connection) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.stop)
+	})
+	err := c.client.Close()
+	for i := len(c.hops) - 1; i >= 0; i-- {
+		c.hops[i].Close()
+	}
+	return err
+}
+
+// dial connects and authenticates to the target, through each of the jump
+// hosts in turn. Cancelling the context (or the connect timeout elapsing)
+// aborts the dial, at whichever hop it has reached.
+func (o *options) dial(ctx context.Context, target string) (*connection, error) {
+	ctx, cancel := withTimeout(ctx, o.connectTimeout)
+	defer cancel()
+	conn, err := o.dialHops(ctx, target)
+	if err != nil && ctx.Err() != nil {
+		return nil, &ContextError{Phase: PhaseConnect, Err: ctx.Err(), cause: err}
+	}
+	return conn, err
+}
+
+func (o *options) dialHops(ctx context.Context, target string) (*connection, error) {
+	conn := &connection{
+		stop: make(chan struct{}),
+	}
+	var previous *ssh.Client
+	for i, hop := range o.jumpHosts {
+		client, err := dialHop(ctx, previous, hop.address, hop.opts)
+		if err != nil {
+			conn.closeHops()
+			return nil, fmt.Errorf("jump host %d ('%s'): %w", i+1, hop.address, err)
+		}
+		conn.hops = append(conn.hops, client)
+		previous = client
+	}
+	client, err := dialHop(ctx, previous, target, o)
+	if err != nil {
+		conn.closeHops()
+		return nil, err
+	}
+	conn.client = client
+	conn.down = make(chan struct{})
+	go func() {
+		client.Wait()
+		close(conn.down)
+	}()
+	if o.keepaliveInterval > 0 {
+		go conn.keepalive(o.keepaliveInterval, o.keepaliveMaxMissed)
+	}
+	return conn, nil
+}
+
+// closeHops closes the connections to the jump hosts.
+func (c *connection) closeHops() {
+	for i := len(c.hops) - 1; i >= 0; i-- {
+		c.hops[i].Close()
+	}
+}
+
+// dialHop connects and authenticates to a single SSH server, either directly
+// or, if via is not nil, via a direct-tcpip channel of the previous hop.
+func dialHop(ctx context.Context, via *ssh.Client, address string, o *options) (*ssh.Client, error) {
+	config, release, err := o.clientConfig()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	var netConn net.Conn
+	if via == nil {
+		netConn, err = (&net.Dialer{Timeout: config.Timeout}).DialContext(ctx, "tcp", address)
+	} else {
+		netConn, err = via.DialContext(ctx, "tcp", address)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w '%s': %w", ErrConnection, address, err)
+	}
+	stop := context.AfterFunc(ctx, func() {
+		netConn.Close()
+	})
+	defer stop()
+	sshConn, chans, reqs, err := ssh.NewClientConn(netConn, address, config)
+	if err != nil {
+		netConn.Close()
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("%w '%s': %w", ErrConnection, address, nescript.ContextError(ctx.Err()))
+		}
+		if isAuthError(err) {
+			return nil, fmt.Errorf("%w: failed to authenticate to ssh target '%s' as '%s': %w", ErrAuthentication, address, config.User, err)
+		}
+		if isNegotiationError(err) {
+			return nil, fmt.Errorf("%w with ssh target '%s': %w", ErrNoCommonAlgorithm, address, err)
+		}
+		return nil, fmt.Errorf("%w '%s': %w", ErrConnection, address, err)
+	}
+	return ssh.NewClient(sshConn, chans, reqs), nil
+}
+
+// alive reports whether the connection to the target is still usable, as it
+// has neither been closed nor found lost by keepalives (see WithKeepalive). No
+// request is sent, so that checking before every reuse does not wait on the
+// target.
+func (c *connection) alive() bool {
+	if c.lost.Load() {
+		return false
+	}
+	select {
+	case <-c.down:
+		return false
+	default:
+		return true
+	}
+}
+
+// keepalive pings the target every interval. Once maxMissed pings in a row
+// have gone unanswered (within the interval), the connection is considered
+// lost and torn down, so that executions fail rather than hang.
+func (c *connection) keepalive(interval time.Duration, maxMissed int) {
+	if maxMissed <= 0 {
+		maxMissed = 1
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	missed := 0
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+		}
+		replied := make(chan error, 1)
+		go func() {
+			_, _, err := c.client.SendRequest("keepalive@openssh.com", true, nil)
+			replied <- err
+		}()
+		select {
+		case <-c.stop:
+			return
+		case err := <-replied:
+			if err == nil {
+				missed = 0
+				continue
+			}
+			missed = maxMissed
+		case <-time.After(interval):
+			missed++
+		}
+		if missed >= maxMissed {
+			c.lost.Store(true)
+			c.Close()
+			return
+		}
+	}
+}
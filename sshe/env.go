@@ -0,0 +1,93 @@
+package sshe
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/neaas/nescript/internal/shellquote"
+	"golang.org/x/crypto/ssh"
+)
+
+// MetadataEnvStrategy is the result metadata key recording the EnvStrategy
+// that was used to give the script its env, if it had any.
+const MetadataEnvStrategy = "sshe.envStrategy"
+
+// EnvStrategy determines how the env vars of a cmd are given to the script.
+type EnvStrategy string
+
+const (
+	// EnvAuto sets the env vars on the session, falling back to exporting them
+	// in the command if the target rejects any (as only those listed in the
+	// server's AcceptEnv are accepted). This is the default.
+	EnvAuto EnvStrategy = "auto"
+
+	// EnvSetenv only sets the env vars on the session. If the target rejects
+	// any, the execution fails.
+	EnvSetenv EnvStrategy = "setenv"
+
+	// EnvExport exports the env vars in the command executed by the login shell,
+	// which must be a POSIX shell. Note that their values are then visible in
+	// the target's process list while the shell starts.
+	EnvExport EnvStrategy = "export"
+)
+
+// envNameRegex matches the env var names that can be exported by a POSIX
+// shell.
+var envNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// setEnv gives the env vars to the session with the strategy, returning those
+// that must instead be exported by the command, along with the strategy that
+// was effectively used.
+func setEnv(session *ssh.Session, env []string, strategy EnvStrategy) ([]string, EnvStrategy, error) {
+	if len(env) == 0 {
+		return nil, "", nil
+	}
+	if strategy == EnvExport {
+		return env, EnvExport, validateExports(env)
+	}
+	var exports []string
+	for _, e := range env {
+		key, value, ok := strings.Cut(e, "=")
+		if !ok {
+			return nil, "", fmt.Errorf("invalid env var '%s'", e)
+		}
+		// any failure is taken as the target refusing the env var, as a session
+		// that failed instead fails to start the command regardless.
+		err := session.Setenv(key, value)
+		if err != nil && strategy == EnvAuto {
+			exports = append(exports, e)
+		} else if err != nil {
+			return nil, "", fmt.Errorf("failed to set env var '%s': %w", key, err)
+		}
+	}
+	if len(exports) == 0 {
+		return nil, EnvSetenv, nil
+	}
+	return exports, EnvExport, validateExports(exports)
+}
+
+func validateExports(env []string) error {
+	for _, e := range env {
+		key, _, ok := strings.Cut(e, "=")
+		if !ok {
+			return fmt.Errorf("invalid env var '%s'", e)
+		}
+		if !envNameRegex.MatchString(key) {
+			return fmt.Errorf("env var '%s' can not be exported by the shell, as its name is invalid", key)
+		}
+	}
+	return nil
+}
+
+// exportPrelude returns the shell commands exporting the env vars, with their
+// values quoted.
+func exportPrelude(env []string) string {
+	prelude := ""
+	for _, e := range env {
+		if key, value, ok := strings.Cut(e, "="); ok {
+			prelude += fmt.Sprintf("export %s=%s; ", key, shellquote.POSIX(value))
+		}
+	}
+	return prelude
+}
@@ -0,0 +1,50 @@
+package sshe_test
+
+import (
+	"testing"
+
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/sshe"
+)
+
+func TestEnvStrategy(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		rejectEnv bool
+		strategy  sshe.EnvStrategy
+		used      sshe.EnvStrategy
+		fails     bool
+	}{
+		{"auto accepted", false, sshe.EnvAuto, sshe.EnvSetenv, false},
+		{"auto rejected", true, sshe.EnvAuto, sshe.EnvExport, false},
+		{"setenv rejected", true, sshe.EnvSetenv, "", true},
+		{"export", false, sshe.EnvExport, sshe.EnvExport, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := newTestServer(t)
+			server.RejectEnv = tc.rejectEnv
+			cmd := nescript.NewScript(`echo "$GREETING"`).Cmd().WithEnv("GREETING=hello")
+			process, err := cmd.Exec(sshe.Executor(server.Addr, server.Config, sshe.WithEnvStrategy(tc.strategy)))
+			if tc.fails {
+				if err == nil {
+					process.Close()
+					t.Fatal("expected the rejected env var to fail the execution")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := process.Result()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.StdOut != "hello\n" {
+				t.Errorf("expected the env var given to the script, got %q", result.StdOut)
+			}
+			if used := result.Metadata[sshe.MetadataEnvStrategy]; used != tc.used {
+				t.Errorf("expected the %s strategy used, got %v", tc.used, used)
+			}
+		})
+	}
+}
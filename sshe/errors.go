@@ -0,0 +1,135 @@
+package sshe
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/neaas/nescript"
+)
+
+var (
+	// ErrConnection is returned (wrapped) when the SSH target could not be
+	// connected to, such as when it is unreachable or the SSH handshake fails.
+	ErrConnection = nescript.NewError(nescript.ErrConnection, "failed to connect to ssh target")
+
+	// ErrAuthentication is returned (wrapped) when the SSH target was reached,
+	// however none of the authentication methods given were accepted.
+	ErrAuthentication = errors.New("ssh authentication failed")
+
+	// ErrAgentUnavailable is returned (wrapped) when authenticating with an SSH
+	// agent, however the agent could not be reached or holds no keys, and no
+	// other authentication method was given.
+	ErrAgentUnavailable = errors.New("ssh agent unavailable")
+
+	// ErrCertificateExpired is returned (wrapped) when the certificate given to
+	// authenticate with (see WithCertificate) has expired. This is checked
+	// before connecting.
+	ErrCertificateExpired = errors.New("ssh certificate has expired")
+
+	// ErrCertificateNotYetValid is returned (wrapped) when the certificate given
+	// to authenticate with is not valid until a later time.
+	ErrCertificateNotYetValid = errors.New("ssh certificate is not yet valid")
+
+	// ErrCertificateInvalid is returned (wrapped) when the certificate given to
+	// authenticate with could not be parsed, is not a user certificate, or was
+	// not issued for the private key given with it.
+	ErrCertificateInvalid = errors.New("ssh certificate is invalid")
+
+	// ErrHostCertificateInvalid is returned (wrapped) when the target presents
+	// a host certificate that is not signed by a trusted authority (see
+	// WithHostCertificateAuthority), has expired, or does not name the host.
+	ErrHostCertificateInvalid = errors.New("ssh host certificate is invalid")
+
+	// ErrUnknownHostKey is returned (wrapped) when the target's host key is not
+	// in known_hosts, and unknown keys are not accepted (see HostKeyMode).
+	ErrUnknownHostKey = errors.New("ssh host key is unknown")
+
+	// ErrHostKeyMismatch is returned (wrapped) when the target presents a host
+	// key that differs from the one in known_hosts.
+	ErrHostKeyMismatch = errors.New("ssh host key does not match known_hosts")
+
+	// ErrConnectionClosed is returned (wrapped) when executing with a Connection
+	// that has been closed, including by scripts that were still running when
+	// it was closed.
+	ErrConnectionClosed = errors.New("ssh connection closed")
+
+	// ErrSFTPUnavailable is returned (wrapped) when scripts must be uploaded
+	// (see WithUpload), however the target does not provide the SFTP subsystem.
+	ErrSFTPUnavailable = errors.New("sftp unavailable on target")
+
+	// ErrUnsupportedOnWindows is returned (wrapped) when an option is given that
+	// is only supported by unix targets, however the target is windows.
+	ErrUnsupportedOnWindows = errors.New("option is not supported on windows targets")
+
+	// ErrExitStatusMissing is returned (wrapped) when the target closed the
+	// script's session without reporting its exit status or signal, so the
+	// outcome of the script is unknown.
+	ErrExitStatusMissing = errors.New("ssh session closed without an exit status")
+
+	// ErrSkipped is recorded in a FanOutError for the hosts a cmd was not
+	// executed on, as an earlier host failed and fail-fast was requested, or the
+	// fan-out was cancelled first.
+	ErrSkipped = errors.New("skipped")
+
+	// ErrWorkDirNotFound is returned (wrapped) when the work dir scripts should
+	// be executed from (see WithRemoteWorkDir) does not exist on the target, or
+	// could not be entered or created. The script is not run.
+	ErrWorkDirNotFound = errors.New("ssh remote work dir not found")
+
+	// ErrUnsupportedAlgorithm is returned (wrapped) when a cipher, key exchange
+	// or MAC algorithm is given (see WithCiphers, WithKeyExchanges and WithMACs)
+	// that is not implemented by the SSH client. This is checked before
+	// connecting.
+	ErrUnsupportedAlgorithm = errors.New("ssh algorithm not supported")
+
+	// ErrNoCommonAlgorithm is returned (wrapped) when the client and target
+	// have no cipher, key exchange, MAC or host key algorithm in common. The
+	// error lists the algorithms each side offered.
+	ErrNoCommonAlgorithm = errors.New("no common ssh algorithm")
+
+	// ErrStdinConflict is returned (wrapped) when stdin is given (see WithStdin)
+	// along with a sudo password (see WithSudoPassword), as the password is
+	// also sent to the script's stdin, or when the stdin reader was already
+	// consumed by another execution.
+	ErrStdinConflict = errors.New("ssh stdin conflict")
+
+	// ErrSessionLimit is returned (wrapped) when the target refused to open a
+	// session for the script, as too many are already open on the connection
+	// (such as OpenSSH's MaxSessions), and no session was freed in time (see
+	// WithSessionQueue and WithSessionOverflow).
+	ErrSessionLimit = errors.New("ssh session limit reached")
+
+	// ErrForward is returned (wrapped) when a remote forward (see
+	// WithRemoteForward) could not be set up, such as when the remote port is
+	// already in use or the target does not permit forwarding.
+	ErrForward = errors.New("ssh remote forward failed")
+
+	// ErrConnectionLost is returned (wrapped) when the connection to the target
+	// stopped responding to keepalives (see WithKeepalive) while a script was
+	// running.
+	ErrConnectionLost = errors.New("ssh connection lost")
+
+	// ErrSudoIncorrectPassword is returned (wrapped) when sudo rejected the
+	// password given with WithSudoPassword.
+	ErrSudoIncorrectPassword = errors.New("sudo password incorrect")
+
+	// ErrSudoNotPermitted is returned (wrapped) when the SSH user is not
+	// allowed to use sudo (as the requested user).
+	ErrSudoNotPermitted = errors.New("sudo not permitted")
+
+	// ErrSudoPasswordRequired is returned (wrapped) when sudo requires a
+	// password, however none was given (see WithSudoPassword).
+	ErrSudoPasswordRequired = errors.New("sudo password required")
+
+	// ErrExecution is returned (wrapped) when connected and authenticated,
+	// however the script could not be executed, or its exit status could not be
+	// determined. A script exiting with a non-zero code is not an error, the code
+	// is given on the result.
+	ErrExecution = errors.New("ssh execution failed")
+)
+
+// isAuthError reports whether an error from establishing an SSH connection was
+// caused by authentication failing.
+func isAuthError(err error) bool {
+	return strings.Contains(err.Error(), "unable to authenticate")
+}
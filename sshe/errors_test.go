@@ -0,0 +1,103 @@
+package sshe_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/sshe"
+)
+
+// closedAddr returns the address of a port nothing is listening on.
+func closedAddr(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.Close()
+	return listener.Addr().String()
+}
+
+// silentAddr returns the address of a listener which accepts connections,
+// however never completes the SSH handshake.
+func silentAddr(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestErrors(t *testing.T) {
+	server := newTestServer(t)
+	for _, tc := range []struct {
+		name   string
+		addr   string
+		opts   []sshe.Option
+		cancel time.Duration
+		script string
+		is     []error
+		phase  sshe.Phase
+		class  string
+	}{
+		{"refused", closedAddr(t), nil, 0, "echo ok", []error{sshe.ErrConnection, nescript.ErrConnection}, "", nescript.ErrorClassConnection},
+		{"connect timeout", silentAddr(t), []sshe.Option{sshe.WithConnectTimeout(100 * time.Millisecond)}, 0, "echo ok", []error{nescript.ErrTimeout, context.DeadlineExceeded}, sshe.PhaseConnect, nescript.ErrorClassTimeout},
+		{"exec timeout", server.Addr, []sshe.Option{sshe.WithExecTimeout(100 * time.Millisecond)}, 0, "sleep 10", []error{nescript.ErrTimeout, context.DeadlineExceeded}, sshe.PhaseExecute, nescript.ErrorClassTimeout},
+		{"cancelled", server.Addr, nil, 200 * time.Millisecond, "sleep 10", []error{nescript.ErrCancelled, context.Canceled}, sshe.PhaseExecute, nescript.ErrorClassCancelled},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tc.cancel > 0 {
+				time.AfterFunc(tc.cancel, cancel)
+			}
+			cmd := nescript.NewScript(tc.script).Cmd().WithContext(ctx)
+			var result *nescript.Result
+			process, err := cmd.Exec(sshe.Executor(tc.addr, server.Config, tc.opts...))
+			if err == nil {
+				result, err = process.Result()
+			}
+			for _, target := range tc.is {
+				if !errors.Is(err, target) {
+					t.Errorf("expected %v to match %v", err, target)
+				}
+			}
+			var contextErr *sshe.ContextError
+			if tc.phase != "" && (!errors.As(err, &contextErr) || contextErr.Phase != tc.phase) {
+				t.Errorf("expected a *ContextError for the %s phase, got %v", tc.phase, err)
+			}
+			if class := nescript.ErrorClass(err); class != tc.class {
+				t.Errorf("expected the class %s, got %s (result %v)", tc.class, class, result)
+			}
+		})
+	}
+}
+
+func TestExitError(t *testing.T) {
+	server := newTestServer(t)
+	process, err := nescript.NewScript("echo failed >&2; exit 3").Cmd().Exec(sshe.Executor(server.Addr, server.Config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := process.Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var exitErr *nescript.ExitError
+	if !errors.As(result.Err(), &exitErr) || exitErr.Result.StdErr != "failed\n" || exitErr.Result.ExitCode != 3 {
+		t.Errorf("expected an *ExitError holding the result, got %v", result.Err())
+	}
+}
@@ -2,54 +2,225 @@ package sshe
 
 import (
 	"fmt"
+	"net"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/internal/shellquote"
 	"golang.org/x/crypto/ssh"
 )
 
 // Executor provides an ExecFunc that will start the script/cmd process on an
 // SSH target. The target must be provided in the form of ip:port along with the
 // ssh client config, specifing factors such as HostKeyAuth and the Auth method.
-// As executing a command over SSH must be done by passing a single string, this
-// ExecFunc will convert the given cmd/script to a string, thus this will use
-// the formatter associated with the cmd/script.
-func Executor(target string, config *ssh.ClientConfig) nescript.ExecFunc {
+// Options may be given to extend the config, such as with further
+// authentication methods. As executing a command over SSH must be done by
+// passing a single string, this ExecFunc will convert the given cmd/script to a
+// string, thus this will use the formatter associated with the cmd/script
+// (unless a shell is given, see WithShell).
+func Executor(target string, config *ssh.ClientConfig, opts ...Option) nescript.ExecFunc {
+	return executor(target, newOptions(config, opts))
+}
+
+// NewExecutor provides an ExecFunc that will start the script/cmd process on
+// the SSH target at the given host and port, authenticating as the given user.
+// Authentication methods are given as options, such as WithPrivateKeyFile, and
+// host keys are verified against ~/.ssh/known_hosts unless configured
+// otherwise. See Executor.
+func NewExecutor(host string, port int, user string, opts ...Option) nescript.ExecFunc {
+	target := net.JoinHostPort(host, strconv.Itoa(port))
+	return executor(target, newOptions(nil, append([]Option{WithUser(user)}, opts...)))
+}
+
+func executor(target string, o *options) nescript.ExecFunc {
 	return func(c nescript.Cmd) (nescript.Process, error) {
-		process := SSHProcess{}
-		sshClient, err := ssh.Dial("tcp", target, config)
+		if err := o.validateStdin(); err != nil {
+			return nil, err
+		}
+		var process nescript.Process
+		err := o.retry.do(c.Context(), target, func() error {
+			conn, err := o.dial(c.Context(), target)
+			if err != nil {
+				return err
+			}
+			process, err = o.start(c, target, conn, func() { conn.Close() }, nil)
+			return err
+		})
 		if err != nil {
-			return nil, fmt.Errorf("failed to connect to ssh target '%s': %w", target, err)
+			return nil, err
+		}
+		return process, nil
+	}
+}
+
+// start starts the cmd in a new session on the connection. The release func is
+// called once the process is closed, and the closed func (if not nil) reports
+// whether the connection was closed from under the process.
+func (o *options) start(c nescript.Cmd, target string, conn *connection, release func(), closed func() bool) (nescript.Process, error) {
+	process := SSHProcess{
+		conn:      conn,
+		release:   release,
+		closed:    closed,
+		stop:      o.stop,
+		normalize: o.normalize,
+	}
+	platform, warning := o.targetPlatform(conn, target)
+	windows := platform.Windows()
+	if windows {
+		if err := o.validateWindows(); err != nil {
+			release()
+			return nil, err
 		}
-		process.sshClient = sshClient
-		sshSession, err := sshClient.NewSession()
+	}
+	process.windows = windows
+	if encoding, ok := o.outputEncoding(windows); ok {
+		if _, err := nescript.DecodeOutput("", encoding); err != nil {
+			release()
+			return nil, err
+		}
+		process.encoding = &encoding
+	}
+	if o.detectOS {
+		process.platform = platform
+	}
+	if warning != "" {
+		process.warnings = append(process.warnings, warning)
+	}
+	env := c.Env()
+	var err error
+	if len(o.forwards) > 0 {
+		if process.forwarding, err = o.forward(conn); err != nil {
+			release()
+			return nil, err
+		}
+		env = append(append([]string{}, env...), process.forwarding.env()...)
+	}
+	file, err := o.uploadScript(c.Context(), target, conn, c, env, windows)
+	if err != nil {
+		process.cleanup()
+		return nil, err
+	}
+	process.uploaded = file
+	sshSession, err := conn.newSession(c.Context(), target, o.sessions)
+	if err != nil {
+		process.cleanup()
+		return nil, fmt.Errorf("%w: failed to create ssh session on target '%s': %w", ErrExecution, target, err)
+	}
+	process.sshSession = sshSession
+	// windows does not accept env vars from the client, they are set by the
+	// PowerShell script instead. With sudo, which resets the env, they are
+	// exported within the elevated shell.
+	var exports []string
+	if !windows && o.sudo == nil {
+		exports, process.envStrategy, err = setEnv(sshSession, env, o.env)
 		if err != nil {
-			sshClient.Close()
-			return nil, fmt.Errorf("failed to create ssh session on target '%s': %w", target, err)
-		}
-		process.sshSession = sshSession
-		for _, e := range c.Env() {
-			envVar := strings.Split(e, "=")
-			if len(envVar) != 2 {
-				process.Close()
-				return nil, fmt.Errorf("invalid env var '%s'", e)
-			}
-			if err := sshSession.Setenv(envVar[0], envVar[1]); err != nil {
-				process.Close()
-				return nil, fmt.Errorf("failed to set env var '%s': %w", e, err)
-			}
+			process.Close()
+			return nil, err
 		}
-		sshSession.Stdout = &process.stdoutBytes
-		sshSession.Stderr = &process.stderrBytes
-		if stdin, err := sshSession.StdinPipe(); err != nil {
-			return nil, fmt.Errorf("failed to create stdin pipe: %w", err)
-		} else {
-			process.stdin = stdin
+	} else if !windows && len(env) > 0 {
+		process.envStrategy = EnvExport
+	}
+	if o.pty != nil {
+		if err := o.pty.request(sshSession); err != nil {
+			process.Close()
+			return nil, err
 		}
-		if err := sshSession.Start(c.String()); err != nil {
+		process.pty = true
+	}
+	process.done = make(chan struct{})
+	process.watched = make(chan struct{})
+	if !windows && (o.stop.kill || o.sudo != nil || o.workDir != nil) {
+		if process.launch, err = newLaunch(o.sudo); err != nil {
 			process.Close()
-			return nil, fmt.Errorf("process failed to start: %w", err)
+			return nil, err
 		}
-		return &process, nil
+		process.launch.done = process.done
+	}
+	sshSession.Stdout, sshSession.Stderr = o.streams(c, &process)
+	stdin, err := sshSession.StdinPipe()
+	if err != nil {
+		process.Close()
+		return nil, fmt.Errorf("failed to create stdin pipe: %w", err)
+	}
+	process.stdin = stdin
+	command, script := o.command(c, env, file, windows, process.launch)
+	if process.launch != nil {
+		process.launch.stdin, process.launch.script = stdin, script
+	}
+	if o.sudo != nil {
+		process.sudo = o.sudo
+		stdin = process.launch.Stdin()
+		process.stdin = stdin
+	}
+	// once the command is sent, the script may have started, so failures from
+	// here are never retried.
+	if err := sshSession.Start(exportPrelude(exports) + command); err != nil {
+		process.Close()
+		return nil, noRetry{fmt.Errorf("%w: process failed to start: %w", ErrExecution, err)}
+	}
+	if o.workDir != nil {
+		process.workDir = o.workDir.path
+	}
+	if o.stdin != nil {
+		process.stdinPipe = pipeStdin(stdin, o.stdin)
+	}
+	process.started = time.Now()
+	go func() {
+		process.waitErr = sshSession.Wait()
+		process.ended = time.Now()
+		if process.launch != nil {
+			process.launch.finish()
+		}
+		for _, w := range process.lineWriters {
+			w.flush()
+		}
+		close(process.done)
+	}()
+	ctx, cancel := withTimeout(c.Context(), o.execTimeout)
+	go process.watch(ctx, cancel)
+	process.completion = nescript.NewCompletion(process.collect)
+	process.completion.Start()
+	return &process, nil
+}
+
+// command converts the cmd into the command string executed on the target. If
+// the script was uploaded, the uploaded file is executed. Otherwise, if a shell
+// is set and the cmd was created from a script, the script is passed to the
+// shell quoted, else the cmd's formatter is used. With a work dir, the command
+// is run from within it. With a launch (see launch), the launch's start line is
+// written just before the command is exec'd, so that with remote kill the PID
+// written is that of the script. With sudo, this is instead the script the
+// elevated shell reads from stdin, which is returned along with the command
+// starting the elevated shell, so that the PID is that of the elevated script
+// rather than sudo. On windows targets, the cmd is always run with PowerShell.
+// The env is that of the cmd, along with any set by the executor.
+func (o *options) command(c nescript.Cmd, env []string, file *uploaded, windows bool, l *launch) (command, script string) {
+	if windows && file != nil {
+		return fileCommand(o.windowsShell(), file.path), ""
+	} else if windows {
+		return encodedCommand(o.windowsShell(), powershellScript(c, env)), ""
+	}
+	command = c.String()
+	if file != nil {
+		command = file.command(c, o.shell)
+	} else if _, script, trailing, ok := c.Script(); o.shell != nil && ok {
+		parts := append([]string{}, o.shell...)
+		parts = append(parts, shellquote.POSIX(script))
+		for _, arg := range trailing {
+			parts = append(parts, shellquote.POSIX(arg))
+		}
+		command = strings.Join(parts, " ")
+	}
+	if l != nil {
+		command = l.startLine(o.stop.kill) + "exec " + command
+	}
+	if o.workDir != nil {
+		command = o.workDir.prelude() + command
+	}
+	if o.sudo != nil {
+		return o.sudo.command(l, o.pty != nil), o.sudo.script(command, env, o.pty != nil)
 	}
+	return command, ""
 }
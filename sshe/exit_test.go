@@ -0,0 +1,72 @@
+package sshe_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/local"
+	"github.com/neaas/nescript/sshe"
+)
+
+func TestExitStatus(t *testing.T) {
+	server := newTestServer(t)
+	for _, tc := range []struct {
+		name     string
+		script   string
+		exitCode int
+		signal   string
+	}{
+		{"exit 0", "exit 0", 0, ""},
+		{"exit 3", "exit 3", 3, ""},
+		{"exit above 128", "exit 137", 137, ""},
+		{"SIGKILL", "kill -KILL $$", 137, "SIGKILL"},
+		{"SIGTERM", "kill -TERM $$", 143, "SIGTERM"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			process, err := nescript.NewScript(tc.script).Cmd().Exec(sshe.Executor(server.Addr, server.Config))
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := process.Result()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.ExitCode != tc.exitCode || result.Signaled != (tc.signal != "") || result.Signal != tc.signal {
+				t.Errorf("expected exit code %d and signal %q, got %d, %t and %q", tc.exitCode, tc.signal, result.ExitCode, result.Signaled, result.Signal)
+			}
+			if result.Success() != (tc.exitCode == 0) {
+				t.Errorf("expected success to be %t, got %t", tc.exitCode == 0, result.Success())
+			}
+			// the outcome is the same as that of the local executor.
+			process, err = nescript.NewScript(tc.script).Cmd().Exec(local.Executor(""))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := process.Result()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.ExitCode != want.ExitCode || result.Signaled != want.Signaled || result.Signal != want.Signal {
+				t.Errorf("expected the local executor's %d, %t and %q, got %d, %t and %q", want.ExitCode, want.Signaled, want.Signal, result.ExitCode, result.Signaled, result.Signal)
+			}
+		})
+	}
+}
+
+func TestExitStatusMissing(t *testing.T) {
+	server := newTestServer(t)
+	server.NoExitStatus = true
+	process, err := nescript.NewScript("echo ran").Cmd().Exec(sshe.Executor(server.Addr, server.Config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := process.Result()
+	if !errors.Is(err, sshe.ErrExitStatusMissing) || result != nil {
+		t.Errorf("expected %v without a result, got %v and %v", sshe.ErrExitStatusMissing, err, result)
+	}
+	var exitErr *nescript.ExitError
+	if errors.As(err, &exitErr) {
+		t.Errorf("expected the missing status not to be an exit error, got %v", err)
+	}
+}
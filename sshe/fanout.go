@@ -0,0 +1,280 @@
+package sshe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/neaas/nescript"
+	"golang.org/x/crypto/ssh"
+)
+
+// Host is an SSH target a cmd is fanned out to (see ExecAll).
+type Host struct {
+	// Name identifies the host in the results. If empty, the address is used.
+	Name string
+
+	// Address is the target, in the form host:port.
+	Address string
+
+	// Config is the client config for the host, which may be nil if the
+	// options provide the user and authentication.
+	Config *ssh.ClientConfig
+
+	// Options are applied for this host only, after those given to ExecAll.
+	Options []Option
+
+	// Fields are template fields merged onto the script's fields for this host
+	// only, replacing any of the same key (see ExecScriptAll).
+	Fields map[string]any
+
+	// Env are env vars in KEY=VALUE format merged onto the script's env for
+	// this host only, replacing any of the same key (see ExecScriptAll).
+	Env []string
+}
+
+func (h Host) name() string {
+	if h.Name == "" {
+		return h.Address
+	}
+	return h.Name
+}
+
+// Outcome is how the execution on a host of a fan-out ended.
+type Outcome string
+
+const (
+	// OutcomeSucceeded means the script exited successfully, with a zero exit
+	// code or one of its success codes (see nescript.SuccessCodes).
+	OutcomeSucceeded Outcome = "succeeded"
+
+	// OutcomeFailed means the script exited unsuccessfully, or could not be
+	// executed (or its result collected).
+	OutcomeFailed Outcome = "failed"
+
+	// OutcomeTimedOut means the host timeout (see WithHostTimeout) or the
+	// overall fan-out deadline passed before the script completed.
+	OutcomeTimedOut Outcome = "timed out"
+
+	// OutcomeSkipped means the script was not executed on the host, as an
+	// earlier host failed with fail-fast enabled, or the fan-out was cancelled
+	// (or its deadline passed) before the host was reached.
+	OutcomeSkipped Outcome = "skipped"
+)
+
+// HostResult is the outcome of the execution on one host of a fan-out. Either
+// the result or the error is set. With ExecScriptAll, Script is the script as
+// compiled for the host (with the host's fields and env), for auditing.
+type HostResult struct {
+	Host     string
+	Outcome  Outcome
+	Result   *nescript.Result
+	Err      error
+	Duration time.Duration
+	Script   *nescript.Script
+}
+
+// FanOutResults are the outcomes of a fan-out, keyed by host name, along with
+// the number of hosts with each outcome.
+type FanOutResults struct {
+	Hosts map[string]HostResult
+
+	Succeeded int
+	Failed    int
+	TimedOut  int
+	Skipped   int
+}
+
+// ProgressFunc is called as each host of a fan-out completes (or is skipped),
+// with the number of hosts completed so far and the total. Calls are not made
+// concurrently.
+type ProgressFunc func(result HostResult, completed, total int)
+
+// FanOutError is returned by ExecAll when the cmd could not be executed on, or
+// its result collected from, one or more of the hosts. Errors is keyed by host
+// name.
+type FanOutError struct {
+	Errors map[string]error
+}
+
+func (e *FanOutError) Error() string {
+	hosts := make([]string, 0, len(e.Errors))
+	for host := range e.Errors {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	messages := make([]string, len(hosts))
+	for i, host := range hosts {
+		messages[i] = fmt.Sprintf("host '%s': %s", host, e.Errors[host])
+	}
+	return fmt.Sprintf("failed on %d host(s): %s", len(hosts), strings.Join(messages, "; "))
+}
+
+func (e *FanOutError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// ExecAll executes the cmd on every host, waiting for each to complete. By
+// default, the cmd is executed on one host at a time; see WithConcurrency. Each
+// host is given up to the host timeout (see WithHostTimeout) to connect and
+// complete, and the fan-out as a whole is limited by the cmd's context and
+// WithFanOutTimeout, so an unreachable host never blocks the rest. A failure on
+// one host does not stop the others unless WithFailFast is given. The outcome
+// of every host is returned; if any errored, a *FanOutError is also returned.
+// The options are applied to the executor used for each host, before the
+// host's own options.
+func ExecAll(hosts []Host, c nescript.Cmd, opts ...Option) (*FanOutResults, error) {
+	o := newOptions(nil, opts)
+	return o.fanOut(c.Context(), hosts, func(ctx context.Context, host Host) HostResult {
+		return o.execHost(ctx, host, c, opts)
+	})
+}
+
+// ExecScriptAll executes the script on every host as with ExecAll, however the
+// script is compiled separately for each host, with the host's fields and env
+// (see Host) merged onto the script's own. Values given for the host replace
+// those of the same key given to the script. The script as compiled for each
+// host is recorded on its result. The fan-out is limited by the given context.
+func ExecScriptAll(ctx context.Context, hosts []Host, script nescript.Script, opts ...Option) (*FanOutResults, error) {
+	o := newOptions(nil, opts)
+	return o.fanOut(ctx, hosts, func(ctx context.Context, host Host) HostResult {
+		compiled, err := hostScript(script, host).Compile()
+		if err != nil {
+			return HostResult{Host: host.name(), Outcome: OutcomeFailed, Err: err}
+		}
+		result := o.execHost(ctx, host, compiled.Cmd(), opts)
+		result.Script = &compiled
+		return result
+	})
+}
+
+// hostScript returns the script with the host's fields and env merged onto its
+// own. The script's data is copied on write, so scripts for each host can be
+// created concurrently, while keeping its templating, required and secret
+// fields and logger.
+func hostScript(script nescript.Script, host Host) nescript.Script {
+	return script.WithFields(host.Fields, true).WithMergedEnv(host.Env...)
+}
+
+// fanOut runs the func for every host, with the concurrency, timeouts and fail
+// fast behavior of the options.
+func (o *options) fanOut(ctx context.Context, hosts []Host, run func(context.Context, Host) HostResult) (*FanOutResults, error) {
+	names := make(map[string]bool, len(hosts))
+	for _, host := range hosts {
+		if names[host.name()] {
+			return nil, fmt.Errorf("duplicate host '%s'", host.name())
+		}
+		names[host.name()] = true
+	}
+	ctx, cancel := withTimeout(ctx, o.fanOutTimeout)
+	defer cancel()
+	concurrency := o.concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		failed  atomic.Bool
+		results = &FanOutResults{Hosts: make(map[string]HostResult, len(hosts))}
+		slots   = make(chan struct{}, concurrency)
+	)
+	record := func(result HostResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		results.Hosts[result.Host] = result
+		switch result.Outcome {
+		case OutcomeSucceeded:
+			results.Succeeded++
+		case OutcomeFailed:
+			results.Failed++
+		case OutcomeTimedOut:
+			results.TimedOut++
+		case OutcomeSkipped:
+			results.Skipped++
+		}
+		if o.progress != nil {
+			o.progress(result, len(results.Hosts), len(hosts))
+		}
+	}
+	for _, host := range hosts {
+		slots <- struct{}{}
+		if err := ctx.Err(); err != nil || (o.failFast && failed.Load()) {
+			<-slots
+			if err == nil {
+				err = ErrSkipped
+			} else {
+				err = fmt.Errorf("%w: %w", ErrSkipped, err)
+			}
+			record(HostResult{Host: host.name(), Outcome: OutcomeSkipped, Err: err})
+			continue
+		}
+		wg.Add(1)
+		go func(host Host) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			result := run(ctx, host)
+			if result.Outcome != OutcomeSucceeded {
+				failed.Store(true)
+			}
+			record(result)
+		}(host)
+	}
+	wg.Wait()
+	errs := make(map[string]error)
+	for name, result := range results.Hosts {
+		if result.Err != nil {
+			errs[name] = result.Err
+		}
+	}
+	if len(errs) > 0 {
+		return results, &FanOutError{Errors: errs}
+	}
+	return results, nil
+}
+
+// execHost executes the cmd on the host, within the host timeout.
+func (o *options) execHost(ctx context.Context, host Host, c nescript.Cmd, opts []Option) HostResult {
+	ctx, cancel := withTimeout(ctx, o.hostTimeout)
+	defer cancel()
+	start := time.Now()
+	hostOpts := newOptions(host.Config, append(append([]Option{}, opts...), host.Options...))
+	result, err := execResult(executor(host.Address, hostOpts), c.WithContext(ctx))
+	outcome := HostResult{
+		Host:     host.name(),
+		Result:   result,
+		Err:      err,
+		Duration: time.Since(start),
+	}
+	var contextErr *ContextError
+	switch {
+	case errors.As(err, &contextErr) && errors.Is(err, context.DeadlineExceeded):
+		outcome.Outcome = OutcomeTimedOut
+	case err != nil || !result.Success():
+		outcome.Outcome = OutcomeFailed
+	default:
+		outcome.Outcome = OutcomeSucceeded
+	}
+	return outcome
+}
+
+// execResult executes the cmd and waits for its result.
+func execResult(executor nescript.ExecFunc, c nescript.Cmd) (*nescript.Result, error) {
+	process, err := c.Exec(executor)
+	if err != nil {
+		return nil, err
+	}
+	defer process.Close()
+	return process.Result()
+}
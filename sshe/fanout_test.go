@@ -0,0 +1,71 @@
+package sshe_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/sshe"
+)
+
+func TestExecScriptAllCompilesPerHost(t *testing.T) {
+	server := newTestServer(t)
+	hosts := make([]sshe.Host, 3)
+	for i := range hosts {
+		hosts[i] = sshe.Host{
+			Name:    fmt.Sprintf("node-%d", i),
+			Address: server.Addr,
+			Config:  server.Config,
+			Fields:  map[string]any{"NodeIndex": i},
+			Env:     []string{fmt.Sprintf("ROLE=role-%d", i)},
+		}
+	}
+	script := nescript.NewScript("echo [[.NodeIndex]] $ROLE $TZ [[.token]] {{.kept}}").
+		WithDelims("[[", "]]").
+		WithRequiredFields("NodeIndex").
+		WithSecretField("token", "s3cr3t").
+		WithEnv("ROLE=base", "TZ=UTC")
+	results, err := sshe.ExecScriptAll(context.Background(), hosts, script, sshe.WithConcurrency(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, host := range hosts {
+		result := results.Hosts[host.Name]
+		if result.Outcome != sshe.OutcomeSucceeded {
+			t.Fatalf("%s: expected success, got %s: %v", host.Name, result.Outcome, result.Err)
+		}
+		expected := fmt.Sprintf("%d role-%d UTC s3cr3t {{.kept}}\n", i, i)
+		if result.Result.StdOut != expected {
+			t.Errorf("%s: expected stdout %q, got %q", host.Name, expected, result.Result.StdOut)
+		}
+		if env := result.Script.Env(); len(env) != 2 || env[0] != fmt.Sprintf("ROLE=role-%d", i) {
+			t.Errorf("%s: expected the host's env to replace the script's, got %v", host.Name, env)
+		}
+		if strings.Contains(result.Script.String(), "s3cr3t") {
+			t.Errorf("%s: expected the secret redacted from the script, got %s", host.Name, result.Script)
+		}
+	}
+	if env := script.Env(); len(env) != 2 || env[0] != "ROLE=base" {
+		t.Errorf("expected the script's env to be unchanged, got %v", env)
+	}
+}
+
+func TestExecScriptAllKeepsRequiredFields(t *testing.T) {
+	server := newTestServer(t)
+	hosts := []sshe.Host{{Name: "missing", Address: server.Addr, Config: server.Config}}
+	script := nescript.NewScript("echo {{.NodeIndex}}").WithRequiredFields("NodeIndex")
+	results, err := sshe.ExecScriptAll(context.Background(), hosts, script)
+	var fanOutErr *sshe.FanOutError
+	if !errors.As(err, &fanOutErr) {
+		t.Fatalf("expected a *FanOutError, got %v", err)
+	}
+	if result := results.Hosts["missing"]; !errors.Is(result.Err, nescript.ErrMissingField) {
+		t.Errorf("expected the host missing a required field to fail to compile, got %v", result.Err)
+	}
+	if len(server.Commands()) != 0 {
+		t.Errorf("expected nothing executed, got %v", server.Commands())
+	}
+}
@@ -0,0 +1,138 @@
+package sshe
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// forwardEnvPrefix prefixes the env vars the remote addresses of forwards are
+// given to scripts in, followed by the index of the forward.
+const forwardEnvPrefix = "NESCRIPT_FORWARD_"
+
+// remoteForward is a port on the target forwarded to an address reachable from
+// the caller.
+type remoteForward struct {
+	remoteAddr string
+	localAddr  string
+}
+
+// forwarding are the listeners on the target for the forwards of an execution,
+// along with the connections being forwarded through them.
+type forwarding struct {
+	listeners []net.Listener
+	addresses []string
+
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+// forward starts listening on the target for each of the forwards. Connections
+// made to them are forwarded to their local address until the forwarding is
+// closed.
+func (o *options) forward(conn *connection) (*forwarding, error) {
+	f := &forwarding{conns: make(map[net.Conn]struct{})}
+	for _, forward := range o.forwards {
+		listener, err := conn.client.Listen("tcp", forward.remoteAddr)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%w: failed to listen on '%s' on the target: %w", ErrForward, forward.remoteAddr, err)
+		}
+		f.listeners = append(f.listeners, listener)
+		f.addresses = append(f.addresses, listener.Addr().String())
+		go f.serve(listener, forward.localAddr)
+	}
+	return f, nil
+}
+
+// env returns the env vars holding the addresses listened on for the forwards,
+// in the order the forwards were given.
+func (f *forwarding) env() []string {
+	env := make([]string, len(f.addresses))
+	for i, address := range f.addresses {
+		env[i] = forwardEnvPrefix + strconv.Itoa(i) + "=" + address
+	}
+	return env
+}
+
+func (f *forwarding) serve(listener net.Listener, localAddr string) {
+	for {
+		remote, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go f.pipe(remote, localAddr)
+	}
+}
+
+// pipe forwards the connection from the target to the local address.
+func (f *forwarding) pipe(remote net.Conn, localAddr string) {
+	local, err := net.Dial("tcp", localAddr)
+	if err != nil {
+		remote.Close()
+		return
+	}
+	if !f.track(remote, local) {
+		return
+	}
+	defer f.untrack(remote, local)
+	done := make(chan struct{})
+	go func() {
+		io.Copy(local, remote)
+		close(done)
+	}()
+	io.Copy(remote, local)
+	<-done
+}
+
+// track records the connections so that they are closed with the forwarding,
+// closing them immediately if it already has been.
+func (f *forwarding) track(conns ...net.Conn) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		for _, c := range conns {
+			c.Close()
+		}
+		return false
+	}
+	for _, c := range conns {
+		f.conns[c] = struct{}{}
+	}
+	return true
+}
+
+func (f *forwarding) untrack(conns ...net.Conn) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, c := range conns {
+		c.Close()
+		delete(f.conns, c)
+	}
+}
+
+// Close stops listening on the target, and closes any connections still being
+// forwarded.
+func (f *forwarding) Close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	for _, listener := range f.listeners {
+		listener.Close()
+	}
+	for c := range f.conns {
+		c.Close()
+	}
+}
+
+// Forwards returns the addresses listened on the target for each of the remote
+// forwards (see WithRemoteForward), in the order they were given.
+func (p *SSHProcess) Forwards() []string {
+	if p.forwarding == nil {
+		return nil
+	}
+	return p.forwarding.addresses
+}
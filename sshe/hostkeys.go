@@ -0,0 +1,314 @@
+package sshe
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// HostKeyMode determines how the host keys presented by SSH targets are
+// verified against known_hosts.
+type HostKeyMode int
+
+const (
+	// HostKeyStrict only accepts host keys already in known_hosts. Unknown and
+	// changed keys fail. This is the default.
+	HostKeyStrict HostKeyMode = iota
+
+	// HostKeyAcceptNew trusts unknown host keys on first use, adding them to
+	// known_hosts, whereas changed keys still fail (like OpenSSH's
+	// StrictHostKeyChecking=accept-new).
+	HostKeyAcceptNew
+
+	// HostKeyInsecure accepts any host key without verification. This should
+	// only be used where the network between the executor and the target is
+	// trusted.
+	HostKeyInsecure
+)
+
+// HostKeyError describes a host key that failed verification. It wraps either
+// ErrUnknownHostKey or ErrHostKeyMismatch.
+type HostKeyError struct {
+	Host      string
+	Presented string
+	Expected  []string
+	err       error
+}
+
+func (e *HostKeyError) Error() string {
+	if len(e.Expected) == 0 {
+		return fmt.Sprintf("%s: host '%s' presented %s", e.err, e.Host, e.Presented)
+	}
+	return fmt.Sprintf("%s: host '%s' presented %s, expected %s", e.err, e.Host, e.Presented, strings.Join(e.Expected, " or "))
+}
+
+func (e *HostKeyError) Unwrap() error {
+	return e.err
+}
+
+// hostKeys verifies host keys, and records keys accepted on first use. It is
+// shared by every execution of an executor.
+type hostKeys struct {
+	mode        HostKeyMode
+	entries     []string
+	inMemory    bool
+	authorities []string
+
+	mu     sync.Mutex
+	parsed bool
+	known  []knownHost
+}
+
+// knownHost is an in-memory known_hosts entry, parsed once.
+type knownHost struct {
+	patterns []string
+	key      ssh.PublicKey
+	revoked  bool
+	line     int
+}
+
+// hostKeyCallback builds the host key callback for the configured mode.
+func (o *options) hostKeyCallback() (ssh.HostKeyCallback, error) {
+	if o.hostKeys.mode == HostKeyInsecure {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+	path := ""
+	if !o.hostKeys.inMemory {
+		path = o.knownHosts
+		if path == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return nil, fmt.Errorf("failed to find known_hosts file: %w", err)
+			}
+			path = filepath.Join(home, ".ssh", "known_hosts")
+		}
+	}
+	callback := o.hostKeys.callback(path)
+	if len(o.hostKeys.authorities) == 0 {
+		return callback, nil
+	}
+	authorities := make([]ssh.PublicKey, 0, len(o.hostKeys.authorities))
+	for _, line := range o.hostKeys.authorities {
+		authority, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse host certificate authority: %w", err)
+		}
+		authorities = append(authorities, authority)
+	}
+	return hostCertificateCallback(authorities, callback), nil
+}
+
+// callback returns a host key callback verifying against the known_hosts file
+// at the path, or the in-memory entries if the path is empty.
+func (h *hostKeys) callback(path string) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		check, err := h.load(path)
+		if err != nil {
+			return err
+		}
+		err = check(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) {
+			return err
+		}
+		hostErr := &HostKeyError{
+			Host:      hostname,
+			Presented: key.Type() + " " + ssh.FingerprintSHA256(key),
+			err:       ErrHostKeyMismatch,
+		}
+		for _, want := range keyErr.Want {
+			source := fmt.Sprintf("%s:%d", want.Filename, want.Line)
+			if path == "" {
+				source = fmt.Sprintf("entry %d", want.Line)
+			}
+			hostErr.Expected = append(hostErr.Expected, fmt.Sprintf("%s %s (%s)", want.Key.Type(), ssh.FingerprintSHA256(want.Key), source))
+		}
+		if len(keyErr.Want) > 0 {
+			return hostErr
+		}
+		if h.mode != HostKeyAcceptNew {
+			hostErr.err = ErrUnknownHostKey
+			return hostErr
+		}
+		return h.add(path, hostname, key)
+	}
+}
+
+// load reads the known_hosts file (or checks the in-memory entries). A missing
+// file is treated as empty in HostKeyAcceptNew mode.
+func (h *hostKeys) load(path string) (ssh.HostKeyCallback, error) {
+	if path == "" {
+		if err := h.parse(); err != nil {
+			return nil, err
+		}
+		return h.check, nil
+	} else if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) && h.mode == HostKeyAcceptNew {
+		return func(string, net.Addr, ssh.PublicKey) error {
+			return &knownhosts.KeyError{}
+		}, nil
+	}
+	check, err := knownhosts.New(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load known_hosts file '%s': %w", path, err)
+	}
+	return check, nil
+}
+
+// parse parses the in-memory entries, once. Certificate authority entries are
+// skipped, as authorities are given with WithHostCertificateAuthority.
+func (h *hostKeys) parse() error {
+	if h.parsed {
+		return nil
+	}
+	for i, line := range strings.Split(strings.Join(h.entries, "\n"), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		marker, hosts, key, _, _, err := ssh.ParseKnownHosts([]byte(line))
+		if err != nil {
+			return fmt.Errorf("failed to load known_hosts entry %d: %w", i+1, err)
+		}
+		if marker == "cert-authority" {
+			continue
+		}
+		h.known = append(h.known, knownHost{patterns: hosts, key: key, revoked: marker == "revoked", line: i + 1})
+	}
+	h.parsed = true
+	return nil
+}
+
+// check verifies the host key against the in-memory entries for the hostname
+// and remote address, failing as the knownhosts package does.
+func (h *hostKeys) check(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	addresses := []string{knownhosts.Normalize(hostname)}
+	if remote != nil && remote.String() != hostname {
+		if _, ok := remote.(*net.TCPAddr); ok {
+			addresses = append(addresses, knownhosts.Normalize(remote.String()))
+		}
+	}
+	var want []knownhosts.KnownKey
+	for _, known := range h.known {
+		if !slices.ContainsFunc(addresses, known.matches) {
+			continue
+		}
+		matched := bytes.Equal(known.key.Marshal(), key.Marshal())
+		if known.revoked && matched {
+			return &knownhosts.RevokedError{Revoked: knownhosts.KnownKey{Key: known.key, Line: known.line}}
+		} else if known.revoked {
+			continue
+		}
+		if matched {
+			return nil
+		}
+		want = append(want, knownhosts.KnownKey{Key: known.key, Line: known.line})
+	}
+	return &knownhosts.KeyError{Want: want}
+}
+
+// matches reports whether the entry's host patterns match the normalized
+// address, which none of its negated patterns may match.
+func (k knownHost) matches(address string) bool {
+	matched := false
+	for _, pattern := range k.patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		if hostMatches(strings.TrimPrefix(pattern, "!"), address) {
+			if negated {
+				return false
+			}
+			matched = true
+		}
+	}
+	return matched
+}
+
+// hostMatches reports whether the known_hosts host pattern, which may be hashed
+// (|1|salt|hash) or contain the wildcards * and ?, matches the address.
+func hostMatches(pattern, address string) bool {
+	if hashed, ok := strings.CutPrefix(pattern, "|1|"); ok {
+		salt, hash, ok := strings.Cut(hashed, "|")
+		if !ok {
+			return false
+		}
+		saltBytes, err := base64.StdEncoding.DecodeString(salt)
+		if err != nil {
+			return false
+		}
+		mac := hmac.New(sha1.New, saltBytes)
+		mac.Write([]byte(address))
+		return base64.StdEncoding.EncodeToString(mac.Sum(nil)) == hash
+	}
+	return wildcardMatch(pattern, address)
+}
+
+// wildcardMatch matches the string against the pattern, where * matches any
+// run of characters and ? any single character.
+func wildcardMatch(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := 0; i <= len(s); i++ {
+				if wildcardMatch(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		default:
+			if len(s) == 0 || pattern[0] != s[0] {
+				return false
+			}
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return len(s) == 0
+}
+
+// add records a host key accepted on first use, appending it to the
+// known_hosts file (or in-memory entries if the path is empty).
+func (h *hostKeys) add(path, hostname string, key ssh.PublicKey) error {
+	line := knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key)
+	if path == "" {
+		h.entries = append(h.entries, line)
+		h.known = append(h.known, knownHost{patterns: []string{knownhosts.Normalize(hostname)}, key: key, line: strings.Count(strings.Join(h.entries, "\n"), "\n") + 1})
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create known_hosts directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open known_hosts file '%s': %w", path, err)
+	}
+	defer file.Close()
+	if info, err := file.Stat(); err == nil && info.Size() > 0 && !endsWithNewline(path) {
+		line = "\n" + line
+	}
+	if _, err := file.WriteString(line + "\n"); err != nil {
+		return fmt.Errorf("failed to add host key to known_hosts file '%s': %w", path, err)
+	}
+	return nil
+}
+
+// endsWithNewline reports whether the file at the path ends with a newline.
+func endsWithNewline(path string) bool {
+	content, err := os.ReadFile(path)
+	return err != nil || len(content) == 0 || content[len(content)-1] == '\n'
+}
@@ -0,0 +1,104 @@
+package sshe_test
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"net"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/sshe"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// hashHost hashes the host as a hashed known_hosts entry does.
+func hashHost(host string) string {
+	salt := make([]byte, 20)
+	rand.Read(salt)
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(host))
+	return "|1|" + base64.StdEncoding.EncodeToString(salt) + "|" + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestKnownHostsEntries(t *testing.T) {
+	server := newTestServer(t)
+	host, portString, _ := net.SplitHostPort(server.Addr)
+	port, _ := strconv.Atoi(portString)
+	address := knownhosts.Normalize(server.Addr)
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := string(ssh.MarshalAuthorizedKey(server.HostKey))
+	for _, tc := range []struct {
+		name    string
+		entries []string
+		err     error
+	}{
+		{"plain", []string{knownhosts.Line([]string{address}, server.HostKey)}, nil},
+		{"hashed", []string{hashHost(address) + " " + key}, nil},
+		{"wildcard", []string{"# fleet", "[127.0.0.*]:" + portString + " " + key}, nil},
+		{"several hosts", []string{"other.example.com," + address + " " + key}, nil},
+		{"negated", []string{"[127.0.0.*]:" + portString + ",!" + address + " " + key}, sshe.ErrUnknownHostKey},
+		{"unknown", []string{knownhosts.Line([]string{"other.example.com"}, server.HostKey)}, sshe.ErrUnknownHostKey},
+		{"mismatch", []string{knownhosts.Line([]string{address}, other)}, sshe.ErrHostKeyMismatch},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// the entries are checked without a temp file, so a missing temp dir
+			// does not matter.
+			t.Setenv("TMPDIR", filepath.Join(t.TempDir(), "missing"))
+			executor := sshe.NewExecutor(host, port, "test", sshe.WithPassword("test"), sshe.WithKnownHostsEntries(tc.entries...))
+			for range 2 {
+				process, err := nescript.NewScript("echo ok").Cmd().Exec(executor)
+				if tc.err != nil {
+					if !errors.Is(err, tc.err) {
+						t.Fatalf("expected %v, got %v", tc.err, err)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := process.Result(); err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestKnownHostsEntriesAcceptNew(t *testing.T) {
+	server := newTestServer(t)
+	host, portString, _ := net.SplitHostPort(server.Addr)
+	port, _ := strconv.Atoi(portString)
+	executor := sshe.NewExecutor(host, port, "test", sshe.WithPassword("test"), sshe.WithKnownHostsEntries(), sshe.WithHostKeyMode(sshe.HostKeyAcceptNew))
+	for range 2 {
+		process, err := nescript.NewScript("echo ok").Cmd().Exec(executor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := process.Result(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a different target presenting another key at the same address fails.
+	server2 := newTestServer(t)
+	_, portString2, _ := net.SplitHostPort(server2.Addr)
+	port2, _ := strconv.Atoi(portString2)
+	sameKey := knownhosts.Line([]string{knownhosts.Normalize(server2.Addr)}, server.HostKey)
+	executor = sshe.NewExecutor(host, port2, "test", sshe.WithPassword("test"), sshe.WithKnownHostsEntries(sameKey), sshe.WithHostKeyMode(sshe.HostKeyAcceptNew))
+	if _, err := nescript.NewScript("echo ok").Cmd().Exec(executor); !errors.Is(err, sshe.ErrHostKeyMismatch) {
+		t.Errorf("expected a changed key to fail in accept-new mode, got %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+package sshe_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/sshe"
+)
+
+// jumpOpts are the options of a hop authenticating with a test server.
+func jumpOpts(password string) []sshe.Option {
+	return []sshe.Option{sshe.WithUser("test"), sshe.WithPassword(password), sshe.WithHostKeyMode(sshe.HostKeyInsecure)}
+}
+
+func TestJumpHosts(t *testing.T) {
+	first, second, target := newTestServer(t), newTestServer(t), newTestServer(t)
+	for _, tc := range []struct {
+		name      string
+		hops      []*testServer
+		forwarded [][]string
+	}{
+		{"one hop", []*testServer{first}, [][]string{{target.Addr}}},
+		{"chained", []*testServer{first, second}, [][]string{{second.Addr}, {target.Addr}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			before := make([]int, len(tc.hops))
+			opts := make([]sshe.Option, 0)
+			for i, hop := range tc.hops {
+				before[i] = len(hop.Forwarded())
+				opts = append(opts, sshe.WithJumpHost(hop.Addr, jumpOpts("test")...))
+			}
+			process, err := nescript.NewScript("echo through").Cmd().Exec(sshe.Executor(target.Addr, target.Config, opts...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := process.Result()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.StdOut != "through\n" {
+				t.Errorf("expected the script run on the target, got %q", result.StdOut)
+			}
+			for i, hop := range tc.hops {
+				if forwarded := hop.Forwarded()[before[i]:]; !slices.Equal(forwarded, tc.forwarded[i]) {
+					t.Errorf("expected hop %d to forward to %v, got %v", i+1, tc.forwarded[i], forwarded)
+				}
+				if len(hop.Commands()) > 0 {
+					t.Errorf("expected nothing executed on hop %d, got %v", i+1, hop.Commands())
+				}
+			}
+		})
+	}
+}
+
+func TestJumpHostErrors(t *testing.T) {
+	first, second, target := newTestServer(t), newTestServer(t), newTestServer(t)
+	for _, tc := range []struct {
+		name    string
+		target  string
+		hops    []string
+		opts    [][]sshe.Option
+		err     error
+		message string
+	}{
+		{"first hop refused", target.Addr, []string{closedAddr(t), second.Addr}, [][]sshe.Option{jumpOpts("test"), jumpOpts("test")}, sshe.ErrConnection, "jump host 1 ('"},
+		{"second hop refused", target.Addr, []string{first.Addr, closedAddr(t)}, [][]sshe.Option{jumpOpts("test"), jumpOpts("test")}, sshe.ErrConnection, "jump host 2 ('"},
+		{"second hop authentication", target.Addr, []string{first.Addr, second.Addr}, [][]sshe.Option{jumpOpts("test"), jumpOpts("wrong")}, sshe.ErrAuthentication, "jump host 2 ('" + second.Addr + "')"},
+		{"target refused", closedAddr(t), []string{first.Addr, second.Addr}, [][]sshe.Option{jumpOpts("test"), jumpOpts("test")}, sshe.ErrConnection, "failed to connect to ssh target"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := make([]sshe.Option, 0)
+			for i, hop := range tc.hops {
+				opts = append(opts, sshe.WithJumpHost(hop, tc.opts[i]...))
+			}
+			_, err := nescript.NewScript("echo through").Cmd().Exec(sshe.Executor(tc.target, target.Config, opts...))
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("expected %v, got %v", tc.err, err)
+			}
+			if !strings.Contains(err.Error(), tc.message) {
+				t.Errorf("expected %q in the error, got %v", tc.message, err)
+			}
+			if tc.target != target.Addr && strings.Contains(err.Error(), "jump host") {
+				t.Errorf("expected the target's failure not attributed to a hop, got %v", err)
+			}
+		})
+	}
+}
+
+func TestJumpHostCancelled(t *testing.T) {
+	first, second, target := newTestServer(t), newTestServer(t), newTestServer(t)
+	for _, tc := range []struct {
+		name    string
+		target  string
+		hops    []string
+		opts    []sshe.Option
+		cancel  time.Duration
+		err     error
+		message string
+	}{
+		{"cancelled at the second hop", target.Addr, []string{first.Addr, silentAddr(t)}, nil, 200 * time.Millisecond, context.Canceled, "jump host 2 ('"},
+		{"connect timeout at the target", silentAddr(t), []string{first.Addr, second.Addr}, []sshe.Option{sshe.WithConnectTimeout(200 * time.Millisecond)}, 0, context.DeadlineExceeded, "ssh connect timed out"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tc.cancel > 0 {
+				time.AfterFunc(tc.cancel, cancel)
+			}
+			opts := append([]sshe.Option{}, tc.opts...)
+			for _, hop := range tc.hops {
+				opts = append(opts, sshe.WithJumpHost(hop, jumpOpts("test")...))
+			}
+			start := time.Now()
+			_, err := nescript.NewScript("echo through").Cmd().WithContext(ctx).Exec(sshe.Executor(tc.target, target.Config, opts...))
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("expected the dial aborted promptly, took %s", elapsed)
+			}
+			var contextErr *sshe.ContextError
+			if !errors.As(err, &contextErr) || contextErr.Phase != sshe.PhaseConnect {
+				t.Fatalf("expected a *ContextError for the connect phase, got %v", err)
+			}
+			if !errors.Is(err, tc.err) {
+				t.Errorf("expected %v, got %v", tc.err, err)
+			}
+			if !strings.Contains(err.Error(), tc.message) {
+				t.Errorf("expected %q in the error, got %v", tc.message, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package sshe_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/sshe"
+)
+
+func TestKeepalive(t *testing.T) {
+	server := newTestServer(t)
+	t.Cleanup(server.Drop)
+	conn := sshe.Connect(server.Addr, server.Config, sshe.WithKeepalive(100*time.Millisecond, 3))
+	defer conn.Close()
+
+	t.Run("answered", func(t *testing.T) {
+		process, err := nescript.NewScript("sleep 0.6; echo done").Cmd().Exec(conn.Executor())
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := process.Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.StdOut != "done\n" {
+			t.Errorf("expected the script to outlast several keepalives, got %q", result.StdOut)
+		}
+	})
+
+	t.Run("unanswered", func(t *testing.T) {
+		process, err := nescript.NewScript("echo started; sleep 10").Cmd().Exec(conn.Executor())
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.AfterFunc(200*time.Millisecond, server.Stall)
+		start := time.Now()
+		_, err = process.Result()
+		if !errors.Is(err, sshe.ErrConnectionLost) {
+			t.Fatalf("expected ErrConnectionLost, got %v", err)
+		}
+		// 3 keepalives missed after the stall, each given an interval to be
+		// answered.
+		if elapsed := time.Since(start); elapsed > 3*time.Second {
+			t.Errorf("expected the lost connection found within the missed keepalives, took %s", elapsed)
+		}
+	})
+
+	t.Run("reconnected", func(t *testing.T) {
+		connections := server.Connections()
+		process, err := nescript.NewScript("echo again").Cmd().Exec(conn.Executor())
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := process.Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.StdOut != "again\n" || server.Connections() != connections+1 {
+			t.Errorf("expected the next execution run over a new connection, got %q over %d new connections", result.StdOut, server.Connections()-connections)
+		}
+	})
+}
+
+func TestKeepaliveExecutor(t *testing.T) {
+	server := newTestServer(t)
+	t.Cleanup(server.Drop)
+	process, err := nescript.NewScript("echo started; sleep 10").Cmd().Exec(sshe.Executor(server.Addr, server.Config, sshe.WithKeepalive(100*time.Millisecond, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.AfterFunc(200*time.Millisecond, server.Stall)
+	if _, err := process.Result(); !errors.Is(err, sshe.ErrConnectionLost) {
+		t.Errorf("expected ErrConnectionLost, got %v", err)
+	}
+}
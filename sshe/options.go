@@ -0,0 +1,829 @@
+package sshe
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/neaas/nescript"
+	"golang.org/x/crypto/ssh"
+)
+
+// Option configures the SSH executor.
+type Option func(*options)
+
+type options struct {
+	config     *ssh.ClientConfig
+	user       string
+	auth       []authSource
+	knownHosts string
+	hostKeys   *hostKeys
+	shell      nescript.Subcommand
+	retry      retryPolicy
+	forwards   []remoteForward
+	workDir    *workDir
+	stdout     io.Writer
+	stderr     io.Writer
+	lines      LineFunc
+	normalize  []nescript.Normalizer
+	encoding   *string
+	maxOutput  int
+	retain     nescript.Retain
+	algorithms algorithms
+	stdin      io.Reader
+	stop       stop
+	env        EnvStrategy
+	sessions   sessionLimit
+	jumpHosts  []jumpHost
+	sudo       *sudo
+	upload     *upload
+	windows    *WindowsShell
+	detectOS   bool
+	detected   *platformCache
+	fallback   Platform
+	pty        *pty
+
+	// stdinClaimed is shared by every options the stdin is given to, so that
+	// the reader is consumed by one execution only.
+	stdinClaimed *atomic.Bool
+
+	clientVersion  string
+	connectTimeout time.Duration
+	execTimeout    time.Duration
+
+	keepaliveInterval  time.Duration
+	keepaliveMaxMissed int
+
+	concurrency   int
+	hostTimeout   time.Duration
+	fanOutTimeout time.Duration
+	failFast      bool
+	progress      ProgressFunc
+}
+
+// jumpHost is an intermediate SSH hop the target is dialed through.
+type jumpHost struct {
+	address string
+	opts    *options
+}
+
+// authSource lazily provides an authentication method, so that keys are only
+// read and parsed when connecting. Any resources held by the method (such as
+// an agent connection) are released with the io.Closer, if not nil, once
+// connected.
+type authSource func() (authMethod, io.Closer, error)
+
+// authMethod is either an authentication method, or the signers of public key
+// authentication. The signers of every source are offered by a single method,
+// as the client tries each kind of method only once, so a key given as a
+// method of its own after another would never be offered.
+type authMethod struct {
+	method  ssh.AuthMethod
+	signers []ssh.Signer
+}
+
+func newOptions(config *ssh.ClientConfig, opts []Option) *options {
+	o := &options{
+		config:   config,
+		hostKeys: &hostKeys{},
+		retry:    retryPolicy{attempts: 1},
+		stop:     stop{signal: ssh.SIGTERM, grace: stopGracePeriod},
+		env:      EnvAuto,
+		detected: &platformCache{},
+		fallback: PlatformUnixSh,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithUser sets the user to authenticate as, overriding any user given in the
+// client config.
+func WithUser(user string) Option {
+	return func(o *options) {
+		o.user = user
+	}
+}
+
+// WithPrivateKey authenticates with the given PEM encoded private key. If the
+// key is encrypted, the passphrase must be given, otherwise it may be empty.
+// Authentication methods are tried in the order they are given, after any
+// given in the client config. Keys (including those of an agent and
+// certificates) are offered in turn where the first of them was given.
+func WithPrivateKey(keyPEM []byte, passphrase string) Option {
+	return func(o *options) {
+		o.auth = append(o.auth, func() (authMethod, io.Closer, error) {
+			signer, err := privateKeySigner(keyPEM, passphrase)
+			return authMethod{signers: []ssh.Signer{signer}}, nil, err
+		})
+	}
+}
+
+// WithPrivateKeyFile authenticates with the PEM encoded private key read from
+// the given path (such as ~/.ssh/id_ed25519) when connecting. If the key is
+// encrypted, the passphrase must be given, otherwise it may be empty.
+func WithPrivateKeyFile(path, passphrase string) Option {
+	return func(o *options) {
+		o.auth = append(o.auth, func() (authMethod, io.Closer, error) {
+			keyPEM, err := os.ReadFile(path)
+			if err != nil {
+				return authMethod{}, nil, fmt.Errorf("failed to read ssh private key '%s': %w", path, err)
+			}
+			signer, err := privateKeySigner(keyPEM, passphrase)
+			return authMethod{signers: []ssh.Signer{signer}}, nil, err
+		})
+	}
+}
+
+// WithPassword authenticates with the given password. The password is held as
+// a secret, so it is redacted if the options are ever formatted.
+func WithPassword(password string) Option {
+	s := secret(password)
+	return func(o *options) {
+		o.auth = append(o.auth, func() (authMethod, io.Closer, error) {
+			return authMethod{method: ssh.Password(string(s))}, nil, nil
+		})
+	}
+}
+
+// KeyboardInteractiveFunc answers the questions of a keyboard-interactive
+// challenge, returning one answer per question.
+type KeyboardInteractiveFunc func(name, instruction string, questions []string) ([]string, error)
+
+// WithKeyboardInteractive authenticates by answering the server's
+// keyboard-interactive challenges with the given func, as used by appliances
+// that prompt for one-time codes or passwords.
+func WithKeyboardInteractive(answerer KeyboardInteractiveFunc) Option {
+	return func(o *options) {
+		o.auth = append(o.auth, func() (authMethod, io.Closer, error) {
+			return authMethod{method: ssh.KeyboardInteractive(func(name, instruction string, questions []string, _ []bool) ([]string, error) {
+				return answerer(name, instruction, questions)
+			})}, nil, nil
+		})
+	}
+}
+
+// WithCertificate authenticates with the given PEM encoded private key,
+// presenting the certificate issued for it by a CA (in the authorized_keys
+// format, as written by ssh-keygen -s). If the key is encrypted, the passphrase
+// must be given, otherwise it may be empty. A certificate that has expired, is
+// not yet valid, or was not issued for the key fails the execution before
+// connecting, with ErrAuthentication wrapping ErrCertificateExpired,
+// ErrCertificateNotYetValid or ErrCertificateInvalid.
+func WithCertificate(certBytes, keyPEM []byte, passphrase string) Option {
+	return func(o *options) {
+		o.auth = append(o.auth, func() (authMethod, io.Closer, error) {
+			signer, err := certificateSigner(certBytes, keyPEM, passphrase)
+			return authMethod{signers: []ssh.Signer{signer}}, nil, err
+		})
+	}
+}
+
+// WithCertificateFile is WithCertificate with the certificate and private key
+// read from the given paths (such as ~/.ssh/id_ed25519-cert.pub and
+// ~/.ssh/id_ed25519) when connecting, so that a renewed certificate is picked
+// up by later executions.
+func WithCertificateFile(certPath, keyPath, passphrase string) Option {
+	return func(o *options) {
+		o.auth = append(o.auth, func() (authMethod, io.Closer, error) {
+			certBytes, err := os.ReadFile(certPath)
+			if err != nil {
+				return authMethod{}, nil, fmt.Errorf("failed to read ssh certificate '%s': %w", certPath, err)
+			}
+			keyPEM, err := os.ReadFile(keyPath)
+			if err != nil {
+				return authMethod{}, nil, fmt.Errorf("failed to read ssh private key '%s': %w", keyPath, err)
+			}
+			signer, err := certificateSigner(certBytes, keyPEM, passphrase)
+			return authMethod{signers: []ssh.Signer{signer}}, nil, err
+		})
+	}
+}
+
+// WithAgent authenticates with the keys held by the SSH agent at
+// $SSH_AUTH_SOCK. If other authentication methods are also given and the agent
+// is unreachable (or holds no keys), it is skipped, otherwise an error wrapping
+// ErrAgentUnavailable is returned.
+func WithAgent() Option {
+	return WithAgentSocket("")
+}
+
+// WithAgentSocket authenticates with the keys held by the SSH agent listening
+// on the given unix socket. If the path is empty, $SSH_AUTH_SOCK is used. See
+// WithAgent.
+func WithAgentSocket(path string) Option {
+	return func(o *options) {
+		o.auth = append(o.auth, func() (authMethod, io.Closer, error) {
+			signers, closer, err := agentSigners(path)
+			return authMethod{signers: signers}, closer, err
+		})
+	}
+}
+
+// WithKnownHosts sets the known_hosts file host keys are verified against. By
+// default, ~/.ssh/known_hosts is used.
+func WithKnownHosts(path string) Option {
+	return func(o *options) {
+		o.knownHosts = path
+	}
+}
+
+// WithKnownHostsEntries verifies host keys against the given known_hosts lines
+// (in the known_hosts file format, including hashed hosts and [host]:port
+// entries) rather than a file. In HostKeyAcceptNew mode, new keys are
+// remembered in memory for the lifetime of the executor.
+func WithKnownHostsEntries(lines ...string) Option {
+	return func(o *options) {
+		o.hostKeys.entries = append(o.hostKeys.entries, lines...)
+		o.hostKeys.inMemory = true
+	}
+}
+
+// WithHostCertificateAuthority trusts host certificates signed by the given
+// certificate authority keys (in the authorized_keys format), as an
+// alternative to listing each host's key in known_hosts. The certificate must
+// be valid, and name the target's host as a principal. Targets presenting a
+// plain host key are still verified against known_hosts.
+func WithHostCertificateAuthority(authorities ...string) Option {
+	return func(o *options) {
+		o.hostKeys.authorities = append(o.hostKeys.authorities, authorities...)
+	}
+}
+
+// WithHostKeyMode sets how host keys are verified. By default, HostKeyStrict is
+// used. This is ignored if the client config given has a HostKeyCallback.
+func WithHostKeyMode(mode HostKeyMode) Option {
+	return func(o *options) {
+		o.hostKeys.mode = mode
+	}
+}
+
+// WithJumpHost dials the target through the SSH jump host (bastion) at the
+// given address (host:port), like OpenSSH's ProxyJump. The jump host is
+// configured by its own options, such as its user, authentication and host key
+// mode. When given more than once, the jump hosts are chained in the order
+// given, the first being dialed directly.
+func WithJumpHost(address string, opts ...Option) Option {
+	return func(o *options) {
+		o.jumpHosts = append(o.jumpHosts, jumpHost{
+			address: address,
+			opts:    newOptions(nil, opts),
+		})
+	}
+}
+
+// WithConnectTimeout limits how long connecting to the target (dialing, the SSH
+// handshake and authentication, including through any jump hosts) may take.
+// By default, the only limit is the cmd's context.
+func WithConnectTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.connectTimeout = timeout
+	}
+}
+
+// WithExecTimeout limits how long the script may run once started, separately
+// from the connect timeout. When it elapses (or the cmd's context is done), the
+// script is sent SIGTERM, then its session is closed, and the result fails with
+// a *ContextError holding the output captured so far.
+func WithExecTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.execTimeout = timeout
+	}
+}
+
+// WithRemoteForward forwards connections made to the remote address on the
+// target (a reverse tunnel) to the local address, which only needs to be
+// reachable from the caller, for the duration of each execution. This allows
+// scripts to reach services near the caller, such as an artifact server. Give
+// a remote port of 0 (such as "127.0.0.1:0") to have the target choose a free
+// port, avoiding collisions. The address listened on is given to the script in
+// the env var NESCRIPT_FORWARD_<n>, where n is the index of the forward (from
+// 0) in the order given, and by SSHProcess.Forwards. If the target refuses to
+// listen, such as when the port is in use, the execution fails with ErrForward.
+func WithRemoteForward(remoteAddr, localAddr string) Option {
+	return func(o *options) {
+		o.forwards = append(o.forwards, remoteForward{remoteAddr: remoteAddr, localAddr: localAddr})
+	}
+}
+
+// WithRemoteWorkDir executes scripts from within the directory on the target,
+// rather than the user's home directory. This includes uploaded scripts (see
+// WithUpload), and with sudo, the directory is entered as the sudo user. If the
+// directory does not exist, the script is not run and ErrWorkDirNotFound is
+// returned. This is not supported on windows targets.
+func WithRemoteWorkDir(path string) Option {
+	return func(o *options) {
+		if o.workDir == nil {
+			o.workDir = &workDir{}
+		}
+		o.workDir.path = path
+	}
+}
+
+// WithRemoteWorkDirCreate creates the work dir given by WithRemoteWorkDir
+// (along with any parents) if it does not exist.
+func WithRemoteWorkDirCreate() Option {
+	return func(o *options) {
+		if o.workDir == nil {
+			o.workDir = &workDir{}
+		}
+		o.workDir.create = true
+	}
+}
+
+// WithStdin sets a reader to be copied to the stdin of the script (the
+// equivalent of piping into ssh). Once the reader is exhausted, stdin is closed
+// so the script receives EOF, and if reading it fails, the execution fails with
+// the error. The result does not wait for a reader that has not returned once
+// the script exits, such as os.Stdin. As an execution consumes the reader, it
+// is given to the first execution only, any other execution with the option
+// (including those of other hosts of a fan-out) failing with ErrStdinConflict.
+// Process.Write should not be used alongside this option. This can not be
+// combined with WithSudoPassword, as the password is also sent on stdin, and
+// fails with ErrStdinConflict. With a PTY (see WithPTY), the terminal does not
+// pass on EOF, so the script may not see the end of its input.
+func WithStdin(stdin io.Reader) Option {
+	claimed := &atomic.Bool{}
+	return func(o *options) {
+		o.stdin = stdin
+		o.stdinClaimed = claimed
+	}
+}
+
+// WithEnvStrategy sets how the cmd's env vars are given to the script. By
+// default, EnvAuto sets them on the session, falling back to exporting them in
+// the command for any the target rejects. The strategy used is recorded on the
+// result (see MetadataEnvStrategy). With sudo, or on windows targets, the env
+// vars are always set within the command.
+func WithEnvStrategy(strategy EnvStrategy) Option {
+	return func(o *options) {
+		o.env = strategy
+	}
+}
+
+// WithSessionQueue queues executions when the target refuses to open more
+// sessions on the connection (such as once OpenSSH's MaxSessions are open, which
+// is 10 by default), waiting for a session to be freed by another execution
+// over a shared Connection. The wait is limited by the cmd's context, and by
+// the timeout if positive, after which the execution fails with
+// ErrSessionLimit (or, with WithSessionOverflow, is started over an additional
+// connection). Without this, executions fail with ErrSessionLimit immediately.
+func WithSessionQueue(timeout time.Duration) Option {
+	return func(o *options) {
+		o.sessions.queue = true
+		o.sessions.wait = timeout
+	}
+}
+
+// WithSessionOverflow starts executions over an additional connection to the
+// target when it refuses to open more sessions on a shared Connection (after
+// any wait given by WithSessionQueue). The additional connection is closed once
+// the execution's process is closed.
+func WithSessionOverflow() Option {
+	return func(o *options) {
+		o.sessions.overflow = true
+	}
+}
+
+// WithSessionQueueHook calls the hook with the number of executions waiting for
+// a session (see WithSessionQueue) each time it changes, such as to export it
+// as a metric. See also Connection.Queued.
+func WithSessionQueueHook(hook QueueFunc) Option {
+	return func(o *options) {
+		o.sessions.hook = hook
+	}
+}
+
+// WithOutput streams the script's stdout and stderr to the writers as it is
+// written, in addition to capturing it for the result. Either may be nil. The
+// writers are given output as received from the target, before any
+// normalization (such as of PTY or windows line endings). Reading from the
+// target is paused while a write blocks, so once the SSH channel's window is
+// full, the script itself blocks writing its output until the writer catches
+// up. Stdout and stderr share the window, so a slow stdout writer also holds up
+// stderr.
+func WithOutput(stdout, stderr io.Writer) Option {
+	return func(o *options) {
+		o.stdout = stdout
+		o.stderr = stderr
+	}
+}
+
+// WithLineHandler calls the handler with each line of stdout and stderr as the
+// script writes it, in addition to capturing it for the result. Lines of each
+// stream are given in order; the handler is never called concurrently,
+// however lines of stdout and stderr written at around the same time may be
+// given in either order. As with WithOutput, a slow handler pauses the script
+// once the SSH channel's window is full.
+func WithLineHandler(handler LineFunc) Option {
+	return func(o *options) {
+		o.lines = handler
+	}
+}
+
+// WithNormalizers applies the normalizers in order to the output of the
+// result, and to each line given to the line handler (see WithLineHandler), such
+// as nescript.StripANSI for scripts running colored CLIs. These are applied
+// after PTY and windows line endings are normalized. Output streamed with
+// WithOutput is written as it is.
+func WithNormalizers(normalizers ...nescript.Normalizer) Option {
+	return func(o *options) {
+		o.normalize = append(o.normalize, normalizers...)
+	}
+}
+
+// WithOutputEncoding decodes the output of the result from the encoding into
+// UTF-8 (see nescript.DecodeOutput), such as "cp850" for the output of native
+// commands on a windows target using the OEM code page of its console. On
+// windows targets, output is otherwise decoded from UTF-16 if it starts with
+// its byte order mark (nescript.EncodingAuto), as the PowerShell prologue sets
+// the console's output encoding to UTF-8. The output is decoded before it is
+// normalized. Output streamed with WithOutput or WithLineHandler is as it is.
+func WithOutputEncoding(encoding string) Option {
+	return func(o *options) {
+		o.encoding = &encoding
+	}
+}
+
+// outputEncoding returns the encoding the output is decoded from, if it is
+// decoded.
+func (o *options) outputEncoding(windows bool) (string, bool) {
+	if o.encoding != nil {
+		return *o.encoding, true
+	}
+	return nescript.EncodingAuto, windows
+}
+
+// WithMaxOutput caps how many bytes of each of stdout and stderr are captured
+// for the result (and for a *ContextError). Output beyond the cap is still
+// streamed (see WithOutput and WithLineHandler), and the number of bytes
+// dropped is recorded on the result as Truncated metadata (see
+// MetadataTruncated). By default, all output is captured, and once capped the
+// start of the output is kept (see WithRetain).
+func WithMaxOutput(size int) Option {
+	return func(o *options) {
+		o.maxOutput = size
+	}
+}
+
+// WithRetain selects which part of stdout and stderr is captured for the result
+// once either exceeds the cap given by WithMaxOutput, such as nescript.RetainTail
+// to keep the end of the output, where a failing script's error tends to be.
+// By default, the start of the output is kept (nescript.RetainHead).
+func WithRetain(retain nescript.Retain) Option {
+	return func(o *options) {
+		o.retain = retain
+	}
+}
+
+// WithCiphers sets the ciphers offered to the target, in order of preference,
+// overriding any given in the client config. This allows targets that only
+// support older ciphers, such as aes128-cbc or 3des-cbc, to be connected to.
+// Unknown ciphers fail the execution with ErrUnsupportedAlgorithm before
+// connecting.
+func WithCiphers(ciphers ...string) Option {
+	return func(o *options) {
+		o.algorithms.ciphers = ciphers
+	}
+}
+
+// WithKeyExchanges sets the key exchange algorithms offered to the target, in
+// order of preference, overriding any given in the client config. Unknown
+// algorithms fail the execution with ErrUnsupportedAlgorithm before
+// connecting.
+func WithKeyExchanges(keyExchanges ...string) Option {
+	return func(o *options) {
+		o.algorithms.keyExchanges = keyExchanges
+	}
+}
+
+// WithMACs sets the MAC algorithms offered to the target, in order of
+// preference, overriding any given in the client config. Unknown algorithms
+// fail the execution with ErrUnsupportedAlgorithm before connecting.
+func WithMACs(macs ...string) Option {
+	return func(o *options) {
+		o.algorithms.macs = macs
+	}
+}
+
+// WithHostKeyAlgorithms sets the host key algorithms accepted from the target,
+// in order of preference, overriding any given in the client config. For
+// example, hardened targets only offering an ssh-ed25519 host key. As ssh-rsa
+// uses SHA-1 signatures, it may only be given along with WithLegacyRSA. Unknown
+// algorithms fail the execution with ErrUnsupportedAlgorithm before connecting.
+func WithHostKeyAlgorithms(algorithms ...string) Option {
+	return func(o *options) {
+		o.algorithms.hostKeys = algorithms
+	}
+}
+
+// WithLegacyRSA accepts ssh-rsa host keys with SHA-1 signatures, for older
+// targets that do not support rsa-sha2-256 or rsa-sha2-512. It is offered
+// after any other host key algorithms, unless given explicitly by
+// WithHostKeyAlgorithms.
+func WithLegacyRSA() Option {
+	return func(o *options) {
+		o.algorithms.legacyRSA = true
+	}
+}
+
+// WithClientVersion sets the identification string the client sends to the
+// target, such as for appliances that filter on it. It must start with
+// "SSH-2.0-". By default, "SSH-2.0-Go" is sent.
+func WithClientVersion(version string) Option {
+	return func(o *options) {
+		o.clientVersion = version
+	}
+}
+
+// WithStopSignal sets the signal sent to the script when its context is done
+// (or the exec timeout passes), giving it the grace period to exit before the
+// session is closed. By default, SIGTERM is sent. Note that some SSH servers
+// (such as OpenSSH before 7.9) ignore signals, see WithRemoteKill.
+func WithStopSignal(signal ssh.Signal) Option {
+	return func(o *options) {
+		o.stop.signal = signal
+	}
+}
+
+// WithStopGracePeriod sets how long the script is given to exit after the stop
+// signal, before the session is closed. By default, this is 2 seconds.
+func WithStopGracePeriod(grace time.Duration) Option {
+	return func(o *options) {
+		o.stop.grace = grace
+	}
+}
+
+// WithRemoteKill kills the script's process by its PID, over a new session,
+// if it has not exited within the grace period after the stop signal. Many SSH
+// servers leave the process running once its session is closed. To find the
+// PID, the command is run by the login shell with exec, after it writes its
+// PID to stdout (which is removed from the output). The login shell must be a
+// POSIX shell. With sudo, the elevated shell writes its PID instead, and the
+// process is killed with sudo. This is ignored on windows targets.
+func WithRemoteKill() Option {
+	return func(o *options) {
+		o.stop.kill = true
+	}
+}
+
+// WithRetry makes the executor retry connecting to the target, and opening the
+// script's session, when they fail with a transient error (see IsTransient),
+// such as while the target reboots. Up to the given number of attempts are
+// made in total, with the backoff between attempts starting at the given
+// duration and doubling after each. Once the command has been sent to the
+// target it is never retried, so the script is never run twice. Cancelling the
+// cmd's context stops the retries immediately. When retries happen, the
+// returned error is a *RetryError holding the most recent errors.
+func WithRetry(attempts int, backoff time.Duration) Option {
+	return func(o *options) {
+		o.retry = retryPolicy{
+			attempts: attempts,
+			backoff:  backoff,
+		}
+	}
+}
+
+// WithKeepalive pings the target every interval while connected. If maxMissed
+// pings in a row go unanswered, the connection is torn down, and executions
+// still running over it fail with ErrConnectionLost rather than hanging (for
+// example when a firewall silently drops an idle connection). Reconnecting is
+// left to the caller, though a Connection reconnects on its next execution.
+func WithKeepalive(interval time.Duration, maxMissed int) Option {
+	return func(o *options) {
+		o.keepaliveInterval = interval
+		o.keepaliveMaxMissed = maxMissed
+	}
+}
+
+// WithSudo executes scripts/cmds as the given user (or root, if empty) with
+// sudo. The elevated shell is started with `sudo -u <user> -- sh`, which reads
+// the command from stdin (as `sh -s`) with the cmd's env exported (as sudo
+// resets the env), so neither appears in the target's process list. The rest
+// of stdin remains available to the script. The exit code of the script is
+// passed through sudo. Failures of sudo itself, detected as the elevated shell
+// never started, are returned as errors, see ErrSudoNotPermitted,
+// ErrSudoPasswordRequired and ErrSudoIncorrectPassword.
+func WithSudo(user string) Option {
+	return func(o *options) {
+		if o.sudo == nil {
+			o.sudo = &sudo{}
+		}
+		o.sudo.user = user
+	}
+}
+
+// WithSudoPassword sets the password sudo is given (over stdin) when sudo
+// prompts for one, so it is not sent where sudo does not require it (such as
+// with NOPASSWD). The password is held as a secret, so it is redacted if the
+// options are ever formatted. This implies WithSudo for root, if WithSudo is
+// not also given.
+func WithSudoPassword(password string) Option {
+	s := secret(password)
+	return func(o *options) {
+		if o.sudo == nil {
+			o.sudo = &sudo{}
+		}
+		o.sudo.password = &s
+	}
+}
+
+// WithUpload uploads scripts to the target over SFTP, executing them as a
+// remote file rather than passing them inline, which avoids quoting and
+// argument length limits on the target. Each script is written to a unique
+// file (with 0700 permissions) in the upload directory (see WithUploadDir),
+// executed with the interpreter (the shell or script subcommand without its
+// -c), then removed once the process is closed, including when cancelled. If
+// the target does not provide SFTP, executions fail with ErrSFTPUnavailable.
+// Cmds not created from a script are always executed inline.
+func WithUpload() Option {
+	return func(o *options) {
+		if o.upload == nil {
+			o.upload = &upload{}
+		}
+		o.upload.always = true
+	}
+}
+
+// WithUploadThreshold uploads scripts larger than the given number of bytes
+// (see WithUpload), executing smaller scripts inline. If the target does not
+// provide SFTP, scripts are executed inline regardless of their size.
+func WithUploadThreshold(size int) Option {
+	return func(o *options) {
+		if o.upload == nil {
+			o.upload = &upload{}
+		}
+		o.upload.threshold = size
+	}
+}
+
+// WithUploadDir sets the remote directory scripts are uploaded to (see
+// WithUpload), for targets where /tmp is mounted noexec.
+func WithUploadDir(dir string) Option {
+	return func(o *options) {
+		if o.upload == nil {
+			o.upload = &upload{}
+		}
+		o.upload.dir = dir
+	}
+}
+
+// WithTransfer sets the method scripts are uploaded with (see WithUpload). By
+// default scripts are uploaded over SFTP, falling back to SCP if the target
+// does not provide the SFTP subsystem, such as it being disabled by
+// appliances. SCP is only supported by unix targets.
+func WithTransfer(transfer Transfer) Option {
+	return func(o *options) {
+		if o.upload == nil {
+			o.upload = &upload{}
+		}
+		o.upload.transfer = transfer
+	}
+}
+
+// WithWindows sets that the target is windows (such as Windows Server with
+// OpenSSH), invoking scripts with the given PowerShell. Scripts are passed as a
+// -EncodedCommand (or uploaded as a .ps1 file, see WithUpload), with the env set
+// with $env: assignments, as windows does not accept env vars from the SSH
+// client. The exit code is that given to `exit` by the script, otherwise that
+// of the last native command if it failed ($LASTEXITCODE), otherwise 1 if the
+// last statement failed. Output line endings are converted from CRLF. Cmds not
+// created from a script are also run with PowerShell (with the call operator).
+func WithWindows(shell WindowsShell) Option {
+	return func(o *options) {
+		o.windows = &shell
+	}
+}
+
+// WithOSDetection detects the platform of the target (see Platform) by running
+// a probe with its default shell, so that the same executor can be used for
+// both unix and windows targets. Windows targets are executed on as with
+// WithWindows. With detection, WithWindows only selects the shell used for
+// windows targets (Windows PowerShell by default). The platform is detected
+// once per target for the executor (or Connection), and recorded in the
+// result's metadata under MetadataPlatform. If detection fails, the fallback
+// platform is used (see WithPlatformFallback) and a warning recorded in the
+// result's metadata, rather than failing the execution.
+func WithOSDetection() Option {
+	return func(o *options) {
+		o.detectOS = true
+	}
+}
+
+// WithPlatformFallback sets the platform assumed for the target when it can not
+// be detected (see WithOSDetection), which is PlatformUnixSh by default.
+func WithPlatformFallback(platform Platform) Option {
+	return func(o *options) {
+		o.fallback = platform
+	}
+}
+
+// WithPTY requests a pseudo terminal of the given type (such as xterm) and size
+// for the script, for commands that require a TTY. In PTY mode the script's
+// stdout and stderr are merged by the terminal into a single stream, which is
+// given as the result StdOut (StdErr is always empty), with the terminal's CRLF
+// line endings converted into newlines. Input written to the process is not
+// echoed into the output, unless enabled with WithPTYEcho. The PTY can be
+// resized while the script runs with SSHProcess.Resize.
+func WithPTY(term string, rows, cols int) Option {
+	return func(o *options) {
+		echo := o.pty != nil && o.pty.echo
+		o.pty = &pty{term: term, rows: rows, cols: cols, echo: echo}
+	}
+}
+
+// WithPTYEcho enables the terminal echoing input written to the process into
+// its output (see WithPTY).
+func WithPTYEcho() Option {
+	return func(o *options) {
+		if o.pty == nil {
+			o.pty = &pty{term: "xterm", rows: 24, cols: 80}
+		}
+		o.pty.echo = true
+	}
+}
+
+// WithShell sets the shell scripts are invoked with on the target, such as
+// nescript.SCBash. The script is passed to the shell as a single (quoted)
+// argument, so the cmd's formatter is not used. Cmds not created from a script
+// are unaffected.
+func WithShell(shell nescript.Subcommand) Option {
+	return func(o *options) {
+		o.shell = shell
+	}
+}
+
+// secret is a string, such as a password, that must not appear in any output.
+// It is redacted whenever it is formatted, with any verb.
+type secret string
+
+func (s secret) String() string {
+	return "[REDACTED]"
+}
+
+func (s secret) GoString() string {
+	return s.String()
+}
+
+func (s secret) Format(f fmt.State, verb rune) {
+	io.WriteString(f, s.String())
+}
+
+// privateKeySigner parses a (possibly encrypted) private key.
+func privateKeySigner(keyPEM []byte, passphrase string) (ssh.Signer, error) {
+	var signer ssh.Signer
+	var err error
+	if passphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(keyPEM, []byte(passphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey(keyPEM)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ssh private key: %w", err)
+	}
+	return signer, nil
+}
+
+// WithConcurrency sets the maximum number of hosts ExecAll executes on at once.
+// By default, this is one.
+func WithConcurrency(n int) Option {
+	return func(o *options) {
+		o.concurrency = n
+	}
+}
+
+// WithHostTimeout limits how long ExecAll waits for each host to connect and
+// complete the script. A host that times out has the outcome OutcomeTimedOut.
+func WithHostTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.hostTimeout = timeout
+	}
+}
+
+// WithFanOutTimeout limits how long ExecAll runs for as a whole. Scripts still
+// running when it passes are stopped (OutcomeTimedOut), and hosts not yet
+// reached are skipped.
+func WithFanOutTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.fanOutTimeout = timeout
+	}
+}
+
+// WithFailFast stops ExecAll from starting executions on any further hosts once
+// one has not succeeded (including exiting unsuccessfully).
+// Executions already started are allowed to complete.
+func WithFailFast(failFast bool) Option {
+	return func(o *options) {
+		o.failFast = failFast
+	}
+}
+
+// WithProgress sets a func ExecAll calls as each host completes.
+func WithProgress(progress ProgressFunc) Option {
+	return func(o *options) {
+		o.progress = progress
+	}
+}
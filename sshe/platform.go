@@ -0,0 +1,143 @@
+package sshe
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/neaas/nescript"
+)
+
+// MetadataPlatform is the result metadata key recording the platform detected
+// for the target (see WithOSDetection).
+const MetadataPlatform = "sshe.platform"
+
+// platformProbe is run by the target's default shell to classify it. cmd
+// neither expands $ vars nor separates commands with ;, so echoes the probe
+// as is, whereas PowerShell (before 7) rejects ||.
+const platformProbe = `echo "$BASH_VERSION"; uname || ver`
+
+// Platform classifies the target by its operating system and the default
+// shell commands are run with.
+type Platform string
+
+const (
+	// PlatformUnixSh is a unix target with a POSIX shell (such as dash or
+	// ash) as its default shell.
+	PlatformUnixSh Platform = "unix-sh"
+
+	// PlatformUnixBash is a unix target with bash as its default shell.
+	PlatformUnixBash Platform = "unix-bash"
+
+	// PlatformWindowsPowerShell is a windows target with PowerShell as its
+	// default shell.
+	PlatformWindowsPowerShell Platform = "windows-powershell"
+
+	// PlatformWindowsCmd is a windows target with cmd as its default shell,
+	// which is the default for Windows OpenSSH.
+	PlatformWindowsCmd Platform = "windows-cmd"
+)
+
+// Windows reports whether the platform is windows, on which scripts are
+// executed as with WithWindows.
+func (p Platform) Windows() bool {
+	return p == PlatformWindowsPowerShell || p == PlatformWindowsCmd
+}
+
+// PlatformFrom returns the platform detected for the target a result was
+// produced on. False is returned if the platform was not detected.
+func PlatformFrom(r *nescript.Result) (Platform, bool) {
+	platform, ok := r.Metadata[MetadataPlatform].(Platform)
+	return platform, ok
+}
+
+// platformCache records the platform detected for each target, so that the
+// target is only probed once, rather than for every connection.
+type platformCache struct {
+	mu        sync.Mutex
+	platforms map[string]Platform
+}
+
+func (c *platformCache) get(target string) (Platform, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	platform, ok := c.platforms[target]
+	return platform, ok
+}
+
+func (c *platformCache) set(target string, platform Platform) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.platforms == nil {
+		c.platforms = map[string]Platform{}
+	}
+	c.platforms[target] = platform
+}
+
+// targetPlatform returns the platform of the target. Without detection, this
+// is windows if given by WithWindows, otherwise unix. When detection fails, the
+// fallback platform (see WithPlatformFallback) is returned along with a
+// warning, rather than failing the execution. Failed detections are not
+// cached, so are retried by the next execution.
+func (o *options) targetPlatform(conn *connection, target string) (Platform, string) {
+	if !o.detectOS {
+		if o.windows != nil {
+			return PlatformWindowsPowerShell, ""
+		}
+		return PlatformUnixSh, ""
+	}
+	if platform, ok := o.detected.get(target); ok {
+		return platform, ""
+	}
+	platform, err := conn.probePlatform()
+	if err != nil {
+		return o.fallback, fmt.Sprintf("%s, assuming %s", err, o.fallback)
+	}
+	o.detected.set(target, platform)
+	return platform, ""
+}
+
+// probePlatform runs the platform probe with the target's default shell.
+func (c *connection) probePlatform() (Platform, error) {
+	session, err := c.client.NewSession()
+	if err != nil {
+		return "", fmt.Errorf("failed to detect target platform: %w", err)
+	}
+	defer session.Close()
+	var stdout, stderr bytes.Buffer
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+	err = session.Run(platformProbe)
+	platform, err := classifyPlatform(stdout.String(), stderr.String(), err)
+	if err != nil {
+		return "", fmt.Errorf("failed to detect target platform: %w", err)
+	}
+	return platform, nil
+}
+
+// classifyPlatform classifies the target from the output of the platform
+// probe. On unix targets, the first line is the bash version (empty with
+// other shells), followed by the output of uname.
+func classifyPlatform(stdout, stderr string, err error) (Platform, error) {
+	switch {
+	case strings.Contains(stdout, "$BASH_VERSION"):
+		return PlatformWindowsCmd, nil
+	case strings.Contains(stdout, "Microsoft Windows"), strings.Contains(stderr, "'||'"):
+		return PlatformWindowsPowerShell, nil
+	case strings.Contains(stderr, "is not recognized"):
+		// PowerShell 7 accepts ||, however has neither uname nor ver.
+		return PlatformWindowsPowerShell, nil
+	case err != nil:
+		return "", err
+	}
+	bashVersion, uname, _ := strings.Cut(stdout, "\n")
+	if strings.TrimSpace(uname) == "" {
+		return "", errors.New("no output from uname")
+	}
+	if strings.TrimSpace(bashVersion) != "" {
+		return PlatformUnixBash, nil
+	}
+	return PlatformUnixSh, nil
+}
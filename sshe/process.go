@@ -1,32 +1,126 @@
 package sshe
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/neaas/nescript"
 	"golang.org/x/crypto/ssh"
 )
 
-// Process represents a single instance of the script running or completed on
-// the local device.
+// SSHProcess represents a single instance of the script running or completed on
+// an SSH target.
 type SSHProcess struct {
 	sshSession  *ssh.Session
-	sshClient   *ssh.Client
+	conn        *connection
+	release     func()
+	closed      func() bool
+	releaseOnce sync.Once
+	uploaded    *uploaded
+	removeOnce  sync.Once
+	removeErr   error
+	forwarding  *forwarding
 	stdin       io.Writer
-	stdoutBytes bytes.Buffer
-	stderrBytes bytes.Buffer
+	stdinPipe   *stdinPipe
+	stdoutBytes *nescript.Capture
+	stderrBytes *nescript.Capture
+	lineWriters []*lineWriter
+	done        chan struct{}
+	watched     chan struct{}
+	waitErr     error
+	started     time.Time
+	ended       time.Time
+	stop        stop
+	launch      *launch
+	sudo        *sudo
+	envStrategy EnvStrategy
+	workDir     string
+	platform    Platform
+	warnings    []string
+	windows     bool
+	pty         bool
+	normalize   []nescript.Normalizer
+	encoding    *string
+	tee         *nescript.Tee
+	completion  *nescript.Completion
+
+	mu          sync.Mutex
+	interrupted error
+	termination Termination
+}
+
+// watch stops the script if the context is done before it exits, first with
+// the stop signal, then by closing the session once the grace period has
+// passed. With remote kill, the script's process is then killed by its PID, as
+// many SSH servers leave it running once the session is closed.
+func (p *SSHProcess) watch(ctx context.Context, cancel context.CancelFunc) {
+	defer close(p.watched)
+	defer cancel()
+	select {
+	case <-p.done:
+		return
+	case <-ctx.Done():
+	}
+	p.mu.Lock()
+	p.interrupted = ctx.Err()
+	p.mu.Unlock()
+	p.sshSession.Signal(p.stop.signal)
+	select {
+	case <-p.done:
+		p.terminated(TerminationSignalled)
+		return
+	case <-time.After(p.stop.grace):
+	}
+	p.sshSession.Close()
+	p.terminated(TerminationClosed)
+	if p.launch == nil || !p.stop.kill {
+		return
+	}
+	if pid := p.launch.PID(); pid > 0 && killRemote(p.conn.client, pid, p.sudo, p.stop.grace) == nil {
+		p.terminated(TerminationKilled)
+	}
+}
+
+// terminated records how the script was terminated.
+func (p *SSHProcess) terminated(termination Termination) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.termination = termination
+}
+
+// Platform returns the platform detected for the target (see
+// WithOSDetection), or an empty platform if it was not detected.
+func (p *SSHProcess) Platform() Platform {
+	return p.platform
 }
 
 func (p *SSHProcess) Kill() error {
+	if p.exited() {
+		return nil
+	}
 	if err := p.sshSession.Signal(ssh.SIGKILL); err != nil {
 		return fmt.Errorf("failed to kill process: %w", err)
 	}
 	return nil
 }
 
+// exited reports whether the result of the script has been collected.
+func (p *SSHProcess) exited() bool {
+	select {
+	case <-p.completion.Done():
+		return true
+	default:
+		return false
+	}
+}
+
 func (p *SSHProcess) Signal(s os.Signal) error {
 	if err := p.sshSession.Signal(ssh.Signal(s.String())); err != nil {
 		return fmt.Errorf("failed to send signal to process: %w", err)
@@ -41,26 +135,181 @@ func (p *SSHProcess) Write(input string) error {
 	return nil
 }
 
+func (p *SSHProcess) Stdin() io.WriteCloser {
+	return nescript.StdinWriter(p)
+}
+
+func (p *SSHProcess) Stdout() io.Reader {
+	return p.tee.StdoutStream()
+}
+
+func (p *SSHProcess) Stderr() io.Reader {
+	return p.tee.StderrStream()
+}
+
+// ID returns the PID of the script on the target, where it is recorded (see
+// WithRemoteKill), otherwise it is empty.
+func (p *SSHProcess) ID() string {
+	if p.launch != nil && p.stop.kill {
+		if pid := p.launch.PID(); pid > 0 {
+			return strconv.Itoa(pid)
+		}
+	}
+	return ""
+}
+
+func (p *SSHProcess) String() string {
+	return fmt.Sprintf("ssh %s@%s", p.conn.client.User(), p.conn.client.RemoteAddr())
+}
+
+func (p *SSHProcess) Wait(ctx context.Context) (*nescript.Result, error) {
+	return nescript.Wait(ctx, p)
+}
+
 func (p *SSHProcess) Result() (*nescript.Result, error) {
-	defer p.sshSession.Close()
-	defer p.sshClient.Close()
-	exitCode := 0
-	if err := p.sshSession.Wait(); err != nil {
-		if eerr, ok := err.(*ssh.ExitError); !ok {
-			return nil, fmt.Errorf("failed to wait for ssh process: %w", err)
-		} else {
-			exitCode = eerr.ExitStatus()
+	return p.completion.Result()
+}
+
+func (p *SSHProcess) Poll() (*nescript.Result, bool) {
+	return p.completion.Poll()
+}
+
+func (p *SSHProcess) WaitContext(ctx context.Context) (*nescript.Result, error) {
+	return p.completion.WaitContext(ctx)
+}
+
+// collect waits for the script to exit, collecting its result (see
+// nescript.Completion).
+func (p *SSHProcess) collect() (*nescript.Result, error) {
+	defer p.Close()
+	<-p.done
+	<-p.watched
+	removeErr := p.removeUploaded()
+	p.mu.Lock()
+	interrupted, termination := p.interrupted, p.termination
+	p.mu.Unlock()
+	if interrupted != nil {
+		return nil, &ContextError{
+			Phase:       PhaseExecute,
+			Err:         interrupted,
+			StdOut:      p.stdoutBytes.String(),
+			StdErr:      p.stderrBytes.String(),
+			Termination: termination,
+		}
+	}
+	if err := p.stdinPipe.wait(); err != nil {
+		return nil, err
+	}
+	var exit *ssh.ExitError
+	if err := p.waitErr; err != nil && !errors.As(err, &exit) {
+		if p.conn.lost.Load() {
+			return nil, fmt.Errorf("%w: %w", ErrConnectionLost, err)
+		}
+		if p.closed != nil && p.closed() {
+			return nil, fmt.Errorf("%w: %w", ErrConnectionClosed, err)
+		}
+		var missing *ssh.ExitMissingError
+		if errors.As(err, &missing) {
+			return nil, fmt.Errorf("%w: %w", ErrExitStatusMissing, err)
 		}
+		return nil, fmt.Errorf("%w: failed to wait for ssh process: %w", ErrExecution, err)
+	}
+	if p.sudo != nil && !p.launch.Ready() {
+		return nil, p.sudo.err(p.launch.Prompts(), p.launch.Output()+p.stderrBytes.String())
+	}
+	if p.workDir != "" && p.launch != nil && !p.launch.Started() {
+		reason := strings.TrimSpace(p.launch.Output() + p.stderrBytes.String())
+		return nil, fmt.Errorf("%w: '%s': %s", ErrWorkDirNotFound, p.workDir, reason)
+	}
+	result := nescript.Result{}
+	result.SetCaptured(p.stdoutBytes, p.stderrBytes)
+	if exit != nil {
+		setExit(&result, exit)
+	}
+	result.SetTimes(p.started, p.ended)
+	result.SetMetadata(nescript.MetadataExecutor, "ssh")
+	result.SetMetadata(MetadataTermination, TerminationExited)
+	if p.platform != "" {
+		result.SetMetadata(MetadataPlatform, p.platform)
+	}
+	warnings := p.warnings
+	if removeErr != nil {
+		warnings = append(warnings, fmt.Sprintf("uploaded script '%s' was left on the target: %s", p.uploaded.path, removeErr))
+	}
+	if len(warnings) > 0 {
+		result.SetMetadata(MetadataWarnings, warnings)
+	}
+	if p.envStrategy != "" {
+		result.SetMetadata(MetadataEnvStrategy, p.envStrategy)
+	}
+	if result.StdOutTruncated || result.StdErrTruncated {
+		result.SetMetadata(MetadataTruncated, Truncated{StdOut: int(p.stdoutBytes.Dropped()), StdErr: int(p.stderrBytes.Dropped())})
 	}
-	result := nescript.Result{
-		StdOut: string(p.stdoutBytes.String()),
-		StdErr: string(p.stderrBytes.String()),
+	if p.encoding != nil {
+		result.StdOut, _ = nescript.DecodeOutput(result.StdOut, *p.encoding)
+		result.StdErr, _ = nescript.DecodeOutput(result.StdErr, *p.encoding)
 	}
-	result.ExitCode = exitCode
+	if p.pty {
+		result.StdOut = normalizePTYOutput(result.StdOut)
+	}
+	if p.windows {
+		result.StdOut = normalizeWindowsOutput(result.StdOut)
+		result.StdErr = normalizeWindowsOutput(result.StdErr)
+	}
+	if len(p.normalize) > 0 {
+		result = result.Normalized(p.normalize...)
+	}
+	p.tee.Finish(&result)
 	return &result, nil
 }
 
+// setExit records the exit status (or signal) reported for the process on the
+// result. As with other executors, a process terminated by a signal has an exit
+// code of 128 + the signal number.
+func setExit(result *nescript.Result, exit *ssh.ExitError) {
+	result.ExitCode = exit.ExitStatus()
+	if exit.Signal() == "" {
+		return
+	}
+	if number, ok := nescript.SignalNumber(exit.Signal()); ok {
+		result.SetSignal(number)
+		return
+	}
+	result.Signaled = true
+	result.Signal = "SIG" + exit.Signal()
+	if result.ExitCode == 0 {
+		result.ExitCode = 128
+	}
+}
+
 func (p *SSHProcess) Close() {
-	p.sshSession.Close()
-	p.sshClient.Close()
+	if p.sshSession != nil {
+		p.sshSession.Close()
+		p.conn.sessionClosed()
+	}
+	p.cleanup()
+}
+
+// cleanup stops any forwards, removes the uploaded script (if any) and releases
+// the connection.
+func (p *SSHProcess) cleanup() {
+	p.releaseOnce.Do(func() {
+		if p.forwarding != nil {
+			p.forwarding.Close()
+		}
+		p.tee.Finish(nil)
+		p.removeUploaded()
+		p.release()
+	})
+}
+
+// removeUploaded removes the uploaded script (if any) from the target, once
+// the script has exited or the process is closed.
+func (p *SSHProcess) removeUploaded() error {
+	p.removeOnce.Do(func() {
+		if p.uploaded != nil {
+			p.removeErr = p.uploaded.remove()
+		}
+	})
+	return p.removeErr
 }
@@ -0,0 +1,16 @@
+package sshe_test
+
+import (
+	"testing"
+
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/processtest"
+	"github.com/neaas/nescript/sshe"
+)
+
+func TestExecutorContract(t *testing.T) {
+	server := newTestServer(t)
+	if err := processtest.TestExecutor(sshe.Executor(server.Addr, server.Config, sshe.WithShell(nescript.SCShell))); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,53 @@
+package sshe
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ptySpeed is the terminal speed (in baud) requested for a PTY.
+const ptySpeed = 14400
+
+// pty describes the pseudo terminal requested for sessions.
+type pty struct {
+	term       string
+	rows, cols int
+	echo       bool
+}
+
+// request requests the PTY for the session.
+func (t *pty) request(session *ssh.Session) error {
+	echo := uint32(0)
+	if t.echo {
+		echo = 1
+	}
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          echo,
+		ssh.TTY_OP_ISPEED: ptySpeed,
+		ssh.TTY_OP_OSPEED: ptySpeed,
+	}
+	if err := session.RequestPty(t.term, t.rows, t.cols, modes); err != nil {
+		return fmt.Errorf("%w: failed to request pty: %w", ErrExecution, err)
+	}
+	return nil
+}
+
+// Resize changes the size of the process's PTY (see WithPTY), such as to match
+// the size of a local terminal the output is being shown in.
+func (p *SSHProcess) Resize(rows, cols int) error {
+	if !p.pty {
+		return fmt.Errorf("failed to resize: process has no pty")
+	}
+	if err := p.sshSession.WindowChange(rows, cols); err != nil {
+		return fmt.Errorf("failed to resize pty: %w", err)
+	}
+	return nil
+}
+
+// normalizePTYOutput converts the CRLF line endings a PTY outputs (as output
+// post-processing converts newlines) into newlines.
+func normalizePTYOutput(output string) string {
+	return strings.ReplaceAll(output, "\r\n", "\n")
+}
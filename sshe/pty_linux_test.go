@@ -0,0 +1,88 @@
+package sshe_test
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/sshe"
+)
+
+func TestPTY(t *testing.T) {
+	fakeSudo(t)
+	server := newTestServer(t)
+	// the script is double quoted by the cmd's formatter, so it has no vars.
+	isatty := "[ -t 0 ] && echo tty || echo notty; [ -t 1 ] && echo tty || echo notty; [ -t 2 ] && echo tty || echo notty"
+	for _, tc := range []struct {
+		name   string
+		opts   []sshe.Option
+		script string
+		input  string
+		stdout string
+		stderr string
+	}{
+		{"no pty", nil, isatty, "", "notty\nnotty\nnotty\n", ""},
+		{"pty", []sshe.Option{sshe.WithPTY("xterm", 24, 80)}, isatty, "", "tty\ntty\ntty\n", ""},
+		{"stderr merged", []sshe.Option{sshe.WithPTY("xterm", 24, 80)}, "echo out; echo err >&2; echo out", "", "out\nerr\nout\n", ""},
+		{"stderr without pty", nil, "echo out; echo err >&2; echo out", "", "out\nout\n", "err\n"},
+		{"term and size", []sshe.Option{sshe.WithPTY("vt100", 40, 120)}, `printenv TERM; stty size`, "", "vt100\n40 120\n", ""},
+		{"echo off", []sshe.Option{sshe.WithPTY("xterm", 24, 80)}, "sed 's/^/got /; q'", "hi\n", "got hi\n", ""},
+		{"echo", []sshe.Option{sshe.WithPTY("xterm", 24, 80), sshe.WithPTYEcho()}, "sed 's/^/got /; q'", "hi\n", "hi\ngot hi\n", ""},
+		{"sudo", []sshe.Option{sshe.WithSudo(""), sshe.WithPTY("xterm", 24, 80)}, isatty + "; sed 's/^/got /; q'", "hi\n", "tty\ntty\ntty\ngot hi\n", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			process, err := nescript.NewScript(tc.script).Cmd().Exec(sshe.Executor(server.Addr, server.Config, tc.opts...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.input != "" {
+				if err := process.Write(tc.input); err != nil {
+					t.Fatal(err)
+				}
+			}
+			result, err := process.Result()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.StdOut != tc.stdout || result.StdErr != tc.stderr || result.ExitCode != 0 {
+				t.Errorf("expected stdout %q and stderr %q, got %q, %q and exit code %d", tc.stdout, tc.stderr, result.StdOut, result.StdErr, result.ExitCode)
+			}
+		})
+	}
+}
+
+func TestPTYResize(t *testing.T) {
+	server := newTestServer(t)
+	script := "trap 'stty size; exit' WINCH; echo ready; while :; do sleep 0.1; done"
+	process, err := nescript.NewScript(script).Cmd().Exec(sshe.Executor(server.Addr, server.Config, sshe.WithPTY("xterm", 24, 80)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(process.Stdout()).ReadString('\n')
+	if err != nil || strings.TrimSpace(line) != "ready" {
+		t.Fatalf("expected the ready line, got %q (%v)", line, err)
+	}
+	if err := process.(*sshe.SSHProcess).Resize(30, 100); err != nil {
+		t.Fatal(err)
+	}
+	result, err := process.Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.StdOut != "ready\n30 100\n" {
+		t.Errorf("expected the script to see the new size, got %q", result.StdOut)
+	}
+}
+
+func TestPTYResizeWithoutPTY(t *testing.T) {
+	server := newTestServer(t)
+	process, err := nescript.NewScript("true").Cmd().Exec(sshe.Executor(server.Addr, server.Config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer process.Result()
+	if err := process.(*sshe.SSHProcess).Resize(30, 100); err == nil {
+		t.Error("expected an error resizing a process without a pty")
+	}
+}
@@ -0,0 +1,166 @@
+package sshe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// maxRetryErrors is the number of the most recent errors kept on a RetryError.
+const maxRetryErrors = 5
+
+// transientMessages are fragments of error messages that indicate a transient
+// failure, for errors that do not wrap the underlying cause.
+var transientMessages = []string{
+	"connection reset",
+	"connection refused",
+	"broken pipe",
+	"no route to host",
+	"network is unreachable",
+	"i/o timeout",
+	"unexpected eof",
+}
+
+// permanentErrors are never transient, as retrying can not change the outcome.
+var permanentErrors = []error{
+	context.Canceled,
+	context.DeadlineExceeded,
+	ErrAuthentication,
+	ErrAgentUnavailable,
+	ErrUnsupportedAlgorithm,
+	ErrNoCommonAlgorithm,
+	ErrUnknownHostKey,
+	ErrHostKeyMismatch,
+	ErrHostCertificateInvalid,
+	ErrConnectionClosed,
+	ErrSFTPUnavailable,
+	ErrUnsupportedOnWindows,
+}
+
+// IsTransient reports whether an error connecting to the target, or opening a
+// session on it, is likely to succeed if retried, such as a refused or reset
+// connection while the target reboots, or a connect timeout. Authentication
+// and host key failures, and cancellation, are never transient.
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	var contextErr *ContextError
+	if errors.As(err, &contextErr) {
+		return contextErr.Phase == PhaseConnect && errors.Is(contextErr.Err, context.DeadlineExceeded)
+	}
+	for _, permanent := range permanentErrors {
+		if errors.Is(err, permanent) {
+			return false
+		}
+	}
+	var channelErr *ssh.OpenChannelError
+	if errors.As(err, &channelErr) {
+		return channelErr.Reason == ssh.ResourceShortage
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, syscall.EHOSTUNREACH) || errors.Is(err, syscall.ENETUNREACH) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	for _, fragment := range transientMessages {
+		if strings.Contains(msg, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+// RetryError is returned when connecting to the target (or starting a session
+// on it) failed after being retried. It wraps the most recent errors that were
+// encountered, the last being the one that caused the retries to stop.
+type RetryError struct {
+	Target   string
+	Attempts int
+	Errors   []error
+}
+
+func (e *RetryError) Error() string {
+	last := e.Errors[len(e.Errors)-1]
+	class := "permanent"
+	if IsTransient(last) {
+		class = "transient"
+	}
+	return fmt.Sprintf("ssh execution on '%s' failed after %d attempt(s), last error was %s: %s", e.Target, e.Attempts, class, last)
+}
+
+func (e *RetryError) Unwrap() []error {
+	return e.Errors
+}
+
+// noRetry marks an error as one that must not be retried regardless of its
+// class, as the script may already have started.
+type noRetry struct {
+	err error
+}
+
+func (e noRetry) Error() string {
+	return e.err.Error()
+}
+
+func (e noRetry) Unwrap() error {
+	return e.err
+}
+
+// retryPolicy retries connecting and starting sessions when they fail with
+// transient errors, with an exponential backoff between attempts.
+type retryPolicy struct {
+	attempts int
+	backoff  time.Duration
+}
+
+// do runs the operation, retrying it while it fails with a transient error and
+// attempts remain. Cancelling the context stops the retries immediately. If the
+// operation is never retried, its error is returned as is, otherwise a
+// *RetryError is returned.
+func (r retryPolicy) do(ctx context.Context, target string, fn func() error) error {
+	errs := make([]error, 0)
+	backoff := r.backoff
+	retryErr := func(attempt int) error {
+		if len(errs) > maxRetryErrors {
+			errs = errs[len(errs)-maxRetryErrors:]
+		}
+		return &RetryError{Target: target, Attempts: attempt, Errors: errs}
+	}
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		var stop noRetry
+		if errors.As(err, &stop) {
+			err = stop.err
+		}
+		errs = append(errs, err)
+		if attempt >= r.attempts || stop.err != nil || ctx.Err() != nil || !IsTransient(err) {
+			if attempt == 1 {
+				return err
+			}
+			return retryErr(attempt)
+		}
+		select {
+		case <-ctx.Done():
+			errs = append(errs, ctx.Err())
+			return retryErr(attempt)
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,132 @@
+package sshe
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/neaas/nescript/internal/shellquote"
+	"golang.org/x/crypto/ssh"
+)
+
+// Transfer is the method scripts are uploaded to the target with (see
+// WithUpload).
+type Transfer string
+
+const (
+	// TransferAuto uploads scripts over SFTP, falling back to SCP when the
+	// target does not provide the SFTP subsystem. This is the default.
+	TransferAuto Transfer = "auto"
+
+	// TransferSFTP only uploads scripts over SFTP.
+	TransferSFTP Transfer = "sftp"
+
+	// TransferSCP only uploads scripts with SCP, running `scp -t` on the
+	// target.
+	TransferSCP Transfer = "scp"
+)
+
+// errSCPUnavailable is returned (wrapped) when `scp -t` could not be started
+// on the target, such as it not being installed, as opposed to a transfer
+// that was started failing.
+var errSCPUnavailable = errors.New("scp unavailable on target")
+
+// scpScript uploads the script to the path with the SCP protocol (in source
+// mode), by running `scp -t` on the target with the directory of the path,
+// and sending the file with 0700 permissions. The -d flag makes the sink
+// refuse a directory that does not exist, rather than writing the script to
+// that path.
+func scpScript(ctx context.Context, client *ssh.Client, file, script string) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("%w: %w", errSCPUnavailable, err)
+	}
+	defer session.Close()
+	stop := context.AfterFunc(ctx, func() { session.Close() })
+	defer stop()
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	var stderr bytes.Buffer
+	session.Stderr = &stderr
+	if err := session.Start("scp -t -d -- " + shellquote.POSIX(path.Dir(file))); err != nil {
+		return fmt.Errorf("%w: %w", errSCPUnavailable, err)
+	}
+	err = scpSend(stdin, bufio.NewReader(stdout), path.Base(file), script)
+	stdin.Close()
+	waitErr := session.Wait()
+	if err == nil {
+		err = waitErr
+	}
+	if err != nil && stderr.Len() > 0 {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return err
+}
+
+// scpSend sends the file to an SCP sink: waiting for the sink to be ready,
+// then sending the C (file) header with the mode, size and name, followed by
+// the contents and a NUL, with the sink acknowledging each.
+func scpSend(w io.Writer, r *bufio.Reader, name, contents string) error {
+	// a sink that never responds was not started, such as scp not being
+	// installed, whereas one that responds with an error (such as the
+	// directory not existing) fails the upload.
+	if _, err := r.Peek(1); err != nil {
+		return fmt.Errorf("%w: scp: no response: %w", errSCPUnavailable, err)
+	}
+	if err := scpAck(r); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "C0700 %d %s\n", len(contents), name); err != nil {
+		return err
+	}
+	if err := scpAck(r); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, contents+"\x00"); err != nil {
+		return err
+	}
+	return scpAck(r)
+}
+
+// scpAck reads the sink's response, which is a NUL if successful, otherwise 1
+// (a warning) or 2 (a fatal error) followed by a message line.
+func scpAck(r *bufio.Reader) error {
+	b, err := r.ReadByte()
+	if err != nil {
+		return fmt.Errorf("scp: no response: %w", err)
+	}
+	switch b {
+	case 0:
+		return nil
+	case 1, 2:
+		message, _ := r.ReadString('\n')
+		return fmt.Errorf("scp failed: %s", strings.TrimSpace(message))
+	default:
+		return fmt.Errorf("scp: unexpected response %q", b)
+	}
+}
+
+// removeSCP deletes the file at the path on the target with rm, for scripts
+// uploaded with SCP, as SFTP is unavailable.
+func removeSCP(client *ssh.Client, file string) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	if output, err := session.CombinedOutput("rm -f -- " + shellquote.POSIX(file)); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package sshe_test
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/sshe"
+)
+
+// fakeSCPScript is an SCP sink that replies with the responses recorded in
+// FAKE_SCP_RESPONSES (in printf format), recording what it is sent to
+// FAKE_SCP_RECORD and writing the file it is sent to the sink directory.
+// Without responses, it fails as a target without scp does.
+const fakeSCPScript = `#!/bin/sh
+[ -n "$FAKE_SCP_RESPONSES" ] || { echo "sh: 1: scp: not found" >&2; exit 127; }
+printf "$FAKE_SCP_RESPONSES"
+tee "$FAKE_SCP_RECORD" | {
+	read -r mode size name && head -c "$size" > "$4/$name" && chmod "${mode#C}" "$4/$name"
+	cat > /dev/null
+}
+`
+
+// fakeSCP puts the fake SCP sink on the PATH of the test server's commands,
+// replying with the responses, and returns the path its input is recorded to.
+func fakeSCP(t *testing.T, responses string) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "scp"), []byte(fakeSCPScript), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	record := filepath.Join(dir, "record")
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("FAKE_SCP_RESPONSES", responses)
+	t.Setenv("FAKE_SCP_RECORD", record)
+	return record
+}
+
+func TestSCPUpload(t *testing.T) {
+	const script = "echo uploaded"
+	for _, tc := range []struct {
+		name      string
+		responses string
+		stdout    string
+		err       string
+		sentinel  error
+	}{
+		{"accepted", `\0\0\0`, "uploaded\n", "", nil},
+		{"missing directory", `\2scp: /missing: No such file or directory\n`, "", "scp failed: scp: /missing: No such file or directory", sshe.ErrExecution},
+		{"header refused", `\0\1scp: disk quota exceeded\n`, "", "scp failed: scp: disk quota exceeded", sshe.ErrExecution},
+		{"contents refused", `\0\0\2scp: write failed\n`, "", "scp failed: scp: write failed", sshe.ErrExecution},
+		{"unexpected response", `\0X`, "", `scp: unexpected response 'X'`, sshe.ErrExecution},
+		{"no sink", "", "", "scp: no response", sshe.ErrSFTPUnavailable},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			record := fakeSCP(t, tc.responses)
+			server := newTestServer(t)
+			dir := t.TempDir()
+			executor := sshe.Executor(server.Addr, server.Config, sshe.WithUpload(), sshe.WithUploadDir(dir), sshe.WithTransfer(sshe.TransferSCP))
+			process, err := nescript.NewScript(script).Cmd().Exec(executor)
+			if tc.err != "" {
+				if !errors.Is(err, tc.sentinel) || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("expected %v with '%s', got %v", tc.sentinel, tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := process.Result()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.StdOut != tc.stdout {
+				t.Errorf("expected stdout %q, got %q", tc.stdout, result.StdOut)
+			}
+			sent, err := os.ReadFile(record)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := regexp.MustCompile(`^C0700 ` + strconv.Itoa(len(script)) + ` nescript-[0-9]+-[0-9a-f]{32}\n` + script + "\x00$")
+			if !want.Match(sent) {
+				t.Errorf("expected the C header, contents and NUL sent, got %q", sent)
+			}
+			if commands := server.Commands(); !strings.HasPrefix(commands[0], "scp -t -d -- '"+dir+"'") {
+				t.Errorf("expected the sink started in the upload dir, got %q", commands[0])
+			}
+		})
+	}
+}
+
+func TestSCPUploadToSCP(t *testing.T) {
+	if _, err := exec.LookPath("scp"); err != nil {
+		t.Skip("scp is not installed")
+	}
+	server := newTestServer(t)
+	executor := sshe.Executor(server.Addr, server.Config, sshe.WithUpload(), sshe.WithUploadDir(t.TempDir()), sshe.WithTransfer(sshe.TransferSCP))
+	process, err := nescript.NewScript("echo uploaded").Cmd().Exec(executor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := process.Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.StdOut != "uploaded\n" {
+		t.Errorf("expected the uploaded script executed, got %q", result.StdOut)
+	}
+	missing := sshe.Executor(server.Addr, server.Config, sshe.WithUpload(), sshe.WithUploadDir(filepath.Join(t.TempDir(), "missing")), sshe.WithTransfer(sshe.TransferSCP))
+	if _, err := nescript.NewScript("echo uploaded").Cmd().Exec(missing); !errors.Is(err, sshe.ErrExecution) || !strings.Contains(err.Error(), "scp failed") {
+		t.Errorf("expected a missing upload dir to fail the upload, got %v", err)
+	}
+}
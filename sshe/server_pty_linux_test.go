@@ -0,0 +1,82 @@
+package sshe_test
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// startPTY starts the cmd in a new session with a pseudo terminal of the
+// requested size and modes as its controlling terminal, returning the
+// terminal's master side.
+func startPTY(cmd *exec.Cmd, req ptyRequest) (*os.File, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, err
+	}
+	var n uint32
+	unlock := int32(0)
+	if err = ioctl(master, syscall.TIOCGPTN, unsafe.Pointer(&n)); err == nil {
+		err = ioctl(master, syscall.TIOCSPTLCK, unsafe.Pointer(&unlock))
+	}
+	if err == nil {
+		err = resizePTY(master, req.Rows, req.Columns)
+	}
+	if err != nil {
+		master.Close()
+		return nil, err
+	}
+	slave, err := os.OpenFile("/dev/pts/"+strconv.Itoa(int(n)), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, err
+	}
+	defer slave.Close()
+	if echo, ok := req.mode(ssh.ECHO); ok && echo == 0 {
+		var termios syscall.Termios
+		if err = ioctl(slave, syscall.TCGETS, unsafe.Pointer(&termios)); err == nil {
+			termios.Lflag &^= syscall.ECHO
+			err = ioctl(slave, syscall.TCSETS, unsafe.Pointer(&termios))
+		}
+	}
+	if err == nil {
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = slave, slave, slave
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+		err = cmd.Start()
+	}
+	if err != nil {
+		master.Close()
+		return nil, err
+	}
+	return master, nil
+}
+
+// resizePTY sets the size of the terminal, which signals its foreground
+// process group with SIGWINCH.
+func resizePTY(terminal *os.File, rows, cols uint32) error {
+	size := struct{ Rows, Cols, X, Y uint16 }{Rows: uint16(rows), Cols: uint16(cols)}
+	return ioctl(terminal, syscall.TIOCSWINSZ, unsafe.Pointer(&size))
+}
+
+// ioctl makes the ioctl request on the file, without putting it in blocking
+// mode (as Fd would).
+func ioctl(f *os.File, request uintptr, arg unsafe.Pointer) error {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var errno syscall.Errno
+	if err := conn.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(arg))
+	}); err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
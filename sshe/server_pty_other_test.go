@@ -0,0 +1,21 @@
+//go:build !linux
+
+package sshe_test
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// errPTYUnsupported is returned by startPTY where the test server does not
+// support pseudo terminals.
+var errPTYUnsupported = errors.New("pty is not supported by the test server")
+
+func startPTY(cmd *exec.Cmd, req ptyRequest) (*os.File, error) {
+	return nil, errPTYUnsupported
+}
+
+func resizePTY(terminal *os.File, rows, cols uint32) error {
+	return errPTYUnsupported
+}
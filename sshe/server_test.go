@@ -0,0 +1,494 @@
+package sshe_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// testServer is an in-process SSH server executing each command it is sent
+// with sh -c, so that the executor can be tested without a target.
+type testServer struct {
+	// Addr is the address the server listens on, as host:port.
+	Addr string
+
+	// Config is a client config authenticating with the server.
+	Config *ssh.ClientConfig
+
+	// HostKey is the server's host key.
+	HostKey ssh.PublicKey
+
+	// RejectEnv rejects every env var set on a session, as a server without
+	// AcceptEnv does.
+	RejectEnv bool
+
+	// NoSFTP rejects the sftp subsystem, as appliances disabling it do.
+	NoSFTP bool
+
+	// NoExitStatus closes sessions without reporting the exit status (or
+	// signal) of their command, as some servers do when torn down.
+	NoExitStatus bool
+
+	mu         sync.Mutex
+	authorized [][]byte
+	commands   []string
+	forwarded  []string
+	sessions   int
+	conns      []net.Conn
+}
+
+// signals are the signals sent by the executor, by their SSH names.
+var signals = map[ssh.Signal]os.Signal{
+	ssh.SIGINT:  os.Interrupt,
+	ssh.SIGKILL: os.Kill,
+	ssh.SIGTERM: syscall.SIGTERM,
+}
+
+// signalName returns the SSH name of the signal the process was terminated by,
+// if it was terminated by one of the signals.
+func signalName(state *os.ProcessState) (ssh.Signal, bool) {
+	status, ok := state.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() {
+		return "", false
+	}
+	for name, signal := range signals {
+		if signal == os.Signal(status.Signal()) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// newTestServer starts a test server, closed once the test completes.
+func newTestServer(t testing.TB) *testServer {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(meta ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if meta.User() != "test" || string(password) != "test" {
+				return nil, errors.New("invalid credentials")
+			}
+			return nil, nil
+		},
+	}
+	s := &testServer{}
+	config.PublicKeyCallback = func(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, authorized := range s.authorized {
+			if meta.User() == "test" && bytes.Equal(authorized, key.Marshal()) {
+				return nil, nil
+			}
+		}
+		return nil, errors.New("unauthorized key")
+	}
+	config.AddHostKey(signer)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	s.Addr = listener.Addr().String()
+	s.Config = &ssh.ClientConfig{
+		User:            "test",
+		Auth:            []ssh.AuthMethod{ssh.Password("test")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}
+	s.HostKey = signer.PublicKey()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn, config)
+		}
+	}()
+	return s
+}
+
+// Authorize accepts the key for the test user, as an authorized_keys entry
+// does.
+func (s *testServer) Authorize(key ssh.PublicKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.authorized = append(s.authorized, key.Marshal())
+}
+
+// Commands returns the commands the server was sent, in the order they were
+// received.
+func (s *testServer) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.commands...)
+}
+
+// Forwarded returns the addresses the server was asked to connect
+// direct-tcpip channels to, in order.
+func (s *testServer) Forwarded() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.forwarded...)
+}
+
+// Connections returns the number of connections the server accepted.
+func (s *testServer) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+// Drop closes every connection to the server, as a target restarting does.
+func (s *testServer) Drop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+}
+
+// Sessions returns the number of sessions opened on the server.
+func (s *testServer) Sessions() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sessions
+}
+
+// Stall stops the server responding on every connection made so far, as if a
+// firewall had silently dropped them, until they are dropped (see Drop).
+func (s *testServer) Stall() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		conn.(*stallingConn).stall()
+	}
+}
+
+// stallingConn is a connection that can be stalled, after which the data it
+// reads is discarded and writes block until it is closed (or the peer closes
+// it).
+type stallingConn struct {
+	net.Conn
+	once    sync.Once
+	stalled chan struct{}
+	closed  chan struct{}
+}
+
+func (c *stallingConn) stall() {
+	select {
+	case <-c.stalled:
+	default:
+		close(c.stalled)
+	}
+}
+
+func (c *stallingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if err != nil {
+		return n, err
+	}
+	select {
+	case <-c.stalled:
+		<-c.closed
+		return 0, net.ErrClosed
+	default:
+		return n, err
+	}
+}
+
+func (c *stallingConn) Write(p []byte) (int, error) {
+	select {
+	case <-c.stalled:
+		<-c.closed
+		return 0, net.ErrClosed
+	default:
+		return c.Conn.Write(p)
+	}
+}
+
+func (c *stallingConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return c.Conn.Close()
+}
+
+func (s *testServer) serve(raw net.Conn, config *ssh.ServerConfig) {
+	conn := &stallingConn{Conn: raw, stalled: make(chan struct{}), closed: make(chan struct{})}
+	defer conn.Close()
+	s.mu.Lock()
+	s.conns = append(s.conns, conn)
+	s.mu.Unlock()
+	_, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		if newChannel.ChannelType() == "direct-tcpip" {
+			go s.directTCPIP(newChannel)
+			continue
+		}
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions and direct-tcpip are supported")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.sessions++
+		s.mu.Unlock()
+		go s.session(channel, requests)
+	}
+}
+
+// directTCPIP connects a direct-tcpip channel to the address it asks for, as a
+// jump host does.
+func (s *testServer) directTCPIP(newChannel ssh.NewChannel) {
+	var payload struct {
+		Host     string
+		Port     uint32
+		OrigHost string
+		OrigPort uint32
+	}
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &payload); err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	address := net.JoinHostPort(payload.Host, strconv.Itoa(int(payload.Port)))
+	s.mu.Lock()
+	s.forwarded = append(s.forwarded, address)
+	s.mu.Unlock()
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	go func() {
+		io.Copy(conn, channel)
+		conn.Close()
+	}()
+	io.Copy(channel, conn)
+	channel.Close()
+}
+
+// session serves the requests of a session, executing its command once sent.
+func (s *testServer) session(channel ssh.Channel, requests <-chan *ssh.Request) {
+	var (
+		env      []string
+		cmd      *exec.Cmd
+		pty      *ptyRequest
+		terminal *os.File
+	)
+	for req := range requests {
+		switch req.Type {
+		case "env":
+			var payload struct{ Name, Value string }
+			if s.RejectEnv || ssh.Unmarshal(req.Payload, &payload) != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			env = append(env, payload.Name+"="+payload.Value)
+			req.Reply(true, nil)
+		case "subsystem":
+			var payload struct{ Name string }
+			if s.NoSFTP || cmd != nil || ssh.Unmarshal(req.Payload, &payload) != nil || payload.Name != "sftp" {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+			go func() {
+				if server, err := sftp.NewServer(channel); err == nil {
+					server.Serve()
+					server.Close()
+				}
+				channel.Close()
+			}()
+		case "pty-req":
+			var payload ptyRequest
+			if cmd != nil || ssh.Unmarshal(req.Payload, &payload) != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			pty = &payload
+			req.Reply(true, nil)
+		case "window-change":
+			var payload struct{ Columns, Rows, Width, Height uint32 }
+			if terminal == nil || ssh.Unmarshal(req.Payload, &payload) != nil {
+				continue
+			}
+			resizePTY(terminal, payload.Rows, payload.Columns)
+		case "exec":
+			var payload struct{ Command string }
+			if cmd != nil || ssh.Unmarshal(req.Payload, &payload) != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			s.mu.Lock()
+			s.commands = append(s.commands, payload.Command)
+			s.mu.Unlock()
+			cmd = exec.Command("sh", "-c", payload.Command)
+			cmd.Env = append(os.Environ(), env...)
+			var output sync.WaitGroup
+			if pty != nil {
+				// the terminal's output is read until the cmd (and any
+				// process it started) has closed it, before the exit status.
+				var err error
+				cmd.Env = append(cmd.Env, "TERM="+pty.Term)
+				if terminal, err = startPTY(cmd, *pty); err != nil {
+					req.Reply(false, nil)
+					channel.Close()
+					return
+				}
+				req.Reply(true, nil)
+				go io.Copy(terminal, channel)
+				output.Add(1)
+				go func(terminal *os.File) {
+					io.Copy(channel, terminal)
+					output.Done()
+				}(terminal)
+			} else {
+				cmd.Stdout, cmd.Stderr = channel, channel.Stderr()
+				// the stdin is copied rather than given to the cmd, as Wait
+				// would otherwise wait for the client to close it.
+				stdin, err := cmd.StdinPipe()
+				if err == nil {
+					err = cmd.Start()
+				}
+				if err != nil {
+					req.Reply(false, nil)
+					channel.Close()
+					return
+				}
+				req.Reply(true, nil)
+				go func() {
+					io.Copy(stdin, channel)
+					stdin.Close()
+				}()
+			}
+			go func(cmd *exec.Cmd, terminal *os.File) {
+				cmd.Wait()
+				output.Wait()
+				if terminal != nil {
+					terminal.Close()
+				}
+				if signal, ok := signalName(cmd.ProcessState); ok && !s.NoExitStatus {
+					channel.SendRequest("exit-signal", false, ssh.Marshal(struct {
+						Signal     string
+						CoreDumped bool
+						Error      string
+						Lang       string
+					}{Signal: string(signal)}))
+				} else if !s.NoExitStatus {
+					channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(cmd.ProcessState.ExitCode())}))
+				}
+				channel.Close()
+			}(cmd, terminal)
+		case "signal":
+			var payload struct{ Signal string }
+			if cmd == nil || ssh.Unmarshal(req.Payload, &payload) != nil {
+				continue
+			}
+			if signal, ok := signals[ssh.Signal(payload.Signal)]; ok {
+				cmd.Process.Signal(signal)
+			}
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+// ptyRequest is the payload of a pty-req request.
+type ptyRequest struct {
+	Term                         string
+	Columns, Rows, Width, Height uint32
+	Modes                        string
+}
+
+// mode returns the value of the terminal mode with the opcode (such as
+// ssh.ECHO), if it was requested.
+func (r ptyRequest) mode(opcode uint8) (uint32, bool) {
+	modes := []byte(r.Modes)
+	// each mode is an opcode followed by its value, until TTY_OP_END (0).
+	for len(modes) >= 5 && modes[0] != 0 {
+		if modes[0] == opcode {
+			return binary.BigEndian.Uint32(modes[1:5]), true
+		}
+		modes = modes[5:]
+	}
+	return 0, false
+}
+
+// fakeSudoScript behaves as sudo does for the options used by the executor,
+// running the command after -- as a child, so that its PID is not that of the
+// command. The password is "hunter2", tried up to 3 times; FAKE_SUDO_DENIED
+// denies the user, and FAKE_SUDO_PASSWORD_REQUIRED requires a password.
+const fakeSudoScript = `#!/bin/sh
+prompt="[sudo] password: "
+stdin=false
+while [ "$1" != "--" ]; do
+	case "$1" in
+	-S) stdin=true ;;
+	-p) shift; prompt="$1" ;;
+	-u) shift ;;
+	esac
+	shift
+done
+shift
+if [ "$stdin" = true ]; then
+	tries=0
+	while :; do
+		printf '%s' "$prompt" >&2
+		IFS= read -r password || { echo "sudo: no password was provided" >&2; exit 1; }
+		[ "$password" = hunter2 ] && break
+		tries=$((tries + 1))
+		[ "$tries" -ge 3 ] && { echo "sudo: 3 incorrect password attempts" >&2; exit 1; }
+		echo "Sorry, try again." >&2
+	done
+elif [ -n "$FAKE_SUDO_PASSWORD_REQUIRED" ]; then
+	echo "sudo: a password is required" >&2
+	exit 1
+fi
+if [ -n "$FAKE_SUDO_DENIED" ]; then
+	echo "test is not in the sudoers file.  This incident will be reported." >&2
+	exit 1
+fi
+"$@"
+`
+
+// fakeSudo puts the fake sudo on the PATH of the test server's commands.
+func fakeSudo(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "sudo"), []byte(fakeSudoScript), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
@@ -0,0 +1,124 @@
+package sshe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// sessionPollInterval is how often a queued execution retries opening its
+// session, in case sessions were freed by something other than another
+// execution (such as another client of the connection).
+const sessionPollInterval = 250 * time.Millisecond
+
+// QueueFunc is called with the number of executions waiting for a session on
+// the connection to the target, each time it changes (see WithSessionQueue).
+type QueueFunc func(target string, depth int)
+
+// sessionLimit describes how executions are handled when the target refuses to
+// open more sessions on a connection, such as once OpenSSH's MaxSessions
+// (10 by default) are open.
+type sessionLimit struct {
+	queue    bool
+	wait     time.Duration
+	overflow bool
+	hook     QueueFunc
+}
+
+// sessions tracks the executions queued for a session on a connection.
+type sessions struct {
+	mu     sync.Mutex
+	freed  chan struct{}
+	queued int
+}
+
+// isSessionLimit reports whether the target refused to open a session as too
+// many are open on the connection. OpenSSH reports this as administratively
+// prohibited, while others report a resource shortage.
+func isSessionLimit(err error) bool {
+	var channelErr *ssh.OpenChannelError
+	if errors.As(err, &channelErr) {
+		return channelErr.Reason == ssh.Prohibited || channelErr.Reason == ssh.ResourceShortage
+	}
+	return false
+}
+
+// newSession opens a session on the connection. If the target refuses as too
+// many are open, with a session queue the session is retried as others are
+// closed, until the wait limit (if any) passes or the context is done.
+func (c *connection) newSession(ctx context.Context, target string, limit sessionLimit) (*ssh.Session, error) {
+	session, err := c.client.NewSession()
+	if err == nil || !isSessionLimit(err) {
+		return session, err
+	}
+	if !limit.queue {
+		return nil, fmt.Errorf("%w: %w", ErrSessionLimit, err)
+	}
+	c.queue(target, limit.hook, 1)
+	defer c.queue(target, limit.hook, -1)
+	var expired <-chan time.Time
+	if limit.wait > 0 {
+		timer := time.NewTimer(limit.wait)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	ticker := time.NewTicker(sessionPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w: %w", ErrSessionLimit, ctx.Err())
+		case <-expired:
+			return nil, fmt.Errorf("%w: no session was freed within %s: %w", ErrSessionLimit, limit.wait, err)
+		case <-c.freed():
+		case <-ticker.C:
+		}
+		session, err = c.client.NewSession()
+		if err == nil || !isSessionLimit(err) {
+			return session, err
+		}
+	}
+}
+
+// queue changes the number of executions waiting for a session, calling the
+// hook (if any) with the new depth.
+func (c *connection) queue(target string, hook QueueFunc, delta int) {
+	c.sessions.mu.Lock()
+	c.sessions.queued += delta
+	depth := c.sessions.queued
+	c.sessions.mu.Unlock()
+	if hook != nil {
+		hook(target, depth)
+	}
+}
+
+// queued returns the number of executions waiting for a session.
+func (c *connection) queued() int {
+	c.sessions.mu.Lock()
+	defer c.sessions.mu.Unlock()
+	return c.sessions.queued
+}
+
+// freed returns a channel closed the next time a session is closed.
+func (c *connection) freed() <-chan struct{} {
+	c.sessions.mu.Lock()
+	defer c.sessions.mu.Unlock()
+	if c.sessions.freed == nil {
+		c.sessions.freed = make(chan struct{})
+	}
+	return c.sessions.freed
+}
+
+// sessionClosed wakes the executions waiting for a session.
+func (c *connection) sessionClosed() {
+	c.sessions.mu.Lock()
+	defer c.sessions.mu.Unlock()
+	if c.sessions.freed != nil {
+		close(c.sessions.freed)
+		c.sessions.freed = nil
+	}
+}
@@ -0,0 +1,585 @@
+package sshe
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/neaas/nescript"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// maxIncludeDepth limits how deeply Include directives may be nested, as
+	// OpenSSH does, to guard against include loops.
+	maxIncludeDepth = 16
+
+	// defaultServerAliveCountMax is the number of keepalives that may go
+	// unanswered when only ServerAliveInterval is set, as with OpenSSH.
+	defaultServerAliveCountMax = 3
+)
+
+// defaultIdentityFiles are the private keys OpenSSH tries when no IdentityFile
+// is configured, relative to the home directory.
+var defaultIdentityFiles = []string{
+	".ssh/id_rsa",
+	".ssh/id_ecdsa",
+	".ssh/id_ed25519",
+}
+
+// errIdentitySkipped is returned (wrapped) by an identity file that does not
+// exist or can not be used without a passphrase, in which case other methods
+// (such as the agent) are tried instead, as OpenSSH does.
+var errIdentitySkipped = errors.New("identity file skipped")
+
+// SSHConfig is the connection settings for a host alias, resolved from an
+// OpenSSH client config file (see LoadSSHConfig). Options (and an executor)
+// for connecting to the host can be created from it. Fields not set by the
+// config hold the defaults OpenSSH would use.
+type SSHConfig struct {
+	Alias         string
+	HostName      string
+	Port          int
+	User          string
+	IdentityFiles []string
+
+	// ProxyJump are the jump hosts the host is dialed through, each of which is
+	// also resolved from the config.
+	ProxyJump []*SSHConfig
+
+	// UserKnownHostsFile is the known_hosts file host keys are verified
+	// against. If empty, ~/.ssh/known_hosts is used.
+	UserKnownHostsFile string
+
+	// StrictHostKeyChecking is the host key mode the config maps to.
+	StrictHostKeyChecking HostKeyMode
+
+	// IdentityAgent is the agent socket, "none" to not use an agent, or empty
+	// to use $SSH_AUTH_SOCK.
+	IdentityAgent string
+
+	ConnectTimeout      time.Duration
+	ServerAliveInterval time.Duration
+	ServerAliveCountMax int
+
+	// Warnings lists the directives that apply to the host but are not
+	// supported, and so were ignored.
+	Warnings []string
+}
+
+// LoadSSHConfig resolves the host alias from the user's OpenSSH client config
+// (~/.ssh/config). If the user has no config, the alias is resolved with the
+// OpenSSH defaults. See ParseSSHConfig.
+func LoadSSHConfig(alias string) (*SSHConfig, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find ssh config: %w", err)
+	}
+	return ParseSSHConfig(filepath.Join(home, ".ssh", "config"), alias)
+}
+
+// ParseSSHConfig resolves the host alias from the OpenSSH client config file at
+// the path. As with OpenSSH, the first value given for a directive by a
+// matching Host or Match block is used (except IdentityFile, which may be given
+// many times). Include directives (with globs, relative to ~/.ssh) are followed,
+// and Match blocks are supported with the all, host, originalhost, user and
+// localuser criteria; blocks with other criteria are treated as not matching.
+// HostName, User, Port, IdentityFile, ProxyJump, UserKnownHostsFile,
+// StrictHostKeyChecking, IdentityAgent, ConnectTimeout, ServerAliveInterval and
+// ServerAliveCountMax are supported, and other directives are ignored, being
+// listed in the Warnings. A missing file is treated as empty.
+func ParseSSHConfig(path, alias string) (*SSHConfig, error) {
+	return parseSSHConfig(path, alias, map[string]bool{})
+}
+
+func parseSSHConfig(path, alias string, resolving map[string]bool) (*SSHConfig, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find home directory: %w", err)
+	}
+	p := &configParser{
+		alias:  alias,
+		home:   home,
+		values: make(map[string][][]string),
+	}
+	if current, err := user.Current(); err == nil {
+		p.localUser = current.Username
+	}
+	if err := p.parseFile(path, 0); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	config, err := p.resolve()
+	if err != nil {
+		return nil, err
+	}
+	if jumps := p.first("proxyjump"); jumps != "" && !strings.EqualFold(jumps, "none") {
+		resolving[alias] = true
+		defer delete(resolving, alias)
+		for _, jump := range strings.Split(jumps, ",") {
+			hop, err := parseJump(path, jump, resolving)
+			if err != nil {
+				return nil, err
+			}
+			config.ProxyJump = append(config.ProxyJump, hop)
+		}
+	}
+	return config, nil
+}
+
+// parseJump resolves a ProxyJump host, given as [user@]host[:port], from the
+// config. A user or port given in the jump override those of the config.
+func parseJump(path, jump string, resolving map[string]bool) (*SSHConfig, error) {
+	jumpUser, host, _ := strings.Cut(strings.TrimSpace(jump), "@")
+	if host == "" {
+		jumpUser, host = "", jumpUser
+	}
+	port := ""
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		host, port = h, p
+	}
+	if resolving[host] {
+		return nil, fmt.Errorf("ssh config proxy jump loop at '%s'", host)
+	}
+	hop, err := parseSSHConfig(path, host, resolving)
+	if err != nil {
+		return nil, fmt.Errorf("proxy jump '%s': %w", jump, err)
+	}
+	if jumpUser != "" {
+		hop.User = jumpUser
+	}
+	if port != "" {
+		if hop.Port, err = strconv.Atoi(port); err != nil {
+			return nil, fmt.Errorf("invalid proxy jump port '%s'", port)
+		}
+	}
+	return hop, nil
+}
+
+// Address returns the target address of the host, in the form host:port.
+func (c *SSHConfig) Address() string {
+	return net.JoinHostPort(c.HostName, strconv.Itoa(c.Port))
+}
+
+// Options returns the options for connecting to the host. Identity files that
+// do not exist or are encrypted are skipped (as the passphrase is not known),
+// so encrypted keys should be added to the agent.
+func (c *SSHConfig) Options() []Option {
+	return append(c.hostOptions(), c.jumpOptions()...)
+}
+
+// jumpOptions returns the jump host options for the host's ProxyJump, with the
+// jump hosts of each jump host dialed before it.
+func (c *SSHConfig) jumpOptions() []Option {
+	opts := make([]Option, 0)
+	for _, hop := range c.ProxyJump {
+		opts = append(opts, hop.jumpOptions()...)
+		opts = append(opts, WithJumpHost(hop.Address(), hop.hostOptions()...))
+	}
+	return opts
+}
+
+// hostOptions returns the options for authenticating with the host itself.
+func (c *SSHConfig) hostOptions() []Option {
+	opts := []Option{WithUser(c.User)}
+	for _, path := range c.IdentityFiles {
+		opts = append(opts, withIdentityFile(path))
+	}
+	if !strings.EqualFold(c.IdentityAgent, "none") {
+		opts = append(opts, WithAgentSocket(c.IdentityAgent))
+	}
+	if c.UserKnownHostsFile != "" {
+		opts = append(opts, WithKnownHosts(c.UserKnownHostsFile))
+	}
+	opts = append(opts, WithHostKeyMode(c.StrictHostKeyChecking))
+	if c.ConnectTimeout > 0 {
+		opts = append(opts, WithConnectTimeout(c.ConnectTimeout))
+	}
+	if c.ServerAliveInterval > 0 {
+		opts = append(opts, WithKeepalive(c.ServerAliveInterval, c.ServerAliveCountMax))
+	}
+	return opts
+}
+
+// Executor provides an ExecFunc that will start the script/cmd process on the
+// host, with the options from the config followed by the given options, which
+// override those of the config. Identity files and jump hosts given as options
+// are used in addition to those of the config. See Executor.
+func (c *SSHConfig) Executor(opts ...Option) nescript.ExecFunc {
+	return executor(c.Address(), newOptions(nil, append(c.Options(), opts...)))
+}
+
+// Connect creates a persistent connection to the host, with the options from
+// the config followed by the given options. See Connect.
+func (c *SSHConfig) Connect(opts ...Option) *Connection {
+	return Connect(c.Address(), nil, append(c.Options(), opts...)...)
+}
+
+// withIdentityFile authenticates with the private key at the path, skipping it
+// if it does not exist or is encrypted.
+func withIdentityFile(path string) Option {
+	return func(o *options) {
+		o.auth = append(o.auth, func() (authMethod, io.Closer, error) {
+			keyPEM, err := os.ReadFile(path)
+			if errors.Is(err, os.ErrNotExist) {
+				return authMethod{}, nil, fmt.Errorf("%w: '%s' does not exist", errIdentitySkipped, path)
+			} else if err != nil {
+				return authMethod{}, nil, fmt.Errorf("failed to read ssh private key '%s': %w", path, err)
+			}
+			signer, err := ssh.ParsePrivateKey(keyPEM)
+			var missing *ssh.PassphraseMissingError
+			if errors.As(err, &missing) {
+				return authMethod{}, nil, fmt.Errorf("%w: '%s' is encrypted", errIdentitySkipped, path)
+			} else if err != nil {
+				return authMethod{}, nil, fmt.Errorf("failed to parse ssh private key '%s': %w", path, err)
+			}
+			return authMethod{signers: []ssh.Signer{signer}}, nil, nil
+		})
+	}
+}
+
+// configParser reads the directives applying to a host alias from OpenSSH
+// client config files.
+type configParser struct {
+	alias     string
+	home      string
+	localUser string
+	values    map[string][][]string
+	warnings  []string
+}
+
+// parseFile reads the directives from the config file, following includes.
+func (p *configParser) parseFile(path string, depth int) error {
+	if depth > maxIncludeDepth {
+		return fmt.Errorf("ssh config includes nested too deeply at '%s'", path)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to read ssh config: %w", err)
+	}
+	defer file.Close()
+	active := true
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		keyword, args, err := splitDirective(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("ssh config '%s' line %d: %w", path, line, err)
+		} else if keyword == "" {
+			continue
+		}
+		switch key := strings.ToLower(keyword); key {
+		case "host":
+			active = p.matchHost(args)
+		case "match":
+			active = p.match(args, fmt.Sprintf("%s line %d", path, line))
+		case "include":
+			if !active {
+				continue
+			}
+			for _, pattern := range args {
+				if err := p.include(pattern, depth); err != nil {
+					return err
+				}
+			}
+		default:
+			if !active {
+				continue
+			}
+			if !supportedDirectives[key] {
+				p.warnings = append(p.warnings, fmt.Sprintf("unsupported directive '%s' ignored (%s line %d)", keyword, path, line))
+				continue
+			}
+			if key == "identityfile" || len(p.values[key]) == 0 {
+				p.values[key] = append(p.values[key], args)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read ssh config '%s': %w", path, err)
+	}
+	return nil
+}
+
+// include parses each config file matching the pattern, which is relative to
+// ~/.ssh unless absolute.
+func (p *configParser) include(pattern string, depth int) error {
+	pattern = p.expandHome(pattern)
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(p.home, ".ssh", pattern)
+	}
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid ssh config include '%s': %w", pattern, err)
+	}
+	for _, path := range paths {
+		if err := p.parseFile(path, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// supportedDirectives are the (lower cased) directives used when resolving a
+// host.
+var supportedDirectives = map[string]bool{
+	"hostname":              true,
+	"user":                  true,
+	"port":                  true,
+	"identityfile":          true,
+	"proxyjump":             true,
+	"userknownhostsfile":    true,
+	"stricthostkeychecking": true,
+	"identityagent":         true,
+	"connecttimeout":        true,
+	"serveraliveinterval":   true,
+	"serveralivecountmax":   true,
+}
+
+// matchHost reports whether the alias matches any of the Host patterns, and
+// none of the negated (!) patterns.
+func (p *configParser) matchHost(patterns []string) bool {
+	return matchPatterns(patterns, p.alias)
+}
+
+// match reports whether the Match criteria all apply to the host. Unsupported
+// criteria are recorded as warnings, and never match.
+func (p *configParser) match(args []string, location string) bool {
+	matched := true
+	for i := 0; i < len(args); i++ {
+		criterion := strings.ToLower(args[i])
+		if criterion == "all" {
+			continue
+		}
+		if i+1 >= len(args) {
+			p.warnings = append(p.warnings, fmt.Sprintf("match criterion '%s' missing its argument (%s)", args[i], location))
+			return false
+		}
+		patterns := strings.Split(args[i+1], ",")
+		i++
+		switch criterion {
+		case "host":
+			matched = matched && matchPatterns(patterns, p.hostName())
+		case "originalhost":
+			matched = matched && matchPatterns(patterns, p.alias)
+		case "user":
+			matched = matched && matchPatterns(patterns, p.user())
+		case "localuser":
+			matched = matched && matchPatterns(patterns, p.localUser)
+		default:
+			p.warnings = append(p.warnings, fmt.Sprintf("unsupported match criterion '%s', block ignored (%s)", args[i-1], location))
+			return false
+		}
+	}
+	return matched
+}
+
+// first returns the first value given for the directive, if any.
+func (p *configParser) first(key string) string {
+	return strings.Join(p.firstArgs(key), " ")
+}
+
+// firstArgs returns the arguments first given for the directive, if any.
+func (p *configParser) firstArgs(key string) []string {
+	if values := p.values[key]; len(values) > 0 {
+		return values[0]
+	}
+	return nil
+}
+
+func (p *configParser) hostName() string {
+	if hostName := p.first("hostname"); hostName != "" {
+		return strings.ReplaceAll(hostName, "%h", p.alias)
+	}
+	return p.alias
+}
+
+func (p *configParser) user() string {
+	if user := p.first("user"); user != "" {
+		return user
+	}
+	return p.localUser
+}
+
+// resolve builds the host's config from the directives read.
+func (p *configParser) resolve() (*SSHConfig, error) {
+	config := &SSHConfig{
+		Alias:               p.alias,
+		HostName:            p.hostName(),
+		Port:                22,
+		User:                p.user(),
+		ServerAliveCountMax: defaultServerAliveCountMax,
+		Warnings:            p.warnings,
+	}
+	var err error
+	if port := p.first("port"); port != "" {
+		if config.Port, err = strconv.Atoi(port); err != nil {
+			return nil, fmt.Errorf("invalid ssh config port '%s'", port)
+		}
+	}
+	for _, args := range p.values["identityfile"] {
+		path := strings.Join(args, " ")
+		if strings.EqualFold(path, "none") {
+			continue
+		}
+		config.IdentityFiles = append(config.IdentityFiles, p.expand(path, config))
+	}
+	if len(p.values["identityfile"]) == 0 {
+		for _, path := range defaultIdentityFiles {
+			if path = filepath.Join(p.home, path); fileExists(path) {
+				config.IdentityFiles = append(config.IdentityFiles, path)
+			}
+		}
+	}
+	if files := p.firstArgs("userknownhostsfile"); len(files) > 0 {
+		config.UserKnownHostsFile = p.expand(files[0], config)
+	}
+	switch strings.ToLower(p.first("stricthostkeychecking")) {
+	case "accept-new":
+		config.StrictHostKeyChecking = HostKeyAcceptNew
+	case "no", "off":
+		config.StrictHostKeyChecking = HostKeyInsecure
+	default:
+		config.StrictHostKeyChecking = HostKeyStrict
+	}
+	switch agent := p.first("identityagent"); agent {
+	case "", "SSH_AUTH_SOCK", "$SSH_AUTH_SOCK":
+	default:
+		config.IdentityAgent = p.expand(agent, config)
+	}
+	if config.ConnectTimeout, err = p.seconds("connecttimeout"); err != nil {
+		return nil, err
+	}
+	if config.ServerAliveInterval, err = p.seconds("serveraliveinterval"); err != nil {
+		return nil, err
+	}
+	if count := p.first("serveralivecountmax"); count != "" {
+		if config.ServerAliveCountMax, err = strconv.Atoi(count); err != nil {
+			return nil, fmt.Errorf("invalid ssh config ServerAliveCountMax '%s'", count)
+		}
+	}
+	return config, nil
+}
+
+// seconds parses the directive's value as a number of seconds.
+func (p *configParser) seconds(key string) (time.Duration, error) {
+	value := p.first(key)
+	if value == "" {
+		return 0, nil
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid ssh config %s '%s'", key, value)
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// expand expands ~ and the %d (home), %h (host name), %p (port), %r (remote
+// user), %u (local user) and %n (alias) tokens in a path.
+func (p *configParser) expand(path string, config *SSHConfig) string {
+	path = p.expandHome(path)
+	return strings.NewReplacer(
+		"%%", "%",
+		"%d", p.home,
+		"%h", config.HostName,
+		"%p", strconv.Itoa(config.Port),
+		"%r", config.User,
+		"%u", p.localUser,
+		"%n", p.alias,
+	).Replace(path)
+}
+
+func (p *configParser) expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		return filepath.Join(p.home, path[1:])
+	}
+	return path
+}
+
+// splitDirective splits a config line into its keyword and arguments, which may
+// be separated by whitespace or an =, and may be double quoted. An empty
+// keyword is returned for blank and comment lines.
+func splitDirective(line string) (string, []string, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", nil, nil
+	}
+	end := strings.IndexAny(line, " \t=")
+	if end < 0 {
+		return line, nil, nil
+	}
+	keyword := line[:end]
+	rest := strings.TrimLeft(line[end:], " \t")
+	rest = strings.TrimLeft(strings.TrimPrefix(rest, "="), " \t")
+	args := make([]string, 0)
+	for rest != "" {
+		var arg string
+		if rest[0] == '"' {
+			closing := strings.IndexByte(rest[1:], '"')
+			if closing < 0 {
+				return "", nil, fmt.Errorf("unterminated quote")
+			}
+			arg, rest = rest[1:closing+1], rest[closing+2:]
+		} else if end := strings.IndexAny(rest, " \t"); end >= 0 {
+			arg, rest = rest[:end], rest[end:]
+		} else {
+			arg, rest = rest, ""
+		}
+		args = append(args, arg)
+		rest = strings.TrimLeft(rest, " \t")
+	}
+	return keyword, args, nil
+}
+
+// matchPatterns reports whether the value matches any of the OpenSSH patterns
+// (with * and ? wildcards), and none of the negated (!) patterns.
+func matchPatterns(patterns []string, value string) bool {
+	matched := false
+	for _, pattern := range patterns {
+		if negated := strings.HasPrefix(pattern, "!"); negated {
+			if matchWildcard(pattern[1:], value) {
+				return false
+			}
+		} else if matchWildcard(pattern, value) {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// matchWildcard reports whether the value matches the pattern, where * matches
+// any run of characters and ? any single character.
+func matchWildcard(pattern, value string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(value); i >= 0; i-- {
+				if matchWildcard(pattern[1:], value[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if value == "" {
+				return false
+			}
+		default:
+			if value == "" || pattern[0] != value[0] {
+				return false
+			}
+		}
+		pattern, value = pattern[1:], value[1:]
+	}
+	return value == ""
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
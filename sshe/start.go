@@ -0,0 +1,285 @@
+package sshe
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// launch follows the command wrapper (see options.command) as it starts the
+// script on the target, through the lines it writes to stdout. Under sudo, the
+// password is sent when sudo prompts for it, and the script is sent once the
+// elevated shell writes its ready line. The start line is written just before
+// the script is exec'd, recording its PID with remote kill. The markers on
+// these lines are unique to the execution, so that the script can not write
+// them. The output before the start line is that of the wrapper (such as
+// sudo's), so it is held back from the script's output.
+type launch struct {
+	ready  string
+	start  string
+	prompt string
+	sudo   *sudo
+
+	// stdin is the session's stdin, script the line the elevated shell is sent
+	// once ready, and done closed once the session has ended.
+	stdin  io.WriteCloser
+	script string
+	done   <-chan struct{}
+
+	stdout  io.Writer
+	stderr  io.Writer
+	started chan struct{}
+
+	mu            sync.Mutex
+	held          []byte
+	pending       []byte
+	stderrPrompts int
+	prompts       int
+	isReady       bool
+	isStarted     bool
+	pid           int
+}
+
+// newLaunch returns a launch with markers unique to the execution, elevated
+// with sudo if it is not nil.
+func newLaunch(sudo *sudo) (*launch, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("failed to generate start marker: %w", err)
+	}
+	marker := hex.EncodeToString(id)
+	l := &launch{
+		ready:   "nescript-ready-" + marker,
+		start:   "nescript-start-" + marker,
+		sudo:    sudo,
+		started: make(chan struct{}),
+	}
+	if sudo != nil && sudo.password != nil {
+		l.prompt = "nescript-sudo-" + marker + ": "
+	}
+	return l, nil
+}
+
+// startLine returns the command writing the start line, with the shell's PID
+// if pid is true.
+func (l *launch) startLine(pid bool) string {
+	if pid {
+		return "echo " + l.start + " $$; "
+	}
+	return "echo " + l.start + "; "
+}
+
+// readyLine returns the command writing the ready line.
+func (l *launch) readyLine() string {
+	return "echo " + l.ready + "; "
+}
+
+// Stdout returns the writer the session's stdout is written to.
+func (l *launch) Stdout() io.Writer {
+	return launchStdout{l}
+}
+
+// Stderr returns the writer the session's stderr is written to, which removes
+// sudo's prompts.
+func (l *launch) Stderr() io.Writer {
+	return launchStderr{l}
+}
+
+// Stdin returns the session's stdin, whose writes (and close) wait until the
+// script has started (or the session has ended), so that input is not read by
+// the elevated shell as the script.
+func (l *launch) Stdin() io.WriteCloser {
+	return launchStdin{l}
+}
+
+type launchStdout struct{ l *launch }
+
+func (w launchStdout) Write(p []byte) (int, error) {
+	l := w.l
+	n := len(p)
+	l.mu.Lock()
+	if l.isStarted {
+		l.mu.Unlock()
+		return write(l.stdout, p, n)
+	}
+	l.held = append(l.held, p...)
+	if l.prompt != "" {
+		l.prompted(bytes.Count(l.held, []byte(l.prompt)) + l.stderrPrompts)
+	}
+	if l.sudo != nil && !l.isReady && bytes.Contains(l.held, []byte(l.ready)) {
+		l.isReady = true
+		io.WriteString(l.stdin, l.script)
+	}
+	i := bytes.Index(l.held, []byte(l.start))
+	if i < 0 {
+		l.mu.Unlock()
+		return n, nil
+	}
+	end := bytes.IndexByte(l.held[i:], '\n')
+	if end < 0 {
+		l.mu.Unlock()
+		return n, nil
+	}
+	line := l.held[i+len(l.start) : i+end]
+	l.pid, _ = strconv.Atoi(strings.TrimSpace(string(line)))
+	p = append([]byte{}, l.held[i+end+1:]...)
+	l.held = l.held[:i]
+	l.isStarted = true
+	close(l.started)
+	pending := l.pending
+	l.pending = nil
+	l.mu.Unlock()
+	if len(pending) > 0 {
+		l.stderr.Write(pending)
+	}
+	return write(l.stdout, p, n)
+}
+
+type launchStderr struct{ l *launch }
+
+func (w launchStderr) Write(p []byte) (int, error) {
+	l := w.l
+	n := len(p)
+	l.mu.Lock()
+	if l.isStarted || l.prompt == "" {
+		l.mu.Unlock()
+		return write(l.stderr, p, n)
+	}
+	var count int
+	p, count, l.pending = cutPrompts(append(l.pending, p...), []byte(l.prompt))
+	l.stderrPrompts += count
+	l.prompted(bytes.Count(l.held, []byte(l.prompt)) + l.stderrPrompts)
+	l.mu.Unlock()
+	return write(l.stderr, p, n)
+}
+
+type launchStdin struct{ l *launch }
+
+func (w launchStdin) Write(p []byte) (int, error) {
+	w.wait()
+	return w.l.stdin.Write(p)
+}
+
+func (w launchStdin) Close() error {
+	w.wait()
+	return w.l.stdin.Close()
+}
+
+func (w launchStdin) wait() {
+	select {
+	case <-w.l.started:
+	case <-w.l.done:
+	}
+}
+
+// prompted handles the prompts sudo has written so far: the password is sent
+// for the first, while another means the password was rejected, so stdin is
+// closed for sudo to fail rather than wait for another attempt.
+func (l *launch) prompted(prompts int) {
+	for ; l.prompts < prompts; l.prompts++ {
+		if l.prompts == 0 {
+			l.sudo.sendPassword(l.stdin)
+		} else {
+			l.stdin.Close()
+		}
+	}
+}
+
+// finish passes on the output held back if the script was never started
+// without sudo, as where the login shell is not a POSIX shell, along with any
+// stderr held back as a possible prompt. It is called once the session has
+// ended.
+func (l *launch) finish() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.pending) > 0 {
+		l.stderr.Write(l.pending)
+		l.pending = nil
+	}
+	if !l.isStarted && l.sudo == nil && len(l.held) > 0 {
+		l.stdout.Write(l.held)
+		l.held = nil
+	}
+}
+
+// Ready reports whether the elevated shell was started by sudo.
+func (l *launch) Ready() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.isReady
+}
+
+// Started reports whether the script was started.
+func (l *launch) Started() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.isStarted
+}
+
+// Prompts returns the number of times sudo prompted for the password.
+func (l *launch) Prompts() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.prompts
+}
+
+// Output returns the stdout written before the script was started, without the
+// markers and prompts.
+func (l *launch) Output() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	output := string(l.held)
+	for _, marker := range []string{l.ready, l.prompt} {
+		if marker != "" {
+			output = strings.ReplaceAll(output, marker, "")
+		}
+	}
+	return output
+}
+
+// PID returns the PID of the script, or 0 if it is not known.
+func (l *launch) PID() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.pid
+}
+
+// write writes p to the writer, returning n (the length of the data given to
+// the caller's Write) on success.
+func write(w io.Writer, p []byte, n int) (int, error) {
+	if len(p) == 0 {
+		return n, nil
+	}
+	if _, err := w.Write(p); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// cutPrompts removes each occurrence of the prompt from the data, returning the
+// remainder and the number of prompts removed, along with the end of the data
+// held back as it may be the start of a prompt.
+func cutPrompts(data, prompt []byte) ([]byte, int, []byte) {
+	count := 0
+	var out []byte
+	for {
+		i := bytes.Index(data, prompt)
+		if i < 0 {
+			break
+		}
+		out = append(out, data[:i]...)
+		data = data[i+len(prompt):]
+		count++
+	}
+	for k := min(len(prompt)-1, len(data)); k > 0; k-- {
+		if bytes.HasPrefix(prompt, data[len(data)-k:]) {
+			return append(out, data[:len(data)-k]...), count, append([]byte{}, data[len(data)-k:]...)
+		}
+	}
+	return append(out, data...), count, nil
+}
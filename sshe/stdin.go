@@ -0,0 +1,78 @@
+package sshe
+
+import (
+	"fmt"
+	"io"
+)
+
+// stdinPipe copies a reader into the stdin of an SSH process.
+type stdinPipe struct {
+	done    chan struct{}
+	readErr error
+}
+
+// errReader records the error (other than EOF) returned by the reader, so that
+// failures to read the input can be told apart from failures to write it,
+// which happen as a matter of course when a script exits without reading all
+// of its input.
+type errReader struct {
+	reader io.Reader
+	err    error
+}
+
+func (r *errReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+// pipeStdin starts copying the reader into the session's stdin, closing it once
+// the reader is exhausted so that the script receives EOF. The copy is marked
+// done before stdin is closed, so that a script ending once it receives EOF
+// always sees the outcome of the copy.
+func pipeStdin(stdin io.WriteCloser, reader io.Reader) *stdinPipe {
+	pipe := &stdinPipe{
+		done: make(chan struct{}),
+	}
+	go func() {
+		reader := &errReader{reader: reader}
+		io.Copy(stdin, reader)
+		pipe.readErr = reader.err
+		close(pipe.done)
+		stdin.Close()
+	}()
+	return pipe
+}
+
+// wait returns an error if the input could not be read. It is called once the
+// session has ended, so does not wait for a reader that has not returned (such
+// as os.Stdin, which may never return), whose copy ends once its read returns.
+func (p *stdinPipe) wait() error {
+	if p == nil {
+		return nil
+	}
+	select {
+	case <-p.done:
+	default:
+		return nil
+	}
+	if p.readErr != nil {
+		return fmt.Errorf("%w: failed to read script stdin: %w", ErrExecution, p.readErr)
+	}
+	return nil
+}
+
+// validateStdin checks that stdin is not given along with a sudo password, as
+// the password is also sent on stdin, and claims the reader for the execution,
+// as it can only be consumed once.
+func (o *options) validateStdin() error {
+	if o.stdin != nil && o.sudo != nil && o.sudo.password != nil {
+		return fmt.Errorf("%w: stdin can not be given along with a sudo password", ErrStdinConflict)
+	}
+	if o.stdin != nil && !o.stdinClaimed.CompareAndSwap(false, true) {
+		return fmt.Errorf("%w: the stdin reader was already consumed by another execution", ErrStdinConflict)
+	}
+	return nil
+}
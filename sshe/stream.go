@@ -0,0 +1,116 @@
+package sshe
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/neaas/nescript"
+)
+
+// MetadataTruncated is the result metadata key holding the Truncated output
+// counts, recorded only when output exceeded the cap given by WithMaxOutput.
+const MetadataTruncated = "sshe.truncated"
+
+// Stream identifies the output of the script a line was written to.
+type Stream string
+
+const (
+	StreamStdOut Stream = "stdout"
+	StreamStdErr Stream = "stderr"
+)
+
+// LineFunc is called with each line the script writes, as it is written,
+// without the line ending. A final line without a line ending is given once
+// the script exits.
+type LineFunc func(stream Stream, line string)
+
+// Truncated records how many bytes of each output were streamed, but not
+// captured in the result, as they exceeded the cap given by WithMaxOutput.
+type Truncated struct {
+	StdOut int `json:"stdout"`
+	StdErr int `json:"stderr"`
+}
+
+// lineWriter splits the output written to it into lines, given to the
+// handler. The handler is shared by stdout and stderr, so that it is never
+// called concurrently.
+type lineWriter struct {
+	stream  Stream
+	handler *lineHandler
+	partial []byte
+}
+
+type lineHandler struct {
+	mu        sync.Mutex
+	fn        LineFunc
+	normalize []nescript.Normalizer
+}
+
+func (h *lineHandler) line(stream Stream, line []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.fn(stream, nescript.Normalize(strings.TrimSuffix(string(line), "\r"), h.normalize...))
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			break
+		}
+		if len(w.partial) > 0 {
+			w.handler.line(w.stream, append(w.partial, p[:i]...))
+			w.partial = w.partial[:0]
+		} else {
+			w.handler.line(w.stream, p[:i])
+		}
+		p = p[i+1:]
+	}
+	w.partial = append(w.partial, p...)
+	return n, nil
+}
+
+// flush gives the handler the final line, if the output did not end with a
+// line ending. It must only be called once the output has been fully written.
+func (w *lineWriter) flush() {
+	if len(w.partial) > 0 {
+		w.handler.line(w.stream, w.partial)
+		w.partial = nil
+	}
+}
+
+// streams attaches the process's captured output, teed to the cmd's writers,
+// along with the writers and line handler (if any), to the session's stdout and
+// stderr. Where the command is wrapped, the output first passes through the
+// launch (see launch).
+func (o *options) streams(c nescript.Cmd, process *SSHProcess) (stdout, stderr io.Writer) {
+	process.stdoutBytes = nescript.NewCapture(o.maxOutput, o.retain)
+	process.stderrBytes = nescript.NewCapture(o.maxOutput, o.retain)
+	process.tee = nescript.NewTee(c, process.stdoutBytes, process.stderrBytes)
+	stdouts := []io.Writer{process.tee.Stdout()}
+	stderrs := []io.Writer{process.tee.Stderr()}
+	if o.stdout != nil {
+		stdouts = append(stdouts, o.stdout)
+	}
+	if o.stderr != nil {
+		stderrs = append(stderrs, o.stderr)
+	}
+	if o.lines != nil {
+		handler := &lineHandler{fn: o.lines, normalize: o.normalize}
+		process.lineWriters = []*lineWriter{
+			{stream: StreamStdOut, handler: handler},
+			{stream: StreamStdErr, handler: handler},
+		}
+		stdouts = append(stdouts, process.lineWriters[0])
+		stderrs = append(stderrs, process.lineWriters[1])
+	}
+	stdout, stderr = io.MultiWriter(stdouts...), io.MultiWriter(stderrs...)
+	if process.launch != nil {
+		process.launch.stdout, process.launch.stderr = stdout, stderr
+		return process.launch.Stdout(), process.launch.Stderr()
+	}
+	return stdout, stderr
+}
@@ -0,0 +1,92 @@
+package sshe
+
+import (
+	"fmt"
+	"github.com/neaas/nescript/internal/shellquote"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// sudo describes how remote commands are elevated with sudo.
+type sudo struct {
+	user     string
+	password *secret
+}
+
+// args returns the sudo command line up to the command it runs. With a
+// password, sudo reads it from stdin after writing the prompt to stderr,
+// otherwise sudo is run non-interactively so that it fails rather than waiting
+// for a password.
+func (s *sudo) args(prompt string) []string {
+	parts := []string{"sudo"}
+	if s.user != "" {
+		parts = append(parts, "-u", shellquote.POSIX(s.user))
+	}
+	if s.password != nil {
+		parts = append(parts, "-S", "-p", shellquote.POSIX(prompt))
+	} else {
+		parts = append(parts, "-n")
+	}
+	return append(parts, "--")
+}
+
+// command returns the command starting the elevated shell with sudo, which
+// writes the launch's ready line and then reads the script from stdin with
+// sh -s, so that neither the script nor its env is visible in the target's
+// process list. With a PTY, the terminal is put in raw mode without echo while
+// the script is read, as it would otherwise be echoed into the output with its
+// lines limited in length, and restored by the script (see sudo.script).
+func (s *sudo) command(l *launch, pty bool) string {
+	shell := l.readyLine() + "exec sh -s"
+	if pty {
+		shell = "t=$(stty -g); stty raw -echo; " + l.readyLine() + `exec sh -s "$t"`
+	}
+	return strings.Join(append(s.args(l.prompt), "sh", "-c", shellquote.POSIX(shell)), " ")
+}
+
+// script returns the line the elevated shell is sent once ready. As sudo resets
+// the env, the cmd's env vars are exported before the command, which must exec
+// the script so that the rest of stdin is the script's input rather than
+// commands of the elevated shell.
+func (s *sudo) script(command string, env []string, pty bool) string {
+	script := exportPrelude(env) + command + "\n"
+	if pty {
+		script = `stty "$1"; set --; ` + script
+	}
+	return script
+}
+
+// kill returns the command killing the process with the PID as the sudo user,
+// which is given the password (see sendPassword) without a prompt.
+func (s *sudo) kill(pid int) string {
+	return strings.Join(append(s.args(""), "kill", "-KILL", strconv.Itoa(pid)), " ")
+}
+
+// sendPassword writes the sudo password (if any) to the process's stdin.
+func (s *sudo) sendPassword(stdin io.Writer) error {
+	if s.password == nil {
+		return nil
+	}
+	if _, err := io.WriteString(stdin, string(*s.password)+"\n"); err != nil {
+		return fmt.Errorf("failed to send sudo password: %w", err)
+	}
+	return nil
+}
+
+// err identifies why sudo failed to start the elevated shell, from the number
+// of times it prompted for the password and its output. As the script was
+// never started, the output is that of sudo alone.
+func (s *sudo) err(prompts int, output string) error {
+	output = strings.TrimSpace(output)
+	lower := strings.ToLower(output)
+	switch {
+	case prompts > 1:
+		return fmt.Errorf("%w: %s", ErrSudoIncorrectPassword, output)
+	case strings.Contains(lower, "not in the sudoers file") || strings.Contains(lower, "is not allowed to"):
+		return fmt.Errorf("%w: %s", ErrSudoNotPermitted, output)
+	case s.password == nil && (strings.Contains(lower, "a password is required") || strings.Contains(lower, "a terminal is required")):
+		return fmt.Errorf("%w: %s", ErrSudoPasswordRequired, output)
+	}
+	return fmt.Errorf("%w: sudo failed to start the script: %s", ErrExecution, output)
+}
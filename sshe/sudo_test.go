@@ -0,0 +1,79 @@
+package sshe_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/sshe"
+)
+
+func TestSudo(t *testing.T) {
+	fakeSudo(t)
+	server := newTestServer(t)
+	for _, tc := range []struct {
+		name     string
+		opts     []sshe.Option
+		script   string
+		stdout   string
+		exitCode int
+	}{
+		{"passwordless", []sshe.Option{sshe.WithSudo("postgres")}, `echo "$GREETING"; exit 3`, "hello\n", 3},
+		{"password", []sshe.Option{sshe.WithSudoPassword("hunter2")}, `echo "$GREETING"`, "hello\n", 0},
+		{"stdin", []sshe.Option{sshe.WithSudo(""), sshe.WithStdin(strings.NewReader("piped input"))}, "cat", "piped input", 0},
+		{"sudo messages from the script", []sshe.Option{sshe.WithSudoPassword("hunter2")}, "echo 'Sorry, try again.' >&2; echo 'test is not in the sudoers file.' >&2; exit 1", "", 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cmd := nescript.NewScript(tc.script).Cmd().WithEnv("GREETING=hello")
+			process, err := cmd.Exec(sshe.Executor(server.Addr, server.Config, tc.opts...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := process.Result()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.StdOut != tc.stdout || result.ExitCode != tc.exitCode {
+				t.Errorf("expected stdout %q and exit code %d, got %q and %d", tc.stdout, tc.exitCode, result.StdOut, result.ExitCode)
+			}
+			if strings.Contains(result.StdErr, "nescript-") {
+				t.Errorf("expected the markers and prompt removed from stderr, got %q", result.StdErr)
+			}
+			commands := server.Commands()
+			if command := commands[len(commands)-1]; strings.Contains(command, "GREETING") || strings.Contains(command, tc.script) {
+				t.Errorf("expected the script and its env sent on stdin, got the command %q", command)
+			}
+		})
+	}
+}
+
+func TestSudoErrors(t *testing.T) {
+	fakeSudo(t)
+	server := newTestServer(t)
+	for _, tc := range []struct {
+		name string
+		env  string
+		opts []sshe.Option
+		err  error
+	}{
+		{"incorrect password", "", []sshe.Option{sshe.WithSudoPassword("wrong")}, sshe.ErrSudoIncorrectPassword},
+		{"not permitted", "FAKE_SUDO_DENIED", []sshe.Option{sshe.WithSudoPassword("hunter2")}, sshe.ErrSudoNotPermitted},
+		{"password required", "FAKE_SUDO_PASSWORD_REQUIRED", []sshe.Option{sshe.WithSudo("")}, sshe.ErrSudoPasswordRequired},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.env != "" {
+				t.Setenv(tc.env, "1")
+			}
+			process, err := nescript.NewScript("echo ran").Cmd().Exec(sshe.Executor(server.Addr, server.Config, tc.opts...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := process.Result(); !errors.Is(err, tc.err) {
+				t.Errorf("expected %v, got %v", tc.err, err)
+			} else if strings.Contains(err.Error(), "hunter2") || strings.Contains(err.Error(), "nescript-") {
+				t.Errorf("expected the password and markers left out of the error, got %v", err)
+			}
+		})
+	}
+}
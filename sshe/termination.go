@@ -0,0 +1,86 @@
+package sshe
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/neaas/nescript"
+	"golang.org/x/crypto/ssh"
+)
+
+// MetadataTermination is the result metadata key recording how the script
+// came to exit.
+const MetadataTermination = "sshe.termination"
+
+// Termination describes how a script came to exit.
+type Termination string
+
+const (
+	// TerminationExited means the script exited of its own accord.
+	TerminationExited Termination = "exited"
+
+	// TerminationSignalled means the script was sent the stop signal (see
+	// WithStopSignal) as its context was done, and exited within the grace
+	// period.
+	TerminationSignalled Termination = "signalled"
+
+	// TerminationClosed means the script did not exit within the grace period
+	// after the stop signal, so the session was closed. Many SSH servers leave
+	// the script running regardless (see WithRemoteKill).
+	TerminationClosed Termination = "closed"
+
+	// TerminationKilled means the script did not exit within the grace period
+	// after the stop signal, so the session was closed and the script's process
+	// killed by its PID (see WithRemoteKill).
+	TerminationKilled Termination = "killed"
+)
+
+// TerminationFrom returns how the script a result was produced by was
+// terminated. False is returned if the result was not produced by an SSH
+// executor.
+func TerminationFrom(r *nescript.Result) (Termination, bool) {
+	termination, ok := r.Metadata[MetadataTermination].(Termination)
+	return termination, ok
+}
+
+// stop describes how a script is stopped when its context is done.
+type stop struct {
+	signal ssh.Signal
+	grace  time.Duration
+	kill   bool
+}
+
+// killRemote kills the process with the PID over a new session, waiting up to
+// the timeout for the kill to complete. With sudo, the kill is elevated in the
+// same way as the script, as the script is not owned by the SSH user.
+func killRemote(client *ssh.Client, pid int, sudo *sudo, timeout time.Duration) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to create ssh session to kill process %d: %w", pid, err)
+	}
+	defer session.Close()
+	command := "kill -KILL " + strconv.Itoa(pid)
+	if sudo != nil {
+		var stdin bytes.Buffer
+		if err := sudo.sendPassword(&stdin); err != nil {
+			return err
+		}
+		session.Stdin = &stdin
+		command = sudo.kill(pid)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Run(command)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("failed to kill process %d: %w", pid, err)
+		}
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("timed out killing process %d", pid)
+	}
+}
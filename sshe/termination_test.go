@@ -0,0 +1,55 @@
+package sshe_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/sshe"
+)
+
+func TestRemoteKillWithSudo(t *testing.T) {
+	fakeSudo(t)
+	server := newTestServer(t)
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	for _, tc := range []struct {
+		name string
+		opts []sshe.Option
+	}{
+		{"passwordless", []sshe.Option{sshe.WithSudo("")}},
+		{"password", []sshe.Option{sshe.WithSudo(""), sshe.WithSudoPassword("hunter2")}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := append([]sshe.Option{sshe.WithRemoteKill(), sshe.WithExecTimeout(time.Second), sshe.WithStopGracePeriod(500 * time.Millisecond)}, tc.opts...)
+			script := "trap '' TERM; echo $$ > " + pidFile + "; while :; do sleep 0.1; done"
+			process, err := nescript.NewScript(script).Cmd().Exec(sshe.Executor(server.Addr, server.Config, opts...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = process.Result()
+			var contextErr *sshe.ContextError
+			if !errors.As(err, &contextErr) {
+				t.Fatalf("expected a *ContextError, got %v", err)
+			}
+			if contextErr.Termination != sshe.TerminationKilled {
+				t.Errorf("expected the script killed, got %s", contextErr.Termination)
+			}
+			pid, err := os.ReadFile(pidFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			commands := server.Commands()
+			kill := commands[len(commands)-1]
+			if !strings.HasPrefix(kill, "sudo ") || !strings.Contains(kill, "kill -KILL "+strings.TrimSpace(string(pid))) {
+				t.Errorf("expected the script's own PID killed with sudo, got %q", kill)
+			}
+			if process.ID() != strings.TrimSpace(string(pid)) {
+				t.Errorf("expected the ID to be the script's PID %s, got %q", pid, process.ID())
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package sshe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/neaas/nescript"
+)
+
+// Phase is a phase of an SSH execution.
+type Phase string
+
+const (
+	// PhaseConnect is dialing, the SSH handshake and authentication (including
+	// through any jump hosts).
+	PhaseConnect Phase = "connect"
+
+	// PhaseExecute is the execution of the script, from it being started until
+	// it exits.
+	PhaseExecute Phase = "execute"
+)
+
+// stopGracePeriod is how long a script is given by default to exit after being
+// sent the stop signal, when its context is done, before the session is
+// closed.
+const stopGracePeriod = 2 * time.Second
+
+// ContextError is returned when a phase of an execution timed out (see
+// WithConnectTimeout and WithExecTimeout) or the cmd's context was cancelled.
+// It wraps the context's error (context.DeadlineExceeded or
+// context.Canceled), and matches nescript.ErrTimeout or nescript.ErrCancelled
+// accordingly. For the execute phase, the output captured before the
+// script was stopped is included, along with how it was terminated.
+type ContextError struct {
+	Phase       Phase
+	Err         error
+	StdOut      string
+	StdErr      string
+	Termination Termination
+	cause       error
+}
+
+func (e *ContextError) Error() string {
+	verb := "was cancelled"
+	if errors.Is(e.Err, context.DeadlineExceeded) {
+		verb = "timed out"
+	}
+	if e.cause != nil {
+		return fmt.Sprintf("ssh %s %s: %s", e.Phase, verb, e.cause)
+	}
+	return fmt.Sprintf("ssh %s %s", e.Phase, verb)
+}
+
+func (e *ContextError) Unwrap() []error {
+	if e.cause != nil {
+		return []error{nescript.ContextError(e.Err), e.cause}
+	}
+	return []error{nescript.ContextError(e.Err)}
+}
+
+// withTimeout derives a context with the timeout, if it is positive.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
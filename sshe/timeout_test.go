@@ -0,0 +1,77 @@
+package sshe_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/sshe"
+)
+
+func TestTimeouts(t *testing.T) {
+	server := newTestServer(t)
+	for _, tc := range []struct {
+		name        string
+		addr        string
+		opts        []sshe.Option
+		cancel      time.Duration
+		script      string
+		phase       sshe.Phase
+		err         error
+		message     string
+		stdout      string
+		termination sshe.Termination
+	}{
+		{"connect timeout", silentAddr(t), []sshe.Option{sshe.WithConnectTimeout(100 * time.Millisecond), sshe.WithExecTimeout(time.Minute)}, 0, "echo ran", sshe.PhaseConnect, context.DeadlineExceeded, "ssh connect timed out", "", ""},
+		{"exec timeout not applied to connecting", silentAddr(t), []sshe.Option{sshe.WithExecTimeout(100 * time.Millisecond)}, 500 * time.Millisecond, "echo ran", sshe.PhaseConnect, context.Canceled, "ssh connect was cancelled", "", ""},
+		{"exec timeout", server.Addr, []sshe.Option{sshe.WithConnectTimeout(time.Minute), sshe.WithExecTimeout(300 * time.Millisecond), sshe.WithRemoteKill()}, 0, "echo started; exec sleep 10", sshe.PhaseExecute, context.DeadlineExceeded, "ssh execute timed out", "started\n", sshe.TerminationSignalled},
+		{"stop signal handled", server.Addr, []sshe.Option{sshe.WithExecTimeout(300 * time.Millisecond), sshe.WithRemoteKill()}, 0, "trap 'echo stopping; exit 1' TERM; echo started; while :; do sleep 0.1; done", sshe.PhaseExecute, context.DeadlineExceeded, "ssh execute timed out", "started\nstopping\n", sshe.TerminationSignalled},
+		{"stop signal ignored", server.Addr, []sshe.Option{sshe.WithExecTimeout(300 * time.Millisecond), sshe.WithStopGracePeriod(300 * time.Millisecond), sshe.WithRemoteKill()}, 0, "trap '' TERM; echo started; while :; do sleep 0.1; done", sshe.PhaseExecute, context.DeadlineExceeded, "ssh execute timed out", "started\n", sshe.TerminationKilled},
+		{"session closed", server.Addr, []sshe.Option{sshe.WithExecTimeout(300 * time.Millisecond), sshe.WithStopGracePeriod(300 * time.Millisecond)}, 0, "echo started; sleep 10", sshe.PhaseExecute, context.DeadlineExceeded, "ssh execute timed out", "started\n", sshe.TerminationClosed},
+		{"cancelled while executing", server.Addr, []sshe.Option{sshe.WithConnectTimeout(100 * time.Millisecond), sshe.WithRemoteKill()}, 400 * time.Millisecond, "echo started; exec sleep 10", sshe.PhaseExecute, context.Canceled, "ssh execute was cancelled", "started\n", sshe.TerminationSignalled},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tc.cancel > 0 {
+				time.AfterFunc(tc.cancel, cancel)
+			}
+			start := time.Now()
+			process, err := nescript.NewScript(tc.script).Cmd().WithContext(ctx).Exec(sshe.Executor(tc.addr, server.Config, tc.opts...))
+			if err == nil {
+				_, err = process.Result()
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("expected the execution stopped promptly, took %s", elapsed)
+			}
+			var contextErr *sshe.ContextError
+			if !errors.As(err, &contextErr) {
+				t.Fatalf("expected a *ContextError, got %v", err)
+			}
+			if contextErr.Phase != tc.phase || !errors.Is(err, tc.err) || !strings.HasPrefix(err.Error(), tc.message) {
+				t.Errorf("expected %q in the %s phase, wrapping %v, got %v", tc.message, tc.phase, tc.err, err)
+			}
+			if contextErr.StdOut != tc.stdout || contextErr.Termination != tc.termination {
+				t.Errorf("expected the output %q captured and the script %s, got %q and %s", tc.stdout, tc.termination, contextErr.StdOut, contextErr.Termination)
+			}
+		})
+	}
+}
+
+func TestConnectTimeoutNotAppliedToExecuting(t *testing.T) {
+	server := newTestServer(t)
+	process, err := nescript.NewScript("sleep 0.5; echo done").Cmd().Exec(sshe.Executor(server.Addr, server.Config, sshe.WithConnectTimeout(200*time.Millisecond)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := process.Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.StdOut != "done\n" {
+		t.Errorf("expected the script to complete after the connect timeout, got %q", result.StdOut)
+	}
+}
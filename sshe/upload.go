@@ -0,0 +1,243 @@
+package sshe
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/internal/shellquote"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// defaultUploadDir is the remote directory scripts are uploaded to, unless
+// another is given with WithUploadDir.
+const defaultUploadDir = "/tmp"
+
+// uploadPrefix starts the name of every uploaded script, followed by the unix
+// time it was uploaded at, so that scripts left behind (such as when the
+// connection is lost) can be found and swept by CleanupRemoteTemp.
+const uploadPrefix = "nescript-"
+
+// upload describes when and where scripts are uploaded to the target before
+// being executed.
+type upload struct {
+	always    bool
+	threshold int
+	dir       string
+	transfer  Transfer
+}
+
+// uploaded is a script uploaded to the target, ready to be executed. If the
+// connection is lost, the script is removed over a new connection from redial.
+// Scripts uploaded with SCP are removed with rm, as SFTP is unavailable.
+type uploaded struct {
+	path   string
+	conn   *connection
+	redial func() (*connection, error)
+	scp    bool
+}
+
+// wanted reports whether the script should be uploaded. Scripts are uploaded if
+// always requested, or if they are larger than the threshold.
+func (u *upload) wanted(script string) bool {
+	return u != nil && (u.always || (u.threshold > 0 && len(script) > u.threshold))
+}
+
+// uploadScript writes the cmd's script to a unique file (with 0700 permissions)
+// in the upload directory over SFTP, or with SCP if SFTP is unavailable (see
+// WithTransfer). On windows targets, the PowerShell script running the cmd is
+// written as a .ps1 file. Nil is returned if the cmd should be executed
+// inline, either as it was not created from a script, does not need to be
+// uploaded, or neither SFTP nor SCP are available and the upload was not
+// required.
+func (o *options) uploadScript(ctx context.Context, target string, conn *connection, c nescript.Cmd, env []string, windows bool) (*uploaded, error) {
+	_, script, _, ok := c.Script()
+	if !ok || !o.upload.wanted(script) {
+		return nil, nil
+	}
+	dir := o.upload.dir
+	if dir == "" {
+		dir = defaultUploadDir
+	}
+	name, err := uploadName(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if windows {
+		if o.upload.dir == "" {
+			dir = windowsUploadDir
+		}
+		script = powershellFile(powershellScript(c, env))
+		name += ".ps1"
+	}
+	u := &uploaded{
+		path:   path.Join(dir, name),
+		conn:   conn,
+		redial: func() (*connection, error) { return o.dial(context.Background(), target) },
+	}
+	err = u.write(ctx, script, o.upload.transfer, windows)
+	if errors.Is(err, ErrSFTPUnavailable) && !o.upload.always {
+		return nil, nil
+	}
+	if err != nil {
+		if errors.Is(err, ErrSFTPUnavailable) {
+			return nil, err
+		}
+		u.remove()
+		if ctx.Err() != nil {
+			return nil, &ContextError{Phase: PhaseExecute, Err: ctx.Err(), cause: err}
+		}
+		return nil, fmt.Errorf("%w: failed to upload script to '%s': %w", ErrExecution, u.path, err)
+	}
+	return u, nil
+}
+
+// write uploads the script with the transfer method. With TransferAuto, SCP is
+// used if the target does not provide the SFTP subsystem (except on windows
+// targets). ErrSFTPUnavailable is returned (wrapped) if no method is
+// available.
+func (u *uploaded) write(ctx context.Context, script string, transfer Transfer, windows bool) error {
+	var sftpErr error
+	if transfer != TransferSCP {
+		client, err := sftp.NewClient(u.conn.client)
+		if err != nil && (transfer == TransferSFTP || windows) {
+			return fmt.Errorf("%w: %w", ErrSFTPUnavailable, err)
+		}
+		sftpErr = err
+		if err == nil {
+			defer client.Close()
+			stop := context.AfterFunc(ctx, func() { client.Close() })
+			defer stop()
+			return writeScript(client, u.path, script)
+		}
+	}
+	u.scp = true
+	err := scpScript(ctx, u.conn.client, u.path, script)
+	if errors.Is(err, errSCPUnavailable) && ctx.Err() == nil {
+		if sftpErr != nil {
+			return fmt.Errorf("%w: %w, and %w", ErrSFTPUnavailable, sftpErr, err)
+		}
+		return fmt.Errorf("%w: %w", ErrSFTPUnavailable, err)
+	}
+	return err
+}
+
+// writeScript creates the file at the path (which must not already exist),
+// restricting its permissions before the script is written to it.
+func writeScript(client *sftp.Client, path, script string) error {
+	file, err := client.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return err
+	}
+	if err := file.Chmod(0700); err != nil {
+		file.Close()
+		return err
+	}
+	if _, err := file.Write([]byte(script)); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// uploadName generates a unique file name for a script uploaded at the time
+// given, in the form nescript-<unix time>-<random hex>.
+func uploadName(now time.Time) (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("failed to generate upload name: %w", err)
+	}
+	return uploadPrefix + strconv.FormatInt(now.Unix(), 10) + "-" + hex.EncodeToString(id), nil
+}
+
+// uploadedAt parses the time a script was uploaded at from its file name.
+// False is returned if the name is not that of an uploaded script.
+func uploadedAt(name string) (time.Time, bool) {
+	rest, ok := strings.CutPrefix(name, uploadPrefix)
+	if !ok {
+		return time.Time{}, false
+	}
+	timestamp, _, ok := strings.Cut(rest, "-")
+	if !ok {
+		return time.Time{}, false
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(seconds, 0), true
+}
+
+// command builds the command executing the uploaded script with the
+// interpreter, followed by any trailing arguments of the cmd.
+func (u *uploaded) command(c nescript.Cmd, shell nescript.Subcommand) string {
+	subcommand, _, trailing, _ := c.Script()
+	if shell != nil {
+		subcommand = shell
+	}
+	parts := []string{}
+	for _, part := range interpreter(subcommand) {
+		parts = append(parts, shellquote.POSIX(part))
+	}
+	parts = append(parts, shellquote.POSIX(u.path))
+	for _, arg := range trailing {
+		parts = append(parts, shellquote.POSIX(arg))
+	}
+	return strings.Join(parts, " ")
+}
+
+// remove deletes the uploaded script from the target. This is done over a new
+// SFTP session, as the target may limit the number open at once. If the
+// connection has been lost, the target is connected to again to remove it.
+func (u *uploaded) remove() error {
+	err := u.removeWith(u.conn.client)
+	if err == nil || u.redial == nil || u.conn.alive() {
+		return err
+	}
+	conn, dialErr := u.redial()
+	if dialErr != nil {
+		return err
+	}
+	defer conn.Close()
+	return u.removeWith(conn.client)
+}
+
+func (u *uploaded) removeWith(client *ssh.Client) error {
+	if u.scp {
+		return removeSCP(client, u.path)
+	}
+	return removeFile(client, u.path)
+}
+
+// removeFile deletes the file at the path on the target over SFTP. A file
+// that does not exist is not an error.
+func removeFile(sshClient *ssh.Client, path string) error {
+	client, err := sftp.NewClient(sshClient)
+	if err != nil {
+		return fmt.Errorf("failed to start sftp: %w", err)
+	}
+	defer client.Close()
+	if err := client.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// interpreter converts the subcommand a script is passed to inline (such as
+// `sh -c`) into the command executing a script file. With no subcommand, the
+// file is executed directly, so should start with a shebang.
+func interpreter(subcommand nescript.Subcommand) []string {
+	if n := len(subcommand); n > 0 && subcommand[n-1] == "-c" {
+		return subcommand[:n-1]
+	}
+	return subcommand
+}
@@ -0,0 +1,129 @@
+package sshe_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/sshe"
+)
+
+// uploadedScriptBody writes the permissions and name of the file it is run
+// from, when uploaded.
+const uploadedScriptBody = `stat -c %a "$0"; basename "$0"`
+
+func TestUpload(t *testing.T) {
+	large := uploadedScriptBody + "\n# " + strings.Repeat("x", 1024)
+	for _, tc := range []struct {
+		name     string
+		noSFTP   bool
+		scp      string
+		opts     []sshe.Option
+		script   string
+		uploaded bool
+		scpUsed  bool
+	}{
+		{"always", false, "", []sshe.Option{sshe.WithUpload()}, uploadedScriptBody, true, false},
+		{"below the threshold", false, "", []sshe.Option{sshe.WithUploadThreshold(1024)}, uploadedScriptBody, false, false},
+		{"above the threshold", false, "", []sshe.Option{sshe.WithUploadThreshold(1024)}, large, true, false},
+		{"sftp unavailable", true, `\0\0\0`, []sshe.Option{sshe.WithUpload()}, uploadedScriptBody, true, true},
+		{"sftp and scp unavailable above the threshold", true, "", []sshe.Option{sshe.WithUploadThreshold(1024)}, large, false, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			record := fakeSCP(t, tc.scp)
+			server := newTestServer(t)
+			server.NoSFTP = tc.noSFTP
+			dir := t.TempDir()
+			opts := append([]sshe.Option{sshe.WithUploadDir(dir)}, tc.opts...)
+			process, err := nescript.NewScript(tc.script).Cmd().Exec(sshe.Executor(server.Addr, server.Config, opts...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := process.Result()
+			if err != nil {
+				t.Fatal(err)
+			}
+			command := server.Commands()[len(server.Commands())-1]
+			if !tc.uploaded {
+				if !strings.Contains(command, "stat -c") {
+					t.Errorf("expected the script passed inline, got the command %q", command)
+				}
+				return
+			}
+			if tc.scpUsed {
+				if sent, _ := os.ReadFile(record); !strings.HasPrefix(string(sent), "C0700 ") {
+					t.Errorf("expected the script sent with scp, got %q", sent)
+				}
+				// the fake scp does not run the script, so only the upload is
+				// checked.
+				return
+			}
+			if !regexp.MustCompile(`^700\nnescript-[0-9]+-[0-9a-f]{32}\n$`).MatchString(result.StdOut) {
+				t.Errorf("expected the script run from a file with 0700 permissions, got %q", result.StdOut)
+			}
+			if strings.Contains(command, "stat -c") || !strings.HasPrefix(command, "'sh' '"+dir+"/nescript-") {
+				t.Errorf("expected the uploaded file executed with the interpreter, got the command %q", command)
+			}
+			if entries, _ := os.ReadDir(dir); len(entries) > 0 {
+				t.Errorf("expected the uploaded script removed, got %v", entries)
+			}
+		})
+	}
+}
+
+func TestUploadRemovedWhenCancelled(t *testing.T) {
+	server := newTestServer(t)
+	dir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	process, err := nescript.NewScript("echo started; sleep 10").Cmd().WithContext(ctx).Exec(sshe.Executor(server.Addr, server.Config, sshe.WithUpload(), sshe.WithUploadDir(dir), sshe.WithStopGracePeriod(200*time.Millisecond)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Fatalf("expected the script uploaded while running, got %v", entries)
+	}
+	time.AfterFunc(200*time.Millisecond, cancel)
+	if _, err := process.Result(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the execution cancelled, got %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) > 0 {
+		t.Errorf("expected the uploaded script removed, got %v", entries)
+	}
+}
+
+func TestUploadErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		noSFTP  bool
+		opts    []sshe.Option
+		err     error
+		message string
+	}{
+		{"sftp required", true, []sshe.Option{sshe.WithUpload(), sshe.WithTransfer(sshe.TransferSFTP)}, sshe.ErrSFTPUnavailable, "sftp unavailable on target"},
+		{"sftp and scp unavailable", true, []sshe.Option{sshe.WithUpload()}, sshe.ErrSFTPUnavailable, "scp: no response"},
+		{"missing upload dir", false, []sshe.Option{sshe.WithUpload(), sshe.WithUploadDir(filepath.Join(t.TempDir(), "missing"))}, sshe.ErrExecution, "failed to upload script to '"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fakeSCP(t, "")
+			server := newTestServer(t)
+			server.NoSFTP = tc.noSFTP
+			opts := append([]sshe.Option{sshe.WithUploadDir(t.TempDir())}, tc.opts...)
+			_, err := nescript.NewScript("echo ran").Cmd().Exec(sshe.Executor(server.Addr, server.Config, opts...))
+			if !errors.Is(err, tc.err) || !strings.Contains(err.Error(), tc.message) {
+				t.Errorf("expected %v with %q, got %v", tc.err, tc.message, err)
+			}
+			for _, command := range server.Commands() {
+				if strings.Contains(command, "echo ran") {
+					t.Errorf("expected the script not run, got the command %q", command)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package sshe
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/internal/shellquote"
+)
+
+// windowsUploadDir is the remote directory scripts are uploaded to on windows
+// targets, unless another is given with WithUploadDir.
+const windowsUploadDir = "/C:/Windows/Temp"
+
+// WindowsShell is the shell scripts are invoked with on windows targets.
+type WindowsShell int
+
+const (
+	// WindowsPowerShell invokes scripts with Windows PowerShell
+	// (powershell.exe). This is the default.
+	WindowsPowerShell WindowsShell = iota
+
+	// WindowsPwsh invokes scripts with PowerShell (core), as pwsh.exe.
+	WindowsPwsh
+)
+
+func (s WindowsShell) executable() string {
+	if s == WindowsPwsh {
+		return "pwsh"
+	}
+	return "powershell"
+}
+
+// windowsShell returns the shell scripts are invoked with on windows targets.
+func (o *options) windowsShell() WindowsShell {
+	if o.windows == nil {
+		return WindowsPowerShell
+	}
+	return *o.windows
+}
+
+// validateWindows ensures the options are supported by windows targets.
+func (o *options) validateWindows() error {
+	if o.sudo != nil {
+		return fmt.Errorf("sudo: %w", ErrUnsupportedOnWindows)
+	}
+	if o.workDir != nil {
+		return fmt.Errorf("remote work dir: %w", ErrUnsupportedOnWindows)
+	}
+	if o.upload != nil && o.upload.transfer == TransferSCP {
+		return fmt.Errorf("scp transfer: %w", ErrUnsupportedOnWindows)
+	}
+	return nil
+}
+
+// powershellScript builds the PowerShell script that sets the env (that of the
+// cmd, along with any set by the executor) with $env: assignments, then runs
+// the cmd, followed by any trailing arguments. The script is run in a script
+// block so that it can read its arguments from $args. The exit code is that
+// given to `exit` by the script, otherwise that of the last native command run
+// if it failed ($LASTEXITCODE), otherwise 1 if the last statement failed, else
+// 0. The console's output encoding is first set to UTF-8 (without a BOM), so
+// that the output of native commands is not in the OEM code page.
+func powershellScript(c nescript.Cmd, env []string) string {
+	var script strings.Builder
+	script.WriteString("$OutputEncoding = New-Object System.Text.UTF8Encoding $false\n")
+	script.WriteString("try { [Console]::OutputEncoding = $OutputEncoding } catch {}\n")
+	for _, e := range env {
+		if key, value, ok := strings.Cut(e, "="); ok {
+			fmt.Fprintf(&script, "${env:%s} = %s\n", shellquote.PowerShellBraced(key), shellquote.PowerShell(value))
+		}
+	}
+	script.WriteString("$global:LASTEXITCODE = 0\n")
+	if _, body, trailing, ok := c.Script(); ok {
+		script.WriteString("& {\n")
+		script.WriteString(strings.ReplaceAll(body, "\r\n", "\n"))
+		script.WriteString("\n}")
+		for _, arg := range trailing {
+			script.WriteString(" " + shellquote.PowerShell(arg))
+		}
+	} else {
+		script.WriteString("&")
+		for _, arg := range c.Raw() {
+			script.WriteString(" " + shellquote.PowerShell(arg))
+		}
+	}
+	script.WriteString("\n$nescriptSucceeded = $?\n")
+	script.WriteString("if ($global:LASTEXITCODE -ne 0) { exit $global:LASTEXITCODE }\n")
+	script.WriteString("if (-not $nescriptSucceeded) { exit 1 }\n")
+	script.WriteString("exit 0\n")
+	return script.String()
+}
+
+// encodedCommand builds the command running the PowerShell script passed as a
+// -EncodedCommand (base64 encoded UTF-16LE), which needs no quoting for
+// whichever shell OpenSSH is configured to run commands with.
+func encodedCommand(shell WindowsShell, script string) string {
+	return fmt.Sprintf("%s -NoProfile -NonInteractive -EncodedCommand %s", shell.executable(), shellquote.EncodedCommand(script))
+}
+
+// fileCommand builds the command running the PowerShell script uploaded to the
+// given (SFTP) path.
+func fileCommand(shell WindowsShell, path string) string {
+	return fmt.Sprintf(`%s -NoProfile -NonInteractive -ExecutionPolicy Bypass -File "%s"`, shell.executable(), windowsPath(path))
+}
+
+// windowsPath converts an SFTP path on a windows target (such as
+// /C:/Windows/Temp) into a windows path (C:\Windows\Temp).
+func windowsPath(path string) string {
+	if len(path) >= 3 && path[0] == '/' && path[2] == ':' {
+		path = path[1:]
+	}
+	return strings.ReplaceAll(path, "/", `\`)
+}
+
+// powershellFile encodes the script as an uploaded .ps1 file. Windows
+// PowerShell reads files without a byte order mark in the legacy code page,
+// so the script is written as UTF-8 with a BOM, with CRLF line endings.
+func powershellFile(script string) string {
+	return "\ufeff" + strings.ReplaceAll(script, "\n", "\r\n")
+}
+
+// normalizeWindowsOutput converts windows (CRLF) line endings into newlines.
+func normalizeWindowsOutput(output string) string {
+	return strings.ReplaceAll(output, "\r\n", "\n")
+}
@@ -0,0 +1,201 @@
+package sshe_test
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/sshe"
+)
+
+// fakePowerShellScript behaves as powershell does for the invocations used by
+// the executor, recording its args to FAKE_PS_RECORD and the script it was
+// given (decoded from -EncodedCommand, or read from -File) to FAKE_PS_SCRIPT.
+// It writes CRLF line endings, as windows programs do, and exits with
+// FAKE_PS_EXIT.
+const fakePowerShellScript = `#!/bin/sh
+printf "%s\n" "$*" > "$FAKE_PS_RECORD"
+while [ $# -gt 0 ]; do
+	case "$1" in
+	-EncodedCommand) shift; printf '%s' "$1" | base64 -d | iconv -f UTF-16LE -t UTF-8 > "$FAKE_PS_SCRIPT" ;;
+	-File) shift; cp "$(printf '%s' "$1" | tr '\\' /)" "$FAKE_PS_SCRIPT" ;;
+	esac
+	shift
+done
+printf 'out\r\nline 2\r\n'
+printf 'err\r\n' >&2
+exit "${FAKE_PS_EXIT:-0}"
+`
+
+// fakePowerShell puts the fake powershell (and pwsh) on the PATH of the test
+// server's commands, with the exit code given, returning the paths its args
+// and script are recorded to.
+func fakePowerShell(t *testing.T, exitCode string) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	for _, name := range []string{"powershell", "pwsh"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(fakePowerShellScript), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	record, script := filepath.Join(dir, "record"), filepath.Join(dir, "script.ps1")
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("FAKE_PS_RECORD", record)
+	t.Setenv("FAKE_PS_SCRIPT", script)
+	t.Setenv("FAKE_PS_EXIT", exitCode)
+	return record, script
+}
+
+func TestWindows(t *testing.T) {
+	uploadDir := t.TempDir()
+	for _, tc := range []struct {
+		name   string
+		opts   []sshe.Option
+		args   string
+		script []string
+	}{
+		{"powershell", []sshe.Option{sshe.WithWindows(sshe.WindowsPowerShell)}, "-NoProfile -NonInteractive -EncodedCommand ", []string{
+			"$OutputEncoding = New-Object System.Text.UTF8Encoding $false\n",
+			"${env:GREETING} = 'it''s me'\n",
+			"$global:LASTEXITCODE = 0\n& {\nWrite-Output $env:GREETING\nexit 3\n} 'a b' '$c'\n",
+			"$nescriptSucceeded = $?\nif ($global:LASTEXITCODE -ne 0) { exit $global:LASTEXITCODE }\nif (-not $nescriptSucceeded) { exit 1 }\nexit 0\n",
+		}},
+		{"pwsh", []sshe.Option{sshe.WithWindows(sshe.WindowsPwsh)}, "-NoProfile -NonInteractive -EncodedCommand ", []string{
+			"${env:GREETING} = 'it''s me'\n",
+		}},
+		{"uploaded", []sshe.Option{sshe.WithWindows(sshe.WindowsPowerShell), sshe.WithUpload(), sshe.WithUploadDir(uploadDir)}, "-NoProfile -NonInteractive -ExecutionPolicy Bypass -File " + strings.ReplaceAll(uploadDir, "/", `\`) + `\nescript-`, []string{
+			"\ufeff$OutputEncoding = New-Object System.Text.UTF8Encoding $false\r\n",
+			"${env:GREETING} = 'it''s me'\r\n",
+			"& {\r\nWrite-Output $env:GREETING\r\nexit 3\r\n} 'a b' '$c'\r\n",
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			record, scriptRecord := fakePowerShell(t, "3")
+			server := newTestServer(t)
+			cmd := nescript.NewScript("Write-Output $env:GREETING\r\nexit 3").Cmd().WithArgs("a b", "$c").WithEnv("GREETING=it's me")
+			process, err := cmd.Exec(sshe.Executor(server.Addr, server.Config, tc.opts...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := process.Result()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.StdOut != "out\nline 2\n" || result.StdErr != "err\n" || result.ExitCode != 3 {
+				t.Errorf("expected the CRLF output converted and the exit code kept, got %q, %q and %d", result.StdOut, result.StdErr, result.ExitCode)
+			}
+			if args, _ := os.ReadFile(record); !strings.HasPrefix(string(args), tc.args) {
+				t.Errorf("expected the args %q, got %q", tc.args, args)
+			}
+			script, err := os.ReadFile(scriptRecord)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, part := range tc.script {
+				if !strings.Contains(string(script), part) {
+					t.Errorf("expected the script to contain %q, got %q", part, script)
+				}
+			}
+			if strings.Contains(server.Commands()[0], "GREETING") {
+				t.Errorf("expected the env only within the encoded script, got the command %q", server.Commands()[0])
+			}
+		})
+	}
+}
+
+func TestWindowsOSDetection(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		ver      bool
+		platform sshe.Platform
+		stdout   string
+	}{
+		{"unix", false, sshe.PlatformUnixSh, "ran\n"},
+		{"windows", true, sshe.PlatformWindowsPowerShell, "out\nline 2\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fakePowerShell(t, "0")
+			if tc.ver {
+				// a windows target has ver rather than uname.
+				dir := t.TempDir()
+				os.WriteFile(filepath.Join(dir, "uname"), []byte("#!/bin/sh\nexit 127\n"), 0o755)
+				os.WriteFile(filepath.Join(dir, "ver"), []byte("#!/bin/sh\nprintf '\\r\\nMicrosoft Windows [Version 10.0.20348.2527]\\r\\n'\n"), 0o755)
+				t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+			}
+			server := newTestServer(t)
+			process, err := nescript.NewScript("echo ran").Cmd().Exec(sshe.Executor(server.Addr, server.Config, sshe.WithOSDetection()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := process.Result()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if platform, _ := sshe.PlatformFrom(result); platform != tc.platform || result.StdOut != tc.stdout {
+				t.Errorf("expected %s detected and the script run for it, got %s and %q", tc.platform, platform, result.StdOut)
+			}
+		})
+	}
+}
+
+func TestWindowsExitCodes(t *testing.T) {
+	if _, err := exec.LookPath("pwsh"); err != nil {
+		t.Skip("pwsh is not installed")
+	}
+	server := newTestServer(t)
+	for _, tc := range []struct {
+		name     string
+		script   string
+		exitCode int
+	}{
+		{"succeeded", "Write-Output ok", 0},
+		{"exit", "exit 5", 5},
+		{"native command failed", "sh -c 'exit 3'", 3},
+		{"native command failed then succeeded", "sh -c 'exit 3'; sh -c 'exit 0'", 0},
+		{"last statement failed", "Get-Item /missing", 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			process, err := nescript.NewScript(tc.script).Cmd().Exec(sshe.Executor(server.Addr, server.Config, sshe.WithWindows(sshe.WindowsPwsh)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := process.Result()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.ExitCode != tc.exitCode {
+				t.Errorf("expected exit code %d, got %d (stderr %q)", tc.exitCode, result.ExitCode, result.StdErr)
+			}
+		})
+	}
+}
+
+func TestWindowsUnsupported(t *testing.T) {
+	server := newTestServer(t)
+	for _, tc := range []struct {
+		name string
+		opts []sshe.Option
+	}{
+		{"sudo", []sshe.Option{sshe.WithSudo("")}},
+		{"remote work dir", []sshe.Option{sshe.WithRemoteWorkDir("/tmp")}},
+		{"scp transfer", []sshe.Option{sshe.WithUpload(), sshe.WithTransfer(sshe.TransferSCP)}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := append([]sshe.Option{sshe.WithWindows(sshe.WindowsPowerShell)}, tc.opts...)
+			process, err := nescript.NewScript("echo ran").Cmd().Exec(sshe.Executor(server.Addr, server.Config, opts...))
+			if err == nil {
+				_, err = process.Result()
+			}
+			if !errors.Is(err, sshe.ErrUnsupportedOnWindows) || !strings.HasPrefix(err.Error(), tc.name+": ") {
+				t.Errorf("expected %v for %s, got %v", sshe.ErrUnsupportedOnWindows, tc.name, err)
+			}
+			if len(server.Commands()) != 0 {
+				t.Errorf("expected nothing run, got %q", server.Commands())
+			}
+		})
+	}
+}
@@ -0,0 +1,22 @@
+package sshe
+
+import "github.com/neaas/nescript/internal/shellquote"
+
+// workDir describes the directory on the target scripts are executed from.
+type workDir struct {
+	path   string
+	create bool
+}
+
+// prelude returns the shell commands entering the work dir, exiting before the
+// script is run if it can not be (or, if requested, created first). As the
+// launch's start line follows the prelude (see options.command), the failure
+// is told apart from the script's by the script never having started.
+func (w *workDir) prelude() string {
+	path := shellquote.POSIX(w.path)
+	cd := "cd -- " + path
+	if w.create {
+		cd = "mkdir -p -- " + path + " && " + cd
+	}
+	return "{ " + cd + "; } || exit 1; "
+}
@@ -0,0 +1,52 @@
+package sshe_test
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/sshe"
+)
+
+func TestRemoteWorkDir(t *testing.T) {
+	fakeSudo(t)
+	server := newTestServer(t)
+	dir := t.TempDir()
+	missing := filepath.Join(dir, "missing")
+	for _, tc := range []struct {
+		name     string
+		opts     []sshe.Option
+		script   string
+		stdout   string
+		exitCode int
+		err      error
+	}{
+		{"existing", []sshe.Option{sshe.WithRemoteWorkDir(dir)}, "pwd", dir + "\n", 0, nil},
+		{"created", []sshe.Option{sshe.WithRemoteWorkDir(filepath.Join(dir, "a", "b")), sshe.WithRemoteWorkDirCreate()}, "pwd", filepath.Join(dir, "a", "b") + "\n", 0, nil},
+		{"missing", []sshe.Option{sshe.WithRemoteWorkDir(missing)}, "echo ran", "", 0, sshe.ErrWorkDirNotFound},
+		{"missing with sudo", []sshe.Option{sshe.WithRemoteWorkDir(missing), sshe.WithSudo("")}, "echo ran", "", 0, sshe.ErrWorkDirNotFound},
+		{"missing with remote kill", []sshe.Option{sshe.WithRemoteWorkDir(missing), sshe.WithRemoteKill()}, "echo ran", "", 0, sshe.ErrWorkDirNotFound},
+		{"script failing like the work dir", []sshe.Option{sshe.WithRemoteWorkDir(dir)}, "echo 'nescript: remote work dir not found' >&2; exit 1", "", 1, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			process, err := nescript.NewScript(tc.script).Cmd().Exec(sshe.Executor(server.Addr, server.Config, tc.opts...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := process.Result()
+			if tc.err != nil {
+				if !errors.Is(err, tc.err) {
+					t.Errorf("expected %v, got %v (result %v)", tc.err, err, result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.StdOut != tc.stdout || result.ExitCode != tc.exitCode {
+				t.Errorf("expected stdout %q and exit code %d, got %q and %d", tc.stdout, tc.exitCode, result.StdOut, result.ExitCode)
+			}
+		})
+	}
+}
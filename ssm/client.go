@@ -0,0 +1,102 @@
+package ssm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	awsssm "github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/aws/smithy-go"
+)
+
+// maxInstancesPerCommand is the most instance IDs a single SendCommand (or
+// DescribeInstanceInformation filter) accepts.
+const maxInstancesPerCommand = 50
+
+// Client is the part of the SSM API used by the executors. It is satisfied by
+// the AWS SDK's *ssm.Client, so an application's existing client (with its own
+// credentials, region and retries) is given to the executors, or a fake given
+// for testing.
+type Client interface {
+	SendCommand(ctx context.Context, params *awsssm.SendCommandInput, optFns ...func(*awsssm.Options)) (*awsssm.SendCommandOutput, error)
+	GetCommandInvocation(ctx context.Context, params *awsssm.GetCommandInvocationInput, optFns ...func(*awsssm.Options)) (*awsssm.GetCommandInvocationOutput, error)
+	CancelCommand(ctx context.Context, params *awsssm.CancelCommandInput, optFns ...func(*awsssm.Options)) (*awsssm.CancelCommandOutput, error)
+	DescribeInstanceInformation(ctx context.Context, params *awsssm.DescribeInstanceInformationInput, optFns ...func(*awsssm.Options)) (*awsssm.DescribeInstanceInformationOutput, error)
+}
+
+// S3Client is the part of the S3 API used to read command output written to
+// S3 (see WithOutputS3). It is satisfied by the AWS SDK's *s3.Client.
+type S3Client interface {
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+}
+
+// describeInstances gets the SSM registration of each of the instances, keyed
+// by instance ID. Instances not registered with SSM are absent.
+func describeInstances(ctx context.Context, client Client, instanceIDs []string) (map[string]types.InstanceInformation, error) {
+	instances := make(map[string]types.InstanceInformation, len(instanceIDs))
+	for _, batch := range batches(instanceIDs, maxInstancesPerCommand) {
+		input := &awsssm.DescribeInstanceInformationInput{
+			Filters: []types.InstanceInformationStringFilter{{
+				Key:    aws.String("InstanceIds"),
+				Values: batch,
+			}},
+			MaxResults: aws.Int32(maxInstancesPerCommand),
+		}
+		for {
+			out, err := client.DescribeInstanceInformation(ctx, input)
+			if err != nil {
+				return nil, fmt.Errorf("%w: failed to describe instances: %w", ErrConnection, err)
+			}
+			for _, info := range out.InstanceInformationList {
+				instances[aws.ToString(info.InstanceId)] = info
+			}
+			if aws.ToString(out.NextToken) == "" {
+				break
+			}
+			input.NextToken = out.NextToken
+		}
+	}
+	return instances, nil
+}
+
+// checkInstance checks that the instance is registered with SSM and online.
+func checkInstance(instances map[string]types.InstanceInformation, instanceID string) (types.InstanceInformation, error) {
+	info, ok := instances[instanceID]
+	if !ok {
+		return info, fmt.Errorf("%w: '%s'", ErrInstanceNotFound, instanceID)
+	}
+	if info.PingStatus != types.PingStatusOnline {
+		return info, fmt.Errorf("%w: '%s' is %s", ErrInstanceNotOnline, instanceID, info.PingStatus)
+	}
+	return info, nil
+}
+
+// isThrottled reports whether the API request was rejected as the account's
+// request rate was exceeded, after the client's own retries.
+func isThrottled(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.ErrorCode() {
+	case "ThrottlingException", "Throttling", "TooManyRequestsException", "RequestLimitExceeded":
+		return true
+	}
+	return false
+}
+
+// batches splits the IDs into batches no larger than the size.
+func batches(ids []string, size int) [][]string {
+	var split [][]string
+	for len(ids) > size {
+		split = append(split, ids[:size])
+		ids = ids[size:]
+	}
+	if len(ids) > 0 {
+		split = append(split, ids)
+	}
+	return split
+}
@@ -0,0 +1,57 @@
+package ssm
+
+import (
+	"errors"
+
+	"github.com/neaas/nescript"
+)
+
+var (
+	// ErrConnection is returned (wrapped) when a request to the SSM (or S3)
+	// API fails, such as when the credentials are rejected, as opposed to a
+	// failure of the command on the instance.
+	ErrConnection = nescript.NewError(nescript.ErrConnection, "failed to call the ssm api")
+
+	// ErrInstanceNotFound is returned (wrapped) when the instance is not a
+	// managed node registered with SSM in the client's account and region.
+	ErrInstanceNotFound = errors.New("ssm managed instance not found")
+
+	// ErrInstanceNotOnline is returned (wrapped) when the instance is
+	// registered with SSM, however its agent is not online (its ping status is
+	// ConnectionLost or Inactive), so would not receive the command.
+	ErrInstanceNotOnline = errors.New("ssm managed instance is not online")
+
+	// ErrUndeliverable is returned (wrapped) when SSM could not deliver the
+	// command to the instance, such as when the delivery timeout (see
+	// WithDeliveryTimeout) passed, the instance's platform does not match the
+	// document, or the instance profile lacks the required permissions.
+	ErrUndeliverable = errors.New("ssm command could not be delivered")
+
+	// ErrExecution is returned (wrapped) when the command was delivered,
+	// however did not run to completion, such as when the instance was stopped
+	// or terminated while it was running.
+	ErrExecution = errors.New("ssm command execution failed")
+
+	// ErrTimeout is returned (wrapped) when the script did not complete within
+	// the timeout given by WithTimeout. The command is cancelled.
+	ErrTimeout = nescript.NewError(nescript.ErrTimeout, "ssm command timed out")
+
+	// ErrCancelled is returned (wrapped) when the command was cancelled before
+	// it completed, by killing the process, cancelling the cmd's context, or
+	// from outside of nescript.
+	ErrCancelled = errors.New("ssm command cancelled")
+
+	// ErrSignalUnsupported is returned when a signal other than SIGINT,
+	// SIGTERM or SIGKILL is sent to an SSM process, as SSM can only cancel the
+	// command.
+	ErrSignalUnsupported = errors.New("signal not supported by ssm")
+
+	// ErrStdinUnsupported is returned when writing to an SSM process, as Run
+	// Command gives the script no stdin.
+	ErrStdinUnsupported = errors.New("stdin not supported by ssm")
+
+	// ErrSkipped is recorded in a FanOutError for the instances a cmd was not
+	// executed on, as the fan-out was cancelled before the command was sent, or
+	// SSM stopped sending it after an earlier failure with fail-fast enabled.
+	ErrSkipped = errors.New("skipped")
+)
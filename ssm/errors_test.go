@@ -0,0 +1,82 @@
+package ssm_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	awsssm "github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/ssm"
+)
+
+// refusingClient is a fake SSM API whose calls to send commands fail, as when
+// the credentials are rejected.
+type refusingClient struct {
+	*fakeClient
+}
+
+func (refusingClient) SendCommand(ctx context.Context, params *awsssm.SendCommandInput, optFns ...func(*awsssm.Options)) (*awsssm.SendCommandOutput, error) {
+	return nil, errors.New("UnrecognizedClientException: the security token is invalid")
+}
+
+func TestErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		client ssm.Client
+		opts   []ssm.Option
+		ctx    func() (context.Context, context.CancelFunc)
+		is     []error
+		class  string
+	}{
+		{"api call failed", refusingClient{newFakeClient()}, nil, func() (context.Context, context.CancelFunc) {
+			return context.WithCancel(context.Background())
+		}, []error{ssm.ErrConnection, nescript.ErrConnection}, nescript.ErrorClassConnection},
+		{"executor timeout", newFakeClient(), []ssm.Option{ssm.WithTimeout(100 * time.Millisecond)}, func() (context.Context, context.CancelFunc) {
+			return context.WithCancel(context.Background())
+		}, []error{ssm.ErrTimeout, nescript.ErrTimeout}, nescript.ErrorClassTimeout},
+		{"context deadline", newFakeClient(), nil, func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), 100*time.Millisecond)
+		}, []error{ssm.ErrCancelled, nescript.ErrTimeout, context.DeadlineExceeded}, nescript.ErrorClassTimeout},
+		{"context cancelled", newFakeClient(), nil, func() (context.Context, context.CancelFunc) {
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(100*time.Millisecond, cancel)
+			return ctx, cancel
+		}, []error{ssm.ErrCancelled, nescript.ErrCancelled, context.Canceled}, nescript.ErrorClassCancelled},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := append([]ssm.Option{ssm.WithPollInterval(time.Millisecond, 10*time.Millisecond)}, tc.opts...)
+			ctx, cancel := tc.ctx()
+			defer cancel()
+			process, err := nescript.NewCmd("sleep", "30").WithContext(ctx).Exec(ssm.Executor(tc.client, "i-0123", opts...))
+			if err == nil {
+				_, err = process.Result()
+			}
+			for _, target := range tc.is {
+				if !errors.Is(err, target) {
+					t.Errorf("expected %v to match %v", err, target)
+				}
+			}
+			if class := nescript.ErrorClass(err); class != tc.class {
+				t.Errorf("expected the class %s, got %s", tc.class, class)
+			}
+		})
+	}
+}
+
+func TestExitError(t *testing.T) {
+	executor := ssm.Executor(newFakeClient(), "i-0123", ssm.WithPollInterval(time.Millisecond, 10*time.Millisecond))
+	process, err := nescript.NewCmd("sh", "-c", "echo failed >&2; exit 3").Exec(executor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := process.Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var exitErr *nescript.ExitError
+	if !errors.As(result.Err(), &exitErr) || exitErr.Result.StdErr != "failed\n" || exitErr.Result.ExitCode != 3 {
+		t.Errorf("expected an *ExitError holding the result, got %v", result.Err())
+	}
+}
@@ -0,0 +1,133 @@
+package ssm
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsssm "github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/neaas/nescript"
+)
+
+// Executor creates an ExecFunc that runs cmds on the SSM managed instance with
+// Run Command, using the client. Before each execution the instance is checked
+// to be registered and online, and its platform determined (unless given with
+// WithPlatform), which selects AWS-RunShellScript or AWS-RunPowerShellScript.
+// The command's invocation is then polled until it completes.
+func Executor(client Client, instanceID string, opts ...Option) nescript.ExecFunc {
+	o := newOptions(opts)
+	return func(c nescript.Cmd) (nescript.Process, error) {
+		instances, err := describeInstances(c.Context(), client, []string{instanceID})
+		if err != nil {
+			return nil, err
+		}
+		info, err := checkInstance(instances, instanceID)
+		if err != nil {
+			return nil, err
+		}
+		platform := o.platformOf(info)
+		commandID, err := o.send(c.Context(), client, c, platform, []string{instanceID})
+		if err != nil {
+			return nil, err
+		}
+		return o.start(c.Context(), client, commandID, instanceID, platform, nescript.NewTee(c, nil, nil)), nil
+	}
+}
+
+// platformOf is the platform of the instance, unless one was given.
+func (o *options) platformOf(info types.InstanceInformation) Platform {
+	if o.platform != "" {
+		return o.platform
+	}
+	if info.PlatformType == types.PlatformTypeWindows {
+		return PlatformWindows
+	}
+	return PlatformLinux
+}
+
+// send sends the cmd to the instances (of the same platform) as a single
+// command, returning its ID.
+func (o *options) send(ctx context.Context, client Client, c nescript.Cmd, platform Platform, instanceIDs []string) (string, error) {
+	script := shellScript(c, o.shell)
+	if platform == PlatformWindows {
+		script = powershellScript(c)
+	}
+	document, _ := platform.document()
+	input := &awsssm.SendCommandInput{
+		DocumentName: aws.String(document),
+		InstanceIds:  instanceIDs,
+		Parameters: map[string][]string{
+			"commands": {script},
+		},
+	}
+	if o.workDir != "" {
+		input.Parameters["workingDirectory"] = []string{o.workDir}
+	}
+	if o.timeout > 0 {
+		input.Parameters["executionTimeout"] = []string{strconv.Itoa(int(math.Ceil(o.timeout.Seconds())))}
+	}
+	if o.deliveryTimeout > 0 {
+		input.TimeoutSeconds = aws.Int32(int32(math.Ceil(o.deliveryTimeout.Seconds())))
+	}
+	if o.comment != "" {
+		input.Comment = aws.String(o.comment)
+	}
+	if o.s3Bucket != "" {
+		input.OutputS3BucketName = aws.String(o.s3Bucket)
+		if o.s3Prefix != "" {
+			input.OutputS3KeyPrefix = aws.String(o.s3Prefix)
+		}
+	}
+	if o.concurrency != "" {
+		input.MaxConcurrency = aws.String(o.concurrency)
+	}
+	if o.failFast {
+		input.MaxErrors = aws.String("0")
+	}
+	out, err := client.SendCommand(ctx, input)
+	if err != nil {
+		return "", fmt.Errorf("%w: failed to send command: %w", ErrConnection, err)
+	}
+	if out.Command == nil || aws.ToString(out.Command.CommandId) == "" {
+		return "", fmt.Errorf("%w: no command ID returned", ErrConnection)
+	}
+	return aws.ToString(out.Command.CommandId), nil
+}
+
+// start begins polling the command's invocation on the instance, within the
+// timeout. The output is written to the tee (if any) once collected.
+func (o *options) start(ctx context.Context, client Client, commandID, instanceID string, platform Platform, tee *nescript.Tee) *SSMProcess {
+	var (
+		pollCtx context.Context
+		cancel  context.CancelFunc
+	)
+	if o.timeout > 0 {
+		pollCtx, cancel = context.WithTimeout(ctx, o.timeout)
+	} else {
+		pollCtx, cancel = context.WithCancel(ctx)
+	}
+	process := &SSMProcess{
+		client:          client,
+		commandID:       commandID,
+		instanceID:      instanceID,
+		platform:        platform,
+		s3Client:        o.s3Client,
+		s3Bucket:        o.s3Bucket,
+		s3Prefix:        o.s3Prefix,
+		pollInterval:    o.pollInterval,
+		maxPollInterval: o.maxPollInterval,
+		parent:          ctx,
+		ctx:             pollCtx,
+		cancel:          cancel,
+		timeout:         o.timeout > 0,
+		done:            make(chan struct{}),
+		started:         time.Now(),
+		tee:             tee,
+	}
+	go process.watch()
+	return process
+}
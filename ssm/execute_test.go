@@ -0,0 +1,163 @@
+package ssm_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsssm "github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/ssm"
+)
+
+// fakeClient is an SSM API with one online linux instance, which runs the
+// commands sent to it as local processes.
+type fakeClient struct {
+	mu       sync.Mutex
+	commands map[string]*fakeCommand
+	cancels  int
+}
+
+// fakeCommand is a command sent to the fake instance.
+type fakeCommand struct {
+	cmd    *exec.Cmd
+	stdout bytes.Buffer
+	stderr bytes.Buffer
+	done   chan struct{}
+	status types.CommandInvocationStatus
+	code   int
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{commands: make(map[string]*fakeCommand)}
+}
+
+func (f *fakeClient) DescribeInstanceInformation(ctx context.Context, params *awsssm.DescribeInstanceInformationInput, optFns ...func(*awsssm.Options)) (*awsssm.DescribeInstanceInformationOutput, error) {
+	return &awsssm.DescribeInstanceInformationOutput{InstanceInformationList: []types.InstanceInformation{{
+		InstanceId:   aws.String("i-0123"),
+		PingStatus:   types.PingStatusOnline,
+		PlatformType: types.PlatformTypeLinux,
+	}}}, nil
+}
+
+func (f *fakeClient) SendCommand(ctx context.Context, params *awsssm.SendCommandInput, optFns ...func(*awsssm.Options)) (*awsssm.SendCommandOutput, error) {
+	c := &fakeCommand{done: make(chan struct{}), status: types.CommandInvocationStatusInProgress}
+	c.cmd = exec.Command("sh", "-c", params.Parameters["commands"][0])
+	c.cmd.Stdout, c.cmd.Stderr = &c.stdout, &c.stderr
+	if err := c.cmd.Start(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	id := fmt.Sprintf("command-%d", len(f.commands))
+	f.commands[id] = c
+	f.mu.Unlock()
+	go func() {
+		err := c.cmd.Wait()
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if c.status == types.CommandInvocationStatusInProgress {
+			c.status, c.code = types.CommandInvocationStatusSuccess, 0
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				c.status, c.code = types.CommandInvocationStatusFailed, exitErr.ExitCode()
+			}
+		}
+		close(c.done)
+	}()
+	return &awsssm.SendCommandOutput{Command: &types.Command{CommandId: aws.String(id)}}, nil
+}
+
+func (f *fakeClient) GetCommandInvocation(ctx context.Context, params *awsssm.GetCommandInvocationInput, optFns ...func(*awsssm.Options)) (*awsssm.GetCommandInvocationOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, ok := f.commands[aws.ToString(params.CommandId)]
+	if !ok {
+		return nil, &types.InvocationDoesNotExist{}
+	}
+	select {
+	case <-c.done:
+	default:
+		return &awsssm.GetCommandInvocationOutput{Status: types.CommandInvocationStatusInProgress}, nil
+	}
+	return &awsssm.GetCommandInvocationOutput{
+		Status:                c.status,
+		ResponseCode:          int32(c.code),
+		StandardOutputContent: aws.String(c.stdout.String()),
+		StandardErrorContent:  aws.String(c.stderr.String()),
+	}, nil
+}
+
+func (f *fakeClient) CancelCommand(ctx context.Context, params *awsssm.CancelCommandInput, optFns ...func(*awsssm.Options)) (*awsssm.CancelCommandOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cancels++
+	if c, ok := f.commands[aws.ToString(params.CommandId)]; ok && c.status == types.CommandInvocationStatusInProgress {
+		c.status = types.CommandInvocationStatusCancelled
+		c.cmd.Process.Kill()
+	}
+	return &awsssm.CancelCommandOutput{}, nil
+}
+
+// Cancels returns how many times a command was cancelled.
+func (f *fakeClient) Cancels() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.cancels
+}
+
+func TestExecutor(t *testing.T) {
+	client := newFakeClient()
+	executor := ssm.Executor(client, "i-0123", ssm.WithPollInterval(time.Millisecond, 10*time.Millisecond))
+	cmd := nescript.NewCmd("sh", "-c", `echo "$GREETING"; echo oops >&2; exit 3`).WithEnv("GREETING=it's $HOME")
+	process, err := cmd.Exec(executor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := process.Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.StdOut != "it's $HOME\n" || result.StdErr != "oops\n" || result.ExitCode != 3 {
+		t.Errorf("expected the command's output and exit code, got %q, %q, %d", result.StdOut, result.StdErr, result.ExitCode)
+	}
+}
+
+func TestExecutorContext(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts []ssm.Option
+		ctx  func() (context.Context, context.CancelFunc)
+		err  error
+	}{
+		{"timeout", []ssm.Option{ssm.WithTimeout(100 * time.Millisecond)}, func() (context.Context, context.CancelFunc) {
+			return context.WithCancel(context.Background())
+		}, ssm.ErrTimeout},
+		{"cancelled", nil, func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), 100*time.Millisecond)
+		}, ssm.ErrCancelled},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client := newFakeClient()
+			opts := append([]ssm.Option{ssm.WithPollInterval(time.Millisecond, 10*time.Millisecond)}, tc.opts...)
+			ctx, cancel := tc.ctx()
+			defer cancel()
+			process, err := nescript.NewCmd("sleep", "30").WithContext(ctx).Exec(ssm.Executor(client, "i-0123", opts...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := process.Result(); !errors.Is(err, tc.err) {
+				t.Errorf("expected %v, got %v", tc.err, err)
+			}
+			if client.Cancels() != 1 {
+				t.Errorf("expected the command cancelled once, got %d", client.Cancels())
+			}
+		})
+	}
+}
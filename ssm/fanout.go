@@ -0,0 +1,216 @@
+package ssm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/neaas/nescript"
+)
+
+// Outcome is how the execution on an instance of a fan-out ended.
+type Outcome string
+
+const (
+	// OutcomeSucceeded means the script exited successfully, with a zero exit
+	// code or one of its success codes (see nescript.SuccessCodes).
+	OutcomeSucceeded Outcome = "succeeded"
+
+	// OutcomeFailed means the script exited unsuccessfully, or could not be
+	// executed (or its result collected).
+	OutcomeFailed Outcome = "failed"
+
+	// OutcomeTimedOut means the timeout (see WithTimeout) passed before the
+	// script completed.
+	OutcomeTimedOut Outcome = "timed out"
+
+	// OutcomeSkipped means the script was not executed on the instance, as the
+	// fan-out was cancelled before the command was sent, or SSM stopped sending
+	// it after an earlier failure with fail-fast enabled.
+	OutcomeSkipped Outcome = "skipped"
+)
+
+// InstanceResult is the outcome of the execution on one instance of a
+// fan-out. Either the result or the error is set.
+type InstanceResult struct {
+	Instance string
+	Outcome  Outcome
+	Result   *nescript.Result
+	Err      error
+	Duration time.Duration
+}
+
+// FanOutResults are the outcomes of a fan-out, keyed by instance ID, along
+// with the number of instances with each outcome, and the IDs of the commands
+// sent.
+type FanOutResults struct {
+	Instances  map[string]InstanceResult
+	CommandIDs []string
+
+	Succeeded int
+	Failed    int
+	TimedOut  int
+	Skipped   int
+}
+
+// ProgressFunc is called as each instance of a fan-out completes (or is
+// skipped), with the number of instances completed so far and the total. Calls
+// are not made concurrently.
+type ProgressFunc func(result InstanceResult, completed, total int)
+
+// FanOutError is returned by ExecAll when the cmd could not be executed on, or
+// its result collected from, one or more of the instances. Errors is keyed by
+// instance ID.
+type FanOutError struct {
+	Errors map[string]error
+}
+
+func (e *FanOutError) Error() string {
+	ids := make([]string, 0, len(e.Errors))
+	for id := range e.Errors {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	messages := make([]string, len(ids))
+	for i, id := range ids {
+		messages[i] = fmt.Sprintf("instance '%s': %s", id, e.Errors[id])
+	}
+	return fmt.Sprintf("failed on %d instance(s): %s", len(ids), strings.Join(messages, "; "))
+}
+
+func (e *FanOutError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// ExecAll executes the cmd on every instance, waiting for each to complete.
+// Rather than a command per instance, the instances are sent a single command
+// for each platform (and each 50 instances), which SSM runs on all of them at
+// once unless WithConcurrency is given. Instances that are not registered or
+// online are failed without the others being affected. A failure on one
+// instance does not stop the others unless WithFailFast is given. The outcome
+// of every instance is returned; if any errored, a *FanOutError is also
+// returned. The fan-out is limited by the cmd's context.
+func ExecAll(client Client, instanceIDs []string, c nescript.Cmd, opts ...Option) (*FanOutResults, error) {
+	o := newOptions(opts)
+	seen := make(map[string]bool, len(instanceIDs))
+	for _, id := range instanceIDs {
+		if seen[id] {
+			return nil, fmt.Errorf("duplicate instance '%s'", id)
+		}
+		seen[id] = true
+	}
+	ctx := c.Context()
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		results = &FanOutResults{Instances: make(map[string]InstanceResult, len(instanceIDs))}
+	)
+	record := func(result InstanceResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		results.Instances[result.Instance] = result
+		switch result.Outcome {
+		case OutcomeSucceeded:
+			results.Succeeded++
+		case OutcomeFailed:
+			results.Failed++
+		case OutcomeTimedOut:
+			results.TimedOut++
+		case OutcomeSkipped:
+			results.Skipped++
+		}
+		if o.progress != nil {
+			o.progress(result, len(results.Instances), len(instanceIDs))
+		}
+	}
+	instances, err := describeInstances(ctx, client, instanceIDs)
+	if err != nil {
+		return nil, err
+	}
+	platforms := make(map[Platform][]string)
+	var order []Platform
+	for _, id := range instanceIDs {
+		info, err := checkInstance(instances, id)
+		if err != nil {
+			record(InstanceResult{Instance: id, Outcome: OutcomeFailed, Err: err})
+			continue
+		}
+		platform := o.platformOf(info)
+		if _, ok := platforms[platform]; !ok {
+			order = append(order, platform)
+		}
+		platforms[platform] = append(platforms[platform], id)
+	}
+	for _, platform := range order {
+		for _, batch := range batches(platforms[platform], maxInstancesPerCommand) {
+			if err := ctx.Err(); err != nil {
+				for _, id := range batch {
+					record(InstanceResult{Instance: id, Outcome: OutcomeSkipped, Err: fmt.Errorf("%w: %w", ErrSkipped, err)})
+				}
+				continue
+			}
+			start := time.Now()
+			commandID, err := o.send(ctx, client, c, platform, batch)
+			if err != nil {
+				for _, id := range batch {
+					record(InstanceResult{Instance: id, Outcome: OutcomeFailed, Err: err})
+				}
+				continue
+			}
+			results.CommandIDs = append(results.CommandIDs, commandID)
+			for _, id := range batch {
+				process := o.start(ctx, client, commandID, id, platform, nescript.NewTee(c, nil, nil))
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					record(o.instanceResult(ctx, process, start))
+				}()
+			}
+		}
+	}
+	wg.Wait()
+	errs := make(map[string]error)
+	for id, result := range results.Instances {
+		if result.Err != nil {
+			errs[id] = result.Err
+		}
+	}
+	if len(errs) > 0 {
+		return results, &FanOutError{Errors: errs}
+	}
+	return results, nil
+}
+
+// instanceResult waits for the result of the invocation on the instance, and
+// determines its outcome.
+func (o *options) instanceResult(ctx context.Context, process *SSMProcess, start time.Time) InstanceResult {
+	result, err := process.Result()
+	outcome := InstanceResult{
+		Instance: process.instanceID,
+		Result:   result,
+		Err:      err,
+		Duration: time.Since(start),
+	}
+	switch {
+	case errors.Is(err, ErrTimeout):
+		outcome.Outcome = OutcomeTimedOut
+	case o.failFast && errors.Is(err, ErrCancelled) && ctx.Err() == nil:
+		// SSM cancels the invocations it has not sent once the error
+		// threshold is reached.
+		outcome.Outcome = OutcomeSkipped
+		outcome.Err = fmt.Errorf("%w: %w", ErrSkipped, err)
+	case err != nil || !result.Success():
+		outcome.Outcome = OutcomeFailed
+	default:
+		outcome.Outcome = OutcomeSucceeded
+	}
+	return outcome
+}
@@ -0,0 +1,176 @@
+package ssm
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/neaas/nescript"
+)
+
+const (
+	// defaultPollInterval is how long after sending the command the invocation
+	// is first polled, which then backs off up to the max poll interval.
+	defaultPollInterval = time.Second
+
+	// defaultMaxPollInterval is the longest the invocation goes without being
+	// polled, including when the API is throttling requests.
+	defaultMaxPollInterval = 15 * time.Second
+
+	// cleanupTimeout bounds the request cancelling the command, as it is sent
+	// after the cmd context is done.
+	cleanupTimeout = 30 * time.Second
+)
+
+// Platform is the platform of the instance, which determines the document the
+// script is sent with.
+type Platform string
+
+const (
+	// PlatformLinux runs the script with AWS-RunShellScript, which is also
+	// used for macOS instances.
+	PlatformLinux Platform = "Linux"
+
+	// PlatformWindows runs the script with AWS-RunPowerShellScript.
+	PlatformWindows Platform = "Windows"
+)
+
+// document is the SSM document the script is sent with on the platform, along
+// with the name of its plugin, which the S3 output keys are named after.
+func (p Platform) document() (string, string) {
+	if p == PlatformWindows {
+		return "AWS-RunPowerShellScript", "awsrunPowerShellScript"
+	}
+	return "AWS-RunShellScript", "awsrunShellScript"
+}
+
+// Option configures the SSM executors.
+type Option func(*options)
+
+type options struct {
+	platform        Platform
+	shell           nescript.Subcommand
+	workDir         string
+	comment         string
+	timeout         time.Duration
+	deliveryTimeout time.Duration
+	s3Client        S3Client
+	s3Bucket        string
+	s3Prefix        string
+	pollInterval    time.Duration
+	maxPollInterval time.Duration
+	concurrency     string
+	failFast        bool
+	progress        ProgressFunc
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		pollInterval:    defaultPollInterval,
+		maxPollInterval: defaultMaxPollInterval,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithPlatform sets the platform of the instances, rather than it being taken
+// from each instance's SSM registration.
+func WithPlatform(platform Platform) Option {
+	return func(o *options) {
+		o.platform = platform
+	}
+}
+
+// WithShell sets the shell scripts are invoked with on linux instances, such
+// as nescript.SCBash, rather than the cmd's subcommand. Cmds not created from
+// a script are run as they are.
+func WithShell(shell nescript.Subcommand) Option {
+	return func(o *options) {
+		o.shell = shell
+	}
+}
+
+// WithWorkDir sets the directory the script is run from on the instance.
+func WithWorkDir(dir string) Option {
+	return func(o *options) {
+		o.workDir = dir
+	}
+}
+
+// WithComment sets the comment the command is sent with, which is shown in
+// the SSM console and command history.
+func WithComment(comment string) Option {
+	return func(o *options) {
+		o.comment = comment
+	}
+}
+
+// WithTimeout limits how long the script may run for. If the script has not
+// completed within the timeout, the command is cancelled and Result returns
+// ErrTimeout. The timeout is also given to the document as its execution
+// timeout, so that the agent ends the script even if the cancellation is not
+// received. Without it, the documents' own execution timeout of one hour
+// applies.
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.timeout = timeout
+	}
+}
+
+// WithDeliveryTimeout sets how long SSM tries to deliver the command to an
+// instance that has not received it, such as one that briefly loses its
+// connection, before giving up with ErrUndeliverable. SSM's minimum is 30
+// seconds, and its default 10 minutes.
+func WithDeliveryTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.deliveryTimeout = timeout
+	}
+}
+
+// WithOutputS3 has the agent write the script's complete output to the S3
+// bucket, under the key prefix, from where it is read with the client. Without
+// it, the output is limited to the first 24000 characters of each of stdout
+// and stderr (see MetadataTruncated). The instance profile must be allowed to
+// put objects into the bucket, and the client to get them.
+func WithOutputS3(client S3Client, bucket, prefix string) Option {
+	return func(o *options) {
+		o.s3Client = client
+		o.s3Bucket = bucket
+		o.s3Prefix = prefix
+	}
+}
+
+// WithPollInterval sets how long after sending the command its invocation is
+// first polled, and the longest between polls. The interval grows between each
+// poll, and doubles when the API throttles the requests, up to the max.
+func WithPollInterval(interval, max time.Duration) Option {
+	return func(o *options) {
+		o.pollInterval = interval
+		o.maxPollInterval = max
+	}
+}
+
+// WithConcurrency sets how many instances ExecAll has SSM run the command on
+// at once, rather than all of them.
+func WithConcurrency(concurrency int) Option {
+	return func(o *options) {
+		o.concurrency = strconv.Itoa(concurrency)
+	}
+}
+
+// WithFailFast has SSM stop sending the command to further instances once it
+// fails on one, with ExecAll. The instances it was not sent to are recorded as
+// skipped. This is most useful with WithConcurrency.
+func WithFailFast() Option {
+	return func(o *options) {
+		o.failFast = true
+	}
+}
+
+// WithProgress sets the func called as each instance of ExecAll completes.
+func WithProgress(progress ProgressFunc) Option {
+	return func(o *options) {
+		o.progress = progress
+	}
+}
@@ -0,0 +1,310 @@
+package ssm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	awsssm "github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/neaas/nescript"
+)
+
+const (
+	// MetadataCommandID is the result metadata key holding the ID of the SSM
+	// command the script was sent as.
+	MetadataCommandID = "ssm.command"
+
+	// MetadataInstance is the result metadata key holding the ID of the
+	// instance the script was executed on.
+	MetadataInstance = "ssm.instance"
+
+	// MetadataPlatform is the result metadata key holding the Platform the
+	// script was executed as.
+	MetadataPlatform = "ssm.platform"
+
+	// MetadataTruncated is the result metadata key set to true when the output
+	// was not written to S3 (see WithOutputS3), and stdout or stderr reached
+	// the limit of the output returned by the API, so may be incomplete.
+	MetadataTruncated = "ssm.truncated"
+
+	// inlineOutputLimit is the most characters of stdout and stderr returned
+	// by GetCommandInvocation.
+	inlineOutputLimit = 24000
+)
+
+// SSMProcess represents a single invocation of the script running or completed
+// on an instance, sent by the SSM executors.
+type SSMProcess struct {
+	client          Client
+	commandID       string
+	instanceID      string
+	platform        Platform
+	s3Client        S3Client
+	s3Bucket        string
+	s3Prefix        string
+	pollInterval    time.Duration
+	maxPollInterval time.Duration
+	parent          context.Context
+	ctx             context.Context
+	cancel          context.CancelFunc
+	timeout         bool
+	done            chan struct{}
+	started         time.Time
+	tee             *nescript.Tee
+
+	// set by watch, before done is closed.
+	stdout    string
+	stderr    string
+	exitCode  int
+	truncated bool
+	ended     time.Time
+	err       error
+}
+
+// CommandID returns the ID of the SSM command the script was sent as.
+func (p *SSMProcess) CommandID() string {
+	return p.commandID
+}
+
+// InstanceID returns the ID of the instance the script is executing on.
+func (p *SSMProcess) InstanceID() string {
+	return p.instanceID
+}
+
+// watch polls the invocation until it completes, backing off between polls.
+// If the context is done first, the command is cancelled.
+func (p *SSMProcess) watch() {
+	defer close(p.done)
+	defer p.tee.Finish(nil)
+	defer p.cancel()
+	interval := p.pollInterval
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-p.ctx.Done():
+			p.cancelCommand()
+			p.err = p.ctxErr()
+			return
+		case <-timer.C:
+		}
+		out, err := p.client.GetCommandInvocation(p.ctx, &awsssm.GetCommandInvocationInput{
+			CommandId:  aws.String(p.commandID),
+			InstanceId: aws.String(p.instanceID),
+		})
+		var notFound *types.InvocationDoesNotExist
+		switch {
+		case err != nil && p.ctx.Err() != nil:
+		case isThrottled(err):
+			interval *= 2
+		case errors.As(err, &notFound):
+			// the invocation is not visible until shortly after the command
+			// is sent.
+			interval += interval / 2
+		case err != nil:
+			p.err = fmt.Errorf("%w: failed to get command invocation: %w", ErrConnection, err)
+			return
+		case completed(out.Status):
+			p.ended = time.Now()
+			p.err = p.complete(out)
+			p.tee.WriteOutput(p.stdout, p.stderr)
+			return
+		default:
+			interval += interval / 2
+		}
+		if interval > p.maxPollInterval {
+			interval = p.maxPollInterval
+		}
+		timer.Reset(interval)
+	}
+}
+
+// completed reports whether the invocation has reached a terminal status.
+func completed(status types.CommandInvocationStatus) bool {
+	switch status {
+	case types.CommandInvocationStatusSuccess, types.CommandInvocationStatusFailed,
+		types.CommandInvocationStatusTimedOut, types.CommandInvocationStatusCancelled:
+		return true
+	}
+	return false
+}
+
+// complete records the completed invocation's exit code and output, or the
+// error the status maps to when the script did not run to completion.
+func (p *SSMProcess) complete(out *awsssm.GetCommandInvocationOutput) error {
+	details := aws.ToString(out.StatusDetails)
+	switch out.Status {
+	case types.CommandInvocationStatusCancelled:
+		return fmt.Errorf("%w: %s", ErrCancelled, details)
+	case types.CommandInvocationStatusTimedOut:
+		if details == "DeliveryTimedOut" {
+			return fmt.Errorf("%w: %s", ErrUndeliverable, details)
+		}
+		return fmt.Errorf("%w: %s on the instance", ErrTimeout, details)
+	case types.CommandInvocationStatusFailed:
+		switch details {
+		case "Undeliverable", "InvalidPlatform", "AccessDenied", "DeliveryTimedOut":
+			return fmt.Errorf("%w: %s", ErrUndeliverable, details)
+		case "Terminated":
+			return fmt.Errorf("%w: %s", ErrExecution, details)
+		}
+	}
+	p.exitCode = int(out.ResponseCode)
+	p.stdout = aws.ToString(out.StandardOutputContent)
+	p.stderr = aws.ToString(out.StandardErrorContent)
+	if p.s3Bucket == "" {
+		p.truncated = len([]rune(p.stdout)) >= inlineOutputLimit || len([]rune(p.stderr)) >= inlineOutputLimit
+		return nil
+	}
+	var err error
+	if p.stdout, err = p.s3Output("stdout"); err != nil {
+		return err
+	}
+	if p.stderr, err = p.s3Output("stderr"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// s3Output reads the complete stream written by the agent to S3. The agent
+// writes no object for an empty stream.
+func (p *SSMProcess) s3Output(stream string) (string, error) {
+	_, plugin := p.platform.document()
+	key := path.Join(strings.Trim(p.s3Prefix, "/"), p.commandID, p.instanceID, plugin, "0."+plugin, stream)
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+	out, err := p.s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(p.s3Bucket),
+		Key:    aws.String(key),
+	})
+	var noSuchKey *s3types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("%w: failed to get %s from s3 object '%s': %w", ErrConnection, stream, key, err)
+	}
+	defer out.Body.Close()
+	output, err := io.ReadAll(out.Body)
+	if err != nil {
+		return "", fmt.Errorf("%w: failed to read %s from s3 object '%s': %w", ErrConnection, stream, key, err)
+	}
+	return string(output), nil
+}
+
+// ctxErr is the error the execution ended with once its context is done.
+func (p *SSMProcess) ctxErr() error {
+	if p.timeout && errors.Is(p.ctx.Err(), context.DeadlineExceeded) && p.parent.Err() == nil {
+		return fmt.Errorf("%w: command cancelled", ErrTimeout)
+	}
+	return fmt.Errorf("%w: %w", ErrCancelled, nescript.ContextError(p.ctx.Err()))
+}
+
+// cancelCommand cancels the command on the instance.
+func (p *SSMProcess) cancelCommand() error {
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+	_, err := p.client.CancelCommand(ctx, &awsssm.CancelCommandInput{
+		CommandId:   aws.String(p.commandID),
+		InstanceIds: []string{p.instanceID},
+	})
+	return err
+}
+
+// Kill cancels the command, which the agent ends the script for. Result then
+// returns ErrCancelled once the invocation is cancelled. Once the invocation
+// has completed, Kill does nothing.
+func (p *SSMProcess) Kill() error {
+	select {
+	case <-p.done:
+		return nil
+	default:
+	}
+	if err := p.cancelCommand(); err != nil {
+		return fmt.Errorf("failed to cancel ssm command: %w", err)
+	}
+	return nil
+}
+
+// Signal cancels the command for SIGINT, SIGTERM and SIGKILL. Other signals
+// return ErrSignalUnsupported.
+func (p *SSMProcess) Signal(s os.Signal) error {
+	switch s {
+	case os.Interrupt, syscall.SIGINT, syscall.SIGTERM, os.Kill, syscall.SIGKILL:
+		return p.Kill()
+	}
+	return fmt.Errorf("%w: %s", ErrSignalUnsupported, s)
+}
+
+func (p *SSMProcess) Write(input string) error {
+	return ErrStdinUnsupported
+}
+
+func (p *SSMProcess) Stdin() io.WriteCloser {
+	return nescript.StdinWriter(p)
+}
+
+func (p *SSMProcess) Stdout() io.Reader {
+	return p.tee.StdoutStream()
+}
+
+func (p *SSMProcess) Stderr() io.Reader {
+	return p.tee.StderrStream()
+}
+
+// ID returns the ID of the SSM command the script was sent as (see
+// CommandID).
+func (p *SSMProcess) ID() string {
+	return p.commandID
+}
+
+func (p *SSMProcess) String() string {
+	return fmt.Sprintf("ssm command %s on %s", p.commandID, p.instanceID)
+}
+
+func (p *SSMProcess) Wait(ctx context.Context) (*nescript.Result, error) {
+	return nescript.Wait(ctx, p)
+}
+
+func (p *SSMProcess) Result() (*nescript.Result, error) {
+	defer p.Close()
+	<-p.done
+	if p.err != nil {
+		return nil, p.err
+	}
+	result := nescript.Result{
+		StdOut:   p.stdout,
+		StdErr:   p.stderr,
+		ExitCode: p.exitCode,
+	}
+	if p.platform == PlatformWindows {
+		result.StdOut = normalizeWindowsOutput(result.StdOut)
+		result.StdErr = normalizeWindowsOutput(result.StdErr)
+	}
+	result.SetTimes(p.started, p.ended)
+	result.SetMetadata(nescript.MetadataExecutor, "ssm")
+	result.SetMetadata(MetadataCommandID, p.commandID)
+	result.SetMetadata(MetadataInstance, p.instanceID)
+	result.SetMetadata(MetadataPlatform, p.platform)
+	if p.truncated {
+		result.SetMetadata(MetadataTruncated, true)
+	}
+	p.tee.Finish(&result)
+	return &result, nil
+}
+
+// Close stops polling the invocation, cancelling the command if it has not
+// completed.
+func (p *SSMProcess) Close() {
+	p.cancel()
+	<-p.done
+}
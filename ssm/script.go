@@ -0,0 +1,86 @@
+package ssm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/internal/shellquote"
+)
+
+// command converts the cmd into the command run on the instance. If a shell is
+// set and the cmd was created from a script, the script is passed to the shell,
+// otherwise the cmd is run as it is.
+func command(c nescript.Cmd, shell nescript.Subcommand) []string {
+	_, script, trailing, ok := c.Script()
+	if !ok || shell == nil {
+		return c.Raw()
+	}
+	return append(append(append([]string{}, shell...), script), trailing...)
+}
+
+// shellScript builds the commands given to AWS-RunShellScript, which are run
+// by sh: the cmd's env is exported, then the command is run quoted, so that
+// its exit code is that of the script.
+func shellScript(c nescript.Cmd, shell nescript.Subcommand) string {
+	var script strings.Builder
+	for _, e := range c.Env() {
+		if key, value, ok := strings.Cut(e, "="); ok {
+			fmt.Fprintf(&script, "export %s=%s\n", key, shellquote.POSIX(value))
+		}
+	}
+	args := command(c, shell)
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = shellquote.POSIX(arg)
+	}
+	script.WriteString(strings.Join(quoted, " "))
+	script.WriteString("\n")
+	return script.String()
+}
+
+// powershellScript builds the commands given to AWS-RunPowerShellScript. The
+// cmd's env is set with $env: assignments, then the cmd is run in a script
+// block, followed by any trailing arguments. The exit code is that given to
+// `exit` by the script, otherwise that of the last native command run if it
+// failed ($LASTEXITCODE), otherwise 1 if the last statement failed, else 0.
+// The console's output encoding is set to UTF-8, so that the output of native
+// commands is not in the instance's OEM code page.
+func powershellScript(c nescript.Cmd) string {
+	var script strings.Builder
+	script.WriteString("$ProgressPreference = 'SilentlyContinue'\n")
+	script.WriteString("$OutputEncoding = New-Object System.Text.UTF8Encoding $false\n")
+	script.WriteString("try { [Console]::OutputEncoding = $OutputEncoding } catch {}\n")
+	for _, e := range c.Env() {
+		if key, value, ok := strings.Cut(e, "="); ok {
+			fmt.Fprintf(&script, "${env:%s} = %s\n", shellquote.PowerShellBraced(key), shellquote.PowerShell(value))
+		}
+	}
+	script.WriteString("$global:LASTEXITCODE = 0\n")
+	if _, body, trailing, ok := c.Script(); ok {
+		script.WriteString("& {\n")
+		script.WriteString(strings.ReplaceAll(body, "\r\n", "\n"))
+		script.WriteString("\n}")
+		for _, arg := range trailing {
+			script.WriteString(" " + shellquote.PowerShell(arg))
+		}
+	} else {
+		script.WriteString("&")
+		for _, arg := range c.Raw() {
+			script.WriteString(" " + shellquote.PowerShell(arg))
+		}
+	}
+	script.WriteString("\n$nescriptSucceeded = $?\n")
+	script.WriteString("if ($global:LASTEXITCODE -ne 0) { exit $global:LASTEXITCODE }\n")
+	script.WriteString("if (-not $nescriptSucceeded) { exit 1 }\n")
+	script.WriteString("exit 0\n")
+	return script.String()
+}
+
+// normalizeWindowsOutput decodes UTF-16 output (such as that of a program
+// writing Unicode to a redirected stream) by its byte order mark, and converts
+// windows (CRLF) line endings into newlines.
+func normalizeWindowsOutput(output string) string {
+	output, _ = nescript.DecodeOutput(output, nescript.EncodingAuto)
+	return strings.ReplaceAll(output, "\r\n", "\n")
+}
@@ -0,0 +1,355 @@
+package nescript
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// StepMarker is the comment starting each step of a script (see
+	// Script.Steps), followed by the step's name, such as:
+	//
+	//	#nescript:step install packages
+	StepMarker = "#nescript:step"
+
+	// MetadataSteps is the result metadata key holding the StepReport of a
+	// script executed step by step (see Stepwise).
+	MetadataSteps = "nescript.steps"
+)
+
+// Step is a named part of a script (see Script.Steps).
+type Step struct {
+	Name   string
+	Script Script
+}
+
+// StepPolicy decides whether the steps of a script executed step by step (see
+// Stepwise) continue once a step fails.
+type StepPolicy int
+
+const (
+	// StepStopOnFailure stops at the first step that fails, skipping the rest.
+	// This is the default.
+	StepStopOnFailure StepPolicy = iota
+
+	// StepContinueOnFailure executes every step, whether those before failed
+	// or not.
+	StepContinueOnFailure
+)
+
+// StepResult is the outcome of a step of a script executed step by step.
+type StepResult struct {
+	Name     string        `json:"name"`
+	ExitCode int           `json:"exitCode"`
+	Success  bool          `json:"success"`
+	Skipped  bool          `json:"skipped,omitempty"`
+	Duration time.Duration `json:"duration"`
+	StdOut   string        `json:"stdout"`
+	StdErr   string        `json:"stderr"`
+
+	// Result is the result of the step, or nil if it was skipped.
+	Result *Result `json:"-"`
+}
+
+// StepReport is the outcome of each step of a script executed step by step,
+// in order, recorded on its result (see Result.Steps).
+type StepReport struct {
+	Steps []StepResult `json:"steps"`
+
+	// FirstFailed is the index of the first step that failed, or -1 if none
+	// did.
+	FirstFailed int `json:"firstFailed"`
+}
+
+// Failed returns the first step that failed, if any did.
+func (r StepReport) Failed() (StepResult, bool) {
+	if r.FirstFailed < 0 || r.FirstFailed >= len(r.Steps) {
+		return StepResult{}, false
+	}
+	return r.Steps[r.FirstFailed], true
+}
+
+// Steps returns the report of each step of the script, if it was executed step
+// by step (see Stepwise).
+func (r Result) Steps() (StepReport, bool) {
+	report, ok := r.Metadata[MetadataSteps].(StepReport)
+	return report, ok
+}
+
+// WithStep appends a step to the script, as the marker comment (see
+// StepMarker) naming it followed by its raw script.
+func (s Script) WithStep(name, raw string) Script {
+	if s.raw != "" && !strings.HasSuffix(s.raw, "\n") {
+		s.raw += "\n"
+	}
+	s.raw += StepMarker + " " + name + "\n" + raw
+	return s
+}
+
+// Steps splits the script into its steps, each started by a line of the marker
+// comment naming it (see StepMarker and WithStep). Anything before the first
+// step, such as `set -eu` or functions used by the steps, is a prologue
+// included in every step, as each is executed separately. A step without a
+// name is named by its position, such as "step 2", and a script without steps
+// is a single step. Each step has the script's fields and env.
+func (s Script) Steps() []Step {
+	steps := splitSteps(s.raw)
+	split := make([]Step, 0, len(steps))
+	for _, step := range steps {
+		script := s
+		script.raw = step.raw
+		split = append(split, Step{Name: step.name, Script: script})
+	}
+	return split
+}
+
+// rawStep is a step split from a raw script.
+type rawStep struct {
+	name string
+	raw  string
+}
+
+// splitSteps splits the raw script into its steps.
+func splitSteps(raw string) []rawStep {
+	var (
+		prologue strings.Builder
+		steps    []rawStep
+		current  *strings.Builder
+	)
+	bodies := make([]*strings.Builder, 0)
+	for _, line := range strings.SplitAfter(raw, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if rest, ok := strings.CutPrefix(trimmed, StepMarker); ok && (rest == "" || rest[0] == ' ' || rest[0] == '\t') {
+			name := strings.TrimSpace(rest)
+			if name == "" {
+				name = fmt.Sprintf("step %d", len(steps)+1)
+			}
+			steps = append(steps, rawStep{name: name})
+			current = &strings.Builder{}
+			bodies = append(bodies, current)
+			continue
+		}
+		if current == nil {
+			prologue.WriteString(line)
+		} else {
+			current.WriteString(line)
+		}
+	}
+	if len(steps) == 0 {
+		return []rawStep{{name: "step 1", raw: raw}}
+	}
+	for i := range steps {
+		steps[i].raw = prologue.String() + bodies[i].String()
+	}
+	return steps
+}
+
+// Stepwise wraps the executor so that scripts are executed step by step (see
+// Script.Steps), sequentially, each step as its own execution by the executor,
+// sharing a connection or session where the executor does (such as
+// sshe.Connection). Once a step fails, the rest are skipped unless the policy
+// is StepContinueOnFailure, as are those after the cmd's context is done.
+//
+// The result of the process is that of the script as a whole, with the output
+// of each step executed in turn, the exit code of the first step to fail (or
+// 0), and a StepReport of each step recorded on it (see Result.Steps), along
+// with the warnings of every step (see MetadataWarnings). Kill, Signal and
+// Write apply to the step being executed, while Stdout and Stderr stream the
+// output of each step in turn. If a step fails to start, or its result can not
+// be collected, that error is returned. Cmds not created from a script are
+// executed as they are.
+func Stepwise(executor ExecFunc, policy StepPolicy) ExecFunc {
+	return func(c Cmd) (Process, error) {
+		_, raw, _, ok := c.Script()
+		if !ok {
+			return executor(c)
+		}
+		steps := splitSteps(raw)
+		process, err := executor(c.withScriptRaw(steps[0].raw))
+		if err != nil {
+			return nil, fmt.Errorf("step '%s': %w", steps[0].name, err)
+		}
+		p := &stepsProcess{
+			executor: executor,
+			cmd:      c,
+			policy:   policy,
+			steps:    steps,
+			stdout:   newOutputStream(nil),
+			stderr:   newOutputStream(nil),
+			current:  process,
+		}
+		p.piped = p.pipe(process)
+		return p, nil
+	}
+}
+
+// stepsProcess executes the steps of a script in turn, as its result is
+// collected.
+type stepsProcess struct {
+	executor ExecFunc
+	cmd      Cmd
+	policy   StepPolicy
+	steps    []rawStep
+	stdout   *outputStream
+	stderr   *outputStream
+
+	mu      sync.Mutex
+	current Process
+	piped   <-chan struct{}
+	closed  bool
+}
+
+// pipe copies the output streams of the process of a step to those of the
+// steps, returning a channel closed once both have been copied.
+func (p *stepsProcess) pipe(process Process) <-chan struct{} {
+	piped := make(chan struct{})
+	stdout, stderr := process.Stdout(), process.Stderr()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		io.Copy(p.stdout, stdout)
+	}()
+	go func() {
+		defer wg.Done()
+		io.Copy(p.stderr, stderr)
+	}()
+	go func() {
+		wg.Wait()
+		close(piped)
+	}()
+	return piped
+}
+
+// process returns the process of the step being executed.
+func (p *stepsProcess) process() Process {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.current
+}
+
+func (p *stepsProcess) Kill() error {
+	return p.process().Kill()
+}
+
+func (p *stepsProcess) Signal(s os.Signal) error {
+	return p.process().Signal(s)
+}
+
+func (p *stepsProcess) Write(input string) error {
+	return p.process().Write(input)
+}
+
+func (p *stepsProcess) Stdin() io.WriteCloser {
+	return StdinWriter(p)
+}
+
+func (p *stepsProcess) Stdout() io.Reader {
+	return p.stdout.reader()
+}
+
+func (p *stepsProcess) Stderr() io.Reader {
+	return p.stderr.reader()
+}
+
+func (p *stepsProcess) ID() string {
+	return p.process().ID()
+}
+
+func (p *stepsProcess) String() string {
+	return p.process().String()
+}
+
+func (p *stepsProcess) Wait(ctx context.Context) (*Result, error) {
+	return Wait(ctx, p)
+}
+
+func (p *stepsProcess) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	p.current.Close()
+}
+
+func (p *stepsProcess) Result() (*Result, error) {
+	defer p.stdout.finish()
+	defer p.stderr.finish()
+	report := StepReport{Steps: make([]StepResult, 0, len(p.steps)), FirstFailed: -1}
+	aggregate := &Result{}
+	var stdout, stderr strings.Builder
+	for i, step := range p.steps {
+		if i > 0 && (report.FirstFailed >= 0 && p.policy != StepContinueOnFailure || p.cmd.Context().Err() != nil) {
+			report.Steps = append(report.Steps, StepResult{Name: step.name, Skipped: true})
+			continue
+		}
+		process, piped, err := p.start(i)
+		if err != nil {
+			return nil, fmt.Errorf("step '%s': %w", step.name, err)
+		}
+		result, err := process.Result()
+		if err != nil {
+			return nil, fmt.Errorf("step '%s': %w", step.name, err)
+		}
+		<-piped
+		stdout.WriteString(result.StdOut)
+		stderr.WriteString(result.StdErr)
+		if i == 0 {
+			aggregate.Start = result.Start
+			if name := result.Executor(); name != "" {
+				aggregate.SetMetadata(MetadataExecutor, name)
+			}
+		}
+		aggregate.End = result.End
+		if warnings, _ := result.Metadata[MetadataWarnings].([]string); len(warnings) > 0 {
+			existing, _ := aggregate.Metadata[MetadataWarnings].([]string)
+			aggregate.SetMetadata(MetadataWarnings, append(existing, warnings...))
+		}
+		success := result.Success()
+		if !success && report.FirstFailed < 0 {
+			report.FirstFailed = i
+			aggregate.ExitCode = result.ExitCode
+			aggregate.Signaled, aggregate.Signal = result.Signaled, result.Signal
+			aggregate.SuccessCodes = result.SuccessCodes
+		}
+		report.Steps = append(report.Steps, StepResult{
+			Name:     step.name,
+			ExitCode: result.ExitCode,
+			Success:  success,
+			Duration: result.Duration(),
+			StdOut:   result.StdOut,
+			StdErr:   result.StdErr,
+			Result:   result,
+		})
+	}
+	aggregate.StdOut, aggregate.StdErr = stdout.String(), stderr.String()
+	aggregate.SetTimes(aggregate.Start, aggregate.End)
+	aggregate.SetMetadata(MetadataSteps, report)
+	return aggregate, nil
+}
+
+// start returns the process of the step, starting it (once the process of the
+// step before is closed) unless it is the first, which was started by
+// Stepwise, along with the channel closed once its output has been piped to
+// the streams of the steps.
+func (p *stepsProcess) start(i int) (Process, <-chan struct{}, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if i == 0 {
+		return p.current, p.piped, nil
+	}
+	if p.closed {
+		return nil, nil, fmt.Errorf("%w: the process was closed", ErrExited)
+	}
+	p.current.Close()
+	process, err := p.executor(p.cmd.withScriptRaw(p.steps[i].raw))
+	if err != nil {
+		return nil, nil, err
+	}
+	p.current, p.piped = process, p.pipe(process)
+	return process, p.piped, nil
+}
@@ -0,0 +1,253 @@
+package nescript
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// MetadataWarnings is the result metadata key holding warnings about the
+// execution that did not fail it, such as a teed writer failing.
+const MetadataWarnings = "nescript.warnings"
+
+// Tee tees the output of a process to the writers of its cmd (see
+// Cmd.WithStdoutWriter), for executors to write the output to along with their
+// capture. Each writer is written to in the order the output was written, from
+// a goroutine of its own, so that a slow writer never blocks the script (the
+// output waiting for it is held in memory). Once a writer fails, the rest of
+// the output is not written to it, and the failure is recorded as a warning on
+// the result (see MetadataWarnings), rather than failing the execution. The
+// output is also streamed to the readers of the process (see StdoutStream).
+type Tee struct {
+	stdout       io.Writer
+	stderr       io.Writer
+	stdoutStream *outputStream
+	stderrStream *outputStream
+	queues       []*teeQueue
+}
+
+// NewTee creates the tee of the cmd's writers, along with the writers capturing
+// the output for the result (which are written to straight away and should
+// apply any cap on the captured output). If the cmd has no writers, the
+// captures and streams are written to alone. The captures may be nil, for
+// WriteOutput.
+func NewTee(c Cmd, stdout, stderr io.Writer) *Tee {
+	t := &Tee{stdoutStream: newOutputStream(stdout), stderrStream: newOutputStream(stderr)}
+	t.stdout = t.tee("stdout", t.stdoutStream, c.stdout)
+	t.stderr = t.tee("stderr", t.stderrStream, c.stderr)
+	return t
+}
+
+func (t *Tee) tee(stream string, s *outputStream, w io.Writer) io.Writer {
+	if w == nil {
+		return s
+	}
+	q := &teeQueue{stream: stream, w: w, done: make(chan struct{})}
+	q.cond = sync.NewCond(&q.mu)
+	go q.run()
+	t.queues = append(t.queues, q)
+	return io.MultiWriter(s, q)
+}
+
+// Stdout is the writer the process should write the script's stdout to.
+func (t *Tee) Stdout() io.Writer {
+	return t.stdout
+}
+
+// Stderr is the writer the process should write the script's stderr to.
+func (t *Tee) Stderr() io.Writer {
+	return t.stderr
+}
+
+// StdoutStream is the reader of the stdout written to the tee, for the
+// process's Stdout, which starts with the stdout captured so far and returns
+// io.EOF once the tee is finished. It returns an empty reader on a nil tee.
+func (t *Tee) StdoutStream() io.Reader {
+	if t == nil {
+		return strings.NewReader("")
+	}
+	return t.stdoutStream.reader()
+}
+
+// StderrStream is the reader of the stderr written to the tee, for the
+// process's Stderr (see StdoutStream).
+func (t *Tee) StderrStream() io.Reader {
+	if t == nil {
+		return strings.NewReader("")
+	}
+	return t.stderrStream.reader()
+}
+
+// WriteOutput writes the whole of the script's output to the cmd's writers at
+// once, for executors that only collect the output once the script has exited
+// (which may create the tee without captures). It does nothing on a nil tee.
+func (t *Tee) WriteOutput(stdout, stderr string) {
+	if t == nil {
+		return
+	}
+	if t.stdout != nil {
+		io.WriteString(t.stdout, stdout)
+	}
+	if t.stderr != nil {
+		io.WriteString(t.stderr, stderr)
+	}
+}
+
+// Finish waits for the output to be written to the cmd's writers, once the
+// output has been fully written to the tee, recording any that failed as
+// warnings on the result (if not nil), and ends the streams. Finish may be
+// called more than once, and on a nil tee.
+func (t *Tee) Finish(result *Result) {
+	if t == nil {
+		return
+	}
+	var warnings []string
+	for _, q := range t.queues {
+		if err := q.close(); err != nil {
+			warnings = append(warnings, fmt.Sprintf("failed to tee %s: %s", q.stream, err))
+		}
+	}
+	t.stdoutStream.finish()
+	t.stderrStream.finish()
+	if result != nil && len(warnings) > 0 {
+		existing, _ := result.Metadata[MetadataWarnings].([]string)
+		result.SetMetadata(MetadataWarnings, append(existing, warnings...))
+	}
+}
+
+// teeQueue writes the output queued to the writer, in order.
+type teeQueue struct {
+	stream string
+	w      io.Writer
+	done   chan struct{}
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queued [][]byte
+	closed bool
+	err    error
+}
+
+// Write queues a copy of the output, never failing.
+func (q *teeQueue) Write(p []byte) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.closed && q.err == nil {
+		q.queued = append(q.queued, append([]byte(nil), p...))
+		q.cond.Signal()
+	}
+	return len(p), nil
+}
+
+func (q *teeQueue) run() {
+	defer close(q.done)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for {
+		for len(q.queued) == 0 && !q.closed {
+			q.cond.Wait()
+		}
+		if len(q.queued) == 0 {
+			return
+		}
+		chunk := q.queued[0]
+		q.queued = q.queued[1:]
+		q.mu.Unlock()
+		_, err := q.w.Write(chunk)
+		q.mu.Lock()
+		if err != nil {
+			q.err = err
+			q.queued = nil
+			return
+		}
+	}
+}
+
+// close waits for the queued output to be written, returning the error of the
+// writer, if it failed.
+func (q *teeQueue) close() error {
+	q.mu.Lock()
+	q.closed = true
+	q.cond.Signal()
+	q.mu.Unlock()
+	<-q.done
+	return q.err
+}
+
+// outputStream writes the output to its capture, and holds the output for its
+// reader once it has been asked for, so that the script never blocks on a
+// reader (see Process.Stdout). Until then, nothing is held where the output
+// captured so far can be read from the capture (as with a *bytes.Buffer or
+// *Capture), which the reader starts with once asked for, so that output
+// not read is not held in memory twice. Once finished, reads return io.EOF
+// after the output held.
+type outputStream struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	capture  io.Writer
+	held     bytes.Buffer
+	read     bool
+	finished bool
+}
+
+func newOutputStream(capture io.Writer) *outputStream {
+	s := &outputStream{capture: capture}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// Write writes the output to the capture, holding a copy of it if it is being
+// read or can not be read from the capture.
+func (s *outputStream) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := len(p)
+	var err error
+	if s.capture != nil {
+		n, err = s.capture.Write(p)
+	}
+	if _, captured := s.capture.(fmt.Stringer); !s.finished && (s.read || !captured) {
+		s.held.Write(p)
+		s.cond.Broadcast()
+	}
+	return n, err
+}
+
+// reader returns the reader of the stream, which starts with the output
+// captured so far the first time it is asked for.
+func (s *outputStream) reader() io.Reader {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if captured, ok := s.capture.(fmt.Stringer); ok && !s.read {
+		s.held.WriteString(captured.String())
+	}
+	s.read = true
+	return streamReader{s}
+}
+
+// finish ends the stream.
+func (s *outputStream) finish() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.finished = true
+	s.cond.Broadcast()
+}
+
+// streamReader reads the output held by a stream, waiting for more until the
+// stream is finished.
+type streamReader struct {
+	s *outputStream
+}
+
+func (r streamReader) Read(p []byte) (int, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	for r.s.held.Len() == 0 && !r.s.finished {
+		r.s.cond.Wait()
+	}
+	if r.s.held.Len() == 0 {
+		return 0, io.EOF
+	}
+	return r.s.held.Read(p)
+}
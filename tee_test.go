@@ -0,0 +1,65 @@
+package nescript_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/neaas/nescript"
+)
+
+func TestTeeStreamStartsWithCapturedOutput(t *testing.T) {
+	var captured bytes.Buffer
+	tee := nescript.NewTee(*nescript.NewCmd("true"), &captured, nil)
+	io.WriteString(tee.Stdout(), "before ")
+	stream := tee.StdoutStream()
+	read := make(chan string, 1)
+	go func() {
+		b, _ := io.ReadAll(stream)
+		read <- string(b)
+	}()
+	io.WriteString(tee.Stdout(), "after")
+	tee.Finish(nil)
+	select {
+	case got := <-read:
+		if got != "before after" {
+			t.Errorf("expected the stream 'before after', got '%s'", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the stream to end once the tee finished")
+	}
+	if captured.String() != "before after" {
+		t.Errorf("expected the capture 'before after', got '%s'", captured.String())
+	}
+}
+
+func TestTeeStreamWithoutCapture(t *testing.T) {
+	tee := nescript.NewTee(*nescript.NewCmd("true"), nil, nil)
+	tee.WriteOutput("out", "err")
+	tee.Finish(nil)
+	for name, stream := range map[string]io.Reader{"stdout": tee.StdoutStream(), "stderr": tee.StderrStream()} {
+		b, _ := io.ReadAll(stream)
+		if expected := name[3:]; string(b) != expected {
+			t.Errorf("expected %s streamed '%s', got '%s'", name, expected, b)
+		}
+	}
+	var nilTee *nescript.Tee
+	if b, _ := io.ReadAll(nilTee.StdoutStream()); len(b) != 0 {
+		t.Errorf("expected a nil tee to stream nothing, got '%s'", b)
+	}
+}
+
+func TestStdinWriterWithoutCloser(t *testing.T) {
+	stdin := nescript.StdinWriter(newFakeProcess(""))
+	if _, err := stdin.Write([]byte("input")); err != nil {
+		t.Fatal(err)
+	}
+	if err := stdin.Close(); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("expected closing to fail with '%v', got: %v", errors.ErrUnsupported, err)
+	}
+	if err := nescript.StdinWriter(&closingFakeProcess{newFakeProcess("")}).Close(); err != nil {
+		t.Errorf("expected closing a stdin closer to succeed, got: %v", err)
+	}
+}
@@ -0,0 +1,175 @@
+package nescript
+
+import (
+	"io"
+	"maps"
+	"strings"
+	"text/template"
+)
+
+// Templater is a template engine compiling scripts and cmds (see
+// Script.Compile), such as Mustache or one that leaves them as they are. The
+// default is GoTemplater.
+type Templater interface {
+	// Parse parses the text as a template, returning an error if it is not
+	// valid.
+	Parse(text string) (Template, error)
+}
+
+// Template is a template parsed by a Templater.
+type Template interface {
+	// Render writes the template with the data to the writer, returning an
+	// error if it could not be rendered, such as where data is missing.
+	Render(w io.Writer, data map[string]any) error
+}
+
+// GoTemplater is the default Templater, of text/template, configured by
+// WithTemplateFuncs, WithStrictTemplating and WithDelims.
+type GoTemplater struct {
+	Funcs template.FuncMap
+
+	// Strict fails rendering where data is missing for a field (see
+	// WithStrictTemplating).
+	Strict bool
+
+	// LeftDelim and RightDelim are the delimiters of actions, {{ and }} where
+	// empty.
+	LeftDelim, RightDelim string
+}
+
+// Parse parses the text as a text/template template.
+func (g GoTemplater) Parse(text string) (Template, error) {
+	tmpl := template.New("").Delims(g.LeftDelim, g.RightDelim).Funcs(g.Funcs)
+	if g.Strict {
+		tmpl = tmpl.Option("missingkey=error")
+	}
+	tmpl, err := tmpl.Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return goTemplate{tmpl}, nil
+}
+
+// goTemplate is a template parsed by GoTemplater.
+type goTemplate struct {
+	*template.Template
+}
+
+func (t goTemplate) Render(w io.Writer, data map[string]any) error {
+	return t.Execute(w, data)
+}
+
+// templating holds how a script/cmd is compiled as a template, such as the
+// functions usable within it. It is carried from a script to its cmd (see
+// Script.Cmd), so that compiling either compiles the same way. The func map is
+// replaced rather than added to, so that copies of a script/cmd do not share
+// it.
+type templating struct {
+	engine      Templater
+	funcs       template.FuncMap
+	strict      bool
+	left, right string
+}
+
+// withFuncs returns the templating with the funcs added, replacing any of the
+// same name.
+func (t templating) withFuncs(funcs template.FuncMap) templating {
+	merged := make(template.FuncMap, len(t.funcs)+len(funcs))
+	maps.Copy(merged, t.funcs)
+	maps.Copy(merged, funcs)
+	t.funcs = merged
+	return t
+}
+
+// hasActions reports whether the text may contain template actions, as it
+// contains the left delimiter of the default templater. Text without compiles
+// to itself, so is not parsed. Text is always parsed by other templaters, as
+// their actions are not known.
+func (t templating) hasActions(text string) bool {
+	if t.engine != nil {
+		return true
+	}
+	left := t.left
+	if left == "" {
+		left = "{{"
+	}
+	return strings.Contains(text, left)
+}
+
+// parse parses the text as a template, with the templater given with
+// WithTemplater, or otherwise GoTemplater.
+func (t templating) parse(text string) (Template, error) {
+	if t.engine != nil {
+		return t.engine.Parse(text)
+	}
+	return GoTemplater{Funcs: t.funcs, Strict: t.strict, LeftDelim: t.left, RightDelim: t.right}.Parse(text)
+}
+
+// WithTemplateFuncs adds functions usable within the script's template when it
+// is compiled, such as a quote or upper helper, replacing any already added of
+// the same name. As with text/template, each must return a single value, or a
+// value and an error, and a template using a function not added fails to
+// compile with a *CompileError. The functions are carried to the script's cmd
+// (see Cmd).
+func (s Script) WithTemplateFuncs(funcs template.FuncMap) Script {
+	s.templating = s.templating.withFuncs(funcs)
+	return s
+}
+
+// WithTemplateFuncs adds functions usable within the templates of the
+// command's args when it is compiled (see Script.WithTemplateFuncs).
+func (c Cmd) WithTemplateFuncs(funcs template.FuncMap) Cmd {
+	c.templating = c.templating.withFuncs(funcs)
+	return c
+}
+
+// WithStrictTemplating makes compiling the script fail with a *CompileError
+// where its template uses a field that was not given data (see WithField),
+// rather than compiling it as "<no value>", so that a typo of a field name is
+// caught before the script is executed. The strictness is carried to the
+// script's cmd (see Cmd).
+func (s Script) WithStrictTemplating() Script {
+	s.templating.strict = true
+	return s
+}
+
+// WithStrictTemplating makes compiling the command fail where the template of
+// an arg uses a field that was not given data (see Script.WithStrictTemplating).
+func (c Cmd) WithStrictTemplating() Cmd {
+	c.templating.strict = true
+	return c
+}
+
+// WithDelims sets the delimiters of the script's template actions, in place of
+// {{ and }}, so that a script which itself contains {{ }} (such as one writing
+// a Helm chart or Go template) is left as it is, with only actions such as
+// [[.Name]] compiled. An empty delimiter is the default. The delimiters are
+// carried to the script's cmd (see Cmd).
+func (s Script) WithDelims(left, right string) Script {
+	s.templating.left, s.templating.right = left, right
+	return s
+}
+
+// WithDelims sets the delimiters of the template actions of the command's args
+// (see Script.WithDelims).
+func (c Cmd) WithDelims(left, right string) Cmd {
+	c.templating.left, c.templating.right = left, right
+	return c
+}
+
+// WithTemplater sets the template engine compiling the script, in place of
+// the default GoTemplater, which the functions, strictness and delimiters of
+// WithTemplateFuncs, WithStrictTemplating and WithDelims configure, so are not
+// used by another templater. A nil templater is the default. The templater is
+// carried to the script's cmd (see Cmd).
+func (s Script) WithTemplater(templater Templater) Script {
+	s.templating.engine = templater
+	return s
+}
+
+// WithTemplater sets the template engine compiling the command's args (see
+// Script.WithTemplater).
+func (c Cmd) WithTemplater(templater Templater) Cmd {
+	c.templating.engine = templater
+	return c
+}
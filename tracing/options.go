@@ -0,0 +1,54 @@
+package tracing
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Option configures the spans of compilation and execution.
+type Option func(*options)
+
+type options struct {
+	provider    trace.TracerProvider
+	targetType  string
+	target      string
+	traceParent bool
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{provider: otel.GetTracerProvider()}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+func (o *options) tracer() trace.Tracer {
+	return o.provider.Tracer(instrumentationName)
+}
+
+// WithTracerProvider creates the spans with the provider, rather than the
+// global provider (see otel.GetTracerProvider).
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(o *options) {
+		o.provider = provider
+	}
+}
+
+// WithTarget records the type of the executor (such as "ssh" or "docker") and
+// the target it executes on (such as a host or container) on execution spans.
+func WithTarget(targetType, target string) Option {
+	return func(o *options) {
+		o.targetType = targetType
+		o.target = target
+	}
+}
+
+// WithTraceParent injects the execution span into the env of the script, as
+// the TRACEPARENT (and TRACESTATE) env vars of the W3C trace context, so that
+// a script can continue the trace, such as with an OTel instrumented CLI.
+func WithTraceParent() Option {
+	return func(o *options) {
+		o.traceParent = true
+	}
+}
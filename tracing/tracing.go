@@ -0,0 +1,155 @@
+// Package tracing instruments the compilation and execution of scripts with
+// OpenTelemetry spans, continuing the trace of the cmd's context.
+package tracing
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/neaas/nescript"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName names the tracer of the spans.
+const instrumentationName = "github.com/neaas/nescript/tracing"
+
+// The names of the spans.
+const (
+	SpanCompile = "nescript.compile"
+	SpanExecute = "nescript.execute"
+)
+
+// The attribute keys of the spans.
+const (
+	KeyTemplateSize   = attribute.Key("nescript.template.size")
+	KeyTemplateFields = attribute.Key("nescript.template.fields")
+	KeyTargetType     = attribute.Key("nescript.target.type")
+	KeyTarget         = attribute.Key("nescript.target")
+	KeyInterpreter    = attribute.Key("nescript.interpreter")
+	KeyExitCode       = attribute.Key("nescript.exit_code")
+	KeySignal         = attribute.Key("nescript.signal")
+	KeyTruncated      = attribute.Key("nescript.output.truncated")
+	KeyErrorClass     = attribute.Key("nescript.error.class")
+)
+
+// fail records the error on the span, setting its status.
+func fail(span trace.Span, err error) {
+	span.SetAttributes(KeyErrorClass.String(nescript.ErrorClass(err)))
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// Compile compiles the script (see nescript.Script.Compile) within a span, a
+// child of any in the context, recording the size of the template and the
+// number of its fields.
+func Compile(ctx context.Context, script nescript.Script, opts ...Option) (nescript.Script, error) {
+	o := newOptions(opts)
+	_, span := o.tracer().Start(ctx, SpanCompile, trace.WithAttributes(
+		KeyTemplateSize.Int(len(script.Raw())),
+		KeyTemplateFields.Int(len(script.Data())),
+	))
+	defer span.End()
+	compiled, err := script.Compile()
+	if err != nil {
+		fail(span, err)
+	}
+	return compiled, err
+}
+
+// Executor wraps the executor so that each execution is within a span, a
+// child of any in the cmd's context, which ends once the result is collected
+// (or the process is closed). The span records the interpreter and target of
+// the script (see WithTarget), along with its exit code and whether its output
+// was truncated. A script exiting unsuccessfully sets the span's status to an
+// error of nescript.ErrorClassExit, distinct from the class of an error
+// executing it (see nescript.ErrorClass).
+func Executor(executor nescript.ExecFunc, opts ...Option) nescript.ExecFunc {
+	o := newOptions(opts)
+	tracer := o.tracer()
+	return func(c nescript.Cmd) (nescript.Process, error) {
+		ctx, span := tracer.Start(c.Context(), SpanExecute,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(o.attributes(c)...),
+		)
+		c = c.WithContext(ctx)
+		if o.traceParent {
+			c = c.WithEnv(traceEnv(ctx)...)
+		}
+		process, err := executor(c)
+		if err != nil {
+			fail(span, err)
+			span.End()
+			return nil, err
+		}
+		traced := &tracedProcess{span: span}
+		return nescript.WrapProcess(process, nescript.ProcessHooks{Result: traced.result, Close: traced.close}), nil
+	}
+}
+
+// attributes returns the attributes of the execution span of the cmd.
+func (o *options) attributes(c nescript.Cmd) []attribute.KeyValue {
+	interpreter := c.Raw()[0]
+	if subcommand, _, _, ok := c.Script(); ok {
+		interpreter = strings.Join(subcommand, " ")
+	}
+	attributes := []attribute.KeyValue{KeyInterpreter.String(interpreter)}
+	if o.targetType != "" {
+		attributes = append(attributes, KeyTargetType.String(o.targetType))
+	}
+	if o.target != "" {
+		attributes = append(attributes, KeyTarget.String(o.target))
+	}
+	return attributes
+}
+
+// traceEnv returns the env vars of the W3C trace context of the span in the
+// context.
+func traceEnv(ctx context.Context) []string {
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	env := make([]string, 0, len(carrier))
+	for _, key := range carrier.Keys() {
+		env = append(env, strings.ToUpper(key)+"="+carrier.Get(key))
+	}
+	return env
+}
+
+// tracedProcess ends the span of the process once its result is collected.
+type tracedProcess struct {
+	span trace.Span
+	once sync.Once
+}
+
+func (p *tracedProcess) result(result *nescript.Result, err error) (*nescript.Result, error) {
+	p.once.Do(func() {
+		defer p.span.End()
+		if err != nil {
+			fail(p.span, err)
+			return
+		}
+		p.span.SetAttributes(
+			KeyExitCode.Int(result.ExitCode),
+			KeyTruncated.Bool(result.StdOutTruncated || result.StdErrTruncated),
+		)
+		if result.Signaled {
+			p.span.SetAttributes(KeySignal.String(result.Signal))
+		}
+		if err := result.Err(); err != nil {
+			p.span.SetAttributes(KeyErrorClass.String(nescript.ErrorClassExit))
+			p.span.SetStatus(codes.Error, err.Error())
+			return
+		}
+		p.span.SetStatus(codes.Ok, "")
+	})
+	return result, err
+}
+
+func (p *tracedProcess) close() {
+	p.once.Do(func() {
+		p.span.End()
+	})
+}
@@ -0,0 +1,140 @@
+package nescript
+
+import (
+	"slices"
+	"text/template/parse"
+)
+
+// FieldTemplate is a Template that can report the fields of data it uses, for
+// validating the data of a script before it is compiled (see Script.Validate).
+type FieldTemplate interface {
+	Template
+
+	// Fields returns the names of the fields of data the template uses.
+	Fields() []string
+}
+
+// Validation is the fields of data a script uses (see Script.Validate).
+type Validation struct {
+	// Fields are the sorted names of the fields used by the script's template,
+	// along with its required fields (see WithRequiredFields).
+	Fields []string `json:"fields"`
+
+	// Missing are the sorted names of the fields without data.
+	Missing []string `json:"missing,omitempty"`
+}
+
+// Valid reports whether each field used by the script has data.
+func (v Validation) Valid() bool {
+	return len(v.Missing) == 0
+}
+
+// Validate parses the script's template, returning the fields of data it uses
+// and which are missing data, so that data given by a user can be validated
+// before anything is executed, such as on a remote target. A field is used
+// where the template refers to it from the root of the data, as {{.Name}} or
+// {{$.Name}}, including within templates it executes with the root data,
+// while the fields within a range or with (where the data is the element or
+// value) are not. The script's required fields are always used. Where another
+// templater is set (see WithTemplater), only the fields of its templates that
+// are a FieldTemplate are reported. A *CompileError is returned if the
+// template could not be parsed.
+func (s Script) Validate() (Validation, error) {
+	var fields []string
+	if s.templating.hasActions(s.raw) {
+		tmpl, err := s.templating.parse(s.raw)
+		if err != nil {
+			return Validation{}, &CompileError{Arg: -1, Parse: true, Err: err}
+		}
+		if fieldTemplate, ok := tmpl.(FieldTemplate); ok {
+			fields = fieldTemplate.Fields()
+		}
+	}
+	fields = append(slices.Clip(fields), s.required...)
+	slices.Sort(fields)
+	validation := Validation{Fields: slices.Compact(fields)}
+	for _, key := range validation.Fields {
+		if _, ok := s.field(key); !ok {
+			validation.Missing = append(validation.Missing, key)
+		}
+	}
+	return validation, nil
+}
+
+// Fields returns the sorted names of the fields of the root data used by the
+// template (see Script.Validate).
+func (t goTemplate) Fields() []string {
+	found := make(map[string]bool)
+	executed := make(map[string]bool)
+	var walk func(node parse.Node, root bool)
+	walk = func(node parse.Node, root bool) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, child := range n.Nodes {
+				walk(child, root)
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe, root)
+		case *parse.PipeNode:
+			if n == nil {
+				return
+			}
+			for _, cmd := range n.Cmds {
+				walk(cmd, root)
+			}
+		case *parse.CommandNode:
+			for _, arg := range n.Args {
+				walk(arg, root)
+			}
+		case *parse.ChainNode:
+			walk(n.Node, root)
+		case *parse.FieldNode:
+			if root {
+				found[n.Ident[0]] = true
+			}
+		case *parse.VariableNode:
+			if n.Ident[0] == "$" && len(n.Ident) > 1 {
+				found[n.Ident[1]] = true
+			}
+		case *parse.IfNode:
+			walk(n.Pipe, root)
+			walk(n.List, root)
+			walk(n.ElseList, root)
+		case *parse.RangeNode:
+			walk(n.Pipe, root)
+			walk(n.List, false)
+			walk(n.ElseList, root)
+		case *parse.WithNode:
+			walk(n.Pipe, root)
+			walk(n.List, false)
+			walk(n.ElseList, root)
+		case *parse.TemplateNode:
+			walk(n.Pipe, root)
+			if root && isDot(n.Pipe) && !executed[n.Name] {
+				executed[n.Name] = true
+				if tmpl := t.Lookup(n.Name); tmpl != nil && tmpl.Tree != nil {
+					walk(tmpl.Tree.Root, true)
+				}
+			}
+		}
+	}
+	walk(t.Tree.Root, true)
+	fields := make([]string, 0, len(found))
+	for field := range found {
+		fields = append(fields, field)
+	}
+	slices.Sort(fields)
+	return fields
+}
+
+// isDot reports whether the pipeline is only the dot, as in {{template "x" .}}.
+func isDot(pipe *parse.PipeNode) bool {
+	if pipe == nil || len(pipe.Cmds) != 1 || len(pipe.Cmds[0].Args) != 1 {
+		return false
+	}
+	_, ok := pipe.Cmds[0].Args[0].(*parse.DotNode)
+	return ok
+}
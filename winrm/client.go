@@ -0,0 +1,226 @@
+package winrm
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	ntlmssp "github.com/Azure/go-ntlmssp"
+)
+
+const (
+	resourceURI = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/cmd"
+
+	actionCreate  = "http://schemas.xmlsoap.org/ws/2004/09/transfer/Create"
+	actionDelete  = "http://schemas.xmlsoap.org/ws/2004/09/transfer/Delete"
+	actionCommand = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Command"
+	actionReceive = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Receive"
+	actionSend    = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Send"
+	actionSignal  = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Signal"
+
+	signalTerminate = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/signal/terminate"
+	signalCtrlC     = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/signal/ctrl_c"
+
+	commandStateDone = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/CommandState/Done"
+
+	// maxEnvelopeSize is the largest response the endpoint may send, which is
+	// the WinRM default (MaxEnvelopeSizekb of 150).
+	maxEnvelopeSize = 153600
+)
+
+// client sends WS-Management requests to the WinRM endpoint.
+type client struct {
+	url              string
+	user             string
+	password         string
+	http             *http.Client
+	operationTimeout time.Duration
+}
+
+// newClient creates the client for the endpoint URL. With NTLM, the basic
+// credentials are converted into an NTLM negotiation by the transport.
+func (o *options) newClient(url, user, password string) (*client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if o.https {
+		tlsConfig, err := o.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+	var roundTripper http.RoundTripper = transport
+	if o.auth == AuthNTLM {
+		roundTripper = ntlmssp.Negotiator{RoundTripper: transport}
+	}
+	return &client{
+		url:      url,
+		user:     user,
+		password: password,
+		http: &http.Client{
+			Transport: roundTripper,
+			// a receive waits up to the operation timeout for output.
+			Timeout: o.operationTimeout + 30*time.Second,
+		},
+		operationTimeout: o.operationTimeout,
+	}, nil
+}
+
+// request describes a WS-Management request, made within the shell (if any).
+type request struct {
+	action  string
+	shellID string
+	options map[string]string
+	body    string
+}
+
+// response is the part of a WS-Management response used by the executor.
+type response struct {
+	Body struct {
+		Fault *struct {
+			Code struct {
+				Value   string `xml:"Value"`
+				Subcode struct {
+					Value string `xml:"Value"`
+				} `xml:"Subcode"`
+			} `xml:"Code"`
+			Reason struct {
+				Text string `xml:"Text"`
+			} `xml:"Reason"`
+			Detail struct {
+				WSManFault struct {
+					Code    string `xml:"Code,attr"`
+					Message string `xml:"Message"`
+				} `xml:"WSManFault"`
+			} `xml:"Detail"`
+		} `xml:"Fault"`
+		Shell struct {
+			ShellID string `xml:"ShellId"`
+		} `xml:"Shell"`
+		ResourceCreated struct {
+			Selectors []selector `xml:"ReferenceParameters>SelectorSet>Selector"`
+		} `xml:"ResourceCreated"`
+		CommandResponse struct {
+			CommandID string `xml:"CommandId"`
+		} `xml:"CommandResponse"`
+		ReceiveResponse struct {
+			Streams []struct {
+				Name string `xml:"Name,attr"`
+				Data string `xml:",chardata"`
+			} `xml:"Stream"`
+			CommandState *struct {
+				State    string `xml:"State,attr"`
+				ExitCode *int64 `xml:"ExitCode"`
+			} `xml:"CommandState"`
+		} `xml:"ReceiveResponse"`
+	} `xml:"Body"`
+}
+
+type selector struct {
+	Name  string `xml:"Name,attr"`
+	Value string `xml:",chardata"`
+}
+
+// do sends the request, returning the parsed response. A fault returned by the
+// endpoint is returned as a *Fault.
+func (c *client) do(ctx context.Context, req request) (*response, error) {
+	envelope, err := c.envelope(req)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, strings.NewReader(envelope))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrConnection, err)
+	}
+	httpReq.Header.Set("Content-Type", "application/soap+xml;charset=UTF-8")
+	httpReq.SetBasicAuth(c.user, c.password)
+	httpResp, err := c.http.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrConnection, err)
+	}
+	defer httpResp.Body.Close()
+	body, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to read response: %w", ErrConnection, err)
+	}
+	if httpResp.StatusCode == http.StatusUnauthorized {
+		return nil, fmt.Errorf("%w: as '%s'", ErrUnauthorized, c.user)
+	}
+	var resp response
+	if err := xml.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("%w: unexpected response (status %d): %w", ErrExecution, httpResp.StatusCode, err)
+	}
+	if fault := resp.Body.Fault; fault != nil {
+		return nil, &Fault{
+			Code:      fault.Code.Subcode.Value,
+			Reason:    strings.TrimSpace(fault.Reason.Text),
+			WSManCode: fault.Detail.WSManFault.Code,
+			Message:   strings.TrimSpace(fault.Detail.WSManFault.Message),
+		}
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: unexpected response status %d", ErrExecution, httpResp.StatusCode)
+	}
+	return &resp, nil
+}
+
+// envelope builds the SOAP envelope of the request.
+func (c *client) envelope(req request) (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("failed to generate message id: %w", err)
+	}
+	messageID := hex.EncodeToString(id)
+	messageID = fmt.Sprintf("%s-%s-%s-%s-%s", messageID[:8], messageID[8:12], messageID[12:16], messageID[16:20], messageID[20:])
+	var b strings.Builder
+	b.WriteString(`<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:w="http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd" xmlns:p="http://schemas.microsoft.com/wbem/wsman/1/wsman.xsd" xmlns:rsp="http://schemas.microsoft.com/wbem/wsman/1/windows/shell">`)
+	b.WriteString(`<s:Header>`)
+	fmt.Fprintf(&b, `<a:To>%s</a:To>`, escape(c.url))
+	b.WriteString(`<a:ReplyTo><a:Address s:mustUnderstand="true">http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous</a:Address></a:ReplyTo>`)
+	fmt.Fprintf(&b, `<w:MaxEnvelopeSize s:mustUnderstand="true">%d</w:MaxEnvelopeSize>`, maxEnvelopeSize)
+	fmt.Fprintf(&b, `<a:MessageID>uuid:%s</a:MessageID>`, messageID)
+	b.WriteString(`<w:Locale xml:lang="en-US" s:mustUnderstand="false"/>`)
+	fmt.Fprintf(&b, `<w:OperationTimeout>%s</w:OperationTimeout>`, duration(c.operationTimeout))
+	fmt.Fprintf(&b, `<w:ResourceURI s:mustUnderstand="true">%s</w:ResourceURI>`, resourceURI)
+	fmt.Fprintf(&b, `<a:Action s:mustUnderstand="true">%s</a:Action>`, req.action)
+	if req.shellID != "" {
+		fmt.Fprintf(&b, `<w:SelectorSet><w:Selector Name="ShellId">%s</w:Selector></w:SelectorSet>`, escape(req.shellID))
+	}
+	if len(req.options) > 0 {
+		b.WriteString(`<w:OptionSet>`)
+		for name, value := range req.options {
+			fmt.Fprintf(&b, `<w:Option Name="%s">%s</w:Option>`, escape(name), escape(value))
+		}
+		b.WriteString(`</w:OptionSet>`)
+	}
+	b.WriteString(`</s:Header>`)
+	fmt.Fprintf(&b, `<s:Body>%s</s:Body>`, req.body)
+	b.WriteString(`</s:Envelope>`)
+	return b.String(), nil
+}
+
+// escape escapes the text for use in the XML envelope.
+func escape(text string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(text))
+	return b.String()
+}
+
+// duration formats the duration as an xs:duration, in seconds.
+func duration(d time.Duration) string {
+	return fmt.Sprintf("PT%.3fS", d.Seconds())
+}
+
+// isFault reports whether the error is a fault returned by the endpoint.
+func isFault(err error) (*Fault, bool) {
+	var fault *Fault
+	ok := errors.As(err, &fault)
+	return fault, ok
+}
@@ -0,0 +1,60 @@
+package winrm
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/neaas/nescript"
+)
+
+var (
+	// ErrConnection is returned (wrapped) when the WinRM endpoint could not be
+	// reached, as opposed to a failure once connected.
+	ErrConnection = nescript.NewError(nescript.ErrConnection, "failed to connect to winrm endpoint")
+
+	// ErrUnauthorized is returned (wrapped) when the WinRM endpoint rejects the
+	// credentials, or the authentication method is not enabled on the target.
+	ErrUnauthorized = errors.New("winrm authentication failed")
+
+	// ErrExecution is returned (wrapped) when the script could not be started,
+	// or its output could not be received.
+	ErrExecution = errors.New("winrm execution failed")
+
+	// ErrTimeout is returned (wrapped) when the script did not complete within
+	// the timeout given by WithTimeout. The command is terminated and its shell
+	// deleted.
+	ErrTimeout = nescript.NewError(nescript.ErrTimeout, "winrm execution timed out")
+
+	// ErrSignalUnsupported is returned when a signal other than SIGINT,
+	// SIGTERM or SIGKILL is sent to a WinRM process, as WinRM can only send a
+	// ctrl-c or terminate the command.
+	ErrSignalUnsupported = errors.New("signal not supported by winrm")
+)
+
+// Fault is a WS-Management fault returned by the WinRM endpoint, such as the
+// shell quota being exceeded. Code is the fault subcode (such as
+// w:QuotaLimit), and WSManCode the windows error code, if given.
+type Fault struct {
+	Code      string
+	Reason    string
+	WSManCode string
+	Message   string
+}
+
+func (f *Fault) Error() string {
+	message := f.Reason
+	if message == "" {
+		message = f.Message
+	}
+	if f.WSManCode != "" {
+		return fmt.Sprintf("winrm fault %s (%s): %s", f.Code, f.WSManCode, message)
+	}
+	return fmt.Sprintf("winrm fault %s: %s", f.Code, message)
+}
+
+// timedOut reports whether the fault is the endpoint ending a receive that
+// had no output within the operation timeout, after which the receive is
+// simply retried.
+func (f *Fault) timedOut() bool {
+	return f.Code == "w:TimedOut" || f.WSManCode == "2150858793"
+}
@@ -0,0 +1,117 @@
+package winrm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/neaas/nescript"
+)
+
+// NewExecutor provides an ExecFunc that runs the script/cmd on a windows host
+// through its WinRM endpoint (normally port 5985, or 5986 with WithHTTPS),
+// authenticating as the user with the password (see WithAuth). Each execution
+// creates a remote shell, runs the cmd in PowerShell (see WithShell) passed as
+// an -EncodedCommand, and deletes the shell once the script completes, or the
+// cmd's context is done. Scripts too long for a single command are transferred
+// in chunks first (see WithCommandLimit). This ExecFunc does not require that
+// the cmd/script be converted to a string, so is Formatter agnostic.
+func NewExecutor(host string, port int, user, password string, opts ...Option) nescript.ExecFunc {
+	o := newOptions(opts)
+	scheme := "http"
+	if o.https {
+		scheme = "https"
+	}
+	url := fmt.Sprintf("%s://%s/wsman", scheme, net.JoinHostPort(host, strconv.Itoa(port)))
+	client, err := o.newClient(url, user, password)
+	return func(c nescript.Cmd) (nescript.Process, error) {
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrConnection, err)
+		}
+		return o.start(c, client)
+	}
+}
+
+func (o *options) start(c nescript.Cmd, client *client) (nescript.Process, error) {
+	parent := c.Context()
+	ctx, cancel := parent, context.CancelFunc(func() {})
+	if o.execTimeout > 0 {
+		ctx, cancel = context.WithTimeout(parent, o.execTimeout)
+	}
+	var idleTimeout string
+	if o.shellTimeout > 0 {
+		idleTimeout = duration(o.shellTimeout)
+	}
+	shellID, err := client.createShell(ctx, idleTimeout)
+	if err != nil {
+		cancel()
+		return nil, o.contextErr(parent, ctx, fmt.Errorf("failed to create winrm shell: %w", err))
+	}
+	process := &WinRMProcess{
+		client:  client,
+		shellID: shellID,
+		parent:  parent,
+		ctx:     ctx,
+		cancel:  cancel,
+		timeout: o.execTimeout > 0,
+		done:    make(chan struct{}),
+	}
+	commandLine, chunks, err := o.commandLine(ctx, client, shellID, powershellScript(c))
+	if err == nil {
+		process.chunks = chunks
+		process.commandID, err = client.command(ctx, shellID, commandLine)
+		if err != nil {
+			err = fmt.Errorf("%w: failed to start script: %w", ErrExecution, err)
+		}
+	}
+	if err != nil {
+		process.deleteShell()
+		cancel()
+		return nil, o.contextErr(parent, ctx, err)
+	}
+	process.tee = nescript.NewTee(c, &process.stdout, &process.stderr)
+	process.started = time.Now()
+	go process.watch()
+	return process, nil
+}
+
+// commandLine returns the command running the script, transferring it to the
+// target in chunks first if it is too long to be run directly. The number of
+// chunks transferred is also returned.
+func (o *options) commandLine(ctx context.Context, client *client, shellID, script string) (string, int, error) {
+	command := encodedCommand(o.shell, script)
+	if len(command) <= o.cmdLimit {
+		return command, 0, nil
+	}
+	t, err := newTransfer(o.shell, script, o.cmdLimit)
+	if err != nil {
+		return "", 0, fmt.Errorf("%w: %w", ErrExecution, err)
+	}
+	for i, chunk := range t.chunks {
+		out, err := client.run(ctx, shellID, chunk)
+		if err == nil && out.exitCode != 0 {
+			err = fmt.Errorf("exit code %d: %s", out.exitCode, strings.TrimSpace(string(out.stderr)))
+		}
+		if err != nil {
+			cleanupCtx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+			client.run(cleanupCtx, shellID, t.remove())
+			cancel()
+			return "", 0, fmt.Errorf("%w: failed to transfer script chunk %d of %d: %w", ErrExecution, i+1, len(t.chunks), err)
+		}
+	}
+	return t.loader, len(t.chunks), nil
+}
+
+// contextErr returns ErrTimeout if the execution timeout (rather than the
+// cmd's context) ended the execution, otherwise the error given (see
+// nescript.ContextError).
+func (o *options) contextErr(parent, ctx context.Context, err error) error {
+	if o.execTimeout > 0 && errors.Is(ctx.Err(), context.DeadlineExceeded) && parent.Err() == nil {
+		return fmt.Errorf("%w: after %s: %w", ErrTimeout, o.execTimeout, err)
+	}
+	return nescript.ContextError(err)
+}
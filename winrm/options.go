@@ -0,0 +1,183 @@
+package winrm
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"time"
+)
+
+const (
+	// defaultOperationTimeout is how long the endpoint waits for output before
+	// ending a receive, after which it is retried.
+	defaultOperationTimeout = 60 * time.Second
+
+	// defaultCommandLimit is the longest command line sent to the endpoint,
+	// which is kept under the cmd.exe limit of 8191 characters.
+	defaultCommandLimit = 8000
+
+	// cleanupTimeout bounds the requests terminating the command and deleting
+	// the shell, as they are sent after the cmd context is done.
+	cleanupTimeout = 30 * time.Second
+)
+
+// Auth is the method used to authenticate with the WinRM endpoint.
+type Auth int
+
+const (
+	// AuthBasic authenticates with HTTP basic auth, which is only allowed by
+	// WinRM for local accounts, and must be enabled on the target
+	// (winrm/config/service/auth Basic). This is the default.
+	AuthBasic Auth = iota
+
+	// AuthNTLM authenticates with NTLM, which is accepted for domain and local
+	// accounts by WinRM's default configuration. The user may be given as
+	// DOMAIN\user.
+	AuthNTLM
+)
+
+// Shell is the PowerShell the script is run with on the target.
+type Shell int
+
+const (
+	// ShellPowerShell runs scripts with Windows PowerShell (powershell.exe).
+	// This is the default.
+	ShellPowerShell Shell = iota
+
+	// ShellPwsh runs scripts with PowerShell (core), as pwsh.exe.
+	ShellPwsh
+)
+
+func (s Shell) executable() string {
+	if s == ShellPwsh {
+		return "pwsh"
+	}
+	return "powershell"
+}
+
+// Option configures the WinRM executor.
+type Option func(*options)
+
+type options struct {
+	https      bool
+	skipVerify bool
+	ca         []byte
+	auth       Auth
+	shell      Shell
+	cmdLimit   int
+
+	operationTimeout time.Duration
+	shellTimeout     time.Duration
+	execTimeout      time.Duration
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		cmdLimit:         defaultCommandLimit,
+		operationTimeout: defaultOperationTimeout,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithHTTPS connects to the endpoint with HTTPS (normally port 5986), rather
+// than HTTP. The endpoint's certificate is verified against the system roots,
+// or those given with WithCACert.
+func WithHTTPS() Option {
+	return func(o *options) {
+		o.https = true
+	}
+}
+
+// WithInsecureSkipVerify connects with HTTPS without verifying the endpoint's
+// certificate, such as the self-signed certificate WinRM listeners are
+// commonly configured with. This should only be used on trusted networks.
+func WithInsecureSkipVerify() Option {
+	return func(o *options) {
+		o.https = true
+		o.skipVerify = true
+	}
+}
+
+// WithCACert connects with HTTPS, verifying the endpoint's certificate against
+// the given PEM encoded CA certificate(s).
+func WithCACert(pem []byte) Option {
+	return func(o *options) {
+		o.https = true
+		o.ca = pem
+	}
+}
+
+// WithAuth sets the method used to authenticate with the endpoint, which is
+// AuthBasic unless given. Note that WinRM only accepts basic auth, and NTLM
+// without message encryption, over HTTP when the service allows unencrypted
+// traffic (winrm/config/service AllowUnencrypted), so HTTPS should be used
+// otherwise.
+func WithAuth(auth Auth) Option {
+	return func(o *options) {
+		o.auth = auth
+	}
+}
+
+// WithShell sets the PowerShell scripts are run with, which is ShellPowerShell
+// unless given.
+func WithShell(shell Shell) Option {
+	return func(o *options) {
+		o.shell = shell
+	}
+}
+
+// WithCommandLimit sets the longest command line sent to the endpoint, which
+// is 8000 characters unless given. Scripts whose encoded command would be
+// longer are transferred to the target in chunks, then run from there.
+func WithCommandLimit(limit int) Option {
+	return func(o *options) {
+		o.cmdLimit = limit
+	}
+}
+
+// WithOperationTimeout sets how long the endpoint waits for output before
+// ending each receive (which is then retried), 60 seconds unless given. This
+// must be under the endpoint's MaxTimeoutms.
+func WithOperationTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.operationTimeout = timeout
+	}
+}
+
+// WithShellTimeout sets the idle timeout of the remote shell, after which the
+// endpoint deletes it if the executor has stopped receiving from it (such as
+// when the client was lost). The endpoint's default (IdleTimeout) is used
+// unless given.
+func WithShellTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.shellTimeout = timeout
+	}
+}
+
+// WithTimeout limits how long the script may run for. If the script has not
+// completed within the timeout, the command is terminated and its shell
+// deleted, and Result returns ErrTimeout.
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.execTimeout = timeout
+	}
+}
+
+// tlsConfig builds the client TLS config for HTTPS endpoints.
+func (o *options) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: o.skipVerify,
+	}
+	if o.ca != nil {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(o.ca) {
+			return nil, fmt.Errorf("failed to parse tls ca certificate")
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
@@ -0,0 +1,164 @@
+package winrm
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/internal/shellquote"
+)
+
+// powershellScript builds the PowerShell script that sets the cmd's env with
+// $env: assignments, then runs the cmd, followed by any trailing arguments.
+// The script is run in a script block so that it can read its arguments from
+// $args. The exit code is that given to `exit` by the script, otherwise that
+// of the last native command run if it failed ($LASTEXITCODE), otherwise 1 if
+// the last statement failed, else 0. Progress output is disabled, as it would
+// otherwise be serialized onto stderr, and the console's output encoding is set
+// to UTF-8 (matching the shell's code page, see WINRS_CODEPAGE).
+func powershellScript(c nescript.Cmd) string {
+	var script strings.Builder
+	script.WriteString("$ProgressPreference = 'SilentlyContinue'\n")
+	script.WriteString("$OutputEncoding = New-Object System.Text.UTF8Encoding $false\n")
+	script.WriteString("try { [Console]::OutputEncoding = $OutputEncoding } catch {}\n")
+	for _, e := range c.Env() {
+		if key, value, ok := strings.Cut(e, "="); ok {
+			fmt.Fprintf(&script, "${env:%s} = %s\n", shellquote.PowerShellBraced(key), shellquote.PowerShell(value))
+		}
+	}
+	script.WriteString("$global:LASTEXITCODE = 0\n")
+	if _, body, trailing, ok := c.Script(); ok {
+		script.WriteString("& {\n")
+		script.WriteString(strings.ReplaceAll(body, "\r\n", "\n"))
+		script.WriteString("\n}")
+		for _, arg := range trailing {
+			script.WriteString(" " + shellquote.PowerShell(arg))
+		}
+	} else {
+		script.WriteString("&")
+		for _, arg := range c.Raw() {
+			script.WriteString(" " + shellquote.PowerShell(arg))
+		}
+	}
+	script.WriteString("\n$nescriptSucceeded = $?\n")
+	script.WriteString("if ($global:LASTEXITCODE -ne 0) { exit $global:LASTEXITCODE }\n")
+	script.WriteString("if (-not $nescriptSucceeded) { exit 1 }\n")
+	script.WriteString("exit 0\n")
+	return script.String()
+}
+
+// encodedCommand builds the command running the PowerShell script passed as a
+// -EncodedCommand (base64 encoded UTF-16LE), which needs no quoting for cmd.
+func encodedCommand(shell Shell, script string) string {
+	return fmt.Sprintf("%s -NoProfile -NonInteractive -EncodedCommand %s", shell.executable(), shellquote.EncodedCommand(script))
+}
+
+// transfer is a script too long to be run as a single command, which is
+// appended to a file in the target's %TEMP% in chunks (as base64), then run
+// by a loader script that reads and removes the file.
+type transfer struct {
+	file   string
+	chunks []string
+	loader string
+}
+
+// newTransfer splits the script into chunk commands no longer than the limit.
+func newTransfer(shell Shell, script string, limit int) (*transfer, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("failed to generate script name: %w", err)
+	}
+	file := "nescript-" + hex.EncodeToString(id) + ".b64"
+	// base64 (and the redirection before the echo) needs no quoting for cmd,
+	// and a chunk ending in a digit can not be mistaken for a handle.
+	prefix := fmt.Sprintf(`>>"%%TEMP%%\%s" echo `, file)
+	size := limit - len(prefix)
+	if size < 1 {
+		return nil, fmt.Errorf("command limit of %d is too short to transfer the script", limit)
+	}
+	encoded := base64.StdEncoding.EncodeToString([]byte(script))
+	t := &transfer{file: file}
+	for len(encoded) > 0 {
+		n := min(size, len(encoded))
+		t.chunks = append(t.chunks, prefix+encoded[:n])
+		encoded = encoded[n:]
+	}
+	t.loader = encodedCommand(shell, fmt.Sprintf(`$ProgressPreference = 'SilentlyContinue'
+$nescriptFile = Join-Path $env:TEMP %s
+$nescriptScript = [Text.Encoding]::UTF8.GetString([Convert]::FromBase64String(((Get-Content -Raw $nescriptFile) -replace '\s', '')))
+Remove-Item -Force $nescriptFile
+& ([scriptblock]::Create($nescriptScript))
+exit $global:LASTEXITCODE
+`, shellquote.PowerShell(file)))
+	return t, nil
+}
+
+// remove is the command removing the transferred file, should the loader not
+// have been run.
+func (t *transfer) remove() string {
+	return fmt.Sprintf(`del /f /q "%%TEMP%%\%s"`, t.file)
+}
+
+// clixmlEscape matches the _xHHHH_ escapes used by PowerShell's CLIXML for
+// characters such as newlines.
+var clixmlEscape = regexp.MustCompile(`_x([0-9A-Fa-f]{4})_`)
+
+// cleanCLIXML converts stderr serialized by PowerShell as CLIXML (as it is
+// when run with -EncodedCommand and its output redirected) into the text of
+// its error records, discarding other records such as progress. Stderr that
+// is not CLIXML, or can not be parsed, is returned as-is.
+func cleanCLIXML(stderr string) string {
+	const header = "#< CLIXML"
+	if !strings.HasPrefix(stderr, header) {
+		return stderr
+	}
+	decoder := xml.NewDecoder(strings.NewReader(strings.TrimPrefix(stderr, header)))
+	var cleaned strings.Builder
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			if cleaned.Len() == 0 && !errors.Is(err, io.EOF) {
+				return stderr
+			}
+			break
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "S" || !hasAttr(start, "S", "Error") {
+			continue
+		}
+		var text string
+		if err := decoder.DecodeElement(&text, &start); err != nil {
+			return stderr
+		}
+		cleaned.WriteString(clixmlEscape.ReplaceAllStringFunc(text, func(escape string) string {
+			r, _ := strconv.ParseUint(escape[2:6], 16, 32)
+			return string(rune(r))
+		}))
+	}
+	return cleaned.String()
+}
+
+func hasAttr(start xml.StartElement, name, value string) bool {
+	for _, attr := range start.Attr {
+		if attr.Name.Local == name && attr.Value == value {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeWindowsOutput decodes UTF-16 output (such as that of a program
+// writing Unicode to a redirected stream) by its byte order mark, and converts
+// windows (CRLF) line endings into newlines.
+func normalizeWindowsOutput(output string) string {
+	output, _ = nescript.DecodeOutput(output, nescript.EncodingAuto)
+	return strings.ReplaceAll(output, "\r\n", "\n")
+}
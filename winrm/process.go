@@ -0,0 +1,196 @@
+package winrm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/neaas/nescript"
+)
+
+const (
+	// MetadataShellID is the result metadata key holding the ID of the remote
+	// shell the script was run in.
+	MetadataShellID = "winrm.shell"
+
+	// MetadataChunks is the result metadata key holding the number of chunks
+	// the script was transferred in, set only when it was too long to be run
+	// as a single command.
+	MetadataChunks = "winrm.chunks"
+)
+
+// WinRMProcess represents a single instance of the script running or completed
+// in a remote shell created by the WinRM executor.
+type WinRMProcess struct {
+	client     *client
+	shellID    string
+	commandID  string
+	chunks     int
+	parent     context.Context
+	ctx        context.Context
+	cancel     context.CancelFunc
+	timeout    bool
+	done       chan struct{}
+	deleteOnce sync.Once
+	started    time.Time
+	tee        *nescript.Tee
+
+	// set by watch, before done is closed.
+	stdout   bytes.Buffer
+	stderr   bytes.Buffer
+	exitCode int
+	ended    time.Time
+	err      error
+}
+
+// ShellID returns the ID of the remote shell the script is running in.
+func (p *WinRMProcess) ShellID() string {
+	return p.shellID
+}
+
+// watch receives the output of the command until it completes, then deletes
+// the shell. If the context is done first, the command is terminated.
+func (p *WinRMProcess) watch() {
+	defer close(p.done)
+	defer p.tee.Finish(nil)
+	defer p.cancel()
+	defer p.deleteShell()
+	for {
+		out, err := p.client.receive(p.ctx, p.shellID, p.commandID)
+		if err != nil {
+			if p.ctx.Err() != nil {
+				p.terminate()
+				err = p.ctxErr()
+			}
+			p.err = err
+			return
+		}
+		p.tee.Stdout().Write(out.stdout)
+		p.tee.Stderr().Write(out.stderr)
+		if out.done {
+			p.exitCode = out.exitCode
+			p.ended = time.Now()
+			return
+		}
+	}
+}
+
+// ctxErr is the error the execution ended with once its context is done.
+func (p *WinRMProcess) ctxErr() error {
+	if p.timeout && errors.Is(p.ctx.Err(), context.DeadlineExceeded) && p.parent.Err() == nil {
+		return fmt.Errorf("%w: command terminated", ErrTimeout)
+	}
+	return fmt.Errorf("%w: command terminated: %w", ErrExecution, nescript.ContextError(p.ctx.Err()))
+}
+
+// terminate sends the terminate signal to the command.
+func (p *WinRMProcess) terminate() error {
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+	return p.client.signal(ctx, p.shellID, p.commandID, signalTerminate)
+}
+
+// deleteShell deletes the remote shell, freeing its resources on the target.
+func (p *WinRMProcess) deleteShell() {
+	p.deleteOnce.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+		defer cancel()
+		p.client.deleteShell(ctx, p.shellID)
+	})
+}
+
+// Kill terminates the command, doing nothing once it has completed.
+func (p *WinRMProcess) Kill() error {
+	select {
+	case <-p.done:
+		return nil
+	default:
+	}
+	if err := p.terminate(); err != nil {
+		return fmt.Errorf("failed to terminate winrm command: %w", err)
+	}
+	return nil
+}
+
+// Signal sends SIGINT as a ctrl-c to the command, and terminates it for
+// SIGTERM and SIGKILL. Other signals return ErrSignalUnsupported.
+func (p *WinRMProcess) Signal(s os.Signal) error {
+	switch s {
+	case os.Interrupt, syscall.SIGINT:
+		ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+		defer cancel()
+		if err := p.client.signal(ctx, p.shellID, p.commandID, signalCtrlC); err != nil {
+			return fmt.Errorf("failed to send ctrl-c to winrm command: %w", err)
+		}
+		return nil
+	case syscall.SIGTERM, os.Kill, syscall.SIGKILL:
+		return p.Kill()
+	}
+	return fmt.Errorf("%w: %s", ErrSignalUnsupported, s)
+}
+
+func (p *WinRMProcess) Write(input string) error {
+	if err := p.client.send(p.ctx, p.shellID, p.commandID, []byte(input)); err != nil {
+		return fmt.Errorf("failed to write to winrm command stdin: %w", err)
+	}
+	return nil
+}
+
+func (p *WinRMProcess) Stdin() io.WriteCloser {
+	return nescript.StdinWriter(p)
+}
+
+func (p *WinRMProcess) Stdout() io.Reader {
+	return p.tee.StdoutStream()
+}
+
+func (p *WinRMProcess) Stderr() io.Reader {
+	return p.tee.StderrStream()
+}
+
+// ID returns the ID of the command in the remote shell.
+func (p *WinRMProcess) ID() string {
+	return p.commandID
+}
+
+func (p *WinRMProcess) String() string {
+	return fmt.Sprintf("winrm command %s in shell %s at %s", p.commandID, p.shellID, p.client.url)
+}
+
+func (p *WinRMProcess) Wait(ctx context.Context) (*nescript.Result, error) {
+	return nescript.Wait(ctx, p)
+}
+
+func (p *WinRMProcess) Result() (*nescript.Result, error) {
+	defer p.Close()
+	<-p.done
+	if p.err != nil {
+		return nil, p.err
+	}
+	result := nescript.Result{
+		StdOut:   normalizeWindowsOutput(p.stdout.String()),
+		StdErr:   normalizeWindowsOutput(cleanCLIXML(p.stderr.String())),
+		ExitCode: p.exitCode,
+	}
+	result.SetTimes(p.started, p.ended)
+	result.SetMetadata(nescript.MetadataExecutor, "winrm")
+	result.SetMetadata(MetadataShellID, p.shellID)
+	if p.chunks > 0 {
+		result.SetMetadata(MetadataChunks, p.chunks)
+	}
+	p.tee.Finish(&result)
+	return &result, nil
+}
+
+// Close terminates the command if it is still running, and deletes the remote
+// shell.
+func (p *WinRMProcess) Close() {
+	p.cancel()
+	<-p.done
+}
@@ -0,0 +1,164 @@
+package winrm
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// output is the output of a command received from its shell.
+type output struct {
+	stdout   []byte
+	stderr   []byte
+	done     bool
+	exitCode int
+}
+
+// createShell creates a remote (cmd) shell, returning its ID. Output is
+// received as UTF-8.
+func (c *client) createShell(ctx context.Context, idleTimeout string) (string, error) {
+	body := `<rsp:Shell><rsp:InputStreams>stdin</rsp:InputStreams><rsp:OutputStreams>stdout stderr</rsp:OutputStreams>`
+	if idleTimeout != "" {
+		body += fmt.Sprintf(`<rsp:IdleTimeOut>%s</rsp:IdleTimeOut>`, idleTimeout)
+	}
+	body += `</rsp:Shell>`
+	resp, err := c.do(ctx, request{
+		action: actionCreate,
+		options: map[string]string{
+			"WINRS_NOPROFILE": "FALSE",
+			"WINRS_CODEPAGE":  "65001",
+		},
+		body: body,
+	})
+	if err != nil {
+		return "", err
+	}
+	if id := resp.Body.Shell.ShellID; id != "" {
+		return id, nil
+	}
+	for _, s := range resp.Body.ResourceCreated.Selectors {
+		if s.Name == "ShellId" && s.Value != "" {
+			return s.Value, nil
+		}
+	}
+	return "", fmt.Errorf("%w: no shell id was returned", ErrExecution)
+}
+
+// command starts the command line in the shell, returning the command's ID.
+func (c *client) command(ctx context.Context, shellID, commandLine string) (string, error) {
+	resp, err := c.do(ctx, request{
+		action:  actionCommand,
+		shellID: shellID,
+		options: map[string]string{
+			"WINRS_CONSOLEMODE_STDIN": "TRUE",
+			"WINRS_SKIP_CMD_SHELL":    "FALSE",
+		},
+		body: fmt.Sprintf(`<rsp:CommandLine><rsp:Command>%s</rsp:Command></rsp:CommandLine>`, escape(commandLine)),
+	})
+	if err != nil {
+		return "", err
+	}
+	if resp.Body.CommandResponse.CommandID == "" {
+		return "", fmt.Errorf("%w: no command id was returned", ErrExecution)
+	}
+	return resp.Body.CommandResponse.CommandID, nil
+}
+
+// receive receives the next output of the command, retrying receives ended by
+// the operation timeout.
+func (c *client) receive(ctx context.Context, shellID, commandID string) (*output, error) {
+	for {
+		resp, err := c.do(ctx, request{
+			action:  actionReceive,
+			shellID: shellID,
+			body:    fmt.Sprintf(`<rsp:Receive><rsp:DesiredStream CommandId="%s">stdout stderr</rsp:DesiredStream></rsp:Receive>`, escape(commandID)),
+		})
+		if fault, ok := isFault(err); ok && fault.timedOut() {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		out := &output{}
+		for _, stream := range resp.Body.ReceiveResponse.Streams {
+			data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(stream.Data))
+			if err != nil {
+				return nil, fmt.Errorf("%w: failed to decode %s: %w", ErrExecution, stream.Name, err)
+			}
+			switch stream.Name {
+			case "stdout":
+				out.stdout = append(out.stdout, data...)
+			case "stderr":
+				out.stderr = append(out.stderr, data...)
+			}
+		}
+		if state := resp.Body.ReceiveResponse.CommandState; state != nil && state.State == commandStateDone {
+			out.done = true
+			if state.ExitCode != nil {
+				out.exitCode = windowsExitCode(*state.ExitCode)
+			}
+		}
+		return out, nil
+	}
+}
+
+// send writes the input to the command's stdin.
+func (c *client) send(ctx context.Context, shellID, commandID string, input []byte) error {
+	_, err := c.do(ctx, request{
+		action:  actionSend,
+		shellID: shellID,
+		body: fmt.Sprintf(`<rsp:Send><rsp:Stream Name="stdin" CommandId="%s">%s</rsp:Stream></rsp:Send>`,
+			escape(commandID), base64.StdEncoding.EncodeToString(input)),
+	})
+	return err
+}
+
+// signal sends the signal (signalTerminate or signalCtrlC) to the command.
+func (c *client) signal(ctx context.Context, shellID, commandID, code string) error {
+	_, err := c.do(ctx, request{
+		action:  actionSignal,
+		shellID: shellID,
+		body:    fmt.Sprintf(`<rsp:Signal CommandId="%s"><rsp:Code>%s</rsp:Code></rsp:Signal>`, escape(commandID), code),
+	})
+	return err
+}
+
+// deleteShell deletes the shell, along with any commands still running in it.
+func (c *client) deleteShell(ctx context.Context, shellID string) error {
+	_, err := c.do(ctx, request{
+		action:  actionDelete,
+		shellID: shellID,
+	})
+	return err
+}
+
+// run runs the command line in the shell to completion, returning its output.
+// This is used for the commands transferring chunked scripts.
+func (c *client) run(ctx context.Context, shellID, commandLine string) (*output, error) {
+	commandID, err := c.command(ctx, shellID, commandLine)
+	if err != nil {
+		return nil, err
+	}
+	result := &output{}
+	for !result.done {
+		out, err := c.receive(ctx, shellID, commandID)
+		if err != nil {
+			return nil, err
+		}
+		result.stdout = append(result.stdout, out.stdout...)
+		result.stderr = append(result.stderr, out.stderr...)
+		result.done, result.exitCode = out.done, out.exitCode
+	}
+	return result, nil
+}
+
+// windowsExitCode maps the exit code reported by WinRM into the unsigned 32
+// bit range windows uses, as codes such as 0xC000013A (terminated by ctrl-c)
+// may be reported as negative numbers.
+func windowsExitCode(code int64) int {
+	return int(uint32(code))
+}
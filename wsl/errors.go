@@ -0,0 +1,27 @@
+package wsl
+
+import "errors"
+
+var (
+	// ErrUnsupported is returned when the WSL executor is used on an operating
+	// system other than windows.
+	ErrUnsupported = errors.New("wsl executor is only supported on windows")
+
+	// ErrWSLNotFound is returned (wrapped) when wsl.exe could not be found, as
+	// WSL is not installed (or enabled) on the host.
+	ErrWSLNotFound = errors.New("wsl.exe not found")
+
+	// ErrDistroNotFound is returned (wrapped) when no WSL distro of the name
+	// the script should be executed in is installed for the user. This is
+	// checked before each execution.
+	ErrDistroNotFound = errors.New("wsl distro not found")
+
+	// ErrExecution is returned (wrapped) when wsl.exe failed before the script
+	// was started in the distro, such as when the user or working directory
+	// does not exist. The wrapping error holds wsl.exe's message.
+	ErrExecution = errors.New("wsl failed to start the script")
+
+	// ErrNotStarted is returned (wrapped) when signalling a script that has not
+	// yet started in the distro, so has no PID to be signalled.
+	ErrNotStarted = errors.New("script has not started in the distro")
+)
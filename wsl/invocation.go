@@ -0,0 +1,182 @@
+package wsl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/neaas/nescript"
+	"github.com/neaas/nescript/internal/shellquote"
+)
+
+// args builds the wsl.exe arguments running the cmd in the distro. The cmd is
+// run by sh with the prelude (see prelude), so that wsl.exe's own handling of
+// the command line (and the distro's default shell) is bypassed with --exec.
+func (o *options) args(distro string, c nescript.Cmd) []string {
+	args := []string{"--distribution", distro}
+	if o.user != "" {
+		args = append(args, "--user", o.user)
+	}
+	if o.workDir != "" {
+		args = append(args, "--cd", LinuxPath(o.workDir))
+	}
+	var env []string
+	if !o.wslenv {
+		env = c.Env()
+	}
+	args = append(args, "--exec", "/bin/sh", "-c", prelude(env), "sh")
+	return append(args, command(c, o.shell)...)
+}
+
+// prelude is the script sh runs before exec'ing the cmd: it writes its PID as
+// the first line of stdout, so that signals can be sent to the script within
+// the distro, and exports the env vars.
+func prelude(env []string) string {
+	var script strings.Builder
+	script.WriteString("echo $$\n")
+	for _, e := range env {
+		if key, value, ok := strings.Cut(e, "="); ok {
+			fmt.Fprintf(&script, "export %s=%s\n", key, shellquote.POSIX(value))
+		}
+	}
+	script.WriteString(`exec "$@"`)
+	return script.String()
+}
+
+// wslenv builds the env of the wsl.exe process passing the vars to the distro,
+// by naming them in WSLENV along with those of the host's WSLENV. The host's
+// own vars are kept, as wsl.exe needs them.
+func wslenv(host, env []string) []string {
+	merged := append([]string{}, host...)
+	var names []string
+	existing := ""
+	for i, e := range merged {
+		if key, value, ok := strings.Cut(e, "="); ok && strings.EqualFold(key, "WSLENV") {
+			existing = value
+			merged = append(merged[:i], merged[i+1:]...)
+			break
+		}
+	}
+	for _, e := range env {
+		if key, _, ok := strings.Cut(e, "="); ok {
+			names = append(names, key)
+		}
+	}
+	if existing != "" {
+		names = append(names, existing)
+	}
+	merged = append(merged, env...)
+	if len(names) > 0 {
+		merged = append(merged, "WSLENV="+strings.Join(names, ":"))
+	}
+	return merged
+}
+
+// command converts the cmd into the command run in the distro. If a shell is
+// set and the cmd was created from a script, the script is passed to the
+// shell, otherwise the cmd is run as it is.
+func command(c nescript.Cmd, shell nescript.Subcommand) []string {
+	_, script, trailing, ok := c.Script()
+	if !ok || shell == nil {
+		return c.Raw()
+	}
+	return append(append(append([]string{}, shell...), script), trailing...)
+}
+
+// LinuxPath converts a windows path into the path to it within a WSL distro:
+// drive paths (C:\src) to their /mnt mount (/mnt/c/src), and paths into a
+// distro's filesystem (\\wsl$\Ubuntu\home or \\wsl.localhost\Ubuntu\home) to
+// the path within the distro (/home). Other paths have their separators
+// converted, and linux paths are returned as they are.
+func LinuxPath(path string) string {
+	if strings.HasPrefix(path, "/") || strings.HasPrefix(path, "~") {
+		return path
+	}
+	slashed := strings.ReplaceAll(path, `\`, "/")
+	for _, prefix := range []string{"//wsl$/", "//wsl.localhost/"} {
+		if len(slashed) >= len(prefix) && strings.EqualFold(slashed[:len(prefix)], prefix) {
+			_, rest, _ := strings.Cut(slashed[len(prefix):], "/")
+			return "/" + rest
+		}
+	}
+	if len(slashed) >= 2 && slashed[1] == ':' && isLetter(slashed[0]) {
+		rest := strings.TrimPrefix(slashed[2:], "/")
+		mount := "/mnt/" + strings.ToLower(slashed[:1])
+		if rest == "" {
+			return mount
+		}
+		return mount + "/" + rest
+	}
+	return slashed
+}
+
+func isLetter(b byte) bool {
+	return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
+
+// decodeOutput decodes output of wsl.exe itself, which (unless WSL_UTF8 is
+// honored) is UTF-16LE, rather than the UTF-8 of programs run in the distro.
+// Output is taken to be UTF-16LE if it has its byte order mark, or (as ASCII
+// text encoded in UTF-16LE) most of its odd bytes are zero.
+func decodeOutput(output []byte) string {
+	if len(output) >= 2 && output[0] == 0xff && output[1] == 0xfe {
+		return decodeUTF16LE(output[2:])
+	}
+	if len(output) < 2 || len(output)%2 != 0 {
+		return string(output)
+	}
+	zeros := 0
+	for i := 1; i < len(output); i += 2 {
+		if output[i] == 0 {
+			zeros++
+		}
+	}
+	if zeros*2 < len(output)/2 {
+		return string(output)
+	}
+	return decodeUTF16LE(output)
+}
+
+func decodeUTF16LE(output []byte) string {
+	units := make([]uint16, len(output)/2)
+	for i := range units {
+		units[i] = uint16(output[2*i]) | uint16(output[2*i+1])<<8
+	}
+	return string(utf16.Decode(units))
+}
+
+// parseDistros parses the distro names listed by wsl.exe --list --quiet.
+func parseDistros(output []byte) []string {
+	var distros []string
+	for _, line := range strings.Split(decodeOutput(output), "\n") {
+		if name := strings.TrimSpace(strings.TrimPrefix(line, "\ufeff")); name != "" {
+			distros = append(distros, name)
+		}
+	}
+	return distros
+}
+
+// hasDistro reports whether the distro is in the list, as WSL matches distro
+// names case insensitively.
+func hasDistro(distros []string, distro string) bool {
+	for _, name := range distros {
+		if strings.EqualFold(name, distro) {
+			return true
+		}
+	}
+	return false
+}
+
+// distroNotFound reports whether wsl.exe's message is that the distro does not
+// exist, such as when it was unregistered after being checked.
+func distroNotFound(message string) bool {
+	return strings.Contains(message, "WSL_E_DISTRO_NOT_FOUND") ||
+		strings.Contains(message, "There is no distribution with the supplied name")
+}
+
+// parsePID parses the PID written by the prelude.
+func parsePID(line string) (int, bool) {
+	pid, err := strconv.Atoi(strings.TrimSpace(line))
+	return pid, err == nil && pid > 0
+}
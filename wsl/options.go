@@ -0,0 +1,72 @@
+package wsl
+
+import "github.com/neaas/nescript"
+
+// defaultWSL is the wsl.exe run if no other is given, which is found in the
+// PATH (within System32).
+const defaultWSL = "wsl.exe"
+
+// Option configures the WSL executor.
+type Option func(*options)
+
+type options struct {
+	wsl     string
+	user    string
+	workDir string
+	shell   nescript.Subcommand
+	wslenv  bool
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		wsl: defaultWSL,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithWSL sets the path of wsl.exe, if not that found in the PATH.
+func WithWSL(path string) Option {
+	return func(o *options) {
+		o.wsl = path
+	}
+}
+
+// WithUser sets the user the script is run as in the distro, rather than the
+// distro's default user.
+func WithUser(user string) Option {
+	return func(o *options) {
+		o.user = user
+	}
+}
+
+// WithWorkDir sets the directory the script is run from in the distro. Windows
+// paths (such as C:\src\app or \\wsl$\Ubuntu\home\dev) are converted to the
+// path in the distro (see LinuxPath). Otherwise, the script is run from the
+// distro user's home directory.
+func WithWorkDir(dir string) Option {
+	return func(o *options) {
+		o.workDir = dir
+	}
+}
+
+// WithShell sets the shell scripts are invoked with in the distro, such as
+// nescript.SCBash, rather than the cmd's subcommand. Cmds not created from a
+// script are run as they are.
+func WithShell(shell nescript.Subcommand) Option {
+	return func(o *options) {
+		o.shell = shell
+	}
+}
+
+// WithWSLENV passes the cmd's env vars to the distro with WSLENV, set on the
+// wsl.exe process, rather than exported by the script's prelude. As the
+// windows env is case insensitive, vars differing only in case from one of the
+// host's (such as Path) replace it for wsl.exe itself.
+func WithWSLENV() Option {
+	return func(o *options) {
+		o.wslenv = true
+	}
+}
@@ -0,0 +1,185 @@
+package wsl
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/neaas/nescript"
+)
+
+// WSLProcess represents a single instance of the script running or completed
+// in a WSL distro.
+type WSLProcess struct {
+	cmd         *exec.Cmd
+	wsl         string
+	distro      string
+	user        string
+	stdin       io.WriteCloser
+	stdout      *stdoutWriter
+	stderrBytes bytes.Buffer
+	stop        func() bool
+	waitOnce    sync.Once
+	waitErr     error
+	exited      atomic.Bool
+	started     time.Time
+	ended       time.Time
+	tee         *nescript.Tee
+}
+
+// PID returns the PID of the script within the distro, if it has started.
+func (p *WSLProcess) PID() (int, bool) {
+	return p.stdout.PID()
+}
+
+// Kill kills the script within the distro, and wsl.exe itself, doing nothing
+// once wsl.exe has exited.
+func (p *WSLProcess) Kill() error {
+	if p.exited.Load() {
+		return nil
+	}
+	var errs []error
+	// once wsl.exe has exited, the PID may have been reused in the distro.
+	if _, ok := p.PID(); ok && !p.exited.Load() {
+		errs = append(errs, p.signal(syscall.SIGKILL))
+	}
+	if err := p.cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		errs = append(errs, err)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("failed to kill process: %w", err)
+	}
+	return nil
+}
+
+// Signal sends the signal to the script within the distro, with kill.
+func (p *WSLProcess) Signal(s os.Signal) error {
+	sig, ok := s.(syscall.Signal)
+	if !ok {
+		return fmt.Errorf("failed to send signal to process: unsupported signal %s", s)
+	}
+	if err := p.signal(sig); err != nil {
+		return fmt.Errorf("failed to send signal to process: %w", err)
+	}
+	return nil
+}
+
+// signal runs kill in the distro, as the same user as the script.
+func (p *WSLProcess) signal(sig syscall.Signal) error {
+	pid, ok := p.PID()
+	if !ok {
+		return ErrNotStarted
+	}
+	args := []string{"--distribution", p.distro}
+	if p.user != "" {
+		args = append(args, "--user", p.user)
+	}
+	args = append(args, "--exec", "kill", "-"+strconv.Itoa(int(sig)), strconv.Itoa(pid))
+	if output, err := exec.Command(p.wsl, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(decodeOutput(output)))
+	}
+	return nil
+}
+
+func (p *WSLProcess) Write(input string) error {
+	if _, err := io.WriteString(p.stdin, input); err != nil {
+		return fmt.Errorf("failed to write to stdin: %w", err)
+	}
+	return nil
+}
+
+// CloseStdin closes the script's stdin.
+func (p *WSLProcess) CloseStdin() error {
+	if err := p.stdin.Close(); err != nil {
+		return fmt.Errorf("failed to close stdin: %w", err)
+	}
+	return nil
+}
+
+// wait waits for wsl.exe to exit.
+func (p *WSLProcess) wait() error {
+	p.waitOnce.Do(func() {
+		p.waitErr = p.cmd.Wait()
+		p.ended = time.Now()
+		p.exited.Store(true)
+		p.stop()
+		p.tee.Finish(nil)
+	})
+	return p.waitErr
+}
+
+func (p *WSLProcess) Stdin() io.WriteCloser {
+	return nescript.StdinWriter(p)
+}
+
+func (p *WSLProcess) Stdout() io.Reader {
+	return p.tee.StdoutStream()
+}
+
+func (p *WSLProcess) Stderr() io.Reader {
+	return p.tee.StderrStream()
+}
+
+// ID returns the PID of the script within the distro, empty until it has
+// started (see PID).
+func (p *WSLProcess) ID() string {
+	if pid, ok := p.PID(); ok {
+		return strconv.Itoa(pid)
+	}
+	return ""
+}
+
+func (p *WSLProcess) String() string {
+	if pid, ok := p.PID(); ok {
+		return fmt.Sprintf("wsl %s pid %d", p.distro, pid)
+	}
+	return fmt.Sprintf("wsl %s", p.distro)
+}
+
+func (p *WSLProcess) Wait(ctx context.Context) (*nescript.Result, error) {
+	return nescript.Wait(ctx, p)
+}
+
+func (p *WSLProcess) Result() (*nescript.Result, error) {
+	if err := p.wait(); err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			return nil, fmt.Errorf("failed to wait for process: %w", err)
+		}
+	}
+	if _, ok := p.PID(); !ok {
+		// the prelude never ran, so the output is wsl.exe's own message.
+		message := strings.TrimSpace(decodeOutput(p.stdout.raw()) + decodeOutput(p.stderrBytes.Bytes()))
+		if distroNotFound(message) {
+			return nil, fmt.Errorf("%w: '%s'", ErrDistroNotFound, p.distro)
+		}
+		return nil, fmt.Errorf("%w: %s", ErrExecution, message)
+	}
+	result := nescript.Result{
+		StdOut:   p.stdout.String(),
+		StdErr:   p.stderrBytes.String(),
+		ExitCode: p.cmd.ProcessState.ExitCode(),
+	}
+	result.SetTimes(p.started, p.ended)
+	result.SetMetadata(nescript.MetadataExecutor, "wsl")
+	p.tee.Finish(&result)
+	return &result, nil
+}
+
+// Close kills the script if it is still running, and waits for wsl.exe to
+// exit.
+func (p *WSLProcess) Close() {
+	if !p.exited.Load() {
+		p.Kill()
+	}
+	p.wait()
+}
@@ -0,0 +1,73 @@
+package wsl
+
+import (
+	"bytes"
+	"io"
+	"sync"
+
+	"github.com/neaas/nescript"
+)
+
+// stdoutWriter collects the script's stdout, taking the first line (written
+// by the prelude) as the script's PID within the distro. If the first line is
+// not a PID, the prelude never ran, and all of the output is wsl.exe's own.
+type stdoutWriter struct {
+	mu      sync.Mutex
+	all     bytes.Buffer
+	out     bytes.Buffer
+	script  io.Writer
+	started bool
+	pid     int
+}
+
+// newStdoutWriter creates the writer, the script's stdout also being written
+// to the tee of the cmd.
+func newStdoutWriter(c nescript.Cmd, stderr io.Writer) (*stdoutWriter, *nescript.Tee) {
+	w := &stdoutWriter{}
+	tee := nescript.NewTee(c, &w.out, stderr)
+	w.script = tee.Stdout()
+	return w, tee
+}
+
+func (w *stdoutWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.all.Write(b)
+	if w.started {
+		if w.pid > 0 {
+			w.script.Write(b)
+		}
+		return len(b), nil
+	}
+	line, rest, found := bytes.Cut(w.all.Bytes(), []byte("\n"))
+	if !found {
+		return len(b), nil
+	}
+	w.started = true
+	if pid, ok := parsePID(string(line)); ok {
+		w.pid = pid
+		w.script.Write(rest)
+	}
+	return len(b), nil
+}
+
+// PID returns the script's PID, if the prelude has written it.
+func (w *stdoutWriter) PID() (int, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.pid, w.pid > 0
+}
+
+// String returns the script's stdout, without the PID.
+func (w *stdoutWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.out.String()
+}
+
+// raw returns all that was written, including the PID line.
+func (w *stdoutWriter) raw() []byte {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]byte{}, w.all.Bytes()...)
+}
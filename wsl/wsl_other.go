@@ -0,0 +1,14 @@
+//go:build !windows
+
+package wsl
+
+import "github.com/neaas/nescript"
+
+// Executor provides an ExecFunc that runs the script/cmd inside the WSL
+// distro. This is only supported on windows, elsewhere every execution returns
+// ErrUnsupported.
+func Executor(distro string, opts ...Option) nescript.ExecFunc {
+	return func(c nescript.Cmd) (nescript.Process, error) {
+		return nil, ErrUnsupported
+	}
+}
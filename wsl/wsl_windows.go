@@ -0,0 +1,91 @@
+package wsl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/neaas/nescript"
+)
+
+// Executor provides an ExecFunc that runs the script/cmd inside the WSL
+// distro, by running wsl.exe from the windows host. Before each execution the
+// distro is checked to be installed (see ErrDistroNotFound). The script is run
+// with --exec, so the distro user's login shell and profile are not used, and
+// its exit code is that of wsl.exe. wsl.exe is run with the host's env, along
+// with WSL_UTF8 so that its own messages are UTF-8 where supported. This
+// ExecFunc does not require that the cmd/script be converted to a string, so is
+// Formatter agnostic.
+func Executor(distro string, opts ...Option) nescript.ExecFunc {
+	o := newOptions(opts)
+	return func(c nescript.Cmd) (nescript.Process, error) {
+		distros, err := o.listDistros(c.Context())
+		if err != nil {
+			return nil, err
+		}
+		if !hasDistro(distros, distro) {
+			return nil, fmt.Errorf("%w: '%s'", ErrDistroNotFound, distro)
+		}
+		cmd := exec.Command(o.wsl, o.args(distro, c)...)
+		cmd.Env = o.env(c)
+		process := &WSLProcess{
+			cmd:    cmd,
+			wsl:    o.wsl,
+			distro: distro,
+			user:   o.user,
+		}
+		process.stdout, process.tee = newStdoutWriter(c, &process.stderrBytes)
+		cmd.Stdout = process.stdout
+		cmd.Stderr = process.tee.Stderr()
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			process.tee.Finish(nil)
+			return nil, fmt.Errorf("failed to create stdin pipe: %w", err)
+		}
+		process.stdin = stdin
+		if err := cmd.Start(); err != nil {
+			process.tee.Finish(nil)
+			return nil, fmt.Errorf("%w: %w", ErrWSLNotFound, err)
+		}
+		process.started = time.Now()
+		// the script is killed if the cmd's context is done before it exits.
+		process.stop = context.AfterFunc(c.Context(), func() {
+			process.Kill()
+		})
+		return process, nil
+	}
+}
+
+// env is the env of the wsl.exe process: the host's, along with the cmd's when
+// passed with WSLENV.
+func (o *options) env(c nescript.Cmd) []string {
+	env := os.Environ()
+	if o.wslenv {
+		env = wslenv(env, c.Env())
+	}
+	return append(env, "WSL_UTF8=1")
+}
+
+// listDistros lists the names of the distros installed for the user.
+func (o *options) listDistros(ctx context.Context) ([]string, error) {
+	cmd := exec.CommandContext(ctx, o.wsl, "--list", "--quiet")
+	cmd.Env = append(os.Environ(), "WSL_UTF8=1")
+	output, err := cmd.Output()
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		message := strings.TrimSpace(decodeOutput(output) + decodeOutput(exitErr.Stderr))
+		// wsl.exe fails to list when there are no distros at all.
+		if strings.Contains(message, "no installed distributions") {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%w: failed to list distros: %s", ErrExecution, message)
+	case err != nil:
+		return nil, fmt.Errorf("%w: %w", ErrWSLNotFound, err)
+	}
+	return parseDistros(output), nil
+}